@@ -0,0 +1,227 @@
+// Package cbor encodes values as deterministic CBOR (RFC 8949), for clients that prefer CBOR to
+// JSON.
+//
+// Values are encoded much as encoding/json would encode them, so that a response has the same shape
+// in either encoding: structs become maps keyed by their JSON field names, and "omitempty" and "-"
+// tags are honored. Byte slices become byte strings rather than base64 text. Map keys are sorted as
+// in the core deterministic encoding of RFC 8949 Section 4.2.1.
+package cbor
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"reflect"
+	"slices"
+	"strings"
+)
+
+// Major types.
+const (
+	majorUint   = 0
+	majorNegInt = 1
+	majorBytes  = 2
+	majorText   = 3
+	majorArray  = 4
+	majorMap    = 5
+)
+
+// Simple values and the float64 initial byte.
+const (
+	simpleFalse = 0xf4
+	simpleTrue  = 0xf5
+	simpleNull  = 0xf6
+	float64Byte = 0xfb
+)
+
+// Returns the deterministic CBOR encoding of v.
+func Marshal(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := encode(&buf, reflect.ValueOf(v)); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Writes the head of a data item with the shortest encoding of its argument.
+func writeHead(buf *bytes.Buffer, major byte, arg uint64) {
+	m := major << 5
+	switch {
+	case arg < 24:
+		buf.WriteByte(m | byte(arg))
+	case arg <= math.MaxUint8:
+		buf.Write([]byte{m | 24, byte(arg)})
+	case arg <= math.MaxUint16:
+		buf.WriteByte(m | 25)
+		buf.Write(binary.BigEndian.AppendUint16(nil, uint16(arg)))
+	case arg <= math.MaxUint32:
+		buf.WriteByte(m | 26)
+		buf.Write(binary.BigEndian.AppendUint32(nil, uint32(arg)))
+	default:
+		buf.WriteByte(m | 27)
+		buf.Write(binary.BigEndian.AppendUint64(nil, arg))
+	}
+}
+
+func writeInt(buf *bytes.Buffer, n int64) {
+	if n < 0 {
+		writeHead(buf, majorNegInt, uint64(-(n + 1)))
+		return
+	}
+	writeHead(buf, majorUint, uint64(n))
+}
+
+func encode(buf *bytes.Buffer, v reflect.Value) error {
+	if !v.IsValid() {
+		buf.WriteByte(simpleNull)
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		if v.IsNil() {
+			buf.WriteByte(simpleNull)
+			return nil
+		}
+		return encode(buf, v.Elem())
+	case reflect.Bool:
+		if v.Bool() {
+			buf.WriteByte(simpleTrue)
+		} else {
+			buf.WriteByte(simpleFalse)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		writeInt(buf, v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		writeHead(buf, majorUint, v.Uint())
+	case reflect.Float32, reflect.Float64:
+		buf.WriteByte(float64Byte)
+		buf.Write(binary.BigEndian.AppendUint64(nil, math.Float64bits(v.Float())))
+	case reflect.String:
+		writeHead(buf, majorText, uint64(v.Len()))
+		buf.WriteString(v.String())
+	case reflect.Slice:
+		if v.IsNil() {
+			buf.WriteByte(simpleNull)
+			return nil
+		}
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			writeHead(buf, majorBytes, uint64(v.Len()))
+			buf.Write(v.Bytes())
+			return nil
+		}
+		return encodeArray(buf, v)
+	case reflect.Array:
+		return encodeArray(buf, v)
+	case reflect.Map:
+		if v.IsNil() {
+			buf.WriteByte(simpleNull)
+			return nil
+		}
+		var entries []entry
+		for iter := v.MapRange(); iter.Next(); {
+			entries = append(entries, entry{key: iter.Key(), value: iter.Value()})
+		}
+		return encodeMap(buf, entries)
+	case reflect.Struct:
+		var entries []entry
+		structEntries(v, &entries)
+		return encodeMap(buf, entries)
+	default:
+		return fmt.Errorf("cbor: unsupported type %v", v.Type())
+	}
+	return nil
+}
+
+func encodeArray(buf *bytes.Buffer, v reflect.Value) error {
+	writeHead(buf, majorArray, uint64(v.Len()))
+	for i := range v.Len() {
+		if err := encode(buf, v.Index(i)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Key and value of a map or struct field.
+type entry struct {
+	key, value reflect.Value
+}
+
+// Encodes a map, sorting entries by the bytewise order of their encoded keys.
+func encodeMap(buf *bytes.Buffer, entries []entry) error {
+	type encoded struct {
+		key, value []byte
+	}
+	items := make([]encoded, 0, len(entries))
+	for _, e := range entries {
+		var k, v bytes.Buffer
+		if err := encode(&k, e.key); err != nil {
+			return err
+		}
+		if err := encode(&v, e.value); err != nil {
+			return err
+		}
+		items = append(items, encoded{key: k.Bytes(), value: v.Bytes()})
+	}
+	slices.SortFunc(items, func(a, b encoded) int { return bytes.Compare(a.key, b.key) })
+
+	writeHead(buf, majorMap, uint64(len(items)))
+	for i, item := range items {
+		if i > 0 && bytes.Equal(item.key, items[i-1].key) {
+			return fmt.Errorf("cbor: duplicate map key %x", item.key)
+		}
+		buf.Write(item.key)
+		buf.Write(item.value)
+	}
+	return nil
+}
+
+// Collects the fields of a struct as encoding/json would, flattening embedded structs.
+func structEntries(v reflect.Value, entries *[]entry) {
+	t := v.Type()
+	for i := range t.NumField() {
+		f := t.Field(i)
+		name, opts, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" && opts == "" {
+			continue
+		}
+		fv := v.Field(i)
+		if f.Anonymous && name == "" {
+			if fv.Kind() == reflect.Pointer {
+				if fv.IsNil() {
+					continue
+				}
+				fv = fv.Elem()
+			}
+			if fv.Kind() == reflect.Struct {
+				structEntries(fv, entries)
+				continue
+			}
+		}
+		if !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		if slices.Contains(strings.Split(opts, ","), "omitempty") && isEmpty(fv) {
+			continue
+		}
+		*entries = append(*entries, entry{key: reflect.ValueOf(name), value: fv})
+	}
+}
+
+// Reports whether encoding/json would consider a value empty for the "omitempty" option.
+func isEmpty(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64, reflect.Interface, reflect.Pointer:
+		return v.IsZero()
+	default:
+		return false
+	}
+}
@@ -0,0 +1,67 @@
+package cbor
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+type inner struct {
+	B int `json:"b"`
+}
+
+type outer struct {
+	inner
+	A        string  `json:"a"`
+	Skipped  string  `json:"-"`
+	Empty    []byte  `json:"empty,omitempty"`
+	Nil      *string `json:"nil"`
+	Untagged bool
+	hidden   int
+}
+
+func TestMarshal(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		value any
+		want  string
+	}{
+		// Examples from RFC 8949 Appendix A.
+		{"zero", 0, "00"},
+		{"small", 23, "17"},
+		{"one byte", 24, "1818"},
+		{"two bytes", 1000, "1903e8"},
+		{"four bytes", 1000000, "1a000f4240"},
+		{"eight bytes", uint64(18446744073709551615), "1bffffffffffffffff"},
+		{"negative", -1, "20"},
+		{"negative two bytes", -1000, "3903e7"},
+		{"float", 1.1, "fb3ff199999999999a"},
+		{"false", false, "f4"},
+		{"true", true, "f5"},
+		{"null", nil, "f6"},
+		{"bytes", []byte{1, 2, 3, 4}, "4401020304"},
+		{"text", "IETF", "6449455446"},
+		{"array", []int{1, 2, 3}, "83010203"},
+		{"nested array", []any{1, []int{2, 3}, []int{4, 5}}, "8301820203820405"},
+		{"int keys", map[int]int{1: 2, 3: 4}, "a201020304"},
+		// Keys are sorted by their encodings, so shorter strings come first.
+		{"sorted keys", map[string]int{"aa": 1, "b": 2, "a": 3}, "a361610361620262616101"},
+		{"negative keys after positive", map[int]int{-1: 1, 1: 2}, "a201022001"},
+		{"struct", outer{inner: inner{B: 1}, A: "x"}, "a461616178616201636e696cf668556e746167676564f4"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := Marshal(tc.value)
+			if err != nil {
+				t.Fatalf("Marshal(%#v) failed: %+v", tc.value, err)
+			}
+			if hex.EncodeToString(got) != tc.want {
+				t.Errorf("Marshal(%#v) = %x, want %s", tc.value, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestMarshalUnsupported(t *testing.T) {
+	if _, err := Marshal(make(chan int)); err == nil {
+		t.Errorf("Marshal(chan) succeeded, want error")
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"crypto/tls"
+	"log"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// How often to check the TLS certificate and key files for changes.
+const certPollPeriod = time.Minute
+
+// Serves a TLS certificate loaded from files, reloading it when the files change or the process
+// receives SIGHUP.
+type certReloader struct {
+	certFile string
+	keyFile  string
+
+	mu   sync.RWMutex
+	cert *tls.Certificate
+	// Latest modification time of the files when the certificate was loaded.
+	modTime time.Time
+}
+
+// Loads the certificate and starts watching its files for changes.
+func newCertReloader(certFile string, keyFile string) (*certReloader, error) {
+	r := &certReloader{certFile: certFile, keyFile: keyFile}
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+	go r.watch()
+	return r, nil
+}
+
+// Returns the latest modification time of the certificate and key files.
+func (r *certReloader) filesModTime() (time.Time, error) {
+	var latest time.Time
+	for _, file := range []string{r.certFile, r.keyFile} {
+		info, err := os.Stat(file)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+// Loads the certificate from its files, replacing the current one.
+func (r *certReloader) reload() error {
+	modTime, err := r.filesModTime()
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.cert = &cert
+	r.modTime = modTime
+	return nil
+}
+
+// Reloads the certificate whenever its files change or the process receives SIGHUP. Never
+// returns. If reloading fails, the current certificate stays in use.
+func (r *certReloader) watch() {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	ticker := time.NewTicker(certPollPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-hup:
+		case <-ticker.C:
+			modTime, err := r.filesModTime()
+			if err != nil {
+				log.Printf("ERROR: Failed to check TLS certificate files: %+v", err)
+				continue
+			}
+			r.mu.RLock()
+			changed := !modTime.Equal(r.modTime)
+			r.mu.RUnlock()
+			if !changed {
+				continue
+			}
+		}
+
+		if err := r.reload(); err != nil {
+			log.Printf("ERROR: Failed to reload TLS certificate; keeping the current one: %+v", err)
+			continue
+		}
+		log.Printf("Reloaded TLS certificate from %s", r.certFile)
+	}
+}
+
+// Returns the current certificate. Suitable for tls.Config.GetCertificate.
+func (r *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert, nil
+}
@@ -1,43 +1,294 @@
-// Package clock provides a secure clock using NTS.
+// Package clock provides a secure clock using NTS and Roughtime.
 package clock
 
 import (
+	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"errors"
 	"fmt"
+	"log"
+	"sync/atomic"
 	"time"
 )
 
-// How old NTS measurements are allowed to be.
-const ntsStaleThreshold = 6 * time.Hour
+// Returned by a clock that has yet to obtain a reading from the time servers.
+var errNotSynced = errors.New("secure clock has not yet obtained the time")
 
-// NTS-backed secure clock.
+// Default for Options.StaleThreshold.
+const defaultStaleThreshold = 6 * time.Hour
+
+// Default for Options.MaxDivergence.
+const defaultMaxDivergence = time.Minute
+
+// Bound on how fast or slow the system monotonic clock may run, in parts per million. Commodity
+// oscillators are typically within 50 ppm.
+const maxDriftPPM = 100
+
+// Secure clock options.
+type Options struct {
+	// How often to request a new time from the time servers. If zero, defaultPollPeriod is used.
+	PollPeriod time.Duration
+	// How long to wait before retrying after a failed poll. The wait doubles with each further
+	// consecutive failure, up to the poll period, and is randomly shortened by up to half. If zero,
+	// defaultRetryPeriod is used.
+	RetryPeriod time.Duration
+	// How many consecutive failed polls are allowed before sessions are reestablished, one server
+	// per further failed poll. If zero, defaultMaxConsecutiveFailures is used.
+	MaxConsecutiveFailures int
+	// How old the last reading may be before Now fails. If zero, defaultStaleThreshold is used.
+	// Must be longer than the poll period, or the clock would go stale between polls.
+	StaleThreshold time.Duration
+	// How far the system realtime clock may diverge from the secure clock before a warning is
+	// logged. If zero, defaultMaxDivergence is used.
+	MaxDivergence time.Duration
+	// Whether Interval fails while the divergence exceeds MaxDivergence, so that private keys are
+	// withheld while the host clock may have been tampered with.
+	RefuseOnDivergence bool
+	// Root certificates that NTS key exchange servers must chain to. If nil, the system roots are
+	// used.
+	NTSRootCAs *x509.CertPool
+	// SHA-256 hashes of DER-encoded SubjectPublicKeyInfos, at least one of which must appear in the
+	// verified certificate chain of every NTS key exchange server. If empty, any chain to the roots
+	// is accepted.
+	NTSPins [][sha256.Size]byte
+	// Path of a file in which the time of the last accepted reading is persisted. Readings before
+	// the persisted time are rejected, so that a time server that is rolled back while the clock is
+	// not running cannot make the clock run backwards across a restart. If empty, only readings
+	// accepted since the clock started are used as a floor.
+	FloorFile string
+}
+
+// Returns the options with defaults in place of zero fields.
+func (o Options) withDefaults() Options {
+	if o.PollPeriod == 0 {
+		o.PollPeriod = defaultPollPeriod
+	}
+	if o.RetryPeriod == 0 {
+		o.RetryPeriod = defaultRetryPeriod
+	}
+	if o.MaxConsecutiveFailures == 0 {
+		o.MaxConsecutiveFailures = defaultMaxConsecutiveFailures
+	}
+	if o.StaleThreshold == 0 {
+		o.StaleThreshold = defaultStaleThreshold
+	}
+	if o.MaxDivergence == 0 {
+		o.MaxDivergence = defaultMaxDivergence
+	}
+	return o
+}
+
+// Checks options with defaults filled in.
+func (o Options) validate() error {
+	if o.PollPeriod < 0 || o.RetryPeriod < 0 || o.MaxConsecutiveFailures < 0 || o.StaleThreshold < 0 || o.MaxDivergence < 0 {
+		return fmt.Errorf("clock options must not be negative")
+	}
+	if o.StaleThreshold <= o.PollPeriod {
+		return fmt.Errorf("staleness threshold %s must be longer than poll period %s", o.StaleThreshold, o.PollPeriod)
+	}
+	return nil
+}
+
+// A source of the current time that is trusted to release private keys. Implemented by SecureClock,
+// and by ManualClock for tests.
+type TimeSource interface {
+	// Returns a secure estimate of the current time, or an error if there is none.
+	Now() (time.Time, error)
+	// Returns an interval that contains the current time, or an error if there is none.
+	Interval() (Interval, error)
+	// Returns how long ago the measurement underlying Now was taken.
+	Staleness() time.Duration
+	// Returns how far the system realtime clock is ahead of the time source.
+	Divergence() time.Duration
+	// Returns signed Roughtime responses supporting the measurement underlying Now, if any.
+	RoughtimeProofs() []RoughtimeProof
+	// Returns the status of the time source's synchronization, for monitoring.
+	Health() Health
+}
+
+// An interval of time.
+type Interval struct {
+	Earliest time.Time
+	Latest   time.Time
+}
+
+// Status of a time source's synchronization with its time servers.
+type Health struct {
+	// Whether the time source has obtained a reading. Until it has, LastPoll, Offset, and Staleness
+	// are zero.
+	Synced bool
+	// Name of the time server whose reading was last accepted, such as "NTS server at
+	// time.example.com". Empty before the first successful poll.
+	Server string
+	// System realtime clock at the last successful poll. Zero before the first successful poll.
+	LastPoll time.Time
+	// How far the time servers were ahead of the system realtime clock at the last successful
+	// poll, or behind it if negative.
+	Offset time.Duration
+	// Number of polls that have failed since the last successful one.
+	ConsecutiveFailures int
+	// How long ago the last successful poll was. See Staleness. Zero before the first successful
+	// poll.
+	Staleness time.Duration
+	// Staleness at which the time source stops returning the time.
+	StaleThreshold time.Duration
+}
+
+// Secure clock backed by NTS and Roughtime servers.
 type SecureClock struct {
 	cell *muCell[clockReading]
+	// Options, with defaults filled in.
+	opts Options
+	// Stops the poll loop.
+	stop context.CancelFunc
+	// Closed once the poll loop has returned.
+	done chan struct{}
+	// Number of consecutive failed polls, shared with the poller.
+	failures *atomic.Int64
 }
 
-// Constructs a new secure clock using the given NTS server.
-func NewSecureClock(ntsAddrs []string) (*SecureClock, error) {
-	poller, err := newPoller(ntsAddrs)
+// Constructs a new secure clock using the given NTS and Roughtime servers, a majority of which must
+// agree on the time for a reading to be accepted.
+//
+// Unreachable time servers don't prevent the clock from being constructed. Instead, Now and
+// Interval fail until the clock has obtained a reading.
+//
+// Readings earlier than notBefore, or earlier than a reading previously accepted by the clock, are
+// rejected. A zero notBefore imposes no floor beyond the latter. Readings persisted to the floor file
+// named by the options count as previously accepted, even by an earlier run of the clock.
+func NewSecureClock(ntsAddrs []string, roughtimeServers []RoughtimeServer, notBefore time.Time, opts Options) (*SecureClock, error) {
+	opts = opts.withDefaults()
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+	poller, err := newPoller(timeServers(ntsAddrs, roughtimeServers, ntsTLSConfig(opts)), notBefore, opts)
 	if err != nil {
 		return nil, err
 	}
-	go poller.PollLoop()
+	// The clock starts even if the time servers can't be reached, so that public keys can be served
+	// in the meantime. Now fails until a poll succeeds.
+	if err := poller.pollOnce(); err != nil {
+		log.Printf("ERROR: Failed to obtain a secure time, retrying within %v: %+v", opts.RetryPeriod, err)
+	}
+	return startClock(poller, opts), nil
+}
+
+// Starts a secure clock that polls using the given poller until it is closed.
+func startClock(p *poller, opts Options) *SecureClock {
+	ctx, stop := context.WithCancel(context.Background())
+	c := &SecureClock{cell: p.Cell(), opts: opts, stop: stop, done: make(chan struct{}), failures: &p.failures}
+	go func() {
+		defer close(c.done)
+		p.PollLoop(ctx)
+	}()
+	return c
+}
 
-	return &SecureClock{cell: poller.Cell()}, nil
+// Stops polling time servers, waiting for any poll in progress to finish. Now continues to
+// extrapolate from the last reading until it goes stale. Close may be called more than once.
+func (c *SecureClock) Close() {
+	c.stop()
+	<-c.done
 }
 
 // Returns a secure estimate of the current time.
 //
-// Now computes the current time as the last time obtained from the NTS server, plus the difference
-// in monotonic clock readings between when Now is called and when the NTS response was obtained.
+// Now computes the current time as the last time agreed on by the time servers, plus the difference
+// in monotonic clock readings between when Now is called and when that time was obtained.
 // When uncertainty arises, Now prefers to err on the side of underestimating the current time.
 func (c *SecureClock) Now() (time.Time, error) {
+	last, delta, err := c.last()
+	if err != nil {
+		return time.Time{}, err
+	}
+	return last.nts.Add(delta), nil
+}
+
+// Returns the last reading and the time since it was taken, or an error if there is no reading or
+// it is stale.
+func (c *SecureClock) last() (clockReading, time.Duration, error) {
 	last := c.cell.Get()
+	if last.system.IsZero() {
+		return clockReading{}, 0, errNotSynced
+	}
 
 	// time.Since uses the system monotic clock, rather than the realtime clock, so we are not
 	// significantly exposed to NTP attacks on the system clock.
 	delta := time.Since(last.system)
-	if delta >= ntsStaleThreshold {
-		return time.Time{}, fmt.Errorf("NTS time is too stale")
+	if delta >= c.opts.StaleThreshold {
+		return clockReading{}, 0, fmt.Errorf("NTS time is too stale")
 	}
-	return last.nts.Add(delta), nil
+	return last, delta, nil
+}
+
+// Returns an interval that contains the current time.
+//
+// The interval starts out as wide as the uncertainty of the last reading, which covers the round
+// trip of the query and any uncertainty reported by the time server. It then widens with the time
+// since the reading, by the maximum drift of the monotonic clock.
+//
+// If the options say to refuse on divergence, Interval fails while the system realtime clock
+// diverges too far from the secure clock.
+func (c *SecureClock) Interval() (Interval, error) {
+	last, delta, err := c.last()
+	if err != nil {
+		return Interval{}, err
+	}
+	if d := c.Divergence(); c.opts.RefuseOnDivergence && d.Abs() > c.opts.MaxDivergence {
+		return Interval{}, fmt.Errorf("system clock diverges from the secure clock by %v", d)
+	}
+	drift := delta / 1_000_000 * maxDriftPPM
+	return Interval{
+		Earliest: last.nts.Add(delta - drift),
+		Latest:   last.nts.Add(last.uncertainty + delta + drift),
+	}, nil
+}
+
+// Returns how far the system realtime clock is ahead of the secure clock, or behind it if negative.
+//
+// The secure clock only uses the system monotonic clock, but a large divergence may indicate that
+// the host clock has been tampered with.
+func (c *SecureClock) Divergence() time.Duration {
+	last := c.cell.Get()
+	if last.system.IsZero() {
+		return 0
+	}
+	now := time.Now()
+	// Round strips the monotonic reading, so that the subtraction compares realtime clocks.
+	return now.Round(0).Sub(last.nts.Add(now.Sub(last.system)))
+}
+
+// Returns how long ago the NTS measurement underlying Now was taken.
+//
+// Now fails once this reaches the staleness threshold. Before the clock obtains its first reading,
+// this saturates at the maximum duration.
+func (c *SecureClock) Staleness() time.Duration {
+	return time.Since(c.cell.Get().system)
+}
+
+// Returns the signed Roughtime responses that agreed with the measurement underlying Now, so that
+// the time can be audited. There are none if no Roughtime server is configured.
+func (c *SecureClock) RoughtimeProofs() []RoughtimeProof {
+	return c.cell.Get().proofs
+}
+
+// Returns the status of the clock's polling, so that operators can be alerted before the clock goes
+// stale.
+func (c *SecureClock) Health() Health {
+	last := c.cell.Get()
+	h := Health{
+		Server:              last.server,
+		ConsecutiveFailures: int(c.failures.Load()),
+		StaleThreshold:      c.opts.StaleThreshold,
+	}
+	// Before the first reading, the staleness would be measured from the zero time.
+	if !last.system.IsZero() {
+		h.Synced = true
+		h.Staleness = time.Since(last.system)
+		// Round strips the monotonic reading, so that the offset is from the realtime clock.
+		h.LastPoll = last.system.Round(0)
+		h.Offset = last.nts.Sub(h.LastPoll)
+	}
+	return h
 }
@@ -0,0 +1,172 @@
+package clock
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStaleThreshold(t *testing.T) {
+	cell := newCell(clockReading{nts: time.Now(), system: time.Now().Add(-2 * time.Hour)})
+
+	if _, err := (&SecureClock{cell: cell, opts: Options{}.withDefaults()}).Now(); err != nil {
+		t.Errorf("Reading 2h old is stale under the default threshold: %+v", err)
+	}
+	opts := Options{PollPeriod: 10 * time.Minute, StaleThreshold: time.Hour}.withDefaults()
+	if _, err := (&SecureClock{cell: cell, opts: opts}).Now(); err == nil {
+		t.Errorf("Reading 2h old is not stale under a 1h threshold")
+	}
+}
+
+func TestInvalidOptions(t *testing.T) {
+	for _, opts := range []Options{
+		{PollPeriod: -time.Minute},
+		{MaxConsecutiveFailures: -1},
+		{StaleThreshold: 30 * time.Minute},
+		{PollPeriod: 12 * time.Hour},
+	} {
+		if err := opts.withDefaults().validate(); err == nil {
+			t.Errorf("Accepted invalid options %+v", opts)
+		}
+	}
+	if err := (Options{PollPeriod: time.Minute, StaleThreshold: 5 * time.Minute}).withDefaults().validate(); err != nil {
+		t.Errorf("Rejected valid options: %+v", err)
+	}
+}
+
+func TestInterval(t *testing.T) {
+	reading := time.Date(2025, time.June, 1, 12, 0, 0, 0, time.UTC)
+	cell := newCell(clockReading{nts: reading, system: time.Now().Add(-time.Hour), uncertainty: 50 * time.Millisecond})
+	c := &SecureClock{cell: cell, opts: Options{}.withDefaults()}
+
+	interval, err := c.Interval()
+	if err != nil {
+		t.Fatalf("Failed to get interval: %+v", err)
+	}
+	now, err := c.Now()
+	if err != nil {
+		t.Fatalf("Failed to read clock: %+v", err)
+	}
+	if now.Before(interval.Earliest) || now.After(interval.Latest) {
+		t.Errorf("Now %s is outside the interval [%s, %s]", now.Format(time.RFC3339Nano), interval.Earliest.Format(time.RFC3339Nano), interval.Latest.Format(time.RFC3339Nano))
+	}
+
+	// An hour at 100 ppm is 360ms of drift either way.
+	at := reading.Add(time.Hour)
+	if d := at.Sub(interval.Earliest); d < 300*time.Millisecond || d > 400*time.Millisecond {
+		t.Errorf("Interval starts %v before the reading plus its age, want about 360ms", d)
+	}
+	if d := interval.Latest.Sub(at); d < 350*time.Millisecond || d > 450*time.Millisecond {
+		t.Errorf("Interval ends %v after the reading plus its age, want about 410ms", d)
+	}
+}
+
+func TestDivergence(t *testing.T) {
+	// The system clock is five minutes behind the time servers.
+	cell := newCell(clockReading{nts: time.Now().Add(5 * time.Minute), system: time.Now()})
+
+	c := &SecureClock{cell: cell, opts: Options{}.withDefaults()}
+	if d := c.Divergence(); d > -4*time.Minute || d < -6*time.Minute {
+		t.Errorf("Got divergence %v, want about -5m", d)
+	}
+	if _, err := c.Interval(); err != nil {
+		t.Errorf("Divergence was refused by default: %+v", err)
+	}
+
+	c.opts.RefuseOnDivergence = true
+	if _, err := c.Interval(); err == nil {
+		t.Errorf("Divergence of %v was not refused", c.Divergence())
+	}
+	if _, err := c.Now(); err != nil {
+		t.Errorf("Now failed on divergence: %+v", err)
+	}
+	c.opts.MaxDivergence = 10 * time.Minute
+	if _, err := c.Interval(); err != nil {
+		t.Errorf("Divergence within threshold was refused: %+v", err)
+	}
+}
+
+func TestUnreachableServers(t *testing.T) {
+	// Nothing listens on the discard port, so queries fail.
+	unreachable := RoughtimeServer{Addr: "127.0.0.1:9", PublicKey: make([]byte, 32)}
+	c, err := NewSecureClock(nil, []RoughtimeServer{unreachable}, time.Time{}, Options{})
+	if err != nil {
+		t.Fatalf("Failed to create clock with unreachable servers: %+v", err)
+	}
+	defer c.Close()
+
+	if _, err := c.Now(); err == nil {
+		t.Errorf("Clock returned a time without reaching any server")
+	}
+}
+
+func TestClose(t *testing.T) {
+	servers := map[string]*fakeServer{
+		"a": {time: time.Now()},
+	}
+	p, err := startPoller(fakeTimeServers(servers, "a"))
+	if err != nil {
+		t.Fatalf("Failed to create poller: %+v", err)
+	}
+	c := startClock(p, p.opts)
+
+	closed := make(chan struct{})
+	go func() {
+		c.Close()
+		c.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(10 * time.Second):
+		t.Fatalf("Close did not return")
+	}
+
+	if p.sessions[0] != nil {
+		t.Errorf("Session was kept after the clock was closed")
+	}
+	checkNow(t, c)
+}
+
+func TestHealth(t *testing.T) {
+	servers := map[string]*fakeServer{}
+	p, err := newPoller(fakeTimeServers(servers, "a", "b"), notBefore, Options{}.withDefaults())
+	if err != nil {
+		t.Fatalf("Failed to create poller: %+v", err)
+	}
+	c := &SecureClock{cell: p.Cell(), opts: p.opts, failures: &p.failures}
+
+	if err := p.pollOnce(); err == nil {
+		t.Fatalf("Polled unreachable servers")
+	}
+	if h := c.Health(); h.Synced || h.Server != "" || !h.LastPoll.IsZero() || h.Staleness != 0 || h.ConsecutiveFailures != 1 {
+		t.Errorf("Clock without a reading reported health %+v", h)
+	}
+
+	// The lower of the two readings is accepted.
+	servers["a"] = &fakeServer{time: time.Now()}
+	servers["b"] = &fakeServer{time: time.Now().Add(time.Second)}
+	if err := p.pollOnce(); err != nil {
+		t.Fatalf("Failed to poll: %+v", err)
+	}
+	h := c.Health()
+	if !h.Synced || h.Server != "a" || h.ConsecutiveFailures != 0 || h.StaleThreshold != defaultStaleThreshold {
+		t.Errorf("Synchronized clock reported health %+v", h)
+	}
+	if d := time.Since(h.LastPoll); d < 0 || d > time.Minute {
+		t.Errorf("Last poll was reported %v ago", d)
+	}
+	if h.Offset.Abs() > time.Second {
+		t.Errorf("Offset of %v was reported for a server reading the system time", h.Offset)
+	}
+
+	clear(servers)
+	clear(p.sessions)
+	for range 2 {
+		if err := p.pollOnce(); err == nil {
+			t.Fatalf("Polled unreachable servers")
+		}
+	}
+	if h := c.Health(); h.Server != "a" || h.ConsecutiveFailures != 2 {
+		t.Errorf("Clock with failing servers reported health %+v", h)
+	}
+}
@@ -0,0 +1,104 @@
+package clock
+
+import (
+	"sync"
+	"time"
+)
+
+// A time source whose time is set by hand, for tests. Its time only changes through Set and
+// Advance.
+type ManualClock struct {
+	mu          sync.Mutex
+	now         time.Time
+	uncertainty time.Duration
+	err         error
+}
+
+// Constructs a manual clock reading the given time.
+func NewManualClock(now time.Time) *ManualClock {
+	return &ManualClock{now: now}
+}
+
+// Returns the time last set, or the error set by SetError.
+func (c *ManualClock) Now() (time.Time, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.err != nil {
+		return time.Time{}, c.err
+	}
+	return c.now, nil
+}
+
+// Returns the interval within the uncertainty set by SetUncertainty on either side of the time
+// last set, or the error set by SetError.
+func (c *ManualClock) Interval() (Interval, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.err != nil {
+		return Interval{}, c.err
+	}
+	return Interval{Earliest: c.now.Add(-c.uncertainty), Latest: c.now.Add(c.uncertainty)}, nil
+}
+
+// Sets the time that Now returns.
+func (c *ManualClock) Set(now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.now = now
+}
+
+// Moves the time that Now returns forward by d.
+func (c *ManualClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.now = c.now.Add(d)
+}
+
+// Sets how far on either side of the time the interval returned by Interval extends.
+func (c *ManualClock) SetUncertainty(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.uncertainty = d
+}
+
+// Makes Now and Interval fail with err, as a secure clock does once its reading goes stale, or
+// succeed again if err is nil.
+func (c *ManualClock) SetError(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.err = err
+}
+
+// Always returns zero, since the time is as fresh as the test makes it.
+func (c *ManualClock) Staleness() time.Duration {
+	return 0
+}
+
+// Always returns zero, since the manual time is unrelated to the system clock.
+func (c *ManualClock) Divergence() time.Duration {
+	return 0
+}
+
+// Always returns nil.
+func (c *ManualClock) RoughtimeProofs() []RoughtimeProof {
+	return nil
+}
+
+// Returns a status with no time server, taking the time last set as a reading of zero staleness
+// against the default staleness threshold. While an error is set by SetError, the status is that of
+// a clock that has yet to obtain a reading.
+func (c *ManualClock) Health() Health {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.err != nil {
+		return Health{StaleThreshold: defaultStaleThreshold}
+	}
+	return Health{Synced: true, LastPoll: c.now, StaleThreshold: defaultStaleThreshold}
+}
@@ -1,131 +1,399 @@
 package clock
 
 import (
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"log"
+	"math/rand/v2"
+	"slices"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/beevik/nts"
 )
 
 const (
-	// How often the client should request a new absolute time from the NTS
-	// server.
-	pollPeriod = time.Hour
+	// Default for Options.PollPeriod.
+	defaultPollPeriod = time.Hour
 
-	// How often the client should retry failure.
-	retryPeriod = 5 * time.Minute
+	// Default for Options.RetryPeriod.
+	defaultRetryPeriod = 5 * time.Minute
 
-	// How many consecutive failures the client should allow before trying a new server.
-	maxConsecutiveFailures = 5
+	// Default for Options.MaxConsecutiveFailures.
+	defaultMaxConsecutiveFailures = 5
+
+	// How far apart the readings of different time servers may be while still agreeing on the time.
+	agreementTolerance = 2 * time.Second
 )
 
-// Creates a new NTS session by trying to connect to each address in order.
-func createSession(addrs []string) (*nts.Session, error) {
-	for _, addr := range addrs {
-		session, err := nts.NewSession(addr)
-		if err == nil {
-			log.Printf("Connected to NTS server at %s", addr)
-			return session, nil
+// Returned when a time server reports a time that cannot possibly be correct.
+var errImplausibleTime = errors.New("implausible time")
+
+// Returned when too few time servers agree on the time.
+var errNoQuorum = errors.New("no quorum of time servers")
+
+// A time server's answer to a query.
+type sample struct {
+	// Earliest time that the server reported.
+	time time.Time
+	// How far past time the server's uncertainty extends. Zero for servers that report a single
+	// time.
+	width time.Duration
+	// Signed proof of the answer, if the server signs its responses.
+	proof *RoughtimeProof
+}
+
+// A session with a time server.
+type session interface {
+	// Queries the current time from the server.
+	Query() (sample, error)
+}
+
+// A time server that the poller queries.
+type timeServer struct {
+	// Description of the server for logs, such as "NTS server at time.example.com".
+	name string
+	// Opens a session with the server.
+	dial func() (session, error)
+}
+
+// Returns the time servers for the given NTS and Roughtime servers. NTS key exchange uses the given
+// TLS configuration.
+func timeServers(ntsAddrs []string, roughtimeServers []RoughtimeServer, config *tls.Config) []timeServer {
+	var servers []timeServer
+	for _, addr := range ntsAddrs {
+		servers = append(servers, timeServer{
+			name: "NTS server at " + addr,
+			dial: func() (session, error) { return dialNTS(addr, config) },
+		})
+	}
+	for _, server := range roughtimeServers {
+		servers = append(servers, timeServer{
+			name: "Roughtime server at " + server.Addr,
+			dial: func() (session, error) { return dialRoughtime(server) },
+		})
+	}
+	return servers
+}
+
+// A session with an NTS server.
+type ntsSession struct {
+	session *nts.Session
+}
+
+// Returns the TLS configuration for NTS key exchange under the given options.
+func ntsTLSConfig(opts Options) *tls.Config {
+	config := &tls.Config{RootCAs: opts.NTSRootCAs}
+	if len(opts.NTSPins) > 0 {
+		config.VerifyConnection = func(cs tls.ConnectionState) error {
+			for _, chain := range cs.VerifiedChains {
+				for _, cert := range chain {
+					if slices.Contains(opts.NTSPins, sha256.Sum256(cert.RawSubjectPublicKeyInfo)) {
+						return nil
+					}
+				}
+			}
+			return fmt.Errorf("no pinned public key in the certificate chain of %s", cs.ServerName)
 		}
-		log.Printf("ERROR: failed to connect to NTS server at %s", addr)
 	}
-	return nil, fmt.Errorf("failed to connect to any NTS server")
+	return config
 }
 
-// A reading of both NTS and system clocks.
+// Opens an NTS session with the server at the given address, using the given TLS configuration for
+// key exchange.
+func dialNTS(addr string, config *tls.Config) (session, error) {
+	s, err := nts.NewSessionWithOptions(addr, &nts.SessionOptions{TLSConfig: config})
+	if err != nil {
+		return nil, err
+	}
+	return &ntsSession{session: s}, nil
+}
+
+func (s *ntsSession) Query() (sample, error) {
+	resp, err := s.session.Query()
+	if err != nil {
+		return sample{}, err
+	}
+	return sample{time: resp.Time}, nil
+}
+
+// A reading of both secure and system clocks.
 type clockReading struct {
+	// Name of the time server that provided the reading.
+	server string
+	// Earliest time that the time servers reported.
 	nts    time.Time
 	system time.Time
+	// How much later than nts the time may have been at the system time. Covers the round trip of
+	// the query and any uncertainty reported by the server.
+	uncertainty time.Duration
+	// Signed Roughtime responses supporting the reading.
+	proofs []RoughtimeProof
 }
 
-// Gets a clock reading from both NTS and the system clock.
-func readTime(session *nts.Session) (clockReading, error) {
-	resp, err := session.Query()
+// Gets a clock reading from both a time server and the system clock.
+func readTime(session session) (clockReading, error) {
+	sent := time.Now()
+	s, err := session.Query()
 	if err != nil {
-		return clockReading{}, fmt.Errorf("failed to query time from NTS server: %w", err)
+		return clockReading{}, fmt.Errorf("failed to query time: %w", err)
 	}
 
-	// Read the system time after obtaining the NTS time in order to err on the side of
-	// underestimating the current time.
-	nts := resp.Time
+	// Read the system time after obtaining the server time in order to err on the side of
+	// underestimating the current time. The server read its clock at some point during the round
+	// trip, so the time may since have advanced by up to the round trip time.
 	system := time.Now()
-	return clockReading{nts: nts, system: system}, nil
+	reading := clockReading{nts: s.time, system: system, uncertainty: system.Sub(sent) + s.width}
+	if s.proof != nil {
+		reading.proofs = []RoughtimeProof{*s.proof}
+	}
+	return reading, nil
 }
 
-// State for regularly polling NTS.
-type ntsPoller struct {
-	addrs   []string
-	session *nts.Session
-	cell    *muCell[clockReading]
+// State for regularly polling time servers.
+type poller struct {
+	servers []timeServer
+	// Earliest time that the poller will accept, raised to the time in the floor file if it is later.
+	notBefore time.Time
+	// How many servers must agree on the time for a reading to be accepted.
+	quorum int
+	// Poll and retry periods and divergence threshold, with defaults filled in.
+	opts Options
+
+	// Current session with each server, by index into servers, or nil where a new session must be
+	// established before the next query.
+	sessions []session
+	// Index into servers of the next server whose session is reestablished by resetNext.
+	nextReset int
+	// Number of polls that have failed since the last successful one.
+	failures atomic.Int64
+
+	cell *muCell[clockReading]
 }
 
-// Constructs a new poller using any of the given servers.
-func newPoller(addrs []string) (*ntsPoller, error) {
-	session, err := createSession(addrs)
-	if err != nil {
-		return nil, err
+// Returns the number of servers that make up a majority of n servers.
+func majority(n int) int {
+	return n/2 + 1
+}
+
+// Constructs a new poller using the given servers, a majority of which must agree on the time.
+//
+// The poller rejects any reading earlier than notBefore or earlier than the last reading it
+// accepted, including one persisted to the floor file by an earlier poller. It has no reading until
+// the first successful poll.
+func newPoller(servers []timeServer, notBefore time.Time, opts Options) (*poller, error) {
+	if len(servers) == 0 {
+		return nil, fmt.Errorf("no time server provided")
 	}
+	if opts.FloorFile != "" {
+		floor, err := loadFloor(opts.FloorFile)
+		if err != nil {
+			return nil, err
+		}
+		if floor.After(notBefore) {
+			notBefore = floor
+		}
+	}
+	p := &poller{
+		servers:   servers,
+		notBefore: notBefore,
+		quorum:    majority(len(servers)),
+		opts:      opts,
+		sessions:  make([]session, len(servers)),
+		cell:      newCell(clockReading{}),
+	}
+	return p, nil
+}
 
-	initial, err := readTime(session)
+// Returns the cell that the poller writes its readings to.
+func (p *poller) Cell() *muCell[clockReading] {
+	return p.cell
+}
+
+// Queries the server at the given index into servers, connecting to it first if there is no
+// session with it. The session is abandoned if the query fails.
+func (p *poller) query(i int) (clockReading, error) {
+	server := p.servers[i]
+	if p.sessions[i] == nil {
+		session, err := server.dial()
+		if err != nil {
+			return clockReading{}, fmt.Errorf("failed to connect to %s: %w", server.name, err)
+		}
+		log.Printf("Connected to %s", server.name)
+		p.sessions[i] = session
+	}
+
+	reading, err := readTime(p.sessions[i])
 	if err != nil {
-		return nil, err
+		p.sessions[i] = nil
+		return clockReading{}, fmt.Errorf("%s: %w", server.name, err)
+	}
+	reading.server = server.name
+	if reading.nts.Before(p.notBefore) {
+		p.sessions[i] = nil
+		return clockReading{}, fmt.Errorf("%w: %s reported %s, which is before %s", errImplausibleTime, server.name, reading.nts.Format(time.RFC3339), p.notBefore.Format(time.RFC3339))
 	}
+	return reading, nil
+}
 
-	return &ntsPoller{
-		addrs:   addrs,
-		session: session,
-		cell:    newCell(initial),
-	}, nil
+// Combines readings from different servers into one that a quorum of them agrees with.
+//
+// Readings are compared by their offset from the system clock, so that they needn't be taken at
+// the same instant. The result is the reading with the median offset, taking the lower of the two
+// middle readings when there is an even number of them, in order to err on the side of
+// underestimating the current time. It is accepted if at least a quorum of readings, including
+// itself, are within agreementTolerance of it, and carries the proofs of all of those readings.
+func (p *poller) combine(readings []clockReading) (clockReading, error) {
+	if len(readings) < p.quorum {
+		return clockReading{}, fmt.Errorf("%w: only %d of %d time servers provided a plausible time, need %d", errNoQuorum, len(readings), len(p.servers), p.quorum)
+	}
+
+	offset := func(r clockReading) time.Duration { return r.nts.Sub(r.system) }
+	slices.SortFunc(readings, func(a, b clockReading) int { return cmp.Compare(offset(a), offset(b)) })
+	median := readings[(len(readings)-1)/2]
+
+	agreeing := 0
+	var proofs []RoughtimeProof
+	for _, r := range readings {
+		if (offset(r) - offset(median)).Abs() <= agreementTolerance {
+			agreeing++
+			proofs = append(proofs, r.proofs...)
+		}
+	}
+	if agreeing < p.quorum {
+		return clockReading{}, fmt.Errorf("%w: only %d of %d time servers agree on the time within %v, need %d", errNoQuorum, agreeing, len(p.servers), agreementTolerance, p.quorum)
+	}
+	median.proofs = proofs
+	return median, nil
 }
 
-// Returns the cell that the poller writes its readings to.
-func (p *ntsPoller) Cell() *muCell[clockReading] {
-	return p.cell
+// Checks that a reading is not earlier than the last accepted reading.
+//
+// Accepting such a reading would make the clock run backwards, which could revoke the availability
+// of keys that have already been disclosed.
+func (p *poller) checkReading(reading clockReading) error {
+	last := p.cell.Get()
+	if reading.nts.Before(last.nts) {
+		return fmt.Errorf("%w: time servers agreed on %s, which is before the last good reading %s", errImplausibleTime, reading.nts.Format(time.RFC3339), last.nts.Format(time.RFC3339))
+	}
+	return nil
 }
 
-// Updates the clock reading cell with new data, returning true on success.
+// Updates the clock reading cell with new data, counting the poll towards the consecutive failures
+// if it fails.
 //
-// If reinit is true, a new NTS session is established before querying.
-func (p *ntsPoller) pollOnce(reinit bool) bool {
-	if reinit {
-		session, err := createSession(p.addrs)
+// All servers are queried concurrently, connecting first to servers without an active session.
+// Servers that fail or report a time before notBefore are left out, and the reading is only updated
+// if a quorum of the remaining servers agree on the time.
+func (p *poller) pollOnce() (err error) {
+	defer func() {
 		if err != nil {
-			log.Printf("ERROR: %+v", err)
-			return false
+			p.failures.Add(1)
+		} else {
+			p.failures.Store(0)
+		}
+	}()
+
+	readings := make([]clockReading, len(p.servers))
+	errs := make([]error, len(p.servers))
+	var wg sync.WaitGroup
+	for i := range p.servers {
+		wg.Go(func() {
+			readings[i], errs[i] = p.query(i)
+		})
+	}
+	wg.Wait()
+
+	var plausible []clockReading
+	for i, err := range errs {
+		if err != nil {
+			log.Printf("ERROR: %v", err)
+			continue
 		}
-		p.session = session
+		plausible = append(plausible, readings[i])
 	}
 
-	reading, err := readTime(p.session)
+	reading, err := p.combine(plausible)
 	if err != nil {
-		log.Printf("ERROR: %v", err)
-		return false
+		return err
+	}
+	if err := p.checkReading(reading); err != nil {
+		return err
 	}
 	p.cell.Put(reading)
+	if p.opts.FloorFile != "" {
+		// The reading has already been accepted, so failing to persist it only weakens the floor of
+		// the next run.
+		if err := saveFloor(p.opts.FloorFile, reading.nts); err != nil {
+			log.Printf("ERROR: Failed to persist the last good reading: %+v", err)
+		}
+	}
 
-	return true
+	if d := reading.system.Round(0).Sub(reading.nts); d.Abs() > p.opts.MaxDivergence {
+		log.Printf("WARNING: System clock diverges from the time servers by %v", d)
+	}
+	return nil
 }
 
-// Periodically updates the clock reading cell. Never returns.
+// Abandons the session with the next server in rotation, so that a new session is established with
+// it by the next poll.
 //
-// If polls fail consecutively, a new session will be established, possibly with a different server.
-func (p *ntsPoller) PollLoop() {
-	consecutiveFailures := 0
-	for {
-		var d time.Duration
-		if consecutiveFailures > 0 {
-			d = retryPeriod
-		} else {
-			d = pollPeriod
+// Sessions are reestablished one server at a time, rather than all at once, so that a recovering
+// server isn't met with a burst of key exchanges every time polls start failing.
+func (p *poller) resetNext() {
+	p.sessions[p.nextReset] = nil
+	p.nextReset = (p.nextReset + 1) % len(p.servers)
+}
+
+// Returns how long to wait before the next poll, given the number of consecutive failed polls.
+//
+// Retries back off exponentially from the retry period, doubling with each failure up to the poll
+// period. Each retry delay is randomly shortened by up to half, so that servers that lost their time
+// servers together don't retry in lockstep.
+func (p *poller) nextDelay(consecutiveFailures int) time.Duration {
+	if consecutiveFailures == 0 {
+		return p.opts.PollPeriod
+	}
+	limit := max(p.opts.PollPeriod, p.opts.RetryPeriod)
+	d := p.opts.RetryPeriod
+	for range consecutiveFailures - 1 {
+		if d >= limit/2 {
+			d = limit
+			break
 		}
+		d *= 2
+	}
+	return d - rand.N(d/2+1)
+}
 
-		<-time.After(d)
+// Periodically updates the clock reading cell until the context is canceled, after which the
+// sessions with the time servers are dropped.
+//
+// Failed polls are retried with backoff. Once more than MaxConsecutiveFailures polls have failed in
+// a row, each further retry first reestablishes the session with one server, in rotation.
+func (p *poller) PollLoop(ctx context.Context) {
+	defer clear(p.sessions)
 
-		if !p.pollOnce(consecutiveFailures > maxConsecutiveFailures) {
-			consecutiveFailures++
-			continue
+	for {
+		consecutiveFailures := int(p.failures.Load())
+		timer := time.NewTimer(p.nextDelay(consecutiveFailures))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		if consecutiveFailures > p.opts.MaxConsecutiveFailures {
+			p.resetNext()
+		}
+		if err := p.pollOnce(); err != nil {
+			log.Printf("ERROR: %+v", err)
 		}
-		consecutiveFailures = 0
 	}
 }
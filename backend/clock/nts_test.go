@@ -0,0 +1,421 @@
+package clock
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var (
+	notBefore = time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+	y2k       = time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC)
+)
+
+// A fake time server whose reported time is controlled by the test.
+type fakeServer struct {
+	time time.Time
+}
+
+func (f *fakeServer) Query() (sample, error) {
+	return sample{time: f.time}, nil
+}
+
+// Returns time servers that connect to the fake servers with the given addresses, if they exist at
+// the time of connection.
+func fakeTimeServers(servers map[string]*fakeServer, addrs ...string) []timeServer {
+	var ts []timeServer
+	for _, addr := range addrs {
+		ts = append(ts, timeServer{
+			name: addr,
+			dial: func() (session, error) {
+				s, ok := servers[addr]
+				if !ok {
+					return nil, errors.New("no such server")
+				}
+				return s, nil
+			},
+		})
+	}
+	return ts
+}
+
+// Constructs a poller with default options and takes an initial reading.
+func startPoller(servers []timeServer) (*poller, error) {
+	p, err := newPoller(servers, notBefore, Options{}.withDefaults())
+	if err != nil {
+		return nil, err
+	}
+	return p, p.pollOnce()
+}
+
+// Checks that a clock reports approximately the current time.
+func checkNow(t *testing.T, c *SecureClock) {
+	t.Helper()
+
+	now, err := c.Now()
+	if err != nil {
+		t.Fatalf("Failed to read secure clock: %+v", err)
+	}
+	if d := time.Since(now).Abs(); d > time.Minute {
+		t.Errorf("Secure clock is off by %v: got %s", d, now.Format(time.RFC3339))
+	}
+}
+
+func TestRejectsTimeBeforeLastReading(t *testing.T) {
+	servers := map[string]*fakeServer{
+		"a": {time: time.Now()},
+		"b": {time: time.Now()},
+		"c": {time: time.Now()},
+	}
+	p, err := startPoller(fakeTimeServers(servers, "a", "b", "c"))
+	if err != nil {
+		t.Fatalf("Failed to create poller: %+v", err)
+	}
+	c := &SecureClock{cell: p.Cell(), opts: p.opts}
+
+	earlier := time.Now().Add(-time.Hour)
+	for _, s := range servers {
+		s.time = earlier
+	}
+	if err := p.pollOnce(); !errors.Is(err, errImplausibleTime) {
+		t.Errorf("Reading of %s was not rejected: got error %v", earlier.Format(time.RFC3339), err)
+	}
+	checkNow(t, c)
+}
+
+func TestRejectsTimeBeforeNotBefore(t *testing.T) {
+	servers := map[string]*fakeServer{
+		"a": {time: y2k},
+		"b": {time: time.Now()},
+		"c": {time: time.Now()},
+	}
+	p, err := startPoller(fakeTimeServers(servers, "a", "b", "c"))
+	if err != nil {
+		t.Fatalf("Failed to create poller: %+v", err)
+	}
+	checkNow(t, &SecureClock{cell: p.Cell(), opts: p.opts})
+}
+
+func TestRejectsTimeBeforePersistedReading(t *testing.T) {
+	opts := Options{FloorFile: filepath.Join(t.TempDir(), "clock", "floor")}.withDefaults()
+	servers := map[string]*fakeServer{
+		"a": {time: time.Now()},
+	}
+	p, err := newPoller(fakeTimeServers(servers, "a"), notBefore, opts)
+	if err != nil {
+		t.Fatalf("Failed to create poller: %+v", err)
+	}
+	if err := p.pollOnce(); err != nil {
+		t.Fatalf("Failed to poll: %+v", err)
+	}
+
+	// A new poller, as after a restart, keeps the floor of the old one.
+	earlier := time.Now().Add(-time.Hour)
+	servers["a"] = &fakeServer{time: earlier}
+	p, err = newPoller(fakeTimeServers(servers, "a"), notBefore, opts)
+	if err != nil {
+		t.Fatalf("Failed to create poller after restart: %+v", err)
+	}
+	if err := p.pollOnce(); err == nil {
+		t.Errorf("Reading of %s was accepted after restart", earlier.Format(time.RFC3339))
+	}
+
+	servers["a"] = &fakeServer{time: time.Now()}
+	if err := p.pollOnce(); err != nil {
+		t.Errorf("Failed to poll after restart: %+v", err)
+	}
+}
+
+func TestInvalidFloorFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "floor")
+	if err := os.WriteFile(path, []byte("yesterday\n"), 0o600); err != nil {
+		t.Fatalf("Failed to write floor file: %+v", err)
+	}
+	if _, err := newPoller(fakeTimeServers(nil, "a"), notBefore, Options{FloorFile: path}.withDefaults()); err == nil {
+		t.Errorf("Created poller with an invalid floor file")
+	}
+}
+
+func TestNoPlausibleServer(t *testing.T) {
+	servers := map[string]*fakeServer{
+		"a": {time: y2k},
+	}
+	if _, err := startPoller(fakeTimeServers(servers, "a")); err == nil {
+		t.Errorf("Poller accepted a reading of %s", y2k.Format(time.RFC3339))
+	}
+}
+
+func TestOutvotesBrokenServer(t *testing.T) {
+	servers := map[string]*fakeServer{
+		"a": {time: time.Now()},
+		"b": {time: time.Now()},
+		"c": {time: time.Now()},
+	}
+	p, err := startPoller(fakeTimeServers(servers, "a", "b", "c"))
+	if err != nil {
+		t.Fatalf("Failed to create poller: %+v", err)
+	}
+	c := &SecureClock{cell: p.Cell(), opts: p.opts}
+
+	// A server running ahead must not be able to unlock future keys on its own.
+	servers["a"].time = time.Now().AddDate(1, 0, 0)
+	if err := p.pollOnce(); err != nil {
+		t.Fatalf("Failed to poll: %+v", err)
+	}
+	checkNow(t, c)
+
+	// With one server unreachable, the other two must agree.
+	delete(servers, "b")
+	clear(p.sessions)
+	if err := p.pollOnce(); !errors.Is(err, errNoQuorum) {
+		t.Errorf("Poll without agreeing quorum did not fail: got error %v", err)
+	}
+	servers["a"].time = time.Now()
+	if err := p.pollOnce(); err != nil {
+		t.Fatalf("Failed to poll with one server unreachable: %+v", err)
+	}
+	checkNow(t, c)
+}
+
+func TestNoQuorum(t *testing.T) {
+	servers := map[string]*fakeServer{
+		"a": {time: time.Now()},
+		"b": {time: time.Now().AddDate(1, 0, 0)},
+	}
+	if _, err := startPoller(fakeTimeServers(servers, "a", "b")); !errors.Is(err, errNoQuorum) {
+		t.Errorf("Poller accepted disagreeing servers: got error %v", err)
+	}
+	if _, err := startPoller(fakeTimeServers(servers, "a", "b", "c")); !errors.Is(err, errNoQuorum) {
+		t.Errorf("Poller accepted disagreeing servers with one unreachable: got error %v", err)
+	}
+}
+
+func TestPollLoopStops(t *testing.T) {
+	servers := map[string]*fakeServer{
+		"a": {time: time.Now()},
+	}
+	p, err := startPoller(fakeTimeServers(servers, "a"))
+	if err != nil {
+		t.Fatalf("Failed to create poller: %+v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		p.PollLoop(ctx)
+		close(done)
+	}()
+	cancel()
+
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Errorf("Poll loop did not stop after its context was canceled")
+	}
+}
+
+func TestPollPeriod(t *testing.T) {
+	servers := map[string]*fakeServer{
+		"a": {time: time.Now()},
+	}
+	p, err := newPoller(fakeTimeServers(servers, "a"), notBefore, Options{PollPeriod: time.Millisecond}.withDefaults())
+	if err != nil {
+		t.Fatalf("Failed to create poller: %+v", err)
+	}
+	if err := p.pollOnce(); err != nil {
+		t.Fatalf("Failed to poll: %+v", err)
+	}
+	first := p.Cell().Get()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go p.PollLoop(ctx)
+
+	deadline := time.Now().Add(10 * time.Second)
+	for p.Cell().Get().system.Equal(first.system) {
+		if time.Now().After(deadline) {
+			t.Fatalf("Poll loop did not take a new reading within 10s")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestStartsWithoutTime(t *testing.T) {
+	servers := map[string]*fakeServer{}
+	p, err := newPoller(fakeTimeServers(servers, "a"), notBefore, Options{}.withDefaults())
+	if err != nil {
+		t.Fatalf("Failed to create poller: %+v", err)
+	}
+	c := &SecureClock{cell: p.Cell(), opts: p.opts}
+
+	if err := p.pollOnce(); err == nil {
+		t.Fatalf("Polled an unreachable server")
+	}
+	if _, err := c.Now(); !errors.Is(err, errNotSynced) {
+		t.Errorf("Clock without a reading returned error %v, want %v", err, errNotSynced)
+	}
+	if _, err := c.Interval(); !errors.Is(err, errNotSynced) {
+		t.Errorf("Clock without a reading returned error %v, want %v", err, errNotSynced)
+	}
+
+	// The clock recovers once the server can be reached.
+	servers["a"] = &fakeServer{time: time.Now()}
+	if err := p.pollOnce(); err != nil {
+		t.Fatalf("Failed to poll: %+v", err)
+	}
+	checkNow(t, c)
+}
+
+func TestNextDelay(t *testing.T) {
+	p, err := newPoller(fakeTimeServers(nil, "a"), notBefore, Options{PollPeriod: time.Hour, RetryPeriod: time.Minute}.withDefaults())
+	if err != nil {
+		t.Fatalf("Failed to create poller: %+v", err)
+	}
+
+	for _, tc := range []struct {
+		failures int
+		want     time.Duration
+	}{
+		{0, time.Hour},
+		{1, time.Minute},
+		{2, 2 * time.Minute},
+		{3, 4 * time.Minute},
+		{7, time.Hour},
+		{1000, time.Hour},
+	} {
+		for range 100 {
+			d := p.nextDelay(tc.failures)
+			lo := tc.want / 2
+			if tc.failures == 0 {
+				lo = tc.want
+			}
+			if d < lo || d > tc.want {
+				t.Fatalf("Delay after %d failures was %v, want between %v and %v", tc.failures, d, lo, tc.want)
+			}
+		}
+	}
+}
+
+func TestResetRotates(t *testing.T) {
+	servers := map[string]*fakeServer{
+		"a": {time: time.Now()},
+		"b": {time: time.Now()},
+		"c": {time: time.Now()},
+	}
+	p, err := startPoller(fakeTimeServers(servers, "a", "b", "c"))
+	if err != nil {
+		t.Fatalf("Failed to create poller: %+v", err)
+	}
+
+	for i := range 2 * len(p.servers) {
+		p.resetNext()
+		for j, s := range p.sessions {
+			if reset := j == i%len(p.servers); (s == nil) != reset {
+				t.Fatalf("Reset %d: session %d is %v, want reset only for server %d", i, j, s, i%len(p.servers))
+			}
+		}
+		for _, s := range servers {
+			s.time = time.Now()
+		}
+		if err := p.pollOnce(); err != nil {
+			t.Fatalf("Failed to poll: %+v", err)
+		}
+	}
+}
+
+// Issues a certificate for the given key, signed by the given parent certificate and key, or
+// self-signed if parent is nil.
+func issueCert(t *testing.T, key *ecdsa.PrivateKey, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) *x509.Certificate {
+	t.Helper()
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "nts.example.com"},
+		DNSNames:     []string{"nts.example.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	if parent == nil {
+		template.Subject.CommonName = "Test CA"
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage = x509.KeyUsageCertSign
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatalf("Failed to create certificate: %+v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("Failed to parse certificate: %+v", err)
+	}
+	return cert
+}
+
+func TestNTSTLSConfig(t *testing.T) {
+	caKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	ca := issueCert(t, caKey, nil, nil)
+	leafKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	leaf := issueCert(t, leafKey, ca, caKey)
+	otherKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	other := issueCert(t, otherKey, nil, nil)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+	pin := func(cert *x509.Certificate) [sha256.Size]byte { return sha256.Sum256(cert.RawSubjectPublicKeyInfo) }
+
+	for _, tc := range []struct {
+		name string
+		opts Options
+		ok   bool
+	}{
+		{"SystemRoots", Options{}, false},
+		{"CustomRoots", Options{NTSRootCAs: roots}, true},
+		{"PinnedCA", Options{NTSRootCAs: roots, NTSPins: [][sha256.Size]byte{pin(ca)}}, true},
+		{"PinnedLeaf", Options{NTSRootCAs: roots, NTSPins: [][sha256.Size]byte{pin(other), pin(leaf)}}, true},
+		{"WrongPin", Options{NTSRootCAs: roots, NTSPins: [][sha256.Size]byte{pin(other)}}, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			l, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+				Certificates: []tls.Certificate{{Certificate: [][]byte{leaf.Raw}, PrivateKey: leafKey}},
+			})
+			if err != nil {
+				t.Fatalf("Failed to listen: %+v", err)
+			}
+			defer l.Close()
+			go func() {
+				conn, err := l.Accept()
+				if err != nil {
+					return
+				}
+				defer conn.Close()
+				conn.(*tls.Conn).Handshake()
+			}()
+
+			config := ntsTLSConfig(tc.opts)
+			config.ServerName = "nts.example.com"
+			conn, err := tls.Dial("tcp", l.Addr().String(), config)
+			if err == nil {
+				conn.Close()
+			}
+			if tc.ok && err != nil {
+				t.Errorf("Handshake failed: %+v", err)
+			}
+			if !tc.ok && err == nil {
+				t.Errorf("Handshake succeeded with an untrusted server")
+			}
+		})
+	}
+}
@@ -0,0 +1,320 @@
+package clock
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+	"time"
+)
+
+// This file implements a client for the original Roughtime protocol, as specified at
+// https://roughtime.googlesource.com/roughtime/+/HEAD/PROTOCOL.md.
+
+const (
+	// Size of request nonces.
+	roughtimeNonceSize = 64
+	// Minimum size of requests, which servers require so that they can't be used for amplification.
+	roughtimeRequestSize = 1024
+	// Maximum size of responses that the client accepts.
+	roughtimeMaxResponseSize = 4096
+	// How long to wait for a response.
+	roughtimeTimeout = 5 * time.Second
+
+	// Signature contexts for delegation certificates and responses.
+	roughtimeDelegationContext = "RoughTime v1 delegation signature--\x00"
+	roughtimeResponseContext   = "RoughTime v1 response signature\x00"
+)
+
+// Returns the tag with the given four-character name.
+func roughtimeTag(name string) uint32 {
+	return binary.LittleEndian.Uint32([]byte(name))
+}
+
+var (
+	tagNONC = roughtimeTag("NONC")
+	tagPAD  = roughtimeTag("PAD\xff")
+	tagSIG  = roughtimeTag("SIG\x00")
+	tagSREP = roughtimeTag("SREP")
+	tagCERT = roughtimeTag("CERT")
+	tagDELE = roughtimeTag("DELE")
+	tagPUBK = roughtimeTag("PUBK")
+	tagMINT = roughtimeTag("MINT")
+	tagMAXT = roughtimeTag("MAXT")
+	tagINDX = roughtimeTag("INDX")
+	tagPATH = roughtimeTag("PATH")
+	tagROOT = roughtimeTag("ROOT")
+	tagMIDP = roughtimeTag("MIDP")
+	tagRADI = roughtimeTag("RADI")
+)
+
+// Returned when a Roughtime response is malformed or its signatures don't verify.
+var errInvalidRoughtimeResponse = errors.New("invalid Roughtime response")
+
+// A Roughtime server, identified by its long-term public key.
+type RoughtimeServer struct {
+	// UDP address of the server, as host:port.
+	Addr string
+	// Long-term Ed25519 public key of the server.
+	PublicKey ed25519.PublicKey
+}
+
+// Parses a Roughtime server in the form host:port/key, where key is the server's long-term public
+// key in standard base64.
+func ParseRoughtimeServer(s string) (RoughtimeServer, error) {
+	addr, key, ok := strings.Cut(s, "/")
+	if !ok {
+		return RoughtimeServer{}, fmt.Errorf("Roughtime server %q has no public key", s)
+	}
+	pub, err := base64.StdEncoding.DecodeString(key)
+	if err != nil {
+		return RoughtimeServer{}, fmt.Errorf("invalid public key for Roughtime server %s: %w", addr, err)
+	}
+	if len(pub) != ed25519.PublicKeySize {
+		return RoughtimeServer{}, fmt.Errorf("public key for Roughtime server %s has %d bytes, want %d", addr, len(pub), ed25519.PublicKeySize)
+	}
+	return RoughtimeServer{Addr: addr, PublicKey: pub}, nil
+}
+
+// A signed Roughtime response, which lets anyone holding the server's public key check the time that
+// the server reported.
+type RoughtimeProof struct {
+	// Server that signed the response.
+	Server RoughtimeServer
+	// Nonce of the request that the response answers.
+	Nonce []byte
+	// Response as received from the server.
+	Response []byte
+}
+
+// Verifies the proof, returning the midpoint and radius of the time interval that the server
+// reported.
+func (p *RoughtimeProof) Verify() (time.Time, time.Duration, error) {
+	midpoint, radius, err := verifyRoughtimeResponse(p.Server.PublicKey, p.Nonce, p.Response)
+	if err != nil {
+		return time.Time{}, 0, fmt.Errorf("%w: %v", errInvalidRoughtimeResponse, err)
+	}
+	return midpoint, radius, nil
+}
+
+// Encodes a Roughtime message with the given tagged values, each of which must be a multiple of
+// four bytes long.
+func encodeRoughtimeMessage(values map[uint32][]byte) []byte {
+	tags := make([]uint32, 0, len(values))
+	for tag := range values {
+		tags = append(tags, tag)
+	}
+	slices.Sort(tags)
+
+	msg := binary.LittleEndian.AppendUint32(nil, uint32(len(tags)))
+	offset := 0
+	for i, tag := range tags {
+		if i > 0 {
+			msg = binary.LittleEndian.AppendUint32(msg, uint32(offset))
+		}
+		offset += len(values[tag])
+	}
+	for _, tag := range tags {
+		msg = binary.LittleEndian.AppendUint32(msg, tag)
+	}
+	for _, tag := range tags {
+		msg = append(msg, values[tag]...)
+	}
+	return msg
+}
+
+// Parses a Roughtime message into its tagged values.
+func parseRoughtimeMessage(msg []byte) (map[uint32][]byte, error) {
+	if len(msg) < 4 || len(msg)%4 != 0 {
+		return nil, fmt.Errorf("message has invalid length %d", len(msg))
+	}
+	n := int(binary.LittleEndian.Uint32(msg))
+	if n == 0 {
+		return map[uint32][]byte{}, nil
+	}
+	if n > len(msg)/8 {
+		return nil, fmt.Errorf("message with %d bytes claims %d tags", len(msg), n)
+	}
+
+	header := msg[4 : 8*n]
+	values := msg[8*n:]
+	offsets := make([]int, n+1)
+	for i := 1; i < n; i++ {
+		offsets[i] = int(binary.LittleEndian.Uint32(header[4*(i-1):]))
+	}
+	offsets[n] = len(values)
+
+	parsed := make(map[uint32][]byte, n)
+	var last uint32
+	for i := range n {
+		tag := binary.LittleEndian.Uint32(header[4*(n-1)+4*i:])
+		if i > 0 && tag <= last {
+			return nil, fmt.Errorf("message tags are not in ascending order")
+		}
+		last = tag
+		start, end := offsets[i], offsets[i+1]
+		if start%4 != 0 || start > end || end > len(values) {
+			return nil, fmt.Errorf("message has invalid offset %d", start)
+		}
+		parsed[tag] = values[start:end]
+	}
+	return parsed, nil
+}
+
+// Checks that a message has each of the given tags, with a value of the given size where the size
+// is not negative.
+func roughtimeFields(msg map[uint32][]byte, sizes map[uint32]int) error {
+	for tag, size := range sizes {
+		value, ok := msg[tag]
+		if !ok {
+			return fmt.Errorf("missing tag %q", binary.LittleEndian.AppendUint32(nil, tag))
+		}
+		if size >= 0 && len(value) != size {
+			return fmt.Errorf("tag %q has %d bytes, want %d", binary.LittleEndian.AppendUint32(nil, tag), len(value), size)
+		}
+	}
+	return nil
+}
+
+// Builds a request with the given nonce.
+func roughtimeRequest(nonce []byte) []byte {
+	// Two tags take 16 bytes of header.
+	return encodeRoughtimeMessage(map[uint32][]byte{
+		tagNONC: nonce,
+		tagPAD:  make([]byte, roughtimeRequestSize-16-len(nonce)),
+	})
+}
+
+// Hashes a leaf of a Roughtime Merkle tree.
+func roughtimeLeafHash(nonce []byte) []byte {
+	h := sha512.New()
+	h.Write([]byte{0})
+	h.Write(nonce)
+	return h.Sum(nil)
+}
+
+// Hashes an interior node of a Roughtime Merkle tree.
+func roughtimeNodeHash(left, right []byte) []byte {
+	h := sha512.New()
+	h.Write([]byte{1})
+	h.Write(left)
+	h.Write(right)
+	return h.Sum(nil)
+}
+
+// Verifies a response to a request with the given nonce, signed under the given long-term key.
+func verifyRoughtimeResponse(rootKey ed25519.PublicKey, nonce []byte, resp []byte) (time.Time, time.Duration, error) {
+	msg, err := parseRoughtimeMessage(resp)
+	if err != nil {
+		return time.Time{}, 0, err
+	}
+	if err := roughtimeFields(msg, map[uint32]int{tagSIG: ed25519.SignatureSize, tagSREP: -1, tagCERT: -1, tagINDX: 4, tagPATH: -1}); err != nil {
+		return time.Time{}, 0, err
+	}
+
+	// The long-term key delegates to an online key for a window of time.
+	cert, err := parseRoughtimeMessage(msg[tagCERT])
+	if err != nil {
+		return time.Time{}, 0, fmt.Errorf("invalid certificate: %w", err)
+	}
+	if err := roughtimeFields(cert, map[uint32]int{tagDELE: -1, tagSIG: ed25519.SignatureSize}); err != nil {
+		return time.Time{}, 0, fmt.Errorf("invalid certificate: %w", err)
+	}
+	if !ed25519.Verify(rootKey, append([]byte(roughtimeDelegationContext), cert[tagDELE]...), cert[tagSIG]) {
+		return time.Time{}, 0, fmt.Errorf("delegation signature does not verify")
+	}
+	dele, err := parseRoughtimeMessage(cert[tagDELE])
+	if err != nil {
+		return time.Time{}, 0, fmt.Errorf("invalid delegation: %w", err)
+	}
+	if err := roughtimeFields(dele, map[uint32]int{tagPUBK: ed25519.PublicKeySize, tagMINT: 8, tagMAXT: 8}); err != nil {
+		return time.Time{}, 0, fmt.Errorf("invalid delegation: %w", err)
+	}
+	if !ed25519.Verify(dele[tagPUBK], append([]byte(roughtimeResponseContext), msg[tagSREP]...), msg[tagSIG]) {
+		return time.Time{}, 0, fmt.Errorf("response signature does not verify")
+	}
+
+	srep, err := parseRoughtimeMessage(msg[tagSREP])
+	if err != nil {
+		return time.Time{}, 0, fmt.Errorf("invalid signed response: %w", err)
+	}
+	if err := roughtimeFields(srep, map[uint32]int{tagROOT: sha512.Size, tagMIDP: 8, tagRADI: 4}); err != nil {
+		return time.Time{}, 0, fmt.Errorf("invalid signed response: %w", err)
+	}
+
+	// The server signs the root of a Merkle tree of the nonces in a batch of requests.
+	path := msg[tagPATH]
+	if len(path)%sha512.Size != 0 {
+		return time.Time{}, 0, fmt.Errorf("path has invalid length %d", len(path))
+	}
+	index := binary.LittleEndian.Uint32(msg[tagINDX])
+	hash := roughtimeLeafHash(nonce)
+	for ; len(path) > 0; path = path[sha512.Size:] {
+		if index&1 == 0 {
+			hash = roughtimeNodeHash(hash, path[:sha512.Size])
+		} else {
+			hash = roughtimeNodeHash(path[:sha512.Size], hash)
+		}
+		index >>= 1
+	}
+	if index != 0 {
+		return time.Time{}, 0, fmt.Errorf("index is out of range of the path")
+	}
+	if !bytes.Equal(hash, srep[tagROOT]) {
+		return time.Time{}, 0, fmt.Errorf("nonce is not in the signed Merkle tree")
+	}
+
+	midp := binary.LittleEndian.Uint64(srep[tagMIDP])
+	if midp < binary.LittleEndian.Uint64(dele[tagMINT]) || midp > binary.LittleEndian.Uint64(dele[tagMAXT]) {
+		return time.Time{}, 0, fmt.Errorf("time is outside the validity of the delegation")
+	}
+	radius := time.Duration(binary.LittleEndian.Uint32(srep[tagRADI])) * time.Microsecond
+	return time.UnixMicro(int64(midp)), radius, nil
+}
+
+// A session with a Roughtime server. Roughtime is stateless, so this just identifies the server.
+type roughtimeSession struct {
+	server RoughtimeServer
+}
+
+// Opens a Roughtime session with the given server.
+func dialRoughtime(server RoughtimeServer) (session, error) {
+	return &roughtimeSession{server: server}, nil
+}
+
+// Queries the time from the server, reporting the interval within the server's uncertainty radius.
+func (s *roughtimeSession) Query() (sample, error) {
+	nonce := make([]byte, roughtimeNonceSize)
+	rand.Read(nonce)
+
+	conn, err := net.Dial("udp", s.server.Addr)
+	if err != nil {
+		return sample{}, err
+	}
+	defer conn.Close()
+	if err := conn.SetDeadline(time.Now().Add(roughtimeTimeout)); err != nil {
+		return sample{}, err
+	}
+	if _, err := conn.Write(roughtimeRequest(nonce)); err != nil {
+		return sample{}, err
+	}
+	buf := make([]byte, roughtimeMaxResponseSize)
+	n, err := conn.Read(buf)
+	if err != nil {
+		return sample{}, err
+	}
+
+	proof := &RoughtimeProof{Server: s.server, Nonce: nonce, Response: buf[:n]}
+	midpoint, radius, err := proof.Verify()
+	if err != nil {
+		return sample{}, err
+	}
+	return sample{time: midpoint.Add(-radius), width: 2 * radius, proof: proof}, nil
+}
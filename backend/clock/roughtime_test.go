@@ -0,0 +1,193 @@
+package clock
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+// A fake Roughtime server that answers each request as part of a batch of four.
+type fakeRoughtimeServer struct {
+	conn    net.PacketConn
+	rootKey ed25519.PrivateKey
+	// Offset of the reported time from the system time.
+	offset time.Duration
+}
+
+// Starts a fake Roughtime server on a local port.
+func startFakeRoughtimeServer(t *testing.T, offset time.Duration) (*fakeRoughtimeServer, RoughtimeServer) {
+	t.Helper()
+
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %+v", err)
+	}
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %+v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	s := &fakeRoughtimeServer{conn: conn, rootKey: priv, offset: offset}
+	go s.serve()
+	return s, RoughtimeServer{Addr: conn.LocalAddr().String(), PublicKey: pub}
+}
+
+func (s *fakeRoughtimeServer) serve() {
+	buf := make([]byte, roughtimeMaxResponseSize)
+	for {
+		n, addr, err := s.conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		req, err := parseRoughtimeMessage(buf[:n])
+		if err != nil || n < roughtimeRequestSize {
+			continue
+		}
+		s.conn.WriteTo(s.respond(req[tagNONC]), addr)
+	}
+}
+
+// Builds a signed response to a request with the given nonce.
+func (s *fakeRoughtimeServer) respond(nonce []byte) []byte {
+	onlinePub, onlinePriv, _ := ed25519.GenerateKey(nil)
+	now := time.Now().Add(s.offset)
+	u64 := func(t time.Time) []byte { return binary.LittleEndian.AppendUint64(nil, uint64(t.UnixMicro())) }
+	dele := encodeRoughtimeMessage(map[uint32][]byte{
+		tagPUBK: onlinePub,
+		tagMINT: u64(now.Add(-time.Hour)),
+		tagMAXT: u64(now.Add(time.Hour)),
+	})
+	cert := encodeRoughtimeMessage(map[uint32][]byte{
+		tagDELE: dele,
+		tagSIG:  ed25519.Sign(s.rootKey, append([]byte(roughtimeDelegationContext), dele...)),
+	})
+
+	// The nonce is the third leaf of the tree.
+	leaves := make([][]byte, 4)
+	for i := range leaves {
+		leaves[i] = roughtimeLeafHash([]byte{byte(i)})
+	}
+	leaves[2] = roughtimeLeafHash(nonce)
+	left := roughtimeNodeHash(leaves[0], leaves[1])
+	root := roughtimeNodeHash(left, roughtimeNodeHash(leaves[2], leaves[3]))
+
+	srep := encodeRoughtimeMessage(map[uint32][]byte{
+		tagROOT: root,
+		tagMIDP: u64(now),
+		tagRADI: binary.LittleEndian.AppendUint32(nil, uint32(time.Second/time.Microsecond)),
+	})
+	return encodeRoughtimeMessage(map[uint32][]byte{
+		tagSIG:  ed25519.Sign(onlinePriv, append([]byte(roughtimeResponseContext), srep...)),
+		tagSREP: srep,
+		tagCERT: cert,
+		tagINDX: binary.LittleEndian.AppendUint32(nil, 2),
+		tagPATH: append(append([]byte{}, leaves[3]...), left...),
+	})
+}
+
+func TestRoughtime(t *testing.T) {
+	_, server := startFakeRoughtimeServer(t, 0)
+	session, err := dialRoughtime(server)
+	if err != nil {
+		t.Fatalf("Failed to dial: %+v", err)
+	}
+	got, err := session.Query()
+	if err != nil {
+		t.Fatalf("Failed to query: %+v", err)
+	}
+	if d := time.Since(got.time); d < time.Second || d > time.Minute {
+		t.Errorf("Roughtime query returned %s, %v before now, want the start of the uncertainty radius", got.time.Format(time.RFC3339Nano), d)
+	}
+	if got.width != 2*time.Second {
+		t.Errorf("Roughtime query returned width %v, want 2s", got.width)
+	}
+	proof := got.proof
+
+	midpoint, radius, err := proof.Verify()
+	if err != nil {
+		t.Fatalf("Failed to verify proof: %+v", err)
+	}
+	if !midpoint.Add(-radius).Equal(got.time) || radius != time.Second {
+		t.Errorf("Proof verified as %s ± %v, want %s ± 1s", midpoint.Format(time.RFC3339Nano), radius, got.time.Add(time.Second).Format(time.RFC3339Nano))
+	}
+
+	t.Run("WrongKey", func(t *testing.T) {
+		bad := *proof
+		bad.Server.PublicKey, _, _ = ed25519.GenerateKey(nil)
+		if _, _, err := bad.Verify(); !errors.Is(err, errInvalidRoughtimeResponse) {
+			t.Errorf("Proof verified under the wrong key: got error %v", err)
+		}
+	})
+	t.Run("WrongNonce", func(t *testing.T) {
+		bad := *proof
+		bad.Nonce = make([]byte, roughtimeNonceSize)
+		if _, _, err := bad.Verify(); !errors.Is(err, errInvalidRoughtimeResponse) {
+			t.Errorf("Proof verified for the wrong nonce: got error %v", err)
+		}
+	})
+	t.Run("Tampered", func(t *testing.T) {
+		for i := range proof.Response {
+			bad := *proof
+			bad.Response = append([]byte{}, proof.Response...)
+			bad.Response[i] ^= 1
+			if _, _, err := bad.Verify(); err == nil {
+				t.Fatalf("Proof verified with byte %d modified", i)
+			}
+		}
+	})
+}
+
+func TestRoughtimeQuorum(t *testing.T) {
+	_, server := startFakeRoughtimeServer(t, 0)
+	_, ahead := startFakeRoughtimeServer(t, 365*24*time.Hour)
+	servers := map[string]*fakeServer{
+		"a": {time: time.Now()},
+	}
+	ts := append(fakeTimeServers(servers, "a"), timeServers(nil, []RoughtimeServer{server, ahead}, nil)...)
+	p, err := startPoller(ts)
+	if err != nil {
+		t.Fatalf("Failed to create poller: %+v", err)
+	}
+	c := &SecureClock{cell: p.Cell(), opts: p.opts}
+	checkNow(t, c)
+
+	// Only the proof of the Roughtime server in the quorum is retained.
+	proofs := c.RoughtimeProofs()
+	if len(proofs) != 1 {
+		t.Fatalf("Got %d Roughtime proofs, want 1", len(proofs))
+	}
+	if proofs[0].Server.Addr != server.Addr {
+		t.Errorf("Got proof from %s, want %s", proofs[0].Server.Addr, server.Addr)
+	}
+	if _, _, err := proofs[0].Verify(); err != nil {
+		t.Errorf("Failed to verify proof: %+v", err)
+	}
+}
+
+func TestParseRoughtimeServer(t *testing.T) {
+	pub, _, _ := ed25519.GenerateKey(nil)
+	key := base64.StdEncoding.EncodeToString(pub)
+
+	got, err := ParseRoughtimeServer("roughtime.example.com:2002/" + key)
+	if err != nil {
+		t.Fatalf("Failed to parse server: %+v", err)
+	}
+	if got.Addr != "roughtime.example.com:2002" || !got.PublicKey.Equal(pub) {
+		t.Errorf("Got %+v, want roughtime.example.com:2002 with key %s", got, key)
+	}
+
+	for _, s := range []string{
+		"roughtime.example.com:2002",
+		"roughtime.example.com:2002/!!!",
+		"roughtime.example.com:2002/" + base64.StdEncoding.EncodeToString(pub[:16]),
+	} {
+		if _, err := ParseRoughtimeServer(s); err == nil {
+			t.Errorf("Parsed invalid server %q", s)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+// Command tcbundle exports a PKI as a single encrypted bundle and imports it elsewhere, for disaster
+// recovery and migration between servers.
+//
+// Usage:
+//
+//	tcbundle -dir <secrets directory> -file <bundle> [-passphrase-file <file>] export|import
+//
+// The bundle is encrypted under a passphrase, which is read from the passphrase file if given, or
+// else from TIMECAPSULE_BUNDLE_PASSPHRASE. Only PKIs whose secrets are kept in the secrets
+// directory are supported; PKIs kept in other secret stores are exported with keys.ExportPKI.
+package main
+
+import (
+	"bytes"
+	"flag"
+	"log"
+	"os"
+
+	"github.com/newgrp/timecapsule/keys"
+)
+
+const envPassphrase = "TIMECAPSULE_BUNDLE_PASSPHRASE"
+
+func main() {
+	dir := flag.String("dir", "", "secrets directory of the PKI")
+	file := flag.String("file", "", "bundle to write or read")
+	passphraseFile := flag.String("passphrase-file", "", "file holding the bundle passphrase")
+	flag.Parse()
+	if *dir == "" || *file == "" || flag.NArg() != 1 {
+		log.Fatalf("Usage: tcbundle -dir <secrets directory> -file <bundle> [-passphrase-file <file>] export|import")
+	}
+
+	var passphrase []byte
+	if *passphraseFile != "" {
+		b, err := os.ReadFile(*passphraseFile)
+		if err != nil {
+			log.Fatalf("Failed to read passphrase: %v", err)
+		}
+		passphrase = bytes.TrimRight(b, "\r\n")
+	} else {
+		passphrase = []byte(os.Getenv(envPassphrase))
+	}
+	if len(passphrase) == 0 {
+		log.Fatalf("No passphrase provided in -passphrase-file or %s", envPassphrase)
+	}
+	opts := keys.BundleOptions{Passphrase: passphrase}
+
+	switch flag.Arg(0) {
+	case "export":
+		// O_EXCL avoids overwriting an earlier bundle.
+		f, err := os.OpenFile(*file, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+		if err != nil {
+			log.Fatalf("Failed to create bundle: %v", err)
+		}
+		if err := keys.ExportPKI(*dir, f, opts); err != nil {
+			f.Close()
+			os.Remove(*file)
+			log.Fatalf("Failed to export PKI: %v", err)
+		}
+		if err := f.Sync(); err != nil {
+			log.Fatalf("Failed to write bundle: %v", err)
+		}
+		if err := f.Close(); err != nil {
+			log.Fatalf("Failed to write bundle: %v", err)
+		}
+	case "import":
+		f, err := os.Open(*file)
+		if err != nil {
+			log.Fatalf("Failed to open bundle: %v", err)
+		}
+		defer f.Close()
+		if err := keys.ImportPKI(f, *dir, opts); err != nil {
+			log.Fatalf("Failed to import PKI: %v", err)
+		}
+	default:
+		log.Fatalf("Unknown command %q, want export or import", flag.Arg(0))
+	}
+}
@@ -0,0 +1,64 @@
+// Command tcshares splits the master secret of a PKI with hierarchical secrets into shares, any
+// threshold of which reconstruct it, so that no single operator can reconstitute the PKI.
+//
+// Usage:
+//
+//	tcshares -dir <secrets directory> -shares <n> -threshold <k> [-out <directory>] [-remove]
+//
+// Shares are printed one per line, or written to share-1, ..., share-n in the output directory.
+// With -remove, the master secret file is deleted once the shares are written. The server is then
+// started with the shares given in MASTER_SECRET_SHARES_FILES, or on standard input with
+// MASTER_SECRET_SHARES_STDIN=true.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/newgrp/timecapsule/keys"
+)
+
+func main() {
+	dir := flag.String("dir", "", "secrets directory of the PKI")
+	n := flag.Int("shares", 0, "number of shares")
+	threshold := flag.Int("threshold", 0, "number of shares needed to reconstruct the master secret")
+	out := flag.String("out", "", "directory to write shares to, instead of standard output")
+	remove := flag.Bool("remove", false, "delete the master secret file once the shares are written")
+	flag.Parse()
+	if *dir == "" {
+		log.Fatalf("No secrets directory provided")
+	}
+
+	shares, err := keys.SplitMasterSecret(*dir, *n, *threshold)
+	if err != nil {
+		log.Fatalf("Failed to split master secret: %v", err)
+	}
+	for i, s := range shares {
+		if *out == "" {
+			fmt.Println(s)
+			continue
+		}
+		path := filepath.Join(*out, fmt.Sprintf("share-%d", i+1))
+		// O_EXCL avoids overwriting the shares of an earlier split.
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o400)
+		if err != nil {
+			log.Fatalf("Failed to write share: %v", err)
+		}
+		if _, err := fmt.Fprintln(f, s); err != nil {
+			log.Fatalf("Failed to write share %s: %v", path, err)
+		}
+		if err := f.Close(); err != nil {
+			log.Fatalf("Failed to write share %s: %v", path, err)
+		}
+	}
+
+	if *remove {
+		if err := keys.RemoveMasterSecret(*dir); err != nil {
+			log.Fatalf("Failed to remove master secret: %v", err)
+		}
+		log.Printf("Removed master secret; start the server with %d of the %d shares", *threshold, *n)
+	}
+}
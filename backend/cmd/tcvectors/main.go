@@ -0,0 +1,74 @@
+// Command tcvectors prints test vectors of timecapsule's key derivation as JSON, for checking
+// third-party implementations against.
+//
+// Usage:
+//
+//	tcvectors [-secret <hex> -times <time>,... -algorithm <algorithm> -kdf-version <n> [-salt <hex>] [-context <string>]]
+//
+// Without -secret, the standard vectors of the testvectors package are printed. Otherwise, the keys
+// derived from the given root secret at each of the given RFC 3339 times are printed.
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/newgrp/timecapsule/keys"
+	"github.com/newgrp/timecapsule/keys/testvectors"
+)
+
+func main() {
+	secretHex := flag.String("secret", "", "hex-encoded root secret; if empty, the standard vectors are printed")
+	timesStr := flag.String("times", "", "comma-separated RFC 3339 times of the keys")
+	alg := flag.String("algorithm", string(keys.AlgorithmP256), "key algorithm")
+	version := flag.Int("kdf-version", 2, "KDF version")
+	saltHex := flag.String("salt", "", "hex-encoded HKDF salt")
+	context := flag.String("context", "", "HKDF application context string")
+	flag.Parse()
+
+	var vectors []testvectors.Vector
+	if *secretHex == "" {
+		var err error
+		if vectors, err = testvectors.Standard(); err != nil {
+			log.Fatalf("Failed to generate standard vectors: %v", err)
+		}
+	} else {
+		secret, err := hex.DecodeString(*secretHex)
+		if err != nil {
+			log.Fatalf("Invalid secret: %v", err)
+		}
+		salt, err := hex.DecodeString(*saltHex)
+		if err != nil {
+			log.Fatalf("Invalid salt: %v", err)
+		}
+		if len(salt) == 0 {
+			salt = nil
+		}
+		if *timesStr == "" {
+			log.Fatalf("No times provided")
+		}
+		var times []time.Time
+		for _, s := range strings.Split(*timesStr, ",") {
+			t, err := time.Parse(time.RFC3339, strings.TrimSpace(s))
+			if err != nil {
+				log.Fatalf("Invalid time %q: %v", s, err)
+			}
+			times = append(times, t)
+		}
+		p := testvectors.Params{KDFVersion: *version, Algorithm: keys.Algorithm(*alg), Salt: salt, Context: *context}
+		if vectors, err = testvectors.Generate(p, secret, times); err != nil {
+			log.Fatalf("Failed to generate vectors: %v", err)
+		}
+	}
+
+	e := json.NewEncoder(os.Stdout)
+	e.SetIndent("", "  ")
+	if err := e.Encode(vectors); err != nil {
+		log.Fatalf("Failed to write vectors: %v", err)
+	}
+}
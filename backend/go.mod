@@ -1,17 +1,21 @@
 module github.com/newgrp/timecapsule
 
-go 1.23.0
+go 1.26.0
 
 require (
 	github.com/beevik/nts v0.1.1
 	github.com/google/uuid v1.6.0
-	golang.org/x/crypto v0.26.0
+	golang.org/x/crypto v0.54.0
+	google.golang.org/grpc v1.84.0
+	google.golang.org/protobuf v1.36.11
 )
 
 require (
 	github.com/aead/cmac v0.0.0-20160719120800-7af84192f0b1 // indirect
 	github.com/beevik/ntp v1.4.0 // indirect
 	github.com/secure-io/siv-go v0.0.0-20180922214919-5ff40651e2c4 // indirect
-	golang.org/x/net v0.24.0 // indirect
-	golang.org/x/sys v0.23.0 // indirect
+	golang.org/x/net v0.57.0 // indirect
+	golang.org/x/sys v0.47.0 // indirect
+	golang.org/x/text v0.40.0 // indirect
+	google.golang.org/genproto/googleapis/rpc v0.0.0-20260706201446-f0a921348800 // indirect
 )
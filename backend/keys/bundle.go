@@ -0,0 +1,286 @@
+package keys
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// Prefix of every PKI bundle.
+const bundleMagic = "TCB1"
+
+// Argon2id parameters with which bundle keys are derived from passphrases.
+const (
+	bundleSaltSize = 16
+	bundleTime     = 3
+	bundleMemory   = 64 * 1024
+	bundleThreads  = 4
+)
+
+// Directories of a bundle's archive holding configuration files and secrets.
+const (
+	bundleConfigDir  = "config"
+	bundleSecretsDir = "secrets"
+)
+
+// Options for exporting and importing PKI bundles.
+type BundleOptions struct {
+	// Passphrase from which the bundle's encryption key is derived. Required.
+	Passphrase []byte
+
+	// Store holding the PKI's secrets. If nil, secrets are kept in the secrets directory.
+	SecretStore SecretStore
+}
+
+// Derives the AEAD of a bundle from its passphrase and salt.
+func newBundleAEAD(passphrase, salt []byte) (cipher.AEAD, error) {
+	if len(passphrase) == 0 {
+		return nil, errors.New("no bundle passphrase provided")
+	}
+	key := argon2.IDKey(passphrase, salt, bundleTime, bundleMemory, bundleThreads, 32)
+	defer clear(key)
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Returns the store holding the secrets of the PKI in a secrets directory.
+func bundleStore(dir string, layout SecretLayout, opts BundleOptions) SecretStore {
+	if opts.SecretStore != nil {
+		return opts.SecretStore
+	}
+	return &dirStore{dir: dir, layout: layout}
+}
+
+// Writes the PKI in a secrets directory to w as a single encrypted bundle, from which ImportPKI
+// restores it elsewhere, for disaster recovery and migration between servers.
+//
+// The bundle holds the PKI's configuration files, including its name, UUID, and any master secret
+// and signing key, and every secret in its store. Secrets are exported as stored, so secrets
+// encrypted with a KMS can only be used where the same KMS is available, and their checksums are
+// verified when the imported PKI is loaded.
+//
+// A bundle is
+//
+//	"TCB1" || salt (16 bytes) || nonce || ciphertext
+//
+// where the ciphertext is the AES-256-GCM encryption of a tar archive, with additional data of
+// "TCB1" and the salt, under a key derived from the passphrase and the salt with Argon2id.
+func ExportPKI(dir string, w io.Writer, opts BundleOptions) error {
+	if _, ok, err := tryReadFile(path.Join(dir, uuidFile)); err != nil {
+		return fmt.Errorf("failed to read PKI UUID: %w", err)
+	} else if !ok {
+		return fmt.Errorf("no PKI in %s", dir)
+	}
+	layout := SecretLayoutFlat
+	if recorded, ok, err := newFileSource(path.Join(dir, layoutFile)).Get(); err != nil {
+		return fmt.Errorf("failed to read secret layout: %w", err)
+	} else if ok {
+		if layout, err = parseSecretLayout(recorded); err != nil {
+			return err
+		}
+	}
+
+	var archive bytes.Buffer
+	// The archive holds secrets in the clear until it is encrypted.
+	defer clear(archive.Bytes())
+	tw := tar.NewWriter(&archive)
+	add := func(name string, contents []byte, mode fs.FileMode) error {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: int64(mode), Size: int64(len(contents))}); err != nil {
+			return err
+		}
+		_, err := tw.Write(contents)
+		return err
+	}
+
+	for name := range configFiles {
+		file := path.Join(dir, name)
+		contents, ok, err := tryReadFile(file)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", file, err)
+		}
+		if !ok {
+			continue
+		}
+		info, err := os.Stat(file)
+		if err != nil {
+			return err
+		}
+		err = add(path.Join(bundleConfigDir, name), contents, info.Mode().Perm())
+		clear(contents)
+		if err != nil {
+			return fmt.Errorf("failed to archive %s: %w", file, err)
+		}
+	}
+
+	store := bundleStore(dir, layout, opts)
+	buckets, err := store.List()
+	if err != nil {
+		return fmt.Errorf("failed to list secrets: %w", err)
+	}
+	for _, bucket := range buckets {
+		secret, ok, err := store.Get(bucket)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return fmt.Errorf("secret for %s disappeared during export", bucket.Format(time.RFC3339))
+		}
+		err = add(path.Join(bundleSecretsDir, bucket.UTC().Format(fileNameLayout)), secret, secretMode)
+		clear(secret)
+		if err != nil {
+			return fmt.Errorf("failed to archive secret for %s: %w", bucket.Format(time.RFC3339), err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+
+	salt := make([]byte, bundleSaltSize)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return fmt.Errorf("insufficient entropy: %w", err)
+	}
+	aead, err := newBundleAEAD(opts.Passphrase, salt)
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return fmt.Errorf("insufficient entropy: %w", err)
+	}
+	header := append([]byte(bundleMagic), salt...)
+	bundle := append(append(header, nonce...), aead.Seal(nil, nonce, archive.Bytes(), header)...)
+	if _, err := w.Write(bundle); err != nil {
+		return fmt.Errorf("failed to write bundle: %w", err)
+	}
+	log.Printf("Exported PKI in %s with %d secrets", dir, len(buckets))
+	return nil
+}
+
+// Restores a PKI exported by ExportPKI into a secrets directory, which must not already hold a PKI.
+// The directory is created if it doesn't exist.
+//
+// The PKI's secrets are written to the store in opts, so a PKI can be moved between stores. The
+// imported PKI is checked when it is next loaded. If an import fails, the directory should be
+// emptied before retrying.
+func ImportPKI(r io.Reader, dir string, opts BundleOptions) error {
+	bundle, err := io.ReadAll(r)
+	if err != nil {
+		return fmt.Errorf("failed to read bundle: %w", err)
+	}
+	rest, ok := bytes.CutPrefix(bundle, []byte(bundleMagic))
+	if !ok {
+		return errors.New("not a PKI bundle")
+	}
+	if len(rest) < bundleSaltSize {
+		return errors.New("PKI bundle is truncated")
+	}
+	salt, rest := rest[:bundleSaltSize], rest[bundleSaltSize:]
+	aead, err := newBundleAEAD(opts.Passphrase, salt)
+	if err != nil {
+		return err
+	}
+	if len(rest) < aead.NonceSize() {
+		return errors.New("PKI bundle is truncated")
+	}
+	nonce, ciphertext := rest[:aead.NonceSize()], rest[aead.NonceSize():]
+	archive, err := aead.Open(nil, nonce, ciphertext, bundle[:len(bundleMagic)+bundleSaltSize])
+	if err != nil {
+		return errors.New("failed to decrypt PKI bundle: wrong passphrase or corrupted bundle")
+	}
+	defer clear(archive)
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("failed to create secrets directory: %w", err)
+	}
+	if _, ok, err := tryReadFile(path.Join(dir, uuidFile)); err != nil {
+		return fmt.Errorf("failed to read PKI UUID: %w", err)
+	} else if ok {
+		return fmt.Errorf("%s already holds a PKI", dir)
+	}
+
+	// Configuration files are written before secrets, so that the layout of secret files is known.
+	// The UUID is written last, since it marks the directory as holding a PKI.
+	var (
+		pkiID   []byte
+		secrets = map[time.Time][]byte{}
+	)
+	tr := tar.NewReader(bytes.NewReader(archive))
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("invalid PKI bundle: %w", err)
+		}
+		contents, err := io.ReadAll(tr)
+		if err != nil {
+			return fmt.Errorf("invalid PKI bundle: %w", err)
+		}
+		dirName, name := path.Split(hdr.Name)
+		switch strings.TrimSuffix(dirName, "/") {
+		case bundleConfigDir:
+			if !configFiles[name] {
+				return fmt.Errorf("invalid PKI bundle: unknown configuration file %q", name)
+			}
+			if name == uuidFile {
+				pkiID = contents
+				continue
+			}
+			if err := createFileAtomic(path.Join(dir, name), contents, fs.FileMode(hdr.Mode).Perm()); err != nil {
+				return fmt.Errorf("failed to write %s: %w", name, err)
+			}
+		case bundleSecretsDir:
+			bucket, err := time.Parse(fileNameLayout, name)
+			if err != nil {
+				return fmt.Errorf("invalid PKI bundle: unrecognized secret %q", name)
+			}
+			secrets[bucket] = contents
+		default:
+			return fmt.Errorf("invalid PKI bundle: unrecognized file %q", hdr.Name)
+		}
+	}
+	if pkiID == nil {
+		return errors.New("invalid PKI bundle: no PKI UUID")
+	}
+
+	layout, err := loadSecretLayout(dir, "")
+	if err != nil {
+		return err
+	}
+	store := bundleStore(dir, layout, opts)
+	for bucket, secret := range secrets {
+		err := store.Put(bucket, secret)
+		if errors.Is(err, ErrSecretExists) {
+			// The store may be shared with the exporting server, or hold an earlier import.
+			existing, _, getErr := store.Get(bucket)
+			if getErr == nil && bytes.Equal(existing, secret) {
+				err = nil
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("failed to import secret for %s: %w", bucket.Format(time.RFC3339), err)
+		}
+	}
+	if err := createFileAtomic(path.Join(dir, uuidFile), pkiID, fileMode); err != nil {
+		return fmt.Errorf("failed to write %s: %w", uuidFile, err)
+	}
+	log.Printf("Imported PKI into %s with %d secrets", dir, len(secrets))
+	return nil
+}
@@ -0,0 +1,135 @@
+package keys
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// Default for PKIOptions.KeyCacheSize.
+const defaultKeyCacheSize = 4096
+
+// Identifies a derived key in the key cache.
+type cacheKey struct {
+	// HKDF label of the key. See kdfInfo.
+	label   string
+	version int
+	// Keys are derived per second, so this identifies the time.
+	unix int64
+}
+
+// Counters describing the effectiveness of a PKI's key cache.
+type CacheStats struct {
+	// Requests served from the cache.
+	Hits uint64
+	// Requests that derived a key.
+	Misses uint64
+	// Requests that waited for a concurrent request to derive the same key instead of deriving it
+	// themselves.
+	Shared uint64
+	// Keys dropped from the cache to make room for others.
+	Evictions uint64
+	// Number of keys in the cache.
+	Size int
+}
+
+// A derivation in progress, which concurrent requests for the same key wait for.
+type flight struct {
+	done  chan struct{}
+	value any
+	err   error
+}
+
+// An entry of the key cache's recency list.
+type cacheEntry struct {
+	key   cacheKey
+	value any
+}
+
+// Least-recently-used cache of derived keys. Concurrent requests for a key that is not cached share
+// a single derivation.
+type keyCache struct {
+	size int
+
+	mu       sync.Mutex
+	entries  map[cacheKey]*list.Element
+	recency  *list.List // of *cacheEntry, most recently used first
+	inflight map[cacheKey]*flight
+	stats    CacheStats
+}
+
+// Returns a key cache holding at most size keys, or nil, which caches nothing, if size is negative.
+func newKeyCache(size int) *keyCache {
+	if size < 0 {
+		return nil
+	}
+	if size == 0 {
+		size = defaultKeyCacheSize
+	}
+	return &keyCache{
+		size:     size,
+		entries:  make(map[cacheKey]*list.Element),
+		recency:  list.New(),
+		inflight: make(map[cacheKey]*flight),
+	}
+}
+
+// Returns the cached value of a key, calling derive to compute it if it isn't cached. Errors are not
+// cached.
+func cached[T any](c *keyCache, label string, version int, t time.Time, derive func() (T, error)) (T, error) {
+	if c == nil {
+		return derive()
+	}
+	key := cacheKey{label: label, version: version, unix: t.Unix()}
+
+	c.mu.Lock()
+	if e, ok := c.entries[key]; ok {
+		c.recency.MoveToFront(e)
+		c.stats.Hits++
+		c.mu.Unlock()
+		return e.Value.(*cacheEntry).value.(T), nil
+	}
+	if f, ok := c.inflight[key]; ok {
+		c.stats.Shared++
+		c.mu.Unlock()
+		<-f.done
+		if f.err != nil {
+			var zero T
+			return zero, f.err
+		}
+		return f.value.(T), nil
+	}
+	c.stats.Misses++
+	f := &flight{done: make(chan struct{})}
+	c.inflight[key] = f
+	c.mu.Unlock()
+
+	value, err := derive()
+	f.value, f.err = value, err
+
+	c.mu.Lock()
+	delete(c.inflight, key)
+	if err == nil {
+		c.entries[key] = c.recency.PushFront(&cacheEntry{key: key, value: value})
+		for c.recency.Len() > c.size {
+			oldest := c.recency.Remove(c.recency.Back()).(*cacheEntry)
+			delete(c.entries, oldest.key)
+			c.stats.Evictions++
+		}
+	}
+	c.mu.Unlock()
+	close(f.done)
+	return value, err
+}
+
+// Returns the cache's counters.
+func (c *keyCache) Stats() CacheStats {
+	if c == nil {
+		return CacheStats{}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats := c.stats
+	stats.Size = c.recency.Len()
+	return stats
+}
@@ -0,0 +1,71 @@
+package keys
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Prefix of every checksummed secret.
+const checksumMagic = "TCK1"
+
+// Returned when a stored secret fails its integrity check.
+var ErrCorruptSecret = errors.New("secret is corrupted")
+
+// Secret store that stores each secret with a checksum and verifies it on every read, so that a
+// corrupted secret fails loudly rather than silently yielding different keys. A stored secret is
+//
+//	"TCK1" || secret || SHA-256("TCK1" || start of bucket as 8-byte big-endian Unix seconds || secret)
+//
+// Secrets stored without a checksum are returned as they are, provided they have the size of a
+// secret.
+type checksumStore struct {
+	inner SecretStore
+}
+
+// Returns the checksum of the secret of a bucket.
+func secretChecksum(bucket time.Time, secret []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte(checksumMagic))
+	h.Write(binary.BigEndian.AppendUint64(nil, uint64(bucket.Unix())))
+	h.Write(secret)
+	return h.Sum(nil)
+}
+
+func (c *checksumStore) Get(bucket time.Time) ([]byte, bool, error) {
+	stored, ok, err := c.inner.Get(bucket)
+	if err != nil || !ok {
+		return nil, ok, err
+	}
+	if len(stored) == secretSize {
+		return stored, true, nil
+	}
+	rest, ok := bytes.CutPrefix(stored, []byte(checksumMagic))
+	if !ok || len(rest) < sha256.Size {
+		return nil, false, fmt.Errorf("%w: secret for %s has no checksum and %d bytes, want %d", ErrCorruptSecret, bucket.Format(time.RFC3339), len(stored), secretSize)
+	}
+	secret, sum := rest[:len(rest)-sha256.Size], rest[len(rest)-sha256.Size:]
+	if subtle.ConstantTimeCompare(sum, secretChecksum(bucket, secret)) != 1 {
+		return nil, false, fmt.Errorf("%w: checksum mismatch for secret for %s", ErrCorruptSecret, bucket.Format(time.RFC3339))
+	}
+	return secret, true, nil
+}
+
+func (c *checksumStore) Put(bucket time.Time, secret []byte) error {
+	stored := append([]byte(checksumMagic), secret...)
+	stored = append(stored, secretChecksum(bucket, secret)...)
+	defer clear(stored)
+	return c.inner.Put(bucket, stored)
+}
+
+func (c *checksumStore) Delete(bucket time.Time) error {
+	return deleteSecret(c.inner, bucket)
+}
+
+func (c *checksumStore) List() ([]time.Time, error) {
+	return c.inner.List()
+}
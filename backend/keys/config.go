@@ -2,7 +2,6 @@ package keys
 
 import (
 	"fmt"
-	"os"
 	"strings"
 )
 
@@ -79,7 +78,7 @@ func (f *fileSource) Set(value string) error {
 	if value != "" && value[len(value)-1] != '\n' {
 		value = fmt.Sprintf("%s\n", value)
 	}
-	return os.WriteFile(f.path, []byte(value), fileMode)
+	return createFileAtomic(f.path, []byte(value), fileMode)
 }
 
 // A function that generates a new value. Writing to this source is a no-op.
@@ -0,0 +1,81 @@
+package keys
+
+import (
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ed25519"
+	"fmt"
+
+	"github.com/newgrp/timecapsule/cbor"
+)
+
+// COSE_Key map labels and values from RFC 9052 and RFC 9053.
+const (
+	coseKty = 1
+	coseCrv = -1
+	coseX   = -2
+	coseY   = -3
+	coseD   = -4
+
+	coseKtyOKP = 1
+	coseKtyEC2 = 2
+
+	coseCrvP256    = 1
+	coseCrvP384    = 2
+	coseCrvX25519  = 4
+	coseCrvEd25519 = 6
+)
+
+// Returns the COSE_Key map of a public key.
+func publicCOSEKey(pub crypto.PublicKey) (map[int]any, error) {
+	if pub, ok := pub.(ed25519.PublicKey); ok {
+		return map[int]any{coseKty: coseKtyOKP, coseCrv: coseCrvEd25519, coseX: []byte(pub)}, nil
+	}
+	ecdhPub, ok := pub.(*ecdh.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("public key is of unsupported type %T", pub)
+	}
+
+	b := ecdhPub.Bytes()
+	switch curve := ecdhPub.Curve(); curve {
+	case ecdh.P256(), ecdh.P384():
+		crv := coseCrvP256
+		if curve == ecdh.P384() {
+			crv = coseCrvP384
+		}
+		// Uncompressed point encoding: 0x04 || X || Y.
+		n := (len(b) - 1) / 2
+		return map[int]any{coseKty: coseKtyEC2, coseCrv: crv, coseX: b[1 : 1+n], coseY: b[1+n:]}, nil
+	case ecdh.X25519():
+		return map[int]any{coseKty: coseKtyOKP, coseCrv: coseCrvX25519, coseX: b}, nil
+	default:
+		return nil, fmt.Errorf("public key has unsupported curve %v", curve)
+	}
+}
+
+// Formats a public key as a CBOR-encoded COSE_Key (RFC 9052 Section 7), the key format of
+// COSE-speaking clients.
+func FormatPublicKeyAsCOSE(pub crypto.PublicKey) ([]byte, error) {
+	key, err := publicCOSEKey(pub)
+	if err != nil {
+		return nil, err
+	}
+	return cbor.Marshal(key)
+}
+
+// Formats a private key as a CBOR-encoded COSE_Key, including its public part.
+func FormatPrivateKeyAsCOSE(priv PrivateKey) ([]byte, error) {
+	key, err := publicCOSEKey(priv.Public())
+	if err != nil {
+		return nil, err
+	}
+	switch priv := priv.(type) {
+	case *ecdh.PrivateKey:
+		key[coseD] = priv.Bytes()
+	case ed25519.PrivateKey:
+		key[coseD] = priv.Seed()
+	default:
+		return nil, fmt.Errorf("private key is of unsupported type %T", priv)
+	}
+	return cbor.Marshal(key)
+}
@@ -1,50 +1,130 @@
 package keys
 
 import (
-	"bytes"
 	"crypto/ecdh"
-	"crypto/sha256"
-	"encoding/binary"
+	"crypto/ed25519"
 	"fmt"
 	"io"
+	"strconv"
 	"time"
-
-	"golang.org/x/crypto/hkdf"
 )
 
 const maxKeyAttempts = 10
 const p256ScalarSize = 32
+const p384ScalarSize = 48
+const x25519ScalarSize = 32
+
+// A key algorithm.
+type Algorithm string
+
+const (
+	AlgorithmP256    Algorithm = "P-256"
+	AlgorithmP384    Algorithm = "P-384"
+	AlgorithmX25519  Algorithm = "X25519"
+	AlgorithmEd25519 Algorithm = "Ed25519"
+)
+
+// Parses the name of a supported key algorithm.
+func parseAlgorithm(s string) (Algorithm, error) {
+	switch alg := Algorithm(s); alg {
+	case AlgorithmP256, AlgorithmP384, AlgorithmX25519, AlgorithmEd25519:
+		return alg, nil
+	default:
+		return "", fmt.Errorf("unsupported key algorithm %q", s)
+	}
+}
 
 // Generates a P-256 key pair from a byte stream of entropy.
 //
 // This function is essentially the same as ecdh.P256().GenerateKey(), but is guaranteed to be
 // stable.
 func generateKeyStable(stream io.Reader) (*ecdh.PrivateKey, error) {
+	return generateNISTKeyStable(ecdh.P256(), p256ScalarSize, stream)
+}
+
+// Generates a key pair on a NIST curve with scalars of the given size from a byte stream of
+// entropy.
+func generateNISTKeyStable(curve ecdh.Curve, scalarSize int, stream io.Reader) (*ecdh.PrivateKey, error) {
 	// This "generate bytes and check" approach seems uncomfortably naive, but it is used by the Go
 	// standard library and BoringSSL at time of writing. It is also recommended by FIPS 186-4
 	// B.4.2.
-	buf := make([]byte, p256ScalarSize)
+	buf := make([]byte, scalarSize)
+	// The key copies the scalar, so the buffer needn't outlive this call.
+	defer clear(buf)
 	for i := 0; i < maxKeyAttempts; i++ {
 		if _, err := io.ReadFull(stream, buf); err != nil {
 			return nil, fmt.Errorf("ran out of entropy: %w", err)
 		}
-		if key, err := ecdh.P256().NewPrivateKey(buf); err == nil {
+		if key, err := curve.NewPrivateKey(buf); err == nil {
 			return key, nil
 		}
 	}
 	return nil, fmt.Errorf("failed to generate a valid key in %d attempts", maxKeyAttempts)
 }
 
-// Derives a P-256 key pair from an initial secret and a time.
+// Generates an X25519 key pair from a byte stream of entropy.
+//
+// Every 32-byte string is a valid X25519 private key, so this never needs to retry.
+func generateX25519KeyStable(stream io.Reader) (*ecdh.PrivateKey, error) {
+	buf := make([]byte, x25519ScalarSize)
+	defer clear(buf)
+	if _, err := io.ReadFull(stream, buf); err != nil {
+		return nil, fmt.Errorf("ran out of entropy: %w", err)
+	}
+	return ecdh.X25519().NewPrivateKey(buf)
+}
+
+// Generates an Ed25519 key pair from a byte stream of entropy.
+//
+// Every 32-byte string is a valid Ed25519 seed, so this never needs to retry.
+func generateEd25519KeyStable(stream io.Reader) (ed25519.PrivateKey, error) {
+	seed := make([]byte, ed25519.SeedSize)
+	defer clear(seed)
+	if _, err := io.ReadFull(stream, seed); err != nil {
+		return nil, fmt.Errorf("ran out of entropy: %w", err)
+	}
+	return ed25519.NewKeyFromSeed(seed), nil
+}
+
+// Derives a key pair for the algorithm of the given KDF parameters from an initial secret and a
+// time.
 //
 // The key derivation is deterministic and stable.
-func deriveKeyForTime(ikm []byte, t time.Time) (*ecdh.PrivateKey, error) {
-	var info bytes.Buffer
-	if err := binary.Write(&info, binary.BigEndian, t.Unix()); err != nil {
-		// We should never fail to write an int64 to the buffer.
+func deriveKeyForTime(p kdfParams, ikm []byte, t time.Time) (PrivateKey, error) {
+	stream, err := keyStream(p, ikm, t, "")
+	if err != nil {
 		return nil, err
 	}
-	stream := hkdf.New(sha256.New, ikm, nil, info.Bytes())
 
-	return generateKeyStable(stream)
+	switch alg := p.alg; alg {
+	case AlgorithmP256:
+		return generateKeyStable(stream)
+	case AlgorithmP384:
+		return generateNISTKeyStable(ecdh.P384(), p384ScalarSize, stream)
+	case AlgorithmX25519:
+		return generateX25519KeyStable(stream)
+	case AlgorithmEd25519:
+		return generateEd25519KeyStable(stream)
+	default:
+		return nil, fmt.Errorf("unsupported key algorithm %q", alg)
+	}
+}
+
+// Derives the key pair for a time from the root secret of the time's interval, exactly as a PKI with
+// the given KDF version, algorithm, and HKDF customization would. The salt may be nil and the
+// context string empty for PKIs without customization.
+//
+// Servers derive keys through a KeyManager; this exists so that the derivation can be checked
+// against fixed secrets, as by the testvectors package.
+func DeriveKey(version int, alg Algorithm, salt []byte, context string, secret []byte, t time.Time) (PrivateKey, error) {
+	if _, err := parseKDFVersion(strconv.Itoa(version)); err != nil {
+		return nil, err
+	}
+	if _, err := parseAlgorithm(string(alg)); err != nil {
+		return nil, err
+	}
+	if len(secret) != secretSize {
+		return nil, fmt.Errorf("secret has %d bytes, want %d", len(secret), secretSize)
+	}
+	return deriveKeyForTime(kdfParams{version: version, alg: alg, salt: salt, context: context}, secret, t)
 }
@@ -0,0 +1,138 @@
+package keys
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"time"
+)
+
+// A key management service holding a key-encryption key, with which the data keys of encrypted
+// secrets are wrapped. The key-encryption key itself never leaves the service.
+type KMS interface {
+	// Encrypts a data key.
+	Encrypt(plaintext []byte) ([]byte, error)
+	// Decrypts a data key encrypted by Encrypt.
+	Decrypt(ciphertext []byte) ([]byte, error)
+}
+
+// Prefix of every envelope-encrypted secret.
+const envelopeMagic = "TCE1"
+
+// Size of the AES-256 data key of each envelope-encrypted secret.
+const dataKeySize = 32
+
+// Secret store that encrypts each secret with its own data key, which is in turn encrypted by a KMS,
+// before passing it to an inner store.
+//
+// A stored secret is
+//
+//	"TCE1" || length of wrapped data key (2-byte big-endian) || wrapped data key || nonce || ciphertext
+//
+// where the ciphertext is the AES-256-GCM encryption of the secret under the data key, with
+// additional data of "TCE1" and the start of the secret's bucket as 8-byte big-endian Unix seconds,
+// so that an encrypted secret can't be moved to another bucket.
+//
+// Secrets that the inner store holds without the "TCE1" prefix were written before encryption was
+// enabled, and are returned as they are.
+type envelopeStore struct {
+	inner SecretStore
+	kms   KMS
+}
+
+// Returns the additional data with which the secret of a bucket is encrypted.
+func envelopeAD(bucket time.Time) []byte {
+	return binary.BigEndian.AppendUint64([]byte(envelopeMagic), uint64(bucket.Unix()))
+}
+
+func (e *envelopeStore) Get(bucket time.Time) ([]byte, bool, error) {
+	stored, ok, err := e.inner.Get(bucket)
+	if err != nil || !ok {
+		return nil, ok, err
+	}
+	rest, ok := bytes.CutPrefix(stored, []byte(envelopeMagic))
+	if !ok {
+		return stored, true, nil
+	}
+
+	if len(rest) < 2 {
+		return nil, false, fmt.Errorf("encrypted secret for %s is truncated", bucket.Format(time.RFC3339))
+	}
+	n := 2 + int(binary.BigEndian.Uint16(rest))
+	if len(rest) < n {
+		return nil, false, fmt.Errorf("encrypted secret for %s is truncated", bucket.Format(time.RFC3339))
+	}
+	wrapped, rest := rest[2:n], rest[n:]
+	dataKey, err := e.kms.Decrypt(wrapped)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to decrypt data key of secret for %s: %w", bucket.Format(time.RFC3339), err)
+	}
+	// Data keys are only held while they are in use.
+	defer clear(dataKey)
+	aead, err := newDataKeyAEAD(dataKey)
+	if err != nil {
+		return nil, false, err
+	}
+	if len(rest) < aead.NonceSize() {
+		return nil, false, fmt.Errorf("encrypted secret for %s is truncated", bucket.Format(time.RFC3339))
+	}
+	secret, err := aead.Open(nil, rest[:aead.NonceSize()], rest[aead.NonceSize():], envelopeAD(bucket))
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to decrypt secret for %s: %w", bucket.Format(time.RFC3339), err)
+	}
+	return secret, true, nil
+}
+
+func (e *envelopeStore) Put(bucket time.Time, secret []byte) error {
+	dataKey := make([]byte, dataKeySize)
+	defer clear(dataKey)
+	if _, err := io.ReadFull(rand.Reader, dataKey); err != nil {
+		return fmt.Errorf("insufficient entropy: %w", err)
+	}
+	wrapped, err := e.kms.Encrypt(dataKey)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt data key of secret for %s: %w", bucket.Format(time.RFC3339), err)
+	}
+	if len(wrapped) > 0xFFFF {
+		return fmt.Errorf("KMS returned an encrypted data key of %d bytes, at most %d are supported", len(wrapped), 0xFFFF)
+	}
+	aead, err := newDataKeyAEAD(dataKey)
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return fmt.Errorf("insufficient entropy: %w", err)
+	}
+
+	stored := []byte(envelopeMagic)
+	stored = binary.BigEndian.AppendUint16(stored, uint16(len(wrapped)))
+	stored = append(stored, wrapped...)
+	stored = append(stored, nonce...)
+	stored = aead.Seal(stored, nonce, secret, envelopeAD(bucket))
+	return e.inner.Put(bucket, stored)
+}
+
+func (e *envelopeStore) Delete(bucket time.Time) error {
+	return deleteSecret(e.inner, bucket)
+}
+
+func (e *envelopeStore) List() ([]time.Time, error) {
+	return e.inner.List()
+}
+
+// Returns AES-256-GCM keyed with a data key.
+func newDataKeyAEAD(dataKey []byte) (cipher.AEAD, error) {
+	if len(dataKey) != dataKeySize {
+		return nil, fmt.Errorf("data key has %d bytes, want %d", len(dataKey), dataKeySize)
+	}
+	block, err := aes.NewCipher(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,102 @@
+package keys
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"sync"
+	"time"
+)
+
+const (
+	// Name of the file in the secrets directory that stores named events.
+	eventsFile = "events.json"
+
+	// Maximum length of an event name in bytes.
+	maxEventNameLength = 128
+)
+
+// Returned when an event cannot be registered because the request itself is invalid.
+var ErrInvalidEvent = errors.New("invalid event")
+
+// A durable mapping from event names to times.
+//
+// Events are stored as a JSON object mapping names to RFC 3339 times.
+type eventStore struct {
+	path string
+
+	mu     sync.Mutex
+	events map[string]time.Time
+}
+
+// Loads the event store in the given directory, or an empty store if none exists yet.
+func loadEventStore(dir string) (*eventStore, error) {
+	s := &eventStore{
+		path:   path.Join(dir, eventsFile),
+		events: make(map[string]time.Time),
+	}
+
+	b, ok, err := tryReadFile(s.path)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return s, nil
+	}
+	if err := json.Unmarshal(b, &s.events); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", s.path, err)
+	}
+	return s, nil
+}
+
+// Returns the time of the named event.
+func (s *eventStore) Get(name string) (time.Time, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, ok := s.events[name]
+	return t, ok
+}
+
+// Registers a new event, persisting it to disk.
+//
+// Re-registering an existing event is allowed only if the time is unchanged, since clients may
+// already have used the key for the original time.
+func (s *eventStore) Register(name string, t time.Time) error {
+	if name == "" {
+		return fmt.Errorf("%w: name must not be empty", ErrInvalidEvent)
+	}
+	if len(name) > maxEventNameLength {
+		return fmt.Errorf("%w: name must be at most %d bytes", ErrInvalidEvent, maxEventNameLength)
+	}
+	t = t.UTC()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if old, ok := s.events[name]; ok {
+		if !old.Equal(t) {
+			return fmt.Errorf("%w: %q is already registered for %s", ErrInvalidEvent, name, old.Format(time.RFC3339))
+		}
+		return nil
+	}
+
+	events := make(map[string]time.Time, len(s.events)+1)
+	for k, v := range s.events {
+		events[k] = v
+	}
+	events[name] = t
+	b, err := json.MarshalIndent(events, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// A crash must never leave a partially written file behind.
+	if err := replaceFileAtomic(s.path, append(b, '\n'), fileMode); err != nil {
+		return fmt.Errorf("failed to replace %s: %w", s.path, err)
+	}
+
+	s.events = events
+	return nil
+}
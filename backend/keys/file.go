@@ -4,6 +4,7 @@ import (
 	"errors"
 	"io/fs"
 	"os"
+	"path/filepath"
 )
 
 // Reads a file from disk, separating non-existence from other errors.
@@ -17,3 +18,76 @@ func tryReadFile(path string) (contents []byte, exists bool, err error) {
 	}
 	return contents, true, nil
 }
+
+// Suffix of temporary files, which are skipped when listing the secrets directory.
+const tempSuffix = ".tmp"
+
+// Writes data to a new temporary file next to path and syncs it to disk. Returns the temporary
+// file's path.
+func writeTempFile(path string, data []byte, mode fs.FileMode) (string, error) {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*"+tempSuffix)
+	if err != nil {
+		return "", err
+	}
+	tmp := f.Name()
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return "", err
+	}
+	if err := f.Chmod(mode); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return "", err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return "", err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return "", err
+	}
+	return tmp, nil
+}
+
+// Syncs a directory, making the creation, removal, and renaming of its entries durable.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// Creates a file atomically and durably: the file never exists with partial contents, even if the
+// process crashes, and once this returns, the file survives a power loss. Fails with an error
+// wrapping fs.ErrExist if the file already exists.
+func createFileAtomic(path string, data []byte, mode fs.FileMode) error {
+	tmp, err := writeTempFile(path, data, mode)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+	// Unlike renaming, linking never replaces an existing file.
+	if err := os.Link(tmp, path); err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(path))
+}
+
+// Replaces a file, or creates it if it does not exist, atomically and durably: readers see either
+// the old or the new contents, never a mix.
+func replaceFileAtomic(path string, data []byte, mode fs.FileMode) error {
+	tmp, err := writeTempFile(path, data, mode)
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return syncDir(filepath.Dir(path))
+}
@@ -1,9 +1,12 @@
 package keys
 
 import (
+	"crypto"
 	"crypto/ecdh"
 	"crypto/ecdsa"
+	"crypto/ed25519"
 	"crypto/x509"
+	"encoding/base64"
 	"encoding/pem"
 	"fmt"
 )
@@ -59,6 +62,19 @@ func ParseECDHPublicKeyAsSPKIDER(d []byte) (*ecdh.PublicKey, error) {
 	}
 }
 
+// Parses a DER-encoded SubjectPublicKeyInfo message as a time public key: an *ecdh.PublicKey, or an
+// ed25519.PublicKey for the Ed25519 algorithm.
+func ParsePublicKeyAsSPKIDER(d []byte) (crypto.PublicKey, error) {
+	parsed, err := x509.ParsePKIXPublicKey(d)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse SubjectPublicKeyInfo: %w", err)
+	}
+	if pub, ok := parsed.(ed25519.PublicKey); ok {
+		return pub, nil
+	}
+	return ParseECDHPublicKeyAsSPKIDER(d)
+}
+
 // Parses a PEM-encoded SubjectPublicKeyInfo message as an ECDH public key.
 func ParseECDHPublicKeyAsSPKIPEM(p string) (*ecdh.PublicKey, error) {
 	block, _ := pem.Decode([]byte(p))
@@ -91,6 +107,18 @@ func ParseECDHPrivateKeyAsPKCS8DER(d []byte) (*ecdh.PrivateKey, error) {
 	}
 }
 
+// Parses a DER-encoded PrivateKeyInfo (a.k.a. PKCS #8) message as a time private key.
+func ParsePrivateKeyAsPKCS8DER(d []byte) (PrivateKey, error) {
+	parsed, err := x509.ParsePKCS8PrivateKey(d)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse PKCS #8: %w", err)
+	}
+	if priv, ok := parsed.(ed25519.PrivateKey); ok {
+		return priv, nil
+	}
+	return ParseECDHPrivateKeyAsPKCS8DER(d)
+}
+
 // Parses a PEM-encoded PrivateKeyInfo (a.k.a. PKCS #8) message as an ECDH private key.
 func ParseECDHPrivateKeyAsPKCS8PEM(p string) (*ecdh.PrivateKey, error) {
 	block, _ := pem.Decode([]byte(p))
@@ -102,3 +130,73 @@ func ParseECDHPrivateKeyAsPKCS8PEM(p string) (*ecdh.PrivateKey, error) {
 	}
 	return ParseECDHPrivateKeyAsPKCS8DER(block.Bytes)
 }
+
+// JSON Web Key (RFC 7517) representation of a time key.
+type JWK struct {
+	Kty string `json:"kty"`
+	Crv string `json:"crv"`
+	Kid string `json:"kid,omitempty"`
+	Use string `json:"use,omitempty"`
+	Alg string `json:"alg,omitempty"`
+	X   string `json:"x"`
+	Y   string `json:"y,omitempty"`
+	D   string `json:"d,omitempty"`
+}
+
+// Formats a public key as a JWK. P-256 and P-384 keys use the "EC" key type (RFC 7518) and X25519
+// and Ed25519 keys use the "OKP" key type (RFC 8037).
+func FormatPublicKeyAsJWK(pub crypto.PublicKey) (*JWK, error) {
+	if pub, ok := pub.(ed25519.PublicKey); ok {
+		return &JWK{
+			Kty: "OKP",
+			Crv: "Ed25519",
+			X:   base64.RawURLEncoding.EncodeToString(pub),
+		}, nil
+	}
+	ecdhPub, ok := pub.(*ecdh.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("public key is of unsupported type %T", pub)
+	}
+
+	b := ecdhPub.Bytes()
+	switch curve := ecdhPub.Curve(); curve {
+	case ecdh.P256(), ecdh.P384():
+		crv := "P-256"
+		if curve == ecdh.P384() {
+			crv = "P-384"
+		}
+		// Uncompressed point encoding: 0x04 || X || Y.
+		n := (len(b) - 1) / 2
+		return &JWK{
+			Kty: "EC",
+			Crv: crv,
+			X:   base64.RawURLEncoding.EncodeToString(b[1 : 1+n]),
+			Y:   base64.RawURLEncoding.EncodeToString(b[1+n:]),
+		}, nil
+	case ecdh.X25519():
+		return &JWK{
+			Kty: "OKP",
+			Crv: "X25519",
+			X:   base64.RawURLEncoding.EncodeToString(b),
+		}, nil
+	default:
+		return nil, fmt.Errorf("public key has unsupported curve %v", curve)
+	}
+}
+
+// Formats a private key as a JWK, including its public part.
+func FormatPrivateKeyAsJWK(priv PrivateKey) (*JWK, error) {
+	jwk, err := FormatPublicKeyAsJWK(priv.Public())
+	if err != nil {
+		return nil, err
+	}
+	switch priv := priv.(type) {
+	case *ecdh.PrivateKey:
+		jwk.D = base64.RawURLEncoding.EncodeToString(priv.Bytes())
+	case ed25519.PrivateKey:
+		jwk.D = base64.RawURLEncoding.EncodeToString(priv.Seed())
+	default:
+		return nil, fmt.Errorf("private key is of unsupported type %T", priv)
+	}
+	return jwk, nil
+}
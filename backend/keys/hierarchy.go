@@ -0,0 +1,120 @@
+package keys
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"fmt"
+	"io"
+	"log"
+	"path"
+	"time"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+// How a PKI's root secrets are obtained.
+type SecretScheme string
+
+const (
+	// Each secret interval has its own randomly generated secret file.
+	SecretSchemePerInterval SecretScheme = "per-interval"
+	// Every secret is derived from a single master secret through an HKDF tree with a level for
+	// each of year, month, day, and hour. Only the master secret is stored.
+	SecretSchemeHierarchical SecretScheme = "hierarchical"
+)
+
+// Name of the master secret file of a PKI with hierarchical secrets.
+const masterSecretFile = "master_secret"
+
+// Prefix of the HKDF info of every level of the hierarchical derivation tree.
+const hierarchyInfo = "timecapsule secret "
+
+// Parses the name of a supported secret scheme.
+func parseSecretScheme(s string) (SecretScheme, error) {
+	switch scheme := SecretScheme(s); scheme {
+	case SecretSchemePerInterval, SecretSchemeHierarchical:
+		return scheme, nil
+	default:
+		return "", fmt.Errorf("unsupported secret scheme %q", s)
+	}
+}
+
+// Derives the secret of a node in the hierarchical derivation tree from its parent's secret.
+func deriveChildSecret(parent []byte, label string) ([]byte, error) {
+	child := make([]byte, secretSize)
+	// The parent secret is uniformly random, so it serves as a pseudorandom key without extraction.
+	if _, err := io.ReadFull(hkdf.Expand(sha256.New, parent, []byte(hierarchyInfo+label)), child); err != nil {
+		return nil, err
+	}
+	return child, nil
+}
+
+// Derives the root secret for the secret interval starting at the given time from a master secret.
+//
+// The derivation passes through the secrets of the bucket's year, month, and day, so the secret of
+// any node yields the secrets of every bucket beneath it.
+func deriveHierarchicalSecret(master []byte, bucket time.Time) ([]byte, error) {
+	bucket = bucket.UTC()
+	secret := master
+	for i, label := range []string{
+		fmt.Sprintf("year %04d", bucket.Year()),
+		fmt.Sprintf("month %02d", int(bucket.Month())),
+		fmt.Sprintf("day %02d", bucket.Day()),
+		fmt.Sprintf("hour %02d", bucket.Hour()),
+	} {
+		child, err := deriveChildSecret(secret, label)
+		if i > 0 {
+			// Intermediate secrets are only needed for the next level.
+			clear(secret)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to derive %s secret: %w", label, err)
+		}
+		secret = child
+	}
+	return secret, nil
+}
+
+// Loads the master secret from the secrets directory, creating it if it does not exist, or
+// reconstructs it from shares if any are given.
+func loadMasterSecret(dir string, shares []string) ([]byte, error) {
+	if len(shares) > 0 {
+		return reconstructMasterSecret(dir, shares)
+	}
+	file := path.Join(dir, masterSecretFile)
+	secret, ok, err := tryReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read master secret: %w", err)
+	}
+	if ok {
+		if len(secret) != secretSize {
+			return nil, fmt.Errorf("%w: master secret %s has %d bytes, want %d", ErrCorruptSecret, file, len(secret), secretSize)
+		}
+	} else {
+		log.Printf("Creating new master secret: %s", file)
+		secret = make([]byte, secretSize)
+		if _, err := io.ReadFull(rand.Reader, secret); err != nil {
+			return nil, fmt.Errorf("insufficient entropy: %w", err)
+		}
+		if err := createFileAtomic(file, secret, secretMode); err != nil {
+			return nil, fmt.Errorf("failed to write master secret %s: %w", file, err)
+		}
+	}
+
+	// The hash is recorded the first time the master secret is loaded, and checked every time
+	// after.
+	record := newFileSource(path.Join(dir, masterSecretHashFile))
+	recorded, ok, err := record.Get()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read master secret hash: %w", err)
+	}
+	if !ok {
+		if err := record.Set(masterSecretHash(secret)); err != nil {
+			return nil, fmt.Errorf("failed to record master secret hash: %w", err)
+		}
+	} else if subtle.ConstantTimeCompare([]byte(recorded), []byte(masterSecretHash(secret))) != 1 {
+		return nil, fmt.Errorf("%w: master secret %s doesn't match its recorded hash", ErrCorruptSecret, file)
+	}
+	return secret, nil
+}
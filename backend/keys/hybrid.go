@@ -0,0 +1,53 @@
+package keys
+
+import (
+	"crypto/ecdh"
+	"crypto/hpke"
+	"crypto/mlkem"
+	"fmt"
+)
+
+// HPKE info string used when sealing hybrid capsules.
+const hybridInfo = "timecapsule hybrid capsule"
+
+// Seals a message to both the classical and the ML-KEM-768 key of a time, so that opening it needs
+// both private keys. This is the capsule format of hybrid mode.
+//
+// The message is sealed with HPKE in base mode using the hybrid KEM of draft-ietf-hpke-pq that
+// combines ML-KEM-768 with the curve of the classical key: MLKEM768-X25519 for X25519 PKIs and
+// MLKEM768-P256 for P-256 PKIs. Other algorithms are not supported. Returns the encapsulated key and
+// the ciphertext, which must both be passed to OpenHybrid.
+func SealHybrid(classical *ecdh.PublicKey, pq *mlkem.EncapsulationKey768, msg []byte) (enc []byte, ciphertext []byte, err error) {
+	kdf, aead := wrapSuite()
+	pk, err := hpke.NewHybridPublicKey(pq, classical)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unsupported public key: %w", err)
+	}
+	enc, sender, err := hpke.NewSender(pk, kdf, aead, []byte(hybridInfo))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to set up HPKE sender: %w", err)
+	}
+	ciphertext, err = sender.Seal(nil, msg)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to seal message: %w", err)
+	}
+	return enc, ciphertext, nil
+}
+
+// Opens a capsule sealed by SealHybrid using the private keys of its time.
+func OpenHybrid(classical *ecdh.PrivateKey, pq *mlkem.DecapsulationKey768, enc []byte, ciphertext []byte) ([]byte, error) {
+	kdf, aead := wrapSuite()
+	sk, err := hpke.NewHybridPrivateKey(pq, classical)
+	if err != nil {
+		return nil, fmt.Errorf("unsupported private key: %w", err)
+	}
+	recipient, err := hpke.NewRecipient(enc, sk, kdf, aead, []byte(hybridInfo))
+	if err != nil {
+		return nil, fmt.Errorf("failed to set up HPKE recipient: %w", err)
+	}
+	msg, err := recipient.Open(nil, ciphertext)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open message: %w", err)
+	}
+	return msg, nil
+}
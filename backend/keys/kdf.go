@@ -0,0 +1,155 @@
+package keys
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math"
+	"path"
+	"strconv"
+	"time"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+// Versions of the key derivation function, which determines the HKDF info from which each key is
+// derived.
+//
+// Every version must remain supported forever, since a PKI's keys can only be reproduced under the
+// version that the PKI was created with. Any change to the derivation that produces different keys
+// must be made as a new version.
+const (
+	// The info is the time as 8-byte big-endian Unix seconds, followed by the key's label for keys
+	// other than the PKI's key of its configured algorithm.
+	kdfVersion1 = 1
+	// The info is the ASCII string "timecapsule kdf v2", a zero byte, the algorithm name, a zero
+	// byte, and then the version 1 info. Tagging the info with the algorithm ensures that PKIs of
+	// different algorithms never derive from the same HKDF output.
+	kdfVersion2 = 2
+)
+
+// Version of the key derivation function used by new PKIs.
+const derivationVersion = kdfVersion2
+
+// Name of the file recording the KDF version of a PKI.
+const kdfVersionFile = "kdf_version"
+
+// Prefix of the HKDF info in KDF version 2.
+const kdfV2Context = "timecapsule kdf v2\x00"
+
+// Names of the files recording a PKI's HKDF customization. Both are stored in hex, since the file
+// is trimmed of whitespace when read and the context string may begin or end with whitespace.
+const (
+	hkdfSaltFile    = "hkdf_salt"
+	hkdfContextFile = "hkdf_context"
+)
+
+// Prefix of the HKDF info of PKIs with an application context string.
+const hkdfContextPrefix = "timecapsule context\x00"
+
+// Parameters of a PKI's key derivation function.
+type kdfParams struct {
+	version int
+	alg     Algorithm
+	// HKDF salt, or nil for none.
+	salt []byte
+	// Application context string mixed into the HKDF info, or "" for none.
+	context string
+}
+
+// Parses a supported KDF version.
+func parseKDFVersion(s string) (int, error) {
+	version, err := strconv.Atoi(s)
+	if err != nil || version < kdfVersion1 || version > derivationVersion {
+		return 0, fmt.Errorf("unsupported KDF version %q", s)
+	}
+	return version, nil
+}
+
+// Returns the HKDF info of the key with the given label for a time. The PKI's key of its configured
+// algorithm has the empty label.
+//
+// If the PKI has an application context string, the info of its KDF version is preceded by the
+// ASCII string "timecapsule context", a zero byte, the 2-byte big-endian length of the context
+// string, and the context string.
+func kdfInfo(p kdfParams, t time.Time, label string) ([]byte, error) {
+	var info []byte
+	if p.context != "" {
+		info = append(info, hkdfContextPrefix...)
+		info = binary.BigEndian.AppendUint16(info, uint16(len(p.context)))
+		info = append(info, p.context...)
+	}
+	switch p.version {
+	case kdfVersion1:
+	case kdfVersion2:
+		info = append(info, kdfV2Context...)
+		info = append(info, p.alg...)
+		info = append(info, 0)
+	default:
+		return nil, fmt.Errorf("unsupported KDF version %d", p.version)
+	}
+	info = binary.BigEndian.AppendUint64(info, uint64(t.Unix()))
+	return append(info, label...), nil
+}
+
+// Returns the HKDF-SHA256 stream of the key with the given label for a time.
+func keyStream(p kdfParams, ikm []byte, t time.Time, label string) (io.Reader, error) {
+	info, err := kdfInfo(p, t, label)
+	if err != nil {
+		return nil, err
+	}
+	return hkdf.New(sha256.New, ikm, p.salt, info), nil
+}
+
+// Loads a PKI's HKDF customization from the secrets directory, recording the requested one if the
+// PKI is new.
+//
+// The salt and context string can't be changed once a PKI has keys, since that would change all of
+// them, so giving values that differ from the recorded ones is an error, as is giving values for an
+// existing PKI that has none.
+func loadHKDFCustomization(dir string, existing bool, salt []byte, context string) ([]byte, string, error) {
+	load := func(file string, requested string) (string, error) {
+		path := path.Join(dir, file)
+		recorded, ok, err := newFileSource(path).Get()
+		if err != nil {
+			return "", err
+		}
+		switch {
+		case ok && requested != "" && requested != recorded:
+			return "", fmt.Errorf("requested value differs from value at %s", path)
+		case ok:
+			return recorded, nil
+		case requested != "" && existing:
+			return "", fmt.Errorf("cannot add %s to an existing PKI, since it would change every key", file)
+		case requested != "":
+			return requested, newFileSource(path).Set(requested)
+		default:
+			return "", nil
+		}
+	}
+
+	if len(context) > math.MaxUint16 {
+		return nil, "", fmt.Errorf("HKDF context string is %d bytes long, at most %d are allowed", len(context), math.MaxUint16)
+	}
+	saltHex, err := load(hkdfSaltFile, hex.EncodeToString(salt))
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to determine HKDF salt: %w", err)
+	}
+	if salt, err = hex.DecodeString(saltHex); err != nil {
+		return nil, "", fmt.Errorf("invalid HKDF salt: %w", err)
+	}
+	if len(salt) == 0 {
+		salt = nil
+	}
+	contextHex, err := load(hkdfContextFile, hex.EncodeToString([]byte(context)))
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to determine HKDF context string: %w", err)
+	}
+	b, err := hex.DecodeString(contextHex)
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid HKDF context string: %w", err)
+	}
+	return salt, string(b), nil
+}
@@ -1,26 +1,128 @@
-// Package keys associates times to P-256 keypairs.
+// Package keys associates times to key pairs.
 package keys
 
 import (
-	"crypto/ecdh"
+	"crypto"
+	"crypto/ed25519"
+	"errors"
 	"fmt"
+	"strconv"
+	"sync"
 	"time"
 
 	"github.com/google/uuid"
 )
 
+// Returned when a time is outside of a PKI's time range.
+var ErrOutOfRange = errors.New("time out of range")
+
+// Returned when a requested change to a PKI's time range is invalid.
+var ErrInvalidRange = errors.New("invalid time range")
+
+// Private time key: an *ecdh.PrivateKey for the P-256, P-384, and X25519 algorithms, or an
+// ed25519.PrivateKey for the Ed25519 algorithm.
+type PrivateKey interface {
+	Public() crypto.PublicKey
+	Equal(crypto.PrivateKey) bool
+}
+
 type PKIOptions struct {
 	Name    string
 	ID      uuid.UUID
 	MinTime time.Time
 	MaxTime time.Time
+
+	// Key algorithm. If empty, the algorithm recorded in the secrets directory is used, or P-256
+	// for a new PKI.
+	Algorithm Algorithm
+
+	// Version of the key derivation function. If zero, the version recorded in the secrets
+	// directory is used, or the latest version for a new PKI. PKIs that predate recorded versions
+	// use version 1. A PKI's version can never change, since that would change all of its keys.
+	KDFVersion int
+
+	// Optional HKDF salt and application context string, which are mixed into the derivation of
+	// every key so that PKIs seeded from the same secrets don't share keys. These are recorded in
+	// the secrets directory, and can only be given when a PKI is created.
+	HKDFSalt    []byte
+	HKDFContext string
+
+	// How root secrets are obtained. If empty, the scheme recorded in the secrets directory is
+	// used, or per-interval secrets for a new PKI.
+	SecretScheme SecretScheme
+
+	// Shares of the master secret of a PKI with hierarchical secrets, from which the master secret
+	// is reconstructed instead of being read from the secrets directory. See SplitMasterSecret.
+	MasterSecretShares []string
+
+	// Arrangement of secret files in the secrets directory. If empty, the layout recorded in the
+	// secrets directory is used, or the flat layout for a new PKI. Giving a layout that differs
+	// from the recorded one moves the existing secret files into it.
+	SecretLayout SecretLayout
+
+	// If set, secrets are kept in this store rather than in files in the secrets directory. The
+	// PKI's configuration is still kept in the secrets directory.
+	SecretStore SecretStore
+
+	// Stores to which every secret is mirrored, so that losing the secret store doesn't lose the
+	// secrets. The stores are brought up to date with each other when the PKI is loaded, and lost
+	// secrets are restored from the replicas. Not supported with hierarchical secrets, whose only
+	// secret is the master secret.
+	Replicas []SecretStore
+
+	// If positive, each secret is deleted this long after its interval ends, after which the keys of
+	// the interval can never be derived again and data encrypted to them is permanently unreadable.
+	// Deletions are recorded in the secrets directory. See KeyManager.DeleteExpiredSecrets. Not
+	// supported with hierarchical secrets.
+	Retention time.Duration
+
+	// If set, secrets are encrypted before they are stored, each with its own data key encrypted by
+	// the KMS. Secrets stored before encryption was enabled remain readable.
+	KMS KMS
+
+	// Maximum number of derived keys kept in memory, so that repeated requests for the same time
+	// don't derive its keys again. If zero, a default size is used; if negative, keys are not
+	// cached.
+	KeyCacheSize int
+
+	// If true, missing secrets are generated in the background after NewKeyManager returns, rather
+	// than before. Until generation finishes, requests for secrets that have not been generated yet
+	// fail with ErrNotReady.
+	BackgroundGeneration bool
+
+	// If true, every secret is read and its checksum verified when the PKI is loaded. Otherwise,
+	// secrets whose creation metadata is recorded are assumed to exist, and are only verified when
+	// they are next read.
+	VerifySecrets bool
 }
 
-// KeyManager associates times to P-256 key pairs.
+// Parameters that determine how a time key is derived.
+type DerivationParams struct {
+	// Version of the key derivation scheme.
+	Version int
+	// Key algorithm.
+	Algorithm Algorithm
+	// Length of time covered by each root secret. Keys are derived per second, so each secret
+	// yields the keys for every second in its interval.
+	Interval time.Duration
+}
+
+// KeyManager associates times to key pairs.
 type KeyManager struct {
-	minTime time.Time
+	minTime     time.Time
+	secrets     *secretManager
+	events      *eventStore
+	revocations *revocationStore
+	signer      *signingKey
+	cache       *keyCache
+	precomputed *precomputedKeys
+	tlog        *transparencyLog
+	// How long secrets are kept after their interval ends, or zero to keep them forever.
+	retention time.Duration
+
+	// Guards maxTime, which may be extended at runtime.
+	mu      sync.RWMutex
 	maxTime time.Time
-	secrets *secretManager
 }
 
 // Constructs a new key manager using the given working directory for root
@@ -30,10 +132,33 @@ func NewKeyManager(options PKIOptions, secretsDir string) (*KeyManager, error) {
 	if err != nil {
 		return nil, err
 	}
+	events, err := loadEventStore(secretsDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load named events: %w", err)
+	}
+	revocations, err := loadRevocationStore(secretsDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load revocations: %w", err)
+	}
+	signer, err := loadSigningKey(secretsDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load signing key: %w", err)
+	}
+	tlog, err := loadTransparencyLog(secretsDir, secrets.PKIID())
+	if err != nil {
+		return nil, fmt.Errorf("failed to load transparency log: %w", err)
+	}
 	return &KeyManager{
-		minTime: options.MinTime,
-		maxTime: options.MaxTime,
-		secrets: secrets,
+		minTime:     options.MinTime,
+		maxTime:     options.MaxTime,
+		secrets:     secrets,
+		events:      events,
+		revocations: revocations,
+		signer:      signer,
+		cache:       newKeyCache(options.KeyCacheSize),
+		precomputed: newPrecomputedKeys(),
+		tlog:        tlog,
+		retention:   options.Retention,
 	}, nil
 }
 
@@ -47,18 +172,179 @@ func (m *KeyManager) PKIID() uuid.UUID {
 	return m.secrets.PKIID()
 }
 
-// Returns the P-256 key pair for the given time.
+// The parameters used to derive keys in this key manager.
+func (m *KeyManager) DerivationParams() DerivationParams {
+	return DerivationParams{
+		Version:   m.secrets.KDFVersion(),
+		Algorithm: m.secrets.Algorithm(),
+		Interval:  secretInterval,
+	}
+}
+
+// How root secrets are obtained in this key manager.
+func (m *KeyManager) SecretScheme() SecretScheme {
+	return m.secrets.SecretScheme()
+}
+
+// The earliest time supported by this key manager.
+func (m *KeyManager) MinTime() time.Time {
+	return m.minTime
+}
+
+// The latest time supported by this key manager.
+func (m *KeyManager) MaxTime() time.Time {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.maxTime
+}
+
+// Extends the PKI's time range to end at newMax, creating any secrets needed to cover the new
+// range. newMax must be after the current end of the range.
 //
-// Times are normalized to UTC time internally, so different time.Time values that refer to the
-// same absolute time are guaranteed to correspond to the same key.
-func (m *KeyManager) GetKeyForTime(t time.Time) (*ecdh.PrivateKey, error) {
+// Secrets are created before the new range takes effect, so no time is ever in range without a
+// secret.
+func (m *KeyManager) ExtendRange(newMax time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !newMax.After(m.maxTime) {
+		return fmt.Errorf("%w: new maximum time %s is not after current maximum time %s", ErrInvalidRange, newMax.Format(time.RFC3339), m.maxTime.Format(time.RFC3339))
+	}
+	if err := m.secrets.ensureSecrets(m.maxTime, newMax); err != nil {
+		return err
+	}
+	m.maxTime = newMax
+	return nil
+}
+
+// Checks that the given time is within the PKI's time range. Both endpoints are inclusive.
+//
+// This is the single source of truth for which times have keys. Errors wrap ErrOutOfRange.
+func (m *KeyManager) CheckTime(t time.Time) error {
+	maxTime := m.MaxTime()
+	if t.Compare(m.minTime) < 0 || t.Compare(maxTime) > 0 {
+		return fmt.Errorf("%w: must be between %s and %s", ErrOutOfRange, m.minTime.Format(time.RFC3339), maxTime.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// Checks that keys exist for the given time: that it is within the PKI's time range, that it has not
+// been revoked, and that its secret has not been deleted. Errors wrap ErrOutOfRange, ErrRevoked, or
+// ErrSecretDeleted.
+//
+// This is checked before cached keys are returned, so that no key outlives its secret.
+func (m *KeyManager) checkKeyTime(t time.Time) error {
+	if err := m.CheckTime(t); err != nil {
+		return err
+	}
+	if err := m.revocations.Check(t); err != nil {
+		return err
+	}
+	return m.secrets.checkNotDeleted(t)
+}
+
+// Whether all secrets in the PKI's time range have been generated.
+func (m *KeyManager) Ready() bool {
+	return m.secrets.Ready()
+}
+
+// Registers a named event at the given time, which must be within the PKI's time range.
+//
+// Events are persisted in the secrets directory. An event cannot be moved to a different time once
+// registered.
+func (m *KeyManager) RegisterEvent(name string, t time.Time) error {
+	if err := m.CheckTime(t); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidEvent, err)
+	}
+	return m.events.Register(name, t)
+}
+
+// Returns the time of the named event, if it is registered.
+func (m *KeyManager) EventTime(name string) (time.Time, bool) {
+	return m.events.Get(name)
+}
+
+// Returns the secret from which the keys for the given time are derived. The caller zeroes the
+// secret once it is done with it.
+func (m *KeyManager) secretForTime(t time.Time) ([]byte, error) {
+	if err := m.CheckTime(t); err != nil {
+		return nil, err
+	}
 	secret, err := m.secrets.GetSecretForTime(t)
 	if err != nil {
-		return nil, fmt.Errorf("failed to determine secret for %s: %+v", t.Format(time.RFC3339), err)
+		return nil, fmt.Errorf("failed to determine secret for %s: %w", t.Format(time.RFC3339), err)
 	}
-	key, err := deriveKeyForTime(secret, t)
-	if err != nil {
-		return nil, fmt.Errorf("failed to derive keypair for %s: %+v", t.Format(time.RFC3339), err)
+	return secret, nil
+}
+
+// Returns the key pair for the given time.
+//
+// Times are normalized to UTC time internally, so different time.Time values that refer to the
+// same absolute time are guaranteed to correspond to the same key.
+func (m *KeyManager) GetKeyForTime(t time.Time) (PrivateKey, error) {
+	return m.GetKeyForTimeWithVersion(t, m.secrets.KDFVersion())
+}
+
+// Returns the key pair for the given time as derived under the given KDF version, which may differ
+// from the PKI's own.
+//
+// This allows keys to be reproduced under an old version while a PKI's users migrate to one created
+// under a newer version.
+func (m *KeyManager) GetKeyForTimeWithVersion(t time.Time, version int) (PrivateKey, error) {
+	if _, err := parseKDFVersion(strconv.Itoa(version)); err != nil {
+		return nil, err
+	}
+	if err := m.checkKeyTime(t); err != nil {
+		return nil, err
+	}
+	return cached(m.cache, "", version, t, func() (PrivateKey, error) {
+		secret, err := m.secretForTime(t)
+		if err != nil {
+			return nil, err
+		}
+		defer clear(secret)
+		p := m.secrets.kdfParams()
+		p.version = version
+		key, err := deriveKeyForTime(p, secret, t)
+		if err != nil {
+			return nil, fmt.Errorf("failed to derive keypair for %s: %+v", t.Format(time.RFC3339), err)
+		}
+		return key, nil
+	})
+}
+
+// Counters describing the effectiveness of the PKI's key cache.
+func (m *KeyManager) CacheStats() CacheStats {
+	return m.cache.Stats()
+}
+
+// The public half of the PKI's long-term Ed25519 signing key.
+func (m *KeyManager) SigningPublicKey() ed25519.PublicKey {
+	return m.signer.priv.Public().(ed25519.PublicKey)
+}
+
+// Fingerprint of the PKI's signing key, the root of trust of its public keys. See Fingerprint.
+func (m *KeyManager) SigningKeyFingerprint() string {
+	return m.signer.fingerprint
+}
+
+// Identifies the PKI's signing key. Signatures carry this ID so that clients can tell which
+// signing key to verify them against.
+func (m *KeyManager) SigningKeyID() string {
+	return m.signer.id
+}
+
+// Signs the DER-encoded SubjectPublicKeyInfo of the public key for the given time with the PKI's
+// signing key. See PublicKeySignatureMessage for the signed message.
+func (m *KeyManager) SignPublicKey(t time.Time, spki []byte) []byte {
+	if sig, ok := m.precomputedSignature(t, spki); ok {
+		return sig
 	}
-	return key, nil
+	return ed25519.Sign(m.signer.priv, PublicKeySignatureMessage(m.PKIID(), t, spki))
+}
+
+// Signs an attestation that the private key for the target time was released at the given time,
+// as determined by the secure clock. See ReleaseAttestationMessage for the signed message.
+func (m *KeyManager) AttestRelease(target time.Time, released time.Time) []byte {
+	return ed25519.Sign(m.signer.priv, ReleaseAttestationMessage(m.PKIID(), target, released))
 }
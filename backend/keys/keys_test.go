@@ -1,12 +1,25 @@
 package keys_test
 
 import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
 	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/newgrp/timecapsule/keys"
+	"golang.org/x/crypto/ssh"
 )
 
 func TestDeterminism(t *testing.T) {
@@ -37,15 +50,12 @@ func TestDeterminism(t *testing.T) {
 	}
 }
 
-func TestStability(t *testing.T) {
+// Checks that the key derived for a fixed time from the test PKI in testdata is the given key. The
+// test PKI predates recorded KDF versions, so a version of zero selects version 1.
+func testStability(t *testing.T, alg keys.Algorithm, kdfVersion int, pubPem string) {
 	const (
 		uuidStr = "aa625eb2-d75d-4a64-8f5c-22cd4a06db22"
-
 		timeStr = "2024-09-01T16:29:33-07:00"
-		pubPem  = `-----BEGIN PUBLIC KEY-----
-MFkwEwYHKoZIzj0CAQYIKoZIzj0DAQcDQgAExVW5oMPcttINe6ZtyfHJ7p1SQOrX
-zBkII7T3C0onq4q6kpqYgi3I1UT7bTVJLYscqgQTD5oTHYhw5M87B1az2g==
------END PUBLIC KEY-----`
 	)
 
 	pkiID, err := uuid.Parse(uuidStr)
@@ -56,7 +66,11 @@ zBkII7T3C0onq4q6kpqYgi3I1UT7bTVJLYscqgQTD5oTHYhw5M87B1az2g==
 	if err != nil {
 		t.Fatalf("Test time is improperly formatted: %+v", err)
 	}
-	wantKey, err := keys.ParseECDHPublicKeyAsSPKIPEM(pubPem)
+	block, _ := pem.Decode([]byte(pubPem))
+	if block == nil {
+		t.Fatalf("Test key is not PEM-encoded")
+	}
+	wantKey, err := keys.ParsePublicKeyAsSPKIDER(block.Bytes)
 	if err != nil {
 		t.Fatalf("Test key is improperly formatted: %+v", err)
 	}
@@ -68,8 +82,10 @@ zBkII7T3C0onq4q6kpqYgi3I1UT7bTVJLYscqgQTD5oTHYhw5M87B1az2g==
 	ks, err := keys.NewKeyManager(
 		keys.PKIOptions{
 			Name: "Stability Test", ID: pkiID,
-			MinTime: tm.Add(-time.Hour),
-			MaxTime: tm.Add(time.Hour),
+			MinTime:    tm.Add(-time.Hour),
+			MaxTime:    tm.Add(time.Hour),
+			Algorithm:  alg,
+			KDFVersion: kdfVersion,
 		},
 		dir,
 	)
@@ -86,10 +102,903 @@ zBkII7T3C0onq4q6kpqYgi3I1UT7bTVJLYscqgQTD5oTHYhw5M87B1az2g==
 		t.Fatalf("Failed to format derived key as PEM SubjectPublicKeyInfo: %+v", err)
 	}
 
-	if !k.PublicKey().Equal(wantKey) {
+	if !k.Public().(interface{ Equal(crypto.PublicKey) bool }).Equal(wantKey) {
 		t.Errorf(`Key generation has changed: got
 %v
 want
 %v`, gotPem, pubPem)
 	}
 }
+
+func TestStability(t *testing.T) {
+	testStability(t, keys.AlgorithmP256, 0, `-----BEGIN PUBLIC KEY-----
+MFkwEwYHKoZIzj0CAQYIKoZIzj0DAQcDQgAExVW5oMPcttINe6ZtyfHJ7p1SQOrX
+zBkII7T3C0onq4q6kpqYgi3I1UT7bTVJLYscqgQTD5oTHYhw5M87B1az2g==
+-----END PUBLIC KEY-----`)
+}
+
+func TestStabilityX25519(t *testing.T) {
+	testStability(t, keys.AlgorithmX25519, 0, `-----BEGIN PUBLIC KEY-----
+MCowBQYDK2VuAyEAXDwuHi08nLMEw7+CoRtkNb1dob+6QAXZ2YxtScY1Oj4=
+-----END PUBLIC KEY-----`)
+}
+
+func TestStabilityP384(t *testing.T) {
+	testStability(t, keys.AlgorithmP384, 0, `-----BEGIN PUBLIC KEY-----
+MHYwEAYHKoZIzj0CAQYFK4EEACIDYgAE/pY8ctX3m1wiTeWOtQ691wURZS444e84
+l3s1AhYfu0QAvnbPbIgPIzmJsLp/lM65UVgMMw/Mx6dfjDlJ3CGpapJlF9pq/aEN
+H9nlgWaZe0k4gRuJPHAt0pA5xXEsCJsE
+-----END PUBLIC KEY-----`)
+}
+
+func TestStabilityEd25519(t *testing.T) {
+	testStability(t, keys.AlgorithmEd25519, 0, `-----BEGIN PUBLIC KEY-----
+MCowBQYDK2VwAyEAS28MJOSFm9wZW8ALzxIIQO2xZ4kDf1r4r27ArMgVqVg=
+-----END PUBLIC KEY-----`)
+}
+
+func TestStabilityKDFVersion2(t *testing.T) {
+	testStability(t, keys.AlgorithmP256, 2, `-----BEGIN PUBLIC KEY-----
+MFkwEwYHKoZIzj0CAQYIKoZIzj0DAQcDQgAE+tJe/9s/e1RHPQJBMPvZKxkykEME
+UNzACgRLedS0Ms0X2fLjmkY0zLUA14wcLXPyFVhFbQ1XE0OgsYofMtUDeQ==
+-----END PUBLIC KEY-----`)
+}
+
+func TestKDFVersions(t *testing.T) {
+	dir := t.TempDir()
+	opts := keys.PKIOptions{
+		Name:    "KDF Version Test",
+		MinTime: time.Now().Add(-2 * time.Hour),
+		MaxTime: time.Now().Add(2 * time.Hour),
+	}
+	ks, err := keys.NewKeyManager(opts, dir)
+	if err != nil {
+		t.Fatalf("Failed to initialize key manager: %+v", err)
+	}
+	if got := ks.DerivationParams().Version; got != 2 {
+		t.Errorf("New PKI has KDF version %d, want 2", got)
+	}
+
+	// A PKI's KDF version can't be changed once recorded.
+	opts.KDFVersion = 1
+	if _, err := keys.NewKeyManager(opts, dir); err == nil {
+		t.Errorf("Reopened PKI with a different KDF version")
+	}
+
+	// Keys can still be derived under old versions.
+	now := time.Now()
+	k2, err := ks.GetKeyForTime(now)
+	if err != nil {
+		t.Fatalf("Failed to get key: %+v", err)
+	}
+	k1, err := ks.GetKeyForTimeWithVersion(now, 1)
+	if err != nil {
+		t.Fatalf("Failed to get key under KDF version 1: %+v", err)
+	}
+	if k1.Equal(k2) {
+		t.Errorf("Keys under KDF versions 1 and 2 are the same")
+	}
+	if _, err := ks.GetKeyForTimeWithVersion(now, 3); err == nil {
+		t.Errorf("Derived a key under an unsupported KDF version")
+	}
+}
+
+func TestHKDFCustomization(t *testing.T) {
+	tm := time.Now()
+	opts := keys.PKIOptions{
+		Name:        "Customization Test",
+		MinTime:     tm.Add(-time.Hour),
+		MaxTime:     tm.Add(time.Hour),
+		HKDFSalt:    []byte("salt"),
+		HKDFContext: "first",
+	}
+	dir := t.TempDir()
+	first, err := keys.NewKeyManager(opts, dir)
+	if err != nil {
+		t.Fatalf("Failed to initialize key manager: %+v", err)
+	}
+
+	opts.HKDFContext = "second"
+	if _, err := keys.NewKeyManager(opts, dir); err == nil {
+		t.Errorf("Reopened PKI with a different HKDF context string")
+	}
+
+	// Restore the first PKI's secrets into a new PKI with a different context string.
+	restored := t.TempDir()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("Failed to list secrets: %+v", err)
+	}
+	for _, e := range entries {
+		name := e.Name()
+		if _, err := time.Parse("2006-01-02@15.04.05", name); err != nil {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatalf("Failed to read secret: %+v", err)
+		}
+		if err := os.WriteFile(filepath.Join(restored, name), data, 0o400); err != nil {
+			t.Fatalf("Failed to restore secret: %+v", err)
+		}
+	}
+	second, err := keys.NewKeyManager(opts, restored)
+	if err != nil {
+		t.Fatalf("Failed to initialize key manager: %+v", err)
+	}
+
+	k1, err := first.GetKeyForTime(tm)
+	if err != nil {
+		t.Fatalf("Failed to get key: %+v", err)
+	}
+	k2, err := second.GetKeyForTime(tm)
+	if err != nil {
+		t.Fatalf("Failed to get key: %+v", err)
+	}
+	if k1.Equal(k2) {
+		t.Errorf("PKIs with different HKDF context strings derived the same key from the same secret")
+	}
+
+	// Customization can't be added to an existing PKI.
+	plain := t.TempDir()
+	opts.HKDFSalt, opts.HKDFContext = nil, ""
+	if _, err := keys.NewKeyManager(opts, plain); err != nil {
+		t.Fatalf("Failed to initialize key manager: %+v", err)
+	}
+	opts.HKDFContext = "late"
+	if _, err := keys.NewKeyManager(opts, plain); err == nil {
+		t.Errorf("Added an HKDF context string to an existing PKI")
+	}
+}
+
+func TestHKDFContextWhitespace(t *testing.T) {
+	tm := time.Now()
+	opts := keys.PKIOptions{
+		Name:        "Whitespace Context Test",
+		MinTime:     tm.Add(-time.Hour),
+		MaxTime:     tm.Add(time.Hour),
+		HKDFContext: " padded context\n",
+	}
+	dir := t.TempDir()
+	first, err := keys.NewKeyManager(opts, dir)
+	if err != nil {
+		t.Fatalf("Failed to initialize key manager: %+v", err)
+	}
+	reopened, err := keys.NewKeyManager(opts, dir)
+	if err != nil {
+		t.Fatalf("Failed to reopen PKI with a whitespace-padded HKDF context string: %+v", err)
+	}
+
+	k1, err := first.GetKeyForTime(tm)
+	if err != nil {
+		t.Fatalf("Failed to get key: %+v", err)
+	}
+	k2, err := reopened.GetKeyForTime(tm)
+	if err != nil {
+		t.Fatalf("Failed to get key: %+v", err)
+	}
+	if !k1.Equal(k2) {
+		t.Errorf("Reopened PKI derived a different key than the original")
+	}
+
+	opts.HKDFContext = "padded context"
+	if _, err := keys.NewKeyManager(opts, dir); err == nil {
+		t.Errorf("Reopened PKI with its HKDF context string trimmed of whitespace")
+	}
+}
+
+func TestStabilityMLKEM(t *testing.T) {
+	// SHA-256 hash of the encapsulation key, which is too long to embed.
+	const wantHash = "fd614119f4d4ab10801d65f608adf8232f6732ae234f90b1a43cca2709a2985d"
+
+	pkiID := uuid.MustParse("aa625eb2-d75d-4a64-8f5c-22cd4a06db22")
+	tm, err := time.Parse(time.RFC3339, "2024-09-01T16:29:33-07:00")
+	if err != nil {
+		t.Fatalf("Test time is improperly formatted: %+v", err)
+	}
+	dir := t.TempDir()
+	if err := os.CopyFS(dir, os.DirFS("./testdata")); err != nil {
+		t.Fatalf("Failed to copy test PKI: %+v", err)
+	}
+	ks, err := keys.NewKeyManager(
+		keys.PKIOptions{
+			Name: "Stability Test", ID: pkiID,
+			MinTime: tm.Add(-time.Hour),
+			MaxTime: tm.Add(time.Hour),
+		},
+		dir,
+	)
+	if err != nil {
+		t.Fatalf("Failed to initialize key manager: %+v", err)
+	}
+
+	k, err := ks.GetMLKEMKeyForTime(tm)
+	if err != nil {
+		t.Fatalf("Failed to get ML-KEM key for test time: %+v", err)
+	}
+	sum := sha256.Sum256(k.EncapsulationKey().Bytes())
+	if got := hex.EncodeToString(sum[:]); got != wantHash {
+		t.Errorf("ML-KEM key generation has changed: got encapsulation key hash %s, want %s", got, wantHash)
+	}
+}
+
+func TestKeyCache(t *testing.T) {
+	now := time.Now()
+	ks, err := keys.NewKeyManager(
+		keys.PKIOptions{
+			Name:         "Cache Test",
+			MinTime:      now.Add(-2 * time.Hour),
+			MaxTime:      now.Add(2 * time.Hour),
+			KeyCacheSize: 2,
+		},
+		t.TempDir(),
+	)
+	if err != nil {
+		t.Fatalf("Failed to initialize key manager: %+v", err)
+	}
+
+	// Concurrent requests for the same key derive it once.
+	var wg sync.WaitGroup
+	got := make([]keys.PrivateKey, 8)
+	for i := range got {
+		wg.Go(func() {
+			k, err := ks.GetKeyForTime(now)
+			if err != nil {
+				t.Errorf("Failed to get key: %+v", err)
+			}
+			got[i] = k
+		})
+	}
+	wg.Wait()
+	for _, k := range got[1:] {
+		if !k.Equal(got[0]) {
+			t.Errorf("Concurrent requests for the same time returned different keys")
+		}
+	}
+	if stats := ks.CacheStats(); stats.Misses != 1 || stats.Hits+stats.Shared != 7 {
+		t.Errorf("Got cache stats %+v, want 1 miss and 7 hits or shared derivations", stats)
+	}
+
+	for i := 1; i <= 2; i++ {
+		if _, err := ks.GetKeyForTime(now.Add(time.Duration(i) * time.Second)); err != nil {
+			t.Fatalf("Failed to get key: %+v", err)
+		}
+	}
+	if stats := ks.CacheStats(); stats.Evictions != 1 || stats.Size != 2 {
+		t.Errorf("Got cache stats %+v, want 1 eviction and size 2", stats)
+	}
+
+	// Errors are not cached.
+	if _, err := ks.GetKeyForTime(now.Add(3 * time.Hour)); err == nil {
+		t.Errorf("Got key for time out of range")
+	}
+	if stats := ks.CacheStats(); stats.Size != 2 {
+		t.Errorf("Got cache size %d after failed request, want 2", stats.Size)
+	}
+}
+
+func TestEventsPersist(t *testing.T) {
+	dir := t.TempDir()
+	opts := keys.PKIOptions{
+		Name:    "Events Test",
+		MinTime: time.Now().Add(-2 * time.Hour),
+		MaxTime: time.Now().Add(2 * time.Hour),
+	}
+	want := time.Now().Truncate(time.Second)
+
+	ks, err := keys.NewKeyManager(opts, dir)
+	if err != nil {
+		t.Fatalf("Failed to initialize key manager: %+v", err)
+	}
+	if err := ks.RegisterEvent("launch", want); err != nil {
+		t.Fatalf("Failed to register event: %+v", err)
+	}
+	if err := ks.RegisterEvent("launch", want.Add(time.Hour)); err == nil {
+		t.Errorf("Event was moved to a different time")
+	}
+
+	ks, err = keys.NewKeyManager(opts, dir)
+	if err != nil {
+		t.Fatalf("Failed to reinitialize key manager: %+v", err)
+	}
+	got, ok := ks.EventTime("launch")
+	if !ok {
+		t.Fatalf("Event was not persisted")
+	}
+	if !got.Equal(want) {
+		t.Errorf("Persisted event has wrong time: got %s, want %s", got.Format(time.RFC3339), want.Format(time.RFC3339))
+	}
+}
+
+func TestSigningKeyPersists(t *testing.T) {
+	dir := t.TempDir()
+	opts := keys.PKIOptions{
+		Name:    "Signing Test",
+		MinTime: time.Now().Add(-2 * time.Hour),
+		MaxTime: time.Now().Add(2 * time.Hour),
+	}
+	now := time.Now()
+	spki := []byte("not really a public key")
+
+	ks, err := keys.NewKeyManager(opts, dir)
+	if err != nil {
+		t.Fatalf("Failed to initialize key manager: %+v", err)
+	}
+	sig := ks.SignPublicKey(now, spki)
+
+	ks, err = keys.NewKeyManager(opts, dir)
+	if err != nil {
+		t.Fatalf("Failed to reinitialize key manager: %+v", err)
+	}
+	if !keys.VerifyPublicKeySignature(ks.SigningPublicKey(), ks.PKIID(), now, spki, sig) {
+		t.Errorf("Signature does not verify after reloading signing key")
+	}
+	if keys.VerifyPublicKeySignature(ks.SigningPublicKey(), ks.PKIID(), now.Add(time.Second), spki, sig) {
+		t.Errorf("Signature verifies for a different time")
+	}
+}
+
+func TestRetention(t *testing.T) {
+	minTime := time.Date(2024, time.September, 1, 0, 0, 0, 0, time.UTC)
+	opts := keys.PKIOptions{
+		Name:      "Retention Test",
+		MinTime:   minTime,
+		MaxTime:   minTime.Add(4 * time.Hour),
+		Retention: time.Hour,
+	}
+	dir := t.TempDir()
+	ks, err := keys.NewKeyManager(opts, dir)
+	if err != nil {
+		t.Fatalf("Failed to initialize key manager: %+v", err)
+	}
+	// Cached keys must not outlive their secrets.
+	if _, err := ks.GetKeyForTime(minTime); err != nil {
+		t.Fatalf("Failed to get key: %+v", err)
+	}
+
+	// Secrets of intervals that ended at least an hour ago are deleted.
+	now := minTime.Add(3*time.Hour + 30*time.Minute)
+	n, err := ks.DeleteExpiredSecrets(now)
+	if err != nil {
+		t.Fatalf("Failed to delete expired secrets: %+v", err)
+	}
+	if n != 2 {
+		t.Errorf("Deleted %d secrets, want 2", n)
+	}
+	if n, err := ks.DeleteExpiredSecrets(now); err != nil || n != 0 {
+		t.Errorf("Deleting expired secrets again deleted %d secrets with error %v, want none", n, err)
+	}
+
+	for _, ks := range []*keys.KeyManager{ks, mustReload(t, opts, dir)} {
+		for _, tm := range []time.Time{minTime, minTime.Add(time.Hour + 59*time.Minute)} {
+			if _, err := ks.GetKeyForTime(tm); !errors.Is(err, keys.ErrSecretDeleted) {
+				t.Errorf("Got wrong error for key at %v: got %v, want %v", tm, err, keys.ErrSecretDeleted)
+			}
+		}
+		if _, err := ks.GetKeyForTime(minTime.Add(2 * time.Hour)); err != nil {
+			t.Errorf("Failed to get key that wasn't deleted: %+v", err)
+		}
+	}
+	for _, name := range []string{"2024-09-01@00.00.00", "2024-09-01@01.00.00"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("Secret file %s still exists: %v", name, err)
+		}
+	}
+	log, err := os.ReadFile(filepath.Join(dir, "secret_deletions.log"))
+	if err != nil {
+		t.Fatalf("Failed to read deletion records: %+v", err)
+	}
+	if lines := strings.Count(string(log), "\n"); lines != 2 {
+		t.Errorf("Recorded %d deletions, want 2", lines)
+	}
+}
+
+func mustReload(t *testing.T, opts keys.PKIOptions, dir string) *keys.KeyManager {
+	t.Helper()
+	ks, err := keys.NewKeyManager(opts, dir)
+	if err != nil {
+		t.Fatalf("Failed to reload key manager: %+v", err)
+	}
+	return ks
+}
+
+func TestRevocation(t *testing.T) {
+	minTime := time.Date(2024, time.September, 1, 0, 0, 0, 0, time.UTC)
+	opts := keys.PKIOptions{Name: "Revocation Test", MinTime: minTime, MaxTime: minTime.Add(4 * time.Hour)}
+	dir := t.TempDir()
+	ks, err := keys.NewKeyManager(opts, dir)
+	if err != nil {
+		t.Fatalf("Failed to initialize key manager: %+v", err)
+	}
+	if _, err := ks.GetKeyForTime(minTime.Add(time.Hour)); err != nil {
+		t.Fatalf("Failed to get key: %+v", err)
+	}
+
+	// Revocations cover whole intervals.
+	r, err := ks.Revoke(minTime.Add(90*time.Minute), minTime.Add(150*time.Minute), "leaked")
+	if err != nil {
+		t.Fatalf("Failed to revoke keys: %+v", err)
+	}
+	if !r.Start.Equal(minTime.Add(time.Hour)) || !r.End.Equal(minTime.Add(3*time.Hour)) {
+		t.Errorf("Revoked [%v, %v), want [%v, %v)", r.Start, r.End, minTime.Add(time.Hour), minTime.Add(3*time.Hour))
+	}
+
+	for _, ks := range []*keys.KeyManager{ks, mustReload(t, opts, dir)} {
+		for _, tm := range []time.Time{minTime.Add(time.Hour), minTime.Add(3*time.Hour - time.Second)} {
+			if _, err := ks.GetKeyForTime(tm); !errors.Is(err, keys.ErrRevoked) {
+				t.Errorf("Got wrong error for key at %v: got %v, want %v", tm, err, keys.ErrRevoked)
+			}
+			if _, err := ks.GetMLKEMKeyForTime(tm); !errors.Is(err, keys.ErrRevoked) {
+				t.Errorf("Got wrong error for ML-KEM key at %v: got %v, want %v", tm, err, keys.ErrRevoked)
+			}
+		}
+		for _, tm := range []time.Time{minTime, minTime.Add(3 * time.Hour)} {
+			if _, err := ks.GetKeyForTime(tm); err != nil {
+				t.Errorf("Failed to get key at %v outside of revoked range: %+v", tm, err)
+			}
+		}
+		if got := ks.Revocations(); len(got) != 1 || got[0].Reason != "leaked" {
+			t.Errorf("Got revocations %+v, want one with reason %q", got, "leaked")
+		}
+	}
+
+	if _, err := ks.Revoke(minTime.Add(time.Hour), minTime, ""); !errors.Is(err, keys.ErrInvalidRevocation) {
+		t.Errorf("Got wrong error for revoking an empty range: got %v, want %v", err, keys.ErrInvalidRevocation)
+	}
+	if _, err := ks.Revoke(minTime, minTime.Add(24*time.Hour), ""); !errors.Is(err, keys.ErrInvalidRevocation) {
+		t.Errorf("Got wrong error for revoking past the end of the PKI: got %v, want %v", err, keys.ErrInvalidRevocation)
+	}
+}
+
+// Secret store that fails every request while it is down.
+type flakyStore struct {
+	keys.SecretStore
+	down bool
+}
+
+var errStoreDown = errors.New("secret store is down")
+
+func (s *flakyStore) Get(bucket time.Time) ([]byte, bool, error) {
+	if s.down {
+		return nil, false, errStoreDown
+	}
+	return s.SecretStore.Get(bucket)
+}
+
+func TestPrecomputePublicKeys(t *testing.T) {
+	minTime := time.Date(2024, time.September, 1, 0, 0, 0, 0, time.UTC)
+	inner, err := keys.NewDirStore(t.TempDir(), keys.SecretLayoutFlat)
+	if err != nil {
+		t.Fatalf("Failed to create secret store: %+v", err)
+	}
+	store := &flakyStore{SecretStore: inner}
+	ks, err := keys.NewKeyManager(keys.PKIOptions{
+		Name:    "Precompute Test",
+		MinTime: minTime,
+		MaxTime: minTime.Add(4 * time.Hour),
+		// Disable the key cache, so that only precomputed keys are served without the store.
+		KeyCacheSize: -1,
+		SecretStore:  store,
+	}, t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to initialize key manager: %+v", err)
+	}
+
+	n, err := ks.PrecomputePublicKeys(minTime.Add(30*time.Minute), 3)
+	if err != nil {
+		t.Fatalf("Failed to precompute public keys: %+v", err)
+	}
+	if n != 3 {
+		t.Errorf("Precomputed %d public keys, want 3", n)
+	}
+
+	store.down = true
+	for i := range 3 {
+		tm := minTime.Add(time.Duration(i) * time.Hour)
+		k, ok := ks.PrecomputedPublicKey(tm)
+		if !ok {
+			t.Errorf("No precomputed public key for %v", tm)
+			continue
+		}
+		if !ed25519.Verify(ks.SigningPublicKey(), keys.PublicKeySignatureMessage(ks.PKIID(), tm, k.SPKI), k.Signature) {
+			t.Errorf("Precomputed public key for %v has an invalid signature", tm)
+		}
+		if !bytes.Equal(ks.SignPublicKey(tm, k.SPKI), k.Signature) {
+			t.Errorf("SignPublicKey didn't return the precomputed signature for %v", tm)
+		}
+	}
+	if _, ok := ks.PrecomputedPublicKey(minTime.Add(3 * time.Hour)); ok {
+		t.Errorf("Got precomputed public key past the requested intervals")
+	}
+	if _, err := ks.GetKeyForTime(minTime.Add(3 * time.Hour)); !errors.Is(err, errStoreDown) {
+		t.Errorf("Got wrong error for key that wasn't precomputed: got %v, want %v", err, errStoreDown)
+	}
+	store.down = false
+
+	priv, err := ks.GetKeyForTime(minTime.Add(time.Hour))
+	if err != nil {
+		t.Fatalf("Failed to get key: %+v", err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(priv.Public())
+	if err != nil {
+		t.Fatalf("Failed to marshal public key: %+v", err)
+	}
+	if k, _ := ks.PrecomputedPublicKey(minTime.Add(time.Hour)); !bytes.Equal(k.SPKI, der) {
+		t.Errorf("Precomputed public key differs from the derived one")
+	}
+
+	// Moving forward drops past keys and only derives the new ones.
+	n, err = ks.PrecomputePublicKeys(minTime.Add(time.Hour), 3)
+	if err != nil {
+		t.Fatalf("Failed to precompute public keys: %+v", err)
+	}
+	if n != 1 {
+		t.Errorf("Precomputed %d new public keys, want 1", n)
+	}
+	if _, ok := ks.PrecomputedPublicKey(minTime); ok {
+		t.Errorf("Past precomputed public key was not dropped")
+	}
+
+	// Revoked keys are no longer served.
+	if _, err := ks.Revoke(minTime.Add(2*time.Hour), minTime.Add(2*time.Hour), ""); err != nil {
+		t.Fatalf("Failed to revoke keys: %+v", err)
+	}
+	if _, ok := ks.PrecomputedPublicKey(minTime.Add(2 * time.Hour)); ok {
+		t.Errorf("Got precomputed public key for revoked time")
+	}
+}
+
+func TestTransparencyLog(t *testing.T) {
+	minTime := time.Date(2024, time.September, 1, 0, 0, 0, 0, time.UTC)
+	opts := keys.PKIOptions{Name: "Transparency Log Test", MinTime: minTime, MaxTime: minTime}
+	dir := t.TempDir()
+	ks, err := keys.NewKeyManager(opts, dir)
+	if err != nil {
+		t.Fatalf("Failed to initialize key manager: %+v", err)
+	}
+
+	// Grow the log one interval at a time, keeping every tree head.
+	const size = 13
+	var heads []keys.TreeHead
+	for i := range size {
+		if i > 0 {
+			if err := ks.ExtendRange(minTime.Add(time.Duration(i) * time.Hour)); err != nil {
+				t.Fatalf("Failed to extend range: %+v", err)
+			}
+		}
+		n, err := ks.LogPublicKeys()
+		if err != nil {
+			t.Fatalf("Failed to log public keys: %+v", err)
+		}
+		if n != 1 {
+			t.Errorf("Logged %d public keys, want 1", n)
+		}
+		head := ks.TreeHead(time.Now())
+		if head.Size != uint64(i+1) {
+			t.Errorf("Tree head has size %d, want %d", head.Size, i+1)
+		}
+		if !keys.VerifyTreeHead(ks.SigningPublicKey(), ks.PKIID(), head) {
+			t.Errorf("Tree head of size %d has an invalid signature", head.Size)
+		}
+		heads = append(heads, head)
+	}
+
+	for i := range size {
+		tm := minTime.Add(time.Duration(i) * time.Hour)
+		priv, err := ks.GetKeyForTime(tm)
+		if err != nil {
+			t.Fatalf("Failed to get key: %+v", err)
+		}
+		der, err := x509.MarshalPKIXPublicKey(priv.Public())
+		if err != nil {
+			t.Fatalf("Failed to marshal public key: %+v", err)
+		}
+		fingerprint := sha256.Sum256(der)
+		for _, head := range heads[i:] {
+			entry, index, proof, err := ks.LogInclusionProof(tm.Add(30*time.Minute), head.Size)
+			if err != nil {
+				t.Fatalf("Failed to prove inclusion of %v in tree of size %d: %+v", tm, head.Size, err)
+			}
+			if index != uint64(i) || !entry.Time.Equal(tm) || !bytes.Equal(entry.Fingerprint, fingerprint[:]) {
+				t.Errorf("Got entry %d %+v for %v, want entry %d with fingerprint %x", index, entry, tm, i, fingerprint)
+			}
+			if !keys.VerifyInclusion(keys.LogLeafHash(keys.LogLeaf(ks.PKIID(), entry)), index, head.Size, proof, head.RootHash) {
+				t.Errorf("Inclusion proof of entry %d in tree of size %d doesn't verify", index, head.Size)
+			}
+		}
+		if _, _, _, err := ks.LogInclusionProof(tm, uint64(i)); !errors.Is(err, keys.ErrInvalidTreeSize) {
+			t.Errorf("Got wrong error for proving inclusion of entry %d in tree of size %d: got %v, want %v", i, i, err, keys.ErrInvalidTreeSize)
+		}
+	}
+
+	for _, first := range heads {
+		for _, second := range heads {
+			proof, err := ks.LogConsistencyProof(first.Size, second.Size)
+			if first.Size > second.Size {
+				if !errors.Is(err, keys.ErrInvalidTreeSize) {
+					t.Errorf("Got wrong error for proving consistency from %d to %d: got %v, want %v", first.Size, second.Size, err, keys.ErrInvalidTreeSize)
+				}
+				continue
+			}
+			if err != nil {
+				t.Fatalf("Failed to prove consistency from %d to %d: %+v", first.Size, second.Size, err)
+			}
+			if !keys.VerifyConsistency(first.Size, second.Size, first.RootHash, second.RootHash, proof) {
+				t.Errorf("Consistency proof from %d to %d doesn't verify", first.Size, second.Size)
+			}
+		}
+	}
+	// A proof doesn't verify against a different tree.
+	proof, err := ks.LogConsistencyProof(5, size)
+	if err != nil {
+		t.Fatalf("Failed to prove consistency: %+v", err)
+	}
+	if keys.VerifyConsistency(5, size, heads[4].RootHash, heads[size-2].RootHash, proof) {
+		t.Errorf("Consistency proof verified against the wrong tree head")
+	}
+
+	if _, _, _, err := ks.LogInclusionProof(minTime.Add(size*time.Hour), size); !errors.Is(err, keys.ErrNotLogged) {
+		t.Errorf("Got wrong error for proving inclusion of an unlogged time: got %v, want %v", err, keys.ErrNotLogged)
+	}
+
+	// The log persists.
+	opts.MaxTime = ks.MaxTime()
+	reloaded := mustReload(t, opts, dir)
+	if head := reloaded.TreeHead(time.Now()); head.Size != size || !bytes.Equal(head.RootHash, heads[size-1].RootHash) {
+		t.Errorf("Reloaded log has size %d and root %x, want %d and %x", head.Size, head.RootHash, size, heads[size-1].RootHash)
+	}
+	if n, err := reloaded.LogPublicKeys(); n != 0 || err != nil {
+		t.Errorf("Reloaded log appended %d public keys with error %v, want none", n, err)
+	}
+}
+
+func TestFingerprint(t *testing.T) {
+	der := []byte("not really a key")
+	sum := sha256.Sum256(der)
+	if got, want := keys.Fingerprint(der), hex.EncodeToString(sum[:]); got != want {
+		t.Errorf("Got fingerprint %s, want %s", got, want)
+	}
+
+	ks, err := keys.NewKeyManager(keys.PKIOptions{Name: "Fingerprint Test", MinTime: time.Now(), MaxTime: time.Now()}, t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to initialize key manager: %+v", err)
+	}
+	spki, err := x509.MarshalPKIXPublicKey(ks.SigningPublicKey())
+	if err != nil {
+		t.Fatalf("Failed to marshal signing key: %+v", err)
+	}
+	if got, want := ks.SigningKeyFingerprint(), keys.Fingerprint(spki); got != want {
+		t.Errorf("Got signing key fingerprint %s, want %s", got, want)
+	}
+	if !strings.HasPrefix(ks.SigningKeyFingerprint(), ks.SigningKeyID()) {
+		t.Errorf("Signing key ID %s is not a prefix of its fingerprint %s", ks.SigningKeyID(), ks.SigningKeyFingerprint())
+	}
+}
+
+func TestSSHFormat(t *testing.T) {
+	tm := time.Date(2024, time.September, 1, 0, 0, 0, 0, time.UTC)
+	comment := "timecapsule " + tm.Format(time.RFC3339)
+	for _, alg := range []keys.Algorithm{keys.AlgorithmP256, keys.AlgorithmP384, keys.AlgorithmEd25519} {
+		t.Run(string(alg), func(t *testing.T) {
+			ks, err := keys.NewKeyManager(keys.PKIOptions{Name: "SSH Test", MinTime: tm, MaxTime: tm, Algorithm: alg}, t.TempDir())
+			if err != nil {
+				t.Fatalf("Failed to initialize key manager: %+v", err)
+			}
+			priv, err := ks.GetKeyForTime(tm)
+			if err != nil {
+				t.Fatalf("Failed to get key: %+v", err)
+			}
+
+			line, err := keys.FormatPublicKeyAsSSH(priv.Public(), comment)
+			if err != nil {
+				t.Fatalf("Failed to format public key: %+v", err)
+			}
+			pub, gotComment, _, _, err := ssh.ParseAuthorizedKey([]byte(line))
+			if err != nil {
+				t.Fatalf("Failed to parse authorized key %q: %+v", line, err)
+			}
+			if gotComment != comment {
+				t.Errorf("Got comment %q, want %q", gotComment, comment)
+			}
+
+			p, err := keys.FormatPrivateKeyAsSSH(priv, comment)
+			if err != nil {
+				t.Fatalf("Failed to format private key: %+v", err)
+			}
+			signer, err := ssh.ParsePrivateKey([]byte(p))
+			if err != nil {
+				t.Fatalf("Failed to parse private key: %+v", err)
+			}
+			if !bytes.Equal(signer.PublicKey().Marshal(), pub.Marshal()) {
+				t.Errorf("Private key doesn't match public key")
+			}
+			sig, err := signer.Sign(nil, []byte("message"))
+			if err != nil {
+				t.Fatalf("Failed to sign: %+v", err)
+			}
+			if err := pub.Verify([]byte("message"), sig); err != nil {
+				t.Errorf("Signature by private key doesn't verify with public key: %+v", err)
+			}
+		})
+	}
+
+	ks, err := keys.NewKeyManager(keys.PKIOptions{Name: "SSH Test", MinTime: tm, MaxTime: tm, Algorithm: keys.AlgorithmX25519}, t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to initialize key manager: %+v", err)
+	}
+	priv, err := ks.GetKeyForTime(tm)
+	if err != nil {
+		t.Fatalf("Failed to get key: %+v", err)
+	}
+	if _, err := keys.FormatPublicKeyAsSSH(priv.Public(), ""); err == nil {
+		t.Errorf("Formatted X25519 public key as SSH")
+	}
+	if _, err := keys.FormatPrivateKeyAsSSH(priv, ""); err == nil {
+		t.Errorf("Formatted X25519 private key as SSH")
+	}
+}
+
+func TestCOSEFormat(t *testing.T) {
+	tm := time.Date(2024, time.September, 1, 0, 0, 0, 0, time.UTC)
+	for _, tc := range []struct {
+		alg      keys.Algorithm
+		kty, crv byte
+	}{
+		{keys.AlgorithmP256, 0x02, 0x01},
+		{keys.AlgorithmP384, 0x02, 0x02},
+		{keys.AlgorithmX25519, 0x01, 0x04},
+		{keys.AlgorithmEd25519, 0x01, 0x06},
+	} {
+		t.Run(string(tc.alg), func(t *testing.T) {
+			ks, err := keys.NewKeyManager(keys.PKIOptions{Name: "COSE Test", MinTime: tm, MaxTime: tm, Algorithm: tc.alg}, t.TempDir())
+			if err != nil {
+				t.Fatalf("Failed to initialize key manager: %+v", err)
+			}
+			priv, err := ks.GetKeyForTime(tm)
+			if err != nil {
+				t.Fatalf("Failed to get key: %+v", err)
+			}
+			jwk, err := keys.FormatPrivateKeyAsJWK(priv)
+			if err != nil {
+				t.Fatalf("Failed to format private key as JWK: %+v", err)
+			}
+
+			// Byte string of 24 to 255 bytes, the lengths of all coordinates and scalars.
+			bstr := func(s string) []byte {
+				b, err := base64.RawURLEncoding.DecodeString(s)
+				if err != nil {
+					t.Fatalf("Invalid base64url %q: %+v", s, err)
+				}
+				return append([]byte{0x58, byte(len(b))}, b...)
+			}
+			// Labels in encoded order: kty (1), crv (-1), x (-2), y (-3), d (-4).
+			pub := []byte{0xa3, 0x01, tc.kty, 0x20, tc.crv, 0x21}
+			pub = append(pub, bstr(jwk.X)...)
+			if jwk.Y != "" {
+				pub[0]++
+				pub = append(append(pub, 0x22), bstr(jwk.Y)...)
+			}
+			wantPriv := append(append(bytes.Clone(pub), 0x23), bstr(jwk.D)...)
+			wantPriv[0]++
+
+			gotPub, err := keys.FormatPublicKeyAsCOSE(priv.Public())
+			if err != nil {
+				t.Fatalf("Failed to format public key: %+v", err)
+			}
+			if !bytes.Equal(gotPub, pub) {
+				t.Errorf("Got public COSE_Key %x, want %x", gotPub, pub)
+			}
+			gotPriv, err := keys.FormatPrivateKeyAsCOSE(priv)
+			if err != nil {
+				t.Fatalf("Failed to format private key: %+v", err)
+			}
+			if !bytes.Equal(gotPriv, wantPriv) {
+				t.Errorf("Got private COSE_Key %x, want %x", gotPriv, wantPriv)
+			}
+		})
+	}
+}
+
+func TestSecretMetadata(t *testing.T) {
+	minTime := time.Date(2024, time.September, 1, 0, 0, 0, 0, time.UTC)
+	opts := keys.PKIOptions{Name: "Metadata Test", MinTime: minTime, MaxTime: minTime.Add(2 * time.Hour), Algorithm: keys.AlgorithmEd25519}
+	dir := t.TempDir()
+	ks, err := keys.NewKeyManager(opts, dir)
+	if err != nil {
+		t.Fatalf("Failed to initialize key manager: %+v", err)
+	}
+
+	mds, err := ks.ListSecretMetadata()
+	if err != nil {
+		t.Fatalf("Failed to list secret metadata: %+v", err)
+	}
+	if len(mds) != 3 {
+		t.Fatalf("Listed %d secrets, want 3", len(mds))
+	}
+	for i, md := range mds {
+		if want := minTime.Add(time.Duration(i) * time.Hour); !md.Bucket.Equal(want) {
+			t.Errorf("Secret %d is for %s, want %s", i, md.Bucket.Format(time.RFC3339), want.Format(time.RFC3339))
+		}
+		if md.Created.IsZero() || md.GeneratorVersion == "" || md.Algorithm != keys.AlgorithmEd25519 {
+			t.Errorf("Secret %d has incomplete metadata %+v", i, md)
+		}
+		if sum, err := hex.DecodeString(md.Checksum); err != nil || len(sum) != sha256.Size {
+			t.Errorf("Secret %d has invalid checksum %q", i, md.Checksum)
+		}
+	}
+
+	// Secrets created before metadata existed are listed without it, and are not given any.
+	if err := os.RemoveAll(filepath.Join(dir, "secret_metadata")); err != nil {
+		t.Fatalf("Failed to remove secret metadata: %+v", err)
+	}
+	mds, err = mustReload(t, opts, dir).ListSecretMetadata()
+	if err != nil {
+		t.Fatalf("Failed to list secret metadata: %+v", err)
+	}
+	if len(mds) != 3 {
+		t.Fatalf("Listed %d secrets, want 3", len(mds))
+	}
+	for i, md := range mds {
+		if !md.Created.IsZero() || md.Checksum != "" {
+			t.Errorf("Secret %d without recorded metadata is listed with metadata %+v", i, md)
+		}
+	}
+}
+
+func TestMemStore(t *testing.T) {
+	minTime := time.Date(2024, time.September, 1, 0, 0, 0, 0, time.UTC)
+	dir := t.TempDir()
+	store := keys.NewMemStore(nil)
+	opts := keys.PKIOptions{Name: "Memory Test", MinTime: minTime, MaxTime: minTime.Add(2 * time.Hour), SecretStore: store}
+	ks := mustReload(t, opts, dir)
+	want, err := ks.GetKeyForTime(minTime.Add(time.Hour))
+	if err != nil {
+		t.Fatalf("Failed to get key: %+v", err)
+	}
+
+	buckets, err := store.List()
+	if err != nil {
+		t.Fatalf("Failed to list secrets: %+v", err)
+	}
+	if len(buckets) != 3 || !buckets[0].Equal(minTime) {
+		t.Errorf("Store holds secrets for %v, want the 3 hours from %s", buckets, minTime.Format(time.RFC3339))
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("Failed to read secrets directory: %+v", err)
+	}
+	for _, e := range entries {
+		if _, err := time.Parse("2006-01-02@15.04.05", e.Name()); err == nil {
+			t.Errorf("Secrets directory holds secret file %s", e.Name())
+		}
+	}
+
+	// A snapshot restores the same keys.
+	opts.SecretStore = keys.NewMemStore(store.Snapshot())
+	got, err := mustReload(t, opts, dir).GetKeyForTime(minTime.Add(time.Hour))
+	if err != nil {
+		t.Fatalf("Failed to get key from restored snapshot: %+v", err)
+	}
+	if !got.Public().(interface{ Equal(crypto.PublicKey) bool }).Equal(want.Public()) {
+		t.Errorf("Restored snapshot yields a different key")
+	}
+
+	// Secrets are recreated in a new store, even though their creation metadata is still recorded.
+	opts.SecretStore = keys.NewMemStore(nil)
+	if _, err := mustReload(t, opts, dir).GetKeyForTime(minTime); err != nil {
+		t.Errorf("Failed to get key from new store: %+v", err)
+	}
+
+	// Snapshots are copies.
+	snapshot := store.Snapshot()
+	clear(snapshot[minTime])
+	if err := store.Delete(minTime.Add(time.Hour)); err != nil {
+		t.Fatalf("Failed to delete secret: %+v", err)
+	}
+	if _, ok, err := store.Get(minTime.Add(time.Hour)); ok || err != nil {
+		t.Errorf("Got deleted secret: ok = %v, err = %v", ok, err)
+	}
+	if _, err := mustReload(t, keys.PKIOptions{Name: "Memory Test", SecretStore: store, MinTime: minTime, MaxTime: minTime}, dir).GetKeyForTime(minTime); err != nil {
+		t.Errorf("Secret changed by modifying a snapshot: %+v", err)
+	}
+}
@@ -0,0 +1,174 @@
+package keys
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// How secret files are arranged in the secrets directory.
+type SecretLayout string
+
+const (
+	// Every secret file is directly in the secrets directory.
+	SecretLayoutFlat SecretLayout = "flat"
+	// Secret files are nested in YYYY/MM/ subdirectories by the start of their interval, so that no
+	// directory holds more than a month of secrets.
+	SecretLayoutSharded SecretLayout = "sharded"
+)
+
+// Name of the file recording the layout of the secrets directory.
+const layoutFile = "secret_layout"
+
+// Parses the name of a supported secret layout.
+func parseSecretLayout(s string) (SecretLayout, error) {
+	switch layout := SecretLayout(s); layout {
+	case SecretLayoutFlat, SecretLayoutSharded:
+		return layout, nil
+	default:
+		return "", fmt.Errorf("unsupported secret layout %q", s)
+	}
+}
+
+// Returns the path, relative to the secrets directory, of the secret file for the bucket starting
+// at the given time.
+func (l SecretLayout) secretPath(bucket time.Time) string {
+	bucket = bucket.UTC()
+	name := bucket.Format(fileNameLayout)
+	if l == SecretLayoutSharded {
+		return path.Join(fmt.Sprintf("%04d", bucket.Year()), fmt.Sprintf("%02d", int(bucket.Month())), name)
+	}
+	return name
+}
+
+// Whether a directory name is a number of the given number of digits, as in a shard name.
+func isShardName(name string, digits int) bool {
+	if len(name) != digits {
+		return false
+	}
+	_, err := strconv.Atoi(name)
+	return err == nil
+}
+
+// Returns the start times of all buckets that have a secret file in the given layout, in
+// chronological order.
+//
+// Files that are not named like secret files are skipped. Unrecognized files are logged.
+func listBuckets(dir string, layout SecretLayout) ([]time.Time, error) {
+	var buckets []time.Time
+	// os.ReadDir sorts entries by file name, which is chronological for secret files and shards.
+	var walk func(rel string, depth int) error
+	walk = func(rel string, depth int) error {
+		entries, err := os.ReadDir(path.Join(dir, rel))
+		if err != nil {
+			return fmt.Errorf("failed to read secrets directory: %w", err)
+		}
+		for _, e := range entries {
+			name := path.Join(rel, e.Name())
+			if e.IsDir() {
+				// Years, then months.
+				if layout == SecretLayoutSharded && depth < 2 && isShardName(e.Name(), 4-2*depth) {
+					if err := walk(name, depth+1); err != nil {
+						return err
+					}
+				}
+				continue
+			}
+			if depth == 0 && configFiles[e.Name()] || strings.HasSuffix(e.Name(), tempSuffix) {
+				continue
+			}
+			t, err := time.Parse(fileNameLayout, e.Name())
+			if err != nil {
+				log.Printf("Skipping unrecognized file in secrets directory: %s", name)
+				continue
+			}
+			// Secret files outside of shards belong to the flat layout.
+			if layout != SecretLayoutSharded || depth == 2 {
+				buckets = append(buckets, t)
+			}
+		}
+		return nil
+	}
+	if err := walk("", 0); err != nil {
+		return nil, err
+	}
+	return buckets, nil
+}
+
+// Moves any secret files that are arranged in the other layout into the given layout.
+//
+// This is idempotent, so a migration that is interrupted is completed the next time it runs.
+func migrateLayout(dir string, to SecretLayout) error {
+	from := SecretLayoutFlat
+	if to == SecretLayoutFlat {
+		from = SecretLayoutSharded
+	}
+	buckets, err := listBuckets(dir, from)
+	if err != nil {
+		return err
+	}
+	for _, t := range buckets {
+		oldPath := path.Join(dir, from.secretPath(t))
+		newPath := path.Join(dir, to.secretPath(t))
+		if err := os.MkdirAll(path.Dir(newPath), 0o755); err != nil {
+			return fmt.Errorf("failed to create directory for %s: %w", newPath, err)
+		}
+		if err := os.Rename(oldPath, newPath); err != nil {
+			return fmt.Errorf("failed to move secret file %s to %s: %w", oldPath, newPath, err)
+		}
+	}
+	if from == SecretLayoutSharded {
+		// Remove the emptied shards, leaving any that still hold other files.
+		for _, t := range buckets {
+			month := path.Join(dir, path.Dir(from.secretPath(t)))
+			os.Remove(month)
+			os.Remove(path.Dir(month))
+		}
+	}
+	if len(buckets) != 0 {
+		log.Printf("Moved %d secret files in %s to the %s layout", len(buckets), dir, to)
+	}
+	return nil
+}
+
+// Determines the layout of the secrets directory and arranges the secret files in it.
+//
+// The layout is the requested one if given, or else the recorded one, or else flat, since PKIs
+// that predate layouts are flat. The layout is recorded before any files are moved, and files in
+// the other layout are moved on every call, so that an interrupted migration is completed the next
+// time the PKI is loaded.
+func loadSecretLayout(dir string, requested SecretLayout) (SecretLayout, error) {
+	record := newFileSource(path.Join(dir, layoutFile))
+	recorded, ok, err := record.Get()
+	if err != nil {
+		return "", fmt.Errorf("failed to read secret layout: %w", err)
+	}
+	layout := requested
+	if layout == "" {
+		layout = SecretLayoutFlat
+		if ok {
+			layout = SecretLayout(recorded)
+		}
+	}
+	if _, err := parseSecretLayout(string(layout)); err != nil {
+		return "", err
+	}
+
+	if ok && SecretLayout(recorded) != layout {
+		// Replace the record explicitly, since the file source only writes new files.
+		if err := replaceFileAtomic(path.Join(dir, layoutFile), []byte(fmt.Sprintf("%s\n", layout)), fileMode); err != nil {
+			return "", fmt.Errorf("failed to record secret layout: %w", err)
+		}
+	} else if err := record.Set(string(layout)); err != nil {
+		return "", fmt.Errorf("failed to record secret layout: %w", err)
+	}
+
+	if err := migrateLayout(dir, layout); err != nil {
+		return "", fmt.Errorf("failed to migrate secrets to the %s layout: %w", layout, err)
+	}
+	return layout, nil
+}
@@ -0,0 +1,26 @@
+package keys
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// prctl option that controls whether the process may be dumped. See prctl(2).
+const prSetDumpable = 4
+
+// Locks all of the process's memory, present and future, into RAM and excludes the process from
+// core dumps, so that root secrets and the cache of derived keys are never written to swap or to a
+// core file.
+//
+// Individual keys can't be locked, since Go allocates them on the garbage-collected heap alongside
+// everything else, so the whole process is locked. This requires CAP_IPC_LOCK or an RLIMIT_MEMLOCK
+// large enough for the process.
+func LockMemory() error {
+	if err := syscall.Mlockall(syscall.MCL_CURRENT | syscall.MCL_FUTURE); err != nil {
+		return fmt.Errorf("failed to lock memory (is RLIMIT_MEMLOCK too low?): %w", err)
+	}
+	if _, _, errno := syscall.RawSyscall(syscall.SYS_PRCTL, prSetDumpable, 0, 0); errno != 0 {
+		return fmt.Errorf("failed to disable core dumps: %w", errno)
+	}
+	return nil
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+
+package keys
+
+import "errors"
+
+// Locks all of the process's memory into RAM and excludes the process from core dumps. Only
+// supported on Linux.
+func LockMemory() error {
+	return errors.New("locking memory is only supported on Linux")
+}
@@ -0,0 +1,83 @@
+package keys
+
+import (
+	"bytes"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Secret store that holds secrets only in memory, so that they are lost when the process exits.
+// This suits tests and short-lived demo deployments that shouldn't touch disk.
+//
+// The PKI's configuration is still kept in its secrets directory, which may itself be temporary.
+type MemStore struct {
+	mu sync.RWMutex
+	// Stored secrets, keyed by the Unix time of their bucket.
+	secrets map[int64][]byte
+}
+
+// Returns a new in-memory secret store, holding the secrets of the given snapshot if it isn't nil.
+// See MemStore.Snapshot.
+func NewMemStore(snapshot map[time.Time][]byte) *MemStore {
+	m := &MemStore{secrets: make(map[int64][]byte, len(snapshot))}
+	for bucket, secret := range snapshot {
+		m.secrets[bucket.Unix()] = bytes.Clone(secret)
+	}
+	return m
+}
+
+func (m *MemStore) Get(bucket time.Time) ([]byte, bool, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	secret, ok := m.secrets[bucket.Unix()]
+	return bytes.Clone(secret), ok, nil
+}
+
+func (m *MemStore) Put(bucket time.Time, secret []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.secrets[bucket.Unix()]; ok {
+		return ErrSecretExists
+	}
+	m.secrets[bucket.Unix()] = bytes.Clone(secret)
+	return nil
+}
+
+// Deletes a secret, first overwriting it with zeros.
+func (m *MemStore) Delete(bucket time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if secret, ok := m.secrets[bucket.Unix()]; ok {
+		clear(secret)
+		delete(m.secrets, bucket.Unix())
+	}
+	return nil
+}
+
+func (m *MemStore) List() ([]time.Time, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	unix := slices.Sorted(maps.Keys(m.secrets))
+	buckets := make([]time.Time, len(unix))
+	for i, u := range unix {
+		buckets[i] = time.Unix(u, 0).UTC()
+	}
+	return buckets, nil
+}
+
+// Returns a copy of every stored secret, keyed by the start of its bucket, such as to save a demo
+// deployment's secrets before it exits. Secrets are as they were stored, so a snapshot can be
+// restored with NewMemStore or copied into another store with SecretStore.Put.
+//
+// A snapshot holds root secrets, and must be protected accordingly.
+func (m *MemStore) Snapshot() map[time.Time][]byte {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	snapshot := make(map[time.Time][]byte, len(m.secrets))
+	for u, secret := range m.secrets {
+		snapshot[time.Unix(u, 0).UTC()] = bytes.Clone(secret)
+	}
+	return snapshot
+}
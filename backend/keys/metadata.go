@@ -0,0 +1,132 @@
+package keys
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"runtime/debug"
+	"time"
+)
+
+// Name of the directory in the secrets directory that holds a metadata file for each secret.
+const metadataDir = "secret_metadata"
+
+// Audit record of the creation of a secret.
+//
+// Metadata is kept in the secrets directory whichever store holds the secrets, and outlives the
+// secret if it is deleted under a retention policy. It reveals nothing about the secret.
+type SecretMetadata struct {
+	// Start of the bucket covered by the secret.
+	Bucket time.Time `json:"bucket"`
+	// When the secret was created.
+	Created time.Time `json:"created,omitzero"`
+	// Version of the binary that created the secret.
+	GeneratorVersion string `json:"generatorVersion,omitempty"`
+	// Algorithm of the PKI when the secret was created.
+	Algorithm Algorithm `json:"algorithm,omitempty"`
+	// Hex-encoded checksum of the secret, as stored by the checksum store.
+	Checksum string `json:"checksum,omitempty"`
+}
+
+// Version of the running binary, as recorded in secret metadata: the main module's version, followed
+// by the VCS revision if it is known.
+func generatorVersion() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "unknown"
+	}
+	version := info.Main.Version
+	if version == "" {
+		version = "(devel)"
+	}
+	for _, setting := range info.Settings {
+		if setting.Key == "vcs.revision" {
+			version = fmt.Sprintf("%s+%s", version, setting.Value)
+		}
+	}
+	return version
+}
+
+// Returns the path of the metadata file of a bucket's secret.
+func metadataPath(dir string, bucket time.Time) string {
+	return path.Join(dir, metadataDir, bucket.UTC().Format(fileNameLayout)+".json")
+}
+
+// Records the metadata of a newly created secret.
+func writeSecretMetadata(dir string, md SecretMetadata) error {
+	if err := os.MkdirAll(path.Join(dir, metadataDir), 0o755); err != nil {
+		return fmt.Errorf("failed to create secret metadata directory: %w", err)
+	}
+	b, err := json.Marshal(md)
+	if err != nil {
+		return err
+	}
+	return replaceFileAtomic(metadataPath(dir, md.Bucket), append(b, '\n'), fileMode)
+}
+
+// Reads the metadata of a bucket's secret, or ok = false if none was recorded, as for secrets that
+// predate metadata.
+func readSecretMetadata(dir string, bucket time.Time) (md SecretMetadata, ok bool, err error) {
+	b, ok, err := tryReadFile(metadataPath(dir, bucket))
+	if err != nil || !ok {
+		return SecretMetadata{}, false, err
+	}
+	if err := json.Unmarshal(b, &md); err != nil {
+		return SecretMetadata{}, false, fmt.Errorf("invalid metadata for secret for %s: %w", bucket.Format(time.RFC3339), err)
+	}
+	return md, true, nil
+}
+
+// Whether the creation of a bucket's secret was recorded, in which case the secret needn't be read
+// to know that it exists. Unreadable or mismatched metadata is treated as missing.
+func (s *secretManager) hasValidMetadata(bucket time.Time) bool {
+	md, ok, err := readSecretMetadata(s.dir, bucket)
+	if err != nil || !ok {
+		return false
+	}
+	sum, err := hex.DecodeString(md.Checksum)
+	return err == nil && len(sum) == sha256.Size && md.Bucket.Equal(bucket)
+}
+
+// Records the metadata of a secret that was just created. Failures are logged rather than
+// returned, since the secret itself is already safely stored.
+func (s *secretManager) recordCreation(bucket time.Time, secret []byte) {
+	md := SecretMetadata{
+		Bucket:           bucket,
+		Created:          time.Now().UTC(),
+		GeneratorVersion: generatorVersion(),
+		Algorithm:        s.alg,
+		Checksum:         hex.EncodeToString(secretChecksum(bucket, secret)),
+	}
+	if err := writeSecretMetadata(s.dir, md); err != nil {
+		log.Printf("ERROR: Failed to record metadata of secret for %s: %+v", bucket.Format(time.RFC3339), err)
+	}
+}
+
+// Returns the metadata of every secret in the store, in chronological order. Secrets without
+// recorded metadata are listed with only their bucket.
+func (m *KeyManager) ListSecretMetadata() ([]SecretMetadata, error) {
+	if m.secrets.scheme == SecretSchemeHierarchical {
+		return nil, fmt.Errorf("PKIs with the %s secret scheme have no per-interval secrets", SecretSchemeHierarchical)
+	}
+	buckets, err := m.secrets.ListBuckets()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list secrets: %w", err)
+	}
+	mds := make([]SecretMetadata, 0, len(buckets))
+	for _, bucket := range buckets {
+		md, ok, err := readSecretMetadata(m.secrets.dir, bucket)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			md = SecretMetadata{Bucket: bucket.UTC()}
+		}
+		mds = append(mds, md)
+	}
+	return mds, nil
+}
@@ -0,0 +1,50 @@
+package keys
+
+import (
+	"crypto/mlkem"
+	"fmt"
+	"io"
+	"time"
+)
+
+// HKDF label of ML-KEM-768 keys. See kdfInfo.
+const mlkem768Label = "ML-KEM-768"
+
+// Derives an ML-KEM-768 key pair from an initial secret and a time.
+//
+// The key is generated from a 64-byte seed (FIPS 203, section 7.1), which is read from the HKDF
+// stream. Every seed is valid, so the derivation is deterministic and stable.
+func deriveMLKEMKeyForTime(p kdfParams, ikm []byte, t time.Time) (*mlkem.DecapsulationKey768, error) {
+	stream, err := keyStream(p, ikm, t, mlkem768Label)
+	if err != nil {
+		return nil, err
+	}
+	seed := make([]byte, mlkem.SeedSize)
+	defer clear(seed)
+	if _, err := io.ReadFull(stream, seed); err != nil {
+		return nil, fmt.Errorf("ran out of entropy: %w", err)
+	}
+	return mlkem.NewDecapsulationKey768(seed)
+}
+
+// Returns the post-quantum ML-KEM-768 key pair for the given time.
+//
+// Every PKI has an ML-KEM-768 key for each time in addition to its key of the configured algorithm.
+// Both are derived from the same secret, so they are released together.
+func (m *KeyManager) GetMLKEMKeyForTime(t time.Time) (*mlkem.DecapsulationKey768, error) {
+	if err := m.checkKeyTime(t); err != nil {
+		return nil, err
+	}
+	return cached(m.cache, mlkem768Label, m.secrets.KDFVersion(), t, func() (*mlkem.DecapsulationKey768, error) {
+		secret, err := m.secretForTime(t)
+		if err != nil {
+			return nil, err
+		}
+		defer clear(secret)
+		key, err := deriveMLKEMKeyForTime(m.secrets.kdfParams(), secret, t)
+		if err != nil {
+			return nil, fmt.Errorf("failed to derive ML-KEM-768 keypair for %s: %+v", t.Format(time.RFC3339), err)
+		}
+		return key, nil
+	})
+}
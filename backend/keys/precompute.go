@@ -0,0 +1,113 @@
+package keys
+
+import (
+	"bytes"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"maps"
+	"sync"
+	"time"
+)
+
+// A public key and its signature by the PKI signing key. See SignPublicKey.
+type SignedPublicKey struct {
+	// DER-encoded SubjectPublicKeyInfo message.
+	SPKI      []byte
+	Signature []byte
+}
+
+// Signed public keys of upcoming intervals, derived ahead of time so that they can be served
+// without touching the secret store.
+//
+// Unlike the key cache, entries are never evicted to make room for others, only dropped once their
+// time has passed.
+type precomputedKeys struct {
+	mu   sync.RWMutex
+	keys map[int64]SignedPublicKey
+}
+
+func newPrecomputedKeys() *precomputedKeys {
+	return &precomputedKeys{keys: make(map[int64]SignedPublicKey)}
+}
+
+func (p *precomputedKeys) get(t time.Time) (SignedPublicKey, bool) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	k, ok := p.keys[t.Unix()]
+	return k, ok
+}
+
+func (p *precomputedKeys) put(t time.Time, k SignedPublicKey) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.keys[t.Unix()] = k
+}
+
+// Drops all keys for times before t.
+func (p *precomputedKeys) dropBefore(t time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	maps.DeleteFunc(p.keys, func(unix int64, _ SignedPublicKey) bool {
+		return unix < t.Unix()
+	})
+}
+
+// Derives and signs the public keys for the starts of the n intervals beginning with the one
+// containing from, and keeps them until their time has passed. Keys for earlier times are dropped.
+// Intervals outside the PKI's time range, or whose keys have been revoked or deleted, are skipped.
+// Returns the number of keys newly derived.
+//
+// Returns ErrNotReady if the secrets for some of the intervals have not been generated yet; calling
+// again later picks up where this call stopped.
+func (m *KeyManager) PrecomputePublicKeys(from time.Time, n int) (int, error) {
+	start := from.UTC().Truncate(secretInterval)
+	m.precomputed.dropBefore(start)
+
+	var derived int
+	for i := range n {
+		t := start.Add(time.Duration(i) * secretInterval)
+		if t.After(m.MaxTime()) {
+			break
+		}
+		if t.Before(m.minTime) {
+			continue
+		}
+		if _, ok := m.precomputed.get(t); ok {
+			continue
+		}
+		priv, err := m.GetKeyForTime(t)
+		if errors.Is(err, ErrRevoked) || errors.Is(err, ErrSecretDeleted) {
+			continue
+		}
+		if err != nil {
+			return derived, err
+		}
+		der, err := x509.MarshalPKIXPublicKey(priv.Public())
+		if err != nil {
+			return derived, fmt.Errorf("failed to marshal public key for %s: %w", t.Format(time.RFC3339), err)
+		}
+		m.precomputed.put(t, SignedPublicKey{SPKI: der, Signature: m.SignPublicKey(t, der)})
+		derived++
+	}
+	return derived, nil
+}
+
+// Returns the precomputed public key for the given time, if there is one. See
+// PrecomputePublicKeys.
+func (m *KeyManager) PrecomputedPublicKey(t time.Time) (SignedPublicKey, bool) {
+	// Keys may have been revoked or deleted since they were precomputed.
+	if err := m.checkKeyTime(t); err != nil {
+		return SignedPublicKey{}, false
+	}
+	return m.precomputed.get(t)
+}
+
+// Returns the stored signature of a precomputed public key, if spki is that key.
+func (m *KeyManager) precomputedSignature(t time.Time, spki []byte) ([]byte, bool) {
+	k, ok := m.precomputed.get(t)
+	if !ok || !bytes.Equal(k.SPKI, spki) {
+		return nil, false
+	}
+	return k.Signature, true
+}
@@ -0,0 +1,173 @@
+package keys
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"time"
+)
+
+// Secret store that mirrors every secret of a primary store to replicas, so that losing the primary
+// store, such as a disk, doesn't destroy the secrets of every future key.
+//
+// Secrets are written to the primary first and then to every replica, and a replica that already
+// holds a different secret is an error. Secrets are read from the primary, falling back to the
+// replicas when the primary fails or has lost a secret, in which case the secret is restored to the
+// primary.
+type replicatedStore struct {
+	primary  SecretStore
+	replicas []SecretStore
+}
+
+// Writes a secret to a replica, verifying any secret that the replica already holds.
+func putReplica(replica SecretStore, bucket time.Time, secret []byte) error {
+	err := replica.Put(bucket, secret)
+	if !errors.Is(err, ErrSecretExists) {
+		return err
+	}
+	existing, _, err := replica.Get(bucket)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(existing, secret) {
+		return errors.New("replica holds a different secret")
+	}
+	return nil
+}
+
+func (r *replicatedStore) Get(bucket time.Time) ([]byte, bool, error) {
+	secret, ok, primaryErr := r.primary.Get(bucket)
+	if primaryErr == nil && ok {
+		return secret, true, nil
+	}
+	for i, replica := range r.replicas {
+		secret, ok, err := replica.Get(bucket)
+		if err != nil {
+			log.Printf("Failed to read secret for %s from replica %d: %v", bucket.Format(time.RFC3339), i, err)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		if primaryErr != nil {
+			log.Printf("Read secret for %s from replica %d after primary store failed: %v", bucket.Format(time.RFC3339), i, primaryErr)
+			return secret, true, nil
+		}
+		if err := r.primary.Put(bucket, secret); err != nil && !errors.Is(err, ErrSecretExists) {
+			log.Printf("Failed to restore secret for %s from replica %d: %v", bucket.Format(time.RFC3339), i, err)
+		} else {
+			log.Printf("Restored lost secret for %s from replica %d", bucket.Format(time.RFC3339), i)
+		}
+		return secret, true, nil
+	}
+	return nil, false, primaryErr
+}
+
+func (r *replicatedStore) Put(bucket time.Time, secret []byte) error {
+	// If the primary already has a secret, whoever wrote it replicates it.
+	if err := r.primary.Put(bucket, secret); err != nil {
+		return err
+	}
+	for i, replica := range r.replicas {
+		if err := putReplica(replica, bucket, secret); err != nil {
+			return fmt.Errorf("failed to replicate secret for %s to replica %d: %w", bucket.Format(time.RFC3339), i, err)
+		}
+	}
+	return nil
+}
+
+// Deletes a secret from the primary and from every replica, since a secret left on any replica
+// isn't deleted at all.
+func (r *replicatedStore) Delete(bucket time.Time) error {
+	if err := deleteSecret(r.primary, bucket); err != nil {
+		return err
+	}
+	for i, replica := range r.replicas {
+		if err := deleteSecret(replica, bucket); err != nil {
+			return fmt.Errorf("failed to delete secret for %s from replica %d: %w", bucket.Format(time.RFC3339), i, err)
+		}
+	}
+	return nil
+}
+
+func (r *replicatedStore) List() ([]time.Time, error) {
+	buckets, err := r.primary.List()
+	if err == nil {
+		return buckets, nil
+	}
+	for i, replica := range r.replicas {
+		if buckets, replicaErr := replica.List(); replicaErr == nil {
+			log.Printf("Listed secrets of replica %d after primary store failed: %v", i, err)
+			return buckets, nil
+		}
+	}
+	return nil, err
+}
+
+// Brings the primary and every replica up to date with each other: secrets missing from the
+// primary are restored from the replicas, and secrets missing from a replica are copied to it. Fails
+// if any two stores hold different secrets for the same bucket.
+//
+// Secrets of buckets before deletedBefore have been deleted under a retention policy, so they are
+// deleted from every store rather than restored.
+func (r *replicatedStore) sync(deletedBefore time.Time) error {
+	buckets, err := r.primary.List()
+	if err != nil {
+		return fmt.Errorf("failed to list secrets: %w", err)
+	}
+	for i, replica := range r.replicas {
+		replicaBuckets, err := replica.List()
+		if err != nil {
+			return fmt.Errorf("failed to list secrets of replica %d: %w", i, err)
+		}
+		buckets = append(buckets, replicaBuckets...)
+	}
+	slices.SortFunc(buckets, time.Time.Compare)
+	buckets = slices.CompactFunc(buckets, time.Time.Equal)
+
+	var restored, copied int
+	for _, bucket := range buckets {
+		if bucket.Before(deletedBefore) {
+			if err := r.Delete(bucket); err != nil {
+				return err
+			}
+			continue
+		}
+		secret, ok, err := r.primary.Get(bucket)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			// Some replica has the secret, since the bucket was listed.
+			for _, replica := range r.replicas {
+				if secret, ok, err = replica.Get(bucket); err != nil {
+					return err
+				} else if ok {
+					break
+				}
+			}
+			if err := r.primary.Put(bucket, secret); err != nil {
+				return fmt.Errorf("failed to restore secret for %s: %w", bucket.Format(time.RFC3339), err)
+			}
+			restored++
+		}
+		for i, replica := range r.replicas {
+			_, ok, err := replica.Get(bucket)
+			if err != nil {
+				return fmt.Errorf("failed to read secret for %s from replica %d: %w", bucket.Format(time.RFC3339), i, err)
+			}
+			if err := putReplica(replica, bucket, secret); err != nil {
+				return fmt.Errorf("failed to replicate secret for %s to replica %d: %w", bucket.Format(time.RFC3339), i, err)
+			}
+			if !ok {
+				copied++
+			}
+		}
+	}
+	if restored != 0 || copied != 0 {
+		log.Printf("Restored %d secrets to the primary store and copied %d secrets to replicas", restored, copied)
+	}
+	return nil
+}
@@ -0,0 +1,185 @@
+package keys
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"time"
+)
+
+// Name of the file recording the deletion of secrets under a retention policy, one JSON record per
+// line.
+const deletionsFile = "secret_deletions.log"
+
+// Returned when a time's secret has been deleted under the PKI's retention policy, so that its keys
+// can no longer be derived.
+var ErrSecretDeleted = errors.New("secret has been deleted")
+
+// Audit record of the deletion of a secret.
+type deletionRecord struct {
+	// Start of the bucket whose secret was deleted.
+	Bucket time.Time `json:"bucket"`
+	// When the secret was deleted, according to the secure clock.
+	Deleted time.Time `json:"deleted"`
+}
+
+// Reads the deletion records of a secrets directory and returns the start of the first bucket
+// whose secret has not been deleted, or the zero time if no secret has been deleted. Secrets are
+// deleted in chronological order, so every earlier bucket's secret has been deleted.
+//
+// A final record that was torn by a crash is removed. Its secret was not deleted yet, since secrets
+// are only deleted once their record is on disk.
+func loadDeletions(dir string) (time.Time, error) {
+	file := path.Join(dir, deletionsFile)
+	contents, ok, err := tryReadFile(file)
+	if err != nil || !ok {
+		return time.Time{}, err
+	}
+	if i := bytes.LastIndexByte(contents, '\n'); i != len(contents)-1 {
+		log.Printf("Removing torn final record of %s", file)
+		if err := os.Truncate(file, int64(i+1)); err != nil {
+			return time.Time{}, fmt.Errorf("failed to repair %s: %w", file, err)
+		}
+		contents = contents[:i+1]
+	}
+	var deletedBefore time.Time
+	for line := range bytes.Lines(contents) {
+		var r deletionRecord
+		if err := json.Unmarshal(line, &r); err != nil {
+			return time.Time{}, fmt.Errorf("invalid record in %s: %w", file, err)
+		}
+		if end := r.Bucket.Add(secretInterval); end.After(deletedBefore) {
+			deletedBefore = end
+		}
+	}
+	return deletedBefore.UTC(), nil
+}
+
+// Appends a deletion record to the secrets directory and syncs it to disk.
+func appendDeletion(dir string, r deletionRecord) error {
+	b, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path.Join(dir, deletionsFile), os.O_WRONLY|os.O_CREATE|os.O_APPEND, fileMode)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(append(b, '\n'))
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// Checks that the secret of the bucket containing the given time has not been deleted. Errors wrap
+// ErrSecretDeleted.
+func (s *secretManager) checkNotDeleted(t time.Time) error {
+	s.deletedMu.RLock()
+	defer s.deletedMu.RUnlock()
+	if t.Before(s.deletedBefore) {
+		return fmt.Errorf("%w: secret for %s", ErrSecretDeleted, t.Truncate(secretInterval).UTC().Format(time.RFC3339))
+	}
+	return nil
+}
+
+// The start of the first bucket whose secret has not been deleted, or the zero time if no secret has
+// been deleted.
+func (s *secretManager) deletedBeforeTime() time.Time {
+	s.deletedMu.RLock()
+	defer s.deletedMu.RUnlock()
+	return s.deletedBefore
+}
+
+// Deletes any secrets that deletion records say are deleted, completing deletions that were
+// interrupted by a crash.
+func (s *secretManager) finishDeletions() error {
+	if s.deletedBefore.IsZero() {
+		return nil
+	}
+	buckets, err := s.store.List()
+	if err != nil {
+		return fmt.Errorf("failed to list secrets: %w", err)
+	}
+	for _, bucket := range buckets {
+		if !bucket.Before(s.deletedBefore) {
+			break
+		}
+		if err := deleteSecret(s.store, bucket); err != nil {
+			return err
+		}
+		log.Printf("Completed interrupted deletion of secret for %s", bucket.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// Deletes the secrets of all buckets in [minTime, maxTime] that end no later than cutoff, in
+// chronological order, recording each deletion. now is recorded as the time of deletion. Returns the
+// number of secrets deleted.
+func (s *secretManager) deleteBefore(minTime, maxTime, cutoff, now time.Time) (int, error) {
+	s.deletedMu.Lock()
+	defer s.deletedMu.Unlock()
+
+	bucket := minTime.UTC().Truncate(secretInterval)
+	if s.deletedBefore.After(bucket) {
+		bucket = s.deletedBefore
+	}
+	var n int
+	for ; bucket.Compare(maxTime) <= 0 && !bucket.Add(secretInterval).After(cutoff); bucket = bucket.Add(secretInterval) {
+		// Record the deletion first, so that a crash never leaves a deleted secret to be recreated.
+		if err := appendDeletion(s.dir, deletionRecord{Bucket: bucket, Deleted: now.UTC()}); err != nil {
+			return n, fmt.Errorf("failed to record deletion of secret for %s: %w", bucket.Format(time.RFC3339), err)
+		}
+		s.deletedBefore = bucket.Add(secretInterval)
+		if err := deleteSecret(s.store, bucket); err != nil {
+			return n, fmt.Errorf("failed to delete secret for %s: %w", bucket.Format(time.RFC3339), err)
+		}
+		log.Printf("Deleted secret for %s under retention policy", bucket.Format(time.RFC3339))
+		n++
+	}
+	return n, nil
+}
+
+// Drops all cached keys for times before t.
+func (c *keyCache) dropBefore(t time.Time) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key, e := range c.entries {
+		if key.unix < t.Unix() {
+			c.recency.Remove(e)
+			delete(c.entries, key)
+		}
+	}
+}
+
+// How long the PKI keeps secrets after their interval ends, or zero if secrets are kept forever.
+func (m *KeyManager) Retention() time.Duration {
+	return m.retention
+}
+
+// Deletes the secrets of every interval that ended at least the PKI's retention period before now,
+// after which the keys of those intervals can never be derived again, by anyone: data encrypted to
+// them becomes permanently unreadable. Each deletion is recorded in the secrets directory. Returns
+// the number of secrets deleted. Does nothing if the PKI has no retention period.
+//
+// now must come from a secure clock, since a clock that runs fast deletes secrets early.
+func (m *KeyManager) DeleteExpiredSecrets(now time.Time) (int, error) {
+	if m.retention <= 0 {
+		return 0, nil
+	}
+	n, err := m.secrets.deleteBefore(m.minTime, m.MaxTime(), now.Add(-m.retention), now)
+	if n > 0 {
+		m.cache.dropBefore(m.secrets.deletedBeforeTime())
+	}
+	return n, err
+}
@@ -0,0 +1,139 @@
+package keys
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	// Name of the file in the secrets directory that stores revoked time ranges.
+	revocationsFile = "revocations.json"
+
+	// Maximum length of a revocation reason in bytes.
+	maxRevocationReasonLength = 1024
+)
+
+// Returned when a time's keys have been revoked.
+var ErrRevoked = errors.New("keys revoked")
+
+// Returned when a revocation cannot be recorded because the request itself is invalid.
+var ErrInvalidRevocation = errors.New("invalid revocation")
+
+// A revoked time range, whose keys are never served again.
+type Revocation struct {
+	// Start of the first revoked interval.
+	Start time.Time `json:"start"`
+	// End of the last revoked interval, exclusive.
+	End time.Time `json:"end"`
+	// Why the range was revoked, such as a reference to an incident.
+	Reason string `json:"reason,omitempty"`
+	// When the range was revoked.
+	Revoked time.Time `json:"revoked"`
+}
+
+// A durable list of revoked time ranges.
+//
+// Revocations are stored as a JSON array, and are permanent: a secret that is known to have leaked
+// never becomes safe again.
+type revocationStore struct {
+	path string
+
+	mu          sync.RWMutex
+	revocations []Revocation
+}
+
+// Loads the revocation store in the given directory, or an empty store if none exists yet.
+func loadRevocationStore(dir string) (*revocationStore, error) {
+	s := &revocationStore{path: path.Join(dir, revocationsFile)}
+
+	b, ok, err := tryReadFile(s.path)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return s, nil
+	}
+	if err := json.Unmarshal(b, &s.revocations); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", s.path, err)
+	}
+	return s, nil
+}
+
+// Checks that the given time is not in a revoked range. Errors wrap ErrRevoked.
+func (s *revocationStore) Check(t time.Time) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, r := range s.revocations {
+		if !t.Before(r.Start) && t.Before(r.End) {
+			return fmt.Errorf("%w: %s is in the range revoked at %s", ErrRevoked, t.UTC().Format(time.RFC3339), r.Revoked.Format(time.RFC3339))
+		}
+	}
+	return nil
+}
+
+// Returns all revocations, in the order they were made.
+func (s *revocationStore) List() []Revocation {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return slices.Clone(s.revocations)
+}
+
+// Records a new revocation, persisting it to disk.
+func (s *revocationStore) Add(r Revocation) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	revocations := append(slices.Clone(s.revocations), r)
+	b, err := json.MarshalIndent(revocations, "", "  ")
+	if err != nil {
+		return err
+	}
+	// A crash must never leave a partially written file behind.
+	if err := replaceFileAtomic(s.path, append(b, '\n'), fileMode); err != nil {
+		return fmt.Errorf("failed to replace %s: %w", s.path, err)
+	}
+	s.revocations = revocations
+	return nil
+}
+
+// Permanently revokes the keys of every time in [start, end], for use when a secret is known to have
+// leaked. Keys for revoked times are never served again, public or private, so that no new data is
+// encrypted to them and no one relies on their release.
+//
+// Every time in an interval shares the interval's secret, so the range is widened to whole intervals.
+// Returns the recorded revocation.
+func (m *KeyManager) Revoke(start, end time.Time, reason string) (Revocation, error) {
+	if end.Before(start) {
+		return Revocation{}, fmt.Errorf("%w: end %s is before start %s", ErrInvalidRevocation, end.Format(time.RFC3339), start.Format(time.RFC3339))
+	}
+	if len(reason) > maxRevocationReasonLength {
+		return Revocation{}, fmt.Errorf("%w: reason must be at most %d bytes", ErrInvalidRevocation, maxRevocationReasonLength)
+	}
+	if err := m.CheckTime(start); err != nil {
+		return Revocation{}, fmt.Errorf("%w: start %w", ErrInvalidRevocation, err)
+	}
+	if err := m.CheckTime(end); err != nil {
+		return Revocation{}, fmt.Errorf("%w: end %w", ErrInvalidRevocation, err)
+	}
+	r := Revocation{
+		Start:   start.UTC().Truncate(secretInterval),
+		End:     end.UTC().Truncate(secretInterval).Add(secretInterval),
+		Reason:  reason,
+		Revoked: time.Now().UTC(),
+	}
+	if err := m.revocations.Add(r); err != nil {
+		return Revocation{}, err
+	}
+	return r, nil
+}
+
+// Returns the PKI's revoked time ranges, in the order they were revoked.
+func (m *KeyManager) Revocations() []Revocation {
+	return m.revocations.List()
+}
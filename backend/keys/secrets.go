@@ -2,11 +2,15 @@ package keys
 
 import (
 	"crypto/rand"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"os"
 	"path"
+	"strconv"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/google/uuid"
@@ -31,16 +35,79 @@ const (
 
 	// Creation mode for info and secret files.
 	secretMode = 0o400
+
+	// Names of PKI configuration files in the secrets directory.
+	nameFile      = "name"
+	uuidFile      = "uuid"
+	algorithmFile = "algorithm"
+	schemeFile    = "secret_scheme"
+)
+
+const (
+	// Number of secrets checked concurrently when a PKI is loaded.
+	scanWorkers = 16
+	// How often the progress of checking a PKI's secrets is logged.
+	scanProgressInterval = 10 * time.Second
 )
 
+// Files in the secrets directory that are not secrets.
+var configFiles = map[string]bool{
+	nameFile:             true,
+	uuidFile:             true,
+	algorithmFile:        true,
+	schemeFile:           true,
+	kdfVersionFile:       true,
+	hkdfSaltFile:         true,
+	hkdfContextFile:      true,
+	masterSecretFile:     true,
+	masterSecretHashFile: true,
+	layoutFile:           true,
+	eventsFile:           true,
+	deletionsFile:        true,
+	revocationsFile:      true,
+	transparencyLogFile:  true,
+	signingKeyFile:       true,
+}
+
 // Associates each time with a root secret.
 type secretManager struct {
 	dir string
 
 	name  string
 	pkiID uuid.UUID
+	alg   Algorithm
+	// KDF version under which the PKI's keys are derived.
+	kdfVersion int
+	// HKDF customization of the PKI's keys.
+	hkdfSalt    []byte
+	hkdfContext string
+
+	scheme SecretScheme
+	// Master secret of a PKI with hierarchical secrets.
+	master []byte
+	// Arrangement of secret files of a PKI with per-interval secrets.
+	layout SecretLayout
+	// Storage of the secrets of a PKI with per-interval secrets.
+	store SecretStore
+	// Whether secrets whose creation was recorded are also read when checking that secrets exist.
+	verifyAll bool
+
+	// Whether all secrets in the PKI's time range are known to exist.
+	ready atomic.Bool
+	// While not ready, the Unix time of the earliest bucket that may not have been generated yet.
+	frontier atomic.Int64
+
+	// Guards deletedBefore, and is held for reading while a secret is created, so that a secret is
+	// never created once it has been deleted.
+	deletedMu sync.RWMutex
+	// Start of the first bucket whose secret has not been deleted under a retention policy, or the
+	// zero time if no secret has been deleted.
+	deletedBefore time.Time
 }
 
+// Returned when a secret is requested before it has been generated.
+var ErrNotReady = errors.New("secret not generated yet")
+
 // Constructs a new secret manager using the given working directory.
 func newSecretManager(options PKIOptions, dir string) (*secretManager, error) {
 	// Create secrets directory if it does not already exist.
@@ -48,11 +115,23 @@ func newSecretManager(options PKIOptions, dir string) (*secretManager, error) {
 		return nil, fmt.Errorf("failed to initialize secrets directory: %w", err)
 	}
 
+	// Whether the PKI was created by an earlier call. Existing PKIs that predate recorded KDF
+	// versions use version 1, and existing PKIs can't gain HKDF customization.
+	_, existing, err := tryReadFile(path.Join(dir, uuidFile))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read PKI ID: %w", err)
+	}
+	// Customization is checked first, so that a PKI isn't left half-created if it is invalid.
+	salt, context, err := loadHKDFCustomization(dir, existing, options.HKDFSalt, options.HKDFContext)
+	if err != nil {
+		return nil, err
+	}
+
 	// Detemine PKI name. Fail if the name is not provided by at least one of `options`` and "name"
 	// file.
 	name, err := syncrhonizeConfig(
 		newMemSource(options.Name),
-		newFileSource(path.Join(dir, "name")),
+		newFileSource(path.Join(dir, nameFile)),
 	)
 	if err != nil {
 		return nil, fmt.Errorf("failed to determine PKI name: %w", err)
@@ -66,7 +145,7 @@ func newSecretManager(options PKIOptions, dir string) (*secretManager, error) {
 	}
 	idStr, err := syncrhonizeConfig(
 		newMemSource(mem),
-		newFileSource(path.Join(dir, "uuid")),
+		newFileSource(path.Join(dir, uuidFile)),
 		newGenSource(func() (string, error) {
 			u := uuid.New()
 			log.Printf("Created new PKI ID: %s", u)
@@ -81,29 +160,262 @@ func newSecretManager(options PKIOptions, dir string) (*secretManager, error) {
 		return nil, fmt.Errorf("invalid UUID: %w", err)
 	}
 
-	// Ensure that all secrets we might need exist.
-	for t := options.MinTime.UTC().Truncate(secretInterval); t.Compare(options.MaxTime) <= 0; t = t.Add(secretInterval) {
-		path := path.Join(dir, t.Format(fileNameLayout))
+	// Determine key algorithm. This can be provided by `options` or the "algorithm" file. PKIs that
+	// predate algorithm selection use P-256.
+	algStr, err := syncrhonizeConfig(
+		newMemSource(string(options.Algorithm)),
+		newFileSource(path.Join(dir, algorithmFile)),
+		newGenSource(func() (string, error) {
+			return string(AlgorithmP256), nil
+		}),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to determine key algorithm: %w", err)
+	}
+	alg, err := parseAlgorithm(algStr)
+	if err != nil {
+		return nil, err
+	}
 
-		_, ok, err := tryReadFile(path)
-		if err != nil {
-			return nil, fmt.Errorf("secret file %s is corrupted: %w", path, err)
+	// Determine secret scheme. This can be provided by `options` or the "secret_scheme" file. PKIs
+	// that predate secret schemes have per-interval secrets.
+	schemeStr, err := syncrhonizeConfig(
+		newMemSource(string(options.SecretScheme)),
+		newFileSource(path.Join(dir, schemeFile)),
+		newGenSource(func() (string, error) {
+			return string(SecretSchemePerInterval), nil
+		}),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to determine secret scheme: %w", err)
+	}
+	scheme, err := parseSecretScheme(schemeStr)
+	if err != nil {
+		return nil, err
+	}
+	if len(options.MasterSecretShares) > 0 && scheme != SecretSchemeHierarchical {
+		return nil, fmt.Errorf("master secret shares require the %s secret scheme", SecretSchemeHierarchical)
+	}
+	if len(options.Replicas) > 0 && scheme == SecretSchemeHierarchical {
+		return nil, fmt.Errorf("secret replicas aren't supported with the %s secret scheme", SecretSchemeHierarchical)
+	}
+	if options.Retention > 0 {
+		if scheme == SecretSchemeHierarchical {
+			return nil, fmt.Errorf("retention policies aren't supported with the %s secret scheme, whose secrets are all derived from one master secret", SecretSchemeHierarchical)
+		}
+		for _, store := range append([]SecretStore{options.SecretStore}, options.Replicas...) {
+			if _, ok := store.(SecretDeleter); store != nil && !ok {
+				return nil, fmt.Errorf("retention policies require secret stores that can delete secrets, which %T can't", store)
+			}
+		}
+	}
+
+	// Determine KDF version. This can be provided by `options` or the "kdf_version" file. New PKIs
+	// use the latest version.
+	mem = ""
+	if options.KDFVersion != 0 {
+		mem = strconv.Itoa(options.KDFVersion)
+	}
+	versionStr, err := syncrhonizeConfig(
+		newMemSource(mem),
+		newFileSource(path.Join(dir, kdfVersionFile)),
+		newGenSource(func() (string, error) {
+			if existing {
+				return strconv.Itoa(kdfVersion1), nil
+			}
+			return strconv.Itoa(derivationVersion), nil
+		}),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to determine KDF version: %w", err)
+	}
+	kdfVersion, err := parseKDFVersion(versionStr)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &secretManager{
+		dir: dir, name: name, pkiID: pkiID, alg: alg,
+		kdfVersion: kdfVersion, hkdfSalt: salt, hkdfContext: context,
+		scheme: scheme, verifyAll: options.VerifySecrets,
+	}
+	if s.layout, err = loadSecretLayout(dir, options.SecretLayout); err != nil {
+		return nil, err
+	}
+	if s.deletedBefore, err = loadDeletions(dir); err != nil {
+		return nil, fmt.Errorf("failed to load secret deletion records: %w", err)
+	}
+	s.store = options.SecretStore
+	if _, ok := s.store.(*MemStore); ok {
+		// Metadata in the secrets directory may outlive the secrets of an in-memory store, so it
+		// can't vouch for them.
+		s.verifyAll = true
+	}
+	if s.store == nil {
+		s.store = &dirStore{dir: dir, layout: s.layout}
+	}
+	if len(options.Replicas) > 0 {
+		replicated := &replicatedStore{primary: s.store, replicas: options.Replicas}
+		if err := replicated.sync(s.deletedBefore); err != nil {
+			return nil, fmt.Errorf("failed to synchronize secret replicas: %w", err)
 		}
-		if ok {
-			continue
+		s.store = replicated
+	}
+	if options.KMS != nil {
+		s.store = &envelopeStore{inner: s.store, kms: options.KMS}
+	}
+	s.store = &checksumStore{inner: s.store}
+	if err := s.finishDeletions(); err != nil {
+		return nil, err
+	}
+	if scheme == SecretSchemeHierarchical {
+		// Every secret is derived on demand from the master secret, so there is nothing to generate.
+		if s.master, err = loadMasterSecret(dir, options.MasterSecretShares); err != nil {
+			return nil, err
 		}
+		s.ready.Store(true)
+		return s, nil
+	}
+	if options.BackgroundGeneration {
+		s.frontier.Store(options.MinTime.UTC().Truncate(secretInterval).Unix())
+		go s.generate(options.MinTime, options.MaxTime, s.ensureSecret)
+		return s, nil
+	}
+
+	// Ensure that all secrets we might need exist.
+	if err := s.ensureSecrets(options.MinTime, options.MaxTime); err != nil {
+		return nil, err
+	}
+	s.ready.Store(true)
+
+	return s, nil
+}
+
+// Creates the secret for the bucket starting at the given time, if it does not already exist.
+func (s *secretManager) ensureSecret(t time.Time) error {
+	if s.scheme == SecretSchemeHierarchical {
+		return nil
+	}
+	bucket := t.Truncate(secretInterval).UTC()
+	s.deletedMu.RLock()
+	defer s.deletedMu.RUnlock()
+	if bucket.Before(s.deletedBefore) {
+		return nil
+	}
+
+	// A secret whose creation was recorded was verified as it was stored, so it isn't read again;
+	// reading every secret makes starting a large PKI slow, especially on network file systems.
+	// Reading other secrets verifies their checksums, so generation also checks existing secrets.
+	if !s.verifyAll && s.hasValidMetadata(bucket) {
+		return nil
+	}
+	existing, ok, err := s.store.Get(bucket)
+	if err != nil {
+		return err
+	}
+	if ok {
+		clear(existing)
+		return nil
+	}
+
+	log.Printf("Creating new secret for %s", bucket.Format(time.RFC3339))
+	secret := make([]byte, secretSize)
+	defer clear(secret)
+	if _, err := io.ReadFull(rand.Reader, secret); err != nil {
+		return fmt.Errorf("insufficient entropy: %w", err)
+	}
+	err = s.store.Put(bucket, secret)
+	if errors.Is(err, ErrSecretExists) {
+		// Another process sharing the store created the secret first, which is just as good.
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	s.recordCreation(bucket, secret)
+	return nil
+}
+
+// Ensures that all secrets in [minTime, maxTime] exist, checking up to scanWorkers secrets at once.
+// Progress is logged periodically, since scanning a large PKI may take a while. Returns the first
+// error encountered, after which no further secrets are checked.
+func (s *secretManager) ensureSecrets(minTime, maxTime time.Time) error {
+	var buckets []time.Time
+	for t := minTime.UTC().Truncate(secretInterval); t.Compare(maxTime) <= 0; t = t.Add(secretInterval) {
+		buckets = append(buckets, t)
+	}
+	start := time.Now()
 
-		log.Printf("Creating new secret file: %s", path)
-		secret := make([]byte, secretSize)
-		if _, err := io.ReadFull(rand.Reader, secret); err != nil {
-			return nil, fmt.Errorf("insufficient entropy: %w", err)
+	var (
+		next, checked atomic.Int64
+		failed        atomic.Bool
+		errOnce       sync.Once
+		firstErr      error
+		wg            sync.WaitGroup
+	)
+	for range min(scanWorkers, len(buckets)) {
+		wg.Go(func() {
+			for !failed.Load() {
+				i := next.Add(1) - 1
+				if i >= int64(len(buckets)) {
+					return
+				}
+				if err := s.ensureSecret(buckets[i]); err != nil {
+					errOnce.Do(func() { firstErr = err })
+					failed.Store(true)
+					return
+				}
+				checked.Add(1)
+			}
+		})
+	}
+
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(scanProgressInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				log.Printf("Checked %d of %d secrets in %s", checked.Load(), len(buckets), s.dir)
+			}
 		}
-		if err := os.WriteFile(path, secret, secretMode); err != nil {
-			return nil, fmt.Errorf("failed to write secret file %s: %w", path, err)
+	}()
+	wg.Wait()
+	close(done)
+
+	if firstErr != nil {
+		return firstErr
+	}
+	if elapsed := time.Since(start); elapsed >= scanProgressInterval {
+		log.Printf("Checked %d secrets in %s in %v", len(buckets), s.dir, elapsed)
+	}
+	return nil
+}
+
+// Ensures that all secrets in [minTime, maxTime] exist, in chronological order, advancing the
+// generation frontier as it goes. Marks the manager as ready once done.
+//
+// This is intended to be run in the background. If generation fails, the manager never becomes
+// ready.
+func (s *secretManager) generate(minTime, maxTime time.Time, ensure func(time.Time) error) {
+	start := time.Now()
+	for t := minTime.UTC().Truncate(secretInterval); t.Compare(maxTime) <= 0; t = t.Add(secretInterval) {
+		if err := ensure(t); err != nil {
+			log.Printf("ERROR: Background secret generation failed: %+v", err)
+			return
 		}
+		s.frontier.Store(t.Add(secretInterval).Unix())
 	}
+	s.ready.Store(true)
+	log.Printf("Background secret generation finished in %v", time.Since(start))
+}
 
-	return &secretManager{dir: dir, name: name, pkiID: pkiID}, nil
+// Whether all secrets in the PKI's time range are known to exist.
+func (s *secretManager) Ready() bool {
+	return s.ready.Load()
 }
 
 // The PKI name of this directory.
@@ -116,6 +428,26 @@ func (s *secretManager) PKIID() uuid.UUID {
 	return s.pkiID
 }
 
+// The secret scheme of this directory.
+func (s *secretManager) SecretScheme() SecretScheme {
+	return s.scheme
+}
+
+// The key algorithm of this directory.
+func (s *secretManager) Algorithm() Algorithm {
+	return s.alg
+}
+
+// The KDF version of this directory.
+func (s *secretManager) KDFVersion() int {
+	return s.kdfVersion
+}
+
+// The parameters of the key derivation function of this directory.
+func (s *secretManager) kdfParams() kdfParams {
+	return kdfParams{version: s.kdfVersion, alg: s.alg, salt: s.hkdfSalt, context: s.hkdfContext}
+}
+
 // Returns the root secret for the given time.
 //
 // Different times may share a root secret.
@@ -123,10 +455,28 @@ func (s *secretManager) PKIID() uuid.UUID {
 // Times are normalized to UTC time internally, so different time.Time values representing the same
 // absolute time are guaranteed to have the same root secret.
 func (s *secretManager) GetSecretForTime(t time.Time) ([]byte, error) {
-	file := t.Truncate(secretInterval).UTC().Format(fileNameLayout)
-	secret, err := os.ReadFile(path.Join(s.dir, file))
+	bucket := t.Truncate(secretInterval).UTC()
+	if !s.ready.Load() && bucket.Unix() >= s.frontier.Load() {
+		return nil, fmt.Errorf("%w: secret for %s", ErrNotReady, bucket.Format(time.RFC3339))
+	}
+	if err := s.checkNotDeleted(bucket); err != nil {
+		return nil, err
+	}
+	if s.scheme == SecretSchemeHierarchical {
+		return deriveHierarchicalSecret(s.master, bucket)
+	}
+
+	secret, ok, err := s.store.Get(bucket)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read secret file %s: %w", file, err)
+		return nil, err
+	}
+	if !ok {
+		return nil, fmt.Errorf("no secret for %s", bucket.Format(time.RFC3339))
 	}
 	return secret, nil
 }
+
+// Returns the start times of all buckets that have a secret, in chronological order.
+func (s *secretManager) ListBuckets() ([]time.Time, error) {
+	return s.store.List()
+}
@@ -0,0 +1,780 @@
+package keys
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"maps"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestBackgroundGeneration(t *testing.T) {
+	minTime := time.Date(2024, time.September, 1, 0, 0, 0, 0, time.UTC)
+	maxTime := minTime.Add(3 * secretInterval)
+
+	dir := t.TempDir()
+	s := &secretManager{dir: dir, store: &dirStore{dir: dir}}
+	s.frontier.Store(minTime.Unix())
+
+	// Pause generation after the first secret has been generated.
+	reached := make(chan struct{})
+	gate := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		s.generate(minTime, maxTime, func(bucket time.Time) error {
+			if bucket.Equal(minTime.Add(secretInterval)) {
+				close(reached)
+				<-gate
+			}
+			return s.ensureSecret(bucket)
+		})
+		close(done)
+	}()
+
+	<-reached
+	if s.Ready() {
+		t.Errorf("Secret manager is ready before generation finished")
+	}
+	if _, err := s.GetSecretForTime(minTime); err != nil {
+		t.Errorf("Failed to get generated secret for %s: %+v", minTime.Format(time.RFC3339), err)
+	}
+	if _, err := s.GetSecretForTime(maxTime); !errors.Is(err, ErrNotReady) {
+		t.Errorf("Got wrong error for ungenerated secret for %s: got %v, want %v", maxTime.Format(time.RFC3339), err, ErrNotReady)
+	}
+
+	close(gate)
+	<-done
+	if !s.Ready() {
+		t.Errorf("Secret manager is not ready after generation finished")
+	}
+	if _, err := s.GetSecretForTime(maxTime); err != nil {
+		t.Errorf("Failed to get generated secret for %s: %+v", maxTime.Format(time.RFC3339), err)
+	}
+}
+
+func TestListBuckets(t *testing.T) {
+	minTime := time.Date(2024, time.September, 1, 0, 30, 0, 0, time.UTC)
+	maxTime := minTime.Add(2 * secretInterval)
+	want := []time.Time{
+		time.Date(2024, time.September, 1, 0, 0, 0, 0, time.UTC),
+		time.Date(2024, time.September, 1, 1, 0, 0, 0, time.UTC),
+		time.Date(2024, time.September, 1, 2, 0, 0, 0, time.UTC),
+	}
+
+	dir := t.TempDir()
+	s, err := newSecretManager(PKIOptions{Name: "List Test", MinTime: minTime, MaxTime: maxTime}, dir)
+	if err != nil {
+		t.Fatalf("Failed to initialize secret manager: %+v", err)
+	}
+	if err := os.WriteFile(path.Join(dir, "README"), []byte("not a secret\n"), 0o644); err != nil {
+		t.Fatalf("Failed to write extra file: %+v", err)
+	}
+
+	got, err := s.ListBuckets()
+	if err != nil {
+		t.Fatalf("Failed to list buckets: %+v", err)
+	}
+	if !slices.EqualFunc(got, want, time.Time.Equal) {
+		t.Errorf("Listed wrong buckets: got %v, want %v", got, want)
+	}
+}
+
+func TestHierarchicalSecrets(t *testing.T) {
+	minTime := time.Date(2024, time.September, 1, 0, 0, 0, 0, time.UTC)
+	opts := PKIOptions{
+		Name:         "Hierarchy Test",
+		MinTime:      minTime,
+		MaxTime:      minTime.Add(365 * 24 * time.Hour),
+		SecretScheme: SecretSchemeHierarchical,
+	}
+
+	dir := t.TempDir()
+	s, err := newSecretManager(opts, dir)
+	if err != nil {
+		t.Fatalf("Failed to initialize secret manager: %+v", err)
+	}
+	if !s.Ready() {
+		t.Errorf("Secret manager with hierarchical secrets is not ready")
+	}
+	buckets, err := s.ListBuckets()
+	if err != nil {
+		t.Fatalf("Failed to list buckets: %+v", err)
+	}
+	if len(buckets) != 0 {
+		t.Errorf("Secret manager with hierarchical secrets created %d secret files, want none", len(buckets))
+	}
+
+	first, err := s.GetSecretForTime(minTime.Add(30 * time.Minute))
+	if err != nil {
+		t.Fatalf("Failed to get secret: %+v", err)
+	}
+	next, err := s.GetSecretForTime(minTime.Add(secretInterval))
+	if err != nil {
+		t.Fatalf("Failed to get secret: %+v", err)
+	}
+	if slices.Equal(first, next) {
+		t.Errorf("Consecutive buckets have the same secret")
+	}
+
+	// The scheme is recorded, so it need not be given again.
+	opts.SecretScheme = ""
+	s, err = newSecretManager(opts, dir)
+	if err != nil {
+		t.Fatalf("Failed to reinitialize secret manager: %+v", err)
+	}
+	if s.SecretScheme() != SecretSchemeHierarchical {
+		t.Errorf("Reloaded secret manager has scheme %q, want %q", s.SecretScheme(), SecretSchemeHierarchical)
+	}
+	again, err := s.GetSecretForTime(minTime)
+	if err != nil {
+		t.Fatalf("Failed to get secret: %+v", err)
+	}
+	if !slices.Equal(first, again) {
+		t.Errorf("Secret changed after reloading the master secret")
+	}
+}
+
+// Checks that the secret derived for a fixed master secret and time has not changed.
+func TestHierarchicalSecretStability(t *testing.T) {
+	const want = "233a2b33051462fa1b35cedfeaad7b31325b63b83652b6f3299175829bf9467b"
+
+	master := make([]byte, secretSize)
+	for i := range master {
+		master[i] = byte(i)
+	}
+	secret, err := deriveHierarchicalSecret(master, time.Date(2024, time.September, 1, 23, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatalf("Failed to derive secret: %+v", err)
+	}
+	if got := hex.EncodeToString(secret); got != want {
+		t.Errorf("Hierarchical secret derivation has changed: got %s, want %s", got, want)
+	}
+}
+
+func TestSecretLayoutMigration(t *testing.T) {
+	minTime := time.Date(2024, time.September, 30, 22, 0, 0, 0, time.UTC)
+	maxTime := minTime.Add(3 * secretInterval)
+	opts := PKIOptions{Name: "Layout Test", MinTime: minTime, MaxTime: maxTime}
+	dir := t.TempDir()
+
+	s, err := newSecretManager(opts, dir)
+	if err != nil {
+		t.Fatalf("Failed to initialize secret manager: %+v", err)
+	}
+	want, err := s.ListBuckets()
+	if err != nil {
+		t.Fatalf("Failed to list buckets: %+v", err)
+	}
+	secrets := map[time.Time][]byte{}
+	for _, b := range want {
+		if secrets[b], err = s.GetSecretForTime(b); err != nil {
+			t.Fatalf("Failed to get secret for %s: %+v", b.Format(time.RFC3339), err)
+		}
+	}
+
+	for _, tc := range []struct {
+		layout SecretLayout
+		want   SecretLayout
+		// A file that must exist after loading, relative to the secrets directory.
+		file string
+	}{
+		{SecretLayoutSharded, SecretLayoutSharded, "2024/10/2024-10-01@01.00.00"},
+		{"", SecretLayoutSharded, "2024/09/2024-09-30@22.00.00"},
+		{SecretLayoutFlat, SecretLayoutFlat, "2024-10-01@01.00.00"},
+	} {
+		opts.SecretLayout = tc.layout
+		s, err := newSecretManager(opts, dir)
+		if err != nil {
+			t.Fatalf("Failed to load secret manager with layout %q: %+v", tc.layout, err)
+		}
+		if s.layout != tc.want {
+			t.Errorf("Loaded layout %q, want %q", s.layout, tc.want)
+		}
+		if _, err := os.Stat(path.Join(dir, tc.file)); err != nil {
+			t.Errorf("Secret file %s is missing in the %s layout: %+v", tc.file, tc.want, err)
+		}
+		got, err := s.ListBuckets()
+		if err != nil {
+			t.Fatalf("Failed to list buckets: %+v", err)
+		}
+		if !slices.EqualFunc(got, want, time.Time.Equal) {
+			t.Errorf("Listed wrong buckets in the %s layout: got %v, want %v", tc.want, got, want)
+		}
+		for _, b := range want {
+			secret, err := s.GetSecretForTime(b)
+			if err != nil {
+				t.Fatalf("Failed to get secret for %s in the %s layout: %+v", b.Format(time.RFC3339), tc.want, err)
+			}
+			if !slices.Equal(secret, secrets[b]) {
+				t.Errorf("Secret for %s changed in the %s layout", b.Format(time.RFC3339), tc.want)
+			}
+		}
+	}
+	if _, err := os.Stat(path.Join(dir, "2024")); err == nil {
+		t.Errorf("Shards remain after migrating to the flat layout")
+	}
+}
+
+// KMS that wraps data keys by XORing them with a fixed key.
+type xorKMS struct {
+	key byte
+}
+
+func (k xorKMS) Encrypt(plaintext []byte) ([]byte, error) {
+	out := make([]byte, len(plaintext))
+	for i, b := range plaintext {
+		out[i] = b ^ k.key
+	}
+	return out, nil
+}
+
+func (k xorKMS) Decrypt(ciphertext []byte) ([]byte, error) {
+	return k.Encrypt(ciphertext)
+}
+
+func TestEnvelopeEncryption(t *testing.T) {
+	minTime := time.Date(2024, time.September, 1, 0, 0, 0, 0, time.UTC)
+	maxTime := minTime.Add(2 * secretInterval)
+	dir := t.TempDir()
+	layout := SecretLayoutSharded
+
+	// A secret written before encryption was enabled.
+	legacy := []byte("legacy plaintext secret, 32 byte")
+	if err := (&dirStore{dir: dir, layout: layout}).Put(minTime, legacy); err != nil {
+		t.Fatalf("Failed to write legacy secret: %+v", err)
+	}
+
+	s, err := newSecretManager(PKIOptions{Name: "Envelope Test", MinTime: minTime, MaxTime: maxTime, SecretLayout: layout, KMS: xorKMS{0x5c}}, dir)
+	if err != nil {
+		t.Fatalf("Failed to initialize secret manager: %+v", err)
+	}
+	got, err := s.GetSecretForTime(minTime)
+	if err != nil {
+		t.Fatalf("Failed to get legacy secret: %+v", err)
+	}
+	if !slices.Equal(got, legacy) {
+		t.Errorf("Got wrong legacy secret: got %q, want %q", got, legacy)
+	}
+
+	bucket := minTime.Add(secretInterval)
+	secret, err := s.GetSecretForTime(bucket)
+	if err != nil {
+		t.Fatalf("Failed to get secret for %s: %+v", bucket.Format(time.RFC3339), err)
+	}
+	stored, err := os.ReadFile(path.Join(dir, layout.secretPath(bucket)))
+	if err != nil {
+		t.Fatalf("Failed to read secret file: %+v", err)
+	}
+	if !strings.HasPrefix(string(stored), envelopeMagic) || strings.Contains(string(stored), string(secret)) {
+		t.Errorf("Secret for %s is not encrypted at rest", bucket.Format(time.RFC3339))
+	}
+
+	// Encrypted secrets are bound to their bucket.
+	moved := maxTime
+	if err := os.WriteFile(path.Join(dir, layout.secretPath(moved)), stored, secretMode); err != nil {
+		t.Fatalf("Failed to copy secret: %+v", err)
+	}
+	if _, err := s.GetSecretForTime(moved); err == nil {
+		t.Errorf("Decrypted a secret moved to another bucket")
+	}
+
+	// Secrets can't be read without the KMS key.
+	wrong := &envelopeStore{inner: &dirStore{dir: dir, layout: layout}, kms: xorKMS{0x36}}
+	if _, _, err := wrong.Get(bucket); err == nil {
+		t.Errorf("Decrypted a secret with the wrong KMS key")
+	}
+}
+
+// Secret store that keeps secrets in a map. Tests may access the map directly while the store isn't
+// in use.
+type mapStore map[time.Time][]byte
+
+// Guards all mapStores, since secrets are checked concurrently when a PKI is loaded.
+var mapStoreMu sync.Mutex
+
+func (m mapStore) Get(bucket time.Time) ([]byte, bool, error) {
+	mapStoreMu.Lock()
+	defer mapStoreMu.Unlock()
+	secret, ok := m[bucket]
+	return bytes.Clone(secret), ok, nil
+}
+
+func (m mapStore) Put(bucket time.Time, secret []byte) error {
+	mapStoreMu.Lock()
+	defer mapStoreMu.Unlock()
+	if _, ok := m[bucket]; ok {
+		return ErrSecretExists
+	}
+	m[bucket] = bytes.Clone(secret)
+	return nil
+}
+
+func (m mapStore) List() ([]time.Time, error) {
+	mapStoreMu.Lock()
+	defer mapStoreMu.Unlock()
+	buckets := slices.Collect(maps.Keys(m))
+	slices.SortFunc(buckets, time.Time.Compare)
+	return buckets, nil
+}
+
+func TestCustomSecretStore(t *testing.T) {
+	minTime := time.Date(2024, time.September, 1, 0, 0, 0, 0, time.UTC)
+	maxTime := minTime.Add(2 * secretInterval)
+	dir := t.TempDir()
+	store := mapStore{}
+
+	s, err := newSecretManager(PKIOptions{Name: "Store Test", MinTime: minTime, MaxTime: maxTime, SecretStore: store}, dir)
+	if err != nil {
+		t.Fatalf("Failed to initialize secret manager: %+v", err)
+	}
+	if len(store) != 3 {
+		t.Errorf("Store holds %d secrets, want 3", len(store))
+	}
+	buckets, err := listBuckets(dir, SecretLayoutFlat)
+	if err != nil {
+		t.Fatalf("Failed to list secrets directory: %+v", err)
+	}
+	if len(buckets) != 0 {
+		t.Errorf("Secrets directory holds secret files for %v", buckets)
+	}
+	secret, err := s.GetSecretForTime(minTime)
+	if err != nil {
+		t.Fatalf("Failed to get secret: %+v", err)
+	}
+	if !slices.Equal(secret, store[minTime][len(checksumMagic):len(checksumMagic)+secretSize]) {
+		t.Errorf("Got secret that isn't in the store")
+	}
+}
+
+func TestShamir(t *testing.T) {
+	secret := []byte("a thirty-two byte master secret!")
+	shares, err := splitSecret(secret, 5, 3)
+	if err != nil {
+		t.Fatalf("Failed to split secret: %+v", err)
+	}
+	// Every subset of three shares reconstructs the secret.
+	for i := range shares {
+		for j := i + 1; j < len(shares); j++ {
+			for k := j + 1; k < len(shares); k++ {
+				encoded := []string{shares[k].String(), shares[i].String(), shares[j].String()}
+				subset := make([]share, len(encoded))
+				for n, e := range encoded {
+					if subset[n], err = parseShare(e); err != nil {
+						t.Fatalf("Failed to parse share: %+v", err)
+					}
+				}
+				got, err := combineShares(subset)
+				if err != nil {
+					t.Fatalf("Failed to combine shares: %+v", err)
+				}
+				if !slices.Equal(got, secret) {
+					t.Errorf("Shares %d, %d, and %d reconstructed %q, want %q", i+1, j+1, k+1, got, secret)
+				}
+			}
+		}
+	}
+
+	if _, err := combineShares(shares[:2]); err == nil {
+		t.Errorf("Combined fewer shares than the threshold")
+	}
+	if _, err := combineShares([]share{shares[0], shares[0], shares[1]}); err == nil {
+		t.Errorf("Combined a repeated share")
+	}
+	for _, tc := range []struct{ n, threshold int }{{5, 1}, {3, 4}, {256, 2}} {
+		if _, err := splitSecret(secret, tc.n, tc.threshold); err == nil {
+			t.Errorf("Split into %d shares with threshold %d", tc.n, tc.threshold)
+		}
+	}
+}
+
+func TestMasterSecretShares(t *testing.T) {
+	minTime := time.Date(2024, time.September, 1, 0, 0, 0, 0, time.UTC)
+	opts := PKIOptions{Name: "Shares Test", MinTime: minTime, MaxTime: minTime.Add(24 * time.Hour), SecretScheme: SecretSchemeHierarchical}
+	dir := t.TempDir()
+	s, err := newSecretManager(opts, dir)
+	if err != nil {
+		t.Fatalf("Failed to initialize secret manager: %+v", err)
+	}
+	want, err := s.GetSecretForTime(minTime)
+	if err != nil {
+		t.Fatalf("Failed to get secret: %+v", err)
+	}
+
+	shares, err := SplitMasterSecret(dir, 3, 2)
+	if err != nil {
+		t.Fatalf("Failed to split master secret: %+v", err)
+	}
+	if err := os.Remove(path.Join(dir, masterSecretFile)); err != nil {
+		t.Fatal(err)
+	}
+	opts.MasterSecretShares = []string{shares[2], shares[0]}
+	s, err = newSecretManager(opts, dir)
+	if err != nil {
+		t.Fatalf("Failed to initialize secret manager from shares: %+v", err)
+	}
+	got, err := s.GetSecretForTime(minTime)
+	if err != nil {
+		t.Fatalf("Failed to get secret: %+v", err)
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("Secret changed after reconstructing the master secret")
+	}
+	if _, err := os.Stat(path.Join(dir, masterSecretFile)); err == nil {
+		t.Errorf("Master secret was written to disk after reconstruction")
+	}
+
+	// Shares of another sharing are rejected.
+	other, err := splitSecret(make([]byte, secretSize), 3, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts.MasterSecretShares = []string{other[0].String(), other[1].String()}
+	if _, err := newSecretManager(opts, dir); err == nil {
+		t.Errorf("Accepted shares that reconstruct the wrong master secret")
+	}
+	opts.MasterSecretShares = shares[:1]
+	if _, err := newSecretManager(opts, dir); err == nil {
+		t.Errorf("Accepted too few shares")
+	}
+}
+
+func TestSecretChecksums(t *testing.T) {
+	minTime := time.Date(2024, time.September, 1, 0, 0, 0, 0, time.UTC)
+	maxTime := minTime.Add(2 * secretInterval)
+	opts := PKIOptions{Name: "Checksum Test", MinTime: minTime, MaxTime: maxTime}
+	dir := t.TempDir()
+	s, err := newSecretManager(opts, dir)
+	if err != nil {
+		t.Fatalf("Failed to initialize secret manager: %+v", err)
+	}
+
+	bucket := minTime.Add(secretInterval)
+	file := path.Join(dir, s.layout.secretPath(bucket))
+	stored, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, corrupted := range map[string][]byte{
+		"bit flip":  append(slices.Clone(stored[:10]), append([]byte{stored[10] ^ 1}, stored[11:]...)...),
+		"truncated": stored[:len(stored)-1],
+		"empty":     {},
+	} {
+		// Secret files are read-only, so replace rather than overwrite them.
+		os.Remove(file)
+		if err := os.WriteFile(file, corrupted, secretMode); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := s.GetSecretForTime(bucket); !errors.Is(err, ErrCorruptSecret) {
+			t.Errorf("Got wrong error for %s secret: got %v, want %v", name, err, ErrCorruptSecret)
+		}
+		// The secret's creation was recorded, so it is only read when loading with verification.
+		if _, err := newSecretManager(opts, dir); err != nil {
+			t.Errorf("Failed to load PKI with %s secret whose creation was recorded: %+v", name, err)
+		}
+		verifyOpts := opts
+		verifyOpts.VerifySecrets = true
+		if _, err := newSecretManager(verifyOpts, dir); !errors.Is(err, ErrCorruptSecret) {
+			t.Errorf("Got wrong error for loading PKI with %s secret: got %v, want %v", name, err, ErrCorruptSecret)
+		}
+	}
+	if err := os.RemoveAll(path.Join(dir, metadataDir)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := newSecretManager(opts, dir); !errors.Is(err, ErrCorruptSecret) {
+		t.Errorf("Got wrong error for loading PKI with corrupted secret without metadata: got %v, want %v", err, ErrCorruptSecret)
+	}
+
+	// Master secrets are checked against their recorded hash.
+	opts = PKIOptions{Name: "Master Checksum Test", MinTime: minTime, MaxTime: maxTime, SecretScheme: SecretSchemeHierarchical}
+	dir = t.TempDir()
+	if _, err := newSecretManager(opts, dir); err != nil {
+		t.Fatalf("Failed to initialize secret manager: %+v", err)
+	}
+	os.Remove(path.Join(dir, masterSecretFile))
+	if err := os.WriteFile(path.Join(dir, masterSecretFile), make([]byte, secretSize), secretMode); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := newSecretManager(opts, dir); !errors.Is(err, ErrCorruptSecret) {
+		t.Errorf("Got wrong error for loading PKI with corrupted master secret: got %v, want %v", err, ErrCorruptSecret)
+	}
+}
+
+func TestAtomicSecretWrites(t *testing.T) {
+	minTime := time.Date(2024, time.September, 1, 0, 0, 0, 0, time.UTC)
+	maxTime := minTime.Add(2 * secretInterval)
+	opts := PKIOptions{Name: "Atomic Test", MinTime: minTime, MaxTime: maxTime}
+	dir := t.TempDir()
+	s, err := newSecretManager(opts, dir)
+	if err != nil {
+		t.Fatalf("Failed to initialize secret manager: %+v", err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		if strings.HasSuffix(e.Name(), tempSuffix) {
+			t.Errorf("Temporary file %s left behind", e.Name())
+		}
+	}
+
+	// Existing secrets are never overwritten.
+	store := &dirStore{dir: dir, layout: s.layout}
+	if err := store.Put(minTime, make([]byte, secretSize)); !errors.Is(err, ErrSecretExists) {
+		t.Errorf("Got wrong error for overwriting secret: got %v, want %v", err, ErrSecretExists)
+	}
+
+	// A temporary file left behind by a crash is ignored.
+	tmp := path.Join(dir, path.Base(s.layout.secretPath(maxTime.Add(secretInterval)))+".123"+tempSuffix)
+	if err := os.WriteFile(tmp, nil, secretMode); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := newSecretManager(opts, dir); err != nil {
+		t.Errorf("Failed to load PKI with leftover temporary file: %+v", err)
+	}
+	got, err := s.ListBuckets()
+	if err != nil {
+		t.Fatalf("Failed to list buckets: %+v", err)
+	}
+	if want := []time.Time{minTime, minTime.Add(secretInterval), maxTime}; !slices.EqualFunc(got, want, time.Time.Equal) {
+		t.Errorf("Listed wrong buckets: got %v, want %v", got, want)
+	}
+}
+
+func TestPKIBundle(t *testing.T) {
+	minTime := time.Date(2024, time.September, 1, 0, 0, 0, 0, time.UTC)
+	maxTime := minTime.Add(2 * secretInterval)
+	opts := PKIOptions{Name: "Bundle Test", MinTime: minTime, MaxTime: maxTime, SecretLayout: SecretLayoutSharded}
+	dir := t.TempDir()
+	s, err := newSecretManager(opts, dir)
+	if err != nil {
+		t.Fatalf("Failed to initialize secret manager: %+v", err)
+	}
+
+	var bundle bytes.Buffer
+	if err := ExportPKI(dir, &bundle, BundleOptions{Passphrase: []byte("correct horse")}); err != nil {
+		t.Fatalf("Failed to export PKI: %+v", err)
+	}
+
+	// Import into a different store, as when migrating to another server.
+	store := mapStore{}
+	importDir := path.Join(t.TempDir(), "imported")
+	if err := ImportPKI(bytes.NewReader(bundle.Bytes()), importDir, BundleOptions{Passphrase: []byte("correct horse"), SecretStore: store}); err != nil {
+		t.Fatalf("Failed to import PKI: %+v", err)
+	}
+	opts.SecretStore = store
+	imported, err := newSecretManager(opts, importDir)
+	if err != nil {
+		t.Fatalf("Failed to load imported PKI: %+v", err)
+	}
+	if imported.pkiID != s.pkiID {
+		t.Errorf("Imported PKI has wrong UUID: got %v, want %v", imported.pkiID, s.pkiID)
+	}
+	if len(store) != 3 {
+		t.Errorf("Store holds %d secrets, want 3", len(store))
+	}
+	for _, bucket := range []time.Time{minTime, minTime.Add(secretInterval), maxTime} {
+		want, err := s.GetSecretForTime(bucket)
+		if err != nil {
+			t.Fatalf("Failed to get secret: %+v", err)
+		}
+		got, err := imported.GetSecretForTime(bucket)
+		if err != nil {
+			t.Fatalf("Failed to get imported secret: %+v", err)
+		}
+		if !slices.Equal(got, want) {
+			t.Errorf("Imported wrong secret for %v", bucket)
+		}
+	}
+
+	if err := ImportPKI(bytes.NewReader(bundle.Bytes()), t.TempDir(), BundleOptions{Passphrase: []byte("battery staple")}); err == nil {
+		t.Errorf("Imported PKI with wrong passphrase")
+	}
+	tampered := bytes.Clone(bundle.Bytes())
+	tampered[len(tampered)-1] ^= 1
+	if err := ImportPKI(bytes.NewReader(tampered), t.TempDir(), BundleOptions{Passphrase: []byte("correct horse")}); err == nil {
+		t.Errorf("Imported tampered PKI bundle")
+	}
+	if err := ImportPKI(bytes.NewReader(bundle.Bytes()), dir, BundleOptions{Passphrase: []byte("correct horse")}); err == nil {
+		t.Errorf("Imported PKI over an existing PKI")
+	}
+}
+
+func TestSecretReplication(t *testing.T) {
+	minTime := time.Date(2024, time.September, 1, 0, 0, 0, 0, time.UTC)
+	maxTime := minTime.Add(2 * secretInterval)
+	buckets := []time.Time{minTime, minTime.Add(secretInterval), maxTime}
+	dir := t.TempDir()
+	primary := mapStore{}
+	diskReplica, err := NewDirStore(path.Join(t.TempDir(), "replica"), SecretLayoutSharded)
+	if err != nil {
+		t.Fatalf("Failed to create replica: %+v", err)
+	}
+	mapReplica := mapStore{}
+	opts := PKIOptions{
+		Name: "Replication Test", MinTime: minTime, MaxTime: maxTime,
+		SecretStore: primary, Replicas: []SecretStore{diskReplica, mapReplica},
+	}
+	s, err := newSecretManager(opts, dir)
+	if err != nil {
+		t.Fatalf("Failed to initialize secret manager: %+v", err)
+	}
+	for _, replica := range opts.Replicas {
+		for _, bucket := range buckets {
+			got, ok, err := replica.Get(bucket)
+			if err != nil || !ok {
+				t.Fatalf("Failed to get replicated secret for %v: ok = %t, err = %v", bucket, ok, err)
+			}
+			if !slices.Equal(got, primary[bucket]) {
+				t.Errorf("Replica holds wrong secret for %v", bucket)
+			}
+		}
+	}
+
+	// Lost secrets are restored from replicas, both when reading and when loading.
+	want, err := s.GetSecretForTime(minTime)
+	if err != nil {
+		t.Fatalf("Failed to get secret: %+v", err)
+	}
+	delete(primary, minTime)
+	got, err := s.GetSecretForTime(minTime)
+	if err != nil {
+		t.Fatalf("Failed to get lost secret: %+v", err)
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("Got wrong secret after losing it")
+	}
+	if _, ok := primary[minTime]; !ok {
+		t.Errorf("Lost secret wasn't restored")
+	}
+	delete(primary, maxTime)
+	delete(mapReplica, minTime)
+	if _, err := newSecretManager(opts, dir); err != nil {
+		t.Fatalf("Failed to reload secret manager: %+v", err)
+	}
+	if len(primary) != 3 || len(mapReplica) != 3 {
+		t.Errorf("Stores hold %d and %d secrets after reloading, want 3", len(primary), len(mapReplica))
+	}
+
+	// Replicas that disagree with the primary are an error.
+	mapReplica[minTime] = make([]byte, len(mapReplica[minTime]))
+	if _, err := newSecretManager(opts, dir); err == nil {
+		t.Errorf("Loaded PKI with a mismatched replica")
+	}
+}
+
+// Secret store that counts reads.
+type countingStore struct {
+	SecretStore
+	gets atomic.Int64
+}
+
+func (c *countingStore) Get(bucket time.Time) ([]byte, bool, error) {
+	c.gets.Add(1)
+	return c.SecretStore.Get(bucket)
+}
+
+func TestStartupScan(t *testing.T) {
+	minTime := time.Date(2024, time.September, 1, 0, 0, 0, 0, time.UTC)
+	// More secrets than scan workers.
+	maxTime := minTime.Add(99 * secretInterval)
+	dir := t.TempDir()
+	store := &countingStore{SecretStore: &dirStore{dir: dir, layout: SecretLayoutFlat}}
+	opts := PKIOptions{Name: "Scan Test", MinTime: minTime, MaxTime: maxTime, SecretStore: store}
+	if _, err := newSecretManager(opts, dir); err != nil {
+		t.Fatalf("Failed to initialize secret manager: %+v", err)
+	}
+	buckets, err := listBuckets(dir, SecretLayoutFlat)
+	if err != nil {
+		t.Fatalf("Failed to list secrets directory: %+v", err)
+	}
+	if len(buckets) != 100 {
+		t.Fatalf("Created %d secrets, want 100", len(buckets))
+	}
+
+	// Secrets whose creation was recorded aren't read again.
+	store.gets.Store(0)
+	if _, err := newSecretManager(opts, dir); err != nil {
+		t.Fatalf("Failed to reload secret manager: %+v", err)
+	}
+	if n := store.gets.Load(); n != 0 {
+		t.Errorf("Reloading read %d secrets, want 0", n)
+	}
+
+	// Unless verification is requested, or the metadata is missing or invalid.
+	opts.VerifySecrets = true
+	if _, err := newSecretManager(opts, dir); err != nil {
+		t.Fatalf("Failed to reload secret manager: %+v", err)
+	}
+	if n := store.gets.Load(); n != 100 {
+		t.Errorf("Reloading with verification read %d secrets, want 100", n)
+	}
+	opts.VerifySecrets = false
+	if err := os.Remove(metadataPath(dir, minTime)); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(metadataPath(dir, maxTime), []byte("{}"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	store.gets.Store(0)
+	if _, err := newSecretManager(opts, dir); err != nil {
+		t.Fatalf("Failed to reload secret manager: %+v", err)
+	}
+	if n := store.gets.Load(); n != 2 {
+		t.Errorf("Reloading with missing and invalid metadata read %d secrets, want 2", n)
+	}
+
+	// Scan failures are reported.
+	if err := os.Remove(metadataPath(dir, minTime.Add(50*secretInterval))); err != nil {
+		t.Fatal(err)
+	}
+	file := path.Join(dir, SecretLayoutFlat.secretPath(minTime.Add(50*secretInterval)))
+	os.Remove(file)
+	if err := os.WriteFile(file, []byte("corrupted"), secretMode); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := newSecretManager(opts, dir); !errors.Is(err, ErrCorruptSecret) {
+		t.Errorf("Got wrong error for loading PKI with corrupted secret: got %v, want %v", err, ErrCorruptSecret)
+	}
+}
+
+// Checks that zeroing the secrets handed out by a secret manager, as their users do, never changes
+// the stored secrets.
+func TestZeroedSecrets(t *testing.T) {
+	minTime := time.Date(2024, time.September, 1, 0, 0, 0, 0, time.UTC)
+	for name, opts := range map[string]PKIOptions{
+		"directory":    {},
+		"memory":       {SecretStore: NewMemStore(nil)},
+		"hierarchical": {SecretScheme: SecretSchemeHierarchical},
+	} {
+		t.Run(name, func(t *testing.T) {
+			opts.Name, opts.MinTime, opts.MaxTime = "Zeroing Test", minTime, minTime
+			s, err := newSecretManager(opts, t.TempDir())
+			if err != nil {
+				t.Fatalf("Failed to initialize secret manager: %+v", err)
+			}
+			want, err := s.GetSecretForTime(minTime)
+			if err != nil {
+				t.Fatalf("Failed to get secret: %+v", err)
+			}
+			want = bytes.Clone(want)
+			for range 2 {
+				got, err := s.GetSecretForTime(minTime)
+				if err != nil {
+					t.Fatalf("Failed to get secret: %+v", err)
+				}
+				if !bytes.Equal(got, want) {
+					t.Fatalf("Secret changed after zeroing an earlier copy")
+				}
+				clear(got)
+			}
+		})
+	}
+}
@@ -0,0 +1,240 @@
+package keys
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"strings"
+)
+
+// Name of the file recording a hash of the master secret, against which the master secret is
+// checked when it is reconstructed from shares.
+const masterSecretHashFile = "master_secret_hash"
+
+// Prefix of encoded master secret shares.
+const sharePrefix = "tcs1-"
+
+// Domain separation prefix of master secret hashes.
+const masterSecretHashInfo = "timecapsule master secret\x00"
+
+// Multiplication and inversion tables of GF(2^8) with the AES polynomial x^8 + x^4 + x^3 + x + 1,
+// built from powers of the generator 3.
+var gfExp, gfLog = func() (exp [510]byte, logs [256]byte) {
+	x := byte(1)
+	for i := range 255 {
+		exp[i], exp[i+255] = x, x
+		logs[x] = byte(i)
+		// Multiply by 3: x*2 + x, reducing by the polynomial.
+		x2 := x << 1
+		if x&0x80 != 0 {
+			x2 ^= 0x1b
+		}
+		x ^= x2
+	}
+	return exp, logs
+}()
+
+func gfMul(a, b byte) byte {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	return gfExp[int(gfLog[a])+int(gfLog[b])]
+}
+
+func gfDiv(a, b byte) byte {
+	if a == 0 {
+		return 0
+	}
+	return gfExp[int(gfLog[a])+255-int(gfLog[b])]
+}
+
+// A share of a secret split with Shamir's secret sharing over GF(2^8), one byte at a time.
+type share struct {
+	// Number of shares needed to reconstruct the secret.
+	threshold byte
+	// Nonzero x-coordinate of the share.
+	x byte
+	// Values of the sharing polynomials at x, one for each byte of the secret.
+	y []byte
+}
+
+// Encodes a share as text that can be typed or printed.
+func (s share) String() string {
+	return sharePrefix + hex.EncodeToString(append([]byte{s.threshold, s.x}, s.y...))
+}
+
+// Parses a share encoded by share.String.
+func parseShare(s string) (share, error) {
+	rest, ok := strings.CutPrefix(strings.TrimSpace(s), sharePrefix)
+	if !ok {
+		return share{}, fmt.Errorf("master secret share must start with %q", sharePrefix)
+	}
+	b, err := hex.DecodeString(rest)
+	if err != nil {
+		return share{}, fmt.Errorf("invalid master secret share: %w", err)
+	}
+	if len(b) < 3 || b[0] < 2 || b[1] == 0 {
+		return share{}, errors.New("invalid master secret share")
+	}
+	return share{threshold: b[0], x: b[1], y: b[2:]}, nil
+}
+
+// Splits a secret into n shares, any threshold of which reconstruct it. Fewer shares reveal
+// nothing about the secret.
+func splitSecret(secret []byte, n, threshold int) ([]share, error) {
+	if threshold < 2 || threshold > n || n > 255 {
+		return nil, fmt.Errorf("invalid sharing of %d shares with threshold %d: need 2 <= threshold <= shares <= 255", n, threshold)
+	}
+	// Coefficients of the sharing polynomial of each byte, constant term first.
+	coeffs := make([]byte, threshold)
+	shares := make([]share, n)
+	for i := range shares {
+		shares[i] = share{threshold: byte(threshold), x: byte(i + 1), y: make([]byte, len(secret))}
+	}
+	for j, b := range secret {
+		coeffs[0] = b
+		if _, err := io.ReadFull(rand.Reader, coeffs[1:]); err != nil {
+			return nil, fmt.Errorf("insufficient entropy: %w", err)
+		}
+		for i := range shares {
+			// Horner's rule.
+			var y byte
+			for c := threshold - 1; c >= 0; c-- {
+				y = gfMul(y, shares[i].x) ^ coeffs[c]
+			}
+			shares[i].y[j] = y
+		}
+	}
+	clear(coeffs)
+	return shares, nil
+}
+
+// Reconstructs a secret from shares by Lagrange interpolation at 0.
+func combineShares(shares []share) ([]byte, error) {
+	if len(shares) == 0 {
+		return nil, errors.New("no master secret shares provided")
+	}
+	threshold := int(shares[0].threshold)
+	if len(shares) < threshold {
+		return nil, fmt.Errorf("got %d master secret shares, need %d", len(shares), threshold)
+	}
+	shares = shares[:threshold]
+	seen := map[byte]bool{}
+	for _, s := range shares {
+		if int(s.threshold) != threshold || len(s.y) != len(shares[0].y) {
+			return nil, errors.New("master secret shares are from different sharings")
+		}
+		if seen[s.x] {
+			return nil, fmt.Errorf("master secret share %d was given twice", s.x)
+		}
+		seen[s.x] = true
+	}
+
+	secret := make([]byte, len(shares[0].y))
+	for i, si := range shares {
+		// Lagrange basis polynomial of share i at 0. Subtraction is XOR in GF(2^8).
+		basis := byte(1)
+		for j, sj := range shares {
+			if i != j {
+				basis = gfMul(basis, gfDiv(sj.x, sj.x^si.x))
+			}
+		}
+		for k, y := range si.y {
+			secret[k] ^= gfMul(y, basis)
+		}
+	}
+	return secret, nil
+}
+
+// Returns the hex-encoded hash of a master secret recorded in the master secret hash file.
+func masterSecretHash(master []byte) string {
+	h := sha256.Sum256(append([]byte(masterSecretHashInfo), master...))
+	return hex.EncodeToString(h[:])
+}
+
+// Splits the master secret of the hierarchical PKI in a secrets directory into n shares, any
+// threshold of which reconstruct it, and records a hash of the master secret with which the
+// reconstruction is checked. Returns the encoded shares.
+//
+// The master secret file is left in place. Once the shares have been distributed, it should be
+// deleted, and the PKI started with PKIOptions.MasterSecretShares.
+func SplitMasterSecret(dir string, n, threshold int) ([]string, error) {
+	master, ok, err := tryReadFile(path.Join(dir, masterSecretFile))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read master secret: %w", err)
+	}
+	if !ok {
+		return nil, fmt.Errorf("no master secret in %s", dir)
+	}
+	defer clear(master)
+	if len(master) != secretSize {
+		return nil, fmt.Errorf("master secret has %d bytes, want %d", len(master), secretSize)
+	}
+	shares, err := splitSecret(master, n, threshold)
+	if err != nil {
+		return nil, err
+	}
+	record := newFileSource(path.Join(dir, masterSecretHashFile))
+	if err := record.Set(masterSecretHash(master)); err != nil {
+		return nil, fmt.Errorf("failed to record master secret hash: %w", err)
+	}
+	encoded := make([]string, len(shares))
+	for i, s := range shares {
+		encoded[i] = s.String()
+	}
+	return encoded, nil
+}
+
+// Reconstructs a master secret from encoded shares and checks it against the hash recorded in the
+// secrets directory.
+func reconstructMasterSecret(dir string, encoded []string) ([]byte, error) {
+	shares := make([]share, len(encoded))
+	for i, e := range encoded {
+		var err error
+		if shares[i], err = parseShare(e); err != nil {
+			return nil, err
+		}
+	}
+	master, err := combineShares(shares)
+	if err != nil {
+		return nil, err
+	}
+	recorded, ok, err := newFileSource(path.Join(dir, masterSecretHashFile)).Get()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read master secret hash: %w", err)
+	}
+	if !ok {
+		return nil, errors.New("no master secret hash recorded; shares must be created with SplitMasterSecret")
+	}
+	if subtle.ConstantTimeCompare([]byte(masterSecretHash(master)), []byte(recorded)) != 1 {
+		return nil, errors.New("master secret shares don't reconstruct the master secret")
+	}
+	log.Printf("Reconstructed master secret from %d shares", int(shares[0].threshold))
+	return master, nil
+}
+
+// Returns the number of shares needed to reconstruct the master secret from an encoded share.
+func ShareThreshold(encoded string) (int, error) {
+	s, err := parseShare(encoded)
+	if err != nil {
+		return 0, err
+	}
+	return int(s.threshold), nil
+}
+
+// Deletes the master secret file of a PKI whose master secret has been split into shares.
+func RemoveMasterSecret(dir string) error {
+	if _, ok, err := tryReadFile(path.Join(dir, masterSecretHashFile)); err != nil {
+		return err
+	} else if !ok {
+		return errors.New("master secret has not been split into shares")
+	}
+	return os.Remove(path.Join(dir, masterSecretFile))
+}
@@ -0,0 +1,129 @@
+package keys
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"log"
+	"path"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// Name of the file in the secrets directory that stores the PKI signing key.
+	signingKeyFile = "signing_key.pem"
+
+	// Domain separation prefix for public key signatures.
+	publicKeySignatureContext = "timecapsule public key signature v1\x00"
+
+	// Domain separation prefix for release attestations.
+	releaseAttestationContext = "timecapsule release attestation v1\x00"
+)
+
+// Long-term Ed25519 key with which a PKI signs its public keys.
+type signingKey struct {
+	priv ed25519.PrivateKey
+	// Fingerprint of the public half of the signing key. See Fingerprint.
+	fingerprint string
+	// Identifies the signing key: the first 8 bytes of its fingerprint, in hex.
+	id string
+}
+
+// Returns the SHA-256 fingerprint of a DER-encoded key, such as a SubjectPublicKeyInfo message, in
+// lowercase hex.
+func Fingerprint(der []byte) string {
+	sum := sha256.Sum256(der)
+	return hex.EncodeToString(sum[:])
+}
+
+// Loads the signing key in the given directory, generating a new one if none exists yet.
+func loadSigningKey(dir string) (*signingKey, error) {
+	path := path.Join(dir, signingKeyFile)
+
+	b, ok, err := tryReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("signing key file %s is corrupted: %w", path, err)
+	}
+	var priv ed25519.PrivateKey
+	if ok {
+		block, _ := pem.Decode(b)
+		if block == nil || block.Type != pemTypePrivateKey {
+			return nil, fmt.Errorf("signing key file %s does not contain a PEM private key", path)
+		}
+		parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse signing key file %s: %w", path, err)
+		}
+		priv, ok = parsed.(ed25519.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("signing key file %s contains a key of type %T, want Ed25519", path, parsed)
+		}
+	} else {
+		_, priv, err = ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate signing key: %w", err)
+		}
+		p, err := FormatPrivateKeyAsPKCS8PEM(priv)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode signing key: %w", err)
+		}
+		if err := createFileAtomic(path, []byte(p), secretMode); err != nil {
+			return nil, fmt.Errorf("failed to write signing key file %s: %w", path, err)
+		}
+		log.Printf("Created new PKI signing key: %s", path)
+	}
+
+	der, err := x509.MarshalPKIXPublicKey(priv.Public())
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal signing key: %w", err)
+	}
+	fingerprint := Fingerprint(der)
+	return &signingKey{priv: priv, fingerprint: fingerprint, id: fingerprint[:16]}, nil
+}
+
+// Returns the message that a PKI signs to vouch for the public key of the given time:
+//
+//	context || PKI ID (16 bytes) || time (8-byte big-endian Unix seconds) || SPKI
+//
+// where context is the ASCII string "timecapsule public key signature v1" followed by a zero byte.
+func PublicKeySignatureMessage(pkiID uuid.UUID, t time.Time, spki []byte) []byte {
+	msg := make([]byte, 0, len(publicKeySignatureContext)+len(pkiID)+8+len(spki))
+	msg = append(msg, publicKeySignatureContext...)
+	msg = append(msg, pkiID[:]...)
+	msg = binary.BigEndian.AppendUint64(msg, uint64(t.Unix()))
+	msg = append(msg, spki...)
+	return msg
+}
+
+// Verifies a public key signature produced by KeyManager.SignPublicKey.
+func VerifyPublicKeySignature(signer ed25519.PublicKey, pkiID uuid.UUID, t time.Time, spki []byte, sig []byte) bool {
+	return ed25519.Verify(signer, PublicKeySignatureMessage(pkiID, t, spki), sig)
+}
+
+// Returns the message that a PKI signs to attest that the private key for a target time was
+// released no earlier than the given release time:
+//
+//	context || PKI ID (16 bytes) || target time || release time
+//
+// where times are 8-byte big-endian Unix seconds and context is the ASCII string
+// "timecapsule release attestation v1" followed by a zero byte.
+func ReleaseAttestationMessage(pkiID uuid.UUID, target time.Time, released time.Time) []byte {
+	msg := make([]byte, 0, len(releaseAttestationContext)+len(pkiID)+16)
+	msg = append(msg, releaseAttestationContext...)
+	msg = append(msg, pkiID[:]...)
+	msg = binary.BigEndian.AppendUint64(msg, uint64(target.Unix()))
+	msg = binary.BigEndian.AppendUint64(msg, uint64(released.Unix()))
+	return msg
+}
+
+// Verifies a release attestation produced by KeyManager.AttestRelease.
+func VerifyReleaseAttestation(signer ed25519.PublicKey, pkiID uuid.UUID, target time.Time, released time.Time, sig []byte) bool {
+	return ed25519.Verify(signer, ReleaseAttestationMessage(pkiID, target, released), sig)
+}
@@ -0,0 +1,91 @@
+package keys
+
+import (
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"encoding/pem"
+	"fmt"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Returns the elliptic curve of an ECDH curve usable with ECDSA, as SSH requires.
+func sshCurve(curve ecdh.Curve) (elliptic.Curve, error) {
+	switch curve {
+	case ecdh.P256():
+		return elliptic.P256(), nil
+	case ecdh.P384():
+		return elliptic.P384(), nil
+	default:
+		return nil, fmt.Errorf("keys on curve %v can't be used with SSH", curve)
+	}
+}
+
+// Converts a time public key to the type that the SSH package expects.
+func sshPublicKey(pub crypto.PublicKey) (crypto.PublicKey, error) {
+	switch pub := pub.(type) {
+	case ed25519.PublicKey:
+		return pub, nil
+	case *ecdh.PublicKey:
+		curve, err := sshCurve(pub.Curve())
+		if err != nil {
+			return nil, err
+		}
+		return ecdsa.ParseUncompressedPublicKey(curve, pub.Bytes())
+	default:
+		return nil, fmt.Errorf("public key is of unsupported type %T", pub)
+	}
+}
+
+// Formats a public key as a line of an OpenSSH authorized_keys file, ending in the given comment if
+// it isn't empty. P-256 and P-384 keys are formatted as ECDSA keys; X25519 keys have no SSH
+// format.
+//
+// The comment is a convenient place to record the key's time, since an SSH key for a time is only
+// useful once its private key has been released.
+func FormatPublicKeyAsSSH(pub crypto.PublicKey, comment string) (string, error) {
+	converted, err := sshPublicKey(pub)
+	if err != nil {
+		return "", err
+	}
+	sshPub, err := ssh.NewPublicKey(converted)
+	if err != nil {
+		return "", fmt.Errorf("failed to convert public key to SSH: %w", err)
+	}
+	line := ssh.MarshalAuthorizedKey(sshPub)
+	if comment != "" {
+		// Replace the trailing newline.
+		line = append(append(line[:len(line)-1], ' '), comment...)
+		line = append(line, '\n')
+	}
+	return string(line), nil
+}
+
+// Formats a private key as an unencrypted PEM-encoded OpenSSH private key with the given comment.
+// Key types are as in FormatPublicKeyAsSSH.
+func FormatPrivateKeyAsSSH(priv PrivateKey, comment string) (string, error) {
+	var converted crypto.PrivateKey
+	switch priv := priv.(type) {
+	case ed25519.PrivateKey:
+		converted = priv
+	case *ecdh.PrivateKey:
+		curve, err := sshCurve(priv.Curve())
+		if err != nil {
+			return "", err
+		}
+		converted, err = ecdsa.ParseRawPrivateKey(curve, priv.Bytes())
+		if err != nil {
+			return "", fmt.Errorf("failed to convert private key to ECDSA: %w", err)
+		}
+	default:
+		return "", fmt.Errorf("private key is of unsupported type %T", priv)
+	}
+	block, err := ssh.MarshalPrivateKey(converted, comment)
+	if err != nil {
+		return "", fmt.Errorf("failed to convert private key to SSH: %w", err)
+	}
+	return string(pem.EncodeToMemory(block)), nil
+}
@@ -0,0 +1,138 @@
+package keys
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+)
+
+// Returned by SecretStore.Put when the bucket already has a secret.
+var ErrSecretExists = errors.New("secret already exists")
+
+// Storage for a PKI's per-interval root secrets, keyed by the start of the interval that each
+// secret covers.
+//
+// Stores only hold secrets. A PKI's configuration, such as its name and ID, is always kept in its
+// secrets directory.
+//
+// Stores must be safe for concurrent use: requests read secrets concurrently, and a PKI's secrets
+// are checked concurrently when it is loaded.
+type SecretStore interface {
+	// Returns the secret for the bucket starting at the given time, or ok = false if the bucket
+	// has no secret. The returned slice belongs to the caller, which zeroes it after use.
+	Get(bucket time.Time) (secret []byte, ok bool, err error)
+	// Stores the secret for a bucket that has none. Secrets must never be overwritten, since that
+	// would change every key of the bucket; if the bucket already has a secret, Put fails with an
+	// error wrapping ErrSecretExists. The store must not keep the given slice, which the caller
+	// zeroes once Put returns.
+	Put(bucket time.Time, secret []byte) error
+	// Returns the start times of all buckets that have a secret, in chronological order.
+	List() ([]time.Time, error)
+}
+
+// Implemented by secret stores that can delete secrets, which retention policies require.
+type SecretDeleter interface {
+	// Permanently deletes the secret for a bucket. Deleting a secret that doesn't exist succeeds.
+	Delete(bucket time.Time) error
+}
+
+// Deletes the secret for a bucket from a store, failing if the store can't delete secrets.
+func deleteSecret(store SecretStore, bucket time.Time) error {
+	d, ok := store.(SecretDeleter)
+	if !ok {
+		return fmt.Errorf("secret store %T doesn't support deletion", store)
+	}
+	return d.Delete(bucket)
+}
+
+// Secret store that keeps each secret in its own file in the secrets directory, arranged in the
+// given layout.
+type dirStore struct {
+	dir    string
+	layout SecretLayout
+}
+
+// Returns a secret store that keeps each secret in its own file in a directory, arranged in the given
+// layout, such as a replica of the secrets directory on another disk. The directory is created if it
+// doesn't exist.
+func NewDirStore(dir string, layout SecretLayout) (SecretStore, error) {
+	if layout == "" {
+		layout = SecretLayoutFlat
+	}
+	if _, err := parseSecretLayout(string(layout)); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create secrets directory: %w", err)
+	}
+	return &dirStore{dir: dir, layout: layout}, nil
+}
+
+func (d *dirStore) Get(bucket time.Time) ([]byte, bool, error) {
+	file := d.layout.secretPath(bucket)
+	secret, ok, err := tryReadFile(path.Join(d.dir, file))
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to read secret file %s: %w", file, err)
+	}
+	return secret, ok, nil
+}
+
+func (d *dirStore) Put(bucket time.Time, secret []byte) error {
+	path := path.Join(d.dir, d.layout.secretPath(bucket))
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("failed to create directory for secret file %s: %w", path, err)
+	}
+	// A crash while writing a secret must not leave a truncated secret file behind.
+	err := createFileAtomic(path, secret, secretMode)
+	if errors.Is(err, os.ErrExist) {
+		return fmt.Errorf("failed to create secret file %s: %w", path, ErrSecretExists)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write secret file %s: %w", path, err)
+	}
+	return nil
+}
+
+// Deletes a secret file, first overwriting it with zeros so that the secret doesn't linger in freed
+// disk blocks. Overwriting is best-effort: journaling and copy-on-write file systems, flash storage,
+// and backups may keep old copies of the file.
+func (d *dirStore) Delete(bucket time.Time) error {
+	path := path.Join(d.dir, d.layout.secretPath(bucket))
+	info, err := os.Stat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to delete secret file %s: %w", path, err)
+	}
+	// Secret files are read-only.
+	if err := os.Chmod(path, 0o600); err != nil {
+		return fmt.Errorf("failed to delete secret file %s: %w", path, err)
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		return fmt.Errorf("failed to delete secret file %s: %w", path, err)
+	}
+	_, err = f.Write(make([]byte, info.Size()))
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to overwrite secret file %s: %w", path, err)
+	}
+	if err := os.Remove(path); err != nil {
+		return fmt.Errorf("failed to delete secret file %s: %w", path, err)
+	}
+	return syncDir(filepath.Dir(path))
+}
+
+func (d *dirStore) List() ([]time.Time, error) {
+	return listBuckets(d.dir, d.layout)
+}
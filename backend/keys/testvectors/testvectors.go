@@ -0,0 +1,123 @@
+// Package testvectors generates canonical test vectors of timecapsule's key derivation: the keys
+// derived from fixed root secrets at fixed times, in PEM and JWK form.
+//
+// Third-party implementations can check their derivation against the vectors, and the standard set
+// pins the derivation of every supported algorithm and KDF version so that refactors can't change
+// it unnoticed.
+package testvectors
+
+import (
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/newgrp/timecapsule/keys"
+)
+
+// Parameters of the key derivation for which vectors are generated.
+type Params struct {
+	KDFVersion int
+	Algorithm  keys.Algorithm
+	// HKDF salt, or nil for none.
+	Salt []byte
+	// Application context string, or "" for none.
+	Context string
+}
+
+// Key pair derived from a root secret at a time, with everything needed to reproduce it.
+type Vector struct {
+	KDFVersion int            `json:"kdfVersion"`
+	Algorithm  keys.Algorithm `json:"algorithm"`
+	// Hex-encoded HKDF salt.
+	Salt    string `json:"salt,omitempty"`
+	Context string `json:"context,omitempty"`
+	// Hex-encoded root secret of the interval containing Time.
+	Secret string `json:"secret"`
+	// Time of the key in RFC 3339 format, in UTC.
+	Time          string    `json:"time"`
+	PublicKeyPEM  string    `json:"publicKeyPEM"`
+	PrivateKeyPEM string    `json:"privateKeyPEM"`
+	PublicJWK     *keys.JWK `json:"publicJWK"`
+	PrivateJWK    *keys.JWK `json:"privateJWK"`
+}
+
+// Generates the vectors for each of the given times, all derived from the same root secret.
+//
+// The secret is used as given for every time, as if each time fell in the interval it covers, so
+// that vectors for different times can share one secret.
+func Generate(p Params, secret []byte, times []time.Time) ([]Vector, error) {
+	vectors := make([]Vector, 0, len(times))
+	for _, t := range times {
+		t = t.UTC().Truncate(time.Second)
+		priv, err := keys.DeriveKey(p.KDFVersion, p.Algorithm, p.Salt, p.Context, secret, t)
+		if err != nil {
+			return nil, fmt.Errorf("failed to derive key for %s: %w", t.Format(time.RFC3339), err)
+		}
+		pubPEM, err := keys.FormatPublicKeyAsSPKIPEM(priv.Public())
+		if err != nil {
+			return nil, err
+		}
+		privPEM, err := keys.FormatPrivateKeyAsPKCS8PEM(priv)
+		if err != nil {
+			return nil, err
+		}
+		pubJWK, err := keys.FormatPublicKeyAsJWK(priv.Public())
+		if err != nil {
+			return nil, err
+		}
+		privJWK, err := keys.FormatPrivateKeyAsJWK(priv)
+		if err != nil {
+			return nil, err
+		}
+		vectors = append(vectors, Vector{
+			KDFVersion:    p.KDFVersion,
+			Algorithm:     p.Algorithm,
+			Salt:          hex.EncodeToString(p.Salt),
+			Context:       p.Context,
+			Secret:        hex.EncodeToString(secret),
+			Time:          t.Format(time.RFC3339),
+			PublicKeyPEM:  pubPEM,
+			PrivateKeyPEM: privPEM,
+			PublicJWK:     pubJWK,
+			PrivateJWK:    privJWK,
+		})
+	}
+	return vectors, nil
+}
+
+// Root secret of the standard vectors: the bytes 0x00 through 0x1f.
+func StandardSecret() []byte {
+	secret := make([]byte, 32)
+	for i := range secret {
+		secret[i] = byte(i)
+	}
+	return secret
+}
+
+// Times of the standard vectors: the start of an interval, a time within it, and the last second
+// of it.
+func StandardTimes() []time.Time {
+	start := time.Date(2024, time.September, 1, 23, 0, 0, 0, time.UTC)
+	return []time.Time{start, start.Add(29*time.Minute + 33*time.Second), start.Add(time.Hour - time.Second)}
+}
+
+// Generates the standard vectors: those of the standard secret and times for every supported
+// algorithm and KDF version, without and with HKDF customization.
+func Standard() ([]Vector, error) {
+	var vectors []Vector
+	for _, version := range []int{1, 2} {
+		for _, alg := range []keys.Algorithm{keys.AlgorithmP256, keys.AlgorithmP384, keys.AlgorithmX25519, keys.AlgorithmEd25519} {
+			for _, p := range []Params{
+				{KDFVersion: version, Algorithm: alg},
+				{KDFVersion: version, Algorithm: alg, Salt: []byte("timecapsule test salt"), Context: "timecapsule test context"},
+			} {
+				vs, err := Generate(p, StandardSecret(), StandardTimes())
+				if err != nil {
+					return nil, err
+				}
+				vectors = append(vectors, vs...)
+			}
+		}
+	}
+	return vectors, nil
+}
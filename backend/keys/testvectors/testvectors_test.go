@@ -0,0 +1,93 @@
+package testvectors
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/newgrp/timecapsule/keys"
+)
+
+// Checks that vectors generated from the secret of the test PKI in keys/testdata match the keys
+// pinned by the stability tests of the keys package.
+func TestMatchesStabilityTests(t *testing.T) {
+	secret, err := os.ReadFile("../testdata/2024-09-01@23.00.00")
+	if err != nil {
+		t.Fatalf("Failed to read test secret: %+v", err)
+	}
+	tm := time.Date(2024, time.September, 1, 23, 29, 33, 0, time.UTC)
+
+	for _, tc := range []struct {
+		p    Params
+		want string
+	}{
+		{Params{KDFVersion: 1, Algorithm: keys.AlgorithmP256}, `-----BEGIN PUBLIC KEY-----
+MFkwEwYHKoZIzj0CAQYIKoZIzj0DAQcDQgAExVW5oMPcttINe6ZtyfHJ7p1SQOrX
+zBkII7T3C0onq4q6kpqYgi3I1UT7bTVJLYscqgQTD5oTHYhw5M87B1az2g==
+-----END PUBLIC KEY-----`},
+		{Params{KDFVersion: 1, Algorithm: keys.AlgorithmX25519}, `-----BEGIN PUBLIC KEY-----
+MCowBQYDK2VuAyEAXDwuHi08nLMEw7+CoRtkNb1dob+6QAXZ2YxtScY1Oj4=
+-----END PUBLIC KEY-----`},
+		{Params{KDFVersion: 1, Algorithm: keys.AlgorithmP384}, `-----BEGIN PUBLIC KEY-----
+MHYwEAYHKoZIzj0CAQYFK4EEACIDYgAE/pY8ctX3m1wiTeWOtQ691wURZS444e84
+l3s1AhYfu0QAvnbPbIgPIzmJsLp/lM65UVgMMw/Mx6dfjDlJ3CGpapJlF9pq/aEN
+H9nlgWaZe0k4gRuJPHAt0pA5xXEsCJsE
+-----END PUBLIC KEY-----`},
+		{Params{KDFVersion: 1, Algorithm: keys.AlgorithmEd25519}, `-----BEGIN PUBLIC KEY-----
+MCowBQYDK2VwAyEAS28MJOSFm9wZW8ALzxIIQO2xZ4kDf1r4r27ArMgVqVg=
+-----END PUBLIC KEY-----`},
+		{Params{KDFVersion: 2, Algorithm: keys.AlgorithmP256}, `-----BEGIN PUBLIC KEY-----
+MFkwEwYHKoZIzj0CAQYIKoZIzj0DAQcDQgAE+tJe/9s/e1RHPQJBMPvZKxkykEME
+UNzACgRLedS0Ms0X2fLjmkY0zLUA14wcLXPyFVhFbQ1XE0OgsYofMtUDeQ==
+-----END PUBLIC KEY-----`},
+	} {
+		vs, err := Generate(tc.p, secret, []time.Time{tm})
+		if err != nil {
+			t.Fatalf("Failed to generate vector for %+v: %+v", tc.p, err)
+		}
+		if got := strings.TrimSpace(vs[0].PublicKeyPEM); got != tc.want {
+			t.Errorf("Vector for %+v has public key\n%s\nwant\n%s", tc.p, got, tc.want)
+		}
+	}
+}
+
+// Pins the standard vectors, so that any change to them, and thus to the key derivation, is
+// noticed.
+func TestStandard(t *testing.T) {
+	const want = "7fc476972ae8d34649bd5938b58cfa768ebcb55eb0864a6508e21bbc6892bd1d"
+	vs, err := Standard()
+	if err != nil {
+		t.Fatalf("Failed to generate standard vectors: %+v", err)
+	}
+	if len(vs) != 48 {
+		t.Errorf("Generated %d standard vectors, want 48", len(vs))
+	}
+	b, err := json.Marshal(vs)
+	if err != nil {
+		t.Fatalf("Failed to encode standard vectors: %+v", err)
+	}
+	sum := sha256.Sum256(b)
+	if got := hex.EncodeToString(sum[:]); got != want {
+		t.Errorf("Standard vectors have changed: got SHA-256 %s, want %s", got, want)
+	}
+}
+
+func TestGenerateInvalid(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		p      Params
+		secret []byte
+	}{
+		{"short secret", Params{KDFVersion: 2, Algorithm: keys.AlgorithmP256}, StandardSecret()[:16]},
+		{"unknown version", Params{KDFVersion: 3, Algorithm: keys.AlgorithmP256}, StandardSecret()},
+		{"unknown algorithm", Params{KDFVersion: 2, Algorithm: "RSA"}, StandardSecret()},
+	} {
+		if _, err := Generate(tc.p, tc.secret, StandardTimes()); err == nil {
+			t.Errorf("Generate with %s succeeded, want error", tc.name)
+		}
+	}
+}
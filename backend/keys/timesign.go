@@ -0,0 +1,44 @@
+package keys
+
+import (
+	"crypto/ed25519"
+	"fmt"
+	"time"
+)
+
+// HKDF label of per-interval Ed25519 signing keys. See kdfInfo.
+const timeSigningLabel = "Ed25519 signing"
+
+// Derives an Ed25519 signing key pair from an initial secret and a time.
+func deriveSigningKeyForTime(p kdfParams, ikm []byte, t time.Time) (ed25519.PrivateKey, error) {
+	stream, err := keyStream(p, ikm, t, timeSigningLabel)
+	if err != nil {
+		return nil, err
+	}
+	return generateEd25519KeyStable(stream)
+}
+
+// Returns the Ed25519 signing key pair for the given time.
+//
+// Every PKI has a signing key for each time in addition to its key of the configured algorithm.
+// Both are derived from the same secret, so they are released together, and a signature by the
+// private signing key for a time shows that it was made no earlier than that time.
+//
+// These keys are unrelated to the PKI's long-term signing key, which never leaves the server.
+func (m *KeyManager) GetSigningKeyForTime(t time.Time) (ed25519.PrivateKey, error) {
+	if err := m.checkKeyTime(t); err != nil {
+		return nil, err
+	}
+	return cached(m.cache, timeSigningLabel, m.secrets.KDFVersion(), t, func() (ed25519.PrivateKey, error) {
+		secret, err := m.secretForTime(t)
+		if err != nil {
+			return nil, err
+		}
+		defer clear(secret)
+		key, err := deriveSigningKeyForTime(m.secrets.kdfParams(), secret, t)
+		if err != nil {
+			return nil, fmt.Errorf("failed to derive signing keypair for %s: %+v", t.Format(time.RFC3339), err)
+		}
+		return key, nil
+	})
+}
@@ -0,0 +1,410 @@
+package keys
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/bits"
+	"os"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// Name of the file in the secrets directory that stores the transparency log, one JSON entry per
+	// line.
+	transparencyLogFile = "transparency.log"
+
+	// Domain separation prefix for signed tree heads.
+	treeHeadContext = "timecapsule tree head v1\x00"
+
+	// Most entries appended to the transparency log at once.
+	maxLogBatch = 1024
+)
+
+// Returned when a time's public key is not in the transparency log.
+var ErrNotLogged = errors.New("public key is not in the transparency log")
+
+// Returned when a transparency log proof is requested for a tree size the log can't prove.
+var ErrInvalidTreeSize = errors.New("invalid tree size")
+
+// Entry of a PKI's transparency log, binding the start of an interval to its public key.
+type LogEntry struct {
+	// Start of the interval.
+	Time time.Time `json:"time"`
+	// SHA-256 hash of the DER-encoded SubjectPublicKeyInfo of the interval's public key.
+	Fingerprint []byte `json:"fingerprint"`
+}
+
+// Signed head of a PKI's transparency log.
+type TreeHead struct {
+	// Number of entries in the tree.
+	Size uint64
+	// Merkle tree hash of the entries, as in RFC 9162.
+	RootHash []byte
+	// When the tree head was signed.
+	Timestamp time.Time
+	// Signature of TreeHeadMessage by the PKI signing key.
+	Signature []byte
+}
+
+// Returns the leaf of the transparency log for an entry:
+//
+//	PKI ID (16 bytes) || time (8-byte big-endian Unix seconds) || fingerprint (32 bytes)
+func LogLeaf(pkiID uuid.UUID, e LogEntry) []byte {
+	leaf := make([]byte, 0, len(pkiID)+8+len(e.Fingerprint))
+	leaf = append(leaf, pkiID[:]...)
+	leaf = binary.BigEndian.AppendUint64(leaf, uint64(e.Time.Unix()))
+	leaf = append(leaf, e.Fingerprint...)
+	return leaf
+}
+
+// Returns the Merkle tree hash of a leaf, as in RFC 9162.
+func LogLeafHash(leaf []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{0})
+	h.Write(leaf)
+	return h.Sum(nil)
+}
+
+// Returns the Merkle tree hash of an interior node, as in RFC 9162.
+func logNodeHash(left, right []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{1})
+	h.Write(left)
+	h.Write(right)
+	return h.Sum(nil)
+}
+
+// Returns the message that a PKI signs to commit to its transparency log:
+//
+//	context || PKI ID (16 bytes) || tree size (8 bytes) || timestamp (8 bytes) || root hash
+//
+// where integers are big-endian, the timestamp is in Unix seconds, and context is the ASCII string
+// "timecapsule tree head v1" followed by a zero byte.
+func TreeHeadMessage(pkiID uuid.UUID, size uint64, timestamp time.Time, rootHash []byte) []byte {
+	msg := make([]byte, 0, len(treeHeadContext)+len(pkiID)+16+len(rootHash))
+	msg = append(msg, treeHeadContext...)
+	msg = append(msg, pkiID[:]...)
+	msg = binary.BigEndian.AppendUint64(msg, size)
+	msg = binary.BigEndian.AppendUint64(msg, uint64(timestamp.Unix()))
+	msg = append(msg, rootHash...)
+	return msg
+}
+
+// Verifies a tree head signed by KeyManager.TreeHead.
+func VerifyTreeHead(signer ed25519.PublicKey, pkiID uuid.UUID, head TreeHead) bool {
+	return ed25519.Verify(signer, TreeHeadMessage(pkiID, head.Size, head.Timestamp, head.RootHash), head.Signature)
+}
+
+// Verifies that the leaf with the given hash is at the given index of the tree with the given size
+// and root hash, using an inclusion proof as in RFC 9162.
+func VerifyInclusion(leafHash []byte, index, size uint64, proof [][]byte, rootHash []byte) bool {
+	if index >= size {
+		return false
+	}
+	fn, sn := index, size-1
+	r := leafHash
+	for _, p := range proof {
+		if sn == 0 {
+			return false
+		}
+		if fn&1 == 1 || fn == sn {
+			r = logNodeHash(p, r)
+			for fn&1 == 0 && fn != 0 {
+				fn >>= 1
+				sn >>= 1
+			}
+		} else {
+			r = logNodeHash(r, p)
+		}
+		fn >>= 1
+		sn >>= 1
+	}
+	return sn == 0 && bytes.Equal(r, rootHash)
+}
+
+// Verifies that the tree with size2 and rootHash2 extends the tree with size1 and rootHash1, using
+// a consistency proof as in RFC 9162.
+func VerifyConsistency(size1, size2 uint64, rootHash1, rootHash2 []byte, proof [][]byte) bool {
+	switch {
+	case size1 > size2:
+		return false
+	case size1 == size2:
+		return len(proof) == 0 && bytes.Equal(rootHash1, rootHash2)
+	case size1 == 0:
+		// Every tree extends the empty tree.
+		return len(proof) == 0
+	case len(proof) == 0:
+		return false
+	}
+	if size1&(size1-1) == 0 {
+		proof = append([][]byte{rootHash1}, proof...)
+	}
+	fn, sn := size1-1, size2-1
+	for fn&1 == 1 {
+		fn >>= 1
+		sn >>= 1
+	}
+	fr, sr := proof[0], proof[0]
+	for _, c := range proof[1:] {
+		if sn == 0 {
+			return false
+		}
+		if fn&1 == 1 || fn == sn {
+			fr = logNodeHash(c, fr)
+			sr = logNodeHash(c, sr)
+			for fn&1 == 0 && fn != 0 {
+				fn >>= 1
+				sn >>= 1
+			}
+		} else {
+			sr = logNodeHash(sr, c)
+		}
+		fn >>= 1
+		sn >>= 1
+	}
+	return sn == 0 && bytes.Equal(fr, rootHash1) && bytes.Equal(sr, rootHash2)
+}
+
+// Returns the largest power of two less than n, which must be at least 2.
+func splitPoint(n int) int {
+	return 1 << (bits.Len(uint(n-1)) - 1)
+}
+
+// Returns the Merkle tree hash of the given leaf hashes.
+func merkleRoot(leaves [][]byte) []byte {
+	switch len(leaves) {
+	case 0:
+		sum := sha256.Sum256(nil)
+		return sum[:]
+	case 1:
+		return leaves[0]
+	}
+	k := splitPoint(len(leaves))
+	return logNodeHash(merkleRoot(leaves[:k]), merkleRoot(leaves[k:]))
+}
+
+// Returns the inclusion proof of the m-th of the given leaf hashes.
+func inclusionProof(m int, leaves [][]byte) [][]byte {
+	if len(leaves) <= 1 {
+		return nil
+	}
+	k := splitPoint(len(leaves))
+	if m < k {
+		return append(inclusionProof(m, leaves[:k]), merkleRoot(leaves[k:]))
+	}
+	return append(inclusionProof(m-k, leaves[k:]), merkleRoot(leaves[:k]))
+}
+
+// Returns the consistency proof between the tree of the first m of the given leaf hashes and the
+// tree of all of them. complete is whether the first m leaves form a complete subtree whose hash
+// the verifier already knows.
+func consistencyProof(m int, leaves [][]byte, complete bool) [][]byte {
+	if m == len(leaves) {
+		if complete {
+			return nil
+		}
+		return [][]byte{merkleRoot(leaves)}
+	}
+	k := splitPoint(len(leaves))
+	if m <= k {
+		return append(consistencyProof(m, leaves[:k], complete), merkleRoot(leaves[k:]))
+	}
+	return append(consistencyProof(m-k, leaves[k:], false), merkleRoot(leaves[:k]))
+}
+
+// An append-only Merkle log of a PKI's public keys, one entry per interval in chronological order.
+//
+// The log lets mirrors and clients detect if the server ever presents different public keys for the
+// same time: every tree head the server signs must be consistent with every earlier one.
+type transparencyLog struct {
+	path  string
+	pkiID uuid.UUID
+
+	mu      sync.RWMutex
+	entries []LogEntry
+	leaves  [][]byte
+	// Index of each entry by the Unix time of its interval.
+	index map[int64]int
+}
+
+// Loads the transparency log in the given directory, or an empty log if none exists yet. A final
+// entry that was torn by a crash is removed.
+func loadTransparencyLog(dir string, pkiID uuid.UUID) (*transparencyLog, error) {
+	l := &transparencyLog{path: path.Join(dir, transparencyLogFile), pkiID: pkiID, index: make(map[int64]int)}
+
+	contents, ok, err := tryReadFile(l.path)
+	if err != nil || !ok {
+		return l, err
+	}
+	if i := bytes.LastIndexByte(contents, '\n'); i != len(contents)-1 {
+		log.Printf("Removing torn final entry of %s", l.path)
+		if err := os.Truncate(l.path, int64(i+1)); err != nil {
+			return nil, fmt.Errorf("failed to repair %s: %w", l.path, err)
+		}
+		contents = contents[:i+1]
+	}
+	for line := range bytes.Lines(contents) {
+		var e LogEntry
+		if err := json.Unmarshal(line, &e); err != nil {
+			return nil, fmt.Errorf("invalid entry in %s: %w", l.path, err)
+		}
+		l.add(e)
+	}
+	return l, nil
+}
+
+// Adds an entry to the in-memory log. The caller must hold mu for writing, or have exclusive access.
+func (l *transparencyLog) add(e LogEntry) {
+	l.index[e.Time.Unix()] = len(l.entries)
+	l.entries = append(l.entries, e)
+	l.leaves = append(l.leaves, LogLeafHash(LogLeaf(l.pkiID, e)))
+}
+
+// Appends entries to the log and syncs them to disk.
+func (l *transparencyLog) append(entries []LogEntry) error {
+	var b []byte
+	for _, e := range entries {
+		line, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		b = append(append(b, line...), '\n')
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	f, err := os.OpenFile(l.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, fileMode)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(b)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to append to %s: %w", l.path, err)
+	}
+	for _, e := range entries {
+		l.add(e)
+	}
+	return nil
+}
+
+// The time of the last entry, or ok = false if the log is empty.
+func (l *transparencyLog) last() (t time.Time, ok bool) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if len(l.entries) == 0 {
+		return time.Time{}, false
+	}
+	return l.entries[len(l.entries)-1].Time, true
+}
+
+// Appends the public keys of the intervals after the last one in the PKI's transparency log, through
+// the end of the PKI's time range, in chronological order. Entries are for the starts of intervals
+// in the PKI's time range. Intervals whose keys have been revoked or
+// deleted are skipped. Returns the number of entries appended.
+//
+// Returns ErrNotReady if the secrets for some of the intervals have not been generated yet; calling
+// again later picks up where this call stopped.
+func (m *KeyManager) LogPublicKeys() (int, error) {
+	// The first interval may start before the PKI's time range, in which case it isn't logged.
+	next := m.minTime.UTC().Truncate(secretInterval)
+	if next.Before(m.minTime) {
+		next = next.Add(secretInterval)
+	}
+	if last, ok := m.tlog.last(); ok {
+		next = last.Add(secretInterval)
+	}
+
+	var appended int
+	for !next.After(m.MaxTime()) {
+		var batch []LogEntry
+		var err error
+		for ; len(batch) < maxLogBatch && !next.After(m.MaxTime()); next = next.Add(secretInterval) {
+			var priv PrivateKey
+			priv, err = m.GetKeyForTime(next)
+			if errors.Is(err, ErrRevoked) || errors.Is(err, ErrSecretDeleted) {
+				err = nil
+				continue
+			}
+			if err != nil {
+				break
+			}
+			var der []byte
+			der, err = x509.MarshalPKIXPublicKey(priv.Public())
+			if err != nil {
+				err = fmt.Errorf("failed to marshal public key for %s: %w", next.Format(time.RFC3339), err)
+				break
+			}
+			fingerprint := sha256.Sum256(der)
+			batch = append(batch, LogEntry{Time: next, Fingerprint: fingerprint[:]})
+		}
+		if len(batch) > 0 {
+			if err := m.tlog.append(batch); err != nil {
+				return appended, err
+			}
+			appended += len(batch)
+		}
+		if err != nil {
+			return appended, err
+		}
+	}
+	return appended, nil
+}
+
+// Signs the current head of the PKI's transparency log, timestamped with the given time.
+func (m *KeyManager) TreeHead(now time.Time) TreeHead {
+	m.tlog.mu.RLock()
+	head := TreeHead{Size: uint64(len(m.tlog.leaves)), RootHash: merkleRoot(m.tlog.leaves), Timestamp: now.UTC().Truncate(time.Second)}
+	m.tlog.mu.RUnlock()
+	head.Signature = ed25519.Sign(m.signer.priv, TreeHeadMessage(m.PKIID(), head.Size, head.Timestamp, head.RootHash))
+	return head
+}
+
+// Returns the entry of the PKI's transparency log for the interval containing the given time, its
+// index, and its inclusion proof in the tree with the given size. Fails with ErrNotLogged if the
+// interval is not in the log, and with ErrInvalidTreeSize if the tree is too small to include it or
+// larger than the log.
+func (m *KeyManager) LogInclusionProof(t time.Time, size uint64) (LogEntry, uint64, [][]byte, error) {
+	m.tlog.mu.RLock()
+	defer m.tlog.mu.RUnlock()
+
+	i, ok := m.tlog.index[t.UTC().Truncate(secretInterval).Unix()]
+	if !ok {
+		return LogEntry{}, 0, nil, fmt.Errorf("%w: %s", ErrNotLogged, t.UTC().Format(time.RFC3339))
+	}
+	if size > uint64(len(m.tlog.leaves)) || uint64(i) >= size {
+		return LogEntry{}, 0, nil, fmt.Errorf("%w: %d does not include entry %d of %d", ErrInvalidTreeSize, size, i, len(m.tlog.leaves))
+	}
+	return m.tlog.entries[i], uint64(i), inclusionProof(i, m.tlog.leaves[:size]), nil
+}
+
+// Returns the proof that the PKI's transparency log with size2 entries extends the log with size1
+// entries. Fails with ErrInvalidTreeSize unless size1 <= size2 <= the size of the log.
+func (m *KeyManager) LogConsistencyProof(size1, size2 uint64) ([][]byte, error) {
+	m.tlog.mu.RLock()
+	defer m.tlog.mu.RUnlock()
+
+	if size1 > size2 || size2 > uint64(len(m.tlog.leaves)) {
+		return nil, fmt.Errorf("%w: can't prove %d to %d in a log of %d", ErrInvalidTreeSize, size1, size2, len(m.tlog.leaves))
+	}
+	if size1 == 0 || size1 == size2 {
+		return [][]byte{}, nil
+	}
+	return consistencyProof(int(size1), m.tlog.leaves[:size2], true), nil
+}
@@ -0,0 +1,55 @@
+package keys
+
+import (
+	"crypto/ecdh"
+	"crypto/hpke"
+	"fmt"
+)
+
+// HPKE info string used when sealing private keys to a client's public key.
+const wrapInfo = "timecapsule private key"
+
+// Returns the HPKE KDF and AEAD used for sealing.
+//
+// The KEM is always DHKEM over the curve of the recipient's key.
+func wrapSuite() (hpke.KDF, hpke.AEAD) {
+	return hpke.HKDFSHA256(), hpke.AES128GCM()
+}
+
+// Seals a message to an ECDH public key using HPKE in base mode.
+//
+// Returns the encapsulated key and the ciphertext, which must both be passed to OpenWrapped.
+func Wrap(pub *ecdh.PublicKey, msg []byte) (enc []byte, ciphertext []byte, err error) {
+	kdf, aead := wrapSuite()
+	pk, err := hpke.NewDHKEMPublicKey(pub)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unsupported public key: %w", err)
+	}
+	enc, sender, err := hpke.NewSender(pk, kdf, aead, []byte(wrapInfo))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to set up HPKE sender: %w", err)
+	}
+	ciphertext, err = sender.Seal(nil, msg)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to seal message: %w", err)
+	}
+	return enc, ciphertext, nil
+}
+
+// Opens a message sealed by Wrap using the recipient's private key.
+func OpenWrapped(priv *ecdh.PrivateKey, enc []byte, ciphertext []byte) ([]byte, error) {
+	kdf, aead := wrapSuite()
+	sk, err := hpke.NewDHKEMPrivateKey(priv)
+	if err != nil {
+		return nil, fmt.Errorf("unsupported private key: %w", err)
+	}
+	recipient, err := hpke.NewRecipient(enc, sk, kdf, aead, []byte(wrapInfo))
+	if err != nil {
+		return nil, fmt.Errorf("failed to set up HPKE recipient: %w", err)
+	}
+	msg, err := recipient.Open(nil, ciphertext)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open message: %w", err)
+	}
+	return msg, nil
+}
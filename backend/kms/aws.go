@@ -0,0 +1,91 @@
+package kms
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/newgrp/timecapsule/sigv4"
+)
+
+// An AWS KMS symmetric encryption key.
+//
+// Requests are made to the AWS KMS JSON API directly and signed with Signature Version 4.
+type AWS struct {
+	keyARN   string
+	region   string
+	endpoint string
+	creds    sigv4.Credentials
+	client   *http.Client
+	// Returns the current time, for signing.
+	now func() time.Time
+}
+
+// Returns the AWS KMS key with the given ARN, which determines the region of the requests.
+//
+// Credentials are read from the AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY, and optional
+// AWS_SESSION_TOKEN environment variables.
+func NewAWS(keyARN string) (*AWS, error) {
+	// arn:partition:kms:region:account:key/id
+	parts := strings.Split(keyARN, ":")
+	if len(parts) != 6 || parts[0] != "arn" || parts[2] != "kms" || parts[3] == "" {
+		return nil, fmt.Errorf("invalid AWS KMS key ARN %q", keyARN)
+	}
+	creds, err := sigv4.CredentialsFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	return &AWS{
+		keyARN:   keyARN,
+		region:   parts[3],
+		endpoint: fmt.Sprintf("https://kms.%s.amazonaws.com/", parts[3]),
+		creds:    creds,
+		client:   &http.Client{Timeout: requestTimeout},
+		now:      time.Now,
+	}, nil
+}
+
+// Calls an AWS KMS action.
+func (a *AWS) call(action string, req any, resp any) error {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	r, err := http.NewRequest(http.MethodPost, a.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	r.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	r.Header.Set("X-Amz-Target", "TrentService."+action)
+	sigv4.Sign(r, body, a.creds, a.region, "kms", a.now())
+	if err := doJSON(a.client, r, resp); err != nil {
+		return fmt.Errorf("AWS KMS %s failed: %w", action, err)
+	}
+	return nil
+}
+
+func (a *AWS) Encrypt(plaintext []byte) ([]byte, error) {
+	var resp struct {
+		CiphertextBlob []byte
+	}
+	err := a.call("Encrypt", struct {
+		KeyId     string
+		Plaintext []byte
+	}{a.keyARN, plaintext}, &resp)
+	return resp.CiphertextBlob, err
+}
+
+func (a *AWS) Decrypt(ciphertext []byte) ([]byte, error) {
+	var resp struct {
+		Plaintext []byte
+	}
+	// Naming the key makes KMS refuse ciphertexts of any other key.
+	err := a.call("Decrypt", struct {
+		KeyId          string
+		CiphertextBlob []byte
+	}{a.keyARN, ciphertext}, &resp)
+	return resp.Plaintext, err
+}
@@ -0,0 +1,69 @@
+package kms
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// A key-encryption key held by an external helper program, such as one that keeps the key in a
+// hardware security module and uses it through PKCS#11, so that the key never enters this process.
+//
+// The helper is run as "<program> encrypt" or "<program> decrypt" with the data key or encrypted
+// data key on standard input. It must write the result to standard output and exit with status 0,
+// or write an error message to standard error and exit with another status. The helper inherits
+// the server's environment, from which it can take PINs and module paths.
+type Exec struct {
+	program string
+}
+
+// Returns the key of the helper program at the given absolute path.
+func NewExec(program string) (*Exec, error) {
+	if !filepath.IsAbs(program) {
+		return nil, fmt.Errorf("KMS helper program %q is not an absolute path", program)
+	}
+	info, err := os.Stat(program)
+	if err != nil {
+		return nil, fmt.Errorf("invalid KMS helper program: %w", err)
+	}
+	if info.IsDir() || info.Mode().Perm()&0o111 == 0 {
+		return nil, fmt.Errorf("KMS helper program %s is not executable", program)
+	}
+	return &Exec{program: program}, nil
+}
+
+// Runs a command with the given standard input and returns its standard output. Errors include the
+// start of the command's standard error.
+func runCommand(stdin []byte, name string, args ...string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Stdin = bytes.NewReader(stdin)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		msg := stderr.Bytes()
+		if len(msg) > maxErrorBody {
+			msg = msg[:maxErrorBody]
+		}
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			err = fmt.Errorf("timed out after %s", requestTimeout)
+		}
+		return nil, fmt.Errorf("%s %s failed: %w: %s", filepath.Base(name), strings.Join(args, " "), err, strings.TrimSpace(string(msg)))
+	}
+	return stdout.Bytes(), nil
+}
+
+func (e *Exec) Encrypt(plaintext []byte) ([]byte, error) {
+	return runCommand(plaintext, e.program, "encrypt")
+}
+
+func (e *Exec) Decrypt(ciphertext []byte) ([]byte, error) {
+	return runCommand(ciphertext, e.program, "decrypt")
+}
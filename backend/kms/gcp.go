@@ -0,0 +1,129 @@
+package kms
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Environment variable that may hold a Google Cloud OAuth 2.0 access token. If it is unset, tokens
+// are requested from the metadata server of the Compute Engine instance or other Google Cloud
+// runtime.
+const envGCPAccessToken = "GOOGLE_OAUTH_ACCESS_TOKEN"
+
+// Endpoint from which the metadata server issues access tokens for the default service account.
+const gcpMetadataTokenURL = "http://metadata.google.internal/computeMetadata/v1/instance/service-accounts/default/token"
+
+// Access tokens are refreshed this long before they expire.
+const tokenExpiryMargin = time.Minute
+
+// A Google Cloud KMS symmetric encryption key.
+//
+// Requests are made to the Cloud KMS REST API directly.
+type GCP struct {
+	name     string
+	endpoint string
+	client   *http.Client
+
+	// Access token given by the environment, if any.
+	staticToken string
+	tokenURL    string
+
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+}
+
+// Returns the Google Cloud KMS key with the given resource name, which has the form
+// projects/<project>/locations/<location>/keyRings/<key ring>/cryptoKeys/<key>.
+//
+// The access token is read from the GOOGLE_OAUTH_ACCESS_TOKEN environment variable if it is set,
+// and is otherwise requested from the metadata server.
+func NewGCP(name string) (*GCP, error) {
+	parts := strings.Split(name, "/")
+	if len(parts) != 8 || parts[0] != "projects" || parts[2] != "locations" || parts[4] != "keyRings" || parts[6] != "cryptoKeys" {
+		return nil, fmt.Errorf("invalid Google Cloud KMS key name %q", name)
+	}
+	return &GCP{
+		name:        name,
+		endpoint:    "https://cloudkms.googleapis.com/v1/",
+		client:      &http.Client{Timeout: requestTimeout},
+		staticToken: os.Getenv(envGCPAccessToken),
+		tokenURL:    gcpMetadataTokenURL,
+	}, nil
+}
+
+// Returns an access token, requesting a new one from the metadata server if needed.
+func (g *GCP) accessToken() (string, error) {
+	if g.staticToken != "" {
+		return g.staticToken, nil
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.token != "" && time.Now().Before(g.expires) {
+		return g.token, nil
+	}
+
+	req, err := http.NewRequest(http.MethodGet, g.tokenURL, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+	var resp struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := doJSON(g.client, req, &resp); err != nil {
+		return "", fmt.Errorf("failed to get access token from the metadata server: %w", err)
+	}
+	g.token = resp.AccessToken
+	g.expires = time.Now().Add(time.Duration(resp.ExpiresIn)*time.Second - tokenExpiryMargin)
+	return g.token, nil
+}
+
+// Calls a Cloud KMS method on the key.
+func (g *GCP) call(method string, req any, resp any) error {
+	token, err := g.accessToken()
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	r, err := http.NewRequest(http.MethodPost, g.endpoint+g.name+":"+method, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	r.Header.Set("Content-Type", "application/json")
+	r.Header.Set("Authorization", "Bearer "+token)
+	if err := doJSON(g.client, r, resp); err != nil {
+		return fmt.Errorf("Cloud KMS %s failed: %w", method, err)
+	}
+	return nil
+}
+
+func (g *GCP) Encrypt(plaintext []byte) ([]byte, error) {
+	var resp struct {
+		Ciphertext []byte `json:"ciphertext"`
+	}
+	err := g.call("encrypt", struct {
+		Plaintext []byte `json:"plaintext"`
+	}{plaintext}, &resp)
+	return resp.Ciphertext, err
+}
+
+func (g *GCP) Decrypt(ciphertext []byte) ([]byte, error) {
+	var resp struct {
+		Plaintext []byte `json:"plaintext"`
+	}
+	err := g.call("decrypt", struct {
+		Ciphertext []byte `json:"ciphertext"`
+	}{ciphertext}, &resp)
+	return resp.Plaintext, err
+}
@@ -0,0 +1,86 @@
+// Package kms implements key management services that protect the secrets of a PKI at rest.
+package kms
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/newgrp/timecapsule/keys"
+)
+
+// Timeout of each request to a key management service.
+const requestTimeout = 30 * time.Second
+
+// Largest error response body from a key management service that is included in errors.
+const maxErrorBody = 1024
+
+// URI schemes of the supported key management services.
+const (
+	schemeAWS  = "aws-kms://"
+	schemeGCP  = "gcp-kms://"
+	schemeExec = "exec://"
+	schemeTPM  = "tpm://"
+)
+
+// Opens the key management service key with the given URI, which is one of:
+//
+//   - aws-kms://<key ARN>, for an AWS KMS key
+//   - gcp-kms://projects/<project>/locations/<location>/keyRings/<key ring>/cryptoKeys/<key>, for a
+//     Google Cloud KMS key
+//   - exec://<absolute path of program>, for a key held by a helper program, such as one that
+//     uses a hardware security module
+//   - tpm://[<PCR selection>], for the local TPM, such as tpm://sha256:0,7
+//
+// Credentials are taken from the environment. See NewAWS, NewGCP, NewExec, and NewTPM.
+func Open(uri string) (keys.KMS, error) {
+	if arn, ok := strings.CutPrefix(uri, schemeAWS); ok {
+		k, err := NewAWS(arn)
+		if err != nil {
+			return nil, err
+		}
+		return k, nil
+	}
+	if name, ok := strings.CutPrefix(uri, schemeGCP); ok {
+		k, err := NewGCP(name)
+		if err != nil {
+			return nil, err
+		}
+		return k, nil
+	}
+	if program, ok := strings.CutPrefix(uri, schemeExec); ok {
+		k, err := NewExec(program)
+		if err != nil {
+			return nil, err
+		}
+		return k, nil
+	}
+	if pcrs, ok := strings.CutPrefix(uri, schemeTPM); ok {
+		k, err := NewTPM(pcrs)
+		if err != nil {
+			return nil, err
+		}
+		return k, nil
+	}
+	return nil, fmt.Errorf("unsupported KMS key URI %q: must start with %q, %q, %q, or %q", uri, schemeAWS, schemeGCP, schemeExec, schemeTPM)
+}
+
+// Sends a request with a JSON body to a key management service and decodes its JSON response.
+func doJSON(client *http.Client, req *http.Request, resp any) error {
+	r, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer r.Body.Close()
+	if r.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(r.Body, maxErrorBody))
+		return fmt.Errorf("%s returned %s: %s", req.URL.Host, r.Status, strings.TrimSpace(string(body)))
+	}
+	if err := json.NewDecoder(r.Body).Decode(resp); err != nil {
+		return fmt.Errorf("invalid response from %s: %w", req.URL.Host, err)
+	}
+	return nil
+}
@@ -0,0 +1,257 @@
+package kms
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/newgrp/timecapsule/sigv4"
+)
+
+// Returns a fake KMS that "encrypts" by reversing bytes and checks each request with check.
+func fakeKMS(t *testing.T, check func(r *http.Request, op string, in []byte) bool) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req map[string][]byte
+		var op string
+		if target := r.Header.Get("X-Amz-Target"); target != "" {
+			op = strings.TrimPrefix(target, "TrentService.")
+			var raw map[string]json.RawMessage
+			json.NewDecoder(r.Body).Decode(&raw)
+			req = map[string][]byte{}
+			for k, v := range raw {
+				var b []byte
+				if json.Unmarshal(v, &b) == nil {
+					req[k] = b
+				}
+			}
+		} else {
+			_, op, _ = strings.Cut(r.URL.Path, ":")
+			json.NewDecoder(r.Body).Decode(&req)
+		}
+		var in []byte
+		for _, v := range req {
+			in = v
+		}
+		if !check(r, op, in) {
+			http.Error(w, "denied", http.StatusForbidden)
+			return
+		}
+		out := slices.Clone(in)
+		slices.Reverse(out)
+		resp := map[string][]byte{}
+		switch op {
+		case "Encrypt":
+			resp["CiphertextBlob"] = out
+		case "Decrypt":
+			resp["Plaintext"] = out
+		case "encrypt":
+			resp["ciphertext"] = out
+		case "decrypt":
+			resp["plaintext"] = out
+		}
+		json.NewEncoder(w).Encode(resp)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func testRoundTrip(t *testing.T, k interface {
+	Encrypt([]byte) ([]byte, error)
+	Decrypt([]byte) ([]byte, error)
+}) {
+	t.Helper()
+	plaintext := []byte("data key")
+	ciphertext, err := k.Encrypt(plaintext)
+	if err != nil {
+		t.Fatalf("Failed to encrypt: %+v", err)
+	}
+	if slices.Equal(ciphertext, plaintext) {
+		t.Errorf("Encrypt returned the plaintext")
+	}
+	got, err := k.Decrypt(ciphertext)
+	if err != nil {
+		t.Fatalf("Failed to decrypt: %+v", err)
+	}
+	if !slices.Equal(got, plaintext) {
+		t.Errorf("Round trip changed the plaintext: got %q, want %q", got, plaintext)
+	}
+}
+
+func TestAWS(t *testing.T) {
+	t.Setenv(sigv4.EnvAccessKeyID, "AKIDEXAMPLE")
+	t.Setenv(sigv4.EnvSecretAccessKey, "secret")
+	t.Setenv(sigv4.EnvSessionToken, "")
+	const arn = "arn:aws:kms:eu-west-1:111122223333:key/1234abcd-12ab-34cd-56ef-1234567890ab"
+	k, err := NewAWS(arn)
+	if err != nil {
+		t.Fatalf("Failed to create AWS KMS key: %+v", err)
+	}
+	if k.endpoint != "https://kms.eu-west-1.amazonaws.com/" {
+		t.Errorf("Wrong endpoint %s", k.endpoint)
+	}
+
+	srv := fakeKMS(t, func(r *http.Request, op string, in []byte) bool {
+		auth := r.Header.Get("Authorization")
+		return strings.HasPrefix(auth, "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/") && strings.Contains(auth, "/eu-west-1/kms/aws4_request")
+	})
+	k.endpoint = srv.URL + "/"
+	k.client = srv.Client()
+	testRoundTrip(t, k)
+
+	if _, err := NewAWS("arn:aws:s3:::bucket"); err == nil {
+		t.Errorf("Accepted an ARN that isn't a KMS key")
+	}
+	t.Setenv(sigv4.EnvSecretAccessKey, "")
+	if _, err := NewAWS(arn); err == nil {
+		t.Errorf("Created an AWS KMS key without credentials")
+	}
+}
+
+func TestGCP(t *testing.T) {
+	t.Setenv(envGCPAccessToken, "")
+	const name = "projects/p/locations/global/keyRings/r/cryptoKeys/k"
+	k, err := NewGCP(name)
+	if err != nil {
+		t.Fatalf("Failed to create Cloud KMS key: %+v", err)
+	}
+
+	tokens := 0
+	metadata := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Metadata-Flavor") != "Google" {
+			http.Error(w, "missing header", http.StatusForbidden)
+			return
+		}
+		tokens++
+		json.NewEncoder(w).Encode(map[string]any{"access_token": "token", "expires_in": 3600})
+	}))
+	t.Cleanup(metadata.Close)
+	srv := fakeKMS(t, func(r *http.Request, op string, in []byte) bool {
+		return r.Header.Get("Authorization") == "Bearer token" && strings.HasPrefix(r.URL.Path, "/v1/"+name+":")
+	})
+	k.endpoint = srv.URL + "/v1/"
+	k.tokenURL = metadata.URL
+	testRoundTrip(t, k)
+	if tokens != 1 {
+		t.Errorf("Requested %d access tokens, want 1", tokens)
+	}
+
+	if _, err := Open("gcp-kms://projects/p"); err == nil {
+		t.Errorf("Accepted an invalid key name")
+	}
+	if _, err := Open("vault://key"); err == nil {
+		t.Errorf("Accepted an unsupported URI")
+	}
+}
+
+func TestExec(t *testing.T) {
+	dir := t.TempDir()
+	helper := filepath.Join(dir, "helper")
+	script := `#!/bin/sh
+case "$1" in
+encrypt) exec base64 ;;
+decrypt) exec base64 -d ;;
+*) echo "unknown operation $1" >&2; exit 2 ;;
+esac
+`
+	if err := os.WriteFile(helper, []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	k, err := Open("exec://" + helper)
+	if err != nil {
+		t.Fatalf("Failed to open helper KMS: %+v", err)
+	}
+	testRoundTrip(t, k)
+
+	failing := filepath.Join(dir, "failing")
+	if err := os.WriteFile(failing, []byte("#!/bin/sh\necho 'token not present' >&2\nexit 1\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	k, err = Open("exec://" + failing)
+	if err != nil {
+		t.Fatalf("Failed to open helper KMS: %+v", err)
+	}
+	if _, err := k.Encrypt([]byte("data key")); err == nil || !strings.Contains(err.Error(), "token not present") {
+		t.Errorf("Got wrong error from failing helper: %v", err)
+	}
+
+	if _, err := Open("exec://helper"); err == nil {
+		t.Errorf("Accepted a relative helper path")
+	}
+	if _, err := Open("exec://" + dir); err == nil {
+		t.Errorf("Accepted a directory as the helper")
+	}
+}
+
+// Fakes of the tpm2-tools programs used by the TPM key. The TPM's identity and PCR values are
+// taken from FAKE_TPM_ID and FAKE_TPM_PCRS.
+var fakeTPMTools = map[string]string{
+	"tpm2_createprimary": `echo "$FAKE_TPM_ID" > "$c"`,
+	"tpm2_createpolicy":  `echo "$l=$FAKE_TPM_PCRS" > "$L"`,
+	"tpm2_create":        `cp "$L" "$u"; echo "$(cat "$C"):$(base64 -w0)" > "$r"`,
+	"tpm2_load": `case "$(cat "$r")" in "$(cat "$C")":*) ;; *) echo "integrity check failed" >&2; exit 1 ;; esac
+{ cat "$u"; cat "$r"; } > "$c"`,
+	"tpm2_unseal": `if [ "$(head -n1 "$c")" != "${p#pcr:}=$FAKE_TPM_PCRS" ]; then echo "policy check failed" >&2; exit 1; fi
+tail -n1 "$c" | cut -d: -f2 | base64 -d`,
+}
+
+func TestTPM(t *testing.T) {
+	dir := t.TempDir()
+	for name, body := range fakeTPMTools {
+		script := `#!/bin/sh
+while [ $# -gt 0 ]; do
+	case "$1" in
+	-C) C=$2; shift ;; -c) c=$2; shift ;; -L) L=$2; shift ;; -l) l=$2; shift ;;
+	-u) u=$2; shift ;; -r) r=$2; shift ;; -p) p=$2; shift ;;
+	esac
+	shift
+done
+` + body + "\n"
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(script), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	t.Setenv("FAKE_TPM_ID", "tpm-a")
+	t.Setenv("FAKE_TPM_PCRS", "good")
+
+	k, err := Open("tpm://")
+	if err != nil {
+		t.Fatalf("Failed to open TPM key: %+v", err)
+	}
+	testRoundTrip(t, k)
+	sealed, err := k.Encrypt([]byte("data key"))
+	if err != nil {
+		t.Fatalf("Failed to seal: %+v", err)
+	}
+
+	// The PCR selection is recorded with each data key.
+	other, err := Open("tpm://sha256:0,1,7")
+	if err != nil {
+		t.Fatalf("Failed to open TPM key: %+v", err)
+	}
+	if _, err := other.Decrypt(sealed); err != nil {
+		t.Errorf("Failed to unseal with another PCR selection: %+v", err)
+	}
+
+	t.Setenv("FAKE_TPM_PCRS", "tampered")
+	if _, err := k.Decrypt(sealed); err == nil || !strings.Contains(err.Error(), "policy check failed") {
+		t.Errorf("Got wrong error for unsealing with changed PCRs: %v", err)
+	}
+	t.Setenv("FAKE_TPM_PCRS", "good")
+	t.Setenv("FAKE_TPM_ID", "tpm-b")
+	if _, err := k.Decrypt(sealed); err == nil || !strings.Contains(err.Error(), "integrity check failed") {
+		t.Errorf("Got wrong error for unsealing on another TPM: %v", err)
+	}
+
+	if _, err := Open("tpm://sha256:zero"); err == nil {
+		t.Errorf("Accepted an invalid PCR selection")
+	}
+	if _, err := k.Decrypt(sealed[:len(tpmMagic)+3]); err == nil {
+		t.Errorf("Unsealed a truncated data key")
+	}
+}
@@ -0,0 +1,146 @@
+package kms
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+)
+
+// PCRs to which data keys are sealed by default: the firmware and Secure Boot policy.
+const defaultTPMPCRs = "sha256:0,7"
+
+// Syntax of a PCR selection, as in tpm2-tools: banks separated by "+", each listing its PCRs.
+var pcrSelection = regexp.MustCompile(`^(sha1|sha256|sha384|sha512):\d{1,2}(,\d{1,2})*(\+(sha1|sha256|sha384|sha512):\d{1,2}(,\d{1,2})*)*$`)
+
+// Prefix of data keys sealed to a TPM.
+const tpmMagic = "TPM1"
+
+// The local TPM, to which data keys are sealed so that they can only be unsealed on the same
+// machine, and only while the selected PCRs hold the values they held at sealing. A copied secrets
+// directory, or a disk moved to other hardware or booted into other firmware, is useless.
+//
+// Each data key is sealed as a TPM object under the owner hierarchy's storage primary key. The TPM
+// is used through the tpm2-tools programs, which must be on the PATH and which take the TPM device
+// from the environment (TPM2TOOLS_TCTI). Data keys are passed to and from the programs through
+// pipes, never through files.
+//
+// Firmware and boot loader updates change PCR values, so data keys must be rewrapped before such
+// updates, or the secrets they protect become unreadable.
+type TPM struct {
+	pcrs string
+}
+
+// Returns the local TPM, sealing to the given PCR selection, such as "sha256:0,7". If pcrs is
+// empty, PCRs 0 and 7 of the SHA-256 bank are used.
+func NewTPM(pcrs string) (*TPM, error) {
+	if pcrs == "" {
+		pcrs = defaultTPMPCRs
+	}
+	if !pcrSelection.MatchString(pcrs) {
+		return nil, fmt.Errorf("invalid PCR selection %q", pcrs)
+	}
+	return &TPM{pcrs: pcrs}, nil
+}
+
+// Creates the storage primary key of the owner hierarchy in a working directory. The key is derived
+// from the hierarchy's seed, so it is the same every time.
+func createPrimary(dir string) (string, error) {
+	primary := filepath.Join(dir, "primary.ctx")
+	if _, err := runCommand(nil, "tpm2_createprimary", "-C", "o", "-c", primary); err != nil {
+		return "", err
+	}
+	return primary, nil
+}
+
+// Seals a data key. The result records the PCR selection, so that changing the selection doesn't
+// make existing data keys unreadable.
+//
+// The result is
+//
+//	"TPM1" || length of PCR selection (1 byte) || PCR selection || length of public area (2-byte
+//	big-endian) || public area || private area
+func (t *TPM) Encrypt(plaintext []byte) ([]byte, error) {
+	dir, err := os.MkdirTemp("", "timecapsule-tpm")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	primary, err := createPrimary(dir)
+	if err != nil {
+		return nil, err
+	}
+	policy := filepath.Join(dir, "policy.digest")
+	if _, err := runCommand(nil, "tpm2_createpolicy", "--policy-pcr", "-l", t.pcrs, "-L", policy); err != nil {
+		return nil, err
+	}
+	pub, priv := filepath.Join(dir, "sealed.pub"), filepath.Join(dir, "sealed.priv")
+	if _, err := runCommand(plaintext, "tpm2_create", "-C", primary, "-L", policy, "-i", "-", "-u", pub, "-r", priv); err != nil {
+		return nil, err
+	}
+
+	pubBytes, err := os.ReadFile(pub)
+	if err != nil {
+		return nil, err
+	}
+	privBytes, err := os.ReadFile(priv)
+	if err != nil {
+		return nil, err
+	}
+	if len(pubBytes) > 0xFFFF {
+		return nil, fmt.Errorf("TPM public area has %d bytes, at most %d are supported", len(pubBytes), 0xFFFF)
+	}
+	sealed := []byte(tpmMagic)
+	sealed = append(sealed, byte(len(t.pcrs)))
+	sealed = append(sealed, t.pcrs...)
+	sealed = binary.BigEndian.AppendUint16(sealed, uint16(len(pubBytes)))
+	sealed = append(sealed, pubBytes...)
+	return append(sealed, privBytes...), nil
+}
+
+// Unseals a data key sealed by Encrypt.
+func (t *TPM) Decrypt(ciphertext []byte) ([]byte, error) {
+	errTruncated := errors.New("sealed data key is truncated")
+	if len(ciphertext) < len(tpmMagic)+1 || string(ciphertext[:len(tpmMagic)]) != tpmMagic {
+		return nil, errors.New("data key was not sealed to a TPM")
+	}
+	rest := ciphertext[len(tpmMagic):]
+	n := 1 + int(rest[0])
+	if len(rest) < n+2 {
+		return nil, errTruncated
+	}
+	pcrs := string(rest[1:n])
+	if !pcrSelection.MatchString(pcrs) {
+		return nil, fmt.Errorf("sealed data key has invalid PCR selection %q", pcrs)
+	}
+	rest = rest[n:]
+	n = 2 + int(binary.BigEndian.Uint16(rest))
+	if len(rest) < n {
+		return nil, errTruncated
+	}
+	pubBytes, privBytes := rest[2:n], rest[n:]
+
+	dir, err := os.MkdirTemp("", "timecapsule-tpm")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	pub, priv := filepath.Join(dir, "sealed.pub"), filepath.Join(dir, "sealed.priv")
+	if err := os.WriteFile(pub, pubBytes, 0o600); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(priv, privBytes, 0o600); err != nil {
+		return nil, err
+	}
+	primary, err := createPrimary(dir)
+	if err != nil {
+		return nil, err
+	}
+	sealed := filepath.Join(dir, "sealed.ctx")
+	if _, err := runCommand(nil, "tpm2_load", "-C", primary, "-u", pub, "-r", priv, "-c", sealed); err != nil {
+		return nil, err
+	}
+	return runCommand(nil, "tpm2_unseal", "-c", sealed, "-p", "pcr:"+pcrs)
+}
@@ -1,95 +1,756 @@
 package main
 
 import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
 	"log"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
+	"github.com/newgrp/timecapsule/clock"
+	"github.com/newgrp/timecapsule/keys"
+	"github.com/newgrp/timecapsule/kms"
+	"github.com/newgrp/timecapsule/s3"
 	"github.com/newgrp/timecapsule/server"
+	"github.com/newgrp/timecapsule/sigv4"
+	"github.com/newgrp/timecapsule/vault"
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 )
 
 const (
 	// Environment variables.
 	envServerAddress = "SERVER_ADDRESS"
+	envPlainAddress  = "PLAIN_ADDRESS"
+	envGRPCAddress   = "GRPC_ADDRESS"
 	envServerCert    = "SERVER_CERT"
 	envServerKey     = "SERVER_KEY"
+	envClientCA      = "CLIENT_CA"
+	envClientSANs    = "CLIENT_SANS"
+	envACMEHosts     = "ACME_HOSTS"
+	envACMEEmail     = "ACME_EMAIL"
+	envACMECacheDir  = "ACME_CACHE_DIR"
+	envACMEDirectory = "ACME_DIRECTORY"
 	envNTSServers    = "NTS_SERVERS"
+	envRoughtime     = "ROUGHTIME_SERVERS"
+	envPollPeriod    = "CLOCK_POLL_PERIOD"
+	envRetryPeriod   = "CLOCK_RETRY_PERIOD"
+	envMaxFailures   = "CLOCK_MAX_FAILURES"
+	envStaleAfter    = "CLOCK_STALE_THRESHOLD"
+	envMaxDivergence = "CLOCK_MAX_DIVERGENCE"
+	envRefuseDiverge = "CLOCK_REFUSE_ON_DIVERGENCE"
+	envNTSCACert     = "NTS_CA_CERT"
+	envNTSPins       = "NTS_PINS"
+	envClockFloor    = "CLOCK_FLOOR_FILE"
 	envSecretsDir    = "SECRETS_DIR"
+	envExtraPKIDirs  = "EXTRA_PKI_DIRS"
+	envPKIRootDir    = "PKI_ROOT_DIR"
+	envAdminTokens   = "ADMIN_TOKENS"
+	envAPIKeys       = "API_KEYS"
+	envBackgroundGen = "BACKGROUND_GENERATION"
+	envVerifySecrets = "VERIFY_SECRETS"
+	envLockMemory    = "LOCK_MEMORY"
+	envKeyAlgorithm  = "KEY_ALGORITHM"
+	envMaxWait       = "MAX_WAIT"
+	envReleaseMargin = "RELEASE_MARGIN"
+	envPrecompute    = "PRECOMPUTE_INTERVALS"
+	envTransparency  = "TRANSPARENCY_LOG"
+	envCORSOrigins   = "CORS_ORIGINS"
+	envCORSMaxAge    = "CORS_MAX_AGE"
+	envAccessLog     = "ACCESS_LOG"
+	envPublicOnly    = "PUBLIC_ONLY"
+	envSecretScheme  = "SECRET_SCHEME"
+	envSecretLayout  = "SECRET_LAYOUT"
+	envKDFVersion    = "KDF_VERSION"
+	envHKDFSalt      = "HKDF_SALT"
+	envHKDFContext   = "HKDF_CONTEXT"
+	envKeyCacheSize  = "KEY_CACHE_SIZE"
+	envKMSKeyURI     = "KMS_KEY_URI"
+	envRetention     = "SECRET_RETENTION"
+	envKillSwitch    = "KILL_SWITCH_FILE"
+
+	// Shares of the master secret of a PKI with hierarchical secrets.
+	envSharesFiles = "MASTER_SECRET_SHARES_FILES"
+	envSharesStdin = "MASTER_SECRET_SHARES_STDIN"
+
+	// Vault secret store. The address, credentials, and CA certificate use the Vault CLI's
+	// variables.
+	envVaultPath      = "VAULT_SECRETS_PATH"
+	envVaultMount     = "VAULT_KV_MOUNT"
+	envVaultAddr      = "VAULT_ADDR"
+	envVaultNamespace = "VAULT_NAMESPACE"
+	envVaultToken     = "VAULT_TOKEN"
+	envVaultRoleID    = "VAULT_ROLE_ID"
+	envVaultSecretID  = "VAULT_SECRET_ID"
+	envVaultCACert    = "VAULT_CACERT"
+
+	// Object storage secret store. Credentials use the AWS SDKs' variables.
+	envS3SecretsURI = "S3_SECRETS_URI"
+	envS3Endpoint   = "S3_ENDPOINT"
+	envS3Region     = "S3_REGION"
+	envS3SSE        = "S3_SSE"
+	envS3SSEKMSKey  = "S3_SSE_KMS_KEY_ID"
+
+	// If true, secrets are kept only in memory and lost when the server exits, as for demos.
+	envMemorySecrets = "MEMORY_SECRETS"
+
+	// Secret stores to which secrets are mirrored, separated by commas. Each is a directory, in the
+	// layout of the secrets directory, or an object storage URI of the form s3://<bucket>/<prefix>.
+	envSecretReplicas = "SECRET_REPLICAS"
+
+	// Rate limits, each given as "<requests per second>,<burst>".
+	envRateLimitPerClient        = "RATE_LIMIT_PER_CLIENT"
+	envRateLimitGlobal           = "RATE_LIMIT_GLOBAL"
+	envPrivateRateLimitPerClient = "PRIVATE_RATE_LIMIT_PER_CLIENT"
+	envPrivateRateLimitGlobal    = "PRIVATE_RATE_LIMIT_GLOBAL"
 )
 
+// How long to wait for in-flight gRPC calls to finish when shutting down.
+const shutdownTimeout = 30 * time.Second
+
+// Prefix of addresses that name Unix sockets rather than TCP addresses.
+const unixPrefix = "unix:"
+
 var (
 	// Time parameter bounds.
 	minTime = time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
 	maxTime = time.Date(2049, time.December, 31, 23, 59, 59, 0, time.UTC)
+
+	// Earliest plausible current time. No NTS server may report a time before this.
+	notBefore = time.Date(2024, time.September, 1, 0, 0, 0, 0, time.UTC)
 )
 
 // Infers HTTP server configuration from environment variables.
 //
-// Returns (server address, TLS enabled, cert file, key file). Cert file and key
-// file are non-empty if and only if TLS is enabled.
+// Returns (server addresses, TLS configuration). Server addresses are
+// comma-separated. The TLS configuration is nil if TLS is disabled.
 //
-// Server address is inferred as follows:
+// Server addresses are inferred as follows:
 //
 //   - if the environment provides a custom address, use that
 //   - if TLS is enabled, use ":443"
 //   - otherwise, use ":80"
 //
-// TLS is inferred as enabled if and only if both the server cert and server key
-// environment variables are populated.
-func getServerConfig() (string, bool, string, string) {
-	addr := ":80"
-	s, customAddr := os.LookupEnv(envServerAddress)
-	if customAddr {
-		addr = s
+// TLS is inferred as enabled if and only if either both the server cert and
+// server key environment variables are populated or ACME hosts are provided.
+func getServerConfig() (string, *tls.Config) {
+	addr, customAddr := os.LookupEnv(envServerAddress)
+
+	var config *tls.Config
+	certFile, hasCert := os.LookupEnv(envServerCert)
+	keyFile, hasKey := os.LookupEnv(envServerKey)
+	hosts, hasACME := os.LookupEnv(envACMEHosts)
+	switch {
+	case hasACME && hasCert && hasKey:
+		log.Fatalf("At most one of %s and %s/%s may be provided", envACMEHosts, envServerCert, envServerKey)
+	case hasACME:
+		config = getACMEConfig(strings.Split(hosts, ","))
+	case hasCert && hasKey:
+		config = getTLSConfig(certFile, keyFile)
+	}
+
+	if !customAddr {
+		addr = ":80"
+		if config != nil {
+			addr = ":443"
+		}
 	}
+	return addr, config
+}
 
-	certFile, ok := os.LookupEnv(envServerCert)
+// Reads a rate limit of the form "<requests per second>,<burst>" from an environment variable.
+// Returns the zero (unlimited) rate limit if the variable is unset.
+func getRateLimit(env string) server.RateLimit {
+	s, ok := os.LookupEnv(env)
 	if !ok {
-		return addr, false, "", ""
+		return server.RateLimit{}
+	}
+	rateStr, burstStr, _ := strings.Cut(s, ",")
+	rate, err := strconv.ParseFloat(rateStr, 64)
+	if err != nil {
+		log.Fatalf("Invalid rate for %s: %v", env, err)
 	}
-	keyFile, ok := os.LookupEnv(envServerKey)
+	burst := 0
+	if burstStr != "" {
+		burst, err = strconv.Atoi(burstStr)
+		if err != nil {
+			log.Fatalf("Invalid burst for %s: %v", env, err)
+		}
+	}
+	return server.RateLimit{Rate: rate, Burst: burst}
+}
+
+// Splits a comma-separated list of secret tokens, dropping empty entries so that a trailing or
+// doubled comma doesn't configure the empty token.
+func splitTokens(s string) []string {
+	var tokens []string
+	for _, t := range strings.Split(s, ",") {
+		if t != "" {
+			tokens = append(tokens, t)
+		}
+	}
+	return tokens
+}
+
+// Constructs the configuration of a listener for an address, which is either a TCP address or a
+// Unix socket path prefixed with "unix:".
+func listenerConfig(addr string, tlsConfig *tls.Config) server.ListenerConfig {
+	if path, ok := strings.CutPrefix(addr, unixPrefix); ok {
+		return server.ListenerConfig{Network: "unix", Address: path, TLSConfig: tlsConfig}
+	}
+	return server.ListenerConfig{Network: "tcp", Address: addr, TLSConfig: tlsConfig}
+}
+
+// Loads the server's TLS configuration from its cert and key files. The files are reloaded when
+// they change or the process receives SIGHUP.
+func getTLSConfig(certFile string, keyFile string) *tls.Config {
+	r, err := newCertReloader(certFile, keyFile)
+	if err != nil {
+		log.Fatalf("Failed to load TLS certificate: %+v", err)
+	}
+	return &tls.Config{GetCertificate: r.GetCertificate}
+}
+
+// Constructs a TLS configuration that obtains and renews certificates for the given hosts from an
+// ACME certificate authority, by default Let's Encrypt.
+//
+// Certificates are cached in the directory given by the environment, or in a directory under the
+// secrets directory otherwise. Challenges are answered with TLS-ALPN-01, so the server must be
+// reachable on port 443.
+func getACMEConfig(hosts []string) *tls.Config {
+	cacheDir, ok := os.LookupEnv(envACMECacheDir)
 	if !ok {
-		return addr, false, "", ""
+		cacheDir = filepath.Join(os.Getenv(envSecretsDir), "acme")
+	}
+	m := &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		HostPolicy: autocert.HostWhitelist(hosts...),
+		Cache:      autocert.DirCache(cacheDir),
+		Email:      os.Getenv(envACMEEmail),
 	}
+	if url, ok := os.LookupEnv(envACMEDirectory); ok {
+		m.Client = &acme.Client{DirectoryURL: url}
+	}
+	return m.TLSConfig()
+}
 
-	if !customAddr {
-		addr = ":443"
+// Configures client certificate verification if the environment provides a client CA file.
+//
+// Client certificates are requested and verified against the CA, but not required at the TLS
+// layer; the server enforces them for private key methods.
+func addClientCAs(config *tls.Config) {
+	if caFile, ok := os.LookupEnv(envClientCA); ok {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			log.Fatalf("Failed to read client CA file: %+v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			log.Fatalf("No certificates found in client CA file %s", caFile)
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+}
+
+// Constructs a Vault secret store for the given path from the environment.
+func getVaultStore(path string) *vault.Store {
+	cfg := vault.Config{
+		Address:   os.Getenv(envVaultAddr),
+		Namespace: os.Getenv(envVaultNamespace),
+		Mount:     os.Getenv(envVaultMount),
+		Path:      path,
+		Token:     os.Getenv(envVaultToken),
+		RoleID:    os.Getenv(envVaultRoleID),
+		SecretID:  os.Getenv(envVaultSecretID),
+	}
+	if caFile, ok := os.LookupEnv(envVaultCACert); ok {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			log.Fatalf("Failed to read Vault CA file: %+v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			log.Fatalf("No certificates found in Vault CA file %s", caFile)
+		}
+		cfg.Client = &http.Client{
+			Timeout:   30 * time.Second,
+			Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}},
+		}
+	}
+	store, err := vault.New(cfg)
+	if err != nil {
+		log.Fatalf("Invalid Vault configuration: %v", err)
+	}
+	return store
+}
+
+// Constructs an object storage secret store for a URI of the form s3://<bucket>/<prefix> from the
+// environment.
+func getS3Store(uri string) *s3.Store {
+	rest, ok := strings.CutPrefix(uri, "s3://")
+	if !ok {
+		log.Fatalf("Invalid value for %s: must start with s3://", envS3SecretsURI)
+	}
+	bucket, prefix, _ := strings.Cut(rest, "/")
+	creds, err := sigv4.CredentialsFromEnv()
+	if err != nil {
+		log.Fatalf("Invalid object storage configuration: %v", err)
+	}
+	region, ok := os.LookupEnv(envS3Region)
+	if !ok {
+		region = os.Getenv("AWS_REGION")
+	}
+	store, err := s3.New(s3.Config{
+		Endpoint:             os.Getenv(envS3Endpoint),
+		Region:               region,
+		Bucket:               bucket,
+		Prefix:               prefix,
+		ServerSideEncryption: os.Getenv(envS3SSE),
+		SSEKMSKeyID:          os.Getenv(envS3SSEKMSKey),
+		Credentials:          creds,
+	})
+	if err != nil {
+		log.Fatalf("Invalid object storage configuration: %v", err)
 	}
-	return addr, true, certFile, keyFile
+	return store
+}
+
+// Constructs a secret store to which secrets are mirrored: an object storage store for an s3:// URI,
+// or else a directory store in the given layout.
+func getReplica(replica string, layout keys.SecretLayout) keys.SecretStore {
+	if strings.HasPrefix(replica, "s3://") {
+		return getS3Store(replica)
+	}
+	store, err := keys.NewDirStore(replica, layout)
+	if err != nil {
+		log.Fatalf("Invalid value for %s: %v", envSecretReplicas, err)
+	}
+	return store
+}
+
+// Reads master secret shares from the files listed in the environment, or from standard input, one
+// per line, if the environment asks for it. Returns nil if neither is given.
+func getMasterSecretShares() []string {
+	var shares []string
+	if files, ok := os.LookupEnv(envSharesFiles); ok {
+		for _, file := range strings.Split(files, ",") {
+			b, err := os.ReadFile(file)
+			if err != nil {
+				log.Fatalf("Failed to read master secret share: %v", err)
+			}
+			shares = append(shares, strings.TrimSpace(string(b)))
+		}
+	}
+	if s, ok := os.LookupEnv(envSharesStdin); ok {
+		fromStdin, err := strconv.ParseBool(s)
+		if err != nil {
+			log.Fatalf("Invalid value for %s: %v", envSharesStdin, err)
+		}
+		if fromStdin {
+			log.Printf("Reading master secret shares from standard input, one per line")
+			// Stop once the threshold is reached, so that operators needn't close standard input.
+			scanner := bufio.NewScanner(os.Stdin)
+			threshold := 0
+			for (threshold == 0 || len(shares) < threshold) && scanner.Scan() {
+				line := strings.TrimSpace(scanner.Text())
+				if line == "" {
+					continue
+				}
+				t, err := keys.ShareThreshold(line)
+				if err != nil {
+					log.Fatalf("Invalid master secret share: %v", err)
+				}
+				threshold = t
+				shares = append(shares, line)
+			}
+			if err := scanner.Err(); err != nil {
+				log.Fatalf("Failed to read master secret shares: %v", err)
+			}
+		}
+	}
+	return shares
 }
 
 func main() {
 	var opts server.Options
 
+	// Memory is locked before any secrets are loaded.
+	if s, ok := os.LookupEnv(envLockMemory); ok {
+		lock, err := strconv.ParseBool(s)
+		if err != nil {
+			log.Fatalf("Invalid value for %s: %v", envLockMemory, err)
+		}
+		if lock {
+			if err := keys.LockMemory(); err != nil {
+				log.Fatalf("Failed to protect secrets in memory: %v", err)
+			}
+		}
+	}
+
+	if s, ok := os.LookupEnv(envPublicOnly); ok {
+		publicOnly, err := strconv.ParseBool(s)
+		if err != nil {
+			log.Fatalf("Invalid value for %s: %v", envPublicOnly, err)
+		}
+		opts.PublicOnly = publicOnly
+	}
+
+	// Public-only mirrors never release private keys, so they don't need a secure clock.
 	servers, ok := os.LookupEnv(envNTSServers)
-	if !ok {
-		log.Fatalf("No NTS server provided")
+	if ok {
+		opts.NTSServers = strings.Split(servers, ",")
+	}
+	// Roughtime servers are given as host:port/key, where key is the base64 public key.
+	if s, ok := os.LookupEnv(envRoughtime); ok {
+		for _, r := range strings.Split(s, ",") {
+			server, err := clock.ParseRoughtimeServer(r)
+			if err != nil {
+				log.Fatalf("Invalid value for %s: %v", envRoughtime, err)
+			}
+			opts.RoughtimeServers = append(opts.RoughtimeServers, server)
+		}
+	}
+	if len(opts.NTSServers) == 0 && len(opts.RoughtimeServers) == 0 && !opts.PublicOnly {
+		log.Fatalf("No NTS or Roughtime server provided")
+	}
+	opts.NotBefore = notBefore
+	if s, ok := os.LookupEnv(envPollPeriod); ok {
+		period, err := time.ParseDuration(s)
+		if err != nil {
+			log.Fatalf("Invalid value for %s: %v", envPollPeriod, err)
+		}
+		opts.Clock.PollPeriod = period
+	}
+	if s, ok := os.LookupEnv(envRetryPeriod); ok {
+		period, err := time.ParseDuration(s)
+		if err != nil {
+			log.Fatalf("Invalid value for %s: %v", envRetryPeriod, err)
+		}
+		opts.Clock.RetryPeriod = period
+	}
+	if s, ok := os.LookupEnv(envMaxFailures); ok {
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			log.Fatalf("Invalid value for %s: %v", envMaxFailures, err)
+		}
+		opts.Clock.MaxConsecutiveFailures = n
+	}
+	if s, ok := os.LookupEnv(envStaleAfter); ok {
+		threshold, err := time.ParseDuration(s)
+		if err != nil {
+			log.Fatalf("Invalid value for %s: %v", envStaleAfter, err)
+		}
+		opts.Clock.StaleThreshold = threshold
+	}
+	if s, ok := os.LookupEnv(envMaxDivergence); ok {
+		divergence, err := time.ParseDuration(s)
+		if err != nil {
+			log.Fatalf("Invalid value for %s: %v", envMaxDivergence, err)
+		}
+		opts.Clock.MaxDivergence = divergence
+	}
+	if s, ok := os.LookupEnv(envRefuseDiverge); ok {
+		refuse, err := strconv.ParseBool(s)
+		if err != nil {
+			log.Fatalf("Invalid value for %s: %v", envRefuseDiverge, err)
+		}
+		opts.Clock.RefuseOnDivergence = refuse
+	}
+	if caFile, ok := os.LookupEnv(envNTSCACert); ok {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			log.Fatalf("Failed to read NTS CA file: %+v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			log.Fatalf("No certificates found in NTS CA file %s", caFile)
+		}
+		opts.Clock.NTSRootCAs = pool
+	}
+	// Pins are given as base64 SHA-256 hashes of DER-encoded SubjectPublicKeyInfos.
+	if s, ok := os.LookupEnv(envNTSPins); ok {
+		for _, p := range strings.Split(s, ",") {
+			b, err := base64.StdEncoding.DecodeString(p)
+			if err != nil || len(b) != sha256.Size {
+				log.Fatalf("Invalid value for %s: %q is not a base64 SHA-256 hash", envNTSPins, p)
+			}
+			opts.Clock.NTSPins = append(opts.Clock.NTSPins, [sha256.Size]byte(b))
+		}
 	}
-	opts.NTSServers = strings.Split(servers, ",")
 
 	opts.PKIOptions.MinTime = minTime
 	opts.PKIOptions.MaxTime = maxTime
+	if alg, ok := os.LookupEnv(envKeyAlgorithm); ok {
+		opts.PKIOptions.Algorithm = keys.Algorithm(alg)
+	}
+	if scheme, ok := os.LookupEnv(envSecretScheme); ok {
+		opts.PKIOptions.SecretScheme = keys.SecretScheme(scheme)
+	}
+	if layout, ok := os.LookupEnv(envSecretLayout); ok {
+		opts.PKIOptions.SecretLayout = keys.SecretLayout(layout)
+	}
+	if s, ok := os.LookupEnv(envKDFVersion); ok {
+		version, err := strconv.Atoi(s)
+		if err != nil {
+			log.Fatalf("Invalid value for %s: %v", envKDFVersion, err)
+		}
+		opts.PKIOptions.KDFVersion = version
+	}
+	if s, ok := os.LookupEnv(envHKDFSalt); ok {
+		salt, err := hex.DecodeString(s)
+		if err != nil {
+			log.Fatalf("Invalid value for %s: %v", envHKDFSalt, err)
+		}
+		opts.PKIOptions.HKDFSalt = salt
+	}
+	if hkdfContext, ok := os.LookupEnv(envHKDFContext); ok {
+		opts.PKIOptions.HKDFContext = hkdfContext
+	}
+	if s, ok := os.LookupEnv(envKeyCacheSize); ok {
+		size, err := strconv.Atoi(s)
+		if err != nil {
+			log.Fatalf("Invalid value for %s: %v", envKeyCacheSize, err)
+		}
+		opts.PKIOptions.KeyCacheSize = size
+	}
+	if s, ok := os.LookupEnv(envRetention); ok {
+		retention, err := time.ParseDuration(s)
+		if err != nil {
+			log.Fatalf("Invalid value for %s: %v", envRetention, err)
+		}
+		opts.PKIOptions.Retention = retention
+	}
+	opts.PKIOptions.MasterSecretShares = getMasterSecretShares()
+	if uri, ok := os.LookupEnv(envKMSKeyURI); ok {
+		k, err := kms.Open(uri)
+		if err != nil {
+			log.Fatalf("Invalid value for %s: %v", envKMSKeyURI, err)
+		}
+		opts.PKIOptions.KMS = k
+	}
+	if path, ok := os.LookupEnv(envVaultPath); ok {
+		opts.PKIOptions.SecretStore = getVaultStore(path)
+	}
+	if uri, ok := os.LookupEnv(envS3SecretsURI); ok {
+		if opts.PKIOptions.SecretStore != nil {
+			log.Fatalf("Only one of %s, %s, and %s may be set", envVaultPath, envS3SecretsURI, envMemorySecrets)
+		}
+		opts.PKIOptions.SecretStore = getS3Store(uri)
+	}
+	if s, ok := os.LookupEnv(envMemorySecrets); ok {
+		memory, err := strconv.ParseBool(s)
+		if err != nil {
+			log.Fatalf("Invalid value for %s: %v", envMemorySecrets, err)
+		}
+		if memory {
+			if opts.PKIOptions.SecretStore != nil {
+				log.Fatalf("Only one of %s, %s, and %s may be set", envVaultPath, envS3SecretsURI, envMemorySecrets)
+			}
+			log.Printf("WARNING: Secrets are kept only in memory; all keys will be lost when the server exits")
+			opts.PKIOptions.SecretStore = keys.NewMemStore(nil)
+		}
+	}
+	if replicas, ok := os.LookupEnv(envSecretReplicas); ok {
+		for _, replica := range strings.Split(replicas, ",") {
+			opts.PKIOptions.Replicas = append(opts.PKIOptions.Replicas, getReplica(replica, opts.PKIOptions.SecretLayout))
+		}
+	}
 
 	opts.SecretsDir, ok = os.LookupEnv(envSecretsDir)
 	if !ok {
 		log.Fatalf("No secrets directory provided")
 	}
+	// The last good time is kept beside the secrets unless the environment says otherwise, so that it
+	// survives restarts along with them.
+	opts.Clock.FloorFile, ok = os.LookupEnv(envClockFloor)
+	if !ok {
+		opts.Clock.FloorFile = filepath.Join(opts.SecretsDir, "clock", "floor")
+	}
+
+	if s, ok := os.LookupEnv(envBackgroundGen); ok {
+		background, err := strconv.ParseBool(s)
+		if err != nil {
+			log.Fatalf("Invalid value for %s: %v", envBackgroundGen, err)
+		}
+		opts.PKIOptions.BackgroundGeneration = background
+	}
+	if s, ok := os.LookupEnv(envVerifySecrets); ok {
+		verify, err := strconv.ParseBool(s)
+		if err != nil {
+			log.Fatalf("Invalid value for %s: %v", envVerifySecrets, err)
+		}
+		opts.PKIOptions.VerifySecrets = verify
+	}
+
+	// Additional PKIs share the primary PKI's time range, generation mode, and verification mode.
+	// Their names are read from their secrets directories.
+	if dirs, ok := os.LookupEnv(envExtraPKIDirs); ok {
+		for _, dir := range strings.Split(dirs, ",") {
+			opts.ExtraPKIs = append(opts.ExtraPKIs, server.PKIConfig{
+				PKIOptions: keys.PKIOptions{
+					MinTime:              minTime,
+					MaxTime:              maxTime,
+					BackgroundGeneration: opts.PKIOptions.BackgroundGeneration,
+					VerifySecrets:        opts.PKIOptions.VerifySecrets,
+				},
+				SecretsDir: dir,
+			})
+		}
+	}
+
+	opts.PKIRootDir = os.Getenv(envPKIRootDir)
+
+	if s, ok := os.LookupEnv(envMaxWait); ok {
+		maxWait, err := time.ParseDuration(s)
+		if err != nil {
+			log.Fatalf("Invalid value for %s: %v", envMaxWait, err)
+		}
+		opts.MaxWait = maxWait
+	}
+	if s, ok := os.LookupEnv(envReleaseMargin); ok {
+		margin, err := time.ParseDuration(s)
+		if err != nil {
+			log.Fatalf("Invalid value for %s: %v", envReleaseMargin, err)
+		}
+		opts.ReleaseMargin = margin
+	}
+	if s, ok := os.LookupEnv(envPrecompute); ok {
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			log.Fatalf("Invalid value for %s: %v", envPrecompute, err)
+		}
+		opts.PrecomputeIntervals = n
+	}
+	if s, ok := os.LookupEnv(envTransparency); ok {
+		transparencyLog, err := strconv.ParseBool(s)
+		if err != nil {
+			log.Fatalf("Invalid value for %s: %v", envTransparency, err)
+		}
+		opts.TransparencyLog = transparencyLog
+	}
+
+	// Permit every origin unless told otherwise, matching the behavior of earlier releases.
+	opts.CORS.AllowedOrigins = []string{"*"}
+	if origins, ok := os.LookupEnv(envCORSOrigins); ok {
+		opts.CORS.AllowedOrigins = nil
+		if origins != "" {
+			opts.CORS.AllowedOrigins = strings.Split(origins, ",")
+		}
+	}
+	if s, ok := os.LookupEnv(envCORSMaxAge); ok {
+		maxAge, err := time.ParseDuration(s)
+		if err != nil {
+			log.Fatalf("Invalid value for %s: %v", envCORSMaxAge, err)
+		}
+		opts.CORS.MaxAge = maxAge
+	}
+
+	if s, ok := os.LookupEnv(envAccessLog); ok {
+		accessLog, err := strconv.ParseBool(s)
+		if err != nil {
+			log.Fatalf("Invalid value for %s: %v", envAccessLog, err)
+		}
+		opts.AccessLog = accessLog
+	}
+	opts.KillSwitchFile = os.Getenv(envKillSwitch)
+
+	opts.RateLimits = server.RateLimitOptions{
+		PerClient:           getRateLimit(envRateLimitPerClient),
+		Global:              getRateLimit(envRateLimitGlobal),
+		PrivateKeyPerClient: getRateLimit(envPrivateRateLimitPerClient),
+		PrivateKeyGlobal:    getRateLimit(envPrivateRateLimitGlobal),
+	}
+
+	if tokens, ok := os.LookupEnv(envAdminTokens); ok {
+		opts.AdminTokens = splitTokens(tokens)
+	}
+	if apiKeys, ok := os.LookupEnv(envAPIKeys); ok {
+		opts.APIKeys = splitTokens(apiKeys)
+		// An empty list would silently make private keys public.
+		if len(opts.APIKeys) == 0 {
+			log.Fatalf("%s must contain at least one non-empty key", envAPIKeys)
+		}
+	}
+
+	addrs, tlsConfig := getServerConfig()
+	useTLS := tlsConfig != nil
+	if useTLS {
+		addClientCAs(tlsConfig)
+	}
+	for _, addr := range strings.Split(addrs, ",") {
+		opts.Listeners = append(opts.Listeners, listenerConfig(addr, tlsConfig))
+	}
+	// Plain HTTP addresses are meant for local clients, such as sidecars, that don't need TLS.
+	if plain, ok := os.LookupEnv(envPlainAddress); ok {
+		for _, addr := range strings.Split(plain, ",") {
+			opts.Listeners = append(opts.Listeners, listenerConfig(addr, nil))
+		}
+	}
+	if _, ok := os.LookupEnv(envClientCA); ok {
+		if !useTLS {
+			log.Fatalf("%s requires TLS to be enabled", envClientCA)
+		}
+		opts.ClientCerts.Required = true
+		if sans, ok := os.LookupEnv(envClientSANs); ok {
+			opts.ClientCerts.AllowedSANs = strings.Split(sans, ",")
+		}
+	}
 
 	server, err := server.NewServer(opts)
 	if err != nil {
 		log.Fatalf("Failed to start server: %+v", err)
 	}
 	log.Println("Server dependencies initialized")
-	server.RegisterHandlers(http.DefaultServeMux)
+	log.Printf("Effective configuration: %s", server.ConfigSummary())
+	handler := server.Handler()
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	var gs *grpc.Server
+	if grpcAddr, ok := os.LookupEnv(envGRPCAddress); ok {
+		var grpcOpts []grpc.ServerOption
+		if useTLS {
+			grpcOpts = append(grpcOpts, grpc.Creds(credentials.NewTLS(tlsConfig)))
+		}
+		gs = grpc.NewServer(grpcOpts...)
+		server.RegisterGRPC(gs)
 
-	addr, tls, certFile, keyFile := getServerConfig()
-	if tls {
-		log.Printf("Running HTTPS server at %s", addr)
-		log.Fatal(http.ListenAndServeTLS(addr, certFile, keyFile, nil))
-	} else {
-		log.Printf("Running HTTP server at %s", addr)
-		log.Fatal(http.ListenAndServe(addr, nil))
+		lis, err := net.Listen("tcp", grpcAddr)
+		if err != nil {
+			log.Fatalf("Failed to listen for gRPC at %s: %+v", grpcAddr, err)
+		}
+		log.Printf("Running gRPC server at %s", grpcAddr)
+		go func() {
+			if err := gs.Serve(lis); err != nil {
+				log.Fatalf("gRPC server failed: %+v", err)
+			}
+		}()
+	}
+
+	if err := server.ListenAndServe(ctx, handler); err != nil {
+		log.Printf("ERROR: HTTP server failed: %+v", err)
+	}
+	if gs != nil {
+		timer := time.AfterFunc(shutdownTimeout, gs.Stop)
+		gs.GracefulStop()
+		timer.Stop()
 	}
+	server.Close()
+	log.Printf("Server stopped")
 }
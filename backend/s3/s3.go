@@ -0,0 +1,248 @@
+// Package s3 implements a secret store backed by an object storage bucket with an S3-compatible
+// API, such as Amazon S3, Google Cloud Storage, or MinIO.
+package s3
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/newgrp/timecapsule/keys"
+	"github.com/newgrp/timecapsule/sigv4"
+)
+
+// Timeout of each request to the object store.
+const requestTimeout = 30 * time.Second
+
+// Largest error response body from the object store that is included in errors.
+const maxErrorBody = 1024
+
+// Default signing region.
+const defaultRegion = "us-east-1"
+
+// Names of secret objects, relative to the prefix. The basic ISO 8601 format needs no escaping.
+const objectLayout = "20060102T150405Z"
+
+// Server-side encryption modes.
+const (
+	// Encryption with keys managed by the object store.
+	SSES3 = "AES256"
+	// Encryption with a key in AWS KMS.
+	SSEKMS = "aws:kms"
+)
+
+// Configuration of an object storage secret store.
+type Config struct {
+	// Base URL of the service, such as https://storage.googleapis.com for Google Cloud Storage.
+	// Buckets are addressed by path. If empty, Amazon S3 in the region is used, with buckets
+	// addressed by host name.
+	Endpoint string
+	// Signing region. If empty, us-east-1 is used.
+	Region string
+	Bucket string
+	// Prefix of the names of the PKI's secret objects, such as "timecapsule/". Each PKI needs its
+	// own prefix.
+	Prefix string
+
+	// Server-side encryption mode: empty for the bucket default, SSES3, or SSEKMS.
+	ServerSideEncryption string
+	// AWS KMS key for SSEKMS. If empty, the account's default key for S3 is used.
+	SSEKMSKeyID string
+
+	Credentials sigv4.Credentials
+
+	// If nil, a client with a default timeout is used.
+	Client *http.Client
+}
+
+// Secret store that keeps each secret in its own object, so that any number of server replicas
+// can share one set of secrets.
+//
+// Secrets are written with conditional requests so that an existing secret is never overwritten,
+// even by replicas racing to create it. Since secrets never change once written, secrets that have
+// been read are cached in memory.
+//
+// The credentials need permission to list the bucket, so that reading a missing secret returns
+// 404 Not Found rather than 403 Forbidden.
+type Store struct {
+	cfg    Config
+	client *http.Client
+	// URL of the bucket, ending in a slash.
+	base string
+
+	mu    sync.Mutex
+	cache map[int64][]byte
+}
+
+// Returns an object storage secret store. No requests are made until the store is used.
+func New(cfg Config) (*Store, error) {
+	if cfg.Bucket == "" {
+		return nil, errors.New("no bucket provided")
+	}
+	switch cfg.ServerSideEncryption {
+	case "", SSES3:
+		if cfg.SSEKMSKeyID != "" {
+			return nil, fmt.Errorf("a KMS key requires %s server-side encryption", SSEKMS)
+		}
+	case SSEKMS:
+	default:
+		return nil, fmt.Errorf("unsupported server-side encryption %q: must be %q or %q", cfg.ServerSideEncryption, SSES3, SSEKMS)
+	}
+	if cfg.Region == "" {
+		cfg.Region = defaultRegion
+	}
+	base := fmt.Sprintf("https://%s.s3.%s.amazonaws.com/", cfg.Bucket, cfg.Region)
+	if cfg.Endpoint != "" {
+		base = strings.TrimSuffix(cfg.Endpoint, "/") + sigv4.EscapePath("/"+cfg.Bucket) + "/"
+	}
+	if _, err := url.Parse(base); err != nil {
+		return nil, fmt.Errorf("invalid endpoint: %w", err)
+	}
+	client := cfg.Client
+	if client == nil {
+		client = &http.Client{Timeout: requestTimeout}
+	}
+	return &Store{cfg: cfg, client: client, base: base, cache: make(map[int64][]byte)}, nil
+}
+
+// Sends a signed request for an object, or for the bucket if key is empty. Returns the response
+// body and status code. Status codes other than 2xx are errors, except for those in allowed.
+func (s *Store) do(method, key string, query url.Values, header http.Header, body []byte, allowed ...int) ([]byte, int, error) {
+	u := s.base + sigv4.EscapePath(key)
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequest(method, u, bytes.NewReader(body))
+	if err != nil {
+		return nil, 0, err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	req.Header.Set("X-Amz-Content-Sha256", sigv4.HashPayload(body))
+	sigv4.Sign(req, body, s.cfg.Credentials, s.cfg.Region, "s3", time.Now())
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode/100 != 2 && !slices.Contains(allowed, res.StatusCode) {
+		b, _ := io.ReadAll(io.LimitReader(res.Body, maxErrorBody))
+		return nil, res.StatusCode, fmt.Errorf("%s %s returned %s: %s", method, req.URL.Redacted(), res.Status, strings.TrimSpace(string(b)))
+	}
+	b, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, 0, err
+	}
+	return b, res.StatusCode, nil
+}
+
+// Returns the name of a bucket's secret object.
+func (s *Store) objectKey(bucket time.Time) string {
+	return s.cfg.Prefix + bucket.UTC().Format(objectLayout)
+}
+
+func (s *Store) Get(bucket time.Time) ([]byte, bool, error) {
+	s.mu.Lock()
+	secret, ok := s.cache[bucket.Unix()]
+	s.mu.Unlock()
+	if ok {
+		// Callers zero the secrets they are given, so the cache hands out copies.
+		return slices.Clone(secret), true, nil
+	}
+
+	secret, status, err := s.do(http.MethodGet, s.objectKey(bucket), nil, nil, nil, http.StatusNotFound)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to read secret for %s: %w", bucket.Format(time.RFC3339), err)
+	}
+	if status == http.StatusNotFound {
+		return nil, false, nil
+	}
+	s.mu.Lock()
+	s.cache[bucket.Unix()] = slices.Clone(secret)
+	s.mu.Unlock()
+	return secret, true, nil
+}
+
+func (s *Store) Put(bucket time.Time, secret []byte) error {
+	header := http.Header{}
+	header.Set("Content-Type", "application/octet-stream")
+	// Only create the object if it doesn't exist.
+	header.Set("If-None-Match", "*")
+	if s.cfg.ServerSideEncryption != "" {
+		header.Set("X-Amz-Server-Side-Encryption", s.cfg.ServerSideEncryption)
+	}
+	if s.cfg.SSEKMSKeyID != "" {
+		header.Set("X-Amz-Server-Side-Encryption-Aws-Kms-Key-Id", s.cfg.SSEKMSKeyID)
+	}
+	_, status, err := s.do(http.MethodPut, s.objectKey(bucket), nil, header, secret, http.StatusPreconditionFailed)
+	if err != nil {
+		return fmt.Errorf("failed to write secret for %s: %w", bucket.Format(time.RFC3339), err)
+	}
+	if status == http.StatusPreconditionFailed {
+		return fmt.Errorf("failed to write secret for %s: %w", bucket.Format(time.RFC3339), keys.ErrSecretExists)
+	}
+	s.mu.Lock()
+	s.cache[bucket.Unix()] = slices.Clone(secret)
+	s.mu.Unlock()
+	return nil
+}
+
+// Deletes a secret's object. In buckets with versioning enabled, earlier versions of the object are
+// kept, so the secret is only deleted once a lifecycle rule expires them.
+func (s *Store) Delete(bucket time.Time) error {
+	s.mu.Lock()
+	delete(s.cache, bucket.Unix())
+	s.mu.Unlock()
+	if _, _, err := s.do(http.MethodDelete, s.objectKey(bucket), nil, nil, nil, http.StatusNotFound); err != nil {
+		return fmt.Errorf("failed to delete secret for %s: %w", bucket.Format(time.RFC3339), err)
+	}
+	return nil
+}
+
+// Response to a ListObjectsV2 request.
+type listResult struct {
+	Contents []struct {
+		Key string
+	}
+	IsTruncated           bool
+	NextContinuationToken string
+}
+
+func (s *Store) List() ([]time.Time, error) {
+	var buckets []time.Time
+	query := url.Values{"list-type": {"2"}, "prefix": {s.cfg.Prefix}}
+	for {
+		body, _, err := s.do(http.MethodGet, "", query, nil, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list secrets: %w", err)
+		}
+		var result listResult
+		if err := xml.Unmarshal(body, &result); err != nil {
+			return nil, fmt.Errorf("failed to list secrets: invalid response: %w", err)
+		}
+		for _, c := range result.Contents {
+			t, err := time.Parse(objectLayout, strings.TrimPrefix(c.Key, s.cfg.Prefix))
+			if err != nil {
+				// Unrelated objects under the prefix.
+				continue
+			}
+			buckets = append(buckets, t)
+		}
+		if !result.IsTruncated {
+			break
+		}
+		query.Set("continuation-token", result.NextContinuationToken)
+	}
+	// Objects are listed in lexicographic order, which is chronological for secret objects.
+	return buckets, nil
+}
@@ -0,0 +1,189 @@
+package s3
+
+import (
+	"encoding/xml"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/newgrp/timecapsule/keys"
+	"github.com/newgrp/timecapsule/sigv4"
+)
+
+// In-memory fake of an S3-compatible bucket named "bucket".
+type fakeS3 struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+	// Server-side encryption requested for each object.
+	sse  map[string]string
+	gets int
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	body, _ := io.ReadAll(r.Body)
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=AKID/") ||
+		r.Header.Get("X-Amz-Content-Sha256") != sigv4.HashPayload(body) {
+		http.Error(w, "<Error><Code>AccessDenied</Code></Error>", http.StatusForbidden)
+		return
+	}
+	key, ok := strings.CutPrefix(r.URL.Path, "/bucket/")
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodGet && key == "":
+		// One object per page, to exercise pagination.
+		var names []string
+		for name := range f.objects {
+			if strings.HasPrefix(name, r.URL.Query().Get("prefix")) {
+				names = append(names, name)
+			}
+		}
+		slices.Sort(names)
+		if token := r.URL.Query().Get("continuation-token"); token != "" {
+			i, _ := slices.BinarySearch(names, token)
+			names = names[i:]
+		}
+		var result listResult
+		if len(names) > 0 {
+			result.Contents = append(result.Contents, struct{ Key string }{names[0]})
+		}
+		if len(names) > 1 {
+			result.IsTruncated = true
+			result.NextContinuationToken = names[1]
+		}
+		xml.NewEncoder(w).Encode(result)
+	case r.Method == http.MethodGet:
+		f.gets++
+		obj, ok := f.objects[key]
+		if !ok {
+			http.Error(w, "<Error><Code>NoSuchKey</Code></Error>", http.StatusNotFound)
+			return
+		}
+		w.Write(obj)
+	case r.Method == http.MethodPut:
+		if _, ok := f.objects[key]; ok && r.Header.Get("If-None-Match") == "*" {
+			http.Error(w, "<Error><Code>PreconditionFailed</Code></Error>", http.StatusPreconditionFailed)
+			return
+		}
+		f.objects[key] = body
+		f.sse[key] = r.Header.Get("X-Amz-Server-Side-Encryption")
+	default:
+		http.Error(w, "", http.StatusMethodNotAllowed)
+	}
+}
+
+func newFake(t *testing.T) (*fakeS3, Config) {
+	f := &fakeS3{objects: map[string][]byte{}, sse: map[string]string{}}
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+	return f, Config{
+		Endpoint:    srv.URL,
+		Bucket:      "bucket",
+		Prefix:      "pki/",
+		Credentials: sigv4.Credentials{AccessKeyID: "AKID", SecretAccessKey: "secret"},
+		Client:      srv.Client(),
+	}
+}
+
+func TestStore(t *testing.T) {
+	f, cfg := newFake(t)
+	cfg.ServerSideEncryption = SSES3
+	s, err := New(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create store: %+v", err)
+	}
+	f.objects["other/20240901T000000Z"] = []byte("another PKI")
+	f.objects["pki/README"] = []byte("unrelated")
+
+	b0 := time.Date(2024, time.September, 1, 0, 0, 0, 0, time.UTC)
+	b1 := b0.Add(time.Hour)
+	if _, ok, err := s.Get(b0); err != nil || ok {
+		t.Errorf("Got a missing secret: ok = %v, err = %v", ok, err)
+	}
+	for _, b := range []time.Time{b1, b0} {
+		if err := s.Put(b, []byte(b.String())); err != nil {
+			t.Fatalf("Failed to put secret: %+v", err)
+		}
+	}
+	if err := s.Put(b0, []byte("overwrite")); !errors.Is(err, keys.ErrSecretExists) {
+		t.Errorf("Got wrong error for overwriting a secret: got %v, want %v", err, keys.ErrSecretExists)
+	}
+	if got := f.sse["pki/20240901T000000Z"]; got != SSES3 {
+		t.Errorf("Requested server-side encryption %q, want %q", got, SSES3)
+	}
+
+	// Secrets are cached, so reading them again makes no requests.
+	fresh, err := New(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create store: %+v", err)
+	}
+	for range 2 {
+		secret, ok, err := fresh.Get(b1)
+		if err != nil || !ok {
+			t.Fatalf("Failed to get secret: ok = %v, err = %v", ok, err)
+		}
+		if string(secret) != b1.String() {
+			t.Errorf("Got wrong secret: got %q, want %q", secret, b1.String())
+		}
+	}
+	if f.gets != 2 {
+		t.Errorf("Made %d requests for objects, want 2", f.gets)
+	}
+
+	buckets, err := s.List()
+	if err != nil {
+		t.Fatalf("Failed to list buckets: %+v", err)
+	}
+	if want := []time.Time{b0, b1}; !slices.EqualFunc(buckets, want, time.Time.Equal) {
+		t.Errorf("Listed wrong buckets: got %v, want %v", buckets, want)
+	}
+
+	if _, err := New(Config{Bucket: "bucket", ServerSideEncryption: "rot13"}); err == nil {
+		t.Errorf("Accepted unsupported server-side encryption")
+	}
+}
+
+func TestSharedReplicas(t *testing.T) {
+	_, cfg := newFake(t)
+	minTime := time.Date(2024, time.September, 1, 0, 0, 0, 0, time.UTC)
+	maxTime := minTime.Add(3 * time.Hour)
+	id := uuid.New()
+
+	var managers []*keys.KeyManager
+	for range 2 {
+		store, err := New(cfg)
+		if err != nil {
+			t.Fatalf("Failed to create store: %+v", err)
+		}
+		km, err := keys.NewKeyManager(keys.PKIOptions{Name: "Replica Test", ID: id, MinTime: minTime, MaxTime: maxTime, SecretStore: store}, t.TempDir())
+		if err != nil {
+			t.Fatalf("Failed to create key manager: %+v", err)
+		}
+		managers = append(managers, km)
+	}
+	for t0 := minTime; t0.Before(maxTime); t0 = t0.Add(time.Hour) {
+		k0, err := managers[0].GetKeyForTime(t0)
+		if err != nil {
+			t.Fatalf("Failed to get key: %+v", err)
+		}
+		k1, err := managers[1].GetKeyForTime(t0)
+		if err != nil {
+			t.Fatalf("Failed to get key: %+v", err)
+		}
+		if !k0.Equal(k1) {
+			t.Errorf("Replicas derived different keys for %s", t0.Format(time.RFC3339))
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Header that carries the request ID, both from a proxy and back to the client.
+const requestIDHeader = "X-Request-ID"
+
+// Longest request ID accepted from a client or proxy.
+const maxRequestIDLength = 64
+
+// Query parameters whose values are never logged. None of the API's parameters are secret, but
+// clients sometimes put credentials in the query string by mistake.
+var redactedParams = map[string]bool{
+	"access_token": true,
+	"api_key":      true,
+	"token":        true,
+}
+
+type requestIDKey struct{}
+
+// Returns the ID of the request being served with the given context, or "" if there is none.
+func requestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// Returns a new random request ID.
+func newRequestID() string {
+	var b [8]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// Whether a request ID supplied by a client or proxy is safe to log and echo.
+func validRequestID(id string) bool {
+	if len(id) == 0 || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range id {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_' || c == '.') {
+			return false
+		}
+	}
+	return true
+}
+
+// Returns the address of the client that made a request, preferring the first address in
+// X-Forwarded-For. Suitable for logging only, since the header is under the client's control.
+func forwardedClientIP(req *http.Request) string {
+	if fwd := req.Header.Get("X-Forwarded-For"); fwd != "" {
+		first, _, _ := strings.Cut(fwd, ",")
+		if first = strings.TrimSpace(first); first != "" {
+			return first
+		}
+	}
+	return clientIP(req)
+}
+
+// Returns the query parameters of a request for logging, with secret values redacted.
+func loggableQuery(query url.Values) string {
+	redacted := url.Values{}
+	for k, vs := range query {
+		if redactedParams[strings.ToLower(k)] {
+			vs = []string{"REDACTED"}
+		}
+		redacted[k] = vs
+	}
+	return redacted.Encode()
+}
+
+// Response writer that records the status code.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	return r.ResponseWriter.Write(b)
+}
+
+// Lets http.ResponseController reach the underlying writer, e.g. to flush unlock streams.
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+func (r *statusRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		if r.status == 0 {
+			r.status = http.StatusOK
+		}
+		f.Flush()
+	}
+}
+
+// Wraps a handler so that every request is assigned an ID, echoed in the X-Request-ID response
+// header, and, if access logging is enabled, logged once it completes.
+//
+// A request ID supplied by a proxy in X-Request-ID is kept so that logs can be correlated across
+// both. Requests that fail with a server error are logged as errors even if access logging is
+// disabled.
+func (s *Server) accessLog(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		start := time.Now()
+		id := req.Header.Get(requestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		resp.Header().Set(requestIDHeader, id)
+		rec := &statusRecorder{ResponseWriter: resp}
+		h.ServeHTTP(rec, req.WithContext(context.WithValue(req.Context(), requestIDKey{}, id)))
+
+		status := rec.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		if !s.opts.AccessLog && status < http.StatusInternalServerError {
+			return
+		}
+		level := "INFO"
+		if status >= http.StatusInternalServerError {
+			level = "ERROR"
+		}
+		log.Printf("%s: request_id=%s method=%s path=%q params=%q status=%d latency=%v client=%s",
+			level, id, req.Method, req.URL.Path, loggableQuery(req.URL.Query()), status,
+			time.Since(start).Round(time.Microsecond), forwardedClientIP(req))
+	})
+}
@@ -0,0 +1,83 @@
+package server
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/newgrp/timecapsule/keys"
+)
+
+type RegisterEventResp struct {
+	Name string `json:"name"`
+	Time string `json:"time"`
+}
+
+// Wraps a handler so that it requires one of the configured admin tokens as a bearer token.
+func (s *Server) requireAdmin(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		token, ok := bearerToken(req.Header.Get("Authorization"))
+		if !ok || !containsToken(s.opts.AdminTokens, token) {
+			resp.Header().Set("WWW-Authenticate", "Bearer")
+			resp.WriteHeader(http.StatusUnauthorized)
+			resp.Write([]byte("Valid admin token required\n"))
+			return
+		}
+		h.ServeHTTP(resp, req)
+	})
+}
+
+// Extracts the token from an Authorization header value of the form "Bearer <token>".
+func bearerToken(authorization string) (string, bool) {
+	return strings.CutPrefix(authorization, "Bearer ")
+}
+
+// Whether the given token is one of the given valid tokens. The empty token is never valid.
+func containsToken(tokens []string, token string) bool {
+	if token == "" {
+		return false
+	}
+	// Compare against every token in constant time so as not to leak which prefix matched.
+	ok := 0
+	for _, t := range tokens {
+		ok |= subtle.ConstantTimeCompare([]byte(token), []byte(t))
+	}
+	return ok == 1
+}
+
+// Simple handler for event registration requests.
+func (s *Server) registerEvent(query url.Values) (*RegisterEventResp, int, string) {
+	km, status, message := s.selectPKI(query)
+	if status != http.StatusOK {
+		return nil, status, message
+	}
+	if !query.Has(argName) {
+		return nil, http.StatusBadRequest, fmt.Sprintf("%q parameter is required", argName)
+	}
+	name := query.Get(argName)
+	if !query.Has(argTime) {
+		return nil, http.StatusBadRequest, fmt.Sprintf("%q parameter is required", argTime)
+	}
+	t, err := parseTime(query.Get(argTime))
+	if err != nil {
+		return nil, http.StatusBadRequest, fmt.Sprintf("Invalid %q parameter: %v", argTime, err)
+	}
+
+	if err := km.RegisterEvent(name, t); err != nil {
+		if errors.Is(err, keys.ErrInvalidEvent) {
+			return nil, http.StatusBadRequest, fmt.Sprintf("Failed to register event: %v", err)
+		}
+		log.Printf("ERROR: Failed to register event %q at %s in request %s: %+v", name, t.Format(time.RFC3339), query.Get(argRequestID), err)
+		return nil, http.StatusInternalServerError, "Server failed to register event"
+	}
+	log.Printf("Registered event %q at %s for PKI %s", name, t.Format(time.RFC3339), km.PKIID())
+	return &RegisterEventResp{
+		Name: name,
+		Time: t.UTC().Format(time.RFC3339),
+	}, http.StatusOK, ""
+}
@@ -0,0 +1,40 @@
+package server
+
+import (
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+type GetAvailabilityResp struct {
+	// Whether the private key for the requested time can be retrieved now.
+	Available bool `json:"available"`
+	// Earliest time at which the private key is released, in RFC 3339 format.
+	ReleaseTime string `json:"releaseTime"`
+	// Seconds until the private key is released according to the secure clock, or 0 if it is
+	// already available.
+	Remaining float64 `json:"remaining"`
+}
+
+// Simple handler for availability requests.
+func (s *Server) getAvailability(query url.Values) (*GetAvailabilityResp, int, string) {
+	_, t, status, message := s.parseTarget(query)
+	if status != http.StatusOK {
+		return nil, status, message
+	}
+
+	now, err := s.earliestNow()
+	if err != nil {
+		log.Printf("ERROR: Failed to determine the current time securely in request %s: %+v", query.Get(argRequestID), err)
+		return nil, http.StatusServiceUnavailable, noTimeError
+	}
+
+	release := s.releaseTime(t)
+	remaining := max(release.Sub(now), 0)
+	return &GetAvailabilityResp{
+		Available:   remaining == 0,
+		ReleaseTime: release.UTC().Format(time.RFC3339),
+		Remaining:   remaining.Seconds(),
+	}, http.StatusOK, ""
+}
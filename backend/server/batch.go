@@ -0,0 +1,227 @@
+package server
+
+import (
+	"crypto/ecdh"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/newgrp/timecapsule/keys"
+)
+
+// Maximum number of keys that may be requested in a single batch.
+const maxBatchSize = 1000
+
+// Maximum size of a JSON request body in bytes.
+const maxBodySize = 1 << 20
+
+// Request body for POST /v0/get_public_keys.
+type GetPublicKeysReq struct {
+	PKIID string   `json:"pkiID,omitempty"`
+	Times []string `json:"times"`
+}
+
+type PublicKeyEntry struct {
+	Time string `json:"time"`
+	SPKI []byte `json:"spki"`
+}
+
+type GetPublicKeysResp struct {
+	PKIName string           `json:"pkiName"`
+	PKIID   string           `json:"pkiID"`
+	Keys    []PublicKeyEntry `json:"keys"`
+
+	DerivationInfo
+}
+
+// Converts the request body to the equivalent query parameters.
+func (r *GetPublicKeysReq) query() url.Values {
+	query := url.Values{argTime: r.Times}
+	if r.PKIID != "" {
+		query.Set(argPKIID, r.PKIID)
+	}
+	return query
+}
+
+// Wraps a handler so that it accepts its parameters as a JSON body of type T instead of a query
+// string. The body is translated to query parameters and then served by the wrapped handler.
+func jsonBodyAsQuery[T interface{ query() url.Values }](h http.Handler) http.Handler {
+	return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		var body T
+		d := json.NewDecoder(io.LimitReader(req.Body, maxBodySize))
+		d.DisallowUnknownFields()
+		if err := d.Decode(&body); err != nil {
+			resp.WriteHeader(http.StatusBadRequest)
+			resp.Write([]byte(fmt.Sprintf("Could not parse request body: %v\n", err)))
+			return
+		}
+
+		req.URL.RawQuery = body.query().Encode()
+		h.ServeHTTP(resp, req)
+	})
+}
+
+// Simple handler for batch public key requests.
+func (s *Server) getPublicKeys(query url.Values) (*GetPublicKeysResp, int, string) {
+	km, status, message := s.selectPKI(query)
+	if status != http.StatusOK {
+		return nil, status, message
+	}
+
+	strs := query[argTime]
+	if len(strs) == 0 {
+		return nil, http.StatusBadRequest, fmt.Sprintf("At least one %q parameter is required", argTime)
+	}
+	if len(strs) > maxBatchSize {
+		return nil, http.StatusBadRequest, fmt.Sprintf("At most %d times may be requested at once", maxBatchSize)
+	}
+
+	entries := make([]PublicKeyEntry, 0, len(strs))
+	for _, str := range strs {
+		t, err := parseTime(str)
+		if err != nil {
+			return nil, http.StatusBadRequest, fmt.Sprintf("Invalid %q parameter %q: %v", argTime, str, err)
+		}
+		if err := km.CheckTime(t); err != nil {
+			return nil, http.StatusBadRequest, fmt.Sprintf("Invalid time %q: %v", str, err)
+		}
+
+		der, status, message := publicKeySPKI(km, t, query.Get(argRequestID))
+		if status != http.StatusOK {
+			return nil, status, message
+		}
+		entries = append(entries, PublicKeyEntry{
+			Time: t.UTC().Format(time.RFC3339),
+			SPKI: der,
+		})
+	}
+
+	return &GetPublicKeysResp{
+		PKIName:        km.Name(),
+		PKIID:          km.PKIID().String(),
+		Keys:           entries,
+		DerivationInfo: derivationInfo(km),
+	}, http.StatusOK, ""
+}
+
+type PrivateKeyEntry struct {
+	Time  string `json:"time"`
+	PKCS8 []byte `json:"pkcs8,omitempty"`
+
+	// If the request specified a key to wrap to, each PKCS #8 message is instead HPKE-sealed to that
+	// key separately, as in GetPrivateKeyResp.
+	Enc    []byte `json:"enc,omitempty"`
+	Sealed []byte `json:"sealed,omitempty"`
+}
+
+type GetPrivateKeysResp struct {
+	PKIName string            `json:"pkiName"`
+	PKIID   string            `json:"pkiID"`
+	Keys    []PrivateKeyEntry `json:"keys"`
+
+	DerivationInfo
+}
+
+// Parses a required time parameter and checks that it is within a PKI's time range, unless km is
+// nil. Returns (time, HTTP status code, error message).
+func parseTimeArg(km *keys.KeyManager, query url.Values, arg string) (time.Time, int, string) {
+	if !query.Has(arg) {
+		return time.Time{}, http.StatusBadRequest, fmt.Sprintf("%q parameter is required", arg)
+	}
+	t, err := parseTime(query.Get(arg))
+	if err != nil {
+		return time.Time{}, http.StatusBadRequest, fmt.Sprintf("Invalid %q parameter: %v", arg, err)
+	}
+	if km == nil {
+		return t, http.StatusOK, ""
+	}
+	if err := km.CheckTime(t); err != nil {
+		return time.Time{}, http.StatusBadRequest, fmt.Sprintf("Invalid %q parameter: %v", arg, err)
+	}
+	return t, http.StatusOK, ""
+}
+
+// Simple handler for batch private key requests.
+//
+// Returns the keys for times start, start + step, start + 2*step, and so on through end. The step
+// is given as a Go duration string and defaults to the secret interval. The whole range must be
+// in the past. If "wrap_to" is given, each key is HPKE-sealed to it.
+func (s *Server) getPrivateKeys(query url.Values) (*GetPrivateKeysResp, int, string) {
+	km, status, message := s.selectPKI(query)
+	if status != http.StatusOK {
+		return nil, status, message
+	}
+	start, status, message := parseTimeArg(km, query, argStart)
+	if status != http.StatusOK {
+		return nil, status, message
+	}
+	end, status, message := parseTimeArg(km, query, argEnd)
+	if status != http.StatusOK {
+		return nil, status, message
+	}
+	if end.Before(start) {
+		return nil, http.StatusBadRequest, fmt.Sprintf("%q must not be before %q", argEnd, argStart)
+	}
+
+	step := km.DerivationParams().Interval
+	if query.Has(argStep) {
+		var err error
+		step, err = time.ParseDuration(query.Get(argStep))
+		if err != nil {
+			return nil, http.StatusBadRequest, fmt.Sprintf("Invalid %q parameter: %v", argStep, err)
+		}
+		if step < time.Second {
+			return nil, http.StatusBadRequest, fmt.Sprintf("%q must be at least 1s", argStep)
+		}
+	}
+	if n := end.Sub(start)/step + 1; n > maxBatchSize {
+		return nil, http.StatusBadRequest, fmt.Sprintf("At most %d keys may be requested at once", maxBatchSize)
+	}
+
+	var wrapTo *ecdh.PublicKey
+	if query.Has(argWrapTo) {
+		var err error
+		wrapTo, err = parseWrapKey(query.Get(argWrapTo))
+		if err != nil {
+			return nil, http.StatusBadRequest, fmt.Sprintf("Invalid %q parameter: %v", argWrapTo, err)
+		}
+	}
+
+	// Checking the end of the range suffices, since the rest of the range is earlier.
+	if _, status, message := s.checkDisclosable(km, end, query.Get(argRequestID)); status != http.StatusOK {
+		return nil, status, message
+	}
+
+	var entries []PrivateKeyEntry
+	for t := start; t.Compare(end) <= 0; t = t.Add(step) {
+		der, status, message := privateKeyPKCS8(km, t, query.Get(argRequestID))
+		if status != http.StatusOK {
+			return nil, status, message
+		}
+		entry := PrivateKeyEntry{
+			Time:  t.UTC().Format(time.RFC3339),
+			PKCS8: der,
+		}
+		if wrapTo != nil {
+			var err error
+			entry.Enc, entry.Sealed, err = keys.Wrap(wrapTo, der)
+			if err != nil {
+				log.Printf("ERROR: Failed to wrap private key for time %s in request %s: %+v", t.Format(time.RFC3339), query.Get(argRequestID), err)
+				return nil, http.StatusInternalServerError, "Server failed to retrieve private key"
+			}
+			entry.PKCS8 = nil
+		}
+		entries = append(entries, entry)
+	}
+
+	return &GetPrivateKeysResp{
+		PKIName:        km.Name(),
+		PKIID:          km.PKIID().String(),
+		Keys:           entries,
+		DerivationInfo: derivationInfo(km),
+	}, http.StatusOK, ""
+}
@@ -0,0 +1,122 @@
+package server
+
+import (
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Maximum number of keys in a bundle: a leap year of hourly keys.
+const maxBundleSize = 366 * 24
+
+// Public key in a bundle, signed as in GetPublicKeyResp.
+type BundleEntry struct {
+	Time      string `json:"time"`
+	SPKI      []byte `json:"spki"`
+	Signature []byte `json:"signature"`
+}
+
+// Bundle of a PKI's public keys for a range of times.
+//
+// Every key carries its own signature by the PKI signing key, so the bundle can be verified offline
+// after being fetched once.
+type PublicKeyBundle struct {
+	PKIName      string        `json:"pkiName"`
+	PKIID        string        `json:"pkiID"`
+	SigningKeyID string        `json:"signingKeyID"`
+	Keys         []BundleEntry `json:"keys"`
+
+	DerivationInfo
+}
+
+// Encodes a bundle as concatenated PEM "PUBLIC KEY" blocks, each with headers giving its PKI,
+// time, and signature.
+func (b *PublicKeyBundle) pem() []byte {
+	var data []byte
+	for _, e := range b.Keys {
+		data = append(data, pem.EncodeToMemory(&pem.Block{
+			Type: "PUBLIC KEY",
+			Headers: map[string]string{
+				"PKI-ID":         b.PKIID,
+				"Time":           e.Time,
+				"Signature":      base64.StdEncoding.EncodeToString(e.Signature),
+				"Signing-Key-ID": b.SigningKeyID,
+			},
+			Bytes: e.SPKI,
+		})...)
+	}
+	return data
+}
+
+// Simple handler for public key bundle requests.
+//
+// Returns the public keys for times from, from + step, from + 2*step, and so on through to. The
+// step is given as a Go duration string and defaults to the secret interval. The bundle is encoded
+// as JSON by default or as PEM if "format=pem" is given.
+func (s *Server) getPublicKeyBundle(query url.Values) (any, int, string) {
+	format, status, message := parseFormat(query)
+	if status != http.StatusOK {
+		return nil, status, message
+	}
+	if format != formatJSON && format != formatPEM {
+		return nil, http.StatusBadRequest, fmt.Sprintf("Invalid %q parameter: must be %q or %q", argFormat, formatJSON, formatPEM)
+	}
+
+	km, status, message := s.selectPKI(query)
+	if status != http.StatusOK {
+		return nil, status, message
+	}
+	from, status, message := parseTimeArg(km, query, argFrom)
+	if status != http.StatusOK {
+		return nil, status, message
+	}
+	to, status, message := parseTimeArg(km, query, argTo)
+	if status != http.StatusOK {
+		return nil, status, message
+	}
+	if to.Before(from) {
+		return nil, http.StatusBadRequest, fmt.Sprintf("%q must not be before %q", argTo, argFrom)
+	}
+
+	step := km.DerivationParams().Interval
+	if query.Has(argStep) {
+		var err error
+		step, err = time.ParseDuration(query.Get(argStep))
+		if err != nil {
+			return nil, http.StatusBadRequest, fmt.Sprintf("Invalid %q parameter: %v", argStep, err)
+		}
+		if step < time.Second {
+			return nil, http.StatusBadRequest, fmt.Sprintf("%q must be at least 1s", argStep)
+		}
+	}
+	if n := to.Sub(from)/step + 1; n > maxBundleSize {
+		return nil, http.StatusBadRequest, fmt.Sprintf("At most %d keys may be bundled at once", maxBundleSize)
+	}
+
+	bundle := &PublicKeyBundle{
+		PKIName:        km.Name(),
+		PKIID:          km.PKIID().String(),
+		SigningKeyID:   km.SigningKeyID(),
+		Keys:           []BundleEntry{},
+		DerivationInfo: derivationInfo(km),
+	}
+	for t := from; t.Compare(to) <= 0; t = t.Add(step) {
+		der, status, message := publicKeySPKI(km, t, query.Get(argRequestID))
+		if status != http.StatusOK {
+			return nil, status, message
+		}
+		bundle.Keys = append(bundle.Keys, BundleEntry{
+			Time:      t.UTC().Format(time.RFC3339),
+			SPKI:      der,
+			Signature: km.SignPublicKey(t, der),
+		})
+	}
+
+	if format == formatPEM {
+		return &rawBody{contentType: formatContentTypes[formatPEM], data: bundle.pem()}, http.StatusOK, ""
+	}
+	return bundle, http.StatusOK, ""
+}
@@ -0,0 +1,99 @@
+package server
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Lifetime of cached public key responses. The public key for a given time never changes, but it
+// stops being served once its time is revoked, so this bounds how long caches keep handing out a
+// revoked key. Caches revalidate with the ETag afterwards, which is cheap while the key is valid.
+const publicKeyMaxAge = time.Hour
+
+// Response writer that holds the response in memory until it is complete. Headers are written
+// through to the underlying response.
+type bufferedResponse struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (b *bufferedResponse) Header() http.Header {
+	return b.header
+}
+
+func (b *bufferedResponse) WriteHeader(status int) {
+	b.status = status
+}
+
+func (b *bufferedResponse) Write(data []byte) (int, error) {
+	return b.body.Write(data)
+}
+
+// Whether an If-None-Match header value matches an entity tag.
+func etagMatches(ifNoneMatch string, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// Wraps a public key handler so that successful responses may be cached.
+//
+// Successful responses are given an ETag derived from their body, a Last-Modified time of the
+// start of the PKI's time range, and a Cache-Control header that lets caches store them for
+// publicKeyMaxAge. They are not marked immutable, since revocation withdraws them. Requests whose
+// If-None-Match header matches the ETag get an empty 304 response. Responses to requests for times
+// relative to the current time are not marked cacheable, since the same request later resolves to
+// a different key.
+func (s *Server) cachePublicKeys(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		buf := &bufferedResponse{header: resp.Header(), status: http.StatusOK}
+		h.ServeHTTP(buf, req)
+
+		query, err := requestQuery(req)
+		if buf.status != http.StatusOK || err != nil || query.Has(argIn) {
+			resp.WriteHeader(buf.status)
+			resp.Write(buf.body.Bytes())
+			return
+		}
+		// The request has already succeeded, so the PKI exists.
+		km, _, _ := s.selectPKI(query)
+
+		sum := sha256.Sum256(buf.body.Bytes())
+		etag := fmt.Sprintf("%q", base64.RawURLEncoding.EncodeToString(sum[:]))
+		resp.Header().Set("ETag", etag)
+		resp.Header().Set("Last-Modified", km.MinTime().UTC().Format(http.TimeFormat))
+		resp.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(publicKeyMaxAge.Seconds())))
+		// The response depends on headers that may supply parameters, so caches must key on them.
+		resp.Header().Add("Vary", "Accept")
+		for _, header := range slices.Sorted(maps.Values(headerParams)) {
+			resp.Header().Add("Vary", header)
+		}
+
+		if etagMatches(req.Header.Get("If-None-Match"), etag) {
+			resp.WriteHeader(http.StatusNotModified)
+			return
+		}
+		resp.WriteHeader(http.StatusOK)
+		resp.Write(buf.body.Bytes())
+	})
+}
+
+// Wraps a private key handler so that its responses are never stored by caches.
+func noStore(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		resp.Header().Set("Cache-Control", "no-store")
+		h.ServeHTTP(resp, req)
+	})
+}
@@ -0,0 +1,86 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http"
+	"slices"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// Client certificate requirements of private key methods.
+//
+// Certificates are verified by the TLS listener, which must be configured to request client
+// certificates and verify them against the trusted CAs (e.g. with tls.VerifyClientCertIfGiven, so
+// that public key methods remain open to clients without certificates).
+type ClientCertOptions struct {
+	// Whether private key methods require a verified client certificate.
+	Required bool
+	// If non-empty, the client certificate must have a DNS name, email address, IP address, or URI
+	// subject alternative name in this list.
+	AllowedSANs []string
+}
+
+// Checks the client certificate of a connection against the requirements. Returns (HTTP status
+// code, error message).
+func (o *ClientCertOptions) check(state *tls.ConnectionState) (int, string) {
+	if !o.Required {
+		return http.StatusOK, ""
+	}
+	if state == nil || len(state.VerifiedChains) == 0 {
+		return http.StatusForbidden, "Verified client certificate required"
+	}
+	if len(o.AllowedSANs) == 0 {
+		return http.StatusOK, ""
+	}
+
+	leaf := state.VerifiedChains[0][0]
+	sans := slices.Concat(leaf.DNSNames, leaf.EmailAddresses)
+	for _, ip := range leaf.IPAddresses {
+		sans = append(sans, ip.String())
+	}
+	for _, uri := range leaf.URIs {
+		sans = append(sans, uri.String())
+	}
+	for _, san := range sans {
+		if slices.Contains(o.AllowedSANs, san) {
+			return http.StatusOK, ""
+		}
+	}
+	return http.StatusForbidden, "Client certificate is not authorized to retrieve private keys"
+}
+
+// Wraps a private key handler so that it enforces the client certificate requirements.
+func (s *Server) requireClientCert(h http.Handler) http.Handler {
+	if !s.opts.ClientCerts.Required {
+		return h
+	}
+	return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		if status, message := s.opts.ClientCerts.check(req.TLS); status != http.StatusOK {
+			writeText(resp, status, message)
+			return
+		}
+		h.ServeHTTP(resp, req)
+	})
+}
+
+// Checks that a gRPC call satisfies the client certificate requirements.
+func (s *Server) checkGRPCClientCert(ctx context.Context) error {
+	if !s.opts.ClientCerts.Required {
+		return nil
+	}
+	var state *tls.ConnectionState
+	if p, ok := peer.FromContext(ctx); ok {
+		if info, ok := p.AuthInfo.(credentials.TLSInfo); ok {
+			state = &info.State
+		}
+	}
+	if code, message := s.opts.ClientCerts.check(state); code != http.StatusOK {
+		return status.Error(codes.PermissionDenied, message)
+	}
+	return nil
+}
@@ -0,0 +1,133 @@
+package server
+
+import (
+	"compress/gzip"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Suffix added to the entity tags of gzip-compressed responses, which must differ from those of
+// uncompressed responses (RFC 9110, section 8.8.3).
+const gzipETagSuffix = "-gzip"
+
+// Whether an Accept-Encoding header value permits gzip.
+func acceptsGzip(acceptEncoding string) bool {
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		coding, params, _ := strings.Cut(part, ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		if coding != "gzip" && coding != "*" {
+			continue
+		}
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			var err error
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		return q > 0
+	}
+	return false
+}
+
+// Response writer that gzip-compresses the body once the response headers show that it is worth
+// compressing.
+//
+// Writing the status is deferred until the first write of the body, so that a content type may be
+// sniffed from the uncompressed body as net/http would otherwise do.
+type gzipResponse struct {
+	http.ResponseWriter
+	gz *gzip.Writer
+	// Status code passed to WriteHeader but not yet written.
+	status      int
+	wroteHeader bool
+}
+
+func (g *gzipResponse) WriteHeader(status int) {
+	if g.status == 0 {
+		g.status = status
+	}
+}
+
+// Writes the pending status, starting compression if appropriate.
+func (g *gzipResponse) writeHeader(data []byte) {
+	if g.wroteHeader {
+		return
+	}
+	g.wroteHeader = true
+	if g.status == 0 {
+		g.status = http.StatusOK
+	}
+
+	header := g.Header()
+	if header.Get("Content-Type") == "" && len(data) != 0 {
+		header.Set("Content-Type", http.DetectContentType(data))
+	}
+	// Event streams must be flushed event by event, and bodiless responses have nothing to compress.
+	compress := len(data) != 0 && header.Get("Content-Encoding") == "" &&
+		!strings.HasPrefix(header.Get("Content-Type"), "text/event-stream")
+	if compress {
+		header.Set("Content-Encoding", "gzip")
+		header.Del("Content-Length")
+		g.gz = gzip.NewWriter(g.ResponseWriter)
+	}
+	// A 304 response confirms the client's cached copy, which was compressed.
+	if etag := header.Get("ETag"); (compress || g.status == http.StatusNotModified) && strings.HasSuffix(etag, `"`) {
+		header.Set("ETag", etag[:len(etag)-1]+gzipETagSuffix+`"`)
+	}
+	g.ResponseWriter.WriteHeader(g.status)
+}
+
+func (g *gzipResponse) Write(data []byte) (int, error) {
+	g.writeHeader(data)
+	if g.gz != nil {
+		return g.gz.Write(data)
+	}
+	return g.ResponseWriter.Write(data)
+}
+
+// Completes the response.
+func (g *gzipResponse) close() {
+	g.writeHeader(nil)
+	if g.gz != nil {
+		g.gz.Close()
+	}
+}
+
+func (g *gzipResponse) Flush() {
+	g.writeHeader(nil)
+	if g.gz != nil {
+		g.gz.Flush()
+	}
+	if f, ok := g.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (g *gzipResponse) Unwrap() http.ResponseWriter {
+	return g.ResponseWriter
+}
+
+// Wraps a handler so that responses are gzip-compressed for clients that accept it.
+//
+// Compression matters most for the bundle and batch methods, whose JSON responses carry many
+// base64-encoded keys. Entity tags of compressed responses are given a suffix, which is removed
+// again from If-None-Match before the request reaches the handler.
+func compressResponses(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		resp.Header().Add("Vary", "Accept-Encoding")
+		if !acceptsGzip(req.Header.Get("Accept-Encoding")) {
+			h.ServeHTTP(resp, req)
+			return
+		}
+		if inm := req.Header.Get("If-None-Match"); inm != "" {
+			req = req.Clone(req.Context())
+			req.Header.Set("If-None-Match", strings.ReplaceAll(inm, gzipETagSuffix+`"`, `"`))
+		}
+
+		g := &gzipResponse{ResponseWriter: resp}
+		defer g.close()
+		h.ServeHTTP(g, req)
+	})
+}
@@ -0,0 +1,88 @@
+package server
+
+import (
+	"maps"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Cross-origin resource sharing policy of the public API. The admin API never permits cross-origin
+// requests.
+type CORSOptions struct {
+	// Origins permitted to make cross-origin requests, such as "https://example.com". "*" permits
+	// every origin. If empty, no cross-origin requests are permitted.
+	AllowedOrigins []string
+	// Methods permitted in cross-origin requests. If empty, GET and POST are permitted.
+	AllowedMethods []string
+	// Request headers permitted in cross-origin requests. If empty, Authorization, Content-Type, and
+	// the headers that may supply request parameters are permitted.
+	AllowedHeaders []string
+	// How long browsers may cache preflight responses. If zero, no Access-Control-Max-Age header is
+	// sent.
+	MaxAge time.Duration
+}
+
+// Returns the value of the Access-Control-Allow-Origin header for a request from the given origin,
+// or the empty string if the origin is not permitted.
+func (o *CORSOptions) allowOrigin(origin string) string {
+	if slices.Contains(o.AllowedOrigins, "*") {
+		return "*"
+	}
+	if origin != "" && slices.Contains(o.AllowedOrigins, origin) {
+		return origin
+	}
+	return ""
+}
+
+func (o *CORSOptions) allowMethods() string {
+	if len(o.AllowedMethods) == 0 {
+		return "GET, POST"
+	}
+	return strings.Join(o.AllowedMethods, ", ")
+}
+
+func (o *CORSOptions) allowHeaders() string {
+	if len(o.AllowedHeaders) != 0 {
+		return strings.Join(o.AllowedHeaders, ", ")
+	}
+	headers := append([]string{"Authorization", "Content-Type"}, slices.Sorted(maps.Values(headerParams))...)
+	return strings.Join(headers, ", ")
+}
+
+// Sets the CORS response headers for a request. Returns whether the request's origin is permitted.
+func (o *CORSOptions) setHeaders(resp http.ResponseWriter, req *http.Request) bool {
+	allow := o.allowOrigin(req.Header.Get("Origin"))
+	if allow != "*" {
+		// The response depends on the request's origin, so caches must not share it across origins.
+		resp.Header().Add("Vary", "Origin")
+	}
+	if allow == "" {
+		return false
+	}
+	resp.Header().Set("Access-Control-Allow-Origin", allow)
+	resp.Header().Set("Access-Control-Expose-Headers", requestIDHeader)
+	return true
+}
+
+// Wraps a handler so that its responses carry the configured CORS headers.
+func (s *Server) allowCORS(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		s.opts.CORS.setHeaders(resp, req)
+		h.ServeHTTP(resp, req)
+	})
+}
+
+// Handler for CORS preflight requests.
+func (s *Server) corsPreflight(resp http.ResponseWriter, req *http.Request) {
+	if s.opts.CORS.setHeaders(resp, req) && req.Header.Get("Access-Control-Request-Method") != "" {
+		resp.Header().Set("Access-Control-Allow-Methods", s.opts.CORS.allowMethods())
+		resp.Header().Set("Access-Control-Allow-Headers", s.opts.CORS.allowHeaders())
+		if s.opts.CORS.MaxAge > 0 {
+			resp.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(s.opts.CORS.MaxAge.Seconds())))
+		}
+	}
+	resp.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,138 @@
+package server
+
+import (
+	"encoding/xml"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/newgrp/timecapsule/keys"
+)
+
+// Number of most recently unlocked intervals listed in the feed.
+const feedLength = 24
+
+// Atom feed (RFC 4287).
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	ID      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Updated string      `xml:"updated"`
+	Author  atomAuthor  `xml:"author"`
+	Links   []atomLink  `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+}
+
+type atomEntry struct {
+	ID      string     `xml:"id"`
+	Title   string     `xml:"title"`
+	Updated string     `xml:"updated"`
+	Links   []atomLink `xml:"link"`
+	Summary string     `xml:"summary"`
+}
+
+// Handler for the Atom feed of unlocked intervals.
+//
+// Lists the most recently unlocked secret intervals of a PKI, newest first, each linking to the
+// private key for the start of the interval. Links are absolute, based on the host the request was
+// addressed to.
+func (s *Server) unlockFeed(resp http.ResponseWriter, req *http.Request) {
+	query, err := requestQuery(req)
+	if err != nil {
+		writeText(resp, http.StatusBadRequest, fmt.Sprintf("Could not parse request parameters: %v", err))
+		return
+	}
+	km, status, message := s.selectPKI(query)
+	if status != http.StatusOK {
+		writeText(resp, status, message)
+		return
+	}
+	now, err := s.clock.Now()
+	if err != nil {
+		log.Printf("ERROR: Failed to determine the current time securely in request %s: %+v", requestID(req.Context()), err)
+		writeText(resp, http.StatusServiceUnavailable, noTimeError)
+		return
+	}
+
+	scheme := "http"
+	if req.TLS != nil {
+		scheme = "https"
+	}
+	base := url.URL{Scheme: scheme, Host: req.Host}
+	link := func(method string, query url.Values) string {
+		u := base
+		u.Path = fmt.Sprintf("/v0/%s", method)
+		u.RawQuery = query.Encode()
+		return u.String()
+	}
+
+	pkiID := km.PKIID().String()
+	feed := &atomFeed{
+		ID:      fmt.Sprintf("urn:uuid:%s", pkiID),
+		Title:   fmt.Sprintf("Unlocked intervals of %s", km.Name()),
+		Updated: km.MinTime().UTC().Format(time.RFC3339),
+		Author:  atomAuthor{Name: km.Name()},
+		Links: []atomLink{{
+			Href: link(methodUnlockFeed, url.Values{argPKIID: []string{pkiID}}),
+			Rel:  "self",
+			Type: "application/atom+xml",
+		}},
+	}
+
+	interval := km.DerivationParams().Interval
+	t := now.Truncate(interval)
+	for now.Before(s.releaseTime(t)) {
+		t = t.Add(-interval)
+	}
+	for ; len(feed.Entries) < feedLength && km.CheckTime(t) == nil; t = t.Add(-interval) {
+		der, status, message := publicKeySPKI(km, t, requestID(req.Context()))
+		if status != http.StatusOK {
+			writeText(resp, status, message)
+			return
+		}
+		released := s.releaseTime(t).UTC().Format(time.RFC3339)
+		if len(feed.Entries) == 0 {
+			feed.Updated = released
+		}
+		targetQuery := url.Values{
+			argPKIID: []string{pkiID},
+			argTime:  []string{strconv.FormatInt(t.Unix(), 10)},
+		}
+		feed.Entries = append(feed.Entries, atomEntry{
+			ID:      fmt.Sprintf("urn:timecapsule:%s:%d", pkiID, t.Unix()),
+			Title:   fmt.Sprintf("Unlocked %s", t.UTC().Format(time.RFC3339)),
+			Updated: released,
+			Links: []atomLink{{
+				Href: link(methodGetPrivateKey, targetQuery),
+				Rel:  "alternate",
+				Type: "application/json",
+			}},
+			Summary: fmt.Sprintf("Private keys for the interval starting %s are available. Public key fingerprint: %s", t.UTC().Format(time.RFC3339), keys.Fingerprint(der)),
+		})
+	}
+
+	data, err := xml.MarshalIndent(feed, "", "  ")
+	if err != nil {
+		log.Printf("ERROR: Failed to encode feed in request %s: %+v", requestID(req.Context()), err)
+		writeText(resp, http.StatusInternalServerError, "Server failed to encode feed")
+		return
+	}
+	resp.Header().Set("Content-Type", "application/atom+xml")
+	resp.WriteHeader(http.StatusOK)
+	resp.Write([]byte(xml.Header))
+	resp.Write(data)
+	resp.Write([]byte("\n"))
+}
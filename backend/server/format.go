@@ -0,0 +1,191 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/newgrp/timecapsule/keys"
+)
+
+// Key encodings that may be requested with the "format" parameter.
+const (
+	// JSON response with base64 DER fields (the default).
+	formatJSON = "json"
+	// PEM-encoded key.
+	formatPEM = "pem"
+	// Raw DER-encoded key.
+	formatDER = "der"
+	// JSON Web Key.
+	formatJWK = "jwk"
+	// CBOR-encoded COSE_Key.
+	formatCOSE = "cose"
+)
+
+// Media types of non-JSON key encodings, keyed by format.
+var formatContentTypes = map[string]string{
+	formatPEM:  "application/x-pem-file",
+	formatDER:  "application/octet-stream",
+	formatJWK:  "application/jwk+json",
+	formatCOSE: "application/cose-key",
+}
+
+// Media type of CBOR-encoded responses. Any JSON response may be requested as CBOR instead via the
+// Accept header.
+const cborContentType = "application/cbor"
+
+// Response body that is written verbatim instead of being encoded as JSON.
+type rawBody struct {
+	contentType string
+	data        []byte
+}
+
+// Returns the media types named by an Accept header, in order of appearance. Quality values are
+// ignored.
+func acceptedMediaTypes(accept string) []string {
+	var mediaTypes []string
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		mediaTypes = append(mediaTypes, mediaType)
+	}
+	return mediaTypes
+}
+
+// Infers a key format from an Accept header, returning "" if the header doesn't name one of the
+// non-JSON key encodings.
+func formatFromAccept(accept string) string {
+	for _, mediaType := range acceptedMediaTypes(accept) {
+		for format, contentType := range formatContentTypes {
+			if mediaType == contentType {
+				return format
+			}
+		}
+	}
+	return ""
+}
+
+// Reports whether an Accept header asks for CBOR responses.
+func acceptsCBOR(accept string) bool {
+	return slices.Contains(acceptedMediaTypes(accept), cborContentType)
+}
+
+// Determines the key format requested by a query. Returns (format, HTTP status code, error
+// message).
+func parseFormat(query url.Values) (string, int, string) {
+	if !query.Has(argFormat) {
+		return formatJSON, http.StatusOK, ""
+	}
+	switch format := query.Get(argFormat); format {
+	case formatJSON, formatPEM, formatDER, formatJWK, formatCOSE:
+		return format, http.StatusOK, ""
+	default:
+		return "", http.StatusBadRequest, fmt.Sprintf("Invalid %q parameter: must be one of %q, %q, %q, %q, or %q", argFormat, formatJSON, formatPEM, formatDER, formatJWK, formatCOSE)
+	}
+}
+
+// Encodes a key in a non-JSON format, given its DER encoding and functions that produce its PEM,
+// JWK, and COSE_Key encodings.
+func encodeKey(format string, der []byte, toPEM func() (string, error), toJWK func() (*keys.JWK, error), toCOSE func() ([]byte, error)) (*rawBody, error) {
+	var data []byte
+	switch format {
+	case formatPEM:
+		p, err := toPEM()
+		if err != nil {
+			return nil, err
+		}
+		data = []byte(p)
+	case formatDER:
+		data = der
+	case formatJWK:
+		jwk, err := toJWK()
+		if err != nil {
+			return nil, err
+		}
+		data, err = json.Marshal(jwk)
+		if err != nil {
+			return nil, err
+		}
+		data = append(data, '\n')
+	case formatCOSE:
+		var err error
+		data, err = toCOSE()
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unsupported format %q", format)
+	}
+	return &rawBody{contentType: formatContentTypes[format], data: data}, nil
+}
+
+// Simple handler for public key requests in any format. Formats other than JSON cannot be combined
+// with hybrid mode.
+func (s *Server) getPublicKeyFormatted(query url.Values) (any, int, string) {
+	format, status, message := parseFormat(query)
+	if status != http.StatusOK {
+		return nil, status, message
+	}
+	if format != formatJSON && query.Has(argHybrid) {
+		return nil, http.StatusBadRequest, fmt.Sprintf("%q parameter cannot be combined with %q", argHybrid, argFormat)
+	}
+	resp, status, message := s.getPublicKey(query)
+	if status != http.StatusOK || format == formatJSON {
+		return resp, status, message
+	}
+
+	pub, err := keys.ParsePublicKeyAsSPKIDER(resp.SPKI)
+	if err != nil {
+		log.Printf("ERROR: Failed to parse public key in request %s: %+v", query.Get(argRequestID), err)
+		return nil, http.StatusInternalServerError, "Server failed to encode public key"
+	}
+	body, err := encodeKey(format, resp.SPKI,
+		func() (string, error) { return keys.FormatPublicKeyAsSPKIPEM(pub) },
+		func() (*keys.JWK, error) { return keys.FormatPublicKeyAsJWK(pub) },
+		func() ([]byte, error) { return keys.FormatPublicKeyAsCOSE(pub) })
+	if err != nil {
+		log.Printf("ERROR: Failed to encode public key as %s in request %s: %+v", format, query.Get(argRequestID), err)
+		return nil, http.StatusInternalServerError, "Server failed to encode public key"
+	}
+	return body, http.StatusOK, ""
+}
+
+// Simple handler for private key requests in any format. Formats other than JSON cannot be
+// combined with wrapping or hybrid mode.
+func (s *Server) getPrivateKeyFormatted(query url.Values) (any, int, string) {
+	format, status, message := parseFormat(query)
+	if status != http.StatusOK {
+		return nil, status, message
+	}
+	for _, arg := range []string{argWrapTo, argHybrid} {
+		if format != formatJSON && query.Has(arg) {
+			return nil, http.StatusBadRequest, fmt.Sprintf("%q parameter cannot be combined with %q", arg, argFormat)
+		}
+	}
+	resp, status, message := s.getPrivateKey(query)
+	if status != http.StatusOK || format == formatJSON {
+		return resp, status, message
+	}
+
+	priv, err := keys.ParsePrivateKeyAsPKCS8DER(resp.PKCS8)
+	if err != nil {
+		log.Printf("ERROR: Failed to parse private key in request %s: %+v", query.Get(argRequestID), err)
+		return nil, http.StatusInternalServerError, "Server failed to encode private key"
+	}
+	body, err := encodeKey(format, resp.PKCS8,
+		func() (string, error) { return keys.FormatPrivateKeyAsPKCS8PEM(priv) },
+		func() (*keys.JWK, error) { return keys.FormatPrivateKeyAsJWK(priv) },
+		func() ([]byte, error) { return keys.FormatPrivateKeyAsCOSE(priv) })
+	if err != nil {
+		log.Printf("ERROR: Failed to encode private key as %s in request %s: %+v", format, query.Get(argRequestID), err)
+		return nil, http.StatusInternalServerError, "Server failed to encode private key"
+	}
+	return body, http.StatusOK, ""
+}
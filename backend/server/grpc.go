@@ -0,0 +1,244 @@
+package server
+
+import (
+	"context"
+	"encoding/base64"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/newgrp/timecapsule/timecapsulepb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// gRPC service backed by a Server. Requests are translated to the equivalent query parameters and
+// served by the same simple handlers as the HTTP API, behind the same rate limits and access log.
+type grpcService struct {
+	timecapsulepb.UnimplementedTimecapsuleServer
+
+	s *Server
+}
+
+// Registers the Timecapsule gRPC service.
+func (s *Server) RegisterGRPC(gs *grpc.Server) {
+	timecapsulepb.RegisterTimecapsuleServer(gs, &grpcService{s: s})
+}
+
+// Converts an HTTP status code and error message from a simple handler to a gRPC error.
+func grpcError(code int, message string) error {
+	switch code {
+	case http.StatusOK:
+		return nil
+	case http.StatusBadRequest:
+		return status.Error(codes.InvalidArgument, message)
+	case http.StatusNotFound, http.StatusGone:
+		return status.Error(codes.NotFound, message)
+	case http.StatusForbidden:
+		return status.Error(codes.PermissionDenied, message)
+	case http.StatusServiceUnavailable:
+		return status.Error(codes.Unavailable, message)
+	default:
+		return status.Error(codes.Internal, message)
+	}
+}
+
+// Returns the address of the client of a gRPC call, or "" if it is unknown.
+func grpcClientIP(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(p.Addr.String())
+	if err != nil {
+		return p.Addr.String()
+	}
+	return host
+}
+
+// Serves a gRPC call the way accessLog and rateLimit serve HTTP requests: the call is assigned a
+// request ID, echoed in the x-request-id response header, is rejected if any of the given rate
+// limiters refuses it, and is logged once it completes. The query gives the call's parameters for
+// the log, and the request ID is added to it for the handler's error logs.
+func serveGRPC[T any](s *Server, ctx context.Context, query url.Values, limiters []*rateLimiter, h func() (T, error)) (resp T, err error) {
+	start := time.Now()
+	md, _ := metadata.FromIncomingContext(ctx)
+	var id string
+	if ids := md.Get(requestIDHeader); len(ids) > 0 && validRequestID(ids[0]) {
+		id = ids[0]
+	} else {
+		id = newRequestID()
+	}
+	grpc.SetHeader(ctx, metadata.Pairs(requestIDHeader, id))
+	client := grpcClientIP(ctx)
+	params := loggableQuery(query)
+	query.Set(argRequestID, id)
+
+	defer func() {
+		code := status.Code(err)
+		serverError := code == codes.Internal || code == codes.Unknown
+		if !s.opts.AccessLog && !serverError {
+			return
+		}
+		level := "INFO"
+		if serverError {
+			level = "ERROR"
+		}
+		method, _ := grpc.Method(ctx)
+		log.Printf("%s: request_id=%s method=%s params=%q status=%s latency=%v client=%s",
+			level, id, method, params, code, time.Since(start).Round(time.Microsecond), client)
+	}()
+
+	for _, l := range limiters {
+		if !l.allow(client) {
+			return resp, status.Error(codes.ResourceExhausted, "Too many requests; try again later")
+		}
+	}
+	return h()
+}
+
+// Formats an optional timestamp as a time parameter, or returns "" if the timestamp is absent.
+func timeArg(t *timestamppb.Timestamp) string {
+	if t == nil {
+		return ""
+	}
+	return t.AsTime().Format(time.RFC3339Nano)
+}
+
+// Converts derivation parameters to their protocol buffer representation.
+func (d DerivationInfo) proto() *timecapsulepb.DerivationInfo {
+	return &timecapsulepb.DerivationInfo{
+		DerivationVersion: int32(d.DerivationVersion),
+		Algorithm:         d.Algorithm,
+		Interval:          d.Interval,
+	}
+}
+
+// Parses a time formatted by a simple handler as a timestamp.
+func parseTimestamp(s string) (*timestamppb.Timestamp, error) {
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return timestamppb.New(t), nil
+}
+
+// Converts a release attestation to its protocol buffer representation.
+func (a *ReleaseAttestation) proto() (*timecapsulepb.ReleaseAttestation, error) {
+	target, err := parseTimestamp(a.TargetTime)
+	if err != nil {
+		return nil, err
+	}
+	released, err := parseTimestamp(a.ReleaseTime)
+	if err != nil {
+		return nil, err
+	}
+	return &timecapsulepb.ReleaseAttestation{
+		TargetTime:   target,
+		ReleaseTime:  released,
+		Signature:    a.Signature,
+		SigningKeyId: a.SigningKeyID,
+	}, nil
+}
+
+// Adds the hybrid parameter to a query if a request asks for hybrid keys.
+func hybridQuery(query url.Values, hybrid bool) url.Values {
+	if hybrid {
+		query.Set(argHybrid, "true")
+	}
+	return query
+}
+
+func (g *grpcService) GetPublicKey(ctx context.Context, req *timecapsulepb.GetPublicKeyRequest) (*timecapsulepb.GetPublicKeyResponse, error) {
+	query := hybridQuery(targetQuery(req.GetPkiId(), timeArg(req.GetTime()), req.GetEvent()), req.GetHybrid())
+
+	return serveGRPC(g.s, ctx, query, []*rateLimiter{g.s.limiter}, func() (*timecapsulepb.GetPublicKeyResponse, error) {
+		resp, code, message := g.s.getPublicKey(query)
+		if err := grpcError(code, message); err != nil {
+			return nil, err
+		}
+		t, err := parseTimestamp(resp.Time)
+		if err != nil {
+			return nil, err
+		}
+		return &timecapsulepb.GetPublicKeyResponse{
+			PkiName:               resp.PKIName,
+			PkiId:                 resp.PKIID,
+			Spki:                  resp.SPKI,
+			Derivation:            resp.DerivationInfo.proto(),
+			Time:                  t,
+			Signature:             resp.Signature,
+			SigningKeyId:          resp.SigningKeyID,
+			Fingerprint:           resp.Fingerprint,
+			SigningKeyFingerprint: resp.SigningKeyFingerprint,
+			MlkemEncapsulationKey: resp.MLKEMEncapsulationKey,
+			MlkemSignature:        resp.MLKEMSignature,
+		}, nil
+	})
+}
+
+func (g *grpcService) GetPrivateKey(ctx context.Context, req *timecapsulepb.GetPrivateKeyRequest) (*timecapsulepb.GetPrivateKeyResponse, error) {
+	query := hybridQuery(targetQuery(req.GetPkiId(), timeArg(req.GetTime()), req.GetEvent()), req.GetHybrid())
+	if len(req.GetWrapTo()) != 0 {
+		query.Set(argWrapTo, base64.RawURLEncoding.EncodeToString(req.GetWrapTo()))
+	}
+
+	// The same limiters as privateKeyHandler, in the same order.
+	limiters := []*rateLimiter{g.s.limiter, g.s.privateLimiter}
+	return serveGRPC(g.s, ctx, query, limiters, func() (*timecapsulepb.GetPrivateKeyResponse, error) {
+		if err := g.s.checkGRPCClientCert(ctx); err != nil {
+			return nil, err
+		}
+		if err := g.s.checkGRPCAPIKey(ctx); err != nil {
+			return nil, err
+		}
+
+		resp, code, message := g.s.getPrivateKey(query)
+		if err := grpcError(code, message); err != nil {
+			return nil, err
+		}
+		attestation, err := resp.Attestation.proto()
+		if err != nil {
+			return nil, err
+		}
+		return &timecapsulepb.GetPrivateKeyResponse{
+			PkiName:               resp.PKIName,
+			PkiId:                 resp.PKIID,
+			Pkcs8:                 resp.PKCS8,
+			Enc:                   resp.Enc,
+			Sealed:                resp.Sealed,
+			Derivation:            resp.DerivationInfo.proto(),
+			Fingerprint:           resp.Fingerprint,
+			SigningKeyFingerprint: resp.SigningKeyFingerprint,
+			MlkemSeed:             resp.MLKEMSeed,
+			MlkemEnc:              resp.MLKEMEnc,
+			MlkemSealed:           resp.MLKEMSealed,
+			Attestation:           attestation,
+		}, nil
+	})
+}
+
+func (g *grpcService) GetInfo(ctx context.Context, req *timecapsulepb.GetInfoRequest) (*timecapsulepb.GetInfoResponse, error) {
+	query := targetQuery(req.GetPkiId(), "", "")
+
+	return serveGRPC(g.s, ctx, query, []*rateLimiter{g.s.limiter}, func() (*timecapsulepb.GetInfoResponse, error) {
+		km, code, message := g.s.selectPKI(query)
+		if err := grpcError(code, message); err != nil {
+			return nil, err
+		}
+		return &timecapsulepb.GetInfoResponse{
+			PkiName:    km.Name(),
+			PkiId:      km.PKIID().String(),
+			MinTime:    timestamppb.New(km.MinTime()),
+			MaxTime:    timestamppb.New(km.MaxTime()),
+			ApiVersion: apiVersion,
+			Derivation: derivationInfo(km).proto(),
+		}, nil
+	})
+}
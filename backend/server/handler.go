@@ -0,0 +1,22 @@
+package server
+
+import "net/http"
+
+// Wrapper of an HTTP handler, such as for authentication, logging, or tracing.
+type Middleware func(http.Handler) http.Handler
+
+// Returns an HTTP handler that serves all of the server's methods, as registered by
+// RegisterHandlers, wrapped in the given middleware.
+//
+// The first middleware is outermost, so it sees each request first and its response last. This
+// lets the server be embedded in an existing service, for example by mounting the handler with
+// http.StripPrefix, without sharing a mux.
+func (s *Server) Handler(middleware ...Middleware) http.Handler {
+	mux := http.NewServeMux()
+	s.RegisterHandlers(mux)
+	var h http.Handler = mux
+	for i := len(middleware) - 1; i >= 0; i-- {
+		h = middleware[i](h)
+	}
+	return h
+}
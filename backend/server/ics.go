@@ -0,0 +1,95 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/newgrp/timecapsule/keys"
+)
+
+// Default length of the window covered by an unlock calendar if the request doesn't specify its
+// end.
+const defaultCalendarWindow = 7 * 24 * time.Hour
+
+// Longest content line of an iCalendar object, in octets, excluding the line break (RFC 5545,
+// section 3.1).
+const maxICSLineLength = 75
+
+// Format of UTC date-times in iCalendar objects.
+const icsTimeFormat = "20060102T150405Z"
+
+// Escapes a TEXT value of an iCalendar object (RFC 5545, section 3.3.11).
+func icsEscape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\n", `\n`).Replace(s)
+}
+
+// Appends a content line to an iCalendar object, folding it so that no line is longer than
+// maxICSLineLength octets. Lines are never folded within a UTF-8 sequence.
+func appendICSLine(b *strings.Builder, line string) {
+	limit := maxICSLineLength
+	for len(line) > limit {
+		cut := limit
+		for cut > 0 && line[cut]&0xC0 == 0x80 {
+			cut--
+		}
+		b.WriteString(line[:cut])
+		b.WriteString("\r\n ")
+		line = line[cut:]
+		// Continuation lines begin with a space, which counts toward their length.
+		limit = maxICSLineLength - 1
+	}
+	b.WriteString(line)
+	b.WriteString("\r\n")
+}
+
+// Returns an iCalendar object with an event at the release of each of the given interval
+// boundaries of a PKI.
+func (s *Server) unlockCalendar(km *keys.KeyManager, boundaries []time.Time) []byte {
+	var b strings.Builder
+	appendICSLine(&b, "BEGIN:VCALENDAR")
+	appendICSLine(&b, "VERSION:2.0")
+	appendICSLine(&b, "PRODID:-//newgrp//timecapsule//EN")
+	appendICSLine(&b, "CALSCALE:GREGORIAN")
+	appendICSLine(&b, "X-WR-CALNAME:"+icsEscape(fmt.Sprintf("Unlocks of %s", km.Name())))
+	for _, t := range boundaries {
+		release := s.releaseTime(t).UTC().Format(icsTimeFormat)
+		appendICSLine(&b, "BEGIN:VEVENT")
+		appendICSLine(&b, fmt.Sprintf("UID:%s-%d@timecapsule", km.PKIID(), t.Unix()))
+		// The event is fully determined by the PKI and time, so it is stamped with its own time
+		// rather than the time of the request.
+		appendICSLine(&b, "DTSTAMP:"+release)
+		appendICSLine(&b, "DTSTART:"+release)
+		appendICSLine(&b, "SUMMARY:"+icsEscape(fmt.Sprintf("%s keys unlock", km.Name())))
+		appendICSLine(&b, "DESCRIPTION:"+icsEscape(fmt.Sprintf(
+			"Private keys of PKI %s for the interval starting %s become available.",
+			km.PKIID(), t.UTC().Format(time.RFC3339))))
+		appendICSLine(&b, "TRANSP:TRANSPARENT")
+		appendICSLine(&b, "END:VEVENT")
+	}
+	appendICSLine(&b, "END:VCALENDAR")
+	return []byte(b.String())
+}
+
+// Simple handler for unlock calendar requests.
+//
+// Returns an iCalendar object with an event at the release of each interval boundary from "from"
+// through "to". "from" defaults to the current time and "to" defaults to a week after "from".
+func (s *Server) getUnlockCalendar(query url.Values) (any, int, string) {
+	km, status, message := s.selectPKI(query)
+	if status != http.StatusOK {
+		return nil, status, message
+	}
+	start, to, status, message := s.parseBoundaryWindow(km, query, defaultCalendarWindow)
+	if status != http.StatusOK {
+		return nil, status, message
+	}
+
+	var boundaries []time.Time
+	for t := start; t.Compare(to) <= 0 && km.CheckTime(t) == nil; t = t.Add(km.DerivationParams().Interval) {
+		boundaries = append(boundaries, t)
+	}
+	return &rawBody{contentType: "text/calendar; charset=utf-8", data: s.unlockCalendar(km, boundaries)}, http.StatusOK, ""
+}
@@ -0,0 +1,56 @@
+package server
+
+import (
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/newgrp/timecapsule/keys"
+)
+
+// Version of the HTTP API served under /v0/.
+const apiVersion = "v0"
+
+type GetInfoResp struct {
+	PKIName    string `json:"pkiName"`
+	PKIID      string `json:"pkiID"`
+	MinTime    string `json:"minTime"`
+	MaxTime    string `json:"maxTime"`
+	APIVersion string `json:"apiVersion"`
+
+	DerivationInfo
+}
+
+// Simple handler for server info requests.
+func (s *Server) getInfo(query url.Values) (*GetInfoResp, int, string) {
+	km, status, message := s.selectPKI(query)
+	if status != http.StatusOK {
+		return nil, status, message
+	}
+	return pkiInfo(km), http.StatusOK, ""
+}
+
+// Describes a PKI.
+func pkiInfo(km *keys.KeyManager) *GetInfoResp {
+	return &GetInfoResp{
+		PKIName:        km.Name(),
+		PKIID:          km.PKIID().String(),
+		MinTime:        km.MinTime().UTC().Format(time.RFC3339),
+		MaxTime:        km.MaxTime().UTC().Format(time.RFC3339),
+		APIVersion:     apiVersion,
+		DerivationInfo: derivationInfo(km),
+	}
+}
+
+type ListPKIsResp struct {
+	PKIs []*GetInfoResp `json:"pkis"`
+}
+
+// Simple handler for requests to list the server's PKIs. The primary PKI is listed first.
+func (s *Server) listPKIs(url.Values) (*ListPKIsResp, int, string) {
+	resp := &ListPKIsResp{}
+	for _, km := range s.allPKIs() {
+		resp.PKIs = append(resp.PKIs, pkiInfo(km))
+	}
+	return resp, http.StatusOK, ""
+}
@@ -0,0 +1,114 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/newgrp/timecapsule/keys"
+)
+
+// Default length of the window covered by a JWK Set if the request doesn't specify its end.
+const defaultJWKSWindow = 24 * time.Hour
+
+// JSON Web Key Set (RFC 7517, section 5).
+type JWKSet struct {
+	Keys []*keys.JWK `json:"keys"`
+}
+
+// Returns the key ID of a PKI's key for the given time, which identifies both the PKI and the time.
+func keyID(km *keys.KeyManager, t time.Time) string {
+	return fmt.Sprintf("%s/%d", km.PKIID().String(), t.Unix())
+}
+
+// Parses the "from" and "to" parameters of a request for the interval boundaries in a window of
+// time. "from" defaults to the current time and "to" defaults to defaultLength after "from".
+// Returns (first interval boundary at or after "from", "to", HTTP status code, error message).
+func (s *Server) parseBoundaryWindow(km *keys.KeyManager, query url.Values, defaultLength time.Duration) (time.Time, time.Time, int, string) {
+	var from time.Time
+	var status int
+	var message string
+	if query.Has(argFrom) {
+		from, status, message = parseTimeArg(km, query, argFrom)
+		if status != http.StatusOK {
+			return time.Time{}, time.Time{}, status, message
+		}
+	} else if s.clock == nil {
+		return time.Time{}, time.Time{}, http.StatusBadRequest, fmt.Sprintf("%q parameter is required by public-only servers", argFrom)
+	} else {
+		var err error
+		from, err = s.clock.Now()
+		if err != nil {
+			log.Printf("ERROR: Failed to determine the current time securely in request %s: %+v", query.Get(argRequestID), err)
+			return time.Time{}, time.Time{}, http.StatusServiceUnavailable, noTimeError
+		}
+	}
+	to := from.Add(defaultLength)
+	if query.Has(argTo) {
+		to, status, message = parseTimeArg(km, query, argTo)
+		if status != http.StatusOK {
+			return time.Time{}, time.Time{}, status, message
+		}
+	}
+	if to.Before(from) {
+		return time.Time{}, time.Time{}, http.StatusBadRequest, fmt.Sprintf("%q must not be before %q", argTo, argFrom)
+	}
+
+	step := km.DerivationParams().Interval
+	start := from.Truncate(step)
+	if start.Before(from) {
+		start = start.Add(step)
+	}
+	if n := to.Sub(start)/step + 1; n > maxBatchSize {
+		return time.Time{}, time.Time{}, http.StatusBadRequest, fmt.Sprintf("At most %d keys may be requested at once", maxBatchSize)
+	}
+	return start, to, http.StatusOK, ""
+}
+
+// Simple handler for JWK Set requests.
+//
+// Returns the public keys for each interval boundary from "from" through "to". "from" defaults to
+// the current time and "to" defaults to one day after "from".
+func (s *Server) getJWKS(query url.Values) (*JWKSet, int, string) {
+	km, status, message := s.selectPKI(query)
+	if status != http.StatusOK {
+		return nil, status, message
+	}
+	start, to, status, message := s.parseBoundaryWindow(km, query, defaultJWKSWindow)
+	if status != http.StatusOK {
+		return nil, status, message
+	}
+	step := km.DerivationParams().Interval
+
+	set := &JWKSet{Keys: []*keys.JWK{}}
+	for t := start; t.Compare(to) <= 0; t = t.Add(step) {
+		if err := km.CheckTime(t); err != nil {
+			break
+		}
+		der, status, message := publicKeySPKI(km, t, query.Get(argRequestID))
+		if status != http.StatusOK {
+			return nil, status, message
+		}
+		pub, err := keys.ParsePublicKeyAsSPKIDER(der)
+		if err != nil {
+			log.Printf("ERROR: Failed to parse public key for time %s in request %s: %+v", t.Format(time.RFC3339), query.Get(argRequestID), err)
+			return nil, http.StatusInternalServerError, "Server failed to encode public key"
+		}
+		jwk, err := keys.FormatPublicKeyAsJWK(pub)
+		if err != nil {
+			log.Printf("ERROR: Failed to format public key for time %s as JWK in request %s: %+v", t.Format(time.RFC3339), query.Get(argRequestID), err)
+			return nil, http.StatusInternalServerError, "Server failed to encode public key"
+		}
+		jwk.Kid = keyID(km, t)
+		jwk.Use = "enc"
+		jwk.Alg = "ECDH-ES"
+		if km.DerivationParams().Algorithm == keys.AlgorithmEd25519 {
+			jwk.Use = "sig"
+			jwk.Alg = "EdDSA"
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+	return set, http.StatusOK, ""
+}
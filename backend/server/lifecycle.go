@@ -0,0 +1,251 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/newgrp/timecapsule/keys"
+)
+
+type FreezeResp struct {
+	PKIID  string `json:"pkiID"`
+	Frozen bool   `json:"frozen"`
+}
+
+type HaltResp struct {
+	// Whether private key release is halted through the admin API. A halt is recorded in the
+	// primary PKI's secrets directory, so it survives restarts until release is resumed.
+	Halted bool `json:"halted"`
+	// Whether the kill switch file exists, which halts private key release regardless.
+	KillSwitch bool `json:"killSwitch"`
+}
+
+type RevokeResp struct {
+	PKIID   string `json:"pkiID"`
+	Start   string `json:"start"`
+	End     string `json:"end"`
+	Reason  string `json:"reason,omitempty"`
+	Revoked string `json:"revoked"`
+}
+
+// Whether private key release is frozen for the given PKI.
+func (s *Server) isFrozen(id uuid.UUID) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.frozen[id]
+}
+
+// Whether the kill switch file exists. Errors other than the file not existing are treated as the
+// file existing, so that a kill switch that can't be checked fails closed.
+func (s *Server) killSwitchSet() bool {
+	if s.opts.KillSwitchFile == "" {
+		return false
+	}
+	_, err := os.Stat(s.opts.KillSwitchFile)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		log.Printf("ERROR: Failed to check kill switch file %s: %+v", s.opts.KillSwitchFile, err)
+	}
+	return !errors.Is(err, fs.ErrNotExist)
+}
+
+// Path of the file recording that private key release is halted through the admin API. It is kept
+// in a subdirectory of the primary PKI's secrets directory, which isn't scanned for secrets.
+func (s *Server) haltFile() string {
+	return filepath.Join(s.opts.SecretsDir, "admin", "halted")
+}
+
+// Whether the halt file exists. Errors other than the file not existing are returned, so that a
+// server whose halt can't be checked doesn't start.
+func (s *Server) loadHalted() (bool, error) {
+	_, err := os.Stat(s.haltFile())
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to check halt file: %w", err)
+	}
+	return true, nil
+}
+
+// Creates or removes the halt file.
+func (s *Server) saveHalted(halted bool) error {
+	path := s.haltFile()
+	if !halted {
+		if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("failed to remove halt file: %w", err)
+		}
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return fmt.Errorf("failed to create directory for halt file: %w", err)
+	}
+	if err := os.WriteFile(path, []byte(time.Now().UTC().Format(time.RFC3339)+"\n"), 0o600); err != nil {
+		return fmt.Errorf("failed to write halt file: %w", err)
+	}
+	return nil
+}
+
+// Whether private key release is halted for every PKI, either through the admin API or by the kill
+// switch file.
+func (s *Server) isHalted() bool {
+	s.mu.RLock()
+	halted := s.halted
+	s.mu.RUnlock()
+	return halted || s.killSwitchSet()
+}
+
+// Simple handler for PKI creation requests.
+//
+// The new PKI's secrets are stored in a subdirectory of the PKI root directory named after its PKI
+// ID, and are generated in the background.
+func (s *Server) createPKI(query url.Values) (*GetInfoResp, int, string) {
+	if s.opts.PKIRootDir == "" {
+		return nil, http.StatusNotFound, "Server is not configured to create PKIs"
+	}
+	if !query.Has(argName) {
+		return nil, http.StatusBadRequest, fmt.Sprintf("%q parameter is required", argName)
+	}
+	minTime, status, message := parseTimeArg(nil, query, argMinTime)
+	if status != http.StatusOK {
+		return nil, status, message
+	}
+	maxTime, status, message := parseTimeArg(nil, query, argMaxTime)
+	if status != http.StatusOK {
+		return nil, status, message
+	}
+	if !maxTime.After(minTime) {
+		return nil, http.StatusBadRequest, fmt.Sprintf("%q must be after %q", argMaxTime, argMinTime)
+	}
+
+	id := uuid.New()
+	dir := filepath.Join(s.opts.PKIRootDir, id.String())
+	km, err := keys.NewKeyManager(keys.PKIOptions{
+		Name:                 query.Get(argName),
+		ID:                   id,
+		MinTime:              minTime,
+		MaxTime:              maxTime,
+		Algorithm:            keys.Algorithm(query.Get(argAlgorithm)),
+		BackgroundGeneration: true,
+	}, dir)
+	if err != nil {
+		log.Printf("ERROR: Failed to create PKI in %s in request %s: %+v", dir, query.Get(argRequestID), err)
+		return nil, http.StatusInternalServerError, "Server failed to create PKI"
+	}
+	if err := s.addPKI(km); err != nil {
+		log.Printf("ERROR: Failed to add PKI %s in request %s: %+v", id, query.Get(argRequestID), err)
+		return nil, http.StatusInternalServerError, "Server failed to create PKI"
+	}
+	log.Printf("Created PKI %s (%q) in %s", id, km.Name(), dir)
+	return pkiInfo(km), http.StatusOK, ""
+}
+
+// Simple handler for requests to extend a PKI's time range.
+func (s *Server) extendPKI(query url.Values) (*GetInfoResp, int, string) {
+	km, status, message := s.selectPKI(query)
+	if status != http.StatusOK {
+		return nil, status, message
+	}
+	maxTime, status, message := parseTimeArg(nil, query, argMaxTime)
+	if status != http.StatusOK {
+		return nil, status, message
+	}
+
+	if err := km.ExtendRange(maxTime); err != nil {
+		if errors.Is(err, keys.ErrInvalidRange) {
+			return nil, http.StatusBadRequest, fmt.Sprintf("Failed to extend PKI: %v", err)
+		}
+		log.Printf("ERROR: Failed to extend PKI %s to %s in request %s: %+v", km.PKIID(), maxTime.Format(time.RFC3339), query.Get(argRequestID), err)
+		return nil, http.StatusInternalServerError, "Server failed to extend PKI"
+	}
+	log.Printf("Extended PKI %s to %s", km.PKIID(), maxTime.Format(time.RFC3339))
+	return pkiInfo(km), http.StatusOK, ""
+}
+
+// Returns a simple handler that freezes or unfreezes private key release for a PKI.
+func (s *Server) setFrozen(frozen bool) func(url.Values) (*FreezeResp, int, string) {
+	return func(query url.Values) (*FreezeResp, int, string) {
+		km, status, message := s.selectPKI(query)
+		if status != http.StatusOK {
+			return nil, status, message
+		}
+
+		s.mu.Lock()
+		s.frozen[km.PKIID()] = frozen
+		s.mu.Unlock()
+
+		log.Printf("Set private key release frozen=%t for PKI %s", frozen, km.PKIID())
+		return &FreezeResp{
+			PKIID:  km.PKIID().String(),
+			Frozen: frozen,
+		}, http.StatusOK, ""
+	}
+}
+
+// Returns a simple handler that halts or resumes private key release for every PKI, for incident
+// response when the clock or host may be compromised. The halt is persisted before it is reported,
+// so that a restart doesn't resume release. Resuming has no effect while the kill switch file
+// exists.
+func (s *Server) setHalted(halted bool) func(url.Values) (*HaltResp, int, string) {
+	return func(query url.Values) (*HaltResp, int, string) {
+		s.mu.Lock()
+		err := s.saveHalted(halted)
+		if err == nil {
+			s.halted = halted
+		}
+		s.mu.Unlock()
+		if err != nil {
+			log.Printf("ERROR: Failed to set private key release halted=%t in request %s: %+v", halted, query.Get(argRequestID), err)
+			return nil, http.StatusInternalServerError, "Server failed to record halt"
+		}
+
+		log.Printf("Set private key release halted=%t for all PKIs", halted)
+		return &HaltResp{
+			Halted:     halted,
+			KillSwitch: s.killSwitchSet(),
+		}, http.StatusOK, ""
+	}
+}
+
+// Simple handler for requests to revoke a PKI's keys for a time range.
+//
+// The server stops serving the revoked public keys at once, but HTTP caches may keep serving
+// responses they already stored for up to publicKeyMaxAge.
+func (s *Server) revoke(query url.Values) (*RevokeResp, int, string) {
+	km, status, message := s.selectPKI(query)
+	if status != http.StatusOK {
+		return nil, status, message
+	}
+	start, status, message := parseTimeArg(nil, query, argStart)
+	if status != http.StatusOK {
+		return nil, status, message
+	}
+	end, status, message := parseTimeArg(nil, query, argEnd)
+	if status != http.StatusOK {
+		return nil, status, message
+	}
+
+	r, err := km.Revoke(start, end, query.Get(argReason))
+	if err != nil {
+		if errors.Is(err, keys.ErrInvalidRevocation) {
+			return nil, http.StatusBadRequest, fmt.Sprintf("Failed to revoke keys: %v", err)
+		}
+		log.Printf("ERROR: Failed to revoke keys of PKI %s from %s to %s in request %s: %+v", km.PKIID(), start.Format(time.RFC3339), end.Format(time.RFC3339), query.Get(argRequestID), err)
+		return nil, http.StatusInternalServerError, "Server failed to revoke keys"
+	}
+	log.Printf("Revoked keys of PKI %s from %s to %s: %q", km.PKIID(), r.Start.Format(time.RFC3339), r.End.Format(time.RFC3339), r.Reason)
+	return &RevokeResp{
+		PKIID:   km.PKIID().String(),
+		Start:   r.Start.Format(time.RFC3339),
+		End:     r.End.Format(time.RFC3339),
+		Reason:  r.Reason,
+		Revoked: r.Revoked.Format(time.RFC3339),
+	}, http.StatusOK, ""
+}
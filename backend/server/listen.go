@@ -0,0 +1,121 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"time"
+)
+
+// How long to wait for in-flight requests to finish when shutting down.
+const shutdownTimeout = 30 * time.Second
+
+// Configuration of an address that the server listens on.
+type ListenerConfig struct {
+	// Network of the address: "tcp" or "unix". If empty, "tcp" is used.
+	Network string
+	// Address to listen on, such as ":443" for TCP or a socket path for Unix.
+	Address string
+	// TLS configuration of the listener. If nil, the listener serves plain HTTP.
+	TLSConfig *tls.Config
+}
+
+func (c *ListenerConfig) network() string {
+	if c.Network == "" {
+		return "tcp"
+	}
+	return c.Network
+}
+
+func (c *ListenerConfig) String() string {
+	scheme := "http"
+	if c.TLSConfig != nil {
+		scheme = "https"
+	}
+	return fmt.Sprintf("%s at %s %s", scheme, c.network(), c.Address)
+}
+
+// Opens a listener.
+func listen(c ListenerConfig) (net.Listener, error) {
+	if c.network() == "unix" {
+		// A socket left behind by a previous run would prevent listening, so remove it. Refuse to
+		// remove anything other than a socket.
+		if info, err := os.Lstat(c.Address); err == nil && info.Mode().Type() == fs.ModeSocket {
+			if err := os.Remove(c.Address); err != nil {
+				return nil, fmt.Errorf("failed to remove stale socket %s: %w", c.Address, err)
+			}
+		}
+	}
+	lis, err := net.Listen(c.network(), c.Address)
+	if err != nil {
+		return nil, err
+	}
+	if c.TLSConfig != nil {
+		config := c.TLSConfig.Clone()
+		if len(config.NextProtos) == 0 {
+			config.NextProtos = []string{"h2", "http/1.1"}
+		}
+		lis = tls.NewListener(lis, config)
+	}
+	return lis, nil
+}
+
+// Serves the handler on every configured listener until the context is canceled or a listener
+// fails, then shuts down gracefully.
+//
+// Shutdown cancels the contexts of in-flight requests, so that long-lived requests such as unlock
+// streams and waits for private keys end promptly, and waits up to shutdownTimeout for the rest to
+// finish. Returns nil if shutdown was requested through the context.
+func (s *Server) ListenAndServe(ctx context.Context, handler http.Handler) error {
+	if len(s.opts.Listeners) == 0 {
+		return errors.New("no listeners configured")
+	}
+
+	var listeners []net.Listener
+	for _, c := range s.opts.Listeners {
+		lis, err := listen(c)
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			return fmt.Errorf("failed to listen for %s: %w", c.String(), err)
+		}
+		listeners = append(listeners, lis)
+	}
+
+	baseCtx, cancelRequests := context.WithCancel(context.Background())
+	httpServer := &http.Server{
+		Handler:     handler,
+		BaseContext: func(net.Listener) context.Context { return baseCtx },
+	}
+	httpServer.RegisterOnShutdown(cancelRequests)
+
+	errs := make(chan error, len(listeners))
+	for i, lis := range listeners {
+		log.Printf("Serving %s", s.opts.Listeners[i].String())
+		go func() {
+			errs <- httpServer.Serve(lis)
+		}()
+	}
+
+	var serveErr error
+	select {
+	case <-ctx.Done():
+		log.Printf("Shutting down; waiting up to %v for in-flight requests", shutdownTimeout)
+	case serveErr = <-errs:
+		log.Printf("ERROR: Listener failed; shutting down: %+v", serveErr)
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := httpServer.Shutdown(shutdownCtx); err != nil && serveErr == nil {
+		return fmt.Errorf("failed to shut down gracefully: %w", err)
+	}
+	return serveErr
+}
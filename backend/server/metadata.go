@@ -0,0 +1,56 @@
+package server
+
+import (
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/newgrp/timecapsule/keys"
+)
+
+// Creation record of one secret. Fields other than Bucket are empty for secrets that predate
+// metadata.
+type SecretMetadata struct {
+	Bucket           string `json:"bucket"`
+	Created          string `json:"created,omitempty"`
+	GeneratorVersion string `json:"generatorVersion,omitempty"`
+	Algorithm        string `json:"algorithm,omitempty"`
+	Checksum         string `json:"checksum,omitempty"`
+}
+
+type ListSecretMetadataResp struct {
+	PKIID   string           `json:"pkiID"`
+	Secrets []SecretMetadata `json:"secrets"`
+}
+
+// Simple handler for listing the creation records of a PKI's secrets.
+func (s *Server) listSecretMetadata(query url.Values) (*ListSecretMetadataResp, int, string) {
+	km, status, message := s.selectPKI(query)
+	if status != http.StatusOK {
+		return nil, status, message
+	}
+	if km.SecretScheme() == keys.SecretSchemeHierarchical {
+		return nil, http.StatusBadRequest, "PKI derives its secrets from a master secret and has no per-interval secrets"
+	}
+
+	mds, err := km.ListSecretMetadata()
+	if err != nil {
+		log.Printf("ERROR: Failed to list secret metadata of PKI %s in request %s: %+v", km.PKIID(), query.Get(argRequestID), err)
+		return nil, http.StatusInternalServerError, "Server failed to list secret metadata"
+	}
+	resp := &ListSecretMetadataResp{PKIID: km.PKIID().String(), Secrets: make([]SecretMetadata, 0, len(mds))}
+	for _, md := range mds {
+		entry := SecretMetadata{
+			Bucket:           md.Bucket.Format(time.RFC3339),
+			GeneratorVersion: md.GeneratorVersion,
+			Algorithm:        string(md.Algorithm),
+			Checksum:         md.Checksum,
+		}
+		if !md.Created.IsZero() {
+			entry.Created = md.Created.Format(time.RFC3339)
+		}
+		resp.Secrets = append(resp.Secrets, entry)
+	}
+	return resp, http.StatusOK, ""
+}
@@ -0,0 +1,122 @@
+package server
+
+import (
+	"crypto/mlkem"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/newgrp/timecapsule/keys"
+)
+
+type GetPublicMLKEMKeyResp struct {
+	PKIName string `json:"pkiName"`
+	PKIID   string `json:"pkiID"`
+	// Target time of the key, in RFC 3339 format.
+	Time string `json:"time"`
+	// ML-KEM-768 encapsulation key, in the encoding of FIPS 203.
+	EncapsulationKey []byte `json:"encapsulationKey"`
+
+	// Ed25519 signature over (PKI ID, time, encapsulation key) by the PKI signing key identified by
+	// SigningKeyID. See keys.PublicKeySignatureMessage, with the encapsulation key in place of the
+	// SPKI.
+	Signature    []byte `json:"signature"`
+	SigningKeyID string `json:"signingKeyID"`
+}
+
+type GetPrivateMLKEMKeyResp struct {
+	PKIName string `json:"pkiName"`
+	PKIID   string `json:"pkiID"`
+	// 64-byte seed from which the ML-KEM-768 decapsulation key is expanded (FIPS 203, section 7.1).
+	Seed []byte `json:"seed"`
+
+	Attestation *ReleaseAttestation `json:"attestation"`
+}
+
+// Determines whether a key request asks for hybrid mode, in which responses carry the ML-KEM-768
+// key for the requested time alongside the classical key. Returns (hybrid, HTTP status code, error
+// message).
+func parseHybrid(query url.Values) (bool, int, string) {
+	if !query.Has(argHybrid) {
+		return false, http.StatusOK, ""
+	}
+	hybrid, err := strconv.ParseBool(query.Get(argHybrid))
+	if err != nil {
+		return false, http.StatusBadRequest, fmt.Sprintf("Invalid %q parameter: %v", argHybrid, err)
+	}
+	return hybrid, http.StatusOK, ""
+}
+
+// Retrieves a PKI's ML-KEM-768 key pair for the given time. Returns (key, HTTP status code, error
+// message).
+func mlkemKey(km *keys.KeyManager, t time.Time, reqID string) (*mlkem.DecapsulationKey768, int, string) {
+	key, err := km.GetMLKEMKeyForTime(t)
+	if errors.Is(err, keys.ErrNotReady) {
+		return nil, http.StatusServiceUnavailable, notReadyError
+	}
+	if errors.Is(err, keys.ErrSecretDeleted) {
+		return nil, http.StatusGone, deletedError
+	}
+	if errors.Is(err, keys.ErrRevoked) {
+		return nil, http.StatusGone, revokedError
+	}
+	if err != nil {
+		log.Printf("ERROR: Failed to retrieve ML-KEM key for time %s in request %s: %+v", t.Format(time.RFC3339), reqID, err)
+		return nil, http.StatusInternalServerError, "Server failed to retrieve key"
+	}
+	return key, http.StatusOK, ""
+}
+
+// Simple handler for ML-KEM public key requests.
+func (s *Server) getPublicMLKEMKey(query url.Values) (*GetPublicMLKEMKeyResp, int, string) {
+	km, t, status, message := s.parseTarget(query)
+	if status != http.StatusOK {
+		return nil, status, message
+	}
+	key, status, message := mlkemKey(km, t, query.Get(argRequestID))
+	if status != http.StatusOK {
+		return nil, status, message
+	}
+
+	ek := key.EncapsulationKey().Bytes()
+	return &GetPublicMLKEMKeyResp{
+		PKIName:          km.Name(),
+		PKIID:            km.PKIID().String(),
+		Time:             t.UTC().Format(time.RFC3339),
+		EncapsulationKey: ek,
+		Signature:        km.SignPublicKey(t, ek),
+		SigningKeyID:     km.SigningKeyID(),
+	}, http.StatusOK, ""
+}
+
+// Simple handler for ML-KEM private key requests.
+func (s *Server) getPrivateMLKEMKey(query url.Values) (*GetPrivateMLKEMKeyResp, int, string) {
+	km, t, status, message := s.parseTarget(query)
+	if status != http.StatusOK {
+		return nil, status, message
+	}
+	released, status, message := s.checkDisclosable(km, t, query.Get(argRequestID))
+	if status != http.StatusOK {
+		return nil, status, message
+	}
+	key, status, message := mlkemKey(km, t, query.Get(argRequestID))
+	if status != http.StatusOK {
+		return nil, status, message
+	}
+
+	return &GetPrivateMLKEMKeyResp{
+		PKIName: km.Name(),
+		PKIID:   km.PKIID().String(),
+		Seed:    key.Bytes(),
+		Attestation: &ReleaseAttestation{
+			TargetTime:   t.UTC().Format(time.RFC3339),
+			ReleaseTime:  released.UTC().Format(time.RFC3339),
+			Signature:    km.AttestRelease(t, released),
+			SigningKeyID: km.SigningKeyID(),
+		},
+	}, http.StatusOK, ""
+}
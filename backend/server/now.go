@@ -0,0 +1,107 @@
+package server
+
+import (
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+type GetNowResp struct {
+	// Current time according to the secure clock, in RFC 3339 format with sub-second precision.
+	Time string `json:"time"`
+	// Current time according to the secure clock, in integer seconds since the Unix epoch.
+	Unix int64 `json:"unix"`
+	// Seconds since the secure clock last synchronized with its time servers.
+	Staleness float64 `json:"staleness"`
+	// Bounds of the interval that the secure clock guarantees contains the current time, in RFC
+	// 3339 format with sub-second precision. Private keys are released according to Earliest.
+	Earliest string `json:"earliest"`
+	Latest   string `json:"latest"`
+	// Seconds by which the server's system clock is ahead of the secure clock, or behind it if
+	// negative. A large divergence may indicate that the host clock has been tampered with.
+	Divergence float64 `json:"divergence"`
+	// Signed responses of the Roughtime servers that agreed on the time at the last
+	// synchronization, if any Roughtime servers are configured.
+	RoughtimeProofs []RoughtimeProof `json:"roughtimeProofs,omitempty"`
+}
+
+// Signed Roughtime response, which lets anyone holding the server's public key check the time that
+// the server reported. See https://roughtime.googlesource.com/roughtime/+/HEAD/PROTOCOL.md.
+type RoughtimeProof struct {
+	Server    string `json:"server"`
+	PublicKey []byte `json:"publicKey"`
+	Nonce     []byte `json:"nonce"`
+	Response  []byte `json:"response"`
+}
+
+// Simple handler for current time requests.
+func (s *Server) getNow(query url.Values) (*GetNowResp, int, string) {
+	now, err := s.clock.Now()
+	if err != nil {
+		log.Printf("ERROR: Failed to determine the current time securely in request %s: %+v", query.Get(argRequestID), err)
+		return nil, http.StatusServiceUnavailable, noTimeError
+	}
+	interval, err := s.clock.Interval()
+	if err != nil {
+		log.Printf("ERROR: Failed to determine the current time securely in request %s: %+v", query.Get(argRequestID), err)
+		return nil, http.StatusServiceUnavailable, noTimeError
+	}
+	resp := &GetNowResp{
+		Time:       now.UTC().Format(time.RFC3339Nano),
+		Unix:       now.Unix(),
+		Staleness:  s.clock.Staleness().Seconds(),
+		Earliest:   interval.Earliest.UTC().Format(time.RFC3339Nano),
+		Latest:     interval.Latest.UTC().Format(time.RFC3339Nano),
+		Divergence: s.clock.Divergence().Seconds(),
+	}
+	for _, p := range s.clock.RoughtimeProofs() {
+		resp.RoughtimeProofs = append(resp.RoughtimeProofs, RoughtimeProof{
+			Server:    p.Server.Addr,
+			PublicKey: p.Server.PublicKey,
+			Nonce:     p.Nonce,
+			Response:  p.Response,
+		})
+	}
+	return resp, http.StatusOK, ""
+}
+
+type GetClockHealthResp struct {
+	// Whether the secure clock currently knows the time. Private keys are withheld while it doesn't.
+	Synced bool `json:"synced"`
+	// Time server whose reading was last accepted, such as "NTS server at time.example.com". Empty
+	// before the first successful poll.
+	Server string `json:"server,omitempty"`
+	// Server's system time at the last successful poll, in RFC 3339 format with sub-second
+	// precision. Empty before the first successful poll.
+	LastPoll string `json:"lastPoll,omitempty"`
+	// Seconds by which the time servers were ahead of the server's system clock at the last
+	// successful poll, or behind it if negative.
+	Offset float64 `json:"offset"`
+	// Number of polls of the time servers that have failed since the last successful one.
+	ConsecutiveFailures int `json:"consecutiveFailures"`
+	// Seconds since the last successful poll, or null before the first successful poll.
+	Staleness *float64 `json:"staleness"`
+	// Staleness in seconds at which the clock stops releasing private keys.
+	StaleThreshold float64 `json:"staleThreshold"`
+}
+
+// Handler for secure clock health requests, which operators can monitor to be alerted before the
+// clock goes stale.
+func (s *Server) getClockHealth(url.Values) (*GetClockHealthResp, int, string) {
+	_, err := s.clock.Now()
+	h := s.clock.Health()
+	resp := &GetClockHealthResp{
+		Synced:              err == nil,
+		Server:              h.Server,
+		Offset:              h.Offset.Seconds(),
+		ConsecutiveFailures: h.ConsecutiveFailures,
+		StaleThreshold:      h.StaleThreshold.Seconds(),
+	}
+	if h.Synced {
+		staleness := h.Staleness.Seconds()
+		resp.Staleness = &staleness
+		resp.LastPoll = h.LastPoll.UTC().Format(time.RFC3339Nano)
+	}
+	return resp, http.StatusOK, ""
+}
@@ -0,0 +1,280 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"reflect"
+	"slices"
+	"strings"
+)
+
+// A JSON object in the OpenAPI document.
+type jsonObject = map[string]any
+
+// Descriptions of request parameters in the OpenAPI document, keyed by parameter name.
+var paramDocs = map[string]string{
+	argPKIID:  "UUID of the PKI. Defaults to the primary PKI.",
+	argTime:   "Target time, as integer seconds or milliseconds since the Unix epoch, an RFC 3339 time, an RFC 1123 date, or a YYYY-MM-DD date.",
+	argEvent:  "Name of a registered event whose time is the target time.",
+	argIn:     "Target time relative to the current time, as a Go duration such as 72h.",
+	argWrapTo: "Base64url-encoded DER SubjectPublicKeyInfo to which private keys are HPKE-sealed.",
+	argStart:  "Time of the first key.",
+	argEnd:    "Time of the last key.",
+	argStep:   "Go duration between keys. Defaults to the secret interval.",
+	argFormat: "Key encoding: json, pem, der, jwk, or cose. Defaults to json or to the encoding requested by the Accept header.",
+	argFrom:   "Start of the window of times.",
+	argTo:     "End of the window of times.",
+	argWait:   "If true, hold the request open until the key is released.",
+	argHybrid: "If true, also return the ML-KEM-768 key for the same time.",
+
+	argTreeSize: "Size of the transparency log tree. Defaults to the current size of the log.",
+	argFirst:    "Size of the earlier transparency log tree.",
+	argSecond:   "Size of the later transparency log tree. Defaults to the current size of the log.",
+}
+
+// Parameters identifying the target time of a key request.
+var targetParams = []string{argPKIID, argTime, argEvent, argIn}
+
+// Description of an API method for the OpenAPI document.
+type endpoint struct {
+	method  string
+	path    string
+	summary string
+	// Query parameters.
+	params []string
+	// Type of the JSON request body, or nil if there is none.
+	body reflect.Type
+	// Type of the JSON response body, or nil if the response is not JSON.
+	resp reflect.Type
+	// Content type of the response if it is not JSON.
+	contentType string
+	// Whether the method discloses private keys.
+	private bool
+	// Whether errors are reported as V1ErrorResp objects rather than plain text.
+	v1 bool
+	// Whether the method depends on the current time, and so isn't served by public-only servers.
+	needsClock bool
+	// Whether the method is only served by servers that keep transparency logs.
+	needsLog bool
+}
+
+// Methods of the public API, as registered by RegisterHandlers.
+var endpoints = []endpoint{
+	{method: http.MethodGet, path: "/v0/" + methodInfo, summary: "Describes a PKI.",
+		params: []string{argPKIID}, resp: reflect.TypeFor[GetInfoResp]()},
+	{method: http.MethodGet, path: "/v0/" + methodListPKIs, summary: "Lists the server's PKIs, primary first.",
+		resp: reflect.TypeFor[ListPKIsResp]()},
+	{method: http.MethodGet, path: "/v0/" + methodSigningKey, summary: "Returns a PKI's signing key.",
+		params: []string{argPKIID}, resp: reflect.TypeFor[GetSigningKeyResp]()},
+	{method: http.MethodGet, path: "/v0/" + methodNow, summary: "Returns the current time according to the secure clock.",
+		resp: reflect.TypeFor[GetNowResp](), needsClock: true},
+	{method: http.MethodGet, path: "/v0/" + methodClockHealth, summary: "Reports the health of the secure clock's synchronization with its time servers.",
+		resp: reflect.TypeFor[GetClockHealthResp](), needsClock: true},
+	{method: http.MethodGet, path: "/v0/" + methodAvailability, summary: "Reports when a private key will be released.",
+		params: targetParams, resp: reflect.TypeFor[GetAvailabilityResp](), needsClock: true},
+	{method: http.MethodGet, path: "/v0/" + methodGetPublicKey, summary: "Returns the public key for a time.",
+		params: slices.Concat(targetParams, []string{argFormat, argHybrid}), resp: reflect.TypeFor[GetPublicKeyResp]()},
+	{method: http.MethodGet, path: "/v0/" + methodGetPrivateKey, summary: "Returns the private key for a past time.",
+		params: slices.Concat(targetParams, []string{argWrapTo, argFormat, argWait, argHybrid}), resp: reflect.TypeFor[GetPrivateKeyResp](), private: true},
+	{method: http.MethodGet, path: "/v0/" + methodGetPublicKeys, summary: "Returns the public keys for several times.",
+		params: []string{argPKIID, argTime}, resp: reflect.TypeFor[GetPublicKeysResp]()},
+	{method: http.MethodPost, path: "/v0/" + methodGetPublicKeys, summary: "Returns the public keys for several times.",
+		body: reflect.TypeFor[GetPublicKeysReq](), resp: reflect.TypeFor[GetPublicKeysResp]()},
+	{method: http.MethodGet, path: "/v0/" + methodGetPrivateKeys, summary: "Returns the private keys for a range of past times.",
+		params: []string{argPKIID, argStart, argEnd, argStep, argWrapTo}, resp: reflect.TypeFor[GetPrivateKeysResp](), private: true},
+	{method: http.MethodGet, path: "/v0/" + methodGetBundle, summary: "Returns a signed bundle of the public keys for a range of times.",
+		params: []string{argPKIID, argFrom, argTo, argStep, argFormat}, resp: reflect.TypeFor[PublicKeyBundle]()},
+	{method: http.MethodGet, path: "/v0/" + methodGetPublicKEM, summary: "Returns the ML-KEM-768 encapsulation key for a time.",
+		params: targetParams, resp: reflect.TypeFor[GetPublicMLKEMKeyResp]()},
+	{method: http.MethodGet, path: "/v0/" + methodGetPrivateKEM, summary: "Returns the ML-KEM-768 decapsulation key for a past time.",
+		params: slices.Concat(targetParams, []string{argWait}), resp: reflect.TypeFor[GetPrivateMLKEMKeyResp](), private: true},
+	{method: http.MethodGet, path: "/v0/" + methodGetPublicSig, summary: "Returns the Ed25519 signing key for a time.",
+		params: targetParams, resp: reflect.TypeFor[GetPublicSigningKeyResp]()},
+	{method: http.MethodGet, path: "/v0/" + methodGetPrivateSig, summary: "Returns the Ed25519 signing key for a past time, with which signatures dated no earlier than that time can be made.",
+		params: slices.Concat(targetParams, []string{argWait}), resp: reflect.TypeFor[GetPrivateSigningKeyResp](), private: true},
+	{method: http.MethodGet, path: "/v0/" + methodJWKS, summary: "Returns the public keys for a window of time as a JWK Set. The window defaults to the next day.",
+		params: []string{argPKIID, argFrom, argTo}, resp: reflect.TypeFor[JWKSet]()},
+	{method: http.MethodGet, path: "/v0/" + methodUnlockStream, summary: "Streams an unlock event each time private keys are released.",
+		params: []string{argPKIID}, contentType: "text/event-stream", needsClock: true},
+	{method: http.MethodGet, path: "/v0/" + methodUnlockFeed, summary: "Returns an Atom feed of recently unlocked intervals.",
+		params: []string{argPKIID}, contentType: "application/atom+xml", needsClock: true},
+	{method: http.MethodGet, path: "/v0/" + methodUnlockCalendar, summary: "Returns an iCalendar feed of upcoming unlocks. The window defaults to the next week.",
+		params: []string{argPKIID, argFrom, argTo}, contentType: "text/calendar"},
+	{method: http.MethodGet, path: "/v0/" + methodLogTreeHead, summary: "Returns the signed head of a PKI's transparency log of public keys.",
+		params: []string{argPKIID}, resp: reflect.TypeFor[GetLogTreeHeadResp](), needsLog: true},
+	{method: http.MethodGet, path: "/v0/" + methodLogInclusion, summary: "Proves that the public key for a time is in a PKI's transparency log.",
+		params: slices.Concat(targetParams, []string{argTreeSize}), resp: reflect.TypeFor[GetLogInclusionProofResp](), needsLog: true},
+	{method: http.MethodGet, path: "/v0/" + methodLogConsistency, summary: "Proves that a PKI's transparency log only grew between two tree sizes.",
+		params: []string{argPKIID, argFirst, argSecond}, resp: reflect.TypeFor[GetLogConsistencyProofResp](), needsLog: true},
+	{method: http.MethodPost, path: "/v1/" + methodGetPublicKey, summary: "Returns the public key for a time.",
+		body: reflect.TypeFor[V1GetPublicKeyReq](), resp: reflect.TypeFor[GetPublicKeyResp](), v1: true},
+	{method: http.MethodPost, path: "/v1/" + methodGetPrivateKey, summary: "Returns the private key for a past time.",
+		body: reflect.TypeFor[V1GetPrivateKeyReq](), resp: reflect.TypeFor[GetPrivateKeyResp](), private: true, v1: true},
+	{method: http.MethodPost, path: "/v1/" + methodGetPublicKeys, summary: "Returns the public keys for several times.",
+		body: reflect.TypeFor[GetPublicKeysReq](), resp: reflect.TypeFor[GetPublicKeysResp](), v1: true},
+	{method: http.MethodPost, path: "/v1/" + methodGetPrivateKeys, summary: "Returns the private keys for a range of past times.",
+		body: reflect.TypeFor[V1GetPrivateKeysReq](), resp: reflect.TypeFor[GetPrivateKeysResp](), private: true, v1: true},
+}
+
+// Builds OpenAPI schemas from Go types, following the encoding/json conventions. Named struct
+// types are collected as components and referred to by name.
+type schemaBuilder struct {
+	components jsonObject
+}
+
+// Returns the schema of a type.
+func (b *schemaBuilder) schema(t reflect.Type) jsonObject {
+	if t.Kind() == reflect.Pointer {
+		return b.schema(t.Elem())
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return jsonObject{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return jsonObject{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return jsonObject{"type": "number"}
+	case reflect.String:
+		return jsonObject{"type": "string"}
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return jsonObject{"type": "string", "format": "byte"}
+		}
+		return jsonObject{"type": "array", "items": b.schema(t.Elem())}
+	case reflect.Map:
+		return jsonObject{"type": "object", "additionalProperties": b.schema(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return b.structSchema(t)
+		}
+		if _, ok := b.components[t.Name()]; !ok {
+			// Reserve the name first, in case the type refers to itself.
+			b.components[t.Name()] = jsonObject{}
+			b.components[t.Name()] = b.structSchema(t)
+		}
+		return jsonObject{"$ref": "#/components/schemas/" + t.Name()}
+	default:
+		return jsonObject{}
+	}
+}
+
+// Returns the schema of a struct type, with embedded structs flattened as in encoding/json.
+func (b *schemaBuilder) structSchema(t reflect.Type) jsonObject {
+	properties := jsonObject{}
+	required := []string{}
+	var addFields func(t reflect.Type)
+	addFields = func(t reflect.Type) {
+		for _, f := range reflect.VisibleFields(t) {
+			if !f.IsExported() || len(f.Index) > 1 {
+				continue
+			}
+			tag := f.Tag.Get("json")
+			if tag == "-" {
+				continue
+			}
+			name, opts, _ := strings.Cut(tag, ",")
+			if f.Anonymous && name == "" && f.Type.Kind() == reflect.Struct {
+				addFields(f.Type)
+				continue
+			}
+			if name == "" {
+				name = f.Name
+			}
+			properties[name] = b.schema(f.Type)
+			if !strings.Contains(opts, "omitempty") {
+				required = append(required, name)
+			}
+		}
+	}
+	addFields(t)
+	return jsonObject{"type": "object", "properties": properties, "required": required}
+}
+
+// Returns the OpenAPI 3 document describing the public API.
+func (s *Server) openAPIDocument() jsonObject {
+	b := &schemaBuilder{components: jsonObject{}}
+	errorResp := jsonObject{
+		"description": "Error",
+		"content":     jsonObject{"text/plain": jsonObject{"schema": jsonObject{"type": "string"}}},
+	}
+	v1ErrorResp := jsonObject{
+		"description": "Error",
+		"content":     jsonObject{"application/json": jsonObject{"schema": b.schema(reflect.TypeFor[V1ErrorResp]())}},
+	}
+
+	paths := jsonObject{}
+	for _, e := range endpoints {
+		if (e.needsClock && s.clock == nil) || (e.needsLog && !s.opts.TransparencyLog) {
+			continue
+		}
+		op := jsonObject{"summary": e.summary}
+
+		var params []jsonObject
+		for _, p := range e.params {
+			params = append(params, jsonObject{
+				"name":        p,
+				"in":          "query",
+				"description": paramDocs[p],
+				"schema":      jsonObject{"type": "string"},
+			})
+		}
+		if len(params) != 0 {
+			op["parameters"] = params
+		}
+		if e.body != nil {
+			op["requestBody"] = jsonObject{
+				"required": true,
+				"content":  jsonObject{"application/json": jsonObject{"schema": b.schema(e.body)}},
+			}
+		}
+
+		ok := jsonObject{"description": "OK"}
+		if e.resp != nil {
+			schema := jsonObject{"schema": b.schema(e.resp)}
+			content := jsonObject{"application/json": schema}
+			if !e.v1 {
+				content[cborContentType] = schema
+			}
+			ok["content"] = content
+		} else {
+			ok["content"] = jsonObject{e.contentType: jsonObject{"schema": jsonObject{"type": "string"}}}
+		}
+		op["responses"] = jsonObject{"200": ok, "default": errorResp}
+		if e.v1 {
+			op["responses"] = jsonObject{"200": ok, "default": v1ErrorResp}
+		}
+		if e.private && len(s.opts.APIKeys) != 0 {
+			op["security"] = []jsonObject{{"apiKey": []string{}}}
+		}
+
+		item, _ := paths[e.path].(jsonObject)
+		if item == nil {
+			item = jsonObject{}
+			paths[e.path] = item
+		}
+		item[strings.ToLower(e.method)] = op
+	}
+
+	components := jsonObject{"schemas": b.components}
+	if len(s.opts.APIKeys) != 0 {
+		components["securitySchemes"] = jsonObject{
+			"apiKey": jsonObject{"type": "http", "scheme": "bearer"},
+		}
+	}
+	return jsonObject{
+		"openapi": "3.0.3",
+		"info": jsonObject{
+			"title":   "Timecapsule",
+			"version": fmt.Sprintf("%s, v1", apiVersion),
+		},
+		"paths":      paths,
+		"components": components,
+	}
+}
+
+// Simple handler for the OpenAPI document.
+func (s *Server) getOpenAPI(url.Values) (jsonObject, int, string) {
+	return s.openAPIDocument(), http.StatusOK, ""
+}
@@ -0,0 +1,43 @@
+package server
+
+import (
+	"errors"
+	"log"
+	"time"
+
+	"github.com/newgrp/timecapsule/keys"
+)
+
+// How often upcoming public keys are precomputed.
+const precomputeCheckInterval = time.Minute
+
+// Precomputes the public keys of the configured number of upcoming intervals for every PKI.
+//
+// Public keys are not secret, so the system clock is good enough to decide which are upcoming.
+func (s *Server) precomputePublicKeys() {
+	now := time.Now()
+	for _, km := range s.allPKIs() {
+		n, err := km.PrecomputePublicKeys(now, s.opts.PrecomputeIntervals)
+		// PKIs that are still generating secrets are retried on the next pass.
+		if err != nil && !errors.Is(err, keys.ErrNotReady) {
+			log.Printf("ERROR: Failed to precompute public keys of PKI %s: %+v", km.PKIID(), err)
+		}
+		if n > 0 {
+			log.Printf("Precomputed %d public keys of PKI %s", n, km.PKIID())
+		}
+	}
+}
+
+// Precomputes upcoming public keys periodically until the server is closed.
+func (s *Server) runPrecompute() {
+	ticker := time.NewTicker(precomputeCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+		}
+		s.precomputePublicKeys()
+	}
+}
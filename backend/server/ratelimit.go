@@ -0,0 +1,130 @@
+package server
+
+import (
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Largest number of clients whose request rates are tracked at once. Beyond this, clients that
+// have been idle long enough to have a full bucket are forgotten.
+const maxTrackedClients = 10000
+
+// Token bucket rate limit.
+type RateLimit struct {
+	// Sustained number of requests permitted per second. If zero, requests are not limited.
+	Rate float64
+	// Number of requests permitted in a burst. If zero, one request is permitted at a time.
+	Burst int
+}
+
+// Request rate limits of the public API. Private key requests must satisfy both the general limits
+// and the private key limits.
+type RateLimitOptions struct {
+	// Limit on requests from each client IP address.
+	PerClient RateLimit
+	// Limit on requests from all clients combined.
+	Global RateLimit
+	// Limit on private key requests from each client IP address.
+	PrivateKeyPerClient RateLimit
+	// Limit on private key requests from all clients combined.
+	PrivateKeyGlobal RateLimit
+}
+
+func (l RateLimit) burst() float64 {
+	return float64(max(l.Burst, 1))
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// Refills the bucket for the time elapsed since it was last used and tries to take a token from
+// it. Returns whether a token was taken.
+func (b *tokenBucket) take(l RateLimit, now time.Time) bool {
+	b.tokens = min(l.burst(), b.tokens+now.Sub(b.last).Seconds()*l.Rate)
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// Whether the bucket would be full at the given time.
+func (b *tokenBucket) full(l RateLimit, now time.Time) bool {
+	return b.tokens+now.Sub(b.last).Seconds()*l.Rate >= l.burst()
+}
+
+// Rate limiter with a bucket per client and a bucket for all clients.
+type rateLimiter struct {
+	perClient RateLimit
+	global    RateLimit
+
+	mu           sync.Mutex
+	globalBucket tokenBucket
+	clients      map[string]*tokenBucket
+}
+
+func newRateLimiter(perClient RateLimit, global RateLimit) *rateLimiter {
+	return &rateLimiter{
+		perClient:    perClient,
+		global:       global,
+		globalBucket: tokenBucket{tokens: global.burst(), last: time.Now()},
+		clients:      make(map[string]*tokenBucket),
+	}
+}
+
+// Whether a request from the given client is permitted.
+func (l *rateLimiter) allow(client string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+
+	if l.perClient.Rate > 0 {
+		b, ok := l.clients[client]
+		if !ok {
+			if len(l.clients) >= maxTrackedClients {
+				for c, b := range l.clients {
+					if b.full(l.perClient, now) {
+						delete(l.clients, c)
+					}
+				}
+			}
+			b = &tokenBucket{tokens: l.perClient.burst(), last: now}
+			l.clients[client] = b
+		}
+		if !b.take(l.perClient, now) {
+			return false
+		}
+	}
+	if l.global.Rate > 0 && !l.globalBucket.take(l.global, now) {
+		return false
+	}
+	return true
+}
+
+// Identifies the client of a request by IP address. Forwarding headers are ignored, since clients
+// can set them to anything.
+func clientIP(req *http.Request) string {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
+
+// Wraps a handler so that requests beyond the limiter's rate are rejected.
+func rateLimit(l *rateLimiter, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		if !l.allow(clientIP(req)) {
+			resp.Header().Set("Retry-After", "1")
+			resp.WriteHeader(http.StatusTooManyRequests)
+			resp.Write([]byte("Too many requests; try again later\n"))
+			return
+		}
+		h.ServeHTTP(resp, req)
+	})
+}
@@ -0,0 +1,41 @@
+package server
+
+import (
+	"log"
+	"time"
+)
+
+// How often PKIs with retention policies are checked for expired secrets.
+const retentionCheckInterval = time.Minute
+
+// Deletes the expired secrets of every PKI with a retention policy, according to the secure clock.
+func (s *Server) deleteExpiredSecrets() {
+	now, err := s.clock.Now()
+	if err != nil {
+		log.Printf("ERROR: Not deleting expired secrets: failed to determine the current time securely: %+v", err)
+		return
+	}
+	for _, km := range s.allPKIs() {
+		n, err := km.DeleteExpiredSecrets(now)
+		if err != nil {
+			log.Printf("ERROR: Failed to delete expired secrets of PKI %s: %+v", km.PKIID(), err)
+		}
+		if n > 0 {
+			log.Printf("Deleted %d expired secrets of PKI %s", n, km.PKIID())
+		}
+	}
+}
+
+// Deletes expired secrets periodically until the server is closed.
+func (s *Server) runRetention() {
+	ticker := time.NewTicker(retentionCheckInterval)
+	defer ticker.Stop()
+	for {
+		s.deleteExpiredSecrets()
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
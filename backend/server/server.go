@@ -1,72 +1,282 @@
 package server
 
 import (
+	"crypto/ecdh"
 	"crypto/x509"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"net/url"
+	"slices"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/newgrp/timecapsule/cbor"
 	"github.com/newgrp/timecapsule/clock"
 	"github.com/newgrp/timecapsule/keys"
 )
 
 const (
 	// Request parameter names.
-	argPKIID = "pki_id"
-	argTime  = "time"
+	argPKIID  = "pki_id"
+	argTime   = "time"
+	argEvent  = "event"
+	argName   = "name"
+	argWrapTo = "wrap_to"
+	argStart  = "start"
+	argEnd    = "end"
+	argStep   = "step"
+	argFormat = "format"
+	argFrom   = "from"
+	argTo     = "to"
+	argWait   = "wait"
+	argIn     = "in"
+	argHybrid = "hybrid"
+
+	argMinTime   = "min_time"
+	argMaxTime   = "max_time"
+	argAlgorithm = "algorithm"
+	argReason    = "reason"
+
+	argTreeSize = "tree_size"
+	argFirst    = "first"
+	argSecond   = "second"
+
+	// Parameter through which the request ID is passed to simple handlers, for their error logs.
+	// Any value given by the client is replaced.
+	argRequestID = "request_id"
 
 	// REST method names.
-	methodGetPublicKey  = "get_public_key"
-	methodGetPrivateKey = "get_private_key"
+	methodGetPublicKey   = "get_public_key"
+	methodGetPrivateKey  = "get_private_key"
+	methodGetPublicKeys  = "get_public_keys"
+	methodGetPrivateKeys = "get_private_keys"
+	methodGetBundle      = "get_public_key_bundle"
+	methodGetPublicKEM   = "get_public_mlkem_key"
+	methodGetPrivateKEM  = "get_private_mlkem_key"
+	methodGetPublicSig   = "get_public_signing_key"
+	methodGetPrivateSig  = "get_private_signing_key"
+	methodInfo           = "info"
+	methodNow            = "now"
+	methodClockHealth    = "clock_health"
+	methodAvailability   = "availability"
+	methodJWKS           = "jwks"
+	methodSigningKey     = "signing_key"
+	methodUnlockStream   = "unlock_stream"
+	methodUnlockFeed     = "unlock_feed"
+	methodUnlockCalendar = "unlocks.ics"
+	methodListPKIs       = "list_pkis"
+	methodLogTreeHead    = "log_tree_head"
+	methodLogInclusion   = "log_inclusion_proof"
+	methodLogConsistency = "log_consistency_proof"
+	methodRegisterEvent  = "register_event"
+	methodCreatePKI      = "create_pki"
+	methodExtendPKI      = "extend_pki"
+	methodFreeze         = "freeze"
+	methodUnfreeze       = "unfreeze"
+	methodRevoke         = "revoke"
+	methodHalt           = "halt"
+	methodResume         = "resume"
+	methodSecretMetadata = "secret_metadata"
 )
 
+// Error message for requests that need secrets that have not been generated yet.
+const notReadyError = "Server is still generating secrets; try again later"
+
+// Error message for requests that need the current time while the secure clock doesn't know it, as
+// before its first reading or once its reading has gone stale.
+const noTimeError = "Server could not securely determine the current time"
+
+// How long clients are asked to wait before retrying a request refused with 503 Service
+// Unavailable. Such refusals are transient: the secure clock keeps retrying its time servers, and
+// background generation eventually creates every secret.
+const retryAfter = time.Minute
+
+// Error message for requests that need secrets deleted under a PKI's retention policy.
+const deletedError = "Keys for this time have been deleted under the PKI's retention policy"
+
+// Error message for requests for revoked keys.
+const revokedError = "Keys for this time have been revoked"
+
+// Parameters with which a key was derived.
+//
+// Interval is the length of time covered by each root secret, in seconds. It is not the lifetime of
+// a key: keys are derived per second, so each second within an interval has its own key pair.
+type DerivationInfo struct {
+	DerivationVersion int    `json:"derivationVersion"`
+	Algorithm         string `json:"algorithm"`
+	Interval          int64  `json:"interval"`
+}
+
 type GetPublicKeyResp struct {
 	PKIName string `json:"pkiName"`
 	PKIID   string `json:"pkiID"`
-	SPKI    []byte `json:"spki"`
+	// Target time of the key, in RFC 3339 format. For requests given relative to the current time,
+	// this is the resolved absolute time.
+	Time string `json:"time"`
+	SPKI []byte `json:"spki"`
+	// SHA-256 fingerprint of SPKI, in hex. See keys.Fingerprint.
+	Fingerprint string `json:"fingerprint"`
+
+	// Ed25519 signature over (PKI ID, time, SPKI) by the PKI signing key identified by
+	// SigningKeyID. See keys.PublicKeySignatureMessage.
+	Signature    []byte `json:"signature"`
+	SigningKeyID string `json:"signingKeyID"`
+	// SHA-256 fingerprint of the PKI signing key's SubjectPublicKeyInfo, in hex.
+	SigningKeyFingerprint string `json:"signingKeyFingerprint"`
+
+	// In hybrid mode, the ML-KEM-768 encapsulation key for the same time and its signature, as in
+	// GetPublicMLKEMKeyResp.
+	MLKEMEncapsulationKey []byte `json:"mlkemEncapsulationKey,omitempty"`
+	MLKEMSignature        []byte `json:"mlkemSignature,omitempty"`
+
+	DerivationInfo
 }
 
 type GetPrivateKeyResp struct {
 	PKIName string `json:"pkiName"`
 	PKIID   string `json:"pkiID"`
-	PKCS8   []byte `json:"pkcs8"`
+	PKCS8   []byte `json:"pkcs8,omitempty"`
+	// SHA-256 fingerprint of the SubjectPublicKeyInfo of the corresponding public key, in hex, as in
+	// GetPublicKeyResp.
+	Fingerprint string `json:"fingerprint"`
+	// SHA-256 fingerprint of the PKI signing key's SubjectPublicKeyInfo, in hex.
+	SigningKeyFingerprint string `json:"signingKeyFingerprint"`
+
+	// If the request specified a key to wrap to, the PKCS #8 message is instead HPKE-sealed to that
+	// key, with the encapsulated key in Enc and the ciphertext in Sealed.
+	Enc    []byte `json:"enc,omitempty"`
+	Sealed []byte `json:"sealed,omitempty"`
+
+	// In hybrid mode, the seed of the ML-KEM-768 decapsulation key for the same time, as in
+	// GetPrivateMLKEMKeyResp. If the request specified a key to wrap to, the seed is instead sealed
+	// separately, with the encapsulated key in MLKEMEnc and the ciphertext in MLKEMSealed.
+	MLKEMSeed   []byte `json:"mlkemSeed,omitempty"`
+	MLKEMEnc    []byte `json:"mlkemEnc,omitempty"`
+	MLKEMSealed []byte `json:"mlkemSealed,omitempty"`
+
+	Attestation *ReleaseAttestation `json:"attestation"`
+
+	DerivationInfo
+}
+
+// Signed statement that the private key for TargetTime was released at ReleaseTime according to the
+// secure clock. Signature is an Ed25519 signature by the PKI signing key identified by
+// SigningKeyID. See keys.ReleaseAttestationMessage for the signed message.
+type ReleaseAttestation struct {
+	TargetTime   string `json:"targetTime"`
+	ReleaseTime  string `json:"releaseTime"`
+	Signature    []byte `json:"signature"`
+	SigningKeyID string `json:"signingKeyID"`
 }
 
-// Parses a time string, which may be either:
+// Integer timestamps at least this large in magnitude are interpreted as milliseconds rather than
+// seconds since the Unix epoch. As seconds, they would be more than 30,000 years from now; as
+// milliseconds, they are after 2001.
+const minMillisTimestamp = 1_000_000_000_000
+
+// Parses a time string, which may be any of:
 //
 //   - integer seconds since Unix epoch
+//   - integer milliseconds since Unix epoch, for values of at least minMillisTimestamp
 //   - RFC 3339 formatted time string
+//   - RFC 1123 formatted date, as in HTTP headers
+//   - date-only string in YYYY-MM-DD format, interpreted as the start of that day in UTC
+//
+// The format is chosen by the shape of the string, and errors describe why the string failed to
+// parse in that format.
 func parseTime(s string) (time.Time, error) {
-	if sec, err := strconv.ParseInt(s, 10, 64); err == nil {
-		return time.Unix(sec, 0), nil
+	if digits := strings.TrimPrefix(s, "-"); digits != "" && strings.Trim(digits, "0123456789") == "" {
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid Unix timestamp: %w", err)
+		}
+		if n >= minMillisTimestamp || n <= -minMillisTimestamp {
+			return time.UnixMilli(n), nil
+		}
+		return time.Unix(n, 0), nil
 	}
 
-	if t, err := time.Parse(time.RFC3339, s); err == nil {
+	switch {
+	case strings.Contains(s, ","):
+		t, err := time.Parse(time.RFC1123Z, s)
+		if err != nil {
+			t, err = time.Parse(time.RFC1123, s)
+		}
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid RFC 1123 date: %w", err)
+		}
+		return t, nil
+	case len(s) == len(time.DateOnly):
+		t, err := time.Parse(time.DateOnly, s)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid YYYY-MM-DD date: %w", err)
+		}
+		return t, nil
+	default:
+		t, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid RFC 3339 time: %w", err)
+		}
 		return t, nil
 	}
+}
 
-	return time.Time{}, fmt.Errorf("time must be given either as integer seconds since the Unix epoch or RFC 3339 string")
+// Parses a base64url-encoded DER SubjectPublicKeyInfo message as an ECDH public key. Padding is
+// optional.
+func parseWrapKey(s string) (*ecdh.PublicKey, error) {
+	der, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+	if err != nil {
+		return nil, fmt.Errorf("key must be base64url-encoded: %w", err)
+	}
+	return keys.ParseECDHPublicKeyAsSPKIDER(der)
+}
+
+// Request headers that may supply parameters in place of the query string, keyed by parameter
+// name. Query parameters take precedence over headers.
+var headerParams = map[string]string{
+	argTime:  "X-Timecapsule-Time",
+	argPKIID: "X-Timecapsule-PKI-ID",
 }
 
 // HTTP handler that only depends on URL parameters. Returns (JSON-encodable value, HTTP status
 // code, error message).
 type simpleHandler = func(url.Values) (any, int, string)
 
+// Parses the parameters of a request from its query string, falling back to headers for parameters
+// that the query string doesn't give, and adds the request ID.
+func requestQuery(req *http.Request) (url.Values, error) {
+	query, err := url.ParseQuery(req.URL.RawQuery)
+	if err != nil {
+		return nil, err
+	}
+	for arg, header := range headerParams {
+		if v := req.Header.Get(header); v != "" && !query.Has(arg) {
+			query.Set(arg, v)
+		}
+	}
+	if format := formatFromAccept(req.Header.Get("Accept")); format != "" && !query.Has(argFormat) {
+		query.Set(argFormat, format)
+	}
+	query.Set(argRequestID, requestID(req.Context()))
+	return query, nil
+}
+
 // makeHandler converts a simpleHandler to an http.HandlerFunc.
 //
-// This function handles URL query parsing, JSON encoding, HTTP headers, and appending the body with
-// a newline.
+// This function handles URL query parsing (including parameters supplied as headers and key formats
+// requested via the Accept header, and the request ID), JSON encoding, HTTP headers, and appending
+// the body with a newline. Values of type *rawBody are written verbatim instead of being encoded as
+// JSON. Other values are encoded as CBOR instead if the Accept header asks for it.
 func makeHandler(h simpleHandler) http.HandlerFunc {
 	return func(resp http.ResponseWriter, req *http.Request) {
-		resp.Header().Add("Access-Control-Allow-Origin", "*")
-
-		query, err := url.ParseQuery(req.URL.RawQuery)
+		query, err := requestQuery(req)
 		if err != nil {
 			resp.WriteHeader(http.StatusBadRequest)
 			resp.Write([]byte(fmt.Sprintf("Could not parse request parameters: %v\n", err)))
@@ -74,185 +284,783 @@ func makeHandler(h simpleHandler) http.HandlerFunc {
 		}
 
 		value, status, message := h(query)
-
-		var body string
-		if status == http.StatusOK {
-			b := &strings.Builder{}
-			e := json.NewEncoder(b)
-			e.SetEscapeHTML(false)
-			if err = e.Encode(value); err != nil {
-				log.Printf("ERROR: Failed to encode value of type %T as JSON: %v", value, err)
-				resp.WriteHeader(http.StatusInternalServerError)
-				return
-			}
-			body = b.String()
-		} else {
-			body = message
+		if status != http.StatusOK {
+			writeText(resp, status, message)
+			return
 		}
-		if len(body) != 0 && body[len(body)-1] != '\n' {
-			body = fmt.Sprintf("%s\n", body)
+		if raw, ok := value.(*rawBody); ok {
+			resp.Header().Set("Content-Type", raw.contentType)
+			resp.WriteHeader(status)
+			resp.Write(raw.data)
+			return
+		}
+		if acceptsCBOR(req.Header.Get("Accept")) {
+			writeCBOR(resp, status, value)
+			return
 		}
+		writeJSON(resp, status, value)
+	}
+}
 
-		resp.WriteHeader(status)
-		resp.Write([]byte(body))
+// Asks the client to retry later if the status is 503 Service Unavailable.
+func setRetryAfter(resp http.ResponseWriter, status int) {
+	if status == http.StatusServiceUnavailable {
+		resp.Header().Set("Retry-After", strconv.Itoa(int(retryAfter/time.Second)))
 	}
 }
 
+// Writes a plain text response body, appending a newline if necessary.
+func writeText(resp http.ResponseWriter, status int, body string) {
+	if len(body) != 0 && body[len(body)-1] != '\n' {
+		body = fmt.Sprintf("%s\n", body)
+	}
+	setRetryAfter(resp, status)
+	resp.WriteHeader(status)
+	resp.Write([]byte(body))
+}
+
+// Writes a JSON response body, followed by a newline.
+func writeJSON(resp http.ResponseWriter, status int, value any) {
+	b := &strings.Builder{}
+	e := json.NewEncoder(b)
+	e.SetEscapeHTML(false)
+	if err := e.Encode(value); err != nil {
+		log.Printf("ERROR: Failed to encode value of type %T as JSON: %v", value, err)
+		resp.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	resp.WriteHeader(status)
+	resp.Write([]byte(b.String()))
+}
+
+// Writes a CBOR response body.
+func writeCBOR(resp http.ResponseWriter, status int, value any) {
+	b, err := cbor.Marshal(value)
+	if err != nil {
+		log.Printf("ERROR: Failed to encode value of type %T as CBOR: %v", value, err)
+		resp.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	resp.Header().Set("Content-Type", cborContentType)
+	resp.WriteHeader(status)
+	resp.Write(b)
+}
+
 // Server options.
 type Options struct {
-	// Addresses of permitted NTS servers.
+	// Addresses of permitted NTS servers. A majority of these and the Roughtime servers must agree
+	// on the time.
 	NTSServers []string
+	// Permitted Roughtime servers, whose signed responses are retained as proof of the time.
+	RoughtimeServers []clock.RoughtimeServer
+	// Polling and staleness thresholds of the secure clock, and trust settings for its NTS servers.
+	Clock clock.Options
+	// Source of the current time. If nil, a secure clock is run using the NTS and Roughtime servers
+	// above. The server starts even if none of them can be reached, serving only public keys until
+	// the clock obtains the time. A time source given here is not stopped by Server.Close.
+	TimeSource clock.TimeSource
+	// Earliest plausible current time. NTS readings before this are rejected.
+	NotBefore time.Time
+	// PKI options of the primary PKI, which serves requests that don't name a PKI.
+	PKIOptions keys.PKIOptions
+	// Working directory for root secrets of the primary PKI.
+	SecretsDir string
+	// Additional PKIs, which serve requests that name them by PKI ID.
+	ExtraPKIs []PKIConfig
+	// Directory under which PKIs created through the admin API store their secrets. If empty, PKIs
+	// cannot be created at runtime.
+	PKIRootDir string
+	// Bearer tokens that grant access to the admin API. If empty, the admin API is disabled.
+	AdminTokens []string
+	// Bearer tokens that grant access to private keys. If empty, private keys are available to
+	// everyone. Public keys are always available to everyone.
+	APIKeys []string
+	// Client certificate requirements of private key methods.
+	ClientCerts ClientCertOptions
+	// Addresses served by ListenAndServe.
+	Listeners []ListenerConfig
+	// Longest time that a private key request with "wait=true" may be held open. If zero,
+	// defaultMaxWait is used.
+	MaxWait time.Duration
+	// How long after a time its private key is withheld, compensating for the uncertainty of the
+	// secure clock. The private key for time t is released once the secure clock reads at least
+	// t + ReleaseMargin. Must not be negative.
+	ReleaseMargin time.Duration
+	// Number of upcoming intervals whose public keys are derived and signed ahead of time, so that
+	// they are served without touching the secret store. If zero, public keys are only derived on
+	// demand. Must not be negative.
+	PrecomputeIntervals int
+	// Whether to keep a transparency log of every PKI's public keys and serve its signed tree heads
+	// and proofs. The log covers each PKI's whole time range, so every public key in the range is
+	// derived once its secret exists.
+	TransparencyLog bool
+	// CORS policy of the public API.
+	CORS CORSOptions
+	// Request rate limits of the public API.
+	RateLimits RateLimitOptions
+	// Whether to serve public keys only, as a mirror for encryption traffic. Private key requests
+	// are refused, and no secure clock is run, so time servers need not be configured or reachable.
+	// Methods that depend on the current time are not served.
+	PublicOnly bool
+	// Whether to log every API request. Requests that fail with a server error are logged
+	// regardless.
+	AccessLog bool
+	// Path of a kill switch file. While the file exists, no private key is disclosed by any PKI,
+	// regardless of the admin API. If empty, there is no kill switch.
+	KillSwitchFile string
+}
+
+// Configuration of an additional PKI.
+type PKIConfig struct {
 	// PKI options.
 	PKIOptions keys.PKIOptions
-	// Working directory for root secrets.
+	// Working directory for root secrets. Must differ from that of every other PKI.
 	SecretsDir string
 }
 
 // Server that handles HTTP requests for time keys.
 type Server struct {
-	clock   *clock.SecureClock
-	keys    *keys.KeyManager
-	minTime time.Time
-	maxTime time.Time
+	opts Options
+	// Source of the current time, or nil if the server is public-only.
+	clock clock.TimeSource
+	// Secure clock run by the server, or nil if the time source was given in the options.
+	secureClock *clock.SecureClock
+	// The primary PKI.
+	keys *keys.KeyManager
+
+	// Guards the following fields, which may change at runtime through the admin API.
+	mu sync.RWMutex
+	// All PKIs, including the primary PKI, by PKI ID and in configuration order.
+	pkis     map[uuid.UUID]*keys.KeyManager
+	pkiOrder []*keys.KeyManager
+	// PKIs whose private key release is frozen.
+	frozen map[uuid.UUID]bool
+	// Whether private key release is halted for every PKI.
+	halted bool
+
+	// Rate limiters for all public API requests and for private key requests.
+	limiter        *rateLimiter
+	privateLimiter *rateLimiter
+
+	// Closed when the server is closed, stopping its background work.
+	done chan struct{}
 }
 
 func NewServer(opts Options) (*Server, error) {
-	clock, err := clock.NewSecureClock(opts.NTSServers)
+	s := &Server{
+		opts:   opts,
+		pkis:   make(map[uuid.UUID]*keys.KeyManager),
+		frozen: make(map[uuid.UUID]bool),
+
+		limiter:        newRateLimiter(opts.RateLimits.PerClient, opts.RateLimits.Global),
+		privateLimiter: newRateLimiter(opts.RateLimits.PrivateKeyPerClient, opts.RateLimits.PrivateKeyGlobal),
+
+		done: make(chan struct{}),
+	}
+	if opts.ReleaseMargin < 0 {
+		return nil, fmt.Errorf("release margin must not be negative, got %s", opts.ReleaseMargin)
+	}
+	if opts.PrecomputeIntervals < 0 {
+		return nil, fmt.Errorf("number of intervals to precompute must not be negative, got %d", opts.PrecomputeIntervals)
+	}
+	if slices.Contains(opts.AdminTokens, "") {
+		return nil, errors.New("admin tokens must not be empty")
+	}
+	if slices.Contains(opts.APIKeys, "") {
+		return nil, errors.New("API keys must not be empty")
+	}
+	if !opts.PublicOnly && opts.TimeSource != nil {
+		s.clock = opts.TimeSource
+	} else if !opts.PublicOnly {
+		clock, err := clock.NewSecureClock(opts.NTSServers, opts.RoughtimeServers, opts.NotBefore, opts.Clock)
+		if err != nil {
+			return nil, err
+		}
+		s.clock = clock
+		s.secureClock = clock
+	}
+	halted, err := s.loadHalted()
 	if err != nil {
 		return nil, err
 	}
+	if halted {
+		log.Printf("Private key release is halted through the admin API; resume it to release keys")
+	}
+	s.halted = halted
+	configs := append([]PKIConfig{{PKIOptions: opts.PKIOptions, SecretsDir: opts.SecretsDir}}, opts.ExtraPKIs...)
+	for _, c := range configs {
+		km, err := keys.NewKeyManager(c.PKIOptions, c.SecretsDir)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load PKI in %s: %w", c.SecretsDir, err)
+		}
+		if err := s.addPKI(km); err != nil {
+			return nil, err
+		}
+	}
+	s.keys = s.pkiOrder[0]
 
-	keys, err := keys.NewKeyManager(opts.PKIOptions, opts.SecretsDir)
-	if err != nil {
-		return nil, err
+	for _, km := range s.pkiOrder {
+		if km.Retention() <= 0 {
+			continue
+		}
+		// Secrets are only deleted according to the secure clock.
+		if opts.PublicOnly {
+			return nil, fmt.Errorf("PKI %s has a retention policy, which public-only servers can't enforce", km.PKIID())
+		}
+		go s.runRetention()
+		break
+	}
+	if opts.PrecomputeIntervals > 0 {
+		// The first pass runs before serving, so that upcoming public keys never touch the secret
+		// store.
+		s.precomputePublicKeys()
+		go s.runPrecompute()
+	}
+	if opts.TransparencyLog {
+		go s.runTransparencyLogs()
+	}
+	return s, nil
+}
+
+// Stops the server's background work, such as polling time servers and deleting expired secrets.
+// Should be called once the server has stopped serving requests.
+func (s *Server) Close() {
+	close(s.done)
+	if s.secureClock != nil {
+		s.secureClock.Close()
+	}
+}
+
+// Adds a PKI to the server. Fails if the server already has a PKI with the same ID.
+func (s *Server) addPKI(km *keys.KeyManager) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.pkis[km.PKIID()]; ok {
+		return fmt.Errorf("PKI %s is configured more than once", km.PKIID())
 	}
+	s.pkis[km.PKIID()] = km
+	s.pkiOrder = append(s.pkiOrder, km)
+	return nil
+}
 
-	return &Server{
-		clock:   clock,
-		keys:    keys,
-		minTime: opts.PKIOptions.MinTime,
-		maxTime: opts.PKIOptions.MaxTime,
-	}, nil
+// Returns all of the server's PKIs, with the primary PKI first.
+func (s *Server) allPKIs() []*keys.KeyManager {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return slices.Clone(s.pkiOrder)
 }
 
-// The PKI name of this server.
+// The PKI name of this server's primary PKI.
 func (s *Server) Name() string {
 	return s.keys.Name()
 }
 
-// The PKI ID of this server.
+// The PKI ID of this server's primary PKI.
 func (s *Server) PKIID() uuid.UUID {
 	return s.keys.PKIID()
 }
 
-// Simple handler for public key requests.
-func (s *Server) getPublicKey(query url.Values) (*GetPublicKeyResp, int, string) {
-	if query.Has(argPKIID) {
-		id, err := uuid.Parse(query.Get(argPKIID))
+// The parameters with which a PKI derives keys.
+func derivationInfo(km *keys.KeyManager) DerivationInfo {
+	params := km.DerivationParams()
+	return DerivationInfo{
+		DerivationVersion: params.Version,
+		Algorithm:         string(params.Algorithm),
+		Interval:          int64(params.Interval / time.Second),
+	}
+}
+
+// Selects the PKI named by a request, or the primary PKI if the request doesn't name one. Returns
+// (PKI, HTTP status code, error message).
+func (s *Server) selectPKI(query url.Values) (*keys.KeyManager, int, string) {
+	if !query.Has(argPKIID) {
+		return s.keys, http.StatusOK, ""
+	}
+	id, err := uuid.Parse(query.Get(argPKIID))
+	if err != nil {
+		return nil, http.StatusBadRequest, fmt.Sprintf("Invalid UUID: %v", err)
+	}
+	s.mu.RLock()
+	km, ok := s.pkis[id]
+	s.mu.RUnlock()
+	if !ok {
+		return nil, http.StatusNotFound, fmt.Sprintf("Server does not have PKI %s", id.String())
+	}
+	return km, http.StatusOK, ""
+}
+
+// Constructs the query parameters that identify the target time of a key request. Empty
+// arguments are omitted.
+func targetQuery(pkiID string, t string, event string) url.Values {
+	query := url.Values{}
+	if pkiID != "" {
+		query.Set(argPKIID, pkiID)
+	}
+	if t != "" {
+		query.Set(argTime, t)
+	}
+	if event != "" {
+		query.Set(argEvent, event)
+	}
+	return query
+}
+
+// Determines the PKI and target time of a key request, returning (PKI, time, HTTP status code,
+// error message).
+//
+// The PKI is selected as by selectPKI. The target time is given either directly by the "time"
+// parameter, indirectly by the name of an event registered with that PKI in the "event"
+// parameter, or relative to the secure clock by a Go duration in the "in" parameter.
+func (s *Server) parseTarget(query url.Values) (*keys.KeyManager, time.Time, int, string) {
+	km, status, message := s.selectPKI(query)
+	if status != http.StatusOK {
+		return nil, time.Time{}, status, message
+	}
+
+	given := 0
+	for _, arg := range []string{argTime, argEvent, argIn} {
+		if query.Has(arg) {
+			given++
+		}
+	}
+	if given > 1 {
+		return nil, time.Time{}, http.StatusBadRequest, fmt.Sprintf("At most one of %q, %q, and %q may be given", argTime, argEvent, argIn)
+	}
+
+	var t time.Time
+	switch {
+	case query.Has(argTime):
+		var err error
+		t, err = parseTime(query.Get(argTime))
 		if err != nil {
-			return nil, http.StatusBadRequest, fmt.Sprintf("Invalid UUID: %v", err)
+			return nil, time.Time{}, http.StatusBadRequest, fmt.Sprintf("Invalid %q paremter: %v", argTime, err)
+		}
+	case query.Has(argEvent):
+		var ok bool
+		t, ok = km.EventTime(query.Get(argEvent))
+		if !ok {
+			return nil, time.Time{}, http.StatusNotFound, fmt.Sprintf("No event named %q", query.Get(argEvent))
 		}
-		if id != s.keys.PKIID() {
-			return nil, http.StatusNotFound, fmt.Sprintf("Server does not have PKI %s", id.String())
+	case query.Has(argIn):
+		d, err := time.ParseDuration(query.Get(argIn))
+		if err != nil {
+			return nil, time.Time{}, http.StatusBadRequest, fmt.Sprintf("Invalid %q parameter: %v", argIn, err)
 		}
+		if s.clock == nil {
+			return nil, time.Time{}, http.StatusBadRequest, fmt.Sprintf("%q parameter is not supported by public-only servers", argIn)
+		}
+		now, err := s.clock.Now()
+		if err != nil {
+			log.Printf("ERROR: Failed to determine the current time securely in request %s: %+v", query.Get(argRequestID), err)
+			return nil, time.Time{}, http.StatusServiceUnavailable, noTimeError
+		}
+		// Keys are derived per second, so resolve to a whole second.
+		t = now.Add(d).Truncate(time.Second)
+	default:
+		return nil, time.Time{}, http.StatusBadRequest, fmt.Sprintf("%q parameter is required", argTime)
 	}
 
-	if !query.Has(argTime) {
-		return nil, http.StatusBadRequest, fmt.Sprintf("%q parameter is required", argTime)
+	if err := km.CheckTime(t); err != nil {
+		return nil, time.Time{}, http.StatusBadRequest, fmt.Sprintf("Invalid time: %v", err)
 	}
-	t, err := parseTime(query.Get(argTime))
-	if err != nil {
-		return nil, http.StatusBadRequest, fmt.Sprintf("Invalid %q paremter: %v", argTime, err)
+	return km, t, http.StatusOK, ""
+}
+
+// Simple handler for public key requests.
+func (s *Server) getPublicKey(query url.Values) (*GetPublicKeyResp, int, string) {
+	km, t, status, message := s.parseTarget(query)
+	if status != http.StatusOK {
+		return nil, status, message
 	}
-	if t.Compare(s.minTime) < 0 || t.Compare(s.maxTime) > 0 {
-		return nil, http.StatusBadRequest, fmt.Sprintf("Time out of range: must be between %s and %s", s.minTime.Format(time.RFC3339), s.maxTime.Format(time.RFC3339))
+	hybrid, status, message := parseHybrid(query)
+	if status != http.StatusOK {
+		return nil, status, message
 	}
 
+	der, status, message := publicKeySPKI(km, t, query.Get(argRequestID))
+	if status != http.StatusOK {
+		return nil, status, message
+	}
+	ret := &GetPublicKeyResp{
+		PKIName:               km.Name(),
+		PKIID:                 km.PKIID().String(),
+		Time:                  t.UTC().Format(time.RFC3339),
+		SPKI:                  der,
+		Fingerprint:           keys.Fingerprint(der),
+		Signature:             km.SignPublicKey(t, der),
+		SigningKeyID:          km.SigningKeyID(),
+		SigningKeyFingerprint: km.SigningKeyFingerprint(),
+		DerivationInfo:        derivationInfo(km),
+	}
+	if hybrid {
+		key, status, message := mlkemKey(km, t, query.Get(argRequestID))
+		if status != http.StatusOK {
+			return nil, status, message
+		}
+		ret.MLKEMEncapsulationKey = key.EncapsulationKey().Bytes()
+		ret.MLKEMSignature = km.SignPublicKey(t, ret.MLKEMEncapsulationKey)
+	}
+	return ret, http.StatusOK, ""
+}
+
+// Retrieves a PKI's public key for the given time as a DER-encoded SubjectPublicKeyInfo message.
+// Returns (DER, HTTP status code, error message).
+func publicKeySPKI(km *keys.KeyManager, t time.Time, reqID string) ([]byte, int, string) {
 	// Don't expose internal error details to clients. Instead, log the full error but return a
 	// generic message.
 	const internalError = "Server failed to retrieve public key"
 
-	priv, err := s.keys.GetKeyForTime(t)
+	if k, ok := km.PrecomputedPublicKey(t); ok {
+		return k.SPKI, http.StatusOK, ""
+	}
+	priv, err := km.GetKeyForTime(t)
+	if errors.Is(err, keys.ErrNotReady) {
+		return nil, http.StatusServiceUnavailable, notReadyError
+	}
+	if errors.Is(err, keys.ErrSecretDeleted) {
+		return nil, http.StatusGone, deletedError
+	}
+	if errors.Is(err, keys.ErrRevoked) {
+		return nil, http.StatusGone, revokedError
+	}
 	if err != nil {
-		log.Printf("ERROR: Failed to retrieve key for time %s: %+v", t.Format(time.RFC3339), err)
+		log.Printf("ERROR: Failed to retrieve key for time %s in request %s: %+v", t.Format(time.RFC3339), reqID, err)
 		return nil, http.StatusInternalServerError, internalError
 	}
 
-	der, err := x509.MarshalPKIXPublicKey(priv.PublicKey())
+	der, err := x509.MarshalPKIXPublicKey(priv.Public())
 	if err != nil {
-		log.Printf("ERROR: Failed to marshal public key for time %s: %+v", t.Format(time.RFC3339), err)
+		log.Printf("ERROR: Failed to marshal public key for time %s in request %s: %+v", t.Format(time.RFC3339), reqID, err)
 		return nil, http.StatusInternalServerError, internalError
 	}
-	return &GetPublicKeyResp{
-		PKIName: s.keys.Name(),
-		PKIID:   s.keys.PKIID().String(),
-		SPKI:    der,
-	}, http.StatusOK, ""
+	return der, http.StatusOK, ""
 }
 
 // Simple handler for private key requests.
 func (s *Server) getPrivateKey(query url.Values) (*GetPrivateKeyResp, int, string) {
-	if query.Has(argPKIID) {
-		id, err := uuid.Parse(query.Get(argPKIID))
+	km, t, status, message := s.parseTarget(query)
+	if status != http.StatusOK {
+		return nil, status, message
+	}
+
+	var wrapTo *ecdh.PublicKey
+	if query.Has(argWrapTo) {
+		var err error
+		wrapTo, err = parseWrapKey(query.Get(argWrapTo))
 		if err != nil {
-			return nil, http.StatusBadRequest, fmt.Sprintf("Invalid UUID: %v", err)
+			return nil, http.StatusBadRequest, fmt.Sprintf("Invalid %q parameter: %v", argWrapTo, err)
 		}
-		if id != s.keys.PKIID() {
-			return nil, http.StatusNotFound, fmt.Sprintf("Server does not have PKI %s", id.String())
+	}
+	hybrid, status, message := parseHybrid(query)
+	if status != http.StatusOK {
+		return nil, status, message
+	}
+
+	released, status, message := s.checkDisclosable(km, t, query.Get(argRequestID))
+	if status != http.StatusOK {
+		return nil, status, message
+	}
+
+	der, status, message := privateKeyPKCS8(km, t, query.Get(argRequestID))
+	if status != http.StatusOK {
+		return nil, status, message
+	}
+	spki, status, message := publicKeySPKI(km, t, query.Get(argRequestID))
+	if status != http.StatusOK {
+		return nil, status, message
+	}
+	ret := &GetPrivateKeyResp{
+		PKIName:               km.Name(),
+		PKIID:                 km.PKIID().String(),
+		PKCS8:                 der,
+		Fingerprint:           keys.Fingerprint(spki),
+		SigningKeyFingerprint: km.SigningKeyFingerprint(),
+		Attestation: &ReleaseAttestation{
+			TargetTime:   t.UTC().Format(time.RFC3339),
+			ReleaseTime:  released.UTC().Format(time.RFC3339),
+			Signature:    km.AttestRelease(t, released),
+			SigningKeyID: km.SigningKeyID(),
+		},
+		DerivationInfo: derivationInfo(km),
+	}
+	if hybrid {
+		key, status, message := mlkemKey(km, t, query.Get(argRequestID))
+		if status != http.StatusOK {
+			return nil, status, message
+		}
+		ret.MLKEMSeed = key.Bytes()
+	}
+	if wrapTo != nil {
+		var err error
+		ret.Enc, ret.Sealed, err = keys.Wrap(wrapTo, der)
+		if err == nil && hybrid {
+			ret.MLKEMEnc, ret.MLKEMSealed, err = keys.Wrap(wrapTo, ret.MLKEMSeed)
+		}
+		if err != nil {
+			log.Printf("ERROR: Failed to wrap private key for time %s in request %s: %+v", t.Format(time.RFC3339), query.Get(argRequestID), err)
+			return nil, http.StatusInternalServerError, "Server failed to retrieve private key"
 		}
+		ret.PKCS8 = nil
+		ret.MLKEMSeed = nil
 	}
+	return ret, http.StatusOK, ""
+}
 
-	if !query.Has(argTime) {
-		return nil, http.StatusBadRequest, fmt.Sprintf("%q parameter is required", argTime)
+// Checks that a PKI's private key for the given time may be disclosed, i.e. that the server is not
+// public-only, that the time is not in the future according to the secure clock, and that neither
+// the server's nor the PKI's release is halted. Returns (earliest possible current time, HTTP status
+// code, error message).
+func (s *Server) checkDisclosable(km *keys.KeyManager, t time.Time, reqID string) (time.Time, int, string) {
+	if s.opts.PublicOnly {
+		return time.Time{}, http.StatusForbidden, "Server is public-only and does not disclose private keys"
+	}
+	if s.isHalted() {
+		return time.Time{}, http.StatusForbidden, "Private key release is halted"
 	}
-	t, err := parseTime(query.Get(argTime))
+	if s.isFrozen(km.PKIID()) {
+		return time.Time{}, http.StatusForbidden, fmt.Sprintf("Private key release is frozen for PKI %s", km.PKIID())
+	}
+	now, err := s.earliestNow()
 	if err != nil {
-		return nil, http.StatusBadRequest, fmt.Sprintf("Invalid %q paremter: %v", argTime, err)
+		log.Printf("ERROR: Failed to determine the current time securely in request %s: %+v", reqID, err)
+		return time.Time{}, http.StatusServiceUnavailable, noTimeError
 	}
-	if t.Compare(s.minTime) < 0 || t.Compare(s.maxTime) > 0 {
-		return nil, http.StatusBadRequest, fmt.Sprintf("Time out of range: must be between %s and %s", s.minTime.Format(time.RFC3339), s.maxTime.Format(time.RFC3339))
+	if now.Before(s.releaseTime(t)) {
+		if s.opts.ReleaseMargin > 0 && !now.Before(t) {
+			return time.Time{}, http.StatusForbidden, fmt.Sprintf("Server discloses private keys %s after their timestamps", s.opts.ReleaseMargin)
+		}
+		return time.Time{}, http.StatusForbidden, "Server does not disclose private keys for future timestamps"
 	}
+	return now, http.StatusOK, ""
+}
 
-	now, err := s.clock.Now()
+// Returns the earliest that the current time may be according to the secure clock. Release is
+// checked against this rather than a point estimate, so that the clock's uncertainty can't cause a
+// private key to be disclosed early.
+func (s *Server) earliestNow() (time.Time, error) {
+	interval, err := s.clock.Interval()
 	if err != nil {
-		log.Printf("ERROR: Failed to determine the current time securely: %+v", err)
-		return nil, http.StatusInternalServerError, "Server could securely determine the current time"
-	}
-	if t.After(now) {
-		return nil, http.StatusForbidden, "Server does not disclose private keys for future timestamps"
+		return time.Time{}, err
 	}
+	return interval.Earliest, nil
+}
+
+// Returns the earliest time at which the private key for the given time may be disclosed, i.e. the
+// time plus the release margin.
+func (s *Server) releaseTime(t time.Time) time.Time {
+	return t.Add(s.opts.ReleaseMargin)
+}
 
+// Retrieves a PKI's private key for the given time as a DER-encoded PKCS #8 message. Returns (DER,
+// HTTP status code, error message).
+//
+// The caller is responsible for checking that the key may be disclosed.
+func privateKeyPKCS8(km *keys.KeyManager, t time.Time, reqID string) ([]byte, int, string) {
 	// Don't expose internal error details to clients. Instead, log the full error but return a
 	// generic message.
 	const internalError = "Server failed to retrieve private key"
 
-	priv, err := s.keys.GetKeyForTime(t)
+	priv, err := km.GetKeyForTime(t)
+	if errors.Is(err, keys.ErrNotReady) {
+		return nil, http.StatusServiceUnavailable, notReadyError
+	}
+	if errors.Is(err, keys.ErrSecretDeleted) {
+		return nil, http.StatusGone, deletedError
+	}
+	if errors.Is(err, keys.ErrRevoked) {
+		return nil, http.StatusGone, revokedError
+	}
 	if err != nil {
-		log.Printf("ERROR: Failed to retrieve key for time %s: %+v", t.Format(time.RFC3339), err)
+		log.Printf("ERROR: Failed to retrieve key for time %s in request %s: %+v", t.Format(time.RFC3339), reqID, err)
 		return nil, http.StatusInternalServerError, internalError
 	}
 
 	der, err := x509.MarshalPKCS8PrivateKey(priv)
 	if err != nil {
-		log.Printf("ERROR: Failed to marshal private key for time %s: %+v", t.Format(time.RFC3339), err)
+		log.Printf("ERROR: Failed to marshal private key for time %s in request %s: %+v", t.Format(time.RFC3339), reqID, err)
 		return nil, http.StatusInternalServerError, internalError
 	}
-	return &GetPrivateKeyResp{
-		PKIName: s.keys.Name(),
-		PKIID:   s.keys.PKIID().String(),
-		PKCS8:   der,
-	}, http.StatusOK, ""
+	return der, http.StatusOK, ""
+}
+
+// Handler for readiness probes. Reports 200 OK once all secrets of all PKIs have been generated
+// and 503 Service Unavailable before then.
+func (s *Server) readyz(resp http.ResponseWriter, req *http.Request) {
+	for _, km := range s.allPKIs() {
+		if !km.Ready() {
+			resp.WriteHeader(http.StatusServiceUnavailable)
+			resp.Write([]byte("not ready\n"))
+			return
+		}
+	}
+	resp.WriteHeader(http.StatusOK)
+	resp.Write([]byte("ready\n"))
+}
+
+// Wraps a private key handler with the protections common to every private key method: the
+// private key rate limits, client certificate and API key authentication, and disabling caching.
+func (s *Server) privateKeyHandler(h http.Handler) http.Handler {
+	return rateLimit(s.privateLimiter, s.requireClientCert(s.requireAPIKey(noStore(h))))
 }
 
 // Registers handlers for the following methods:
 //
+//   - GET /readyz
+//   - GET /openapi.json
+//   - GET /v0/info
+//   - GET /v0/list_pkis
+//   - GET /v0/signing_key
+//   - GET /v0/now
+//   - GET /v0/clock_health
+//   - GET /v0/availability
 //   - GET /v0/get_public_key
 //   - GET /v0/get_private_key
+//   - GET /v0/get_public_keys
+//   - POST /v0/get_public_keys
+//   - GET /v0/get_private_keys
+//   - GET /v0/get_public_key_bundle
+//   - GET /v0/get_public_mlkem_key
+//   - GET /v0/get_private_mlkem_key
+//   - GET /v0/get_public_signing_key
+//   - GET /v0/get_private_signing_key
+//   - GET /v0/jwks
+//   - GET /v0/unlock_stream
+//   - GET /v0/unlock_feed
+//   - GET /v0/unlocks.ics
+//   - GET /v0/log_tree_head
+//   - GET /v0/log_inclusion_proof
+//   - GET /v0/log_consistency_proof
+//   - POST /v1/get_public_key
+//   - POST /v1/get_private_key
+//   - POST /v1/get_public_keys
+//   - POST /v1/get_private_keys
+//
+// Public-only servers don't serve now, clock_health, availability, unlock_stream, or unlock_feed,
+// and refuse every private key request. The transparency log methods are only served if the server
+// keeps transparency logs.
+//
+// Responses from these methods carry the CORS headers permitted by the configured policy, and
+// OPTIONS requests under /v0/ and /v1/ are answered as CORS preflight requests. Requests to these
+// methods are subject to the configured rate limits, with private key methods additionally subject
+// to the private key limits and to the configured client certificate and API key requirements.
+//
+// If admin tokens are configured, also registers the following admin methods:
+//
+//   - POST /admin/v0/register_event
+//   - POST /admin/v0/create_pki
+//   - POST /admin/v0/extend_pki
+//   - POST /admin/v0/freeze
+//   - POST /admin/v0/unfreeze
+//   - POST /admin/v0/revoke
+//   - POST /admin/v0/halt
+//   - POST /admin/v0/resume
+//   - POST /admin/v0/secret_metadata
 func (s *Server) RegisterHandlers(mux *http.ServeMux) {
-	mux.HandleFunc(fmt.Sprintf("GET /v0/%s", methodGetPublicKey), makeHandler(func(query url.Values) (any, int, string) {
-		return s.getPublicKey(query)
+	mux.HandleFunc("GET /readyz", s.readyz)
+	handle := func(pattern string, h http.Handler) {
+		mux.Handle(pattern, s.accessLog(compressResponses(s.allowCORS(rateLimit(s.limiter, h)))))
+	}
+	mux.HandleFunc("OPTIONS /v0/", s.corsPreflight)
+	mux.HandleFunc("OPTIONS /v1/", s.corsPreflight)
+	handle("GET /openapi.json", makeHandler(func(query url.Values) (any, int, string) {
+		return s.getOpenAPI(query)
+	}))
+	handle(fmt.Sprintf("GET /v0/%s", methodInfo), makeHandler(func(query url.Values) (any, int, string) {
+		return s.getInfo(query)
+	}))
+	handle(fmt.Sprintf("GET /v0/%s", methodListPKIs), makeHandler(func(query url.Values) (any, int, string) {
+		return s.listPKIs(query)
+	}))
+	handle(fmt.Sprintf("GET /v0/%s", methodSigningKey), makeHandler(func(query url.Values) (any, int, string) {
+		return s.getSigningKey(query)
+	}))
+	handle(fmt.Sprintf("GET /v0/%s", methodGetPublicKey), s.cachePublicKeys(makeHandler(s.getPublicKeyFormatted)))
+	handle(fmt.Sprintf("GET /v0/%s", methodGetPrivateKey), s.privateKeyHandler(s.waitForRelease(makeHandler(s.getPrivateKeyFormatted))))
+	getPublicKeys := makeHandler(func(query url.Values) (any, int, string) {
+		return s.getPublicKeys(query)
+	})
+	handle(fmt.Sprintf("GET /v0/%s", methodGetPublicKeys), getPublicKeys)
+	handle(fmt.Sprintf("POST /v0/%s", methodGetPublicKeys), jsonBodyAsQuery[*GetPublicKeysReq](getPublicKeys))
+	handle(fmt.Sprintf("GET /v0/%s", methodGetPrivateKeys), s.privateKeyHandler(makeHandler(func(query url.Values) (any, int, string) {
+		return s.getPrivateKeys(query)
+	})))
+	handle(fmt.Sprintf("GET /v0/%s", methodGetBundle), s.cachePublicKeys(makeHandler(s.getPublicKeyBundle)))
+	handle(fmt.Sprintf("GET /v0/%s", methodGetPublicKEM), s.cachePublicKeys(makeHandler(func(query url.Values) (any, int, string) {
+		return s.getPublicMLKEMKey(query)
+	})))
+	handle(fmt.Sprintf("GET /v0/%s", methodGetPrivateKEM), s.privateKeyHandler(s.waitForRelease(makeHandler(func(query url.Values) (any, int, string) {
+		return s.getPrivateMLKEMKey(query)
+	}))))
+	handle(fmt.Sprintf("GET /v0/%s", methodGetPublicSig), s.cachePublicKeys(makeHandler(func(query url.Values) (any, int, string) {
+		return s.getPublicSigningKey(query)
+	})))
+	handle(fmt.Sprintf("GET /v0/%s", methodGetPrivateSig), s.privateKeyHandler(s.waitForRelease(makeHandler(func(query url.Values) (any, int, string) {
+		return s.getPrivateSigningKey(query)
+	}))))
+	handle(fmt.Sprintf("GET /v0/%s", methodJWKS), makeHandler(func(query url.Values) (any, int, string) {
+		return s.getJWKS(query)
+	}))
+	handle(fmt.Sprintf("GET /v0/%s", methodUnlockCalendar), makeHandler(s.getUnlockCalendar))
+	if s.opts.TransparencyLog {
+		handle(fmt.Sprintf("GET /v0/%s", methodLogTreeHead), makeHandler(func(query url.Values) (any, int, string) {
+			return s.getLogTreeHead(query)
+		}))
+		handle(fmt.Sprintf("GET /v0/%s", methodLogInclusion), makeHandler(func(query url.Values) (any, int, string) {
+			return s.getLogInclusionProof(query)
+		}))
+		handle(fmt.Sprintf("GET /v0/%s", methodLogConsistency), makeHandler(func(query url.Values) (any, int, string) {
+			return s.getLogConsistencyProof(query)
+		}))
+	}
+	// Public-only servers have no secure clock, so they can't serve methods that depend on the current
+	// time.
+	if s.clock != nil {
+		handle(fmt.Sprintf("GET /v0/%s", methodNow), makeHandler(func(query url.Values) (any, int, string) {
+			return s.getNow(query)
+		}))
+		handle(fmt.Sprintf("GET /v0/%s", methodClockHealth), makeHandler(func(query url.Values) (any, int, string) {
+			return s.getClockHealth(query)
+		}))
+		handle(fmt.Sprintf("GET /v0/%s", methodAvailability), makeHandler(func(query url.Values) (any, int, string) {
+			return s.getAvailability(query)
+		}))
+		handle(fmt.Sprintf("GET /v0/%s", methodUnlockStream), http.HandlerFunc(s.unlockStream))
+		handle(fmt.Sprintf("GET /v0/%s", methodUnlockFeed), http.HandlerFunc(s.unlockFeed))
+	}
+	s.registerV1Handlers(handle)
+
+	if len(s.opts.AdminTokens) == 0 {
+		return
+	}
+	handleAdmin := func(pattern string, h http.Handler) {
+		mux.Handle(pattern, s.accessLog(s.requireAdmin(h)))
+	}
+	handleAdmin(fmt.Sprintf("POST /admin/v0/%s", methodRegisterEvent), makeHandler(func(query url.Values) (any, int, string) {
+		return s.registerEvent(query)
+	}))
+	handleAdmin(fmt.Sprintf("POST /admin/v0/%s", methodCreatePKI), makeHandler(func(query url.Values) (any, int, string) {
+		return s.createPKI(query)
+	}))
+	handleAdmin(fmt.Sprintf("POST /admin/v0/%s", methodExtendPKI), makeHandler(func(query url.Values) (any, int, string) {
+		return s.extendPKI(query)
+	}))
+	handleAdmin(fmt.Sprintf("POST /admin/v0/%s", methodFreeze), makeHandler(func(query url.Values) (any, int, string) {
+		return s.setFrozen(true)(query)
+	}))
+	handleAdmin(fmt.Sprintf("POST /admin/v0/%s", methodUnfreeze), makeHandler(func(query url.Values) (any, int, string) {
+		return s.setFrozen(false)(query)
+	}))
+	handleAdmin(fmt.Sprintf("POST /admin/v0/%s", methodRevoke), makeHandler(func(query url.Values) (any, int, string) {
+		return s.revoke(query)
+	}))
+	handleAdmin(fmt.Sprintf("POST /admin/v0/%s", methodHalt), makeHandler(func(query url.Values) (any, int, string) {
+		return s.setHalted(true)(query)
+	}))
+	handleAdmin(fmt.Sprintf("POST /admin/v0/%s", methodResume), makeHandler(func(query url.Values) (any, int, string) {
+		return s.setHalted(false)(query)
 	}))
-	mux.HandleFunc(fmt.Sprintf("GET /v0/%s", methodGetPrivateKey), makeHandler(func(query url.Values) (any, int, string) {
-		return s.getPrivateKey(query)
+	handleAdmin(fmt.Sprintf("POST /admin/v0/%s", methodSecretMetadata), makeHandler(func(query url.Values) (any, int, string) {
+		return s.listSecretMetadata(query)
 	}))
 }
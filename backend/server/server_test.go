@@ -1,28 +1,73 @@
 package server_test
 
 import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/mlkem"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
+	"encoding/pem"
+	"encoding/xml"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"math"
+	"math/big"
 	"net"
 	"net/http"
+	"net/http/httptest"
 	"net/url"
 	"os"
+	"path/filepath"
 	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/newgrp/timecapsule/cbor"
+	"github.com/newgrp/timecapsule/clock"
 	"github.com/newgrp/timecapsule/keys"
 	"github.com/newgrp/timecapsule/server"
+	"github.com/newgrp/timecapsule/timecapsulepb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
 // Long enough away from now to be definitively in the past or the future.
 const longEnough = 10 * time.Second
 
-// NTS server for testing. Cloudflare seems like it should usually be reachable.
-var ntsServers = []string{"time.cloudflare.com"}
+// Origin permitted by the test server's CORS policy.
+const allowedOrigin = "https://allowed.example"
+
+// Admin token for testing.
+const adminToken = "test-admin-token"
+
+// API key accepted by the API key test server.
+const apiKey = "test-api-key"
+
+// Secure time of the test servers. It is kept in step with the system clock, so that the tests
+// don't depend on reaching any time server.
+var testClock = clock.NewManualClock(time.Now())
 
 var (
 	minTime = time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
@@ -34,30 +79,158 @@ var (
 	timeTooLate  = time.Date(2151, time.April, 16, 0, 0, 0, 0, time.UTC)
 )
 
-var testPKI uuid.UUID
+var (
+	testServer *server.Server
+	testPKI    uuid.UUID
+	secretsDir string
+
+	// A second PKI served by testServer.
+	extraPKI = uuid.New()
+
+	// Handlers for a separate X25519 PKI.
+	x25519Mux = http.NewServeMux()
+	// Handlers for a separate PKI with strict private key rate limits.
+	rateLimitedMux = http.NewServeMux()
+	// Handlers for a separate PKI whose private keys require an API key.
+	apiKeyMux = http.NewServeMux()
+	// Handlers for a separate PKI with a kill switch file.
+	killSwitchMux = http.NewServeMux()
+	// Kill switch file of the server behind killSwitchMux.
+	killSwitchFile string
+)
 
 // Initialize the HTTP handlers once, since they apparently have to be global.
 func init() {
-	secretsDir, err := os.MkdirTemp(os.TempDir(), "*")
+	go func() {
+		for range time.Tick(10 * time.Millisecond) {
+			testClock.Set(time.Now())
+		}
+	}()
+
+	var err error
+	secretsDir, err = os.MkdirTemp(os.TempDir(), "*")
+	if err != nil {
+		log.Fatalf("Failed to create temporary directory for secrets: %+v", err)
+	}
+	extraDir, err := os.MkdirTemp(os.TempDir(), "*")
+	if err != nil {
+		log.Fatalf("Failed to create temporary directory for secrets: %+v", err)
+	}
+	pkiRootDir, err := os.MkdirTemp(os.TempDir(), "*")
 	if err != nil {
 		log.Fatalf("Failed to create temporary directory for secrets: %+v", err)
 	}
 
-	server, err := server.NewServer(server.Options{
-		NTSServers: ntsServers,
+	testServer, err = server.NewServer(server.Options{
+		TimeSource: testClock,
 		PKIOptions: keys.PKIOptions{
 			Name:    "Test Server",
 			MinTime: minTime,
 			MaxTime: maxTime,
 		},
 		SecretsDir: secretsDir,
+		ExtraPKIs: []server.PKIConfig{{
+			PKIOptions: keys.PKIOptions{
+				Name:    "Extra Test Server",
+				ID:      extraPKI,
+				MinTime: minTime,
+				MaxTime: maxTime,
+			},
+			SecretsDir: extraDir,
+		}},
+		PKIRootDir:  pkiRootDir,
+		AdminTokens: []string{adminToken},
+		CORS: server.CORSOptions{
+			AllowedOrigins: []string{allowedOrigin},
+			MaxAge:         time.Hour,
+		},
 	})
 	if err != nil {
 		log.Fatalf("Failed to initialize server: %+v", err)
 	}
 
-	testPKI = server.PKIID()
-	server.RegisterHandlers(http.DefaultServeMux)
+	testPKI = testServer.PKIID()
+	testServer.RegisterHandlers(http.DefaultServeMux)
+
+	x25519Dir, err := os.MkdirTemp(os.TempDir(), "*")
+	if err != nil {
+		log.Fatalf("Failed to create temporary directory for secrets: %+v", err)
+	}
+	x25519Server, err := server.NewServer(server.Options{
+		TimeSource: testClock,
+		PKIOptions: keys.PKIOptions{
+			Name:      "Test X25519 Server",
+			MinTime:   minTime,
+			MaxTime:   maxTime,
+			Algorithm: keys.AlgorithmX25519,
+		},
+		SecretsDir: x25519Dir,
+	})
+	if err != nil {
+		log.Fatalf("Failed to initialize X25519 server: %+v", err)
+	}
+	x25519Server.RegisterHandlers(x25519Mux)
+
+	rateLimitedDir, err := os.MkdirTemp(os.TempDir(), "*")
+	if err != nil {
+		log.Fatalf("Failed to create temporary directory for secrets: %+v", err)
+	}
+	rateLimitedServer, err := server.NewServer(server.Options{
+		TimeSource: testClock,
+		PKIOptions: keys.PKIOptions{
+			Name:    "Test Rate Limited Server",
+			MinTime: time.Now().Add(-24 * time.Hour),
+			MaxTime: time.Now().Add(24 * time.Hour),
+		},
+		SecretsDir: rateLimitedDir,
+		RateLimits: server.RateLimitOptions{
+			PrivateKeyPerClient: server.RateLimit{Rate: 0.001, Burst: 2},
+		},
+	})
+	if err != nil {
+		log.Fatalf("Failed to initialize rate limited server: %+v", err)
+	}
+	rateLimitedServer.RegisterHandlers(rateLimitedMux)
+
+	apiKeyDir, err := os.MkdirTemp(os.TempDir(), "*")
+	if err != nil {
+		log.Fatalf("Failed to create temporary directory for secrets: %+v", err)
+	}
+	apiKeyServer, err := server.NewServer(server.Options{
+		TimeSource: testClock,
+		PKIOptions: keys.PKIOptions{
+			Name:    "Test API Key Server",
+			MinTime: time.Now().Add(-24 * time.Hour),
+			MaxTime: time.Now().Add(24 * time.Hour),
+		},
+		SecretsDir: apiKeyDir,
+		APIKeys:    []string{apiKey},
+	})
+	if err != nil {
+		log.Fatalf("Failed to initialize API key server: %+v", err)
+	}
+	apiKeyServer.RegisterHandlers(apiKeyMux)
+
+	killSwitchDir, err := os.MkdirTemp(os.TempDir(), "*")
+	if err != nil {
+		log.Fatalf("Failed to create temporary directory for secrets: %+v", err)
+	}
+	killSwitchFile = filepath.Join(killSwitchDir, "halt")
+	killSwitchServer, err := server.NewServer(server.Options{
+		TimeSource: testClock,
+		PKIOptions: keys.PKIOptions{
+			Name:    "Test Kill Switch Server",
+			MinTime: time.Now().Add(-24 * time.Hour),
+			MaxTime: time.Now().Add(24 * time.Hour),
+		},
+		SecretsDir:     filepath.Join(killSwitchDir, "secrets"),
+		AdminTokens:    []string{adminToken},
+		KillSwitchFile: killSwitchFile,
+	})
+	if err != nil {
+		log.Fatalf("Failed to initialize kill switch server: %+v", err)
+	}
+	killSwitchServer.RegisterHandlers(killSwitchMux)
 }
 
 // Construct an HTTP URL with the given parameters.
@@ -73,7 +246,19 @@ func createURL(host string, path string, query url.Values) string {
 
 // Wrapper around http.Get that automatically parses the body.
 func httpGet(t *testing.T, url string) (status int, body string, err error) {
-	resp, err := http.Get(url)
+	return httpGetWithHeaders(t, url, nil)
+}
+
+// As httpGet, but sends the given request headers.
+func httpGetWithHeaders(t *testing.T, url string, header http.Header) (status int, body string, err error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return 0, "", err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
 		return 0, "", err
 	}
@@ -88,9 +273,38 @@ func httpGet(t *testing.T, url string) (status int, body string, err error) {
 	return resp.StatusCode, string(b), nil
 }
 
+// Sends an admin POST request with the given bearer token and returns the response.
+func httpPostAdmin(t *testing.T, url string, token string) (status int, body string, err error) {
+	req, err := http.NewRequest(http.MethodPost, url, nil)
+	if err != nil {
+		return 0, "", err
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return 0, "", err
+	}
+	defer resp.Body.Close()
+
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return resp.StatusCode, "", err
+	}
+
+	t.Logf("POST %s returned %s: %s", url, resp.Status, string(b))
+	return resp.StatusCode, string(b), nil
+}
+
 // As httpGet, but returns an error if the status isn't 200 OK.
 func httpGetOK[T any](t *testing.T, url string) (*T, error) {
-	status, body, err := httpGet(t, url)
+	return httpGetOKWithHeaders[T](t, url, nil)
+}
+
+// As httpGetOK, but sends the given request headers.
+func httpGetOKWithHeaders[T any](t *testing.T, url string, header http.Header) (*T, error) {
+	status, body, err := httpGetWithHeaders(t, url, header)
 	if err != nil {
 		return nil, err
 	}
@@ -111,13 +325,18 @@ func httpGetOK[T any](t *testing.T, url string) (*T, error) {
 //
 // The server will automatically forcibly shut down when the test finishes.
 func setupServer(t *testing.T) string {
+	return setupServerWithHandler(t, nil)
+}
+
+// As setupServer, but serves the given handler instead of http.DefaultServeMux.
+func setupServerWithHandler(t *testing.T, handler http.Handler) string {
 	listener, err := net.Listen("tcp", ":0")
 	if err != nil {
 		t.Fatalf("Failed to listen on any port: %+v", err)
 	}
 	addr := listener.Addr().String()
 
-	httpServer := http.Server{Addr: addr}
+	httpServer := http.Server{Addr: addr, Handler: handler}
 	go httpServer.Serve(listener)
 	t.Cleanup(func() { httpServer.Close() })
 
@@ -141,6 +360,12 @@ func TestGetPublicKey(t *testing.T) {
 	if err != nil {
 		t.Errorf("get_public_key returned invalid key: %+v", err)
 	}
+	if sum := sha256.Sum256(resp.SPKI); resp.Fingerprint != hex.EncodeToString(sum[:]) {
+		t.Errorf("get_public_key returned fingerprint %s, want %x", resp.Fingerprint, sum)
+	}
+	if !strings.HasPrefix(resp.SigningKeyFingerprint, resp.SigningKeyID) {
+		t.Errorf("Signing key fingerprint %s doesn't match signing key ID %s", resp.SigningKeyFingerprint, resp.SigningKeyID)
+	}
 }
 
 func TestGetPublicKeyRFC3339(t *testing.T) {
@@ -161,6 +386,49 @@ func TestGetPublicKeyRFC3339(t *testing.T) {
 	}
 }
 
+func TestGetPublicKeyRelative(t *testing.T) {
+	addr := setupServer(t)
+	before := time.Now().Add(72 * time.Hour).Truncate(time.Second)
+	resp, err := httpGetOK[server.GetPublicKeyResp](t, createURL(addr, "/v0/get_public_key", url.Values{
+		"in": []string{"72h"},
+	}))
+	if err != nil {
+		t.Fatalf("Failed to get public key for 72h from now: %+v", err)
+	}
+	after := time.Now().Add(72 * time.Hour)
+
+	got, err := time.Parse(time.RFC3339, resp.Time)
+	if err != nil {
+		t.Fatalf("get_public_key returned invalid time %q: %+v", resp.Time, err)
+	}
+	if got.Before(before.Add(-longEnough)) || got.After(after.Add(longEnough)) {
+		t.Errorf("get_public_key resolved 72h from now to %s, want about %s", resp.Time, before.Format(time.RFC3339))
+	}
+
+	abs, err := httpGetOK[server.GetPublicKeyResp](t, createURL(addr, "/v0/get_public_key", url.Values{
+		"time": []string{resp.Time},
+	}))
+	if err != nil {
+		t.Fatalf("Failed to get public key for %s: %+v", resp.Time, err)
+	}
+	if !bytes.Equal(resp.SPKI, abs.SPKI) {
+		t.Errorf("Public key for relative time differs from public key for resolved time %s", resp.Time)
+	}
+
+	for _, query := range []url.Values{
+		{"in": []string{"soon"}},
+		{"in": []string{"72h"}, "time": []string{resp.Time}},
+	} {
+		status, _, err := httpGet(t, createURL(addr, "/v0/get_public_key", query))
+		if err != nil {
+			t.Fatalf("Network error in get_public_key: %+v", err)
+		}
+		if status != http.StatusBadRequest {
+			t.Errorf("get_public_key with %v returned %s, want %s", query, http.StatusText(status), http.StatusText(http.StatusBadRequest))
+		}
+	}
+}
+
 func TestGetPublicKeyWithPKIID(t *testing.T) {
 	addr := setupServer(t)
 	target := time.Now().Add(-longEnough)
@@ -237,9 +505,16 @@ func TestGetPrivateKey(t *testing.T) {
 		t.Fatalf("Failed to get private key for %s: %+v", target.Format(time.RFC3339), err)
 	}
 
-	_, err = keys.ParseECDHPrivateKeyAsPKCS8DER(resp.PKCS8)
+	priv, err := keys.ParseECDHPrivateKeyAsPKCS8DER(resp.PKCS8)
 	if err != nil {
-		t.Errorf("get_private_key returned invalid key: %+v", err)
+		t.Fatalf("get_private_key returned invalid key: %+v", err)
+	}
+	spki, err := x509.MarshalPKIXPublicKey(priv.PublicKey())
+	if err != nil {
+		t.Fatalf("Failed to marshal public key: %+v", err)
+	}
+	if resp.Fingerprint != keys.Fingerprint(spki) {
+		t.Errorf("get_private_key returned fingerprint %s, want the public key's %s", resp.Fingerprint, keys.Fingerprint(spki))
 	}
 }
 
@@ -373,3 +648,3249 @@ func TestGetKeyPair(t *testing.T) {
 		t.Errorf("Private key for %s does not correspond to public key for %s", target.Format(time.RFC3339), target.Format(time.RFC3339))
 	}
 }
+
+func TestDerivationParams(t *testing.T) {
+	addr := setupServer(t)
+	target := time.Now().Add(-longEnough)
+	pubUrl := createURL(addr, "/v0/get_public_key", url.Values{
+		"time": []string{fmt.Sprint(target.Unix())},
+	})
+	privUrl := createURL(addr, "/v0/get_private_key", url.Values{
+		"time": []string{fmt.Sprint(target.Unix())},
+	})
+
+	pubResp, err := httpGetOK[server.GetPublicKeyResp](t, pubUrl)
+	if err != nil {
+		t.Fatalf("Failed to get public key for %s: %+v", target.Format(time.RFC3339), err)
+	}
+	if pubResp.DerivationVersion != 2 || pubResp.Algorithm != "P-256" || pubResp.Interval != 3600 {
+		t.Errorf("get_public_key returned wrong derivation parameters: got (%d, %s, %d), want (2, P-256, 3600)", pubResp.DerivationVersion, pubResp.Algorithm, pubResp.Interval)
+	}
+
+	privResp, err := httpGetOK[server.GetPrivateKeyResp](t, privUrl)
+	if err != nil {
+		t.Fatalf("Failed to get private key for %s: %+v", target.Format(time.RFC3339), err)
+	}
+	if privResp.DerivationVersion != 2 || privResp.Algorithm != "P-256" || privResp.Interval != 3600 {
+		t.Errorf("get_private_key returned wrong derivation parameters: got (%d, %s, %d), want (2, P-256, 3600)", privResp.DerivationVersion, privResp.Algorithm, privResp.Interval)
+	}
+}
+
+func TestGetPrivateKeyWrapped(t *testing.T) {
+	client, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate client key: %+v", err)
+	}
+	spki, err := x509.MarshalPKIXPublicKey(client.PublicKey())
+	if err != nil {
+		t.Fatalf("Failed to marshal client key: %+v", err)
+	}
+
+	addr := setupServer(t)
+	target := time.Now().Add(-longEnough)
+	pubUrl := createURL(addr, "/v0/get_public_key", url.Values{
+		"time": []string{fmt.Sprint(target.Unix())},
+	})
+	privUrl := createURL(addr, "/v0/get_private_key", url.Values{
+		"time":    []string{fmt.Sprint(target.Unix())},
+		"wrap_to": []string{base64.RawURLEncoding.EncodeToString(spki)},
+	})
+
+	pubResp, err := httpGetOK[server.GetPublicKeyResp](t, pubUrl)
+	if err != nil {
+		t.Fatalf("Failed to get public key for %s: %+v", target.Format(time.RFC3339), err)
+	}
+	pub, err := keys.ParseECDHPublicKeyAsSPKIDER(pubResp.SPKI)
+	if err != nil {
+		t.Fatalf("get_public_key returned invalid key: %+v", err)
+	}
+
+	privResp, err := httpGetOK[server.GetPrivateKeyResp](t, privUrl)
+	if err != nil {
+		t.Fatalf("Failed to get private key for %s: %+v", target.Format(time.RFC3339), err)
+	}
+	if len(privResp.PKCS8) != 0 {
+		t.Errorf("get_private_key returned an unwrapped private key")
+	}
+	der, err := keys.OpenWrapped(client, privResp.Enc, privResp.Sealed)
+	if err != nil {
+		t.Fatalf("Failed to unwrap private key: %+v", err)
+	}
+	priv, err := keys.ParseECDHPrivateKeyAsPKCS8DER(der)
+	if err != nil {
+		t.Fatalf("get_private_key returned invalid wrapped key: %+v", err)
+	}
+
+	if !priv.PublicKey().Equal(pub) {
+		t.Errorf("Wrapped private key for %s does not correspond to public key for %s", target.Format(time.RFC3339), target.Format(time.RFC3339))
+	}
+}
+
+func TestConfigSummary(t *testing.T) {
+	summary := testServer.ConfigSummary()
+	t.Logf("Configuration summary: %s", summary)
+
+	for _, want := range []string{
+		`pki_name="Test Server"`,
+		fmt.Sprintf("pki_id=%s", testPKI),
+		fmt.Sprintf("min_time=%s", minTime.Format(time.RFC3339)),
+		fmt.Sprintf("max_time=%s", maxTime.Format(time.RFC3339)),
+		"interval=1h0m0s",
+		"algorithm=P-256",
+		"derivation_version=2",
+		fmt.Sprintf("secrets_dir=%q", secretsDir),
+		`nts_servers=""`,
+		"max_wait=1m0s",
+	} {
+		if !strings.Contains(summary, want) {
+			t.Errorf("Configuration summary does not contain %s", want)
+		}
+	}
+}
+
+func TestGetKeysForEvent(t *testing.T) {
+	event := fmt.Sprintf("launch-%s", uuid.NewString())
+
+	addr := setupServer(t)
+	target := time.Now().Add(-longEnough)
+	registerUrl := createURL(addr, "/admin/v0/register_event", url.Values{
+		"name": []string{event},
+		"time": []string{fmt.Sprint(target.Unix())},
+	})
+	byTimeUrl := createURL(addr, "/v0/get_public_key", url.Values{
+		"time": []string{fmt.Sprint(target.Unix())},
+	})
+	byEventUrl := createURL(addr, "/v0/get_public_key", url.Values{
+		"event": []string{event},
+	})
+	privUrl := createURL(addr, "/v0/get_private_key", url.Values{
+		"event": []string{event},
+	})
+
+	status, _, err := httpPostAdmin(t, registerUrl, adminToken)
+	if err != nil {
+		t.Fatalf("Network error in register_event: %+v", err)
+	}
+	if status != http.StatusOK {
+		t.Fatalf("Failed to register event %s: %s", event, http.StatusText(status))
+	}
+
+	byTime, err := httpGetOK[server.GetPublicKeyResp](t, byTimeUrl)
+	if err != nil {
+		t.Fatalf("Failed to get public key for %s: %+v", target.Format(time.RFC3339), err)
+	}
+	byEvent, err := httpGetOK[server.GetPublicKeyResp](t, byEventUrl)
+	if err != nil {
+		t.Fatalf("Failed to get public key for event %s: %+v", event, err)
+	}
+	pub, err := keys.ParseECDHPublicKeyAsSPKIDER(byEvent.SPKI)
+	if err != nil {
+		t.Fatalf("get_public_key returned invalid key: %+v", err)
+	}
+	if !bytes.Equal(byTime.SPKI, byEvent.SPKI) {
+		t.Errorf("Public key for event %s does not match public key for %s", event, target.Format(time.RFC3339))
+	}
+
+	privResp, err := httpGetOK[server.GetPrivateKeyResp](t, privUrl)
+	if err != nil {
+		t.Fatalf("Failed to get private key for event %s: %+v", event, err)
+	}
+	priv, err := keys.ParseECDHPrivateKeyAsPKCS8DER(privResp.PKCS8)
+	if err != nil {
+		t.Fatalf("get_private_key returned invalid key: %+v", err)
+	}
+	if !priv.PublicKey().Equal(pub) {
+		t.Errorf("Private key for event %s does not correspond to public key", event)
+	}
+}
+
+func TestGetPublicKeyUnknownEvent(t *testing.T) {
+	addr := setupServer(t)
+	url := createURL(addr, "/v0/get_public_key", url.Values{
+		"event": []string{fmt.Sprintf("unknown-%s", uuid.NewString())},
+	})
+
+	status, _, err := httpGet(t, url)
+	if err != nil {
+		t.Fatalf("Network error in get_public_key: %+v", err)
+	}
+	if status != http.StatusNotFound {
+		t.Errorf("get_public_key returned %s for an unknown event, want %s", http.StatusText(status), http.StatusText(http.StatusNotFound))
+	}
+}
+
+func TestRegisterEventUnauthorized(t *testing.T) {
+	addr := setupServer(t)
+	url := createURL(addr, "/admin/v0/register_event", url.Values{
+		"name": []string{fmt.Sprintf("unauthorized-%s", uuid.NewString())},
+		"time": []string{fmt.Sprint(time.Now().Unix())},
+	})
+
+	for _, token := range []string{"", "wrong-token"} {
+		status, _, err := httpPostAdmin(t, url, token)
+		if err != nil {
+			t.Fatalf("Network error in register_event: %+v", err)
+		}
+		if status != http.StatusUnauthorized {
+			t.Errorf("register_event returned %s with token %q, want %s", http.StatusText(status), token, http.StatusText(http.StatusUnauthorized))
+		}
+	}
+}
+
+func TestEmptyAdminToken(t *testing.T) {
+	_, err := server.NewServer(server.Options{
+		TimeSource: testClock,
+		PKIOptions: keys.PKIOptions{
+			Name:    "Test Empty Admin Token Server",
+			MinTime: time.Now().Add(-24 * time.Hour),
+			MaxTime: time.Now().Add(24 * time.Hour),
+		},
+		SecretsDir:  t.TempDir(),
+		AdminTokens: []string{adminToken, ""},
+	})
+	if err == nil {
+		t.Errorf("NewServer accepted an empty admin token")
+	}
+}
+
+func TestReadyz(t *testing.T) {
+	addr := setupServer(t)
+	url := createURL(addr, "/readyz", nil)
+
+	status, _, err := httpGet(t, url)
+	if err != nil {
+		t.Fatalf("Network error in readyz: %+v", err)
+	}
+	if status != http.StatusOK {
+		t.Errorf("readyz returned %s after eager generation, want %s", http.StatusText(status), http.StatusText(http.StatusOK))
+	}
+}
+
+// Secret store whose writes block until its gate is opened.
+type gatedStore struct {
+	keys.SecretStore
+	gate chan struct{}
+}
+
+func (s *gatedStore) Put(bucket time.Time, secret []byte) error {
+	<-s.gate
+	return s.SecretStore.Put(bucket, secret)
+}
+
+func TestBackgroundGeneration(t *testing.T) {
+	store := &gatedStore{SecretStore: keys.NewMemStore(nil), gate: make(chan struct{})}
+	s, err := server.NewServer(server.Options{
+		TimeSource: testClock,
+		PKIOptions: keys.PKIOptions{
+			Name:                 "Test Background Generation Server",
+			MinTime:              time.Now().Add(-2 * time.Hour),
+			MaxTime:              time.Now().Add(2 * time.Hour),
+			SecretStore:          store,
+			BackgroundGeneration: true,
+		},
+		SecretsDir: t.TempDir(),
+	})
+	if err != nil {
+		t.Fatalf("Failed to initialize server: %+v", err)
+	}
+	t.Cleanup(s.Close)
+	mux := http.NewServeMux()
+	s.RegisterHandlers(mux)
+	addr := setupServerWithHandler(t, mux)
+	readyURL := createURL(addr, "/readyz", nil)
+	pubURL := createURL(addr, "/v0/get_public_key", url.Values{
+		"time": []string{fmt.Sprint(time.Now().Unix())},
+	})
+
+	// Generation is stuck on the first secret, so no key is past the frontier.
+	for _, u := range []string{readyURL, pubURL} {
+		resp, err := http.Get(u)
+		if err != nil {
+			t.Fatalf("Network error in %s: %+v", u, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusServiceUnavailable {
+			t.Errorf("%s returned %s during background generation, want %s", u, http.StatusText(resp.StatusCode), http.StatusText(http.StatusServiceUnavailable))
+		}
+	}
+
+	close(store.gate)
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		status, _, err := httpGet(t, readyURL)
+		if err != nil {
+			t.Fatalf("Network error in readyz: %+v", err)
+		}
+		if status == http.StatusOK {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("readyz returned %s 10s after generation was unblocked", http.StatusText(status))
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	if _, err := httpGetOK[server.GetPublicKeyResp](t, pubURL); err != nil {
+		t.Errorf("Failed to get public key after background generation: %+v", err)
+	}
+}
+
+func TestGetKeysAtBoundaries(t *testing.T) {
+	addr := setupServer(t)
+
+	for _, target := range []time.Time{minTime, maxTime} {
+		url := createURL(addr, "/v0/get_public_key", url.Values{
+			"time": []string{fmt.Sprint(target.Unix())},
+		})
+		if _, err := httpGetOK[server.GetPublicKeyResp](t, url); err != nil {
+			t.Errorf("Failed to get public key for %s: %+v", target.Format(time.RFC3339), err)
+		}
+	}
+
+	// Only the minimum time is in the past.
+	url := createURL(addr, "/v0/get_private_key", url.Values{
+		"time": []string{fmt.Sprint(minTime.Unix())},
+	})
+	if _, err := httpGetOK[server.GetPrivateKeyResp](t, url); err != nil {
+		t.Errorf("Failed to get private key for %s: %+v", minTime.Format(time.RFC3339), err)
+	}
+}
+
+func TestGetPublicKeyJustOutOfRange(t *testing.T) {
+	addr := setupServer(t)
+
+	for _, target := range []time.Time{minTime.Add(-time.Second), maxTime.Add(time.Second)} {
+		url := createURL(addr, "/v0/get_public_key", url.Values{
+			"time": []string{fmt.Sprint(target.Unix())},
+		})
+		status, _, err := httpGet(t, url)
+		if err != nil {
+			t.Fatalf("Network error in get_public_key: %+v", err)
+		}
+		if status != http.StatusBadRequest {
+			t.Errorf("Public key was provided for %s, but it shouldn't have been", target.Format(time.RFC3339))
+		}
+	}
+}
+
+func TestGetKeyPairX25519(t *testing.T) {
+	addr := setupServerWithHandler(t, x25519Mux)
+	target := time.Now().Add(-longEnough)
+	pubUrl := createURL(addr, "/v0/get_public_key", url.Values{
+		"time": []string{fmt.Sprint(target.Unix())},
+	})
+	privUrl := createURL(addr, "/v0/get_private_key", url.Values{
+		"time": []string{fmt.Sprint(target.Unix())},
+	})
+
+	pubResp, err := httpGetOK[server.GetPublicKeyResp](t, pubUrl)
+	if err != nil {
+		t.Fatalf("Failed to get public key for %s: %+v", target.Format(time.RFC3339), err)
+	}
+	if pubResp.Algorithm != "X25519" {
+		t.Errorf("get_public_key returned wrong algorithm: got %s, want X25519", pubResp.Algorithm)
+	}
+	pub, err := keys.ParseECDHPublicKeyAsSPKIDER(pubResp.SPKI)
+	if err != nil {
+		t.Fatalf("get_public_key returned invalid key: %+v", err)
+	}
+	if pub.Curve() != ecdh.X25519() {
+		t.Errorf("get_public_key returned a key for the wrong curve: %v", pub.Curve())
+	}
+
+	privResp, err := httpGetOK[server.GetPrivateKeyResp](t, privUrl)
+	if err != nil {
+		t.Fatalf("Failed to get private key for %s: %+v", target.Format(time.RFC3339), err)
+	}
+	priv, err := keys.ParseECDHPrivateKeyAsPKCS8DER(privResp.PKCS8)
+	if err != nil {
+		t.Fatalf("get_private_key returned invalid key: %+v", err)
+	}
+
+	if !priv.PublicKey().Equal(pub) {
+		t.Errorf("Private key for %s does not correspond to public key for %s", target.Format(time.RFC3339), target.Format(time.RFC3339))
+	}
+}
+
+func TestGetPublicKeyFromHeaders(t *testing.T) {
+	addr := setupServer(t)
+	target := time.Now().Add(-longEnough)
+	queryUrl := createURL(addr, "/v0/get_public_key", url.Values{
+		"pki_id": []string{testPKI.String()},
+		"time":   []string{fmt.Sprint(target.Unix())},
+	})
+	headerUrl := createURL(addr, "/v0/get_public_key", nil)
+	header := http.Header{
+		"X-Timecapsule-Pki-Id": []string{testPKI.String()},
+		"X-Timecapsule-Time":   []string{fmt.Sprint(target.Unix())},
+	}
+
+	byQuery, err := httpGetOK[server.GetPublicKeyResp](t, queryUrl)
+	if err != nil {
+		t.Fatalf("Failed to get public key for %s: %+v", target.Format(time.RFC3339), err)
+	}
+	byHeader, err := httpGetOKWithHeaders[server.GetPublicKeyResp](t, headerUrl, header)
+	if err != nil {
+		t.Fatalf("Failed to get public key for %s from headers: %+v", target.Format(time.RFC3339), err)
+	}
+	if !bytes.Equal(byQuery.SPKI, byHeader.SPKI) {
+		t.Errorf("Public key from headers does not match public key from query parameters")
+	}
+}
+
+func TestQueryTakesPrecedenceOverHeaders(t *testing.T) {
+	addr := setupServer(t)
+	target := time.Now().Add(-longEnough)
+	url := createURL(addr, "/v0/get_public_key", url.Values{
+		"time": []string{fmt.Sprint(target.Unix())},
+	})
+	header := http.Header{
+		"X-Timecapsule-Time": []string{"not a time"},
+	}
+
+	if _, err := httpGetOKWithHeaders[server.GetPublicKeyResp](t, url, header); err != nil {
+		t.Errorf("Failed to get public key for %s: %+v", target.Format(time.RFC3339), err)
+	}
+}
+
+func TestGetPrivateKeyWrongPKIIDFromHeaders(t *testing.T) {
+	addr := setupServer(t)
+	target := time.Now().Add(-longEnough)
+	url := createURL(addr, "/v0/get_private_key", nil)
+	header := http.Header{
+		"X-Timecapsule-Pki-Id": []string{uuid.NewString()},
+		"X-Timecapsule-Time":   []string{fmt.Sprint(target.Unix())},
+	}
+
+	status, _, err := httpGetWithHeaders(t, url, header)
+	if err != nil {
+		t.Fatalf("Network error in get_private_key: %+v", err)
+	}
+	if status != http.StatusNotFound {
+		t.Errorf("get_private_key returned %s for the wrong PKI ID, want %s", http.StatusText(status), http.StatusText(http.StatusNotFound))
+	}
+}
+
+// Sends a POST request with a JSON body and decodes a JSON response, returning an error if the
+// status isn't 200 OK.
+func httpPostJSONOK[T any](t *testing.T, url string, reqBody any) (*T, error) {
+	status, body, err := httpPostJSON(t, url, reqBody)
+	if err != nil {
+		return nil, err
+	}
+	if status != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s", http.StatusText(status), string(body))
+	}
+
+	ret := new(T)
+	d := json.NewDecoder(bytes.NewReader(body))
+	d.DisallowUnknownFields()
+	if err = d.Decode(ret); err != nil {
+		return nil, fmt.Errorf("failed to decode body as %T: %w", ret, err)
+	}
+	return ret, nil
+}
+
+// Sends a POST request with the given value as a JSON body and returns the response.
+func httpPostJSON(t *testing.T, url string, reqBody any) (status int, body []byte, err error) {
+	b, err := json.Marshal(reqBody)
+	if err != nil {
+		return 0, nil, err
+	}
+	resp, err := http.Post(url, "application/json", bytes.NewReader(b))
+	if err != nil {
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err = io.ReadAll(resp.Body)
+	if err != nil {
+		return resp.StatusCode, nil, err
+	}
+	t.Logf("POST %s returned %s: %s", url, resp.Status, string(body))
+	return resp.StatusCode, body, nil
+}
+
+func TestGetPublicKeys(t *testing.T) {
+	addr := setupServer(t)
+	targets := []time.Time{
+		time.Now().Add(-longEnough),
+		time.Now().Add(24 * time.Hour),
+		time.Now().Add(365 * 24 * time.Hour),
+	}
+	var times []string
+	for _, target := range targets {
+		times = append(times, fmt.Sprint(target.Unix()))
+	}
+	getUrl := createURL(addr, "/v0/get_public_keys", url.Values{
+		"time": times,
+	})
+	postUrl := createURL(addr, "/v0/get_public_keys", nil)
+
+	getResp, err := httpGetOK[server.GetPublicKeysResp](t, getUrl)
+	if err != nil {
+		t.Fatalf("Failed to get public keys: %+v", err)
+	}
+	postResp, err := httpPostJSONOK[server.GetPublicKeysResp](t, postUrl, server.GetPublicKeysReq{
+		PKIID: testPKI.String(),
+		Times: times,
+	})
+	if err != nil {
+		t.Fatalf("Failed to get public keys with POST: %+v", err)
+	}
+
+	for _, resp := range []*server.GetPublicKeysResp{getResp, postResp} {
+		if len(resp.Keys) != len(targets) {
+			t.Fatalf("get_public_keys returned %d keys, want %d", len(resp.Keys), len(targets))
+		}
+		for i, target := range targets {
+			url := createURL(addr, "/v0/get_public_key", url.Values{
+				"time": []string{fmt.Sprint(target.Unix())},
+			})
+			single, err := httpGetOK[server.GetPublicKeyResp](t, url)
+			if err != nil {
+				t.Fatalf("Failed to get public key for %s: %+v", target.Format(time.RFC3339), err)
+			}
+			if !bytes.Equal(resp.Keys[i].SPKI, single.SPKI) {
+				t.Errorf("Batch public key for %s does not match get_public_key", target.Format(time.RFC3339))
+			}
+		}
+	}
+}
+
+func TestGetPublicKeysTimeOutOfRange(t *testing.T) {
+	addr := setupServer(t)
+	url := createURL(addr, "/v0/get_public_keys", url.Values{
+		"time": []string{fmt.Sprint(time.Now().Unix()), fmt.Sprint(timeTooLate.Unix())},
+	})
+
+	status, _, err := httpGet(t, url)
+	if err != nil {
+		t.Fatalf("Network error in get_public_keys: %+v", err)
+	}
+	if status != http.StatusBadRequest {
+		t.Errorf("Public keys were provided including %s, but they shouldn't have been", timeTooLate.Format(time.RFC3339))
+	}
+}
+
+func TestGetPrivateKeys(t *testing.T) {
+	addr := setupServer(t)
+	end := time.Now().Add(-longEnough)
+	start := end.Add(-3 * time.Hour)
+	privUrl := createURL(addr, "/v0/get_private_keys", url.Values{
+		"start": []string{fmt.Sprint(start.Unix())},
+		"end":   []string{fmt.Sprint(end.Unix())},
+	})
+
+	resp, err := httpGetOK[server.GetPrivateKeysResp](t, privUrl)
+	if err != nil {
+		t.Fatalf("Failed to get private keys: %+v", err)
+	}
+	if len(resp.Keys) != 4 {
+		t.Fatalf("get_private_keys returned %d keys, want 4", len(resp.Keys))
+	}
+
+	stepUrl := createURL(addr, "/v0/get_private_keys", url.Values{
+		"start": []string{fmt.Sprint(start.Unix())},
+		"end":   []string{fmt.Sprint(end.Unix())},
+		"step":  []string{"30m"},
+	})
+	stepResp, err := httpGetOK[server.GetPrivateKeysResp](t, stepUrl)
+	if err != nil {
+		t.Fatalf("Failed to get private keys with step: %+v", err)
+	}
+	if len(stepResp.Keys) != 7 {
+		t.Errorf("get_private_keys returned %d keys with 30m step, want 7", len(stepResp.Keys))
+	}
+
+	for i, entry := range resp.Keys {
+		entryTime, err := time.Parse(time.RFC3339, entry.Time)
+		if err != nil {
+			t.Fatalf("get_private_keys returned invalid time: %+v", err)
+		}
+		if want := start.Add(time.Duration(i) * time.Hour).Truncate(time.Second); !entryTime.Equal(want) {
+			t.Errorf("Key %d is for %s, want %s", i, entryTime.Format(time.RFC3339), want.Format(time.RFC3339))
+		}
+		priv, err := keys.ParseECDHPrivateKeyAsPKCS8DER(entry.PKCS8)
+		if err != nil {
+			t.Fatalf("get_private_keys returned invalid key: %+v", err)
+		}
+
+		pubUrl := createURL(addr, "/v0/get_public_key", url.Values{
+			"time": []string{fmt.Sprint(entryTime.Unix())},
+		})
+		pubResp, err := httpGetOK[server.GetPublicKeyResp](t, pubUrl)
+		if err != nil {
+			t.Fatalf("Failed to get public key for %s: %+v", entry.Time, err)
+		}
+		pub, err := keys.ParseECDHPublicKeyAsSPKIDER(pubResp.SPKI)
+		if err != nil {
+			t.Fatalf("get_public_key returned invalid key: %+v", err)
+		}
+		if !priv.PublicKey().Equal(pub) {
+			t.Errorf("Private key for %s does not correspond to public key", entry.Time)
+		}
+	}
+}
+
+func TestGetPrivateKeysForbidden(t *testing.T) {
+	addr := setupServer(t)
+	start := time.Now().Add(-time.Hour)
+	end := time.Now().Add(longEnough)
+	url := createURL(addr, "/v0/get_private_keys", url.Values{
+		"start": []string{fmt.Sprint(start.Unix())},
+		"end":   []string{fmt.Sprint(end.Unix())},
+	})
+
+	status, _, err := httpGet(t, url)
+	if err != nil {
+		t.Fatalf("Network error in get_private_keys: %+v", err)
+	}
+	if status != http.StatusForbidden {
+		t.Errorf("Private keys were provided through %s, but they shouldn't have been", end.Format(time.RFC3339))
+	}
+}
+
+func TestGetInfo(t *testing.T) {
+	addr := setupServer(t)
+	url := createURL(addr, "/v0/info", nil)
+
+	resp, err := httpGetOK[server.GetInfoResp](t, url)
+	if err != nil {
+		t.Fatalf("Failed to get server info: %+v", err)
+	}
+	want := server.GetInfoResp{
+		PKIName:    "Test Server",
+		PKIID:      testPKI.String(),
+		MinTime:    minTime.Format(time.RFC3339),
+		MaxTime:    maxTime.Format(time.RFC3339),
+		APIVersion: "v0",
+		DerivationInfo: server.DerivationInfo{
+			DerivationVersion: 2,
+			Algorithm:         "P-256",
+			Interval:          3600,
+		},
+	}
+	if *resp != want {
+		t.Errorf("info returned wrong server info: got %+v, want %+v", *resp, want)
+	}
+}
+
+func TestGetNow(t *testing.T) {
+	addr := setupServer(t)
+	url := createURL(addr, "/v0/now", nil)
+
+	resp, err := httpGetOK[server.GetNowResp](t, url)
+	if err != nil {
+		t.Fatalf("Failed to get current time: %+v", err)
+	}
+	now, err := time.Parse(time.RFC3339Nano, resp.Time)
+	if err != nil {
+		t.Fatalf("now returned invalid time: %+v", err)
+	}
+	if now.Unix() != resp.Unix {
+		t.Errorf("now returned inconsistent times: %s and %d", resp.Time, resp.Unix)
+	}
+	if d := time.Since(now).Abs(); d > time.Minute {
+		t.Errorf("now is off from the system clock by %v", d)
+	}
+	if resp.Staleness < 0 {
+		t.Errorf("now returned negative staleness %v", resp.Staleness)
+	}
+	if math.Abs(resp.Divergence) > time.Minute.Seconds() {
+		t.Errorf("now returned divergence of %vs from the system clock", resp.Divergence)
+	}
+	earliest, err := time.Parse(time.RFC3339Nano, resp.Earliest)
+	if err != nil {
+		t.Fatalf("now returned invalid earliest time: %+v", err)
+	}
+	latest, err := time.Parse(time.RFC3339Nano, resp.Latest)
+	if err != nil {
+		t.Fatalf("now returned invalid latest time: %+v", err)
+	}
+	// The time and interval are read separately, so allow for the time between the readings.
+	if now.Before(earliest.Add(-time.Second)) || now.After(latest.Add(time.Second)) {
+		t.Errorf("now returned %s outside its interval [%s, %s]", resp.Time, resp.Earliest, resp.Latest)
+	}
+}
+
+func TestGetClockHealth(t *testing.T) {
+	addr := setupServer(t)
+
+	resp, err := httpGetOK[server.GetClockHealthResp](t, createURL(addr, "/v0/clock_health", nil))
+	if err != nil {
+		t.Fatalf("Failed to get clock health: %+v", err)
+	}
+	if !resp.Synced || resp.ConsecutiveFailures != 0 {
+		t.Errorf("clock_health reported an unhealthy clock: %+v", *resp)
+	}
+	lastPoll, err := time.Parse(time.RFC3339Nano, resp.LastPoll)
+	if err != nil {
+		t.Fatalf("clock_health returned invalid last poll time: %+v", err)
+	}
+	if resp.Staleness == nil {
+		t.Fatalf("clock_health returned no staleness for a synchronized clock")
+	}
+	if d := time.Since(lastPoll).Seconds(); math.Abs(d-*resp.Staleness) > 1 {
+		t.Errorf("clock_health reported the last poll %vs ago with staleness %vs", d, *resp.Staleness)
+	}
+	if *resp.Staleness < 0 || *resp.Staleness >= resp.StaleThreshold {
+		t.Errorf("clock_health returned staleness %vs with threshold %vs", *resp.Staleness, resp.StaleThreshold)
+	}
+}
+
+func TestGetClockHealthUnsynced(t *testing.T) {
+	clk := clock.NewManualClock(time.Now())
+	clk.SetError(errors.New("clock has not yet obtained the time"))
+	s, err := server.NewServer(server.Options{
+		TimeSource: clk,
+		PKIOptions: keys.PKIOptions{
+			Name:    "Test Unsynced Clock Server",
+			MinTime: time.Now().Add(-24 * time.Hour),
+			MaxTime: time.Now().Add(24 * time.Hour),
+		},
+		SecretsDir: t.TempDir(),
+	})
+	if err != nil {
+		t.Fatalf("Failed to initialize server: %+v", err)
+	}
+	t.Cleanup(s.Close)
+	mux := http.NewServeMux()
+	s.RegisterHandlers(mux)
+	addr := setupServerWithHandler(t, mux)
+
+	_, body, err := httpGet(t, createURL(addr, "/v0/clock_health", nil))
+	if err != nil {
+		t.Fatalf("Failed to get clock health: %+v", err)
+	}
+	var raw map[string]any
+	if err := json.Unmarshal([]byte(body), &raw); err != nil {
+		t.Fatalf("clock_health returned invalid JSON: %+v", err)
+	}
+	if staleness, ok := raw["staleness"]; !ok || staleness != nil {
+		t.Errorf("clock_health without a reading returned staleness %v, want null", staleness)
+	}
+	if synced, _ := raw["synced"].(bool); synced {
+		t.Errorf("clock_health without a reading reported a synchronized clock")
+	}
+
+	clk.SetError(nil)
+	resp, err := httpGetOK[server.GetClockHealthResp](t, createURL(addr, "/v0/clock_health", nil))
+	if err != nil {
+		t.Fatalf("Failed to get clock health: %+v", err)
+	}
+	if !resp.Synced || resp.Staleness == nil {
+		t.Errorf("clock_health after the clock obtained the time returned %+v", *resp)
+	}
+}
+
+func TestGetAvailability(t *testing.T) {
+	addr := setupServer(t)
+	past := time.Now().Add(-longEnough)
+	future := time.Now().Add(time.Hour)
+	pastUrl := createURL(addr, "/v0/availability", url.Values{
+		"time": []string{fmt.Sprint(past.Unix())},
+	})
+	futureUrl := createURL(addr, "/v0/availability", url.Values{
+		"time": []string{fmt.Sprint(future.Unix())},
+	})
+
+	pastResp, err := httpGetOK[server.GetAvailabilityResp](t, pastUrl)
+	if err != nil {
+		t.Fatalf("Failed to get availability for %s: %+v", past.Format(time.RFC3339), err)
+	}
+	if !pastResp.Available || pastResp.Remaining != 0 {
+		t.Errorf("Private key for %s is not available", past.Format(time.RFC3339))
+	}
+
+	futureResp, err := httpGetOK[server.GetAvailabilityResp](t, futureUrl)
+	if err != nil {
+		t.Fatalf("Failed to get availability for %s: %+v", future.Format(time.RFC3339), err)
+	}
+	if futureResp.Available {
+		t.Errorf("Private key for %s is available", future.Format(time.RFC3339))
+	}
+	if futureResp.ReleaseTime != future.UTC().Format(time.RFC3339) {
+		t.Errorf("Private key for %s has wrong release time %s", future.Format(time.RFC3339), futureResp.ReleaseTime)
+	}
+	if futureResp.Remaining <= 0 || futureResp.Remaining > time.Hour.Seconds() {
+		t.Errorf("Private key for %s has implausible time remaining: %vs", future.Format(time.RFC3339), futureResp.Remaining)
+	}
+}
+
+// Starts a gRPC server for the test server on an in-memory listener and returns a client for it.
+func setupGRPC(t *testing.T) timecapsulepb.TimecapsuleClient {
+	return setupGRPCWithServer(t, testServer)
+}
+
+// Starts a gRPC server for the given server on an in-memory listener and returns a client for it.
+func setupGRPCWithServer(t *testing.T, s *server.Server) timecapsulepb.TimecapsuleClient {
+	lis := bufconn.Listen(1 << 20)
+	gs := grpc.NewServer()
+	s.RegisterGRPC(gs)
+	go gs.Serve(lis)
+	t.Cleanup(gs.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("Failed to create gRPC client: %+v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return timecapsulepb.NewTimecapsuleClient(conn)
+}
+
+func TestGRPCGetKeyPair(t *testing.T) {
+	addr := setupServer(t)
+	client := setupGRPC(t)
+	ctx := context.Background()
+	past := time.Now().Add(-longEnough)
+
+	pubResp, err := client.GetPublicKey(ctx, &timecapsulepb.GetPublicKeyRequest{
+		PkiId:  testPKI.String(),
+		Target: &timecapsulepb.GetPublicKeyRequest_Time{Time: timestamppb.New(past)},
+	})
+	if err != nil {
+		t.Fatalf("GetPublicKey failed: %+v", err)
+	}
+	privResp, err := client.GetPrivateKey(ctx, &timecapsulepb.GetPrivateKeyRequest{
+		PkiId:  testPKI.String(),
+		Target: &timecapsulepb.GetPrivateKeyRequest_Time{Time: timestamppb.New(past)},
+	})
+	if err != nil {
+		t.Fatalf("GetPrivateKey failed: %+v", err)
+	}
+
+	httpResp, err := httpGetOK[server.GetPublicKeyResp](t, createURL(addr, "/v0/get_public_key", url.Values{
+		"time": []string{fmt.Sprint(past.Unix())},
+	}))
+	if err != nil {
+		t.Fatalf("Failed to get public key over HTTP: %+v", err)
+	}
+	if !bytes.Equal(pubResp.GetSpki(), httpResp.SPKI) {
+		t.Errorf("gRPC and HTTP public keys differ")
+	}
+	if pubResp.GetFingerprint() != httpResp.Fingerprint || pubResp.GetSigningKeyFingerprint() != httpResp.SigningKeyFingerprint {
+		t.Errorf("gRPC and HTTP public key fingerprints differ")
+	}
+	if privResp.GetFingerprint() != httpResp.Fingerprint || privResp.GetSigningKeyFingerprint() != httpResp.SigningKeyFingerprint {
+		t.Errorf("gRPC private key fingerprints differ from HTTP public key fingerprints")
+	}
+	attestation := privResp.GetAttestation()
+	if attestation.GetTargetTime().GetSeconds() != past.Unix() || attestation.GetSigningKeyId() != httpResp.SigningKeyID || len(attestation.GetSignature()) == 0 {
+		t.Errorf("GetPrivateKey returned attestation %v for %s", attestation, past.Format(time.RFC3339))
+	}
+
+	pub, err := keys.ParseECDHPublicKeyAsSPKIDER(pubResp.GetSpki())
+	if err != nil {
+		t.Fatalf("GetPublicKey returned invalid key: %+v", err)
+	}
+	priv, err := keys.ParseECDHPrivateKeyAsPKCS8DER(privResp.GetPkcs8())
+	if err != nil {
+		t.Fatalf("GetPrivateKey returned invalid key: %+v", err)
+	}
+	if !priv.PublicKey().Equal(pub) {
+		t.Errorf("gRPC private key does not match public key")
+	}
+}
+
+func TestGRPCHybrid(t *testing.T) {
+	client := setupGRPC(t)
+	ctx := context.Background()
+	past := timestamppb.New(time.Now().Add(-longEnough))
+
+	pubResp, err := client.GetPublicKey(ctx, &timecapsulepb.GetPublicKeyRequest{
+		Target: &timecapsulepb.GetPublicKeyRequest_Time{Time: past},
+		Hybrid: true,
+	})
+	if err != nil {
+		t.Fatalf("GetPublicKey failed: %+v", err)
+	}
+	privResp, err := client.GetPrivateKey(ctx, &timecapsulepb.GetPrivateKeyRequest{
+		Target: &timecapsulepb.GetPrivateKeyRequest_Time{Time: past},
+		Hybrid: true,
+	})
+	if err != nil {
+		t.Fatalf("GetPrivateKey failed: %+v", err)
+	}
+	if len(pubResp.GetMlkemSignature()) == 0 {
+		t.Errorf("GetPublicKey returned no ML-KEM signature")
+	}
+	dk, err := mlkem.NewDecapsulationKey768(privResp.GetMlkemSeed())
+	if err != nil {
+		t.Fatalf("GetPrivateKey returned invalid ML-KEM seed: %+v", err)
+	}
+	if !bytes.Equal(dk.EncapsulationKey().Bytes(), pubResp.GetMlkemEncapsulationKey()) {
+		t.Errorf("gRPC ML-KEM decapsulation key does not match encapsulation key")
+	}
+}
+
+func TestGRPCRateLimits(t *testing.T) {
+	s, err := server.NewServer(server.Options{
+		TimeSource: testClock,
+		PKIOptions: keys.PKIOptions{
+			Name:    "Test gRPC Rate Limited Server",
+			MinTime: time.Now().Add(-24 * time.Hour),
+			MaxTime: time.Now().Add(24 * time.Hour),
+		},
+		SecretsDir: t.TempDir(),
+		RateLimits: server.RateLimitOptions{
+			PrivateKeyPerClient: server.RateLimit{Rate: 0.001, Burst: 2},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to initialize server: %+v", err)
+	}
+	t.Cleanup(s.Close)
+	client := setupGRPCWithServer(t, s)
+	ctx := context.Background()
+	past := timestamppb.New(time.Now().Add(-longEnough))
+
+	for i := range 2 {
+		if _, err := client.GetPrivateKey(ctx, &timecapsulepb.GetPrivateKeyRequest{
+			Target: &timecapsulepb.GetPrivateKeyRequest_Time{Time: past},
+		}); err != nil {
+			t.Fatalf("GetPrivateKey %d within burst failed: %+v", i, err)
+		}
+	}
+	_, err = client.GetPrivateKey(ctx, &timecapsulepb.GetPrivateKeyRequest{
+		Target: &timecapsulepb.GetPrivateKeyRequest_Time{Time: past},
+	})
+	if status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("GetPrivateKey beyond burst returned %v, want %v", err, codes.ResourceExhausted)
+	}
+	if _, err := client.GetPublicKey(ctx, &timecapsulepb.GetPublicKeyRequest{
+		Target: &timecapsulepb.GetPublicKeyRequest_Time{Time: past},
+	}); err != nil {
+		t.Errorf("GetPublicKey was limited by private key rate limits: %+v", err)
+	}
+}
+
+func TestGRPCRequestID(t *testing.T) {
+	client := setupGRPC(t)
+
+	for _, tc := range []struct {
+		sent string
+		keep bool
+	}{
+		{"", false},
+		{"proxy-assigned.123", true},
+		{"bad id; with spaces", false},
+	} {
+		ctx := context.Background()
+		if tc.sent != "" {
+			ctx = metadata.AppendToOutgoingContext(ctx, "x-request-id", tc.sent)
+		}
+		var header metadata.MD
+		if _, err := client.GetInfo(ctx, &timecapsulepb.GetInfoRequest{}, grpc.Header(&header)); err != nil {
+			t.Fatalf("GetInfo failed: %+v", err)
+		}
+		got := header.Get("x-request-id")
+		if len(got) != 1 || got[0] == "" || (got[0] == tc.sent) != tc.keep {
+			t.Errorf("GetInfo with request ID %q returned %q, want kept = %v", tc.sent, got, tc.keep)
+		}
+	}
+}
+
+func TestGRPCErrors(t *testing.T) {
+	client := setupGRPC(t)
+	ctx := context.Background()
+
+	_, err := client.GetPrivateKey(ctx, &timecapsulepb.GetPrivateKeyRequest{
+		Target: &timecapsulepb.GetPrivateKeyRequest_Time{Time: timestamppb.New(time.Now().Add(longEnough))},
+	})
+	if status.Code(err) != codes.PermissionDenied {
+		t.Errorf("GetPrivateKey for a future time returned %v, want %v", err, codes.PermissionDenied)
+	}
+
+	_, err = client.GetPublicKey(ctx, &timecapsulepb.GetPublicKeyRequest{
+		Target: &timecapsulepb.GetPublicKeyRequest_Time{Time: timestamppb.New(timeTooLate)},
+	})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("GetPublicKey for an out-of-range time returned %v, want %v", err, codes.InvalidArgument)
+	}
+
+	_, err = client.GetPublicKey(ctx, &timecapsulepb.GetPublicKeyRequest{
+		PkiId:  uuid.New().String(),
+		Target: &timecapsulepb.GetPublicKeyRequest_Time{Time: timestamppb.Now()},
+	})
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("GetPublicKey for an unknown PKI returned %v, want %v", err, codes.NotFound)
+	}
+}
+
+func TestV1GetKeyPair(t *testing.T) {
+	addr := setupServer(t)
+	target := time.Now().Add(-longEnough)
+
+	pubResp, err := httpPostJSONOK[server.GetPublicKeyResp](t, createURL(addr, "/v1/get_public_key", nil), &server.V1GetPublicKeyReq{
+		PKIID: testPKI.String(),
+		Time:  target.Format(time.RFC3339),
+	})
+	if err != nil {
+		t.Fatalf("Failed to get public key for %s: %+v", target.Format(time.RFC3339), err)
+	}
+	pub, err := keys.ParseECDHPublicKeyAsSPKIDER(pubResp.SPKI)
+	if err != nil {
+		t.Fatalf("get_public_key returned invalid key: %+v", err)
+	}
+
+	privResp, err := httpPostJSONOK[server.GetPrivateKeyResp](t, createURL(addr, "/v1/get_private_key", nil), &server.V1GetPrivateKeyReq{
+		Time: target.Format(time.RFC3339),
+	})
+	if err != nil {
+		t.Fatalf("Failed to get private key for %s: %+v", target.Format(time.RFC3339), err)
+	}
+	priv, err := keys.ParseECDHPrivateKeyAsPKCS8DER(privResp.PKCS8)
+	if err != nil {
+		t.Fatalf("get_private_key returned invalid key: %+v", err)
+	}
+
+	if !priv.PublicKey().Equal(pub) {
+		t.Errorf("Private key for %s does not correspond to public key", target.Format(time.RFC3339))
+	}
+}
+
+func TestV1Errors(t *testing.T) {
+	addr := setupServer(t)
+
+	for _, tc := range []struct {
+		name   string
+		path   string
+		body   any
+		status int
+		code   string
+	}{
+		{
+			name:   "future private key",
+			path:   "/v1/get_private_key",
+			body:   &server.V1GetPrivateKeyReq{Time: fmt.Sprint(time.Now().Add(longEnough).Unix())},
+			status: http.StatusForbidden,
+			code:   "permission_denied",
+		},
+		{
+			name:   "out of range",
+			path:   "/v1/get_public_key",
+			body:   &server.V1GetPublicKeyReq{Time: timeTooLate.Format(time.RFC3339)},
+			status: http.StatusBadRequest,
+			code:   "invalid_argument",
+		},
+		{
+			name:   "unknown PKI",
+			path:   "/v1/get_public_key",
+			body:   &server.V1GetPublicKeyReq{PKIID: uuid.New().String(), Time: time.Now().Format(time.RFC3339)},
+			status: http.StatusNotFound,
+			code:   "not_found",
+		},
+		{
+			name:   "unknown field",
+			path:   "/v1/get_public_key",
+			body:   map[string]string{"when": time.Now().Format(time.RFC3339)},
+			status: http.StatusBadRequest,
+			code:   "invalid_argument",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			status, body, err := httpPostJSON(t, createURL(addr, tc.path, nil), tc.body)
+			if err != nil {
+				t.Fatalf("POST %s failed: %+v", tc.path, err)
+			}
+			if status != tc.status {
+				t.Errorf("POST %s returned status %d, want %d", tc.path, status, tc.status)
+			}
+
+			var errResp server.V1ErrorResp
+			d := json.NewDecoder(bytes.NewReader(body))
+			d.DisallowUnknownFields()
+			if err := d.Decode(&errResp); err != nil {
+				t.Fatalf("Failed to decode error response: %+v", err)
+			}
+			if errResp.Error.Code != tc.code {
+				t.Errorf("POST %s returned error code %q, want %q", tc.path, errResp.Error.Code, tc.code)
+			}
+			if errResp.Error.Message == "" {
+				t.Errorf("POST %s returned empty error message", tc.path)
+			}
+		})
+	}
+}
+
+func TestGetKeyFormats(t *testing.T) {
+	addr := setupServer(t)
+	target := time.Now().Add(-longEnough)
+	timeArg := fmt.Sprint(target.Unix())
+
+	jsonResp, err := httpGetOK[server.GetPublicKeyResp](t, createURL(addr, "/v0/get_public_key", url.Values{
+		"time": []string{timeArg},
+	}))
+	if err != nil {
+		t.Fatalf("Failed to get public key for %s: %+v", target.Format(time.RFC3339), err)
+	}
+	pub, err := keys.ParseECDHPublicKeyAsSPKIDER(jsonResp.SPKI)
+	if err != nil {
+		t.Fatalf("get_public_key returned invalid key: %+v", err)
+	}
+
+	t.Run("pem", func(t *testing.T) {
+		_, body, err := httpGet(t, createURL(addr, "/v0/get_public_key", url.Values{
+			"time":   []string{timeArg},
+			"format": []string{"pem"},
+		}))
+		if err != nil {
+			t.Fatalf("Failed to get PEM public key: %+v", err)
+		}
+		got, err := keys.ParseECDHPublicKeyAsSPKIPEM(body)
+		if err != nil {
+			t.Fatalf("get_public_key returned invalid PEM: %+v", err)
+		}
+		if !got.Equal(pub) {
+			t.Errorf("PEM public key differs from JSON public key")
+		}
+	})
+
+	t.Run("der", func(t *testing.T) {
+		_, body, err := httpGetWithHeaders(t, createURL(addr, "/v0/get_public_key", url.Values{
+			"time": []string{timeArg},
+		}), http.Header{"Accept": []string{"application/octet-stream"}})
+		if err != nil {
+			t.Fatalf("Failed to get DER public key: %+v", err)
+		}
+		if !bytes.Equal([]byte(body), jsonResp.SPKI) {
+			t.Errorf("DER public key differs from JSON public key")
+		}
+	})
+
+	t.Run("jwk", func(t *testing.T) {
+		jwk, err := httpGetOK[keys.JWK](t, createURL(addr, "/v0/get_private_key", url.Values{
+			"time":   []string{timeArg},
+			"format": []string{"jwk"},
+		}))
+		if err != nil {
+			t.Fatalf("Failed to get JWK private key: %+v", err)
+		}
+		want, err := keys.FormatPublicKeyAsJWK(pub)
+		if err != nil {
+			t.Fatalf("Failed to format public key as JWK: %+v", err)
+		}
+		if jwk.Kty != "EC" || jwk.Crv != "P-256" || jwk.X != want.X || jwk.Y != want.Y || jwk.D == "" {
+			t.Errorf("JWK private key %+v does not match public key %+v", jwk, want)
+		}
+	})
+
+	t.Run("cose", func(t *testing.T) {
+		_, body, err := httpGet(t, createURL(addr, "/v0/get_public_key", url.Values{
+			"time":   []string{timeArg},
+			"format": []string{"cose"},
+		}))
+		if err != nil {
+			t.Fatalf("Failed to get COSE_Key public key: %+v", err)
+		}
+		want, err := keys.FormatPublicKeyAsCOSE(pub)
+		if err != nil {
+			t.Fatalf("Failed to format public key as COSE_Key: %+v", err)
+		}
+		if !bytes.Equal([]byte(body), want) {
+			t.Errorf("COSE_Key public key differs from JSON public key")
+		}
+	})
+
+	t.Run("cbor", func(t *testing.T) {
+		_, body, err := httpGetWithHeaders(t, createURL(addr, "/v0/get_public_key", url.Values{
+			"time": []string{timeArg},
+		}), http.Header{"Accept": []string{"application/cbor"}})
+		if err != nil {
+			t.Fatalf("Failed to get CBOR public key response: %+v", err)
+		}
+		want, err := cbor.Marshal(jsonResp)
+		if err != nil {
+			t.Fatalf("Failed to encode JSON response as CBOR: %+v", err)
+		}
+		if !bytes.Equal([]byte(body), want) {
+			t.Errorf("CBOR response %x differs from JSON response %+v", body, jsonResp)
+		}
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		status, _, err := httpGet(t, createURL(addr, "/v0/get_public_key", url.Values{
+			"time":   []string{timeArg},
+			"format": []string{"xml"},
+		}))
+		if err != nil {
+			t.Fatalf("Failed to get public key: %+v", err)
+		}
+		if status != http.StatusBadRequest {
+			t.Errorf("get_public_key with invalid format returned status %d, want %d", status, http.StatusBadRequest)
+		}
+	})
+}
+
+func TestGetJWKS(t *testing.T) {
+	addr := setupServer(t)
+	from := time.Date(2025, time.March, 1, 10, 30, 0, 0, time.UTC)
+	to := time.Date(2025, time.March, 1, 13, 0, 0, 0, time.UTC)
+
+	set, err := httpGetOK[server.JWKSet](t, createURL(addr, "/v0/jwks", url.Values{
+		"from": []string{from.Format(time.RFC3339)},
+		"to":   []string{to.Format(time.RFC3339)},
+	}))
+	if err != nil {
+		t.Fatalf("Failed to get JWK Set: %+v", err)
+	}
+
+	// Interval boundaries are 11:00, 12:00, and 13:00.
+	if len(set.Keys) != 3 {
+		t.Fatalf("JWK Set has %d keys, want 3", len(set.Keys))
+	}
+	for i, jwk := range set.Keys {
+		target := time.Date(2025, time.March, 1, 11+i, 0, 0, 0, time.UTC)
+		if want := fmt.Sprintf("%s/%d", testPKI.String(), target.Unix()); jwk.Kid != want {
+			t.Errorf("Key %d has kid %q, want %q", i, jwk.Kid, want)
+		}
+
+		pubResp, err := httpGetOK[server.GetPublicKeyResp](t, createURL(addr, "/v0/get_public_key", url.Values{
+			"time": []string{fmt.Sprint(target.Unix())},
+		}))
+		if err != nil {
+			t.Fatalf("Failed to get public key for %s: %+v", target.Format(time.RFC3339), err)
+		}
+		pub, err := keys.ParseECDHPublicKeyAsSPKIDER(pubResp.SPKI)
+		if err != nil {
+			t.Fatalf("get_public_key returned invalid key: %+v", err)
+		}
+		want, err := keys.FormatPublicKeyAsJWK(pub)
+		if err != nil {
+			t.Fatalf("Failed to format public key as JWK: %+v", err)
+		}
+		if jwk.X != want.X || jwk.Y != want.Y {
+			t.Errorf("Key %d does not match public key for %s", i, target.Format(time.RFC3339))
+		}
+	}
+}
+
+func TestGetPublicKeySignature(t *testing.T) {
+	addr := setupServer(t)
+	target := time.Now().Add(time.Hour).Truncate(time.Second)
+
+	signingResp, err := httpGetOK[server.GetSigningKeyResp](t, createURL(addr, "/v0/signing_key", nil))
+	if err != nil {
+		t.Fatalf("Failed to get signing key: %+v", err)
+	}
+	parsed, err := x509.ParsePKIXPublicKey(signingResp.SPKI)
+	if err != nil {
+		t.Fatalf("signing_key returned invalid key: %+v", err)
+	}
+	signer, ok := parsed.(ed25519.PublicKey)
+	if !ok {
+		t.Fatalf("signing_key returned key of type %T, want Ed25519", parsed)
+	}
+
+	pubResp, err := httpGetOK[server.GetPublicKeyResp](t, createURL(addr, "/v0/get_public_key", url.Values{
+		"time": []string{fmt.Sprint(target.Unix())},
+	}))
+	if err != nil {
+		t.Fatalf("Failed to get public key for %s: %+v", target.Format(time.RFC3339), err)
+	}
+	if pubResp.SigningKeyID != signingResp.KeyID {
+		t.Errorf("Public key was signed by key %q, want %q", pubResp.SigningKeyID, signingResp.KeyID)
+	}
+	if !keys.VerifyPublicKeySignature(signer, testPKI, target, pubResp.SPKI, pubResp.Signature) {
+		t.Errorf("Public key signature does not verify")
+	}
+}
+
+func TestGetPrivateKeyWait(t *testing.T) {
+	addr := setupServer(t)
+	target := time.Now().Add(2 * time.Second)
+
+	start := time.Now()
+	resp, err := httpGetOK[server.GetPrivateKeyResp](t, createURL(addr, "/v0/get_private_key", url.Values{
+		"time": []string{target.Format(time.RFC3339Nano)},
+		"wait": []string{"true"},
+	}))
+	if err != nil {
+		t.Fatalf("Failed to wait for private key for %s: %+v", target.Format(time.RFC3339), err)
+	}
+	if time.Since(start) < time.Second {
+		t.Errorf("Request returned after %v, before the key was released", time.Since(start))
+	}
+	if _, err := keys.ParseECDHPrivateKeyAsPKCS8DER(resp.PKCS8); err != nil {
+		t.Errorf("get_private_key returned invalid key: %+v", err)
+	}
+
+	// Keys released beyond the maximum wait are rejected immediately.
+	status, _, err := httpGet(t, createURL(addr, "/v0/get_private_key", url.Values{
+		"time": []string{fmt.Sprint(time.Now().Add(time.Hour).Unix())},
+		"wait": []string{"true"},
+	}))
+	if err != nil {
+		t.Fatalf("Failed to get private key: %+v", err)
+	}
+	if status != http.StatusForbidden {
+		t.Errorf("get_private_key beyond maximum wait returned status %d, want %d", status, http.StatusForbidden)
+	}
+}
+
+func TestUnlockStream(t *testing.T) {
+	addr := setupServer(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, createURL(addr, "/v0/unlock_stream", nil), nil)
+	if err != nil {
+		t.Fatalf("Failed to create request: %+v", err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Failed to open unlock stream: %+v", err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("Unlock stream has content type %q, want text/event-stream", ct)
+	}
+
+	// The most recently unlocked interval is announced immediately.
+	var data string
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		if line, ok := strings.CutPrefix(scanner.Text(), "data: "); ok {
+			data = line
+			break
+		}
+	}
+	if data == "" {
+		t.Fatalf("Unlock stream ended without an event: %v", scanner.Err())
+	}
+	var event server.UnlockEvent
+	if err := json.Unmarshal([]byte(data), &event); err != nil {
+		t.Fatalf("Failed to parse unlock event %q: %+v", data, err)
+	}
+
+	pubResp, err := httpGetOK[server.GetPublicKeyResp](t, createURL(addr, "/v0/get_public_key", url.Values{
+		"time": []string{event.Time},
+	}))
+	if err != nil {
+		t.Fatalf("Failed to get public key for %s: %+v", event.Time, err)
+	}
+	sum := sha256.Sum256(pubResp.SPKI)
+	if want := hex.EncodeToString(sum[:]); event.Fingerprint != want {
+		t.Errorf("Unlock event has fingerprint %s, want %s", event.Fingerprint, want)
+	}
+}
+
+func TestGetPrivateKeysWrapped(t *testing.T) {
+	client, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate client key: %+v", err)
+	}
+	spki, err := x509.MarshalPKIXPublicKey(client.PublicKey())
+	if err != nil {
+		t.Fatalf("Failed to marshal client key: %+v", err)
+	}
+
+	addr := setupServer(t)
+	start := time.Date(2025, time.March, 1, 10, 0, 0, 0, time.UTC)
+	end := start.Add(2 * time.Hour)
+
+	resp, err := httpPostJSONOK[server.GetPrivateKeysResp](t, createURL(addr, "/v1/get_private_keys", nil), &server.V1GetPrivateKeysReq{
+		Start:  start.Format(time.RFC3339),
+		End:    end.Format(time.RFC3339),
+		WrapTo: spki,
+	})
+	if err != nil {
+		t.Fatalf("Failed to get private keys: %+v", err)
+	}
+	if len(resp.Keys) != 3 {
+		t.Fatalf("get_private_keys returned %d keys, want 3", len(resp.Keys))
+	}
+	for _, entry := range resp.Keys {
+		if len(entry.PKCS8) != 0 {
+			t.Errorf("get_private_keys returned an unwrapped private key for %s", entry.Time)
+		}
+		der, err := keys.OpenWrapped(client, entry.Enc, entry.Sealed)
+		if err != nil {
+			t.Fatalf("Failed to unwrap private key for %s: %+v", entry.Time, err)
+		}
+		priv, err := keys.ParseECDHPrivateKeyAsPKCS8DER(der)
+		if err != nil {
+			t.Fatalf("get_private_keys returned invalid wrapped key for %s: %+v", entry.Time, err)
+		}
+
+		pubResp, err := httpGetOK[server.GetPublicKeyResp](t, createURL(addr, "/v0/get_public_key", url.Values{
+			"time": []string{entry.Time},
+		}))
+		if err != nil {
+			t.Fatalf("Failed to get public key for %s: %+v", entry.Time, err)
+		}
+		pub, err := keys.ParseECDHPublicKeyAsSPKIDER(pubResp.SPKI)
+		if err != nil {
+			t.Fatalf("get_public_key returned invalid key: %+v", err)
+		}
+		if !priv.PublicKey().Equal(pub) {
+			t.Errorf("Wrapped private key for %s does not correspond to public key", entry.Time)
+		}
+	}
+}
+
+func TestGetPrivateKeyAttestation(t *testing.T) {
+	addr := setupServer(t)
+	target := time.Now().Add(-longEnough).Truncate(time.Second)
+
+	signingResp, err := httpGetOK[server.GetSigningKeyResp](t, createURL(addr, "/v0/signing_key", nil))
+	if err != nil {
+		t.Fatalf("Failed to get signing key: %+v", err)
+	}
+	parsed, err := x509.ParsePKIXPublicKey(signingResp.SPKI)
+	if err != nil {
+		t.Fatalf("signing_key returned invalid key: %+v", err)
+	}
+	signer := parsed.(ed25519.PublicKey)
+
+	privResp, err := httpGetOK[server.GetPrivateKeyResp](t, createURL(addr, "/v0/get_private_key", url.Values{
+		"time": []string{fmt.Sprint(target.Unix())},
+	}))
+	if err != nil {
+		t.Fatalf("Failed to get private key for %s: %+v", target.Format(time.RFC3339), err)
+	}
+	att := privResp.Attestation
+	if att == nil {
+		t.Fatalf("get_private_key returned no attestation")
+	}
+	if att.TargetTime != target.UTC().Format(time.RFC3339) {
+		t.Errorf("Attestation has target time %s, want %s", att.TargetTime, target.UTC().Format(time.RFC3339))
+	}
+	released, err := time.Parse(time.RFC3339, att.ReleaseTime)
+	if err != nil {
+		t.Fatalf("Attestation has invalid release time: %+v", err)
+	}
+	if released.Before(target) {
+		t.Errorf("Attestation release time %s is before target time %s", att.ReleaseTime, att.TargetTime)
+	}
+	if !keys.VerifyReleaseAttestation(signer, testPKI, target, released, att.Signature) {
+		t.Errorf("Release attestation does not verify")
+	}
+}
+
+func TestListPKIs(t *testing.T) {
+	addr := setupServer(t)
+
+	resp, err := httpGetOK[server.ListPKIsResp](t, createURL(addr, "/v0/list_pkis", nil))
+	if err != nil {
+		t.Fatalf("Failed to list PKIs: %+v", err)
+	}
+	if len(resp.PKIs) != 2 {
+		t.Fatalf("list_pkis returned %d PKIs, want 2", len(resp.PKIs))
+	}
+	if resp.PKIs[0].PKIID != testPKI.String() {
+		t.Errorf("First PKI is %s, want primary PKI %s", resp.PKIs[0].PKIID, testPKI)
+	}
+	if resp.PKIs[1].PKIID != extraPKI.String() || resp.PKIs[1].PKIName != "Extra Test Server" {
+		t.Errorf("Second PKI is %s (%q), want %s (%q)", resp.PKIs[1].PKIID, resp.PKIs[1].PKIName, extraPKI, "Extra Test Server")
+	}
+}
+
+func TestGetPublicKeyByPKIID(t *testing.T) {
+	addr := setupServer(t)
+	target := time.Now().Add(-longEnough)
+
+	primaryResp, err := httpGetOK[server.GetPublicKeyResp](t, createURL(addr, "/v0/get_public_key", url.Values{
+		"time": []string{fmt.Sprint(target.Unix())},
+	}))
+	if err != nil {
+		t.Fatalf("Failed to get public key from primary PKI: %+v", err)
+	}
+	extraResp, err := httpGetOK[server.GetPublicKeyResp](t, createURL(addr, "/v0/get_public_key", url.Values{
+		"pki_id": []string{extraPKI.String()},
+		"time":   []string{fmt.Sprint(target.Unix())},
+	}))
+	if err != nil {
+		t.Fatalf("Failed to get public key from extra PKI: %+v", err)
+	}
+	if extraResp.PKIID != extraPKI.String() {
+		t.Errorf("Public key is from PKI %s, want %s", extraResp.PKIID, extraPKI)
+	}
+	if bytes.Equal(primaryResp.SPKI, extraResp.SPKI) {
+		t.Errorf("Primary and extra PKIs have the same public key for %s", target.Format(time.RFC3339))
+	}
+
+	privResp, err := httpGetOK[server.GetPrivateKeyResp](t, createURL(addr, "/v0/get_private_key", url.Values{
+		"pki_id": []string{extraPKI.String()},
+		"time":   []string{fmt.Sprint(target.Unix())},
+	}))
+	if err != nil {
+		t.Fatalf("Failed to get private key from extra PKI: %+v", err)
+	}
+	pub, err := keys.ParseECDHPublicKeyAsSPKIDER(extraResp.SPKI)
+	if err != nil {
+		t.Fatalf("get_public_key returned invalid key: %+v", err)
+	}
+	priv, err := keys.ParseECDHPrivateKeyAsPKCS8DER(privResp.PKCS8)
+	if err != nil {
+		t.Fatalf("get_private_key returned invalid key: %+v", err)
+	}
+	if !priv.PublicKey().Equal(pub) {
+		t.Errorf("Extra PKI's private key does not correspond to its public key")
+	}
+}
+
+// Sends an admin POST request and decodes the JSON response, returning an error if the status
+// isn't 200 OK.
+func httpPostAdminOK[T any](t *testing.T, url string) (*T, error) {
+	status, body, err := httpPostAdmin(t, url, adminToken)
+	if err != nil {
+		return nil, err
+	}
+	if status != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s", http.StatusText(status), body)
+	}
+	ret := new(T)
+	d := json.NewDecoder(strings.NewReader(body))
+	d.DisallowUnknownFields()
+	if err := d.Decode(ret); err != nil {
+		return nil, fmt.Errorf("failed to decode body as %T: %w", ret, err)
+	}
+	return ret, nil
+}
+
+func TestCreateAndExtendPKI(t *testing.T) {
+	addr := setupServer(t)
+	minTime := time.Date(2025, time.January, 1, 0, 0, 0, 0, time.UTC)
+	maxTime := time.Date(2025, time.January, 2, 0, 0, 0, 0, time.UTC)
+	newMaxTime := time.Date(2025, time.January, 3, 0, 0, 0, 0, time.UTC)
+	target := time.Date(2025, time.January, 2, 12, 0, 0, 0, time.UTC)
+
+	info, err := httpPostAdminOK[server.GetInfoResp](t, createURL(addr, "/admin/v0/create_pki", url.Values{
+		"name":     []string{"Created PKI"},
+		"min_time": []string{minTime.Format(time.RFC3339)},
+		"max_time": []string{maxTime.Format(time.RFC3339)},
+	}))
+	if err != nil {
+		t.Fatalf("Failed to create PKI: %+v", err)
+	}
+	if info.PKIName != "Created PKI" {
+		t.Errorf("Created PKI has name %q, want %q", info.PKIName, "Created PKI")
+	}
+
+	pkis, err := httpGetOK[server.ListPKIsResp](t, createURL(addr, "/v0/list_pkis", nil))
+	if err != nil {
+		t.Fatalf("Failed to list PKIs: %+v", err)
+	}
+	found := false
+	for _, pki := range pkis.PKIs {
+		found = found || pki.PKIID == info.PKIID
+	}
+	if !found {
+		t.Errorf("Created PKI %s is not listed", info.PKIID)
+	}
+
+	pubUrl := createURL(addr, "/v0/get_public_key", url.Values{
+		"pki_id": []string{info.PKIID},
+		"time":   []string{target.Format(time.RFC3339)},
+	})
+	if status, _, err := httpGet(t, pubUrl); err != nil || status != http.StatusBadRequest {
+		t.Errorf("get_public_key beyond created PKI's range returned (%d, %v), want status %d", status, err, http.StatusBadRequest)
+	}
+
+	info, err = httpPostAdminOK[server.GetInfoResp](t, createURL(addr, "/admin/v0/extend_pki", url.Values{
+		"pki_id":   []string{info.PKIID},
+		"max_time": []string{newMaxTime.Format(time.RFC3339)},
+	}))
+	if err != nil {
+		t.Fatalf("Failed to extend PKI: %+v", err)
+	}
+	if info.MaxTime != newMaxTime.Format(time.RFC3339) {
+		t.Errorf("Extended PKI has maximum time %s, want %s", info.MaxTime, newMaxTime.Format(time.RFC3339))
+	}
+
+	// Secrets for the original range are generated in the background, so wait for them.
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		status, _, err := httpGet(t, pubUrl)
+		if err != nil {
+			t.Fatalf("Failed to get public key: %+v", err)
+		}
+		if status == http.StatusOK {
+			break
+		}
+		if status != http.StatusServiceUnavailable || time.Now().After(deadline) {
+			t.Fatalf("get_public_key in extended range returned status %d", status)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	status, _, err := httpPostAdmin(t, createURL(addr, "/admin/v0/extend_pki", url.Values{
+		"pki_id":   []string{info.PKIID},
+		"max_time": []string{maxTime.Format(time.RFC3339)},
+	}), adminToken)
+	if err != nil {
+		t.Fatalf("Failed to extend PKI: %+v", err)
+	}
+	if status != http.StatusBadRequest {
+		t.Errorf("Shrinking PKI returned status %d, want %d", status, http.StatusBadRequest)
+	}
+}
+
+func TestFreezePKI(t *testing.T) {
+	addr := setupServer(t)
+	privUrl := createURL(addr, "/v0/get_private_key", url.Values{
+		"pki_id": []string{extraPKI.String()},
+		"time":   []string{fmt.Sprint(time.Now().Add(-longEnough).Unix())},
+	})
+	freezeQuery := url.Values{"pki_id": []string{extraPKI.String()}}
+
+	resp, err := httpPostAdminOK[server.FreezeResp](t, createURL(addr, "/admin/v0/freeze", freezeQuery))
+	if err != nil {
+		t.Fatalf("Failed to freeze PKI: %+v", err)
+	}
+	if !resp.Frozen {
+		t.Errorf("PKI was not frozen")
+	}
+	status, _, err := httpGet(t, privUrl)
+	if err != nil {
+		t.Fatalf("Failed to get private key: %+v", err)
+	}
+	if status != http.StatusForbidden {
+		t.Errorf("get_private_key for frozen PKI returned status %d, want %d", status, http.StatusForbidden)
+	}
+
+	// Other PKIs are unaffected.
+	if _, err := httpGetOK[server.GetPrivateKeyResp](t, createURL(addr, "/v0/get_private_key", url.Values{
+		"time": []string{fmt.Sprint(time.Now().Add(-longEnough).Unix())},
+	})); err != nil {
+		t.Errorf("Failed to get private key from primary PKI while extra PKI is frozen: %+v", err)
+	}
+
+	if _, err := httpPostAdminOK[server.FreezeResp](t, createURL(addr, "/admin/v0/unfreeze", freezeQuery)); err != nil {
+		t.Fatalf("Failed to unfreeze PKI: %+v", err)
+	}
+	if _, err := httpGetOK[server.GetPrivateKeyResp](t, privUrl); err != nil {
+		t.Errorf("Failed to get private key after unfreezing: %+v", err)
+	}
+}
+
+func TestHalt(t *testing.T) {
+	addr := setupServer(t)
+	privURLs := []string{
+		createURL(addr, "/v0/get_private_key", url.Values{
+			"time": []string{fmt.Sprint(time.Now().Add(-longEnough).Unix())},
+		}),
+		createURL(addr, "/v0/get_private_key", url.Values{
+			"pki_id": []string{extraPKI.String()},
+			"time":   []string{fmt.Sprint(time.Now().Add(-longEnough).Unix())},
+		}),
+	}
+
+	resp, err := httpPostAdminOK[server.HaltResp](t, createURL(addr, "/admin/v0/halt", nil))
+	if err != nil {
+		t.Fatalf("Failed to halt private key release: %+v", err)
+	}
+	if !resp.Halted || resp.KillSwitch {
+		t.Errorf("halt returned %+v, want halted without kill switch", *resp)
+	}
+	for _, u := range privURLs {
+		status, _, err := httpGet(t, u)
+		if err != nil {
+			t.Fatalf("Failed to get private key: %+v", err)
+		}
+		if status != http.StatusForbidden {
+			t.Errorf("get_private_key while halted returned status %d, want %d", status, http.StatusForbidden)
+		}
+	}
+	// Public keys are still served.
+	if _, err := httpGetOK[server.GetPublicKeyResp](t, createURL(addr, "/v0/get_public_key", url.Values{
+		"time": []string{fmt.Sprint(time.Now().Add(longEnough).Unix())},
+	})); err != nil {
+		t.Errorf("Failed to get public key while halted: %+v", err)
+	}
+
+	if _, err := httpPostAdminOK[server.HaltResp](t, createURL(addr, "/admin/v0/resume", nil)); err != nil {
+		t.Fatalf("Failed to resume private key release: %+v", err)
+	}
+	for _, u := range privURLs {
+		if _, err := httpGetOK[server.GetPrivateKeyResp](t, u); err != nil {
+			t.Errorf("Failed to get private key after resuming: %+v", err)
+		}
+	}
+}
+
+func TestHaltSurvivesRestart(t *testing.T) {
+	dir := t.TempDir()
+	start := func() string {
+		s, err := server.NewServer(server.Options{
+			TimeSource: testClock,
+			PKIOptions: keys.PKIOptions{
+				Name:    "Test Persistent Halt Server",
+				MinTime: time.Now().Add(-24 * time.Hour),
+				MaxTime: time.Now().Add(24 * time.Hour),
+			},
+			SecretsDir:  dir,
+			AdminTokens: []string{adminToken},
+		})
+		if err != nil {
+			t.Fatalf("Failed to initialize server: %+v", err)
+		}
+		t.Cleanup(s.Close)
+		mux := http.NewServeMux()
+		s.RegisterHandlers(mux)
+		return setupServerWithHandler(t, mux)
+	}
+	privURL := func(addr string) string {
+		return createURL(addr, "/v0/get_private_key", url.Values{
+			"time": []string{fmt.Sprint(time.Now().Add(-longEnough).Unix())},
+		})
+	}
+
+	addr := start()
+	if _, err := httpPostAdminOK[server.HaltResp](t, createURL(addr, "/admin/v0/halt", nil)); err != nil {
+		t.Fatalf("Failed to halt private key release: %+v", err)
+	}
+
+	addr = start()
+	status, _, err := httpGet(t, privURL(addr))
+	if err != nil {
+		t.Fatalf("Failed to get private key: %+v", err)
+	}
+	if status != http.StatusForbidden {
+		t.Errorf("get_private_key after restarting while halted returned status %d, want %d", status, http.StatusForbidden)
+	}
+	if _, err := httpPostAdminOK[server.HaltResp](t, createURL(addr, "/admin/v0/resume", nil)); err != nil {
+		t.Fatalf("Failed to resume private key release: %+v", err)
+	}
+
+	addr = start()
+	if _, err := httpGetOK[server.GetPrivateKeyResp](t, privURL(addr)); err != nil {
+		t.Errorf("Failed to get private key after resuming and restarting: %+v", err)
+	}
+}
+
+func TestKillSwitch(t *testing.T) {
+	addr := setupServerWithHandler(t, killSwitchMux)
+	privURL := createURL(addr, "/v0/get_private_key", url.Values{
+		"time": []string{fmt.Sprint(time.Now().Add(-time.Hour).Unix())},
+	})
+	if _, err := httpGetOK[server.GetPrivateKeyResp](t, privURL); err != nil {
+		t.Fatalf("Failed to get private key without kill switch: %+v", err)
+	}
+
+	if err := os.WriteFile(killSwitchFile, nil, 0o600); err != nil {
+		t.Fatalf("Failed to create kill switch file: %+v", err)
+	}
+	status, _, err := httpGet(t, privURL)
+	if err != nil {
+		t.Fatalf("Failed to get private key: %+v", err)
+	}
+	if status != http.StatusForbidden {
+		t.Errorf("get_private_key with kill switch returned status %d, want %d", status, http.StatusForbidden)
+	}
+
+	// The kill switch can't be overridden through the admin API.
+	resp, err := httpPostAdminOK[server.HaltResp](t, createURL(addr, "/admin/v0/resume", nil))
+	if err != nil {
+		t.Fatalf("Failed to resume private key release: %+v", err)
+	}
+	if resp.Halted || !resp.KillSwitch {
+		t.Errorf("resume returned %+v, want kill switch without halt", *resp)
+	}
+	status, _, err = httpGet(t, privURL)
+	if err != nil {
+		t.Fatalf("Failed to get private key: %+v", err)
+	}
+	if status != http.StatusForbidden {
+		t.Errorf("get_private_key with kill switch after resuming returned status %d, want %d", status, http.StatusForbidden)
+	}
+
+	if err := os.Remove(killSwitchFile); err != nil {
+		t.Fatalf("Failed to remove kill switch file: %+v", err)
+	}
+	if _, err := httpGetOK[server.GetPrivateKeyResp](t, privURL); err != nil {
+		t.Errorf("Failed to get private key after removing kill switch: %+v", err)
+	}
+}
+
+func TestReleaseMargin(t *testing.T) {
+	const margin = time.Hour
+	s, err := server.NewServer(server.Options{
+		TimeSource: testClock,
+		PKIOptions: keys.PKIOptions{
+			Name:    "Test Release Margin Server",
+			MinTime: time.Now().Add(-24 * time.Hour),
+			MaxTime: time.Now().Add(24 * time.Hour),
+		},
+		SecretsDir:    t.TempDir(),
+		ReleaseMargin: margin,
+	})
+	if err != nil {
+		t.Fatalf("Failed to initialize server: %+v", err)
+	}
+	t.Cleanup(s.Close)
+	mux := http.NewServeMux()
+	s.RegisterHandlers(mux)
+	addr := setupServerWithHandler(t, mux)
+
+	withheld := time.Now().Add(-margin / 2).Truncate(time.Second)
+	status, _, err := httpGet(t, createURL(addr, "/v0/get_private_key", url.Values{
+		"time": []string{fmt.Sprint(withheld.Unix())},
+	}))
+	if err != nil {
+		t.Fatalf("Failed to get private key: %+v", err)
+	}
+	if status != http.StatusForbidden {
+		t.Errorf("get_private_key within release margin returned status %d, want %d", status, http.StatusForbidden)
+	}
+	avail, err := httpGetOK[server.GetAvailabilityResp](t, createURL(addr, "/v0/availability", url.Values{
+		"time": []string{fmt.Sprint(withheld.Unix())},
+	}))
+	if err != nil {
+		t.Fatalf("Failed to get availability: %+v", err)
+	}
+	if want := withheld.Add(margin).UTC().Format(time.RFC3339); avail.Available || avail.ReleaseTime != want {
+		t.Errorf("availability returned %+v, want unavailable until %s", *avail, want)
+	}
+
+	if _, err := httpGetOK[server.GetPrivateKeyResp](t, createURL(addr, "/v0/get_private_key", url.Values{
+		"time": []string{fmt.Sprint(time.Now().Add(-2 * margin).Unix())},
+	})); err != nil {
+		t.Errorf("Failed to get private key past release margin: %+v", err)
+	}
+
+	if _, err := server.NewServer(server.Options{ReleaseMargin: -time.Minute}); err == nil {
+		t.Errorf("NewServer accepted a negative release margin")
+	}
+}
+
+func TestUnlockBoundary(t *testing.T) {
+	target := time.Date(2025, time.June, 1, 12, 0, 0, 0, time.UTC)
+	clk := clock.NewManualClock(target.Add(-time.Second))
+	s, err := server.NewServer(server.Options{
+		TimeSource: clk,
+		PKIOptions: keys.PKIOptions{
+			Name:    "Test Unlock Boundary Server",
+			MinTime: target.Add(-time.Hour),
+			MaxTime: target.Add(time.Hour),
+		},
+		SecretsDir: t.TempDir(),
+	})
+	if err != nil {
+		t.Fatalf("Failed to initialize server: %+v", err)
+	}
+	t.Cleanup(s.Close)
+	mux := http.NewServeMux()
+	s.RegisterHandlers(mux)
+	addr := setupServerWithHandler(t, mux)
+	privURL := createURL(addr, "/v0/get_private_key", url.Values{
+		"time": []string{fmt.Sprint(target.Unix())},
+	})
+
+	status, _, err := httpGet(t, privURL)
+	if err != nil {
+		t.Fatalf("Failed to get private key: %+v", err)
+	}
+	if status != http.StatusForbidden {
+		t.Errorf("get_private_key a second before release returned status %d, want %d", status, http.StatusForbidden)
+	}
+
+	clk.Advance(time.Second)
+	if _, err := httpGetOK[server.GetPrivateKeyResp](t, privURL); err != nil {
+		t.Errorf("Failed to get private key at release: %+v", err)
+	}
+	now, err := httpGetOK[server.GetNowResp](t, createURL(addr, "/v0/now", nil))
+	if err != nil {
+		t.Fatalf("Failed to get current time: %+v", err)
+	}
+	if now.Unix != target.Unix() {
+		t.Errorf("now returned %s, want %s", now.Time, target.Format(time.RFC3339))
+	}
+
+	// Release is checked against the earliest time within the clock's uncertainty.
+	clk.Advance(time.Second)
+	clk.SetUncertainty(2 * time.Second)
+	status, _, err = httpGet(t, privURL)
+	if err != nil {
+		t.Fatalf("Failed to get private key: %+v", err)
+	}
+	if status != http.StatusForbidden {
+		t.Errorf("get_private_key within the clock's uncertainty returned status %d, want %d", status, http.StatusForbidden)
+	}
+	clk.SetUncertainty(0)
+
+	// Without a secure time, nothing is released until the clock recovers.
+	clk.SetError(errors.New("clock is stale"))
+	resp, err := http.Get(privURL)
+	if err != nil {
+		t.Fatalf("Failed to get private key: %+v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("get_private_key without a secure time returned status %d, want %d", resp.StatusCode, http.StatusServiceUnavailable)
+	}
+	if resp.Header.Get("Retry-After") == "" {
+		t.Errorf("get_private_key without a secure time did not set Retry-After")
+	}
+}
+
+func TestStartsWithoutTime(t *testing.T) {
+	// Nothing listens on the discard port, so the clock never obtains the time.
+	unreachable := clock.RoughtimeServer{Addr: "127.0.0.1:9", PublicKey: make([]byte, 32)}
+	s, err := server.NewServer(server.Options{
+		RoughtimeServers: []clock.RoughtimeServer{unreachable},
+		PKIOptions: keys.PKIOptions{
+			Name:    "Test Unsynchronized Server",
+			MinTime: time.Now().Add(-24 * time.Hour),
+			MaxTime: time.Now().Add(24 * time.Hour),
+		},
+		SecretsDir: t.TempDir(),
+	})
+	if err != nil {
+		t.Fatalf("Failed to initialize server without reachable time servers: %+v", err)
+	}
+	t.Cleanup(s.Close)
+	mux := http.NewServeMux()
+	s.RegisterHandlers(mux)
+	addr := setupServerWithHandler(t, mux)
+	past := url.Values{"time": []string{fmt.Sprint(time.Now().Add(-time.Hour).Unix())}}
+
+	if _, err := httpGetOK[server.GetPublicKeyResp](t, createURL(addr, "/v0/get_public_key", past)); err != nil {
+		t.Errorf("Failed to get public key without a secure time: %+v", err)
+	}
+	status, _, err := httpGet(t, createURL(addr, "/v0/get_private_key", past))
+	if err != nil {
+		t.Fatalf("Failed to get private key: %+v", err)
+	}
+	if status != http.StatusServiceUnavailable {
+		t.Errorf("get_private_key without a secure time returned status %d, want %d", status, http.StatusServiceUnavailable)
+	}
+
+	health, err := httpGetOK[server.GetClockHealthResp](t, createURL(addr, "/v0/clock_health", nil))
+	if err != nil {
+		t.Fatalf("Failed to get clock health: %+v", err)
+	}
+	if health.Synced || health.LastPoll != "" || health.Staleness != nil || health.ConsecutiveFailures < 1 {
+		t.Errorf("clock_health without a secure time returned %+v", *health)
+	}
+}
+
+// Secret store that fails every request while it is down.
+type flakyStore struct {
+	keys.SecretStore
+	down atomic.Bool
+}
+
+func (s *flakyStore) Get(bucket time.Time) ([]byte, bool, error) {
+	if s.down.Load() {
+		return nil, false, errors.New("secret store is down")
+	}
+	return s.SecretStore.Get(bucket)
+}
+
+func TestPrecomputeIntervals(t *testing.T) {
+	inner, err := keys.NewDirStore(t.TempDir(), keys.SecretLayoutFlat)
+	if err != nil {
+		t.Fatalf("Failed to create secret store: %+v", err)
+	}
+	store := &flakyStore{SecretStore: inner}
+	s, err := server.NewServer(server.Options{
+		TimeSource: testClock,
+		PKIOptions: keys.PKIOptions{
+			Name:         "Test Precompute Server",
+			MinTime:      time.Now().Add(-24 * time.Hour),
+			MaxTime:      time.Now().Add(24 * time.Hour),
+			KeyCacheSize: -1,
+			SecretStore:  store,
+		},
+		SecretsDir:          t.TempDir(),
+		PrecomputeIntervals: 4,
+	})
+	if err != nil {
+		t.Fatalf("Failed to initialize server: %+v", err)
+	}
+	t.Cleanup(s.Close)
+	mux := http.NewServeMux()
+	s.RegisterHandlers(mux)
+	addr := setupServerWithHandler(t, mux)
+
+	// Upcoming public keys are precomputed at startup, so they are served with the secret store down.
+	store.down.Store(true)
+	from := time.Now().Add(time.Hour).Truncate(time.Hour)
+	if _, err := httpGetOK[server.PublicKeyBundle](t, createURL(addr, "/v0/get_public_key_bundle", url.Values{
+		"from": []string{fmt.Sprint(from.Unix())},
+		"to":   []string{fmt.Sprint(from.Add(2 * time.Hour).Unix())},
+	})); err != nil {
+		t.Errorf("Failed to get bundle of precomputed public keys with the secret store down: %+v", err)
+	}
+	if _, err := httpGetOK[server.GetPublicKeyResp](t, createURL(addr, "/v0/get_public_key", url.Values{
+		"time": []string{fmt.Sprint(from.Unix())},
+	})); err != nil {
+		t.Errorf("Failed to get precomputed public key with the secret store down: %+v", err)
+	}
+	status, _, err := httpGet(t, createURL(addr, "/v0/get_public_key", url.Values{
+		"time": []string{fmt.Sprint(from.Add(12 * time.Hour).Unix())},
+	}))
+	if err != nil {
+		t.Fatalf("Failed to get public key: %+v", err)
+	}
+	if status != http.StatusInternalServerError {
+		t.Errorf("get_public_key past the precomputed intervals returned status %d, want %d", status, http.StatusInternalServerError)
+	}
+
+	if _, err := server.NewServer(server.Options{PrecomputeIntervals: -1}); err == nil {
+		t.Errorf("NewServer accepted a negative number of intervals to precompute")
+	}
+}
+
+func TestTransparencyLog(t *testing.T) {
+	s, err := server.NewServer(server.Options{
+		TimeSource: testClock,
+		PKIOptions: keys.PKIOptions{
+			Name:    "Test Transparency Log Server",
+			MinTime: time.Now().Truncate(time.Hour).Add(-2 * time.Hour),
+			MaxTime: time.Now().Truncate(time.Hour).Add(2 * time.Hour),
+		},
+		SecretsDir:      t.TempDir(),
+		TransparencyLog: true,
+	})
+	if err != nil {
+		t.Fatalf("Failed to initialize server: %+v", err)
+	}
+	t.Cleanup(s.Close)
+	mux := http.NewServeMux()
+	s.RegisterHandlers(mux)
+	addr := setupServerWithHandler(t, mux)
+
+	// The log is extended in the background.
+	var head *server.GetLogTreeHeadResp
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		head, err = httpGetOK[server.GetLogTreeHeadResp](t, createURL(addr, "/v0/log_tree_head", nil))
+		if err != nil {
+			t.Fatalf("Failed to get tree head: %+v", err)
+		}
+		if head.TreeSize == 5 || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	if head.TreeSize != 5 {
+		t.Fatalf("Tree head has size %d, want 5", head.TreeSize)
+	}
+	signing, err := httpGetOK[server.GetSigningKeyResp](t, createURL(addr, "/v0/signing_key", nil))
+	if err != nil {
+		t.Fatalf("Failed to get signing key: %+v", err)
+	}
+	signer, err := x509.ParsePKIXPublicKey(signing.SPKI)
+	if err != nil {
+		t.Fatalf("Failed to parse signing key: %+v", err)
+	}
+	pkiID := uuid.MustParse(head.PKIID)
+	timestamp, err := time.Parse(time.RFC3339, head.Timestamp)
+	if err != nil {
+		t.Fatalf("Failed to parse tree head timestamp: %+v", err)
+	}
+	if !ed25519.Verify(signer.(ed25519.PublicKey), keys.TreeHeadMessage(pkiID, head.TreeSize, timestamp, head.RootHash), head.Signature) {
+		t.Errorf("Tree head has an invalid signature")
+	}
+
+	target := time.Now()
+	proof, err := httpGetOK[server.GetLogInclusionProofResp](t, createURL(addr, "/v0/log_inclusion_proof", url.Values{
+		"time": []string{fmt.Sprint(target.Unix())},
+	}))
+	if err != nil {
+		t.Fatalf("Failed to get inclusion proof: %+v", err)
+	}
+	pub, err := httpGetOK[server.GetPublicKeyResp](t, createURL(addr, "/v0/get_public_key", url.Values{
+		"time": []string{fmt.Sprint(target.Truncate(time.Hour).Unix())},
+	}))
+	if err != nil {
+		t.Fatalf("Failed to get public key: %+v", err)
+	}
+	if fingerprint := sha256.Sum256(pub.SPKI); !bytes.Equal(proof.Fingerprint, fingerprint[:]) {
+		t.Errorf("Logged fingerprint %x doesn't match public key fingerprint %x", proof.Fingerprint, fingerprint)
+	}
+	entryTime, err := time.Parse(time.RFC3339, proof.Time)
+	if err != nil {
+		t.Fatalf("Failed to parse entry time: %+v", err)
+	}
+	leaf := keys.LogLeaf(pkiID, keys.LogEntry{Time: entryTime, Fingerprint: proof.Fingerprint})
+	if !keys.VerifyInclusion(keys.LogLeafHash(leaf), proof.LeafIndex, proof.TreeSize, proof.AuditPath, head.RootHash) {
+		t.Errorf("Inclusion proof doesn't verify against tree head")
+	}
+
+	consistency, err := httpGetOK[server.GetLogConsistencyProofResp](t, createURL(addr, "/v0/log_consistency_proof", url.Values{
+		"first": []string{"5"},
+	}))
+	if err != nil {
+		t.Fatalf("Failed to get consistency proof: %+v", err)
+	}
+	if consistency.Second != 5 || len(consistency.Proof) != 0 {
+		t.Errorf("Got consistency proof %+v, want an empty proof up to size 5", *consistency)
+	}
+	status, _, err := httpGet(t, createURL(addr, "/v0/log_consistency_proof", url.Values{
+		"first":  []string{"5"},
+		"second": []string{"4"},
+	}))
+	if err != nil {
+		t.Fatalf("Failed to get consistency proof: %+v", err)
+	}
+	if status != http.StatusBadRequest {
+		t.Errorf("log_consistency_proof with decreasing sizes returned status %d, want %d", status, http.StatusBadRequest)
+	}
+
+	// Servers without transparency logs don't serve them.
+	status, _, err = httpGet(t, createURL(setupServer(t), "/v0/log_tree_head", nil))
+	if err != nil {
+		t.Fatalf("Failed to get tree head: %+v", err)
+	}
+	if status == http.StatusOK {
+		t.Errorf("log_tree_head succeeded without a transparency log")
+	}
+}
+
+func TestRevoke(t *testing.T) {
+	addr := setupServer(t)
+	now := time.Now()
+	info, err := httpPostAdminOK[server.GetInfoResp](t, createURL(addr, "/admin/v0/create_pki", url.Values{
+		"name":     []string{"Revocation PKI"},
+		"min_time": []string{now.Add(-2 * time.Hour).Format(time.RFC3339)},
+		"max_time": []string{now.Add(4 * time.Hour).Format(time.RFC3339)},
+	}))
+	if err != nil {
+		t.Fatalf("Failed to create PKI: %+v", err)
+	}
+	revoked := now.Add(time.Hour)
+	pubQuery := func(t time.Time) url.Values {
+		return url.Values{"pki_id": []string{info.PKIID}, "time": []string{fmt.Sprint(t.Unix())}}
+	}
+
+	// Secrets are generated in the background, so wait for them.
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		status, _, err := httpGet(t, createURL(addr, "/v0/get_public_key", pubQuery(revoked)))
+		if err != nil {
+			t.Fatalf("Failed to get public key: %+v", err)
+		}
+		if status != http.StatusServiceUnavailable || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	resp, err := httpPostAdminOK[server.RevokeResp](t, createURL(addr, "/admin/v0/revoke", url.Values{
+		"pki_id": []string{info.PKIID},
+		"start":  []string{fmt.Sprint(revoked.Unix())},
+		"end":    []string{fmt.Sprint(revoked.Unix())},
+		"reason": []string{"leaked in test"},
+	}))
+	if err != nil {
+		t.Fatalf("Failed to revoke keys: %+v", err)
+	}
+	if want := revoked.UTC().Truncate(time.Hour).Format(time.RFC3339); resp.Start != want {
+		t.Errorf("Revocation starts at %s, want %s", resp.Start, want)
+	}
+
+	status, _, err := httpGet(t, createURL(addr, "/v0/get_public_key", pubQuery(revoked)))
+	if err != nil {
+		t.Fatalf("Failed to get public key: %+v", err)
+	}
+	if status != http.StatusGone {
+		t.Errorf("get_public_key for revoked time returned status %d, want %d", status, http.StatusGone)
+	}
+	if _, err := httpGetOK[server.GetPublicKeyResp](t, createURL(addr, "/v0/get_public_key", pubQuery(revoked.Add(2*time.Hour)))); err != nil {
+		t.Errorf("Failed to get public key outside of revoked range: %+v", err)
+	}
+
+	status, _, err = httpPostAdmin(t, createURL(addr, "/admin/v0/revoke", url.Values{
+		"pki_id": []string{info.PKIID},
+		"start":  []string{fmt.Sprint(revoked.Unix())},
+		"end":    []string{fmt.Sprint(revoked.Add(-time.Hour).Unix())},
+	}), adminToken)
+	if err != nil {
+		t.Fatalf("Failed to revoke keys: %+v", err)
+	}
+	if status != http.StatusBadRequest {
+		t.Errorf("revoke with end before start returned status %d, want %d", status, http.StatusBadRequest)
+	}
+}
+
+func TestSecretMetadata(t *testing.T) {
+	addr := setupServer(t)
+	resp, err := httpPostAdminOK[server.ListSecretMetadataResp](t, createURL(addr, "/admin/v0/secret_metadata", nil))
+	if err != nil {
+		t.Fatalf("Failed to list secret metadata: %+v", err)
+	}
+	if resp.PKIID != testPKI.String() {
+		t.Errorf("Listed secrets of PKI %s, want %s", resp.PKIID, testPKI)
+	}
+	if len(resp.Secrets) == 0 {
+		t.Fatalf("Listed no secrets")
+	}
+	for _, md := range resp.Secrets {
+		if md.Created == "" || md.GeneratorVersion == "" || md.Algorithm != "P-256" || len(md.Checksum) != 64 {
+			t.Errorf("Secret for %s has incomplete metadata %+v", md.Bucket, md)
+		}
+	}
+
+	status, _, err := httpPostAdmin(t, createURL(addr, "/admin/v0/secret_metadata", nil), "wrong")
+	if err != nil {
+		t.Fatalf("Failed to list secret metadata: %+v", err)
+	}
+	if status != http.StatusUnauthorized {
+		t.Errorf("secret_metadata with wrong token returned status %d, want %d", status, http.StatusUnauthorized)
+	}
+}
+
+func TestCORS(t *testing.T) {
+	addr := setupServer(t)
+	target := createURL(addr, "/v0/info", nil)
+
+	for _, tc := range []struct {
+		origin string
+		want   string
+	}{
+		{allowedOrigin, allowedOrigin},
+		{"https://other.example", ""},
+	} {
+		req, err := http.NewRequest(http.MethodGet, target, nil)
+		if err != nil {
+			t.Fatalf("Failed to create request: %+v", err)
+		}
+		req.Header.Set("Origin", tc.origin)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Network error in info: %+v", err)
+		}
+		resp.Body.Close()
+		if got := resp.Header.Get("Access-Control-Allow-Origin"); got != tc.want {
+			t.Errorf("info from origin %s returned Access-Control-Allow-Origin %q, want %q", tc.origin, got, tc.want)
+		}
+	}
+
+	req, err := http.NewRequest(http.MethodOptions, createURL(addr, "/v1/get_public_key", nil), nil)
+	if err != nil {
+		t.Fatalf("Failed to create request: %+v", err)
+	}
+	req.Header.Set("Origin", allowedOrigin)
+	req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Network error in preflight request: %+v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		t.Errorf("Preflight request returned %s, want %s", http.StatusText(resp.StatusCode), http.StatusText(http.StatusNoContent))
+	}
+	for header, want := range map[string]string{
+		"Access-Control-Allow-Origin":  allowedOrigin,
+		"Access-Control-Allow-Methods": "GET, POST",
+		"Access-Control-Max-Age":       "3600",
+	} {
+		if got := resp.Header.Get(header); got != want {
+			t.Errorf("Preflight response has %s %q, want %q", header, got, want)
+		}
+	}
+	if got := resp.Header.Get("Access-Control-Allow-Headers"); !strings.Contains(got, "X-Timecapsule-Time") {
+		t.Errorf("Preflight response has Access-Control-Allow-Headers %q, want it to include X-Timecapsule-Time", got)
+	}
+}
+
+// Sends a GET request with the given headers, returning the response (including its headers) with
+// its body closed.
+func httpGetResponse(t *testing.T, url string, headers map[string]string) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	return resp, nil
+}
+
+func TestCacheHeaders(t *testing.T) {
+	addr := setupServer(t)
+	target := fmt.Sprint(time.Now().Add(-longEnough).Unix())
+
+	pubUrl := createURL(addr, "/v0/get_public_key", url.Values{"time": []string{target}})
+	resp, err := httpGetResponse(t, pubUrl, nil)
+	if err != nil {
+		t.Fatalf("Network error in get_public_key: %+v", err)
+	}
+	if got := resp.Header.Get("Cache-Control"); got != "public, max-age=3600" {
+		t.Errorf("get_public_key returned Cache-Control %q, want a short lifetime that isn't immutable", got)
+	}
+	if resp.Header.Get("Last-Modified") == "" {
+		t.Errorf("get_public_key returned no Last-Modified header")
+	}
+	etag := resp.Header.Get("ETag")
+	if etag == "" {
+		t.Fatalf("get_public_key returned no ETag")
+	}
+
+	resp, err = httpGetResponse(t, pubUrl, map[string]string{"If-None-Match": etag})
+	if err != nil {
+		t.Fatalf("Network error in get_public_key: %+v", err)
+	}
+	if resp.StatusCode != http.StatusNotModified {
+		t.Errorf("Conditional get_public_key returned %s, want %s", http.StatusText(resp.StatusCode), http.StatusText(http.StatusNotModified))
+	}
+
+	resp, err = httpGetResponse(t, createURL(addr, "/v0/get_public_key", url.Values{"in": []string{"1h"}}), nil)
+	if err != nil {
+		t.Fatalf("Network error in get_public_key: %+v", err)
+	}
+	if got := resp.Header.Get("ETag"); got != "" {
+		t.Errorf("get_public_key for a relative time returned ETag %q, want none", got)
+	}
+
+	resp, err = httpGetResponse(t, createURL(addr, "/v0/get_private_key", url.Values{"time": []string{target}}), nil)
+	if err != nil {
+		t.Fatalf("Network error in get_private_key: %+v", err)
+	}
+	if got := resp.Header.Get("Cache-Control"); got != "no-store" {
+		t.Errorf("get_private_key returned Cache-Control %q, want %q", got, "no-store")
+	}
+}
+
+func TestGetPublicKeyTimeFormats(t *testing.T) {
+	addr := setupServer(t)
+	target := time.Now().Add(-longEnough).Truncate(time.Second).UTC()
+	date := time.Date(2025, time.June, 1, 0, 0, 0, 0, time.UTC)
+
+	for _, tc := range []struct {
+		in   string
+		want time.Time
+	}{
+		{fmt.Sprint(target.Unix()), target},
+		{fmt.Sprint(target.UnixMilli()), target},
+		{target.Format(time.RFC3339), target},
+		{target.Format(time.RFC1123), target},
+		{target.In(time.FixedZone("", -7*60*60)).Format(time.RFC1123Z), target},
+		{date.Format(time.DateOnly), date},
+	} {
+		resp, err := httpGetOK[server.GetPublicKeyResp](t, createURL(addr, "/v0/get_public_key", url.Values{
+			"time": []string{tc.in},
+		}))
+		if err != nil {
+			t.Errorf("Failed to get public key for %q: %+v", tc.in, err)
+			continue
+		}
+		if resp.Time != tc.want.Format(time.RFC3339) {
+			t.Errorf("get_public_key parsed %q as %s, want %s", tc.in, resp.Time, tc.want.Format(time.RFC3339))
+		}
+	}
+
+	for _, tc := range []struct {
+		in      string
+		wantErr string
+	}{
+		{"99999999999999999999", "Unix timestamp"},
+		{"Sun, 99 Jun 2025 00:00:00 GMT", "RFC 1123"},
+		{"2025-13-01", "YYYY-MM-DD"},
+		{"yesterday", "RFC 3339"},
+	} {
+		status, body, err := httpGet(t, createURL(addr, "/v0/get_public_key", url.Values{
+			"time": []string{tc.in},
+		}))
+		if err != nil {
+			t.Fatalf("Network error in get_public_key: %+v", err)
+		}
+		if status != http.StatusBadRequest || !strings.Contains(body, tc.wantErr) {
+			t.Errorf("get_public_key for %q returned (%s, %q), want %s mentioning %s", tc.in, http.StatusText(status), body, http.StatusText(http.StatusBadRequest), tc.wantErr)
+		}
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	addr := setupServerWithHandler(t, rateLimitedMux)
+	target := fmt.Sprint(time.Now().Add(-longEnough).Unix())
+	privUrl := createURL(addr, "/v0/get_private_key", url.Values{"time": []string{target}})
+
+	for i := 0; i < 2; i++ {
+		if _, err := httpGetOK[server.GetPrivateKeyResp](t, privUrl); err != nil {
+			t.Fatalf("Private key request %d within burst failed: %+v", i, err)
+		}
+	}
+	status, _, err := httpGet(t, privUrl)
+	if err != nil {
+		t.Fatalf("Network error in get_private_key: %+v", err)
+	}
+	if status != http.StatusTooManyRequests {
+		t.Errorf("get_private_key beyond burst returned %s, want %s", http.StatusText(status), http.StatusText(http.StatusTooManyRequests))
+	}
+
+	// Public key requests aren't subject to the private key limits.
+	if _, err := httpGetOK[server.GetPublicKeyResp](t, createURL(addr, "/v0/get_public_key", url.Values{"time": []string{target}})); err != nil {
+		t.Errorf("Failed to get public key after exhausting private key limit: %+v", err)
+	}
+}
+
+func TestAPIKeys(t *testing.T) {
+	addr := setupServerWithHandler(t, apiKeyMux)
+	target := fmt.Sprint(time.Now().Add(-longEnough).Unix())
+	privUrl := createURL(addr, "/v0/get_private_key", url.Values{"time": []string{target}})
+
+	if _, err := httpGetOK[server.GetPublicKeyResp](t, createURL(addr, "/v0/get_public_key", url.Values{"time": []string{target}})); err != nil {
+		t.Errorf("Failed to get public key without an API key: %+v", err)
+	}
+
+	for _, header := range []http.Header{
+		nil,
+		{"Authorization": []string{"Bearer "}},
+		{"Authorization": []string{"Bearer wrong-key"}},
+	} {
+		status, _, err := httpGetWithHeaders(t, privUrl, header)
+		if err != nil {
+			t.Fatalf("Network error in get_private_key: %+v", err)
+		}
+		if status != http.StatusUnauthorized {
+			t.Errorf("get_private_key with headers %v returned %s, want %s", header, http.StatusText(status), http.StatusText(http.StatusUnauthorized))
+		}
+	}
+
+	status, _, err := httpGetWithHeaders(t, privUrl, http.Header{"Authorization": []string{"Bearer " + apiKey}})
+	if err != nil {
+		t.Fatalf("Network error in get_private_key: %+v", err)
+	}
+	if status != http.StatusOK {
+		t.Errorf("get_private_key with a valid API key returned %s, want %s", http.StatusText(status), http.StatusText(http.StatusOK))
+	}
+}
+
+func TestGRPCAPIKeys(t *testing.T) {
+	s, err := server.NewServer(server.Options{
+		TimeSource: testClock,
+		PKIOptions: keys.PKIOptions{
+			Name:    "Test gRPC API Key Server",
+			MinTime: time.Now().Add(-24 * time.Hour),
+			MaxTime: time.Now().Add(24 * time.Hour),
+		},
+		SecretsDir: t.TempDir(),
+		APIKeys:    []string{apiKey},
+	})
+	if err != nil {
+		t.Fatalf("Failed to initialize server: %+v", err)
+	}
+	t.Cleanup(s.Close)
+	client := setupGRPCWithServer(t, s)
+	req := &timecapsulepb.GetPrivateKeyRequest{
+		Target: &timecapsulepb.GetPrivateKeyRequest_Time{Time: timestamppb.New(time.Now().Add(-longEnough))},
+	}
+
+	for _, md := range []metadata.MD{
+		nil,
+		metadata.Pairs("authorization", "Bearer "),
+		metadata.Pairs("authorization", "Bearer wrong-key"),
+	} {
+		ctx := metadata.NewOutgoingContext(context.Background(), md)
+		if _, err := client.GetPrivateKey(ctx, req); status.Code(err) != codes.Unauthenticated {
+			t.Errorf("GetPrivateKey with metadata %v returned %v, want %v", md, err, codes.Unauthenticated)
+		}
+	}
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer "+apiKey)
+	if _, err := client.GetPrivateKey(ctx, req); err != nil {
+		t.Errorf("GetPrivateKey with a valid API key failed: %+v", err)
+	}
+}
+
+func TestEmptyAPIKey(t *testing.T) {
+	_, err := server.NewServer(server.Options{
+		TimeSource: testClock,
+		PKIOptions: keys.PKIOptions{
+			Name:    "Test Empty API Key Server",
+			MinTime: time.Now().Add(-24 * time.Hour),
+			MaxTime: time.Now().Add(24 * time.Hour),
+		},
+		SecretsDir: t.TempDir(),
+		APIKeys:    []string{apiKey, ""},
+	})
+	if err == nil {
+		t.Errorf("NewServer accepted an empty API key")
+	}
+}
+
+// Issues a client certificate for the given DNS name, signed by the given CA.
+func issueClientCert(t *testing.T, ca *x509.Certificate, caKey *ecdsa.PrivateKey, dnsName string) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate client key: %+v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: dnsName},
+		DNSNames:     []string{dnsName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca, &key.PublicKey, caKey)
+	if err != nil {
+		t.Fatalf("Failed to issue client certificate: %+v", err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func TestClientCerts(t *testing.T) {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate CA key: %+v", err)
+	}
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test Client CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatalf("Failed to create CA certificate: %+v", err)
+	}
+	ca, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		t.Fatalf("Failed to parse CA certificate: %+v", err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(ca)
+
+	s, err := server.NewServer(server.Options{
+		TimeSource: testClock,
+		PKIOptions: keys.PKIOptions{
+			Name:    "Test Client Cert Server",
+			MinTime: time.Now().Add(-24 * time.Hour),
+			MaxTime: time.Now().Add(24 * time.Hour),
+		},
+		SecretsDir: t.TempDir(),
+		ClientCerts: server.ClientCertOptions{
+			Required:    true,
+			AllowedSANs: []string{"allowed.client"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to initialize server: %+v", err)
+	}
+	t.Cleanup(s.Close)
+	mux := http.NewServeMux()
+	s.RegisterHandlers(mux)
+	ts := httptest.NewUnstartedServer(mux)
+	ts.TLS = &tls.Config{ClientCAs: pool, ClientAuth: tls.VerifyClientCertIfGiven}
+	ts.StartTLS()
+	t.Cleanup(ts.Close)
+
+	target := fmt.Sprint(time.Now().Add(-longEnough).Unix())
+	pubUrl := ts.URL + "/v0/get_public_key?" + url.Values{"time": []string{target}}.Encode()
+	privUrl := ts.URL + "/v0/get_private_key?" + url.Values{"time": []string{target}}.Encode()
+
+	for _, tc := range []struct {
+		name       string
+		certs      []tls.Certificate
+		url        string
+		wantStatus int
+	}{
+		{"public key without certificate", nil, pubUrl, http.StatusOK},
+		{"private key without certificate", nil, privUrl, http.StatusForbidden},
+		{"private key with disallowed certificate", []tls.Certificate{issueClientCert(t, ca, caKey, "other.client")}, privUrl, http.StatusForbidden},
+		{"private key with allowed certificate", []tls.Certificate{issueClientCert(t, ca, caKey, "allowed.client")}, privUrl, http.StatusOK},
+	} {
+		// Use a fresh transport for each case so that connections with other certificates aren't reused.
+		transport := ts.Client().Transport.(*http.Transport).Clone()
+		transport.TLSClientConfig.Certificates = tc.certs
+		client := &http.Client{Transport: transport}
+		resp, err := client.Get(tc.url)
+		if err != nil {
+			t.Fatalf("%s: network error: %+v", tc.name, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tc.wantStatus {
+			t.Errorf("%s: got %s, want %s", tc.name, http.StatusText(resp.StatusCode), http.StatusText(tc.wantStatus))
+		}
+	}
+}
+
+func TestListenAndServe(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "timecapsule.sock")
+	tcpListener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to find a free port: %+v", err)
+	}
+	tcpAddr := tcpListener.Addr().String()
+	tcpListener.Close()
+
+	s, err := server.NewServer(server.Options{
+		TimeSource: testClock,
+		PKIOptions: keys.PKIOptions{
+			Name:    "Test Listener Server",
+			MinTime: time.Now().Add(-24 * time.Hour),
+			MaxTime: time.Now().Add(24 * time.Hour),
+		},
+		SecretsDir: t.TempDir(),
+		Listeners: []server.ListenerConfig{
+			{Network: "unix", Address: socket},
+			{Network: "tcp", Address: tcpAddr},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to initialize server: %+v", err)
+	}
+	t.Cleanup(s.Close)
+	mux := http.NewServeMux()
+	s.RegisterHandlers(mux)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- s.ListenAndServe(ctx, mux)
+	}()
+
+	unixClient := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", socket)
+		},
+	}}
+	for _, tc := range []struct {
+		name   string
+		client *http.Client
+		url    string
+	}{
+		{"Unix socket", unixClient, "http://unix/v0/info"},
+		{"TCP", http.DefaultClient, createURL(tcpAddr, "/v0/info", nil)},
+	} {
+		// The listeners may not be open yet, so retry briefly.
+		var resp *http.Response
+		for range 50 {
+			resp, err = tc.client.Get(tc.url)
+			if err == nil {
+				break
+			}
+			time.Sleep(100 * time.Millisecond)
+		}
+		if err != nil {
+			t.Errorf("Failed to reach server over %s: %+v", tc.name, err)
+			continue
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("info over %s returned %s, want %s", tc.name, http.StatusText(resp.StatusCode), http.StatusText(http.StatusOK))
+		}
+	}
+
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("ListenAndServe failed: %+v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Errorf("ListenAndServe did not return after its context was canceled")
+	}
+}
+
+func TestOpenAPI(t *testing.T) {
+	addr := setupServer(t)
+	doc, err := httpGetOK[map[string]any](t, createURL(addr, "/openapi.json", nil))
+	if err != nil {
+		t.Fatalf("Failed to get OpenAPI document: %+v", err)
+	}
+	if !strings.HasPrefix(fmt.Sprint((*doc)["openapi"]), "3.") {
+		t.Errorf("OpenAPI document has version %v, want 3.x", (*doc)["openapi"])
+	}
+
+	paths, _ := (*doc)["paths"].(map[string]any)
+	for path, method := range map[string]string{
+		"/v0/get_public_key":  "get",
+		"/v0/get_private_key": "get",
+		"/v0/get_public_keys": "post",
+		"/v1/get_private_key": "post",
+	} {
+		item, _ := paths[path].(map[string]any)
+		if _, ok := item[method]; !ok {
+			t.Errorf("OpenAPI document does not describe %s %s", strings.ToUpper(method), path)
+		}
+	}
+
+	components, _ := (*doc)["components"].(map[string]any)
+	schemas, _ := components["schemas"].(map[string]any)
+	schema, _ := schemas["GetPublicKeyResp"].(map[string]any)
+	properties, _ := schema["properties"].(map[string]any)
+	// Fields of embedded structs are flattened, as in the JSON encoding.
+	for _, property := range []string{"spki", "signature", "interval"} {
+		if _, ok := properties[property]; !ok {
+			t.Errorf("OpenAPI schema of GetPublicKeyResp has no property %q", property)
+		}
+	}
+}
+
+func TestGetPublicKeyBundle(t *testing.T) {
+	addr := setupServer(t)
+	from := time.Date(2025, time.January, 1, 0, 0, 0, 0, time.UTC)
+	to := from.Add(3 * time.Hour)
+	query := url.Values{
+		"from": []string{from.Format(time.RFC3339)},
+		"to":   []string{to.Format(time.RFC3339)},
+	}
+
+	bundle, err := httpGetOK[server.PublicKeyBundle](t, createURL(addr, "/v0/get_public_key_bundle", query))
+	if err != nil {
+		t.Fatalf("Failed to get public key bundle: %+v", err)
+	}
+	if len(bundle.Keys) != 4 {
+		t.Fatalf("Bundle has %d keys, want 4", len(bundle.Keys))
+	}
+	signingKey, err := httpGetOK[server.GetSigningKeyResp](t, createURL(addr, "/v0/signing_key", nil))
+	if err != nil {
+		t.Fatalf("Failed to get signing key: %+v", err)
+	}
+	pub, err := x509.ParsePKIXPublicKey(signingKey.SPKI)
+	if err != nil {
+		t.Fatalf("Signing key is invalid: %+v", err)
+	}
+	pkiID := uuid.MustParse(bundle.PKIID)
+	for _, e := range bundle.Keys {
+		tm, err := time.Parse(time.RFC3339, e.Time)
+		if err != nil {
+			t.Fatalf("Bundle has invalid time %q: %+v", e.Time, err)
+		}
+		if !keys.VerifyPublicKeySignature(pub.(ed25519.PublicKey), pkiID, tm, e.SPKI, e.Signature) {
+			t.Errorf("Signature of bundled key for %s does not verify", e.Time)
+		}
+	}
+
+	query.Set("format", "pem")
+	status, body, err := httpGet(t, createURL(addr, "/v0/get_public_key_bundle", query))
+	if err != nil {
+		t.Fatalf("Network error in get_public_key_bundle: %+v", err)
+	}
+	if status != http.StatusOK {
+		t.Fatalf("get_public_key_bundle in PEM returned %s", http.StatusText(status))
+	}
+	rest := []byte(body)
+	for i, e := range bundle.Keys {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			t.Fatalf("PEM bundle has %d keys, want %d", i, len(bundle.Keys))
+		}
+		if block.Headers["Time"] != e.Time || !bytes.Equal(block.Bytes, e.SPKI) {
+			t.Errorf("PEM bundle key %d is for %s, want %s", i, block.Headers["Time"], e.Time)
+		}
+	}
+}
+
+func TestUnlockFeed(t *testing.T) {
+	addr := setupServer(t)
+
+	status, body, err := httpGet(t, createURL(addr, "/v0/unlock_feed", nil))
+	if err != nil {
+		t.Fatalf("Network error in unlock_feed: %+v", err)
+	}
+	if status != http.StatusOK {
+		t.Fatalf("unlock_feed returned %s", http.StatusText(status))
+	}
+	var feed struct {
+		ID      string `xml:"id"`
+		Entries []struct {
+			ID   string `xml:"id"`
+			Link struct {
+				Href string `xml:"href,attr"`
+			} `xml:"link"`
+		} `xml:"entry"`
+	}
+	if err := xml.Unmarshal([]byte(body), &feed); err != nil {
+		t.Fatalf("Feed is invalid XML: %+v", err)
+	}
+	if len(feed.Entries) == 0 {
+		t.Fatalf("Feed has no entries")
+	}
+
+	// The newest entry links to the private key for an interval that has been released.
+	link, err := url.Parse(feed.Entries[0].Link.Href)
+	if err != nil {
+		t.Fatalf("Feed entry has invalid link %q: %+v", feed.Entries[0].Link.Href, err)
+	}
+	if _, err := httpGetOK[server.GetPrivateKeyResp](t, createURL(addr, link.Path, link.Query())); err != nil {
+		t.Errorf("Failed to get private key linked from feed: %+v", err)
+	}
+	if len(feed.Entries) > 1 && feed.Entries[0].ID == feed.Entries[1].ID {
+		t.Errorf("Feed entries have duplicate ID %q", feed.Entries[0].ID)
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	addr := setupServer(t)
+	target := createURL(addr, "/v0/info", nil)
+
+	ids := map[string]bool{}
+	for range 2 {
+		resp, err := httpGetResponse(t, target, nil)
+		if err != nil {
+			t.Fatalf("Network error in info: %+v", err)
+		}
+		id := resp.Header.Get("X-Request-ID")
+		if id == "" || ids[id] {
+			t.Errorf("info returned request ID %q, want a new one", id)
+		}
+		ids[id] = true
+	}
+
+	for _, tc := range []struct {
+		sent string
+		keep bool
+	}{
+		{"proxy-assigned.123", true},
+		{"bad id; with spaces", false},
+		{strings.Repeat("a", 65), false},
+	} {
+		resp, err := httpGetResponse(t, target, map[string]string{"X-Request-ID": tc.sent})
+		if err != nil {
+			t.Fatalf("Network error in info: %+v", err)
+		}
+		if got := resp.Header.Get("X-Request-ID"); (got == tc.sent) != tc.keep || got == "" {
+			t.Errorf("info with request ID %q returned %q, want kept = %v", tc.sent, got, tc.keep)
+		}
+	}
+}
+
+func TestErrorLogRequestID(t *testing.T) {
+	clk := clock.NewManualClock(time.Now())
+	clk.SetError(errors.New("clock is stale"))
+	s, err := server.NewServer(server.Options{
+		TimeSource: clk,
+		PKIOptions: keys.PKIOptions{
+			Name:    "Test Error Log Server",
+			MinTime: time.Now().Add(-24 * time.Hour),
+			MaxTime: time.Now().Add(24 * time.Hour),
+		},
+		SecretsDir: t.TempDir(),
+	})
+	if err != nil {
+		t.Fatalf("Failed to initialize server: %+v", err)
+	}
+	t.Cleanup(s.Close)
+	mux := http.NewServeMux()
+	s.RegisterHandlers(mux)
+	addr := setupServerWithHandler(t, mux)
+	client := setupGRPCWithServer(t, s)
+
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	if _, err := httpGetResponse(t, createURL(addr, "/v0/now", nil), map[string]string{"X-Request-ID": "http-error"}); err != nil {
+		t.Fatalf("Network error in now: %+v", err)
+	}
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "x-request-id", "grpc-error")
+	if _, err := client.GetPrivateKey(ctx, &timecapsulepb.GetPrivateKeyRequest{
+		Target: &timecapsulepb.GetPrivateKeyRequest_Time{Time: timestamppb.New(time.Now().Add(-longEnough))},
+	}); status.Code(err) != codes.Unavailable {
+		t.Errorf("GetPrivateKey without a secure time returned %v, want %v", err, codes.Unavailable)
+	}
+
+	log.SetOutput(os.Stderr)
+	for _, id := range []string{"http-error", "grpc-error"} {
+		if !strings.Contains(logs.String(), fmt.Sprintf("ERROR: Failed to determine the current time securely in request %s:", id)) {
+			t.Errorf("Error logs don't name request %s:\n%s", id, logs.String())
+		}
+	}
+}
+
+func TestCompression(t *testing.T) {
+	addr := setupServer(t)
+	from := time.Date(2025, time.January, 1, 0, 0, 0, 0, time.UTC)
+	target := createURL(addr, "/v0/get_public_key_bundle", url.Values{
+		"from": []string{from.Format(time.RFC3339)},
+		"to":   []string{from.Add(24 * time.Hour).Format(time.RFC3339)},
+	})
+
+	// Setting Accept-Encoding explicitly stops the transport from decompressing transparently.
+	req, err := http.NewRequest(http.MethodGet, target, nil)
+	if err != nil {
+		t.Fatalf("Failed to create request: %+v", err)
+	}
+	req.Header.Set("Accept-Encoding", "gzip")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Network error in get_public_key_bundle: %+v", err)
+	}
+	defer resp.Body.Close()
+	if got := resp.Header.Get("Content-Encoding"); got != "gzip" {
+		t.Fatalf("get_public_key_bundle returned Content-Encoding %q, want gzip", got)
+	}
+	if !strings.HasSuffix(resp.Header.Get("ETag"), `-gzip"`) {
+		t.Errorf("Compressed get_public_key_bundle returned ETag %q, want a distinct one", resp.Header.Get("ETag"))
+	}
+	gz, err := gzip.NewReader(resp.Body)
+	if err != nil {
+		t.Fatalf("get_public_key_bundle returned invalid gzip: %+v", err)
+	}
+	var bundle server.PublicKeyBundle
+	if err := json.NewDecoder(gz).Decode(&bundle); err != nil {
+		t.Fatalf("Failed to decode compressed bundle: %+v", err)
+	}
+	if len(bundle.Keys) != 25 {
+		t.Errorf("Compressed bundle has %d keys, want 25", len(bundle.Keys))
+	}
+
+	resp, err = httpGetResponse(t, target, map[string]string{"Accept-Encoding": "identity"})
+	if err != nil {
+		t.Fatalf("Network error in get_public_key_bundle: %+v", err)
+	}
+	if got := resp.Header.Get("Content-Encoding"); got != "" {
+		t.Errorf("get_public_key_bundle without gzip returned Content-Encoding %q", got)
+	}
+}
+
+func TestHandlerMiddleware(t *testing.T) {
+	var order []string
+	middleware := func(name string) server.Middleware {
+		return func(h http.Handler) http.Handler {
+			return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+				order = append(order, name)
+				resp.Header().Add("X-Middleware", name)
+				h.ServeHTTP(resp, req)
+			})
+		}
+	}
+	handler := testServer.Handler(middleware("outer"), middleware("inner"))
+	addr := setupServerWithHandler(t, http.StripPrefix("/timecapsule", handler))
+
+	resp, err := httpGetResponse(t, createURL(addr, "/timecapsule/v0/info", nil), nil)
+	if err != nil {
+		t.Fatalf("Network error in info: %+v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("info returned %s", http.StatusText(resp.StatusCode))
+	}
+	if got, want := strings.Join(order, ","), "outer,inner"; got != want {
+		t.Errorf("Middleware ran in order %q, want %q", got, want)
+	}
+	if got := resp.Header.Values("X-Middleware"); len(got) != 2 {
+		t.Errorf("info returned X-Middleware %q, want both middleware", got)
+	}
+}
+
+func TestPublicOnly(t *testing.T) {
+	// Public-only servers run no secure clock, so no NTS servers are given.
+	s, err := server.NewServer(server.Options{
+		PKIOptions: keys.PKIOptions{
+			Name:    "Test Mirror",
+			MinTime: minTime,
+			MaxTime: maxTime,
+		},
+		SecretsDir: t.TempDir(),
+		PublicOnly: true,
+	})
+	if err != nil {
+		t.Fatalf("Failed to initialize public-only server: %+v", err)
+	}
+	t.Cleanup(s.Close)
+	addr := setupServerWithHandler(t, s.Handler())
+	target := url.Values{"time": []string{fmt.Sprint(time.Now().Add(-longEnough).Unix())}}
+
+	if _, err := httpGetOK[server.GetPublicKeyResp](t, createURL(addr, "/v0/get_public_key", target)); err != nil {
+		t.Errorf("Failed to get public key from public-only server: %+v", err)
+	}
+	for _, tc := range []struct {
+		path  string
+		query url.Values
+		want  int
+	}{
+		{"/v0/get_private_key", target, http.StatusForbidden},
+		{"/v0/get_private_key", url.Values{"time": target["time"], "wait": []string{"true"}}, http.StatusForbidden},
+		// Only the CORS preflight route matches, so the method isn't allowed.
+		{"/v0/now", nil, http.StatusMethodNotAllowed},
+		{"/v0/get_public_key", url.Values{"in": []string{"1h"}}, http.StatusBadRequest},
+	} {
+		status, body, err := httpGet(t, createURL(addr, tc.path, tc.query))
+		if err != nil {
+			t.Fatalf("Network error in %s: %+v", tc.path, err)
+		}
+		if status != tc.want {
+			t.Errorf("%s?%s on public-only server returned %s (%q), want %s", tc.path, tc.query.Encode(), http.StatusText(status), body, http.StatusText(tc.want))
+		}
+	}
+}
+
+func TestUnlockCalendar(t *testing.T) {
+	addr := setupServer(t)
+	from := time.Date(2025, time.January, 1, 0, 0, 0, 0, time.UTC)
+	query := url.Values{
+		"from": []string{from.Format(time.RFC3339)},
+		"to":   []string{from.Add(3 * time.Hour).Format(time.RFC3339)},
+	}
+
+	status, body, err := httpGet(t, createURL(addr, "/v0/unlocks.ics", query))
+	if err != nil {
+		t.Fatalf("Network error in unlocks.ics: %+v", err)
+	}
+	if status != http.StatusOK {
+		t.Fatalf("unlocks.ics returned %s", http.StatusText(status))
+	}
+	lines := strings.Split(strings.TrimSuffix(body, "\r\n"), "\r\n")
+	if lines[0] != "BEGIN:VCALENDAR" || lines[len(lines)-1] != "END:VCALENDAR" {
+		t.Errorf("unlocks.ics returned a body that isn't a calendar:\n%s", body)
+	}
+	var starts []string
+	for _, line := range lines {
+		if len(line) > 75 {
+			t.Errorf("unlocks.ics returned an unfolded line of %d octets: %q", len(line), line)
+		}
+		if start, ok := strings.CutPrefix(line, "DTSTART:"); ok {
+			starts = append(starts, start)
+		}
+	}
+	want := []string{"20250101T000000Z", "20250101T010000Z", "20250101T020000Z", "20250101T030000Z"}
+	if strings.Join(starts, ",") != strings.Join(want, ",") {
+		t.Errorf("unlocks.ics has events starting at %q, want %q", starts, want)
+	}
+}
+
+func TestGetKeyPairAlgorithms(t *testing.T) {
+	addr := setupServer(t)
+	minTime := time.Now().Add(-2 * time.Hour)
+	maxTime := time.Now().Add(2 * time.Hour)
+	target := fmt.Sprint(time.Now().Add(-longEnough).Unix())
+
+	for _, tc := range []struct {
+		alg string
+		crv string
+	}{
+		{"P-384", "P-384"},
+		{"Ed25519", "Ed25519"},
+	} {
+		info, err := httpPostAdminOK[server.GetInfoResp](t, createURL(addr, "/admin/v0/create_pki", url.Values{
+			"name":      []string{tc.alg + " PKI"},
+			"min_time":  []string{minTime.Format(time.RFC3339)},
+			"max_time":  []string{maxTime.Format(time.RFC3339)},
+			"algorithm": []string{tc.alg},
+		}))
+		if err != nil {
+			t.Fatalf("Failed to create %s PKI: %+v", tc.alg, err)
+		}
+		query := url.Values{"pki_id": []string{info.PKIID}, "time": []string{target}}
+
+		// Secrets are generated in the background, so wait for them.
+		deadline := time.Now().Add(10 * time.Second)
+		for {
+			status, _, err := httpGet(t, createURL(addr, "/v0/get_public_key", query))
+			if err != nil {
+				t.Fatalf("Failed to get %s public key: %+v", tc.alg, err)
+			}
+			if status != http.StatusServiceUnavailable || time.Now().After(deadline) {
+				break
+			}
+			time.Sleep(100 * time.Millisecond)
+		}
+
+		pubResp, err := httpGetOK[server.GetPublicKeyResp](t, createURL(addr, "/v0/get_public_key", query))
+		if err != nil {
+			t.Fatalf("Failed to get %s public key: %+v", tc.alg, err)
+		}
+		if pubResp.Algorithm != tc.alg {
+			t.Errorf("%s public key has algorithm %q", tc.alg, pubResp.Algorithm)
+		}
+		pub, err := keys.ParsePublicKeyAsSPKIDER(pubResp.SPKI)
+		if err != nil {
+			t.Fatalf("%s public key is invalid: %+v", tc.alg, err)
+		}
+		privResp, err := httpGetOK[server.GetPrivateKeyResp](t, createURL(addr, "/v0/get_private_key", query))
+		if err != nil {
+			t.Fatalf("Failed to get %s private key: %+v", tc.alg, err)
+		}
+		priv, err := keys.ParsePrivateKeyAsPKCS8DER(privResp.PKCS8)
+		if err != nil {
+			t.Fatalf("%s private key is invalid: %+v", tc.alg, err)
+		}
+		if !pub.(interface{ Equal(crypto.PublicKey) bool }).Equal(priv.Public()) {
+			t.Errorf("%s public and private keys do not match", tc.alg)
+		}
+
+		query.Set("format", "jwk")
+		jwk, err := httpGetOK[keys.JWK](t, createURL(addr, "/v0/get_public_key", query))
+		if err != nil {
+			t.Fatalf("Failed to get %s public key as JWK: %+v", tc.alg, err)
+		}
+		if jwk.Crv != tc.crv {
+			t.Errorf("%s public key JWK has curve %q, want %q", tc.alg, jwk.Crv, tc.crv)
+		}
+	}
+}
+
+func TestGetMLKEMKeyPair(t *testing.T) {
+	addr := setupServer(t)
+	target := time.Now().Add(-longEnough)
+	pubUrl := createURL(addr, "/v0/get_public_mlkem_key", url.Values{
+		"time": []string{fmt.Sprint(target.Unix())},
+	})
+	privUrl := createURL(addr, "/v0/get_private_mlkem_key", url.Values{
+		"time": []string{fmt.Sprint(target.Unix())},
+	})
+
+	pubResp, err := httpGetOK[server.GetPublicMLKEMKeyResp](t, pubUrl)
+	if err != nil {
+		t.Fatalf("Failed to get ML-KEM public key for %s: %+v", target.Format(time.RFC3339), err)
+	}
+	ek, err := mlkem.NewEncapsulationKey768(pubResp.EncapsulationKey)
+	if err != nil {
+		t.Fatalf("get_public_mlkem_key returned invalid key: %+v", err)
+	}
+
+	privResp, err := httpGetOK[server.GetPrivateMLKEMKeyResp](t, privUrl)
+	if err != nil {
+		t.Fatalf("Failed to get ML-KEM private key for %s: %+v", target.Format(time.RFC3339), err)
+	}
+	dk, err := mlkem.NewDecapsulationKey768(privResp.Seed)
+	if err != nil {
+		t.Fatalf("get_private_mlkem_key returned invalid key: %+v", err)
+	}
+
+	shared, ciphertext := ek.Encapsulate()
+	got, err := dk.Decapsulate(ciphertext)
+	if err != nil {
+		t.Fatalf("Failed to decapsulate: %+v", err)
+	}
+	if !bytes.Equal(got, shared) {
+		t.Errorf("ML-KEM private key for %s does not correspond to public key", target.Format(time.RFC3339))
+	}
+
+	// The ML-KEM key is separate from the classical key for the same time.
+	classical, err := httpGetOK[server.GetPublicKeyResp](t, createURL(addr, "/v0/get_public_key", url.Values{
+		"time": []string{fmt.Sprint(target.Unix())},
+	}))
+	if err != nil {
+		t.Fatalf("Failed to get public key for %s: %+v", target.Format(time.RFC3339), err)
+	}
+	if bytes.Equal(classical.SPKI, pubResp.EncapsulationKey) {
+		t.Errorf("ML-KEM key is the same as the classical key")
+	}
+
+	future := createURL(addr, "/v0/get_private_mlkem_key", url.Values{
+		"time": []string{fmt.Sprint(time.Now().Add(longEnough).Unix())},
+	})
+	if status, _, err := httpGet(t, future); err != nil || status != http.StatusForbidden {
+		t.Errorf("get_private_mlkem_key for a future time returned (%d, %v), want status %d", status, err, http.StatusForbidden)
+	}
+}
+
+func TestHybridCapsule(t *testing.T) {
+	addr := setupServer(t)
+	target := time.Now().Add(-longEnough)
+	query := url.Values{
+		"time":   []string{fmt.Sprint(target.Unix())},
+		"hybrid": []string{"true"},
+	}
+
+	pubResp, err := httpGetOK[server.GetPublicKeyResp](t, createURL(addr, "/v0/get_public_key", query))
+	if err != nil {
+		t.Fatalf("Failed to get hybrid public key for %s: %+v", target.Format(time.RFC3339), err)
+	}
+	pub, err := keys.ParseECDHPublicKeyAsSPKIDER(pubResp.SPKI)
+	if err != nil {
+		t.Fatalf("get_public_key returned invalid key: %+v", err)
+	}
+	ek, err := mlkem.NewEncapsulationKey768(pubResp.MLKEMEncapsulationKey)
+	if err != nil {
+		t.Fatalf("get_public_key returned invalid ML-KEM key: %+v", err)
+	}
+	signer, err := httpGetOK[server.GetSigningKeyResp](t, createURL(addr, "/v0/signing_key", nil))
+	if err != nil {
+		t.Fatalf("Failed to get signing key: %+v", err)
+	}
+	signingKey, err := x509.ParsePKIXPublicKey(signer.SPKI)
+	if err != nil {
+		t.Fatalf("signing_key returned invalid key: %+v", err)
+	}
+	pkiID := uuid.MustParse(pubResp.PKIID)
+	if !keys.VerifyPublicKeySignature(signingKey.(ed25519.PublicKey), pkiID, target.Truncate(time.Second), pubResp.MLKEMEncapsulationKey, pubResp.MLKEMSignature) {
+		t.Errorf("ML-KEM key signature does not verify")
+	}
+
+	msg := []byte("hello from the past")
+	enc, ciphertext, err := keys.SealHybrid(pub, ek, msg)
+	if err != nil {
+		t.Fatalf("Failed to seal hybrid capsule: %+v", err)
+	}
+
+	privResp, err := httpGetOK[server.GetPrivateKeyResp](t, createURL(addr, "/v0/get_private_key", query))
+	if err != nil {
+		t.Fatalf("Failed to get hybrid private key for %s: %+v", target.Format(time.RFC3339), err)
+	}
+	priv, err := keys.ParseECDHPrivateKeyAsPKCS8DER(privResp.PKCS8)
+	if err != nil {
+		t.Fatalf("get_private_key returned invalid key: %+v", err)
+	}
+	dk, err := mlkem.NewDecapsulationKey768(privResp.MLKEMSeed)
+	if err != nil {
+		t.Fatalf("get_private_key returned invalid ML-KEM key: %+v", err)
+	}
+	got, err := keys.OpenHybrid(priv, dk, enc, ciphertext)
+	if err != nil {
+		t.Fatalf("Failed to open hybrid capsule: %+v", err)
+	}
+	if !bytes.Equal(got, msg) {
+		t.Errorf("Hybrid capsule opened to %q, want %q", got, msg)
+	}
+
+	// Hybrid responses are only available as JSON.
+	pemQuery := url.Values{"format": []string{"pem"}}
+	for k, v := range query {
+		pemQuery[k] = v
+	}
+	if status, _, err := httpGet(t, createURL(addr, "/v0/get_public_key", pemQuery)); err != nil || status != http.StatusBadRequest {
+		t.Errorf("Hybrid PEM request returned (%d, %v), want status %d", status, err, http.StatusBadRequest)
+	}
+}
+
+func TestGetSigningKeyPair(t *testing.T) {
+	addr := setupServer(t)
+	target := time.Now().Add(-longEnough)
+	query := url.Values{
+		"time": []string{fmt.Sprint(target.Unix())},
+	}
+
+	pubResp, err := httpGetOK[server.GetPublicSigningKeyResp](t, createURL(addr, "/v0/get_public_signing_key", query))
+	if err != nil {
+		t.Fatalf("Failed to get public signing key for %s: %+v", target.Format(time.RFC3339), err)
+	}
+	pub, err := x509.ParsePKIXPublicKey(pubResp.SPKI)
+	if err != nil {
+		t.Fatalf("get_public_signing_key returned invalid key: %+v", err)
+	}
+	privResp, err := httpGetOK[server.GetPrivateSigningKeyResp](t, createURL(addr, "/v0/get_private_signing_key", query))
+	if err != nil {
+		t.Fatalf("Failed to get private signing key for %s: %+v", target.Format(time.RFC3339), err)
+	}
+	priv, err := x509.ParsePKCS8PrivateKey(privResp.PKCS8)
+	if err != nil {
+		t.Fatalf("get_private_signing_key returned invalid key: %+v", err)
+	}
+
+	msg := []byte("attested after the fact")
+	sig := ed25519.Sign(priv.(ed25519.PrivateKey), msg)
+	if !ed25519.Verify(pub.(ed25519.PublicKey), msg, sig) {
+		t.Errorf("Private signing key for %s does not correspond to public signing key", target.Format(time.RFC3339))
+	}
+
+	// The signing key is separate from the PKI's key for the same time.
+	classical, err := httpGetOK[server.GetPublicKeyResp](t, createURL(addr, "/v0/get_public_key", query))
+	if err != nil {
+		t.Fatalf("Failed to get public key for %s: %+v", target.Format(time.RFC3339), err)
+	}
+	if bytes.Equal(classical.SPKI, pubResp.SPKI) {
+		t.Errorf("Signing key is the same as the PKI's key")
+	}
+
+	future := createURL(addr, "/v0/get_private_signing_key", url.Values{
+		"time": []string{fmt.Sprint(time.Now().Add(longEnough).Unix())},
+	})
+	if status, _, err := httpGet(t, future); err != nil || status != http.StatusForbidden {
+		t.Errorf("get_private_signing_key for a future time returned (%d, %v), want status %d", status, err, http.StatusForbidden)
+	}
+}
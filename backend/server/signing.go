@@ -0,0 +1,140 @@
+package server
+
+import (
+	"crypto/ed25519"
+	"crypto/x509"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/newgrp/timecapsule/keys"
+)
+
+type GetSigningKeyResp struct {
+	PKIName string `json:"pkiName"`
+	PKIID   string `json:"pkiID"`
+	KeyID   string `json:"keyID"`
+	// DER-encoded SubjectPublicKeyInfo of the Ed25519 signing key.
+	SPKI []byte `json:"spki"`
+}
+
+type GetPublicSigningKeyResp struct {
+	PKIName string `json:"pkiName"`
+	PKIID   string `json:"pkiID"`
+	// Target time of the key, in RFC 3339 format.
+	Time string `json:"time"`
+	// DER-encoded SubjectPublicKeyInfo of the Ed25519 signing key for the time.
+	SPKI []byte `json:"spki"`
+
+	// Signature over (PKI ID, time, SPKI) by the PKI signing key, as in GetPublicKeyResp.
+	Signature    []byte `json:"signature"`
+	SigningKeyID string `json:"signingKeyID"`
+}
+
+type GetPrivateSigningKeyResp struct {
+	PKIName string `json:"pkiName"`
+	PKIID   string `json:"pkiID"`
+	// DER-encoded PKCS #8 message of the Ed25519 signing key for the time.
+	PKCS8 []byte `json:"pkcs8"`
+
+	Attestation *ReleaseAttestation `json:"attestation"`
+}
+
+// Simple handler for signing key requests.
+func (s *Server) getSigningKey(query url.Values) (*GetSigningKeyResp, int, string) {
+	km, status, message := s.selectPKI(query)
+	if status != http.StatusOK {
+		return nil, status, message
+	}
+	der, err := x509.MarshalPKIXPublicKey(km.SigningPublicKey())
+	if err != nil {
+		log.Printf("ERROR: Failed to marshal signing key in request %s: %+v", query.Get(argRequestID), err)
+		return nil, http.StatusInternalServerError, "Server failed to retrieve signing key"
+	}
+	return &GetSigningKeyResp{
+		PKIName: km.Name(),
+		PKIID:   km.PKIID().String(),
+		KeyID:   km.SigningKeyID(),
+		SPKI:    der,
+	}, http.StatusOK, ""
+}
+
+// Retrieves a PKI's per-interval signing key for the given time, encoded with the given function.
+// Returns (DER, HTTP status code, error message).
+func timeSigningKey(km *keys.KeyManager, t time.Time, reqID string, marshal func(ed25519.PrivateKey) ([]byte, error)) ([]byte, int, string) {
+	const internalError = "Server failed to retrieve signing key"
+
+	priv, err := km.GetSigningKeyForTime(t)
+	if errors.Is(err, keys.ErrNotReady) {
+		return nil, http.StatusServiceUnavailable, notReadyError
+	}
+	if errors.Is(err, keys.ErrSecretDeleted) {
+		return nil, http.StatusGone, deletedError
+	}
+	if errors.Is(err, keys.ErrRevoked) {
+		return nil, http.StatusGone, revokedError
+	}
+	if err != nil {
+		log.Printf("ERROR: Failed to retrieve signing key for time %s in request %s: %+v", t.Format(time.RFC3339), reqID, err)
+		return nil, http.StatusInternalServerError, internalError
+	}
+	der, err := marshal(priv)
+	if err != nil {
+		log.Printf("ERROR: Failed to marshal signing key for time %s in request %s: %+v", t.Format(time.RFC3339), reqID, err)
+		return nil, http.StatusInternalServerError, internalError
+	}
+	return der, http.StatusOK, ""
+}
+
+// Simple handler for per-interval public signing key requests.
+func (s *Server) getPublicSigningKey(query url.Values) (*GetPublicSigningKeyResp, int, string) {
+	km, t, status, message := s.parseTarget(query)
+	if status != http.StatusOK {
+		return nil, status, message
+	}
+	der, status, message := timeSigningKey(km, t, query.Get(argRequestID), func(priv ed25519.PrivateKey) ([]byte, error) {
+		return x509.MarshalPKIXPublicKey(priv.Public())
+	})
+	if status != http.StatusOK {
+		return nil, status, message
+	}
+	return &GetPublicSigningKeyResp{
+		PKIName:      km.Name(),
+		PKIID:        km.PKIID().String(),
+		Time:         t.UTC().Format(time.RFC3339),
+		SPKI:         der,
+		Signature:    km.SignPublicKey(t, der),
+		SigningKeyID: km.SigningKeyID(),
+	}, http.StatusOK, ""
+}
+
+// Simple handler for per-interval private signing key requests.
+func (s *Server) getPrivateSigningKey(query url.Values) (*GetPrivateSigningKeyResp, int, string) {
+	km, t, status, message := s.parseTarget(query)
+	if status != http.StatusOK {
+		return nil, status, message
+	}
+	released, status, message := s.checkDisclosable(km, t, query.Get(argRequestID))
+	if status != http.StatusOK {
+		return nil, status, message
+	}
+	der, status, message := timeSigningKey(km, t, query.Get(argRequestID), func(priv ed25519.PrivateKey) ([]byte, error) {
+		return x509.MarshalPKCS8PrivateKey(priv)
+	})
+	if status != http.StatusOK {
+		return nil, status, message
+	}
+	return &GetPrivateSigningKeyResp{
+		PKIName: km.Name(),
+		PKIID:   km.PKIID().String(),
+		PKCS8:   der,
+		Attestation: &ReleaseAttestation{
+			TargetTime:   t.UTC().Format(time.RFC3339),
+			ReleaseTime:  released.UTC().Format(time.RFC3339),
+			Signature:    km.AttestRelease(t, released),
+			SigningKeyID: km.SigningKeyID(),
+		},
+	}, http.StatusOK, ""
+}
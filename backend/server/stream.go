@@ -0,0 +1,112 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/newgrp/timecapsule/keys"
+)
+
+// Longest time the unlock stream may stay silent. Comment lines are sent at this period to keep
+// idle connections open through proxies.
+const streamKeepalive = 30 * time.Second
+
+// Data of an "unlock" server-sent event.
+type UnlockEvent struct {
+	// Start of the interval that became unlockable, in RFC 3339 format.
+	Time string `json:"time"`
+	// Hex-encoded SHA-256 hash of the DER-encoded SubjectPublicKeyInfo of the key for Time.
+	Fingerprint string `json:"fingerprint"`
+}
+
+// Handler for the unlock event stream.
+//
+// Emits an "unlock" server-sent event each time the secure clock passes the start of a secret
+// interval, i.e. each time the private keys for a new interval become available. On connection,
+// an event for the most recently unlocked interval is sent immediately.
+func (s *Server) unlockStream(resp http.ResponseWriter, req *http.Request) {
+	flusher, ok := resp.(http.Flusher)
+	if !ok {
+		resp.WriteHeader(http.StatusInternalServerError)
+		resp.Write([]byte("Streaming is not supported\n"))
+		return
+	}
+
+	query, err := requestQuery(req)
+	if err != nil {
+		writeText(resp, http.StatusBadRequest, fmt.Sprintf("Could not parse request parameters: %v", err))
+		return
+	}
+	km, status, message := s.selectPKI(query)
+	if status != http.StatusOK {
+		writeText(resp, status, message)
+		return
+	}
+
+	now, err := s.earliestNow()
+	if err != nil {
+		log.Printf("ERROR: Failed to determine the current time securely in request %s: %+v", requestID(req.Context()), err)
+		writeText(resp, http.StatusServiceUnavailable, noTimeError)
+		return
+	}
+
+	resp.Header().Set("Content-Type", "text/event-stream")
+	resp.Header().Set("Cache-Control", "no-cache")
+	resp.WriteHeader(http.StatusOK)
+
+	interval := km.DerivationParams().Interval
+	next := now.Truncate(interval)
+	for {
+		if next.After(km.MaxTime()) {
+			return
+		}
+		if !now.Before(s.releaseTime(next)) {
+			if err := writeUnlockEvent(resp, km, next, requestID(req.Context())); err != nil {
+				log.Printf("ERROR: Failed to write unlock event for %s in request %s: %+v", next.Format(time.RFC3339), requestID(req.Context()), err)
+				return
+			}
+			next = next.Add(interval)
+		} else if _, err := resp.Write([]byte(": keepalive\n\n")); err != nil {
+			return
+		}
+		flusher.Flush()
+
+		timer := time.NewTimer(min(s.releaseTime(next).Sub(now), streamKeepalive))
+		select {
+		case <-req.Context().Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		now, err = s.earliestNow()
+		if err != nil {
+			log.Printf("ERROR: Failed to determine the current time securely in request %s: %+v", requestID(req.Context()), err)
+			return
+		}
+	}
+}
+
+// Writes an "unlock" event for a PKI's interval starting at the given time. Intervals before the
+// PKI's time range have no keys, so nothing is written for them.
+func writeUnlockEvent(resp http.ResponseWriter, km *keys.KeyManager, t time.Time, reqID string) error {
+	if km.CheckTime(t) != nil {
+		return nil
+	}
+	der, status, message := publicKeySPKI(km, t, reqID)
+	if status != http.StatusOK {
+		return fmt.Errorf("failed to retrieve public key: %s", message)
+	}
+	data, err := json.Marshal(&UnlockEvent{
+		Time:        t.UTC().Format(time.RFC3339),
+		Fingerprint: keys.Fingerprint(der),
+	})
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(resp, "event: unlock\nid: %d\ndata: %s\n\n", t.Unix(), data)
+	return err
+}
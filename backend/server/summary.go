@@ -0,0 +1,52 @@
+package server
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Returns a single-line summary of the server's effective configuration, suitable for logging.
+//
+// The summary is a space-separated list of key=value pairs. It never includes secret material.
+func (s *Server) ConfigSummary() string {
+	params := s.keys.DerivationParams()
+	notBefore := "none"
+	if !s.opts.NotBefore.IsZero() {
+		notBefore = s.opts.NotBefore.Format(time.RFC3339)
+	}
+	var extraPKIs []string
+	for _, km := range s.allPKIs()[1:] {
+		extraPKIs = append(extraPKIs, km.PKIID().String())
+	}
+	fields := []struct {
+		key   string
+		value string
+	}{
+		{"pki_name", strconv.Quote(s.keys.Name())},
+		{"pki_id", s.keys.PKIID().String()},
+		{"min_time", s.keys.MinTime().Format(time.RFC3339)},
+		{"max_time", s.keys.MaxTime().Format(time.RFC3339)},
+		{"interval", params.Interval.String()},
+		{"algorithm", string(params.Algorithm)},
+		{"secret_scheme", string(s.keys.SecretScheme())},
+		{"derivation_version", strconv.Itoa(params.Version)},
+		{"secrets_dir", strconv.Quote(s.opts.SecretsDir)},
+		{"nts_servers", strconv.Quote(strings.Join(s.opts.NTSServers, ","))},
+		{"not_before", notBefore},
+		{"max_wait", s.maxWait().String()},
+		{"extra_pkis", strconv.Quote(strings.Join(extraPKIs, ","))},
+		{"public_only", strconv.FormatBool(s.opts.PublicOnly)},
+		{"cors_origins", strconv.Quote(strings.Join(s.opts.CORS.AllowedOrigins, ","))},
+	}
+
+	var b strings.Builder
+	for i, f := range fields {
+		if i > 0 {
+			b.WriteByte(' ')
+		}
+		fmt.Fprintf(&b, "%s=%s", f.key, f.value)
+	}
+	return b.String()
+}
@@ -0,0 +1,187 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/newgrp/timecapsule/keys"
+)
+
+// How often the transparency logs of PKIs are extended with newly generated public keys.
+const logCheckInterval = time.Minute
+
+type GetLogTreeHeadResp struct {
+	PKIID string `json:"pkiID"`
+	// Number of entries in the log.
+	TreeSize uint64 `json:"treeSize"`
+	// RFC 9162 Merkle tree hash of the log's entries.
+	RootHash []byte `json:"rootHash"`
+	// When the tree head was signed, in RFC 3339 format.
+	Timestamp string `json:"timestamp"`
+
+	// Signature over (PKI ID, tree size, timestamp, root hash) by the PKI signing key identified by
+	// SigningKeyID. See keys.TreeHeadMessage.
+	Signature    []byte `json:"signature"`
+	SigningKeyID string `json:"signingKeyID"`
+}
+
+type GetLogInclusionProofResp struct {
+	PKIID string `json:"pkiID"`
+	// Start of the interval of the entry, in RFC 3339 format.
+	Time string `json:"time"`
+	// SHA-256 hash of the DER-encoded SubjectPublicKeyInfo of the interval's public key.
+	Fingerprint []byte `json:"fingerprint"`
+	// Index of the entry in the log. The entry's leaf is given by keys.LogLeaf.
+	LeafIndex uint64 `json:"leafIndex"`
+	// Size of the tree in which the entry's inclusion is proven.
+	TreeSize uint64 `json:"treeSize"`
+	// RFC 9162 inclusion proof of the entry.
+	AuditPath [][]byte `json:"auditPath"`
+}
+
+type GetLogConsistencyProofResp struct {
+	PKIID string `json:"pkiID"`
+	// Sizes of the earlier and later trees.
+	First  uint64 `json:"first"`
+	Second uint64 `json:"second"`
+	// RFC 9162 consistency proof between the trees.
+	Proof [][]byte `json:"proof"`
+}
+
+// Parses an optional tree size parameter, which defaults to def. Returns (size, HTTP status code,
+// error message).
+func parseTreeSize(query url.Values, arg string, def uint64) (uint64, int, string) {
+	if !query.Has(arg) {
+		return def, http.StatusOK, ""
+	}
+	size, err := strconv.ParseUint(query.Get(arg), 10, 64)
+	if err != nil {
+		return 0, http.StatusBadRequest, fmt.Sprintf("Invalid %q parameter: %v", arg, err)
+	}
+	return size, http.StatusOK, ""
+}
+
+// Simple handler for transparency log tree head requests.
+//
+// Tree heads are timestamped with the system clock; they commit to public keys only, so the secure
+// clock is not needed.
+func (s *Server) getLogTreeHead(query url.Values) (*GetLogTreeHeadResp, int, string) {
+	km, status, message := s.selectPKI(query)
+	if status != http.StatusOK {
+		return nil, status, message
+	}
+	head := km.TreeHead(time.Now())
+	return &GetLogTreeHeadResp{
+		PKIID:        km.PKIID().String(),
+		TreeSize:     head.Size,
+		RootHash:     head.RootHash,
+		Timestamp:    head.Timestamp.Format(time.RFC3339),
+		Signature:    head.Signature,
+		SigningKeyID: km.SigningKeyID(),
+	}, http.StatusOK, ""
+}
+
+// Simple handler for transparency log inclusion proof requests.
+//
+// Proves the inclusion of the entry for the interval containing the target time in the tree with the
+// given size, which defaults to the current size of the log.
+func (s *Server) getLogInclusionProof(query url.Values) (*GetLogInclusionProofResp, int, string) {
+	km, t, status, message := s.parseTarget(query)
+	if status != http.StatusOK {
+		return nil, status, message
+	}
+	size, status, message := parseTreeSize(query, argTreeSize, km.TreeHead(time.Now()).Size)
+	if status != http.StatusOK {
+		return nil, status, message
+	}
+
+	entry, index, proof, err := km.LogInclusionProof(t, size)
+	if errors.Is(err, keys.ErrNotLogged) {
+		return nil, http.StatusNotFound, "The public key for this time is not in the transparency log yet"
+	}
+	if errors.Is(err, keys.ErrInvalidTreeSize) {
+		return nil, http.StatusBadRequest, fmt.Sprintf("Invalid %q parameter: %v", argTreeSize, err)
+	}
+	if err != nil {
+		log.Printf("ERROR: Failed to prove inclusion of %s in transparency log in request %s: %+v", t.Format(time.RFC3339), query.Get(argRequestID), err)
+		return nil, http.StatusInternalServerError, "Server failed to prove inclusion"
+	}
+	return &GetLogInclusionProofResp{
+		PKIID:       km.PKIID().String(),
+		Time:        entry.Time.UTC().Format(time.RFC3339),
+		Fingerprint: entry.Fingerprint,
+		LeafIndex:   index,
+		TreeSize:    size,
+		AuditPath:   proof,
+	}, http.StatusOK, ""
+}
+
+// Simple handler for transparency log consistency proof requests.
+//
+// Proves that the tree with the second size extends the tree with the first. The second size
+// defaults to the current size of the log.
+func (s *Server) getLogConsistencyProof(query url.Values) (*GetLogConsistencyProofResp, int, string) {
+	km, status, message := s.selectPKI(query)
+	if status != http.StatusOK {
+		return nil, status, message
+	}
+	if !query.Has(argFirst) {
+		return nil, http.StatusBadRequest, fmt.Sprintf("%q parameter is required", argFirst)
+	}
+	first, status, message := parseTreeSize(query, argFirst, 0)
+	if status != http.StatusOK {
+		return nil, status, message
+	}
+	second, status, message := parseTreeSize(query, argSecond, km.TreeHead(time.Now()).Size)
+	if status != http.StatusOK {
+		return nil, status, message
+	}
+
+	proof, err := km.LogConsistencyProof(first, second)
+	if errors.Is(err, keys.ErrInvalidTreeSize) {
+		return nil, http.StatusBadRequest, fmt.Sprintf("Invalid tree sizes: %v", err)
+	}
+	if err != nil {
+		log.Printf("ERROR: Failed to prove consistency of transparency log sizes %d and %d in request %s: %+v", first, second, query.Get(argRequestID), err)
+		return nil, http.StatusInternalServerError, "Server failed to prove consistency"
+	}
+	return &GetLogConsistencyProofResp{
+		PKIID:  km.PKIID().String(),
+		First:  first,
+		Second: second,
+		Proof:  proof,
+	}, http.StatusOK, ""
+}
+
+// Appends newly generated public keys to the transparency log of every PKI.
+func (s *Server) extendTransparencyLogs() {
+	for _, km := range s.allPKIs() {
+		n, err := km.LogPublicKeys()
+		// PKIs that are still generating secrets are picked up on the next pass.
+		if err != nil && !errors.Is(err, keys.ErrNotReady) {
+			log.Printf("ERROR: Failed to extend transparency log of PKI %s: %+v", km.PKIID(), err)
+		}
+		if n > 0 {
+			log.Printf("Appended %d public keys to transparency log of PKI %s", n, km.PKIID())
+		}
+	}
+}
+
+// Extends transparency logs periodically until the server is closed.
+func (s *Server) runTransparencyLogs() {
+	ticker := time.NewTicker(logCheckInterval)
+	defer ticker.Stop()
+	for {
+		s.extendTransparencyLogs()
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
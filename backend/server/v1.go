@@ -0,0 +1,164 @@
+package server
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+)
+
+// Structured error returned by the /v1/ API.
+type V1Error struct {
+	// Machine-readable error code, e.g. "invalid_argument".
+	Code string `json:"code"`
+	// Human-readable description of the error.
+	Message string `json:"message"`
+}
+
+type V1ErrorResp struct {
+	Error V1Error `json:"error"`
+}
+
+// Request body for POST /v1/get_public_key.
+type V1GetPublicKeyReq struct {
+	PKIID string `json:"pkiID,omitempty"`
+	// Exactly one of Time and Event must be given.
+	Time  string `json:"time,omitempty"`
+	Event string `json:"event,omitempty"`
+	// Whether to include the ML-KEM-768 key for the same time.
+	Hybrid bool `json:"hybrid,omitempty"`
+}
+
+// Request body for POST /v1/get_private_key.
+type V1GetPrivateKeyReq struct {
+	PKIID string `json:"pkiID,omitempty"`
+	// Exactly one of Time and Event must be given.
+	Time  string `json:"time,omitempty"`
+	Event string `json:"event,omitempty"`
+	// Optional DER-encoded SubjectPublicKeyInfo to which the private key is HPKE-sealed.
+	WrapTo []byte `json:"wrapTo,omitempty"`
+	// Whether to include the ML-KEM-768 key for the same time.
+	Hybrid bool `json:"hybrid,omitempty"`
+}
+
+// Request body for POST /v1/get_private_keys.
+type V1GetPrivateKeysReq struct {
+	PKIID string `json:"pkiID,omitempty"`
+	Start string `json:"start"`
+	End   string `json:"end"`
+	Step  string `json:"step,omitempty"`
+	// Optional DER-encoded SubjectPublicKeyInfo to which each private key is HPKE-sealed.
+	WrapTo []byte `json:"wrapTo,omitempty"`
+}
+
+// Converts the request body to the equivalent query parameters.
+func (r *V1GetPublicKeyReq) query() url.Values {
+	query := targetQuery(r.PKIID, r.Time, r.Event)
+	if r.Hybrid {
+		query.Set(argHybrid, "true")
+	}
+	return query
+}
+
+// Converts the request body to the equivalent query parameters.
+func (r *V1GetPrivateKeyReq) query() url.Values {
+	query := targetQuery(r.PKIID, r.Time, r.Event)
+	if len(r.WrapTo) != 0 {
+		query.Set(argWrapTo, base64.RawURLEncoding.EncodeToString(r.WrapTo))
+	}
+	if r.Hybrid {
+		query.Set(argHybrid, "true")
+	}
+	return query
+}
+
+// Converts the request body to the equivalent query parameters.
+func (r *V1GetPrivateKeysReq) query() url.Values {
+	query := url.Values{}
+	if r.PKIID != "" {
+		query.Set(argPKIID, r.PKIID)
+	}
+	query.Set(argStart, r.Start)
+	query.Set(argEnd, r.End)
+	if r.Step != "" {
+		query.Set(argStep, r.Step)
+	}
+	if len(r.WrapTo) != 0 {
+		query.Set(argWrapTo, base64.RawURLEncoding.EncodeToString(r.WrapTo))
+	}
+	return query
+}
+
+// Returns the /v1/ error code for an HTTP status code.
+func v1ErrorCode(status int) string {
+	switch status {
+	case http.StatusBadRequest:
+		return "invalid_argument"
+	case http.StatusUnauthorized:
+		return "unauthenticated"
+	case http.StatusForbidden:
+		return "permission_denied"
+	case http.StatusNotFound:
+		return "not_found"
+	case http.StatusServiceUnavailable:
+		return "unavailable"
+	default:
+		return "internal"
+	}
+}
+
+// Writes a structured /v1/ error response.
+func writeV1Error(resp http.ResponseWriter, status int, message string) {
+	setRetryAfter(resp, status)
+	writeJSON(resp, status, &V1ErrorResp{
+		Error: V1Error{
+			Code:    v1ErrorCode(status),
+			Message: message,
+		},
+	})
+}
+
+// Converts a simpleHandler to an http.HandlerFunc that accepts its parameters as a JSON body of
+// type T and reports errors as structured JSON objects.
+func makeV1Handler[T interface{ query() url.Values }](h simpleHandler) http.HandlerFunc {
+	return func(resp http.ResponseWriter, req *http.Request) {
+		resp.Header().Set("Content-Type", "application/json")
+
+		var body T
+		d := json.NewDecoder(io.LimitReader(req.Body, maxBodySize))
+		d.DisallowUnknownFields()
+		if err := d.Decode(&body); err != nil {
+			writeV1Error(resp, http.StatusBadRequest, fmt.Sprintf("Could not parse request body: %v", err))
+			return
+		}
+
+		query := body.query()
+		query.Set(argRequestID, requestID(req.Context()))
+
+		value, status, message := h(query)
+		if status != http.StatusOK {
+			writeV1Error(resp, status, message)
+			return
+		}
+		writeJSON(resp, status, value)
+	}
+}
+
+// Registers the /v1/ API through the given registration function, in which every method is a POST
+// with a JSON request body.
+func (s *Server) registerV1Handlers(handle func(pattern string, h http.Handler)) {
+	handle(fmt.Sprintf("POST /v1/%s", methodGetPublicKey), makeV1Handler[*V1GetPublicKeyReq](func(query url.Values) (any, int, string) {
+		return s.getPublicKey(query)
+	}))
+	handle(fmt.Sprintf("POST /v1/%s", methodGetPrivateKey), s.privateKeyHandler(makeV1Handler[*V1GetPrivateKeyReq](func(query url.Values) (any, int, string) {
+		return s.getPrivateKey(query)
+	})))
+	handle(fmt.Sprintf("POST /v1/%s", methodGetPublicKeys), makeV1Handler[*GetPublicKeysReq](func(query url.Values) (any, int, string) {
+		return s.getPublicKeys(query)
+	}))
+	handle(fmt.Sprintf("POST /v1/%s", methodGetPrivateKeys), s.privateKeyHandler(makeV1Handler[*V1GetPrivateKeysReq](func(query url.Values) (any, int, string) {
+		return s.getPrivateKeys(query)
+	})))
+}
@@ -0,0 +1,79 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Default for Options.MaxWait.
+const defaultMaxWait = time.Minute
+
+// Longest time to sleep between readings of the secure clock while waiting for a release.
+const maxWaitStep = 10 * time.Second
+
+// The longest time that a request may wait for a private key to be released.
+func (s *Server) maxWait() time.Duration {
+	if s.opts.MaxWait == 0 {
+		return defaultMaxWait
+	}
+	return s.opts.MaxWait
+}
+
+// Wraps a private key handler so that requests with "wait=true" are held open until the secure
+// clock passes the release time of the requested key, and then served by the wrapped handler.
+//
+// Requests whose key won't be released within the maximum wait are rejected immediately. Requests
+// without "wait=true", or that are invalid, are passed through unchanged.
+func (s *Server) waitForRelease(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		query, err := requestQuery(req)
+		if err != nil || !query.Has(argWait) {
+			h.ServeHTTP(resp, req)
+			return
+		}
+		wait, err := strconv.ParseBool(query.Get(argWait))
+		if err != nil {
+			resp.WriteHeader(http.StatusBadRequest)
+			resp.Write([]byte(fmt.Sprintf("Invalid %q parameter: %v\n", argWait, err)))
+			return
+		}
+		_, t, status, _ := s.parseTarget(query)
+		// Public-only servers refuse every private key request, so there is nothing to wait for.
+		if !wait || status != http.StatusOK || s.opts.PublicOnly {
+			h.ServeHTTP(resp, req)
+			return
+		}
+
+		release := s.releaseTime(t)
+		deadline := time.Now().Add(s.maxWait())
+		for {
+			now, err := s.earliestNow()
+			if err != nil {
+				log.Printf("ERROR: Failed to determine the current time securely in request %s: %+v", requestID(req.Context()), err)
+				writeText(resp, http.StatusServiceUnavailable, noTimeError)
+				return
+			}
+			remaining := release.Sub(now)
+			if remaining <= 0 {
+				break
+			}
+			if remaining > time.Until(deadline) {
+				resp.WriteHeader(http.StatusForbidden)
+				resp.Write([]byte(fmt.Sprintf("Private key will not be released within the maximum wait of %v\n", s.maxWait())))
+				return
+			}
+
+			timer := time.NewTimer(min(remaining, maxWaitStep))
+			select {
+			case <-req.Context().Done():
+				timer.Stop()
+				return
+			case <-timer.C:
+			}
+		}
+		h.ServeHTTP(resp, req)
+	})
+}
@@ -0,0 +1,34 @@
+package sigv4
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestSign(t *testing.T) {
+	// Example from the AWS Signature Version 4 documentation.
+	req, err := http.NewRequest(http.MethodGet, "https://iam.amazonaws.com/?Action=ListUsers&Version=2010-05-08", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=utf-8")
+	creds := Credentials{AccessKeyID: "AKIDEXAMPLE", SecretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"}
+	Sign(req, nil, creds, "us-east-1", "iam", time.Date(2015, time.August, 30, 12, 36, 0, 0, time.UTC))
+
+	want := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/iam/aws4_request, SignedHeaders=content-type;host;x-amz-date, Signature=5d672d79c15b13162d9279b0855cfba6789a8edb4c82c400e06b5924a6f2b5d7"
+	if got := req.Header.Get("Authorization"); got != want {
+		t.Errorf("Wrong signature:\ngot  %s\nwant %s", got, want)
+	}
+}
+
+func TestEscapePath(t *testing.T) {
+	for _, tc := range []struct{ in, want string }{
+		{"/bucket/key", "/bucket/key"},
+		{"/a b/c@d:e~f", "/a%20b/c%40d%3Ae~f"},
+	} {
+		if got := EscapePath(tc.in); got != tc.want {
+			t.Errorf("EscapePath(%q) = %q, want %q", tc.in, got, tc.want)
+		}
+	}
+}
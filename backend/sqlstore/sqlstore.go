@@ -0,0 +1,249 @@
+// Package sqlstore implements a secret store backed by a SQL database, such as PostgreSQL or
+// SQLite.
+//
+// The package doesn't import a database driver, and the timecapsule server binary doesn't offer
+// it. Programs that use it embed the server as a library, import a driver such as
+// github.com/lib/pq or modernc.org/sqlite, and open the database themselves:
+//
+//	db, err := sql.Open("sqlite", "secrets.db")
+//	...
+//	if _, err := sqlstore.Migrate(ctx, db, sqlstore.DialectSQLite); err != nil {
+//		...
+//	}
+//	store, err := sqlstore.New(db, sqlstore.DialectSQLite, "default")
+//	...
+//	opts.PKIOptions.SecretStore = store
+//	s, err := server.NewServer(opts)
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/newgrp/timecapsule/keys"
+)
+
+// SQL dialect of a database.
+type Dialect string
+
+const (
+	DialectPostgres Dialect = "postgres"
+	DialectSQLite   Dialect = "sqlite"
+)
+
+// Parses the name of a supported dialect.
+func ParseDialect(s string) (Dialect, error) {
+	switch d := Dialect(s); d {
+	case DialectPostgres, DialectSQLite:
+		return d, nil
+	default:
+		return "", fmt.Errorf("unsupported SQL dialect %q", s)
+	}
+}
+
+// Rewrites a statement written with ? placeholders and {blob} column types into the dialect.
+func (d Dialect) rewrite(query string) string {
+	if d == DialectPostgres {
+		query = strings.ReplaceAll(query, "{blob}", "BYTEA")
+		var b strings.Builder
+		n := 0
+		for _, c := range query {
+			if c == '?' {
+				n++
+				fmt.Fprintf(&b, "$%d", n)
+				continue
+			}
+			b.WriteRune(c)
+		}
+		return b.String()
+	}
+	return strings.ReplaceAll(query, "{blob}", "BLOB")
+}
+
+// Table recording the version of the schema, which is the number of migrations applied.
+const schemaTable = `CREATE TABLE IF NOT EXISTS timecapsule_schema (version INTEGER NOT NULL)`
+
+// Migrations of the schema, in order. Migrations must never change once released; changes to the
+// schema are made by appending migrations.
+var migrations = [][]string{
+	// 1: PKIs and their per-interval secrets.
+	{
+		`CREATE TABLE timecapsule_pkis (
+			pki TEXT PRIMARY KEY,
+			created_at BIGINT NOT NULL
+		)`,
+		`CREATE TABLE timecapsule_secrets (
+			pki TEXT NOT NULL REFERENCES timecapsule_pkis (pki),
+			bucket BIGINT NOT NULL,
+			secret {blob} NOT NULL,
+			created_at BIGINT NOT NULL,
+			PRIMARY KEY (pki, bucket)
+		)`,
+	},
+}
+
+// Returns the current version of the schema, or 0 if no migrations have been applied.
+func schemaVersion(ctx context.Context, q interface {
+	QueryRowContext(context.Context, string, ...any) *sql.Row
+}) (int, error) {
+	var version int
+	if err := q.QueryRowContext(ctx, `SELECT COALESCE(MAX(version), 0) FROM timecapsule_schema`).Scan(&version); err != nil {
+		return 0, fmt.Errorf("failed to read schema version: %w", err)
+	}
+	return version, nil
+}
+
+// Applies all pending migrations to the database, each in its own transaction. Returns the
+// resulting schema version.
+func Migrate(ctx context.Context, db *sql.DB, dialect Dialect) (int, error) {
+	if _, err := db.ExecContext(ctx, schemaTable); err != nil {
+		return 0, fmt.Errorf("failed to create schema table: %w", err)
+	}
+	for {
+		done, version, err := migrateOnce(ctx, db, dialect)
+		if err != nil || done {
+			return version, err
+		}
+	}
+}
+
+// Applies the next pending migration, if any.
+func migrateOnce(ctx context.Context, db *sql.DB, dialect Dialect) (done bool, version int, err error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, 0, err
+	}
+	defer tx.Rollback()
+	version, err = schemaVersion(ctx, tx)
+	if err != nil {
+		return false, 0, err
+	}
+	if version > len(migrations) {
+		return false, 0, fmt.Errorf("database schema version %d is newer than the latest supported version %d", version, len(migrations))
+	}
+	if version == len(migrations) {
+		return true, version, nil
+	}
+
+	for _, stmt := range migrations[version] {
+		if _, err := tx.ExecContext(ctx, dialect.rewrite(stmt)); err != nil {
+			return false, 0, fmt.Errorf("migration %d failed: %w", version+1, err)
+		}
+	}
+	if _, err := tx.ExecContext(ctx, dialect.rewrite(`INSERT INTO timecapsule_schema (version) VALUES (?)`), version+1); err != nil {
+		return false, 0, fmt.Errorf("failed to record schema version: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return false, 0, fmt.Errorf("migration %d failed: %w", version+1, err)
+	}
+	return false, version + 1, nil
+}
+
+// Secret store that keeps the secrets of one PKI as rows of a SQL database, so that secrets are
+// covered by the database's backups and point-in-time recovery.
+//
+// Many PKIs can share a database, each under its own name.
+type Store struct {
+	db      *sql.DB
+	dialect Dialect
+	pki     string
+}
+
+// Returns a store for the PKI with the given name in the database. The database schema must be up
+// to date; see Migrate.
+func New(db *sql.DB, dialect Dialect, pki string) (*Store, error) {
+	if _, err := ParseDialect(string(dialect)); err != nil {
+		return nil, err
+	}
+	if pki == "" {
+		return nil, errors.New("no PKI name provided")
+	}
+	ctx := context.Background()
+	if _, err := db.ExecContext(ctx, schemaTable); err != nil {
+		return nil, fmt.Errorf("failed to create schema table: %w", err)
+	}
+	version, err := schemaVersion(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+	if version != len(migrations) {
+		return nil, fmt.Errorf("database schema version is %d, want %d: migrations must be applied first", version, len(migrations))
+	}
+	return &Store{db: db, dialect: dialect, pki: pki}, nil
+}
+
+func (s *Store) Get(bucket time.Time) ([]byte, bool, error) {
+	var secret []byte
+	err := s.db.QueryRow(s.dialect.rewrite(`SELECT secret FROM timecapsule_secrets WHERE pki = ? AND bucket = ?`), s.pki, bucket.Unix()).Scan(&secret)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to read secret for %s: %w", bucket.Format(time.RFC3339), err)
+	}
+	return secret, true, nil
+}
+
+// Stores a secret, recording the PKI first if this is its first secret. Both happen in one
+// transaction.
+func (s *Store) Put(bucket time.Time, secret []byte) error {
+	if err := s.put(bucket, secret); err != nil {
+		return fmt.Errorf("failed to write secret for %s: %w", bucket.Format(time.RFC3339), err)
+	}
+	return nil
+}
+
+func (s *Store) put(bucket time.Time, secret []byte) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	now := time.Now().Unix()
+	if _, err := tx.Exec(s.dialect.rewrite(`INSERT INTO timecapsule_pkis (pki, created_at) VALUES (?, ?) ON CONFLICT DO NOTHING`), s.pki, now); err != nil {
+		return err
+	}
+	res, err := tx.Exec(s.dialect.rewrite(`INSERT INTO timecapsule_secrets (pki, bucket, secret, created_at) VALUES (?, ?, ?, ?) ON CONFLICT DO NOTHING`), s.pki, bucket.Unix(), secret, now)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return keys.ErrSecretExists
+	}
+	return tx.Commit()
+}
+
+// Deletes a secret's row. Database backups and write-ahead logs may keep copies of the secret until
+// they are rotated.
+func (s *Store) Delete(bucket time.Time) error {
+	if _, err := s.db.Exec(s.dialect.rewrite(`DELETE FROM timecapsule_secrets WHERE pki = ? AND bucket = ?`), s.pki, bucket.Unix()); err != nil {
+		return fmt.Errorf("failed to delete secret for %s: %w", bucket.Format(time.RFC3339), err)
+	}
+	return nil
+}
+
+func (s *Store) List() ([]time.Time, error) {
+	rows, err := s.db.Query(s.dialect.rewrite(`SELECT bucket FROM timecapsule_secrets WHERE pki = ? ORDER BY bucket`), s.pki)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list secrets: %w", err)
+	}
+	defer rows.Close()
+	var buckets []time.Time
+	for rows.Next() {
+		var unix int64
+		if err := rows.Scan(&unix); err != nil {
+			return nil, fmt.Errorf("failed to list secrets: %w", err)
+		}
+		buckets = append(buckets, time.Unix(unix, 0).UTC())
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list secrets: %w", err)
+	}
+	return buckets, nil
+}
@@ -0,0 +1,283 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/newgrp/timecapsule/keys"
+)
+
+// Minimal in-memory database driver that understands exactly the statements of this package.
+type fakeDriver struct {
+	dialect Dialect
+}
+
+// State of a fake database.
+type fakeState struct {
+	tables   map[string]bool
+	versions []int
+	pkis     map[string]bool
+	secrets  map[string][]byte // keyed by "<pki>/<bucket>"
+}
+
+func (s fakeState) clone() fakeState {
+	return fakeState{
+		tables:   maps.Clone(s.tables),
+		versions: slices.Clone(s.versions),
+		pkis:     maps.Clone(s.pkis),
+		secrets:  maps.Clone(s.secrets),
+	}
+}
+
+type fakeDB struct {
+	mu    sync.Mutex
+	state fakeState
+}
+
+var (
+	fakeDBsMu sync.Mutex
+	fakeDBs   = map[string]*fakeDB{}
+)
+
+func (d fakeDriver) Open(name string) (driver.Conn, error) {
+	fakeDBsMu.Lock()
+	defer fakeDBsMu.Unlock()
+	db, ok := fakeDBs[name]
+	if !ok {
+		db = &fakeDB{state: fakeState{tables: map[string]bool{}, pkis: map[string]bool{}, secrets: map[string][]byte{}}}
+		fakeDBs[name] = db
+	}
+	return &fakeConn{db: db, dialect: d.dialect}, nil
+}
+
+type fakeConn struct {
+	db      *fakeDB
+	dialect Dialect
+	// State to restore if the open transaction is rolled back.
+	saved *fakeState
+}
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) {
+	if strings.Contains(query, "{blob}") {
+		return nil, fmt.Errorf("column type not rewritten: %s", query)
+	}
+	if c.dialect == DialectPostgres && strings.Contains(query, "?") || c.dialect == DialectSQLite && strings.Contains(query, "$") {
+		return nil, fmt.Errorf("wrong placeholders for %s: %s", c.dialect, query)
+	}
+	return &fakeStmt{conn: c, query: strings.Join(strings.Fields(query), " ")}, nil
+}
+
+func (c *fakeConn) Close() error { return nil }
+
+func (c *fakeConn) Begin() (driver.Tx, error) {
+	c.db.mu.Lock()
+	saved := c.db.state.clone()
+	c.db.mu.Unlock()
+	c.saved = &saved
+	return c, nil
+}
+
+func (c *fakeConn) Commit() error {
+	c.saved = nil
+	return nil
+}
+
+func (c *fakeConn) Rollback() error {
+	c.db.mu.Lock()
+	c.db.state = *c.saved
+	c.db.mu.Unlock()
+	c.saved = nil
+	return nil
+}
+
+type fakeStmt struct {
+	conn  *fakeConn
+	query string
+}
+
+func (s *fakeStmt) Close() error  { return nil }
+func (s *fakeStmt) NumInput() int { return -1 }
+
+func (s *fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	db := s.conn.db
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	st := &db.state
+	q := s.query
+	switch {
+	case strings.HasPrefix(q, "CREATE TABLE IF NOT EXISTS timecapsule_schema"):
+		st.tables["timecapsule_schema"] = true
+	case strings.HasPrefix(q, "CREATE TABLE "):
+		name := strings.Fields(q)[2]
+		if st.tables[name] {
+			return nil, fmt.Errorf("table %s exists", name)
+		}
+		st.tables[name] = true
+	case strings.HasPrefix(q, "INSERT INTO timecapsule_schema"):
+		st.versions = append(st.versions, int(args[0].(int64)))
+	case strings.HasPrefix(q, "INSERT INTO timecapsule_pkis"):
+		st.pkis[args[0].(string)] = true
+	case strings.HasPrefix(q, "INSERT INTO timecapsule_secrets"):
+		if !st.pkis[args[0].(string)] {
+			return nil, errors.New("foreign key violation")
+		}
+		key := fmt.Sprintf("%s/%d", args[0], args[1])
+		if _, ok := st.secrets[key]; ok {
+			return driver.RowsAffected(0), nil
+		}
+		st.secrets[key] = args[2].([]byte)
+	default:
+		return nil, fmt.Errorf("unexpected statement: %s", q)
+	}
+	return driver.RowsAffected(1), nil
+}
+
+func (s *fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	db := s.conn.db
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	st := &db.state
+	q := s.query
+	var rows [][]driver.Value
+	switch {
+	case strings.HasPrefix(q, "SELECT COALESCE(MAX(version), 0)"):
+		rows = [][]driver.Value{{int64(slices.Max(append(slices.Clone(st.versions), 0)))}}
+	case strings.HasPrefix(q, "SELECT secret FROM timecapsule_secrets"):
+		if secret, ok := st.secrets[fmt.Sprintf("%s/%d", args[0], args[1])]; ok {
+			rows = [][]driver.Value{{secret}}
+		}
+	case strings.HasPrefix(q, "SELECT bucket FROM timecapsule_secrets"):
+		prefix := args[0].(string) + "/"
+		var buckets []int64
+		for key := range st.secrets {
+			if rest, ok := strings.CutPrefix(key, prefix); ok {
+				var b int64
+				fmt.Sscan(rest, &b)
+				buckets = append(buckets, b)
+			}
+		}
+		slices.Sort(buckets)
+		for _, b := range buckets {
+			rows = append(rows, []driver.Value{b})
+		}
+	default:
+		return nil, fmt.Errorf("unexpected query: %s", q)
+	}
+	return &fakeRows{rows: rows}, nil
+}
+
+type fakeRows struct {
+	rows [][]driver.Value
+}
+
+func (r *fakeRows) Columns() []string { return []string{"value"} }
+func (r *fakeRows) Close() error      { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
+
+func init() {
+	sql.Register("fakepostgres", fakeDriver{dialect: DialectPostgres})
+	sql.Register("fakesqlite", fakeDriver{dialect: DialectSQLite})
+}
+
+func TestStore(t *testing.T) {
+	for _, dialect := range []Dialect{DialectPostgres, DialectSQLite} {
+		t.Run(string(dialect), func(t *testing.T) {
+			db, err := sql.Open("fake"+string(dialect), t.Name())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+
+			if _, err := New(db, dialect, "pki"); err == nil {
+				t.Errorf("Created a store before migrating")
+			}
+			for range 2 {
+				version, err := Migrate(context.Background(), db, dialect)
+				if err != nil {
+					t.Fatalf("Failed to migrate: %+v", err)
+				}
+				if version != len(migrations) {
+					t.Errorf("Migrated to version %d, want %d", version, len(migrations))
+				}
+			}
+
+			s, err := New(db, dialect, "pki")
+			if err != nil {
+				t.Fatalf("Failed to create store: %+v", err)
+			}
+			other, err := New(db, dialect, "other")
+			if err != nil {
+				t.Fatalf("Failed to create store: %+v", err)
+			}
+			b0 := time.Date(2024, time.September, 1, 0, 0, 0, 0, time.UTC)
+			b1 := b0.Add(time.Hour)
+			if _, ok, err := s.Get(b0); err != nil || ok {
+				t.Errorf("Got a missing secret: ok = %v, err = %v", ok, err)
+			}
+			for _, b := range []time.Time{b1, b0} {
+				if err := s.Put(b, []byte(b.String())); err != nil {
+					t.Fatalf("Failed to put secret: %+v", err)
+				}
+			}
+			if err := other.Put(b0, []byte("other")); err != nil {
+				t.Fatalf("Failed to put secret: %+v", err)
+			}
+			if err := s.Put(b0, []byte("overwrite")); !errors.Is(err, keys.ErrSecretExists) {
+				t.Errorf("Got wrong error for overwriting a secret: got %v, want %v", err, keys.ErrSecretExists)
+			}
+
+			secret, ok, err := s.Get(b0)
+			if err != nil || !ok {
+				t.Fatalf("Failed to get secret: ok = %v, err = %v", ok, err)
+			}
+			if string(secret) != b0.String() {
+				t.Errorf("Got wrong secret: got %q, want %q", secret, b0.String())
+			}
+			buckets, err := s.List()
+			if err != nil {
+				t.Fatalf("Failed to list buckets: %+v", err)
+			}
+			if want := []time.Time{b0, b1}; !slices.EqualFunc(buckets, want, time.Time.Equal) {
+				t.Errorf("Listed wrong buckets: got %v, want %v", buckets, want)
+			}
+		})
+	}
+}
+
+func TestNewerSchema(t *testing.T) {
+	db, err := sql.Open("fakesqlite", t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err := Migrate(context.Background(), db, DialectSQLite); err != nil {
+		t.Fatalf("Failed to migrate: %+v", err)
+	}
+	if _, err := db.Exec(`INSERT INTO timecapsule_schema (version) VALUES (?)`, len(migrations)+1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Migrate(context.Background(), db, DialectSQLite); err == nil {
+		t.Errorf("Migrated a database with a newer schema")
+	}
+	if _, err := New(db, DialectSQLite, "pki"); err == nil {
+		t.Errorf("Created a store on a database with a newer schema")
+	}
+}
@@ -0,0 +1,4 @@
+// Package timecapsulepb contains the protocol buffer and gRPC definitions of the timecapsule API.
+package timecapsulepb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative timecapsule.proto
@@ -0,0 +1,926 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.11
+// 	protoc        (unknown)
+// source: timecapsule.proto
+
+package timecapsulepb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+// Parameters with which a key was derived.
+type DerivationInfo struct {
+	state             protoimpl.MessageState `protogen:"open.v1"`
+	DerivationVersion int32                  `protobuf:"varint,1,opt,name=derivation_version,json=derivationVersion,proto3" json:"derivation_version,omitempty"`
+	Algorithm         string                 `protobuf:"bytes,2,opt,name=algorithm,proto3" json:"algorithm,omitempty"`
+	// Length of time covered by each root secret, in seconds. Keys are derived per
+	// second, so this is not the lifetime of a key.
+	Interval      int64 `protobuf:"varint,3,opt,name=interval,proto3" json:"interval,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *DerivationInfo) Reset() {
+	*x = DerivationInfo{}
+	mi := &file_timecapsule_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DerivationInfo) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DerivationInfo) ProtoMessage() {}
+
+func (x *DerivationInfo) ProtoReflect() protoreflect.Message {
+	mi := &file_timecapsule_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DerivationInfo.ProtoReflect.Descriptor instead.
+func (*DerivationInfo) Descriptor() ([]byte, []int) {
+	return file_timecapsule_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *DerivationInfo) GetDerivationVersion() int32 {
+	if x != nil {
+		return x.DerivationVersion
+	}
+	return 0
+}
+
+func (x *DerivationInfo) GetAlgorithm() string {
+	if x != nil {
+		return x.Algorithm
+	}
+	return ""
+}
+
+func (x *DerivationInfo) GetInterval() int64 {
+	if x != nil {
+		return x.Interval
+	}
+	return 0
+}
+
+type GetPublicKeyRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Optional. If given, must be the server's PKI ID.
+	PkiId string `protobuf:"bytes,1,opt,name=pki_id,json=pkiId,proto3" json:"pki_id,omitempty"`
+	// Types that are valid to be assigned to Target:
+	//
+	//	*GetPublicKeyRequest_Time
+	//	*GetPublicKeyRequest_Event
+	Target isGetPublicKeyRequest_Target `protobuf_oneof:"target"`
+	// Whether to also return the ML-KEM-768 encapsulation key for the same time.
+	Hybrid        bool `protobuf:"varint,4,opt,name=hybrid,proto3" json:"hybrid,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetPublicKeyRequest) Reset() {
+	*x = GetPublicKeyRequest{}
+	mi := &file_timecapsule_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetPublicKeyRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetPublicKeyRequest) ProtoMessage() {}
+
+func (x *GetPublicKeyRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_timecapsule_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetPublicKeyRequest.ProtoReflect.Descriptor instead.
+func (*GetPublicKeyRequest) Descriptor() ([]byte, []int) {
+	return file_timecapsule_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *GetPublicKeyRequest) GetPkiId() string {
+	if x != nil {
+		return x.PkiId
+	}
+	return ""
+}
+
+func (x *GetPublicKeyRequest) GetTarget() isGetPublicKeyRequest_Target {
+	if x != nil {
+		return x.Target
+	}
+	return nil
+}
+
+func (x *GetPublicKeyRequest) GetTime() *timestamppb.Timestamp {
+	if x != nil {
+		if x, ok := x.Target.(*GetPublicKeyRequest_Time); ok {
+			return x.Time
+		}
+	}
+	return nil
+}
+
+func (x *GetPublicKeyRequest) GetEvent() string {
+	if x != nil {
+		if x, ok := x.Target.(*GetPublicKeyRequest_Event); ok {
+			return x.Event
+		}
+	}
+	return ""
+}
+
+func (x *GetPublicKeyRequest) GetHybrid() bool {
+	if x != nil {
+		return x.Hybrid
+	}
+	return false
+}
+
+type isGetPublicKeyRequest_Target interface {
+	isGetPublicKeyRequest_Target()
+}
+
+type GetPublicKeyRequest_Time struct {
+	Time *timestamppb.Timestamp `protobuf:"bytes,2,opt,name=time,proto3,oneof"`
+}
+
+type GetPublicKeyRequest_Event struct {
+	// Name of a registered event.
+	Event string `protobuf:"bytes,3,opt,name=event,proto3,oneof"`
+}
+
+func (*GetPublicKeyRequest_Time) isGetPublicKeyRequest_Target() {}
+
+func (*GetPublicKeyRequest_Event) isGetPublicKeyRequest_Target() {}
+
+type GetPublicKeyResponse struct {
+	state   protoimpl.MessageState `protogen:"open.v1"`
+	PkiName string                 `protobuf:"bytes,1,opt,name=pki_name,json=pkiName,proto3" json:"pki_name,omitempty"`
+	PkiId   string                 `protobuf:"bytes,2,opt,name=pki_id,json=pkiId,proto3" json:"pki_id,omitempty"`
+	// DER-encoded SubjectPublicKeyInfo.
+	Spki       []byte                 `protobuf:"bytes,3,opt,name=spki,proto3" json:"spki,omitempty"`
+	Derivation *DerivationInfo        `protobuf:"bytes,4,opt,name=derivation,proto3" json:"derivation,omitempty"`
+	Time       *timestamppb.Timestamp `protobuf:"bytes,5,opt,name=time,proto3" json:"time,omitempty"`
+	// Ed25519 signature over (PKI ID, time, SPKI) by the PKI signing key identified by
+	// signing_key_id.
+	Signature    []byte `protobuf:"bytes,6,opt,name=signature,proto3" json:"signature,omitempty"`
+	SigningKeyId string `protobuf:"bytes,7,opt,name=signing_key_id,json=signingKeyId,proto3" json:"signing_key_id,omitempty"`
+	// SHA-256 fingerprint of spki, in hex.
+	Fingerprint string `protobuf:"bytes,8,opt,name=fingerprint,proto3" json:"fingerprint,omitempty"`
+	// SHA-256 fingerprint of the PKI signing key's SubjectPublicKeyInfo, in hex.
+	SigningKeyFingerprint string `protobuf:"bytes,9,opt,name=signing_key_fingerprint,json=signingKeyFingerprint,proto3" json:"signing_key_fingerprint,omitempty"`
+	// If the request asked for hybrid keys, the ML-KEM-768 encapsulation key for the same time and
+	// its signature by the PKI signing key.
+	MlkemEncapsulationKey []byte `protobuf:"bytes,10,opt,name=mlkem_encapsulation_key,json=mlkemEncapsulationKey,proto3" json:"mlkem_encapsulation_key,omitempty"`
+	MlkemSignature        []byte `protobuf:"bytes,11,opt,name=mlkem_signature,json=mlkemSignature,proto3" json:"mlkem_signature,omitempty"`
+	unknownFields         protoimpl.UnknownFields
+	sizeCache             protoimpl.SizeCache
+}
+
+func (x *GetPublicKeyResponse) Reset() {
+	*x = GetPublicKeyResponse{}
+	mi := &file_timecapsule_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetPublicKeyResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetPublicKeyResponse) ProtoMessage() {}
+
+func (x *GetPublicKeyResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_timecapsule_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetPublicKeyResponse.ProtoReflect.Descriptor instead.
+func (*GetPublicKeyResponse) Descriptor() ([]byte, []int) {
+	return file_timecapsule_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *GetPublicKeyResponse) GetPkiName() string {
+	if x != nil {
+		return x.PkiName
+	}
+	return ""
+}
+
+func (x *GetPublicKeyResponse) GetPkiId() string {
+	if x != nil {
+		return x.PkiId
+	}
+	return ""
+}
+
+func (x *GetPublicKeyResponse) GetSpki() []byte {
+	if x != nil {
+		return x.Spki
+	}
+	return nil
+}
+
+func (x *GetPublicKeyResponse) GetDerivation() *DerivationInfo {
+	if x != nil {
+		return x.Derivation
+	}
+	return nil
+}
+
+func (x *GetPublicKeyResponse) GetTime() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Time
+	}
+	return nil
+}
+
+func (x *GetPublicKeyResponse) GetSignature() []byte {
+	if x != nil {
+		return x.Signature
+	}
+	return nil
+}
+
+func (x *GetPublicKeyResponse) GetSigningKeyId() string {
+	if x != nil {
+		return x.SigningKeyId
+	}
+	return ""
+}
+
+func (x *GetPublicKeyResponse) GetFingerprint() string {
+	if x != nil {
+		return x.Fingerprint
+	}
+	return ""
+}
+
+func (x *GetPublicKeyResponse) GetSigningKeyFingerprint() string {
+	if x != nil {
+		return x.SigningKeyFingerprint
+	}
+	return ""
+}
+
+func (x *GetPublicKeyResponse) GetMlkemEncapsulationKey() []byte {
+	if x != nil {
+		return x.MlkemEncapsulationKey
+	}
+	return nil
+}
+
+func (x *GetPublicKeyResponse) GetMlkemSignature() []byte {
+	if x != nil {
+		return x.MlkemSignature
+	}
+	return nil
+}
+
+type GetPrivateKeyRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Optional. If given, must be the server's PKI ID.
+	PkiId string `protobuf:"bytes,1,opt,name=pki_id,json=pkiId,proto3" json:"pki_id,omitempty"`
+	// Types that are valid to be assigned to Target:
+	//
+	//	*GetPrivateKeyRequest_Time
+	//	*GetPrivateKeyRequest_Event
+	Target isGetPrivateKeyRequest_Target `protobuf_oneof:"target"`
+	// Optional DER-encoded SubjectPublicKeyInfo. If given, the private key is HPKE-sealed to this
+	// key.
+	WrapTo []byte `protobuf:"bytes,4,opt,name=wrap_to,json=wrapTo,proto3" json:"wrap_to,omitempty"`
+	// Whether to also return the seed of the ML-KEM-768 decapsulation key for the same time.
+	Hybrid        bool `protobuf:"varint,5,opt,name=hybrid,proto3" json:"hybrid,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetPrivateKeyRequest) Reset() {
+	*x = GetPrivateKeyRequest{}
+	mi := &file_timecapsule_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetPrivateKeyRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetPrivateKeyRequest) ProtoMessage() {}
+
+func (x *GetPrivateKeyRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_timecapsule_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetPrivateKeyRequest.ProtoReflect.Descriptor instead.
+func (*GetPrivateKeyRequest) Descriptor() ([]byte, []int) {
+	return file_timecapsule_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *GetPrivateKeyRequest) GetPkiId() string {
+	if x != nil {
+		return x.PkiId
+	}
+	return ""
+}
+
+func (x *GetPrivateKeyRequest) GetTarget() isGetPrivateKeyRequest_Target {
+	if x != nil {
+		return x.Target
+	}
+	return nil
+}
+
+func (x *GetPrivateKeyRequest) GetTime() *timestamppb.Timestamp {
+	if x != nil {
+		if x, ok := x.Target.(*GetPrivateKeyRequest_Time); ok {
+			return x.Time
+		}
+	}
+	return nil
+}
+
+func (x *GetPrivateKeyRequest) GetEvent() string {
+	if x != nil {
+		if x, ok := x.Target.(*GetPrivateKeyRequest_Event); ok {
+			return x.Event
+		}
+	}
+	return ""
+}
+
+func (x *GetPrivateKeyRequest) GetWrapTo() []byte {
+	if x != nil {
+		return x.WrapTo
+	}
+	return nil
+}
+
+func (x *GetPrivateKeyRequest) GetHybrid() bool {
+	if x != nil {
+		return x.Hybrid
+	}
+	return false
+}
+
+type isGetPrivateKeyRequest_Target interface {
+	isGetPrivateKeyRequest_Target()
+}
+
+type GetPrivateKeyRequest_Time struct {
+	Time *timestamppb.Timestamp `protobuf:"bytes,2,opt,name=time,proto3,oneof"`
+}
+
+type GetPrivateKeyRequest_Event struct {
+	// Name of a registered event.
+	Event string `protobuf:"bytes,3,opt,name=event,proto3,oneof"`
+}
+
+func (*GetPrivateKeyRequest_Time) isGetPrivateKeyRequest_Target() {}
+
+func (*GetPrivateKeyRequest_Event) isGetPrivateKeyRequest_Target() {}
+
+// Signed statement that the private key for target_time was released at release_time according to
+// the secure clock.
+type ReleaseAttestation struct {
+	state       protoimpl.MessageState `protogen:"open.v1"`
+	TargetTime  *timestamppb.Timestamp `protobuf:"bytes,1,opt,name=target_time,json=targetTime,proto3" json:"target_time,omitempty"`
+	ReleaseTime *timestamppb.Timestamp `protobuf:"bytes,2,opt,name=release_time,json=releaseTime,proto3" json:"release_time,omitempty"`
+	// Ed25519 signature by the PKI signing key identified by signing_key_id.
+	Signature     []byte `protobuf:"bytes,3,opt,name=signature,proto3" json:"signature,omitempty"`
+	SigningKeyId  string `protobuf:"bytes,4,opt,name=signing_key_id,json=signingKeyId,proto3" json:"signing_key_id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ReleaseAttestation) Reset() {
+	*x = ReleaseAttestation{}
+	mi := &file_timecapsule_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ReleaseAttestation) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ReleaseAttestation) ProtoMessage() {}
+
+func (x *ReleaseAttestation) ProtoReflect() protoreflect.Message {
+	mi := &file_timecapsule_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ReleaseAttestation.ProtoReflect.Descriptor instead.
+func (*ReleaseAttestation) Descriptor() ([]byte, []int) {
+	return file_timecapsule_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *ReleaseAttestation) GetTargetTime() *timestamppb.Timestamp {
+	if x != nil {
+		return x.TargetTime
+	}
+	return nil
+}
+
+func (x *ReleaseAttestation) GetReleaseTime() *timestamppb.Timestamp {
+	if x != nil {
+		return x.ReleaseTime
+	}
+	return nil
+}
+
+func (x *ReleaseAttestation) GetSignature() []byte {
+	if x != nil {
+		return x.Signature
+	}
+	return nil
+}
+
+func (x *ReleaseAttestation) GetSigningKeyId() string {
+	if x != nil {
+		return x.SigningKeyId
+	}
+	return ""
+}
+
+type GetPrivateKeyResponse struct {
+	state   protoimpl.MessageState `protogen:"open.v1"`
+	PkiName string                 `protobuf:"bytes,1,opt,name=pki_name,json=pkiName,proto3" json:"pki_name,omitempty"`
+	PkiId   string                 `protobuf:"bytes,2,opt,name=pki_id,json=pkiId,proto3" json:"pki_id,omitempty"`
+	// DER-encoded PKCS #8. Empty if the request specified a key to wrap to.
+	Pkcs8 []byte `protobuf:"bytes,3,opt,name=pkcs8,proto3" json:"pkcs8,omitempty"`
+	// HPKE encapsulated key and ciphertext, if the request specified a key to wrap to.
+	Enc        []byte          `protobuf:"bytes,4,opt,name=enc,proto3" json:"enc,omitempty"`
+	Sealed     []byte          `protobuf:"bytes,5,opt,name=sealed,proto3" json:"sealed,omitempty"`
+	Derivation *DerivationInfo `protobuf:"bytes,6,opt,name=derivation,proto3" json:"derivation,omitempty"`
+	// SHA-256 fingerprint of the SubjectPublicKeyInfo of the corresponding public key, in hex.
+	Fingerprint string `protobuf:"bytes,7,opt,name=fingerprint,proto3" json:"fingerprint,omitempty"`
+	// SHA-256 fingerprint of the PKI signing key's SubjectPublicKeyInfo, in hex.
+	SigningKeyFingerprint string `protobuf:"bytes,8,opt,name=signing_key_fingerprint,json=signingKeyFingerprint,proto3" json:"signing_key_fingerprint,omitempty"`
+	// If the request asked for hybrid keys, the seed of the ML-KEM-768 decapsulation key for the
+	// same time. If the request specified a key to wrap to, the seed is instead sealed separately to
+	// that key, with the encapsulated key in mlkem_enc and the ciphertext in mlkem_sealed.
+	MlkemSeed     []byte              `protobuf:"bytes,9,opt,name=mlkem_seed,json=mlkemSeed,proto3" json:"mlkem_seed,omitempty"`
+	MlkemEnc      []byte              `protobuf:"bytes,10,opt,name=mlkem_enc,json=mlkemEnc,proto3" json:"mlkem_enc,omitempty"`
+	MlkemSealed   []byte              `protobuf:"bytes,11,opt,name=mlkem_sealed,json=mlkemSealed,proto3" json:"mlkem_sealed,omitempty"`
+	Attestation   *ReleaseAttestation `protobuf:"bytes,12,opt,name=attestation,proto3" json:"attestation,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetPrivateKeyResponse) Reset() {
+	*x = GetPrivateKeyResponse{}
+	mi := &file_timecapsule_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetPrivateKeyResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetPrivateKeyResponse) ProtoMessage() {}
+
+func (x *GetPrivateKeyResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_timecapsule_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetPrivateKeyResponse.ProtoReflect.Descriptor instead.
+func (*GetPrivateKeyResponse) Descriptor() ([]byte, []int) {
+	return file_timecapsule_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *GetPrivateKeyResponse) GetPkiName() string {
+	if x != nil {
+		return x.PkiName
+	}
+	return ""
+}
+
+func (x *GetPrivateKeyResponse) GetPkiId() string {
+	if x != nil {
+		return x.PkiId
+	}
+	return ""
+}
+
+func (x *GetPrivateKeyResponse) GetPkcs8() []byte {
+	if x != nil {
+		return x.Pkcs8
+	}
+	return nil
+}
+
+func (x *GetPrivateKeyResponse) GetEnc() []byte {
+	if x != nil {
+		return x.Enc
+	}
+	return nil
+}
+
+func (x *GetPrivateKeyResponse) GetSealed() []byte {
+	if x != nil {
+		return x.Sealed
+	}
+	return nil
+}
+
+func (x *GetPrivateKeyResponse) GetDerivation() *DerivationInfo {
+	if x != nil {
+		return x.Derivation
+	}
+	return nil
+}
+
+func (x *GetPrivateKeyResponse) GetFingerprint() string {
+	if x != nil {
+		return x.Fingerprint
+	}
+	return ""
+}
+
+func (x *GetPrivateKeyResponse) GetSigningKeyFingerprint() string {
+	if x != nil {
+		return x.SigningKeyFingerprint
+	}
+	return ""
+}
+
+func (x *GetPrivateKeyResponse) GetMlkemSeed() []byte {
+	if x != nil {
+		return x.MlkemSeed
+	}
+	return nil
+}
+
+func (x *GetPrivateKeyResponse) GetMlkemEnc() []byte {
+	if x != nil {
+		return x.MlkemEnc
+	}
+	return nil
+}
+
+func (x *GetPrivateKeyResponse) GetMlkemSealed() []byte {
+	if x != nil {
+		return x.MlkemSealed
+	}
+	return nil
+}
+
+func (x *GetPrivateKeyResponse) GetAttestation() *ReleaseAttestation {
+	if x != nil {
+		return x.Attestation
+	}
+	return nil
+}
+
+type GetInfoRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Optional. If not given, describes the server's primary PKI.
+	PkiId         string `protobuf:"bytes,1,opt,name=pki_id,json=pkiId,proto3" json:"pki_id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetInfoRequest) Reset() {
+	*x = GetInfoRequest{}
+	mi := &file_timecapsule_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetInfoRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetInfoRequest) ProtoMessage() {}
+
+func (x *GetInfoRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_timecapsule_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetInfoRequest.ProtoReflect.Descriptor instead.
+func (*GetInfoRequest) Descriptor() ([]byte, []int) {
+	return file_timecapsule_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *GetInfoRequest) GetPkiId() string {
+	if x != nil {
+		return x.PkiId
+	}
+	return ""
+}
+
+type GetInfoResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	PkiName       string                 `protobuf:"bytes,1,opt,name=pki_name,json=pkiName,proto3" json:"pki_name,omitempty"`
+	PkiId         string                 `protobuf:"bytes,2,opt,name=pki_id,json=pkiId,proto3" json:"pki_id,omitempty"`
+	MinTime       *timestamppb.Timestamp `protobuf:"bytes,3,opt,name=min_time,json=minTime,proto3" json:"min_time,omitempty"`
+	MaxTime       *timestamppb.Timestamp `protobuf:"bytes,4,opt,name=max_time,json=maxTime,proto3" json:"max_time,omitempty"`
+	ApiVersion    string                 `protobuf:"bytes,5,opt,name=api_version,json=apiVersion,proto3" json:"api_version,omitempty"`
+	Derivation    *DerivationInfo        `protobuf:"bytes,6,opt,name=derivation,proto3" json:"derivation,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetInfoResponse) Reset() {
+	*x = GetInfoResponse{}
+	mi := &file_timecapsule_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetInfoResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetInfoResponse) ProtoMessage() {}
+
+func (x *GetInfoResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_timecapsule_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetInfoResponse.ProtoReflect.Descriptor instead.
+func (*GetInfoResponse) Descriptor() ([]byte, []int) {
+	return file_timecapsule_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *GetInfoResponse) GetPkiName() string {
+	if x != nil {
+		return x.PkiName
+	}
+	return ""
+}
+
+func (x *GetInfoResponse) GetPkiId() string {
+	if x != nil {
+		return x.PkiId
+	}
+	return ""
+}
+
+func (x *GetInfoResponse) GetMinTime() *timestamppb.Timestamp {
+	if x != nil {
+		return x.MinTime
+	}
+	return nil
+}
+
+func (x *GetInfoResponse) GetMaxTime() *timestamppb.Timestamp {
+	if x != nil {
+		return x.MaxTime
+	}
+	return nil
+}
+
+func (x *GetInfoResponse) GetApiVersion() string {
+	if x != nil {
+		return x.ApiVersion
+	}
+	return ""
+}
+
+func (x *GetInfoResponse) GetDerivation() *DerivationInfo {
+	if x != nil {
+		return x.Derivation
+	}
+	return nil
+}
+
+var File_timecapsule_proto protoreflect.FileDescriptor
+
+const file_timecapsule_proto_rawDesc = "" +
+	"\n" +
+	"\x11timecapsule.proto\x12\x0etimecapsule.v0\x1a\x1fgoogle/protobuf/timestamp.proto\"y\n" +
+	"\x0eDerivationInfo\x12-\n" +
+	"\x12derivation_version\x18\x01 \x01(\x05R\x11derivationVersion\x12\x1c\n" +
+	"\talgorithm\x18\x02 \x01(\tR\talgorithm\x12\x1a\n" +
+	"\binterval\x18\x03 \x01(\x03R\binterval\"\x98\x01\n" +
+	"\x13GetPublicKeyRequest\x12\x15\n" +
+	"\x06pki_id\x18\x01 \x01(\tR\x05pkiId\x120\n" +
+	"\x04time\x18\x02 \x01(\v2\x1a.google.protobuf.TimestampH\x00R\x04time\x12\x16\n" +
+	"\x05event\x18\x03 \x01(\tH\x00R\x05event\x12\x16\n" +
+	"\x06hybrid\x18\x04 \x01(\bR\x06hybridB\b\n" +
+	"\x06target\"\xcb\x03\n" +
+	"\x14GetPublicKeyResponse\x12\x19\n" +
+	"\bpki_name\x18\x01 \x01(\tR\apkiName\x12\x15\n" +
+	"\x06pki_id\x18\x02 \x01(\tR\x05pkiId\x12\x12\n" +
+	"\x04spki\x18\x03 \x01(\fR\x04spki\x12>\n" +
+	"\n" +
+	"derivation\x18\x04 \x01(\v2\x1e.timecapsule.v0.DerivationInfoR\n" +
+	"derivation\x12.\n" +
+	"\x04time\x18\x05 \x01(\v2\x1a.google.protobuf.TimestampR\x04time\x12\x1c\n" +
+	"\tsignature\x18\x06 \x01(\fR\tsignature\x12$\n" +
+	"\x0esigning_key_id\x18\a \x01(\tR\fsigningKeyId\x12 \n" +
+	"\vfingerprint\x18\b \x01(\tR\vfingerprint\x126\n" +
+	"\x17signing_key_fingerprint\x18\t \x01(\tR\x15signingKeyFingerprint\x126\n" +
+	"\x17mlkem_encapsulation_key\x18\n" +
+	" \x01(\fR\x15mlkemEncapsulationKey\x12'\n" +
+	"\x0fmlkem_signature\x18\v \x01(\fR\x0emlkemSignature\"\xb2\x01\n" +
+	"\x14GetPrivateKeyRequest\x12\x15\n" +
+	"\x06pki_id\x18\x01 \x01(\tR\x05pkiId\x120\n" +
+	"\x04time\x18\x02 \x01(\v2\x1a.google.protobuf.TimestampH\x00R\x04time\x12\x16\n" +
+	"\x05event\x18\x03 \x01(\tH\x00R\x05event\x12\x17\n" +
+	"\awrap_to\x18\x04 \x01(\fR\x06wrapTo\x12\x16\n" +
+	"\x06hybrid\x18\x05 \x01(\bR\x06hybridB\b\n" +
+	"\x06target\"\xd4\x01\n" +
+	"\x12ReleaseAttestation\x12;\n" +
+	"\vtarget_time\x18\x01 \x01(\v2\x1a.google.protobuf.TimestampR\n" +
+	"targetTime\x12=\n" +
+	"\frelease_time\x18\x02 \x01(\v2\x1a.google.protobuf.TimestampR\vreleaseTime\x12\x1c\n" +
+	"\tsignature\x18\x03 \x01(\fR\tsignature\x12$\n" +
+	"\x0esigning_key_id\x18\x04 \x01(\tR\fsigningKeyId\"\xc8\x03\n" +
+	"\x15GetPrivateKeyResponse\x12\x19\n" +
+	"\bpki_name\x18\x01 \x01(\tR\apkiName\x12\x15\n" +
+	"\x06pki_id\x18\x02 \x01(\tR\x05pkiId\x12\x14\n" +
+	"\x05pkcs8\x18\x03 \x01(\fR\x05pkcs8\x12\x10\n" +
+	"\x03enc\x18\x04 \x01(\fR\x03enc\x12\x16\n" +
+	"\x06sealed\x18\x05 \x01(\fR\x06sealed\x12>\n" +
+	"\n" +
+	"derivation\x18\x06 \x01(\v2\x1e.timecapsule.v0.DerivationInfoR\n" +
+	"derivation\x12 \n" +
+	"\vfingerprint\x18\a \x01(\tR\vfingerprint\x126\n" +
+	"\x17signing_key_fingerprint\x18\b \x01(\tR\x15signingKeyFingerprint\x12\x1d\n" +
+	"\n" +
+	"mlkem_seed\x18\t \x01(\fR\tmlkemSeed\x12\x1b\n" +
+	"\tmlkem_enc\x18\n" +
+	" \x01(\fR\bmlkemEnc\x12!\n" +
+	"\fmlkem_sealed\x18\v \x01(\fR\vmlkemSealed\x12D\n" +
+	"\vattestation\x18\f \x01(\v2\".timecapsule.v0.ReleaseAttestationR\vattestation\"'\n" +
+	"\x0eGetInfoRequest\x12\x15\n" +
+	"\x06pki_id\x18\x01 \x01(\tR\x05pkiId\"\x92\x02\n" +
+	"\x0fGetInfoResponse\x12\x19\n" +
+	"\bpki_name\x18\x01 \x01(\tR\apkiName\x12\x15\n" +
+	"\x06pki_id\x18\x02 \x01(\tR\x05pkiId\x125\n" +
+	"\bmin_time\x18\x03 \x01(\v2\x1a.google.protobuf.TimestampR\aminTime\x125\n" +
+	"\bmax_time\x18\x04 \x01(\v2\x1a.google.protobuf.TimestampR\amaxTime\x12\x1f\n" +
+	"\vapi_version\x18\x05 \x01(\tR\n" +
+	"apiVersion\x12>\n" +
+	"\n" +
+	"derivation\x18\x06 \x01(\v2\x1e.timecapsule.v0.DerivationInfoR\n" +
+	"derivation2\x92\x02\n" +
+	"\vTimecapsule\x12Y\n" +
+	"\fGetPublicKey\x12#.timecapsule.v0.GetPublicKeyRequest\x1a$.timecapsule.v0.GetPublicKeyResponse\x12\\\n" +
+	"\rGetPrivateKey\x12$.timecapsule.v0.GetPrivateKeyRequest\x1a%.timecapsule.v0.GetPrivateKeyResponse\x12J\n" +
+	"\aGetInfo\x12\x1e.timecapsule.v0.GetInfoRequest\x1a\x1f.timecapsule.v0.GetInfoResponseB-Z+github.com/newgrp/timecapsule/timecapsulepbb\x06proto3"
+
+var (
+	file_timecapsule_proto_rawDescOnce sync.Once
+	file_timecapsule_proto_rawDescData []byte
+)
+
+func file_timecapsule_proto_rawDescGZIP() []byte {
+	file_timecapsule_proto_rawDescOnce.Do(func() {
+		file_timecapsule_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_timecapsule_proto_rawDesc), len(file_timecapsule_proto_rawDesc)))
+	})
+	return file_timecapsule_proto_rawDescData
+}
+
+var file_timecapsule_proto_msgTypes = make([]protoimpl.MessageInfo, 8)
+var file_timecapsule_proto_goTypes = []any{
+	(*DerivationInfo)(nil),        // 0: timecapsule.v0.DerivationInfo
+	(*GetPublicKeyRequest)(nil),   // 1: timecapsule.v0.GetPublicKeyRequest
+	(*GetPublicKeyResponse)(nil),  // 2: timecapsule.v0.GetPublicKeyResponse
+	(*GetPrivateKeyRequest)(nil),  // 3: timecapsule.v0.GetPrivateKeyRequest
+	(*ReleaseAttestation)(nil),    // 4: timecapsule.v0.ReleaseAttestation
+	(*GetPrivateKeyResponse)(nil), // 5: timecapsule.v0.GetPrivateKeyResponse
+	(*GetInfoRequest)(nil),        // 6: timecapsule.v0.GetInfoRequest
+	(*GetInfoResponse)(nil),       // 7: timecapsule.v0.GetInfoResponse
+	(*timestamppb.Timestamp)(nil), // 8: google.protobuf.Timestamp
+}
+var file_timecapsule_proto_depIdxs = []int32{
+	8,  // 0: timecapsule.v0.GetPublicKeyRequest.time:type_name -> google.protobuf.Timestamp
+	0,  // 1: timecapsule.v0.GetPublicKeyResponse.derivation:type_name -> timecapsule.v0.DerivationInfo
+	8,  // 2: timecapsule.v0.GetPublicKeyResponse.time:type_name -> google.protobuf.Timestamp
+	8,  // 3: timecapsule.v0.GetPrivateKeyRequest.time:type_name -> google.protobuf.Timestamp
+	8,  // 4: timecapsule.v0.ReleaseAttestation.target_time:type_name -> google.protobuf.Timestamp
+	8,  // 5: timecapsule.v0.ReleaseAttestation.release_time:type_name -> google.protobuf.Timestamp
+	0,  // 6: timecapsule.v0.GetPrivateKeyResponse.derivation:type_name -> timecapsule.v0.DerivationInfo
+	4,  // 7: timecapsule.v0.GetPrivateKeyResponse.attestation:type_name -> timecapsule.v0.ReleaseAttestation
+	8,  // 8: timecapsule.v0.GetInfoResponse.min_time:type_name -> google.protobuf.Timestamp
+	8,  // 9: timecapsule.v0.GetInfoResponse.max_time:type_name -> google.protobuf.Timestamp
+	0,  // 10: timecapsule.v0.GetInfoResponse.derivation:type_name -> timecapsule.v0.DerivationInfo
+	1,  // 11: timecapsule.v0.Timecapsule.GetPublicKey:input_type -> timecapsule.v0.GetPublicKeyRequest
+	3,  // 12: timecapsule.v0.Timecapsule.GetPrivateKey:input_type -> timecapsule.v0.GetPrivateKeyRequest
+	6,  // 13: timecapsule.v0.Timecapsule.GetInfo:input_type -> timecapsule.v0.GetInfoRequest
+	2,  // 14: timecapsule.v0.Timecapsule.GetPublicKey:output_type -> timecapsule.v0.GetPublicKeyResponse
+	5,  // 15: timecapsule.v0.Timecapsule.GetPrivateKey:output_type -> timecapsule.v0.GetPrivateKeyResponse
+	7,  // 16: timecapsule.v0.Timecapsule.GetInfo:output_type -> timecapsule.v0.GetInfoResponse
+	14, // [14:17] is the sub-list for method output_type
+	11, // [11:14] is the sub-list for method input_type
+	11, // [11:11] is the sub-list for extension type_name
+	11, // [11:11] is the sub-list for extension extendee
+	0,  // [0:11] is the sub-list for field type_name
+}
+
+func init() { file_timecapsule_proto_init() }
+func file_timecapsule_proto_init() {
+	if File_timecapsule_proto != nil {
+		return
+	}
+	file_timecapsule_proto_msgTypes[1].OneofWrappers = []any{
+		(*GetPublicKeyRequest_Time)(nil),
+		(*GetPublicKeyRequest_Event)(nil),
+	}
+	file_timecapsule_proto_msgTypes[3].OneofWrappers = []any{
+		(*GetPrivateKeyRequest_Time)(nil),
+		(*GetPrivateKeyRequest_Event)(nil),
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_timecapsule_proto_rawDesc), len(file_timecapsule_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   8,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_timecapsule_proto_goTypes,
+		DependencyIndexes: file_timecapsule_proto_depIdxs,
+		MessageInfos:      file_timecapsule_proto_msgTypes,
+	}.Build()
+	File_timecapsule_proto = out.File
+	file_timecapsule_proto_goTypes = nil
+	file_timecapsule_proto_depIdxs = nil
+}
@@ -0,0 +1,207 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.6.2
+// - protoc             (unknown)
+// source: timecapsule.proto
+
+package timecapsulepb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	Timecapsule_GetPublicKey_FullMethodName  = "/timecapsule.v0.Timecapsule/GetPublicKey"
+	Timecapsule_GetPrivateKey_FullMethodName = "/timecapsule.v0.Timecapsule/GetPrivateKey"
+	Timecapsule_GetInfo_FullMethodName       = "/timecapsule.v0.Timecapsule/GetInfo"
+)
+
+// TimecapsuleClient is the client API for Timecapsule service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// Time-based public key infrastructure. Mirrors the /v0/ HTTP API.
+type TimecapsuleClient interface {
+	// Returns the public key for a time.
+	GetPublicKey(ctx context.Context, in *GetPublicKeyRequest, opts ...grpc.CallOption) (*GetPublicKeyResponse, error)
+	// Returns the private key for a time, provided that time is not in the future.
+	GetPrivateKey(ctx context.Context, in *GetPrivateKeyRequest, opts ...grpc.CallOption) (*GetPrivateKeyResponse, error)
+	// Returns information about the server's PKI.
+	GetInfo(ctx context.Context, in *GetInfoRequest, opts ...grpc.CallOption) (*GetInfoResponse, error)
+}
+
+type timecapsuleClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewTimecapsuleClient(cc grpc.ClientConnInterface) TimecapsuleClient {
+	return &timecapsuleClient{cc}
+}
+
+func (c *timecapsuleClient) GetPublicKey(ctx context.Context, in *GetPublicKeyRequest, opts ...grpc.CallOption) (*GetPublicKeyResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(GetPublicKeyResponse)
+	err := c.cc.Invoke(ctx, Timecapsule_GetPublicKey_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *timecapsuleClient) GetPrivateKey(ctx context.Context, in *GetPrivateKeyRequest, opts ...grpc.CallOption) (*GetPrivateKeyResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(GetPrivateKeyResponse)
+	err := c.cc.Invoke(ctx, Timecapsule_GetPrivateKey_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *timecapsuleClient) GetInfo(ctx context.Context, in *GetInfoRequest, opts ...grpc.CallOption) (*GetInfoResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(GetInfoResponse)
+	err := c.cc.Invoke(ctx, Timecapsule_GetInfo_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// TimecapsuleServer is the server API for Timecapsule service.
+// All implementations must embed UnimplementedTimecapsuleServer
+// for forward compatibility.
+//
+// Time-based public key infrastructure. Mirrors the /v0/ HTTP API.
+type TimecapsuleServer interface {
+	// Returns the public key for a time.
+	GetPublicKey(context.Context, *GetPublicKeyRequest) (*GetPublicKeyResponse, error)
+	// Returns the private key for a time, provided that time is not in the future.
+	GetPrivateKey(context.Context, *GetPrivateKeyRequest) (*GetPrivateKeyResponse, error)
+	// Returns information about the server's PKI.
+	GetInfo(context.Context, *GetInfoRequest) (*GetInfoResponse, error)
+	mustEmbedUnimplementedTimecapsuleServer()
+}
+
+// UnimplementedTimecapsuleServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedTimecapsuleServer struct{}
+
+func (UnimplementedTimecapsuleServer) GetPublicKey(context.Context, *GetPublicKeyRequest) (*GetPublicKeyResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method GetPublicKey not implemented")
+}
+func (UnimplementedTimecapsuleServer) GetPrivateKey(context.Context, *GetPrivateKeyRequest) (*GetPrivateKeyResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method GetPrivateKey not implemented")
+}
+func (UnimplementedTimecapsuleServer) GetInfo(context.Context, *GetInfoRequest) (*GetInfoResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method GetInfo not implemented")
+}
+func (UnimplementedTimecapsuleServer) mustEmbedUnimplementedTimecapsuleServer() {}
+func (UnimplementedTimecapsuleServer) testEmbeddedByValue()                     {}
+
+// UnsafeTimecapsuleServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to TimecapsuleServer will
+// result in compilation errors.
+type UnsafeTimecapsuleServer interface {
+	mustEmbedUnimplementedTimecapsuleServer()
+}
+
+func RegisterTimecapsuleServer(s grpc.ServiceRegistrar, srv TimecapsuleServer) {
+	// If the following call panics, it indicates UnimplementedTimecapsuleServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&Timecapsule_ServiceDesc, srv)
+}
+
+func _Timecapsule_GetPublicKey_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetPublicKeyRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TimecapsuleServer).GetPublicKey(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Timecapsule_GetPublicKey_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TimecapsuleServer).GetPublicKey(ctx, req.(*GetPublicKeyRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Timecapsule_GetPrivateKey_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetPrivateKeyRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TimecapsuleServer).GetPrivateKey(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Timecapsule_GetPrivateKey_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TimecapsuleServer).GetPrivateKey(ctx, req.(*GetPrivateKeyRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Timecapsule_GetInfo_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetInfoRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TimecapsuleServer).GetInfo(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Timecapsule_GetInfo_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TimecapsuleServer).GetInfo(ctx, req.(*GetInfoRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// Timecapsule_ServiceDesc is the grpc.ServiceDesc for Timecapsule service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Timecapsule_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "timecapsule.v0.Timecapsule",
+	HandlerType: (*TimecapsuleServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "GetPublicKey",
+			Handler:    _Timecapsule_GetPublicKey_Handler,
+		},
+		{
+			MethodName: "GetPrivateKey",
+			Handler:    _Timecapsule_GetPrivateKey_Handler,
+		},
+		{
+			MethodName: "GetInfo",
+			Handler:    _Timecapsule_GetInfo_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "timecapsule.proto",
+}
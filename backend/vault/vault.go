@@ -0,0 +1,311 @@
+// Package vault implements a secret store backed by the HashiCorp Vault KV secrets engine.
+package vault
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Timeout of each request to Vault.
+const requestTimeout = 30 * time.Second
+
+// Largest error response body from Vault that is included in errors.
+const maxErrorBody = 1024
+
+// Default mount path of the KV secrets engine.
+const defaultMount = "secret"
+
+// Names of secrets in Vault, which match the names of secret files.
+const keyLayout = "2006-01-02@15.04.05"
+
+// Configuration of a Vault secret store.
+type Config struct {
+	// Address of the Vault server, such as https://vault.example.com:8200.
+	Address string
+	// Vault Enterprise namespace, if any.
+	Namespace string
+	// Mount path of a version 2 KV secrets engine. If empty, "secret" is used.
+	Mount string
+	// Path under the mount at which the PKI's secrets are kept. Each PKI needs its own path.
+	Path string
+
+	// Vault token. If empty, the store logs in with AppRole instead.
+	Token string
+	// AppRole credentials.
+	RoleID   string
+	SecretID string
+
+	// If nil, a client with a default timeout is used.
+	Client *http.Client
+}
+
+// Secret store that keeps each secret as a version of a KV secret in Vault, so that secrets never
+// touch the server's local disk and access to them is governed and audited by Vault.
+//
+// Secrets are written with check-and-set so that existing secrets are never overwritten. Tokens are
+// renewed once half of their lease has passed, and AppRole tokens that can't be renewed are replaced
+// by logging in again.
+type Store struct {
+	cfg    Config
+	client *http.Client
+
+	mu sync.Mutex
+	// Current token, or empty before logging in.
+	token string
+	// When the current token was obtained or last renewed, and its lease at that time. Tokens
+	// without a lease never expire.
+	renewed   time.Time
+	lease     time.Duration
+	renewable bool
+}
+
+// Returns a Vault secret store. No requests are made until the store is used.
+func New(cfg Config) (*Store, error) {
+	if cfg.Address == "" {
+		return nil, errors.New("no Vault address provided")
+	}
+	if cfg.Path == "" {
+		return nil, errors.New("no Vault secrets path provided")
+	}
+	if cfg.Token == "" && (cfg.RoleID == "" || cfg.SecretID == "") {
+		return nil, errors.New("no Vault credentials provided: need a token or an AppRole role ID and secret ID")
+	}
+	if cfg.Mount == "" {
+		cfg.Mount = defaultMount
+	}
+	cfg.Address = strings.TrimSuffix(cfg.Address, "/")
+	cfg.Mount = strings.Trim(cfg.Mount, "/")
+	cfg.Path = strings.Trim(cfg.Path, "/")
+	client := cfg.Client
+	if client == nil {
+		client = &http.Client{Timeout: requestTimeout}
+	}
+	return &Store{cfg: cfg, client: client}, nil
+}
+
+// Authentication data in Vault responses.
+type authResp struct {
+	Auth struct {
+		ClientToken   string `json:"client_token"`
+		LeaseDuration int    `json:"lease_duration"`
+		Renewable     bool   `json:"renewable"`
+	} `json:"auth"`
+}
+
+// Sends a request to Vault with the given token and decodes its JSON response into resp, if resp is
+// not nil. Returns ok = false if Vault responds with 404 Not Found.
+func (s *Store) do(token, method, path string, body any, resp any) (bool, error) {
+	var r io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return false, err
+		}
+		r = bytes.NewReader(b)
+	}
+	req, err := http.NewRequest(method, s.cfg.Address+"/v1/"+path, r)
+	if err != nil {
+		return false, err
+	}
+	if token != "" {
+		req.Header.Set("X-Vault-Token", token)
+	}
+	if s.cfg.Namespace != "" {
+		req.Header.Set("X-Vault-Namespace", s.cfg.Namespace)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusNotFound {
+		return false, nil
+	}
+	if res.StatusCode/100 != 2 {
+		b, _ := io.ReadAll(io.LimitReader(res.Body, maxErrorBody))
+		return false, fmt.Errorf("Vault %s %s returned %s: %s", method, path, res.Status, strings.TrimSpace(string(b)))
+	}
+	if resp != nil && res.StatusCode != http.StatusNoContent {
+		if err := json.NewDecoder(res.Body).Decode(resp); err != nil {
+			return false, fmt.Errorf("invalid response from Vault %s %s: %w", method, path, err)
+		}
+	}
+	return true, nil
+}
+
+// Sets the current token from an authentication response.
+func (s *Store) setAuth(auth authResp) {
+	s.token = auth.Auth.ClientToken
+	s.renewed = time.Now()
+	s.lease = time.Duration(auth.Auth.LeaseDuration) * time.Second
+	s.renewable = auth.Auth.Renewable
+}
+
+// Logs in with AppRole.
+func (s *Store) login() error {
+	var auth authResp
+	if _, err := s.do("", http.MethodPost, "auth/approle/login", map[string]string{
+		"role_id":   s.cfg.RoleID,
+		"secret_id": s.cfg.SecretID,
+	}, &auth); err != nil {
+		return fmt.Errorf("failed to log in to Vault: %w", err)
+	}
+	if auth.Auth.ClientToken == "" {
+		return errors.New("failed to log in to Vault: no token returned")
+	}
+	s.setAuth(auth)
+	return nil
+}
+
+// Looks up the lease of a token given by the configuration.
+func (s *Store) lookupToken() error {
+	var lookup struct {
+		Data struct {
+			TTL       int  `json:"ttl"`
+			Renewable bool `json:"renewable"`
+		} `json:"data"`
+	}
+	if _, err := s.do(s.cfg.Token, http.MethodGet, "auth/token/lookup-self", nil, &lookup); err != nil {
+		return fmt.Errorf("failed to look up Vault token: %w", err)
+	}
+	s.token = s.cfg.Token
+	s.renewed = time.Now()
+	s.lease = time.Duration(lookup.Data.TTL) * time.Second
+	s.renewable = lookup.Data.Renewable
+	return nil
+}
+
+// Returns a token with which to make requests, logging in or renewing the current token if needed.
+func (s *Store) authToken() (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.token == "" {
+		var err error
+		if s.cfg.Token != "" {
+			err = s.lookupToken()
+		} else {
+			err = s.login()
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+	if s.lease == 0 || time.Since(s.renewed) < s.lease/2 {
+		return s.token, nil
+	}
+
+	if s.renewable {
+		var auth authResp
+		_, err := s.do(s.token, http.MethodPost, "auth/token/renew-self", struct{}{}, &auth)
+		if err == nil && auth.Auth.ClientToken != "" {
+			s.setAuth(auth)
+			return s.token, nil
+		}
+		if s.cfg.Token != "" {
+			return "", fmt.Errorf("failed to renew Vault token: %w", err)
+		}
+	}
+	if s.cfg.Token != "" {
+		// The token can't be renewed, but remains usable until it expires.
+		return s.token, nil
+	}
+	if err := s.login(); err != nil {
+		return "", err
+	}
+	return s.token, nil
+}
+
+// Returns the path, relative to the API root, of a secret's data.
+func (s *Store) dataPath(bucket time.Time) string {
+	return fmt.Sprintf("%s/data/%s/%s", s.cfg.Mount, s.cfg.Path, bucket.UTC().Format(keyLayout))
+}
+
+func (s *Store) Get(bucket time.Time) ([]byte, bool, error) {
+	token, err := s.authToken()
+	if err != nil {
+		return nil, false, err
+	}
+	var resp struct {
+		Data struct {
+			Data struct {
+				Secret []byte `json:"secret"`
+			} `json:"data"`
+		} `json:"data"`
+	}
+	ok, err := s.do(token, http.MethodGet, s.dataPath(bucket), nil, &resp)
+	if err != nil || !ok {
+		return nil, false, err
+	}
+	if len(resp.Data.Data.Secret) == 0 {
+		return nil, false, fmt.Errorf("Vault secret for %s has no secret data", bucket.Format(time.RFC3339))
+	}
+	return resp.Data.Data.Secret, true, nil
+}
+
+func (s *Store) Put(bucket time.Time, secret []byte) error {
+	token, err := s.authToken()
+	if err != nil {
+		return err
+	}
+	// A check-and-set version of 0 only allows writing a secret that doesn't exist yet.
+	body := map[string]any{
+		"options": map[string]int{"cas": 0},
+		"data":    map[string][]byte{"secret": secret},
+	}
+	if _, err := s.do(token, http.MethodPost, s.dataPath(bucket), body, nil); err != nil {
+		return fmt.Errorf("failed to write secret for %s: %w", bucket.Format(time.RFC3339), err)
+	}
+	return nil
+}
+
+// Permanently deletes every version of a secret, along with its metadata.
+func (s *Store) Delete(bucket time.Time) error {
+	token, err := s.authToken()
+	if err != nil {
+		return err
+	}
+	path := fmt.Sprintf("%s/metadata/%s/%s", s.cfg.Mount, s.cfg.Path, bucket.UTC().Format(keyLayout))
+	if _, err := s.do(token, http.MethodDelete, path, nil, nil); err != nil {
+		return fmt.Errorf("failed to delete secret for %s: %w", bucket.Format(time.RFC3339), err)
+	}
+	return nil
+}
+
+func (s *Store) List() ([]time.Time, error) {
+	token, err := s.authToken()
+	if err != nil {
+		return nil, err
+	}
+	var resp struct {
+		Data struct {
+			Keys []string `json:"keys"`
+		} `json:"data"`
+	}
+	// Vault responds with 404 Not Found when no secrets exist under the path.
+	if _, err := s.do(token, "LIST", fmt.Sprintf("%s/metadata/%s", s.cfg.Mount, s.cfg.Path), nil, &resp); err != nil {
+		return nil, fmt.Errorf("failed to list secrets: %w", err)
+	}
+	var buckets []time.Time
+	for _, key := range resp.Data.Keys {
+		t, err := time.Parse(keyLayout, key)
+		if err != nil {
+			// Subpaths and unrelated secrets.
+			continue
+		}
+		buckets = append(buckets, t)
+	}
+	slices.SortFunc(buckets, time.Time.Compare)
+	return buckets, nil
+}
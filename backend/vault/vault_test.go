@@ -0,0 +1,185 @@
+package vault
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// In-memory fake of the Vault endpoints used by the store.
+type fakeVault struct {
+	mu      sync.Mutex
+	secrets map[string][]byte
+	// Valid tokens.
+	tokens  map[string]bool
+	logins  int
+	renewal int
+}
+
+func (f *fakeVault) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	path := strings.TrimPrefix(r.URL.Path, "/v1/")
+
+	if path == "auth/approle/login" {
+		var req map[string]string
+		json.NewDecoder(r.Body).Decode(&req)
+		if req["role_id"] != "role" || req["secret_id"] != "secret" {
+			http.Error(w, `{"errors":["invalid credentials"]}`, http.StatusBadRequest)
+			return
+		}
+		f.logins++
+		token := "approle-token"
+		f.tokens[token] = true
+		json.NewEncoder(w).Encode(map[string]any{"auth": map[string]any{"client_token": token, "lease_duration": 60, "renewable": true}})
+		return
+	}
+	token := r.Header.Get("X-Vault-Token")
+	if !f.tokens[token] {
+		http.Error(w, `{"errors":["permission denied"]}`, http.StatusForbidden)
+		return
+	}
+
+	switch {
+	case path == "auth/token/lookup-self":
+		json.NewEncoder(w).Encode(map[string]any{"data": map[string]any{"ttl": 0, "renewable": false}})
+	case path == "auth/token/renew-self":
+		f.renewal++
+		json.NewEncoder(w).Encode(map[string]any{"auth": map[string]any{"client_token": token, "lease_duration": 60, "renewable": true}})
+	case r.Method == "LIST" && path == "kv/metadata/pki":
+		var keys []string
+		for k := range f.secrets {
+			keys = append(keys, k)
+		}
+		if len(keys) == 0 {
+			http.Error(w, `{"errors":[]}`, http.StatusNotFound)
+			return
+		}
+		keys = append(keys, "subpath/")
+		json.NewEncoder(w).Encode(map[string]any{"data": map[string]any{"keys": keys}})
+	case strings.HasPrefix(path, "kv/data/pki/"):
+		name := strings.TrimPrefix(path, "kv/data/pki/")
+		switch r.Method {
+		case http.MethodGet:
+			secret, ok := f.secrets[name]
+			if !ok {
+				http.Error(w, `{"errors":[]}`, http.StatusNotFound)
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]any{"data": map[string]any{"data": map[string][]byte{"secret": secret}}})
+		case http.MethodPost:
+			var req struct {
+				Options map[string]int
+				Data    map[string][]byte
+			}
+			json.NewDecoder(r.Body).Decode(&req)
+			if cas, ok := req.Options["cas"]; ok && cas == 0 && f.secrets[name] != nil {
+				http.Error(w, `{"errors":["check-and-set parameter did not match the current version"]}`, http.StatusBadRequest)
+				return
+			}
+			f.secrets[name] = req.Data["secret"]
+			w.WriteHeader(http.StatusNoContent)
+		}
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func newFake(t *testing.T) (*fakeVault, *httptest.Server) {
+	f := &fakeVault{secrets: map[string][]byte{}, tokens: map[string]bool{"root": true}}
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+	return f, srv
+}
+
+func TestStore(t *testing.T) {
+	_, srv := newFake(t)
+	s, err := New(Config{Address: srv.URL, Mount: "kv", Path: "/pki/", Token: "root"})
+	if err != nil {
+		t.Fatalf("Failed to create store: %+v", err)
+	}
+
+	buckets, err := s.List()
+	if err != nil {
+		t.Fatalf("Failed to list empty store: %+v", err)
+	}
+	if len(buckets) != 0 {
+		t.Errorf("Listed buckets in an empty store: %v", buckets)
+	}
+	b1 := time.Date(2024, time.September, 1, 1, 0, 0, 0, time.UTC)
+	b0 := b1.Add(-time.Hour)
+	if _, ok, err := s.Get(b1); err != nil || ok {
+		t.Errorf("Got a missing secret: ok = %v, err = %v", ok, err)
+	}
+
+	for _, b := range []time.Time{b1, b0} {
+		if err := s.Put(b, []byte(b.String())); err != nil {
+			t.Fatalf("Failed to put secret: %+v", err)
+		}
+	}
+	if err := s.Put(b1, []byte("overwrite")); err == nil {
+		t.Errorf("Overwrote an existing secret")
+	}
+	secret, ok, err := s.Get(b1)
+	if err != nil || !ok {
+		t.Fatalf("Failed to get secret: ok = %v, err = %v", ok, err)
+	}
+	if string(secret) != b1.String() {
+		t.Errorf("Got wrong secret: got %q, want %q", secret, b1.String())
+	}
+	buckets, err = s.List()
+	if err != nil {
+		t.Fatalf("Failed to list buckets: %+v", err)
+	}
+	if want := []time.Time{b0, b1}; !slices.EqualFunc(buckets, want, time.Time.Equal) {
+		t.Errorf("Listed wrong buckets: got %v, want %v", buckets, want)
+	}
+
+	bad, err := New(Config{Address: srv.URL, Mount: "kv", Path: "pki", Token: "wrong"})
+	if err != nil {
+		t.Fatalf("Failed to create store: %+v", err)
+	}
+	if _, _, err := bad.Get(b1); err == nil {
+		t.Errorf("Got a secret with an invalid token")
+	}
+}
+
+func TestAppRole(t *testing.T) {
+	f, srv := newFake(t)
+	s, err := New(Config{Address: srv.URL, Mount: "kv", Path: "pki", RoleID: "role", SecretID: "secret"})
+	if err != nil {
+		t.Fatalf("Failed to create store: %+v", err)
+	}
+	bucket := time.Date(2024, time.September, 1, 0, 0, 0, 0, time.UTC)
+	if err := s.Put(bucket, []byte("secret")); err != nil {
+		t.Fatalf("Failed to put secret: %+v", err)
+	}
+
+	// Past half of the lease, the token is renewed.
+	s.renewed = time.Now().Add(-time.Minute)
+	if _, _, err := s.Get(bucket); err != nil {
+		t.Fatalf("Failed to get secret: %+v", err)
+	}
+	if f.logins != 1 || f.renewal != 1 {
+		t.Errorf("Got %d logins and %d renewals, want 1 and 1", f.logins, f.renewal)
+	}
+
+	// Tokens that can no longer be renewed are replaced by logging in again.
+	s.renewed = time.Now().Add(-time.Minute)
+	s.renewable = false
+	if _, _, err := s.Get(bucket); err != nil {
+		t.Fatalf("Failed to get secret: %+v", err)
+	}
+	if f.logins != 2 {
+		t.Errorf("Got %d logins, want 2", f.logins)
+	}
+
+	if _, err := New(Config{Address: srv.URL, Path: "pki", RoleID: "role"}); err == nil {
+		t.Errorf("Created a store without credentials")
+	}
+}